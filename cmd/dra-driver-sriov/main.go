@@ -6,25 +6,35 @@ import (
 	"fmt"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"syscall"
+	"time"
 
 	"github.com/urfave/cli/v2"
 
+	"k8s.io/apimachinery/pkg/util/sets"
 	"k8s.io/dynamic-resource-allocation/kubeletplugin"
 	"k8s.io/klog/v2"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/cache"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	ctrlmetrics "sigs.k8s.io/controller-runtime/pkg/metrics"
 
 	"github.com/SchSeba/dra-driver-sriov/pkg/cdi"
 	"github.com/SchSeba/dra-driver-sriov/pkg/cni"
 	"github.com/SchSeba/dra-driver-sriov/pkg/consts"
 	"github.com/SchSeba/dra-driver-sriov/pkg/controller"
+	"github.com/SchSeba/dra-driver-sriov/pkg/debug"
 	"github.com/SchSeba/dra-driver-sriov/pkg/devicestate"
 	"github.com/SchSeba/dra-driver-sriov/pkg/driver"
+	"github.com/SchSeba/dra-driver-sriov/pkg/filelock"
 	"github.com/SchSeba/dra-driver-sriov/pkg/flags"
+	"github.com/SchSeba/dra-driver-sriov/pkg/host"
+	"github.com/SchSeba/dra-driver-sriov/pkg/metrics"
 	"github.com/SchSeba/dra-driver-sriov/pkg/nri"
 	"github.com/SchSeba/dra-driver-sriov/pkg/podmanager"
+	"github.com/SchSeba/dra-driver-sriov/pkg/selftest"
+	"github.com/SchSeba/dra-driver-sriov/pkg/tracing"
 	"github.com/SchSeba/dra-driver-sriov/pkg/types"
 
 	sriovdrav1alpha1 "github.com/SchSeba/dra-driver-sriov/pkg/api/sriovdra/v1alpha1"
@@ -56,6 +66,52 @@ func newApp() *cli.App {
 			Destination: &flagsOptions.CdiRoot,
 			EnvVars:     []string{"CDI_ROOT"},
 		},
+		&cli.StringFlag{
+			Name:        "cdi-vendor",
+			Usage:       "Vendor of the generated CDI devices. Drivers running side by side on a node must use different vendors so their CDI device names don't collide.",
+			Value:       cdi.DefaultVendor,
+			Destination: &flagsOptions.CdiVendor,
+			EnvVars:     []string{"CDI_VENDOR"},
+		},
+		&cli.StringFlag{
+			Name:        "cdi-spec-mode",
+			Usage:       "Octal mode of the generated CDI spec files, e.g. 0644. When empty, the files are only readable by the driver user.",
+			Destination: &flagsOptions.CdiSpecMode,
+			EnvVars:     []string{"CDI_SPEC_MODE"},
+		},
+		&cli.StringFlag{
+			Name:        "cdi-spec-owner",
+			Usage:       "Numeric uid:gid owning the generated CDI spec files. When empty, the files are owned by the driver user.",
+			Destination: &flagsOptions.CdiSpecOwner,
+			EnvVars:     []string{"CDI_SPEC_OWNER"},
+		},
+		&cli.StringFlag{
+			Name:        "cdi-spec-version",
+			Usage:       "CDI spec version declared by the generated CDI spec files, e.g. 0.6.0, for runtimes supporting only older versions. When empty, each file declares the minimum version it requires.",
+			Destination: &flagsOptions.CdiSpecVersion,
+			EnvVars:     []string{"CDI_SPEC_VERSION"},
+		},
+		&cli.BoolFlag{
+			Name:        "skip-cdi-common-spec",
+			Usage:       "Skip the creation of the CDI common spec file exposing the node and driver names to the containers, for CDI directories where another component conflicts with it. The per-claim spec files are still created. Set it to false to write the common spec file.",
+			Value:       true,
+			Destination: &flagsOptions.SkipCDICommonSpec,
+			EnvVars:     []string{"SKIP_CDI_COMMON_SPEC"},
+		},
+		&cli.BoolFlag{
+			Name:        "gc-stale-cdi",
+			Usage:       "Remove on startup the claim and pod CDI spec files of the claims not in the checkpoint, left behind when the driver was killed. Not done during a seamless upgrade.",
+			Value:       false,
+			Destination: &flagsOptions.GCStaleCDI,
+			EnvVars:     []string{"GC_STALE_CDI"},
+		},
+		&cli.BoolFlag{
+			Name:        "isolate-cni-cache",
+			Usage:       "Keep the libcni cache of the attachments in a directory under the driver plugin data path instead of the shared /var/lib/cni.",
+			Value:       false,
+			Destination: &flagsOptions.IsolateCNICache,
+			EnvVars:     []string{"ISOLATE_CNI_CACHE"},
+		},
 		&cli.StringFlag{
 			Name:        "kubelet-registrar-directory-path",
 			Usage:       "Absolute path to the directory where kubelet stores plugin registrations.",
@@ -77,6 +133,12 @@ func newApp() *cli.App {
 			Destination: &flagsOptions.HealthcheckPort,
 			EnvVars:     []string{"HEALTHCHECK_PORT"},
 		},
+		&cli.StringFlag{
+			Name:        "healthcheck-bind-address",
+			Usage:       "Address the gRPC healthcheck service binds to, e.g. 127.0.0.1. When empty, it binds to all the interfaces.",
+			Destination: &flagsOptions.HealthcheckBindAddress,
+			EnvVars:     []string{"HEALTHCHECK_BIND_ADDRESS"},
+		},
 		&cli.StringFlag{
 			Name:        "default-interface-prefix",
 			Usage:       "Default interface prefix to be used for the virtual functions.",
@@ -84,6 +146,19 @@ func newApp() *cli.App {
 			Destination: &flagsOptions.DefaultInterfacePrefix,
 			EnvVars:     []string{"DEFAULT_INTERFACE_PREFIX"},
 		},
+		&cli.StringFlag{
+			Name:        "ifname-template",
+			Usage:       "Go template naming the interfaces of the virtual functions without an ifName in their config, e.g. 'sriov{{.Index}}'. It must make Linux interface names of at most 15 characters. When empty, the default interface prefix is followed by the index.",
+			Destination: &flagsOptions.IfNameTemplate,
+			EnvVars:     []string{"IFNAME_TEMPLATE"},
+		},
+		&cli.StringFlag{
+			Name:        "env-prefix",
+			Usage:       "Prefix prepended to the names of the environment variables injected for each device (e.g. SRIOVNETWORK_VF_DEVICE_<device>) and for the pod (SRIOVNETWORK_PCI_ADDRESSES). When empty, the names are unchanged.",
+			Value:       "",
+			Destination: &flagsOptions.EnvPrefix,
+			EnvVars:     []string{"ENV_PREFIX"},
+		},
 		&cli.StringFlag{
 			Name:        "namespace",
 			Usage:       "Namespace where the driver should watch for SriovResourceFilter resources.",
@@ -91,6 +166,266 @@ func newApp() *cli.App {
 			Destination: &flagsOptions.Namespace,
 			EnvVars:     []string{"NAMESPACE"},
 		},
+		&cli.BoolFlag{
+			Name:        "seamless-upgrade",
+			Usage:       "Let the kubelet plugin of a new driver pod register while the old one is still running, so the claims keep being prepared during an upgrade. Requires the pod UID and Kubernetes 1.33 or newer.",
+			Value:       false,
+			Destination: &flagsOptions.SeamlessUpgrade,
+			EnvVars:     []string{"SEAMLESS_UPGRADE"},
+		},
+		&cli.StringFlag{
+			Name:        "pod-uid",
+			Usage:       "UID of the driver pod, it makes the plugin socket names unique when the seamless upgrade is enabled.",
+			Value:       "",
+			Destination: &flagsOptions.PodUID,
+			EnvVars:     []string{"POD_UID"},
+		},
+		&cli.BoolFlag{
+			Name:        "drain-on-shutdown",
+			Usage:       "Detach all pod networks and reset the virtual functions before exiting. Intended for node decommission.",
+			Value:       false,
+			Destination: &flagsOptions.DrainOnShutdown,
+			EnvVars:     []string{"DRAIN_ON_SHUTDOWN"},
+		},
+		&cli.IntFlag{
+			Name:        "max-allocations-per-pf",
+			Usage:       "Maximum number of virtual functions that can be prepared at the same time on a single physical function. Zero means no limit.",
+			Value:       0,
+			Destination: &flagsOptions.MaxAllocationsPerPF,
+			EnvVars:     []string{"MAX_ALLOCATIONS_PER_PF"},
+		},
+		&cli.StringFlag{
+			Name:        "netns-resolution",
+			Usage:       "How to resolve the pod network namespace: 'nri' uses the sandbox namespaces, 'procfs' uses /proc/<pid>/ns/net of the sandbox and 'auto' uses the nri path when it exists on the host and procfs otherwise, e.g. for a runtime reporting a bind-mount the plugin can't see.",
+			Value:       consts.NetnsResolutionAuto,
+			Destination: &flagsOptions.NetnsResolution,
+			EnvVars:     []string{"NETNS_RESOLUTION"},
+		},
+		&cli.StringFlag{
+			Name:        "nri-plugin-name",
+			Usage:       "Name the NRI plugin registers with, empty keeps the name derived from the binary.",
+			Destination: &flagsOptions.NRIPluginName,
+			EnvVars:     []string{"SRIOV_NRI_PLUGIN_NAME"},
+		},
+		&cli.StringFlag{
+			Name:        "nri-plugin-index",
+			Usage:       "Two digit index the NRI plugin registers with, ordering it relative to the other NRI plugins.",
+			Destination: &flagsOptions.NRIPluginIndex,
+			EnvVars:     []string{"SRIOV_NRI_PLUGIN_INDEX"},
+		},
+		&cli.BoolFlag{
+			Name:        "verify-vf-reset",
+			Usage:       "Read back the virtual function configuration after it is reset on unprepare and fail if the MAC address, VLAN or rate were not cleared.",
+			Value:       false,
+			Destination: &flagsOptions.VerifyVFReset,
+			EnvVars:     []string{"VERIFY_VF_RESET"},
+		},
+		&cli.DurationFlag{
+			Name:        "vf-reset-grace-period",
+			Usage:       "Time waited before the virtual functions are reset on unprepare, so the reset doesn't race the CNI DEL still processed by the driver. Zero resets them right away.",
+			Value:       0,
+			Destination: &flagsOptions.VFResetGracePeriod,
+			EnvVars:     []string{"VF_RESET_GRACE_PERIOD"},
+		},
+		&cli.DurationFlag{
+			Name:        "vf-netdev-wait-timeout",
+			Usage:       "Time waited on prepare for the netdev of a virtual function kept on its kernel driver to appear, while the driver is still probing it. Zero doesn't wait.",
+			Value:       0,
+			Destination: &flagsOptions.VFNetdevWaitTimeout,
+			EnvVars:     []string{"VF_NETDEV_WAIT_TIMEOUT"},
+		},
+		&cli.StringFlag{
+			Name:        "device-naming",
+			Usage:       "Naming scheme of the published devices: 'pci' uses the VF PCI address and 'pfindex' uses the PF name and VF index, which survives PCI renumbering.",
+			Value:       consts.DeviceNamingPCI,
+			Destination: &flagsOptions.DeviceNaming,
+			EnvVars:     []string{"DEVICE_NAMING"},
+		},
+		&cli.IntFlag{
+			Name:        "max-vfs-per-node",
+			Usage:       "Maximum number of virtual functions advertised by the node, the ones with the lowest PCI addresses are kept. Zero means no limit.",
+			Value:       0,
+			Destination: &flagsOptions.MaxVFsPerNode,
+			EnvVars:     []string{"MAX_VFS_PER_NODE"},
+		},
+		&cli.IntFlag{
+			Name:        "discovery-concurrency",
+			Usage:       "Number of workers walking the PCI devices and the VFs of the PFs in parallel during the device discovery. Zero uses one worker per CPU.",
+			Value:       0,
+			Destination: &flagsOptions.DiscoveryConcurrency,
+			EnvVars:     []string{"DISCOVERY_CONCURRENCY"},
+		},
+		&cli.StringFlag{
+			Name:        "reserved-vfs",
+			Usage:       "Comma separated PCI addresses (e.g. 0000:01:00.2) of the virtual functions kept for the host services, they are never advertised.",
+			Destination: &flagsOptions.ReservedVFs,
+			EnvVars:     []string{"RESERVED_VFS"},
+		},
+		&cli.StringFlag{
+			Name:        "allowed-host-paths",
+			Usage:       "Comma separated host paths (e.g. /dev/hugepages,/dev/vfio) the device nodes and the mounts requested by the VfConfigs must be under. Empty rejects every requested device node and mount, /, /proc, /sys, /etc, /run and /var/run are always rejected.",
+			Destination: &flagsOptions.AllowedHostPaths,
+			EnvVars:     []string{"ALLOWED_HOST_PATHS"},
+		},
+		&cli.BoolFlag{
+			Name:        "exclude-host-used-vfs",
+			Usage:       "Don't advertise the virtual functions with a netdev that is up in the host network namespace, they are used by the host. The virtual functions attached to pods or bound to a userspace driver are still advertised.",
+			Value:       false,
+			Destination: &flagsOptions.ExcludeHostUsedVFs,
+			EnvVars:     []string{"EXCLUDE_HOST_USED_VFS"},
+		},
+		&cli.BoolFlag{
+			Name:        "exclude-default-route-pf",
+			Usage:       "Don't advertise the virtual functions of the PFs carrying the node default route, directly or through a VLAN, a bond or a bridge, so the management uplink is never handed to a pod.",
+			Value:       false,
+			Destination: &flagsOptions.ExcludeDefaultRoutePF,
+			EnvVars:     []string{"EXCLUDE_DEFAULT_ROUTE_PF"},
+		},
+		&cli.StringFlag{
+			Name:        "default-route-pf-allowlist",
+			Usage:       "Comma separated names of the PFs carrying the default route whose virtual functions are still advertised with --exclude-default-route-pf.",
+			Destination: &flagsOptions.DefaultRoutePFAllowlist,
+			EnvVars:     []string{"DEFAULT_ROUTE_PF_ALLOWLIST"},
+		},
+		&cli.StringFlag{
+			Name:        "extra-device-attributes",
+			Usage:       "Comma separated key=value pairs (e.g. rack=r1,zone=z1) published as string attributes of every device under the " + consts.ExtraAttributeDomain + " domain, for topology aware scheduling.",
+			Destination: &flagsOptions.ExtraDeviceAttributes,
+			EnvVars:     []string{"EXTRA_DEVICE_ATTRIBUTES"},
+		},
+		&cli.BoolFlag{
+			Name:        "advertise-vf-groups",
+			Usage:       "Also advertise one device per physical function named '<pf>-all-vfs' allocating all its virtual functions together, for workloads needing the exclusive use of a physical function.",
+			Value:       false,
+			Destination: &flagsOptions.AdvertiseVFGroups,
+			EnvVars:     []string{"ADVERTISE_VF_GROUPS"},
+		},
+		&cli.StringFlag{
+			Name:        "default-vf-config",
+			Usage:       "Path to a JSON file holding a VfConfig applied to every claim underneath the claim configs. Claims without a config for the driver use it as is.",
+			Destination: &flagsOptions.DefaultVfConfigFile,
+			EnvVars:     []string{"DEFAULT_VF_CONFIG"},
+		},
+		&cli.StringFlag{
+			Name:        "prepare-webhook-url",
+			Usage:       "HTTPS URL of a webhook the claims and their resolved VfConfigs are POSTed to before they are prepared. The claim is only prepared when the webhook allows it, a webhook error fails the prepare. When empty, no webhook is called.",
+			Destination: &flagsOptions.PrepareWebhookURL,
+			EnvVars:     []string{"PREPARE_WEBHOOK_URL"},
+		},
+		&cli.StringFlag{
+			Name:        "prepare-webhook-ca-file",
+			Usage:       "Path to a PEM file holding the CA certificates the prepare webhook certificate is verified with. When empty, the system CA certificates are used.",
+			Destination: &flagsOptions.PrepareWebhookCAFile,
+			EnvVars:     []string{"PREPARE_WEBHOOK_CA_FILE"},
+		},
+		&cli.StringFlag{
+			Name:        "otel-endpoint",
+			Usage:       "URL of the OpenTelemetry collector (OTLP over HTTP, e.g. http://collector:4318) the spans of the prepare and CNI operations are exported to. When empty, tracing is disabled.",
+			Destination: &flagsOptions.OtelEndpoint,
+			EnvVars:     []string{"OTEL_ENDPOINT"},
+		},
+		&cli.StringFlag{
+			Name:        "manage-eswitch-mode",
+			Usage:       "Eswitch mode ('legacy' or 'switchdev') set on the SR-IOV physical functions at startup when it differs. Only the physical functions kept by the device filters (e.g. --exclude-default-route-pf) are managed, the ones with virtual functions enabled or failing to be changed are logged and skipped. When empty, the eswitch mode is not managed.",
+			Destination: &flagsOptions.ManageEswitchMode,
+			EnvVars:     []string{"MANAGE_ESWITCH_MODE"},
+		},
+		&cli.DurationFlag{
+			Name:        "link-state-refresh-interval",
+			Usage:       "Interval between the refreshes of the linkUp device attribute from the physical function link state. Zero disables the refresh.",
+			Value:       30 * time.Second,
+			Destination: &flagsOptions.LinkStateRefreshInterval,
+			EnvVars:     []string{"LINK_STATE_REFRESH_INTERVAL"},
+		},
+		&cli.DurationFlag{
+			Name:        "vf-stats-interval",
+			Usage:       "Interval between the reads of the traffic statistics of the prepared virtual functions, exposed as Prometheus gauges on the controller manager metrics endpoint. Zero disables the statistics.",
+			Value:       30 * time.Second,
+			Destination: &flagsOptions.VFStatsInterval,
+			EnvVars:     []string{"VF_STATS_INTERVAL"},
+		},
+		&cli.DurationFlag{
+			Name:        "orphaned-pods-reconcile-interval",
+			Usage:       "Interval between the detaches of the networks of the pods that vanished without a StopPodSandbox event (e.g. after a node reboot), releasing their VF attachments and IPAM leases. Zero disables the reconciliation.",
+			Value:       5 * time.Minute,
+			Destination: &flagsOptions.OrphanedPodsReconcileInterval,
+			EnvVars:     []string{"ORPHANED_PODS_RECONCILE_INTERVAL"},
+		},
+		&cli.DurationFlag{
+			Name:        "pci-hotplug-debounce-window",
+			Usage:       "Quiet period after the last network PCI device added or removed before the devices are rediscovered, so a NIC hot-plugged or enabled is published without a restart. Zero disables the hot-plug detection.",
+			Value:       5 * time.Second,
+			Destination: &flagsOptions.PCIHotplugDebounceWindow,
+			EnvVars:     []string{"PCI_HOTPLUG_DEBOUNCE_WINDOW"},
+		},
+		&cli.IntFlag{
+			Name:        "debug-http-port",
+			Usage:       "Port on localhost serving the read-only debug endpoint with the devices and prepared claims as JSON. When zero, a random port is allocated. When negative, the debug endpoint is disabled.",
+			Value:       -1,
+			Destination: &flagsOptions.DebugHTTPPort,
+			EnvVars:     []string{"DEBUG_HTTP_PORT"},
+		},
+		&cli.StringFlag{
+			Name:        "pool-name",
+			Usage:       "Name of the resourceslice pool the devices are published in. Defaults to the node name.",
+			Destination: &flagsOptions.PoolName,
+			EnvVars:     []string{"POOL_NAME"},
+		},
+		&cli.BoolFlag{
+			Name:        "split-pools-by-numa",
+			Usage:       "Publish the devices in one resourceslice pool per NUMA node named '<pool>-numaN'. The devices of a PF without NUMA affinity are published on NUMA node 0 and land in '<pool>-numa0'.",
+			Value:       false,
+			Destination: &flagsOptions.SplitPoolsByNUMA,
+			EnvVars:     []string{"SPLIT_POOLS_BY_NUMA"},
+		},
+		&cli.StringFlag{
+			Name:        "drained-pfs-file",
+			Usage:       "Path to a file listing one physical function interface name per line whose virtual functions are not published, the already prepared claims are left intact. The file is read periodically. When empty, no physical function is drained.",
+			Destination: &flagsOptions.DrainedPFsFile,
+			EnvVars:     []string{"DRAINED_PFS_FILE"},
+		},
+		&cli.DurationFlag{
+			Name:        "republish-debounce-window",
+			Usage:       "Window during which the device changes are coalesced into a single republish of the resource slices. Zero republishes on every change.",
+			Value:       500 * time.Millisecond,
+			Destination: &flagsOptions.RepublishDebounceWindow,
+			EnvVars:     []string{"REPUBLISH_DEBOUNCE_WINDOW"},
+		},
+		&cli.DurationFlag{
+			Name:        "registration-retry-timeout",
+			Usage:       "Time during which a failed start of the kubelet plugin is retried with backoff, e.g. while the kubelet plugin registry directory is not ready on boot. Zero fails on the first error.",
+			Value:       time.Minute,
+			Destination: &flagsOptions.RegistrationRetryTimeout,
+			EnvVars:     []string{"REGISTRATION_RETRY_TIMEOUT"},
+		},
+		&cli.BoolFlag{
+			Name:        "publish-node-condition",
+			Usage:       "Report the driver health in the '" + consts.NodeConditionDriverReady + "' condition of the node, for the cluster tooling watching the node conditions. Needs the permission to patch the nodes status.",
+			Value:       false,
+			Destination: &flagsOptions.PublishNodeCondition,
+			EnvVars:     []string{"PUBLISH_NODE_CONDITION"},
+		},
+		&cli.BoolFlag{
+			Name:        "log-sysfs-paths",
+			Usage:       "Debug: log every sysfs and procfs path read by the device discovery and configuration with its raw content at verbosity 5.",
+			Value:       false,
+			Destination: &flagsOptions.LogSysfsPaths,
+			EnvVars:     []string{"LOG_SYSFS_PATHS"},
+		},
+		&cli.BoolFlag{
+			Name:        "use-sriovnet",
+			Usage:       "Find the interface and the eswitch mode of the physical functions with the sriovnet library, which maps a switchdev physical function to its uplink representor. The sysfs and devlink detection is the fallback.",
+			Value:       false,
+			Destination: &flagsOptions.UseSriovnet,
+			EnvVars:     []string{"USE_SRIOVNET"},
+		},
+		&cli.StringFlag{
+			Name:        "checkpoint-corruption-policy",
+			Usage:       "What to do when the checkpoint fails the checksum verification on startup: 'fail' refuses to start and 'quarantine' moves the checkpoint aside and starts with an empty one.",
+			Value:       consts.CheckpointCorruptionPolicyFail,
+			Destination: &flagsOptions.CheckpointCorruptionPolicy,
+			EnvVars:     []string{"CHECKPOINT_CORRUPTION_POLICY"},
+		},
 	}
 	cliFlags = append(cliFlags, flagsOptions.KubeClientConfig.Flags()...)
 	cliFlags = append(cliFlags, flagsOptions.LoggingConfig.Flags()...)
@@ -102,12 +437,48 @@ func newApp() *cli.App {
 		HideHelpCommand: true,
 		Flags:           cliFlags,
 		Before: func(c *cli.Context) error {
-			if c.Args().Len() > 0 {
-				return fmt.Errorf("arguments not supported: %v", c.Args().Slice())
+			if err := flagsOptions.LoggingConfig.Apply(); err != nil {
+				return err
 			}
-			return flagsOptions.LoggingConfig.Apply()
+			if flagsOptions.LogSysfsPaths {
+				host.EnableSysfsTrace(klog.Background())
+			}
+			if flagsOptions.UseSriovnet {
+				host.EnableSriovnet()
+			}
+			return nil
+		},
+		Commands: []*cli.Command{
+			{
+				Name:  "selftest",
+				Usage: "Check that the node is ready to run the driver and print a readiness report without starting the plugin.",
+				Action: func(c *cli.Context) error {
+					config := &types.Config{
+						Flags: flagsOptions,
+					}
+					if !selftest.PrintReport(os.Stdout, selftest.Run(c.Context, config, consts.CNIBinDir)) {
+						return fmt.Errorf("node is not ready")
+					}
+					return nil
+				},
+			},
+			{
+				Name:  "status",
+				Usage: "Print the claims prepared by the driver running on the node, read from its debug endpoint (--debug-http-port).",
+				Action: func(c *cli.Context) error {
+					state, err := debug.FetchState(c.Context, flagsOptions.DebugHTTPPort)
+					if err != nil {
+						return err
+					}
+					return debug.PrintPreparedClaims(os.Stdout, state)
+				},
+			},
 		},
 		Action: func(c *cli.Context) error {
+			if c.Args().Len() > 0 {
+				return fmt.Errorf("arguments not supported: %v", c.Args().Slice())
+			}
+
 			ctx := c.Context
 			clientSets, err := flagsOptions.KubeClientConfig.NewClientSets()
 			if err != nil {
@@ -126,16 +497,81 @@ func newApp() *cli.App {
 	return app
 }
 
+// deleteStaleCDISpecFiles removes the CDI spec files of the claims and pods missing from the checkpoint
+func deleteStaleCDISpecFiles(ctx context.Context, cdi *cdi.Handler, podManager *podmanager.PodManager) {
+	logger := klog.FromContext(ctx)
+
+	uids := sets.New[string]()
+	for podUID, preparedDevicesByClaimID := range podManager.Dump() {
+		uids.Insert(string(podUID))
+		for claimUID := range preparedDevicesByClaimID {
+			uids.Insert(string(claimUID))
+		}
+	}
+	removed, err := cdi.DeleteStaleSpecFiles(uids)
+	if len(removed) > 0 {
+		logger.Info("Removed the stale CDI spec files", "files", removed)
+	}
+	if err != nil {
+		logger.Error(err, "Failed to remove the stale CDI spec files")
+	}
+}
+
 func RunPlugin(ctx context.Context, config *types.Config) error {
 	// set the loggers
 	logger := klog.FromContext(ctx)
 	ctrl.SetLogger(logger)
 
-	err := os.MkdirAll(config.DriverPluginPath(), 0750)
+	if err := types.ValidatePoolName(config.PoolName()); err != nil {
+		return err
+	}
+	if err := cdi.ValidateVendor(config.Flags.CdiVendor); err != nil {
+		return err
+	}
+	cdiSpecMode, err := cdi.ParseSpecFileMode(config.Flags.CdiSpecMode)
+	if err != nil {
+		return err
+	}
+	cdiSpecOwner, err := cdi.ParseSpecFileOwner(config.Flags.CdiSpecOwner)
+	if err != nil {
+		return err
+	}
+	if err := cdi.ValidateSpecVersion(config.Flags.CdiSpecVersion); err != nil {
+		return err
+	}
+	if err := types.ValidateEnvPrefix(config.Flags.EnvPrefix); err != nil {
+		return err
+	}
+	if err := types.ValidateBindAddress(config.Flags.HealthcheckBindAddress); err != nil {
+		return err
+	}
+	if err := nri.ValidatePluginIndex(config.Flags.NRIPluginIndex); err != nil {
+		return err
+	}
+	if config.Flags.SeamlessUpgrade && config.Flags.PodUID == "" {
+		return fmt.Errorf("the pod UID is required when the seamless upgrade is enabled")
+	}
+
+	err = os.MkdirAll(config.DriverPluginPath(), 0750)
 	if err != nil {
 		return err
 	}
 
+	// make sure a single driver instance runs on the node, two instances would fight over the CDI files and VFs.
+	// During a seamless upgrade the old and new instances run side by side, the kubelet plugin
+	// serializes their prepare and unprepare calls instead and the lock is only taken before registering to NRI
+	if !config.Flags.SeamlessUpgrade {
+		lock, err := filelock.Acquire(filepath.Join(config.DriverPluginPath(), consts.DriverPluginLockFile))
+		if err != nil {
+			return fmt.Errorf("another driver instance may be running on this node: %w", err)
+		}
+		defer func() {
+			if err := lock.Release(); err != nil {
+				logger.Error(err, "Unable to release the driver lock")
+			}
+		}()
+	}
+
 	info, err := os.Stat(config.Flags.CdiRoot)
 	switch {
 	case err != nil && os.IsNotExist(err):
@@ -154,14 +590,31 @@ func RunPlugin(ctx context.Context, config *types.Config) error {
 	ctx, cancel := context.WithCancelCause(ctx)
 	config.CancelMainCtx = cancel
 
-	cdi, err := cdi.NewHandler(config.Flags.CdiRoot)
+	shutdownTracing, err := tracing.Setup(ctx, config.Flags.OtelEndpoint)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		// the main context is done at this point, bound the flush of the pending spans on its own
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := shutdownTracing(shutdownCtx); err != nil {
+			logger.Error(err, "Unable to flush the pending spans")
+		}
+	}()
+
+	cdi, err := cdi.NewHandler(config.Flags.CdiRoot, config.Flags.CdiVendor)
 	if err != nil {
 		return fmt.Errorf("unable to create CDI handler: %v", err)
 	}
+	cdi.SetSpecFilePermissions(cdiSpecMode, cdiSpecOwner)
+	cdi.SetSpecVersion(config.Flags.CdiSpecVersion)
+	cdi.SetEnvPrefix(config.Flags.EnvPrefix)
 
 	// create device state manager
-	deviceStateManager, err := devicestate.NewManager(config, cdi)
+	deviceStateManager, err := devicestate.NewManager(ctx, config, cdi)
 	if err != nil {
+		driver.ReportStartFailure(ctx, config, consts.ReasonDiscoveryFailed, err)
 		return err
 	}
 
@@ -171,6 +624,28 @@ func RunPlugin(ctx context.Context, config *types.Config) error {
 		return err
 	}
 
+	deviceStateManager.SetPodDevicesLookup(podManager.GetDevicesByPodUID)
+
+	// the instance being upgraded keeps preparing claims, its spec files may not be checkpointed yet
+	if config.Flags.GCStaleCDI && !config.Flags.SeamlessUpgrade {
+		deleteStaleCDISpecFiles(ctx, cdi, podManager)
+	}
+
+	// restore the per PF allocation counters from the devices prepared before a restart
+	deviceStateManager.RecordPreparedDevices(podManager.GetPreparedDevices())
+
+	// read the drained PFs before the first publish so their VFs are never advertised
+	if config.Flags.DrainedPFsFile != "" {
+		if err := deviceStateManager.RefreshDrainedPFs(ctx, config.Flags.DrainedPFsFile); err != nil {
+			return err
+		}
+	}
+
+	// fail before publishing when the runtime has no NRI, the published devices could never be attached
+	if err := nri.CheckAvailable(ctx); err != nil {
+		return err
+	}
+
 	// start driver
 	dvr, err := driver.Start(ctx, config, deviceStateManager, podManager, cdi)
 	if err != nil {
@@ -178,7 +653,42 @@ func RunPlugin(ctx context.Context, config *types.Config) error {
 	}
 
 	// Set up the republish callback so the device state manager can trigger resource republishing
-	deviceStateManager.SetRepublishCallback(dvr.PublishResources)
+	deviceStateManager.SetRepublishCallback(dvr.RequestPublish)
+
+	// unprepare the checkpointed claims deleted while the driver was down
+	if err := dvr.ReconcileOrphanedClaims(ctx); err != nil {
+		logger.Error(err, "Failed to reconcile orphaned claims")
+	}
+
+	// keep the linkUp attribute in sync with the PF link state
+	if config.Flags.LinkStateRefreshInterval > 0 {
+		go deviceStateManager.RunLinkStateRefresher(ctx, config.Flags.LinkStateRefreshInterval)
+	}
+
+	// expose the traffic statistics of the prepared VFs
+	if config.Flags.VFStatsInterval > 0 {
+		vfStatsCollector, err := metrics.NewVFStatsCollector(ctrlmetrics.Registry, deviceStateManager, podManager)
+		if err != nil {
+			return fmt.Errorf("failed to register the VF statistics metrics: %w", err)
+		}
+		go vfStatsCollector.Run(ctx, config.Flags.VFStatsInterval)
+	}
+
+	// publish the VFs of the NICs hot-plugged or enabled after the startup
+	if config.Flags.PCIHotplugDebounceWindow > 0 {
+		go deviceStateManager.RunHotplugWatcher(ctx, config.Flags.PCIHotplugDebounceWindow)
+	}
+
+	// stop publishing the VFs of the PFs drained for maintenance
+	if config.Flags.DrainedPFsFile != "" {
+		go deviceStateManager.RunDrainedPFsWatcher(ctx, config.Flags.DrainedPFsFile)
+	}
+
+	// start the debug endpoint
+	debugServer, err := debug.Start(ctx, config, deviceStateManager, podManager)
+	if err != nil {
+		return fmt.Errorf("failed to start debug endpoint: %w", err)
+	}
 
 	// create controller manager
 	restConfig, err := config.Flags.KubeClientConfig.NewClientSetConfig()
@@ -234,7 +744,31 @@ func RunPlugin(ctx context.Context, config *types.Config) error {
 	logger.Info("Cache synced")
 
 	// create cni runtime
-	cniRuntime := cni.New(consts.DriverName, []string{"/opt/cni/bin"})
+	cniCacheDir := ""
+	if config.Flags.IsolateCNICache {
+		cniCacheDir = filepath.Join(config.DriverPluginPath(), consts.CNICacheDirName)
+	}
+	cniRuntime := cni.New(consts.DriverName, []string{consts.CNIBinDir}, cniCacheDir)
+
+	// during a seamless upgrade both instances would register to NRI and the runtime would call them both
+	// for every pod sandbox. The new instance registers once the old one exited and released the driver lock,
+	// then reads back the claims the old instance prepared meanwhile
+	if config.Flags.SeamlessUpgrade {
+		logger.Info("Waiting for the previous driver instance to exit before registering to NRI")
+		lock, err := filelock.Wait(ctx, filepath.Join(config.DriverPluginPath(), consts.DriverPluginLockFile), time.Second)
+		if err != nil {
+			return fmt.Errorf("failed to wait for the previous driver instance to exit: %w", err)
+		}
+		defer func() {
+			if err := lock.Release(); err != nil {
+				logger.Error(err, "Unable to release the driver lock")
+			}
+		}()
+		if err := podManager.Reload(); err != nil {
+			return fmt.Errorf("failed to reload the prepared claims from the checkpoint: %w", err)
+		}
+		deviceStateManager.ResetPreparedDevices(podManager.GetPreparedDevices())
+	}
 
 	// register to NRI
 	nriPlugin, err := nri.NewNRIPlugin(config, podManager, cniRuntime)
@@ -246,6 +780,11 @@ func RunPlugin(ctx context.Context, config *types.Config) error {
 		return fmt.Errorf("failed to start NRI plugin: %w", err)
 	}
 
+	// detach the networks of the pods that vanished without StopPodSandbox
+	if config.Flags.OrphanedPodsReconcileInterval > 0 {
+		go nriPlugin.RunOrphanedPodsReconciler(ctx, config.Flags.OrphanedPodsReconcileInterval)
+	}
+
 	<-ctx.Done()
 	// restore default signal behavior as soon as possible in case graceful
 	// shutdown gets stuck.
@@ -256,7 +795,16 @@ func RunPlugin(ctx context.Context, config *types.Config) error {
 		logger.Error(err, "error from context")
 	}
 	logger.V(1).Info("Shutting down")
+	if config.Flags.DrainOnShutdown {
+		logger.Info("Draining prepared devices before shutdown")
+		if err := nriPlugin.Drain(klog.NewContext(context.Background(), logger)); err != nil {
+			logger.Error(err, "Unable to cleanly drain prepared devices")
+		}
+	}
 	nriPlugin.Stop()
+	if debugServer != nil {
+		debugServer.Stop(logger)
+	}
 	err = dvr.Shutdown(logger)
 	if err != nil {
 		logger.Error(err, "Unable to cleanly shutdown driver")
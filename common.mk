@@ -47,7 +47,7 @@ endif
 endif
 
 VENDOR := sriovnetwork.openshift.io
-APIS := virtualfunction/v1alpha1 sriovdra/v1alpha1
+APIS := virtualfunction/v1alpha1 virtualfunction/v1alpha2 sriovdra/v1alpha1
 
 PLURAL_EXCEPTIONS  = DeviceClassParameters:DeviceClassParameters
 PLURAL_EXCEPTIONS += ResourceSelector:ResourceSelectors
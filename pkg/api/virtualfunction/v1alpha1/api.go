@@ -17,11 +17,16 @@
 package v1alpha1
 
 import (
+	"fmt"
+
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/conversion"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/runtime/serializer"
 	"k8s.io/apimachinery/pkg/runtime/serializer/json"
 
+	"github.com/SchSeba/dra-driver-sriov/pkg/api/virtualfunction/v1alpha2"
 	"github.com/SchSeba/dra-driver-sriov/pkg/consts"
 )
 
@@ -30,9 +35,23 @@ const (
 	Version   = "v1alpha1"
 
 	VfConfigKind = "VfConfig"
+
+	// DefaultConfigMapKey is the ConfigMap key holding the referenced VfConfig when the reference sets none
+	DefaultConfigMapKey = "vfConfig"
+)
+
+// The link states of a VF set through its PF
+const (
+	// LinkStateAuto makes the VF link follow the PF link
+	LinkStateAuto = "auto"
+	// LinkStateEnable keeps the VF link up whatever the PF link state
+	LinkStateEnable = "enable"
+	// LinkStateDisable keeps the VF link down
+	LinkStateDisable = "disable"
 )
 
 // Decoder implements a decoder for objects in this API group.
+// The v1alpha1 and v1alpha2 VfConfig are decoded to the v1alpha1 VfConfig.
 var Decoder runtime.Decoder
 
 // +genclient
@@ -46,6 +65,57 @@ type VfConfig struct {
 	IfName                string `json:"ifName,omitempty"`
 	NetAttachDefName      string `json:"netAttachDefName,omitempty"`
 	NetAttachDefNamespace string `json:"netAttachDefNamespace,omitempty"`
+	// RequireNumaAlignment fails the pod sandbox when its cpuset is not on the NUMA node of the VF,
+	// the pod must have exclusively pinned CPUs as the shared pool spans every NUMA node
+	RequireNumaAlignment bool   `json:"requireNumaAlignment,omitempty"`
+	MacAddress           string `json:"macAddress,omitempty"`
+	// RandomizeMac sets a locally administered MAC address derived from the claim UID and the device name
+	// when no MAC address is set, it is stable across the driver restarts and unique per VF
+	RandomizeMac bool `json:"randomizeMac,omitempty"`
+	Vlan         int  `json:"vlan,omitempty"`
+	// MinTxRate and MaxTxRate are the VF transmit rate limits in Mbps, 0 means no limit
+	MinTxRate int `json:"minTxRate,omitempty"`
+	MaxTxRate int `json:"maxTxRate,omitempty"`
+	// LinkState is the administrative link state of the VF: auto, enable or disable, defaults to auto
+	LinkState string `json:"linkState,omitempty"`
+	// MakeDefaultRoute requests the default route of the pod through this VF, only one device of a pod can request it
+	MakeDefaultRoute bool `json:"makeDefaultRoute,omitempty"`
+	// StaticIPs are the addresses in CIDR notation pinned on the VF, passed as the ips CNI capability (static IPAM)
+	StaticIPs []string `json:"staticIPs,omitempty"`
+	// WritePciAddressFile is the absolute container path of a file holding the VF PCI address, for the apps not reading the environment
+	WritePciAddressFile string `json:"writePciAddressFile,omitempty"`
+	// DeviceNodes is a list of additional host device nodes to expose to the container
+	DeviceNodes []string `json:"deviceNodes,omitempty"`
+	// Mounts is a list of additional host paths to mount into the container
+	Mounts []Mount `json:"mounts,omitempty"`
+	// CapabilityArgs are passed to the CNI plugins as runtime capabilities (e.g. bandwidth, portMappings)
+	CapabilityArgs map[string]runtime.RawExtension `json:"capabilityArgs,omitempty"`
+	// ConfigMapRef references a VfConfig held in a ConfigMap, resolved at prepare time.
+	// The fields set inline override the ones of the referenced VfConfig.
+	ConfigMapRef *ConfigMapReference `json:"configMapRef,omitempty"`
+}
+
+// ConfigMapReference locates a VfConfig document in a ConfigMap.
+type ConfigMapReference struct {
+	Name string `json:"name"`
+	// Namespace defaults to the namespace of the claim, it can only be set to the namespace of the claim
+	Namespace string `json:"namespace,omitempty"`
+	// Key defaults to DefaultConfigMapKey
+	Key string `json:"key,omitempty"`
+}
+
+// Mount describes a host path to bind mount into the container.
+// The host path must be under one of the allowed host paths of the node.
+type Mount struct {
+	HostPath      string `json:"hostPath"`
+	ContainerPath string `json:"containerPath"`
+	// ReadOnly defaults to true, the mount is only writable when it is set to false
+	ReadOnly *bool `json:"readOnly,omitempty"`
+}
+
+// IsReadOnly returns true unless the mount is explicitly made writable
+func (m Mount) IsReadOnly() bool {
+	return m.ReadOnly == nil || *m.ReadOnly
 }
 
 // DefaultGpuConfig provides the default GPU configuration.
@@ -69,22 +139,68 @@ func (c *VfConfig) Override(other *VfConfig) {
 	if other.IfName != "" {
 		c.IfName = other.IfName
 	}
+	if other.AddVhostMount {
+		c.AddVhostMount = true
+	}
 	if other.NetAttachDefName != "" {
 		c.NetAttachDefName = other.NetAttachDefName
 	}
+	if other.NetAttachDefNamespace != "" {
+		c.NetAttachDefNamespace = other.NetAttachDefNamespace
+	}
+	if other.RequireNumaAlignment {
+		c.RequireNumaAlignment = true
+	}
+	if other.MacAddress != "" {
+		c.MacAddress = other.MacAddress
+	}
+	if other.RandomizeMac {
+		c.RandomizeMac = true
+	}
+	if other.Vlan != 0 {
+		c.Vlan = other.Vlan
+	}
+	if other.MinTxRate != 0 {
+		c.MinTxRate = other.MinTxRate
+	}
+	if other.MaxTxRate != 0 {
+		c.MaxTxRate = other.MaxTxRate
+	}
+	if other.LinkState != "" {
+		c.LinkState = other.LinkState
+	}
+	if other.MakeDefaultRoute {
+		c.MakeDefaultRoute = true
+	}
+	if len(other.StaticIPs) > 0 {
+		c.StaticIPs = other.StaticIPs
+	}
+	if other.WritePciAddressFile != "" {
+		c.WritePciAddressFile = other.WritePciAddressFile
+	}
+	if len(other.DeviceNodes) > 0 {
+		c.DeviceNodes = other.DeviceNodes
+	}
+	if len(other.Mounts) > 0 {
+		c.Mounts = other.Mounts
+	}
+	if len(other.CapabilityArgs) > 0 {
+		c.CapabilityArgs = other.CapabilityArgs
+	}
 }
 
 // Normalize updates a VfConfig config with implied default values.
-// IMPLEMENT IF NEEDED
 func (c *VfConfig) Normalize() {
+	if c.LinkState == "" {
+		c.LinkState = LinkStateAuto
+	}
 }
 
 //nolint:gochecknoinits // Required for Kubernetes scheme registration
 func init() {
-	// Create a new scheme and add our types to it. If at some point in the
-	// future a new version of the configuration API becomes necessary, then
-	// conversion functions can be generated and registered to continue
-	// supporting older versions.
+	// Create a new scheme and add the types of all the supported versions to
+	// it. The objects of the other versions are converted to the v1alpha1
+	// types the driver works on by the conversion functions registered below.
 	scheme := runtime.NewScheme()
 	schemeGroupVersion := schema.GroupVersion{
 		Group:   GroupName,
@@ -95,8 +211,24 @@ func init() {
 	)
 	metav1.AddToGroupVersion(scheme, schemeGroupVersion)
 
-	// Set up a json serializer to decode our types.
-	Decoder = json.NewSerializerWithOptions(
+	v1alpha2GroupVersion := schema.GroupVersion{
+		Group:   v1alpha2.GroupName,
+		Version: v1alpha2.Version,
+	}
+	scheme.AddKnownTypes(v1alpha2GroupVersion,
+		&v1alpha2.VfConfig{},
+	)
+	metav1.AddToGroupVersion(scheme, v1alpha2GroupVersion)
+
+	if err := scheme.AddConversionFunc((*v1alpha2.VfConfig)(nil), (*VfConfig)(nil), func(a, b interface{}, scope conversion.Scope) error {
+		return convertV1alpha2VfConfig(a.(*v1alpha2.VfConfig), b.(*VfConfig), scope)
+	}); err != nil {
+		panic(fmt.Sprintf("failed to register the v1alpha2 VfConfig conversion: %v", err))
+	}
+
+	// Set up a strict json serializer to decode our types, unknown and
+	// duplicated fields are rejected instead of being silently dropped.
+	jsonSerializer := json.NewSerializerWithOptions(
 		json.DefaultMetaFactory,
 		scheme,
 		scheme,
@@ -104,4 +236,7 @@ func init() {
 			Pretty: true, Strict: true,
 		},
 	)
+
+	// Convert the decoded objects to the v1alpha1 version.
+	Decoder = serializer.NewCodecFactory(scheme).DecoderToVersion(jsonSerializer, schemeGroupVersion)
 }
@@ -0,0 +1,43 @@
+package v1alpha1
+
+import (
+	"k8s.io/apimachinery/pkg/conversion"
+
+	"github.com/SchSeba/dra-driver-sriov/pkg/api/virtualfunction/v1alpha2"
+)
+
+// convertV1alpha2VfConfig converts a v1alpha2 VfConfig to the v1alpha1 VfConfig the driver works on.
+func convertV1alpha2VfConfig(in *v1alpha2.VfConfig, out *VfConfig, _ conversion.Scope) error {
+	out.APIVersion = GroupName + "/" + Version
+	out.Kind = VfConfigKind
+	out.Driver = in.Driver
+	out.AddVhostMount = in.AddVhostMount
+	out.IfName = in.IfName
+	out.NetAttachDefName = in.NetAttachDefName
+	out.NetAttachDefNamespace = in.NetAttachDefNamespace
+	out.RequireNumaAlignment = in.RequireNumaAlignment
+	if in.Link != nil {
+		out.MacAddress = in.Link.MacAddress
+		out.RandomizeMac = in.Link.RandomizeMac
+		out.Vlan = in.Link.Vlan
+		out.MinTxRate = in.Link.MinTxRate
+		out.MaxTxRate = in.Link.MaxTxRate
+		out.LinkState = in.Link.LinkState
+	}
+	out.MakeDefaultRoute = in.MakeDefaultRoute
+	out.StaticIPs = in.StaticIPs
+	out.WritePciAddressFile = in.WritePciAddressFile
+	out.DeviceNodes = in.DeviceNodes
+	if in.Mounts != nil {
+		out.Mounts = make([]Mount, len(in.Mounts))
+		for i, mount := range in.Mounts {
+			out.Mounts[i] = Mount(mount)
+		}
+	}
+	out.CapabilityArgs = in.CapabilityArgs
+	if in.ConfigMapRef != nil {
+		ref := ConfigMapReference(*in.ConfigMapRef)
+		out.ConfigMapRef = &ref
+	}
+	return nil
+}
@@ -0,0 +1,94 @@
+package v1alpha1_test
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"k8s.io/apimachinery/pkg/runtime"
+
+	configapi "github.com/SchSeba/dra-driver-sriov/pkg/api/virtualfunction/v1alpha1"
+)
+
+var _ = Describe("VfConfig decoder", func() {
+	It("should decode a v1alpha1 VfConfig", func() {
+		raw := `{"apiVersion": "sriovnetwork.openshift.io/v1alpha1", "kind": "VfConfig", "driver": "vfio-pci",
+			"netAttachDefName": "test-net", "macAddress": "02:00:00:00:00:01", "vlan": 100, "maxTxRate": 1000}`
+
+		decoded, err := runtime.Decode(configapi.Decoder, []byte(raw))
+		Expect(err).ToNot(HaveOccurred())
+		config, ok := decoded.(*configapi.VfConfig)
+		Expect(ok).To(BeTrue())
+		Expect(config.APIVersion).To(Equal("sriovnetwork.openshift.io/v1alpha1"))
+		Expect(config.Driver).To(Equal("vfio-pci"))
+		Expect(config.NetAttachDefName).To(Equal("test-net"))
+		Expect(config.MacAddress).To(Equal("02:00:00:00:00:01"))
+		Expect(config.Vlan).To(Equal(100))
+		Expect(config.MaxTxRate).To(Equal(1000))
+	})
+
+	It("should decode a v1alpha2 VfConfig and convert it to v1alpha1", func() {
+		raw := `{"apiVersion": "sriovnetwork.openshift.io/v1alpha2", "kind": "VfConfig", "driver": "vfio-pci",
+			"netAttachDefName": "test-net", "staticIPs": ["192.168.1.10/24"], "writePciAddressFile": "/etc/podinfo/pci-address",
+			"mounts": [{"hostPath": "/dev/hugepages", "containerPath": "/hugepages"}],
+			"link": {"macAddress": "02:00:00:00:00:01", "randomizeMac": true, "vlan": 100, "minTxRate": 100, "maxTxRate": 1000, "linkState": "enable"}}`
+
+		decoded, err := runtime.Decode(configapi.Decoder, []byte(raw))
+		Expect(err).ToNot(HaveOccurred())
+		config, ok := decoded.(*configapi.VfConfig)
+		Expect(ok).To(BeTrue())
+		Expect(config.APIVersion).To(Equal("sriovnetwork.openshift.io/v1alpha1"))
+		Expect(config.Kind).To(Equal(configapi.VfConfigKind))
+		Expect(config.Driver).To(Equal("vfio-pci"))
+		Expect(config.NetAttachDefName).To(Equal("test-net"))
+		Expect(config.StaticIPs).To(Equal([]string{"192.168.1.10/24"}))
+		Expect(config.WritePciAddressFile).To(Equal("/etc/podinfo/pci-address"))
+		Expect(config.Mounts).To(Equal([]configapi.Mount{{HostPath: "/dev/hugepages", ContainerPath: "/hugepages"}}))
+		Expect(config.MacAddress).To(Equal("02:00:00:00:00:01"))
+		Expect(config.RandomizeMac).To(BeTrue())
+		Expect(config.Vlan).To(Equal(100))
+		Expect(config.MinTxRate).To(Equal(100))
+		Expect(config.MaxTxRate).To(Equal(1000))
+		Expect(config.LinkState).To(Equal(configapi.LinkStateEnable))
+	})
+
+	It("should decode the ConfigMap reference of a v1alpha2 VfConfig", func() {
+		raw := `{"apiVersion": "sriovnetwork.openshift.io/v1alpha2", "kind": "VfConfig",
+			"configMapRef": {"name": "vf-config", "namespace": "sriov", "key": "dpdk"}}`
+
+		decoded, err := runtime.Decode(configapi.Decoder, []byte(raw))
+		Expect(err).ToNot(HaveOccurred())
+		config, ok := decoded.(*configapi.VfConfig)
+		Expect(ok).To(BeTrue())
+		Expect(config.ConfigMapRef).To(Equal(&configapi.ConfigMapReference{Name: "vf-config", Namespace: "sriov", Key: "dpdk"}))
+	})
+
+	It("should decode a v1alpha2 VfConfig without link settings", func() {
+		raw := `{"apiVersion": "sriovnetwork.openshift.io/v1alpha2", "kind": "VfConfig", "netAttachDefName": "test-net"}`
+
+		decoded, err := runtime.Decode(configapi.Decoder, []byte(raw))
+		Expect(err).ToNot(HaveOccurred())
+		config, ok := decoded.(*configapi.VfConfig)
+		Expect(ok).To(BeTrue())
+		Expect(config.NetAttachDefName).To(Equal("test-net"))
+		Expect(config.MacAddress).To(BeEmpty())
+		Expect(config.Vlan).To(BeZero())
+	})
+
+	DescribeTable("should reject the unknown fields",
+		func(raw, field string) {
+			_, err := runtime.Decode(configapi.Decoder, []byte(raw))
+			Expect(err).To(MatchError(ContainSubstring("unknown field %q", field)))
+		},
+		Entry("in a v1alpha1 VfConfig",
+			`{"apiVersion": "sriovnetwork.openshift.io/v1alpha1", "kind": "VfConfig", "link": {"vlan": 100}}`, "link"),
+		Entry("in a v1alpha2 VfConfig",
+			`{"apiVersion": "sriovnetwork.openshift.io/v1alpha2", "kind": "VfConfig", "vlan": 100}`, "vlan"),
+		Entry("in the link of a v1alpha2 VfConfig",
+			`{"apiVersion": "sriovnetwork.openshift.io/v1alpha2", "kind": "VfConfig", "link": {"vlanId": 100}}`, "link.vlanId"),
+	)
+
+	It("should reject an unsupported version", func() {
+		raw := `{"apiVersion": "sriovnetwork.openshift.io/v1beta1", "kind": "VfConfig"}`
+		_, err := runtime.Decode(configapi.Decoder, []byte(raw))
+		Expect(err).To(HaveOccurred())
+	})
+})
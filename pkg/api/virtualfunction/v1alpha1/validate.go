@@ -1,15 +1,125 @@
 package v1alpha1
 
-import "fmt"
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"path/filepath"
+)
+
+// maxVlanID is the highest usable 802.1Q VLAN ID
+const maxVlanID = 4094
 
 // Validate ensures that GpuConfig has a valid set of values.
 func (c *VfConfig) Validate() error {
-	if c.Driver == "" {
-		return fmt.Errorf("no driver set")
+	// the driver and the net attach def name can be set by the referenced ConfigMap instead
+	if c.ConfigMapRef == nil {
+		if c.Driver == "" {
+			return fmt.Errorf("no driver set")
+		}
+		if c.NetAttachDefName == "" {
+			return fmt.Errorf("no net attach def name set")
+		}
+	}
+	return c.validateValues()
+}
+
+// ValidateDefaults ensures that a node default VfConfig has a valid set of values.
+// All the fields are optional, but a MAC address and static IPs can't be shared by all the VFs of the node
+// and the default route can only be requested by one VF of a pod.
+func (c *VfConfig) ValidateDefaults() error {
+	if c.MacAddress != "" {
+		return fmt.Errorf("mac address can not be set in the default config")
 	}
-	if c.NetAttachDefName == "" {
-		return fmt.Errorf("no net attach def name set")
+	if c.MakeDefaultRoute {
+		return fmt.Errorf("makeDefaultRoute can not be set in the default config")
+	}
+	if len(c.StaticIPs) > 0 {
+		return fmt.Errorf("static IPs can not be set in the default config")
+	}
+	if c.ConfigMapRef != nil {
+		return fmt.Errorf("configMapRef can not be set in the default config")
+	}
+	return c.validateValues()
+}
+
+// ValidateReferenced ensures that a VfConfig read from a ConfigMap has a valid set of values.
+// All the fields are optional as the referencing config completes them, but it can't reference another ConfigMap.
+func (c *VfConfig) ValidateReferenced() error {
+	if c.ConfigMapRef != nil {
+		return fmt.Errorf("configMapRef can not be set in a referenced config")
+	}
+	return c.validateValues()
+}
+
+// ValidateClaim ensures that a VfConfig of a claim or of its device class has a valid set of values.
+// All the fields are optional as the configs are merged on top of each other and of the node default config.
+func (c *VfConfig) ValidateClaim() error {
+	return c.validateValues()
+}
+
+// validateValues ensures that the fields set in the VfConfig have valid values
+func (c *VfConfig) validateValues() error {
+	if c.MacAddress != "" {
+		if _, err := net.ParseMAC(c.MacAddress); err != nil {
+			return fmt.Errorf("invalid mac address %q: %v", c.MacAddress, err)
+		}
+	}
+	if c.Vlan < 0 || c.Vlan > maxVlanID {
+		return fmt.Errorf("invalid vlan %d: must be between 0 and %d", c.Vlan, maxVlanID)
+	}
+	if c.MinTxRate < 0 || c.MaxTxRate < 0 {
+		return fmt.Errorf("invalid tx rate: min %d and max %d must not be negative", c.MinTxRate, c.MaxTxRate)
+	}
+	if c.MaxTxRate != 0 && c.MinTxRate > c.MaxTxRate {
+		return fmt.Errorf("invalid tx rate: min %d must not be higher than max %d", c.MinTxRate, c.MaxTxRate)
+	}
+	switch c.LinkState {
+	case "", LinkStateAuto, LinkStateEnable, LinkStateDisable:
+	default:
+		return fmt.Errorf("invalid link state %q: must be one of %s, %s or %s", c.LinkState, LinkStateAuto, LinkStateEnable, LinkStateDisable)
+	}
+	for _, staticIP := range c.StaticIPs {
+		if _, _, err := net.ParseCIDR(staticIP); err != nil {
+			return fmt.Errorf("invalid static IP %q: must be an address in CIDR notation, e.g. 192.168.1.10/24", staticIP)
+		}
+	}
+	if c.WritePciAddressFile != "" && !filepath.IsAbs(c.WritePciAddressFile) {
+		return fmt.Errorf("PCI address file path %q must be absolute", c.WritePciAddressFile)
+	}
+	for _, deviceNode := range c.DeviceNodes {
+		if !filepath.IsAbs(deviceNode) {
+			return fmt.Errorf("device node path %q must be absolute", deviceNode)
+		}
+	}
+	for _, mount := range c.Mounts {
+		if !filepath.IsAbs(mount.HostPath) || !filepath.IsAbs(mount.ContainerPath) {
+			return fmt.Errorf("mount paths %q:%q must be absolute", mount.HostPath, mount.ContainerPath)
+		}
+	}
+	if _, err := c.GetCapabilityArgs(); err != nil {
+		return err
+	}
+	if c.ConfigMapRef != nil && c.ConfigMapRef.Name == "" {
+		return fmt.Errorf("configMapRef has no name set")
 	}
 
 	return nil
 }
+
+// GetCapabilityArgs returns the CNI capability args decoded from their JSON values
+func (c *VfConfig) GetCapabilityArgs() (map[string]interface{}, error) {
+	if len(c.CapabilityArgs) == 0 {
+		return nil, nil
+	}
+
+	capabilityArgs := make(map[string]interface{}, len(c.CapabilityArgs))
+	for name, rawValue := range c.CapabilityArgs {
+		var value interface{}
+		if err := json.Unmarshal(rawValue.Raw, &value); err != nil {
+			return nil, fmt.Errorf("invalid capability arg %q: %v", name, err)
+		}
+		capabilityArgs[name] = value
+	}
+	return capabilityArgs, nil
+}
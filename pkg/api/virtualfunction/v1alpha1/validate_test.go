@@ -0,0 +1,136 @@
+package v1alpha1_test
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	configapi "github.com/SchSeba/dra-driver-sriov/pkg/api/virtualfunction/v1alpha1"
+)
+
+var _ = Describe("VfConfig validation", func() {
+	var config *configapi.VfConfig
+
+	BeforeEach(func() {
+		config = configapi.DefaultVfConfig()
+		config.Driver = "vfio-pci"
+		config.NetAttachDefName = "test-net"
+	})
+
+	Context("PCI address file", func() {
+		It("should accept an absolute path", func() {
+			config.WritePciAddressFile = "/etc/podinfo/pci-address"
+			Expect(config.Validate()).To(Succeed())
+		})
+
+		It("should reject a relative path", func() {
+			config.WritePciAddressFile = "podinfo/pci-address"
+			Expect(config.Validate()).To(MatchError(ContainSubstring(`PCI address file path "podinfo/pci-address" must be absolute`)))
+		})
+	})
+
+	Context("static IPs", func() {
+		It("should accept IPv4 and IPv6 addresses in CIDR notation", func() {
+			config.StaticIPs = []string{"192.168.1.10/24", "fd00::10/64"}
+			Expect(config.Validate()).To(Succeed())
+		})
+
+		DescribeTable("should reject the malformed entries",
+			func(staticIP string) {
+				config.StaticIPs = []string{"192.168.1.10/24", staticIP}
+				Expect(config.Validate()).To(MatchError(ContainSubstring("invalid static IP %q", staticIP)))
+			},
+			Entry("without a prefix length", "192.168.1.11"),
+			Entry("with an invalid address", "192.168.1.300/24"),
+			Entry("with an invalid prefix length", "fd00::11/129"),
+			Entry("empty", ""),
+		)
+
+		It("should reject the static IPs in the default config", func() {
+			config.StaticIPs = []string{"192.168.1.10/24"}
+			Expect(config.ValidateDefaults()).To(MatchError(ContainSubstring("static IPs can not be set in the default config")))
+		})
+
+		It("should be overridden by the claim config", func() {
+			claimConfig := &configapi.VfConfig{StaticIPs: []string{"10.0.0.5/24"}}
+			config.Override(claimConfig)
+			Expect(config.StaticIPs).To(Equal([]string{"10.0.0.5/24"}))
+		})
+	})
+
+	Context("link state", func() {
+		DescribeTable("should accept the valid values",
+			func(linkState string) {
+				config.LinkState = linkState
+				Expect(config.Validate()).To(Succeed())
+			},
+			Entry("unset", ""),
+			Entry("auto", configapi.LinkStateAuto),
+			Entry("enable", configapi.LinkStateEnable),
+			Entry("disable", configapi.LinkStateDisable),
+		)
+
+		It("should reject an invalid value", func() {
+			config.LinkState = "up"
+			Expect(config.Validate()).To(MatchError(ContainSubstring(`invalid link state "up"`)))
+		})
+
+		It("should default to auto", func() {
+			config.Normalize()
+			Expect(config.LinkState).To(Equal(configapi.LinkStateAuto))
+		})
+
+		It("should be overridden by the claim config", func() {
+			config.LinkState = configapi.LinkStateAuto
+			config.Override(&configapi.VfConfig{LinkState: configapi.LinkStateDisable})
+			Expect(config.LinkState).To(Equal(configapi.LinkStateDisable))
+		})
+	})
+
+	Context("override", func() {
+		It("should merge the vhost mount and the net attach def namespace", func() {
+			config.Override(&configapi.VfConfig{AddVhostMount: true, NetAttachDefNamespace: "networks"})
+			Expect(config.AddVhostMount).To(BeTrue())
+			Expect(config.NetAttachDefNamespace).To(Equal("networks"))
+		})
+
+		It("should keep the vhost mount and the net attach def namespace when they are not set", func() {
+			config.AddVhostMount = true
+			config.NetAttachDefNamespace = "networks"
+			config.Override(&configapi.VfConfig{NetAttachDefName: "test-net"})
+			Expect(config.AddVhostMount).To(BeTrue())
+			Expect(config.NetAttachDefNamespace).To(Equal("networks"))
+		})
+	})
+
+	Context("claim config", func() {
+		It("should accept a config completed by the other configs", func() {
+			Expect((&configapi.VfConfig{Vlan: 100}).ValidateClaim()).To(Succeed())
+		})
+
+		It("should reject an invalid value", func() {
+			Expect((&configapi.VfConfig{Vlan: 5000}).ValidateClaim()).To(MatchError(ContainSubstring("invalid vlan 5000")))
+		})
+	})
+
+	Context("ConfigMap reference", func() {
+		It("should accept a config taking its driver and net attach def name from the ConfigMap", func() {
+			config := &configapi.VfConfig{ConfigMapRef: &configapi.ConfigMapReference{Name: "vf-config"}}
+			Expect(config.Validate()).To(Succeed())
+		})
+
+		It("should reject a reference without name", func() {
+			config.ConfigMapRef = &configapi.ConfigMapReference{Namespace: "default"}
+			Expect(config.Validate()).To(MatchError(ContainSubstring("configMapRef has no name set")))
+		})
+
+		It("should reject the reference in the default config", func() {
+			config.ConfigMapRef = &configapi.ConfigMapReference{Name: "vf-config"}
+			Expect(config.ValidateDefaults()).To(MatchError(ContainSubstring("configMapRef can not be set in the default config")))
+		})
+
+		It("should reject a referenced config referencing another ConfigMap", func() {
+			config.ConfigMapRef = &configapi.ConfigMapReference{Name: "vf-config"}
+			Expect(config.ValidateReferenced()).To(MatchError(ContainSubstring("configMapRef can not be set in a referenced config")))
+		})
+	})
+})
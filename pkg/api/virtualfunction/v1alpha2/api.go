@@ -0,0 +1,95 @@
+/*
+ * Copyright 2023 The Kubernetes Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package v1alpha2
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+
+	"github.com/SchSeba/dra-driver-sriov/pkg/consts"
+)
+
+const (
+	GroupName = consts.GroupName
+	Version   = "v1alpha2"
+
+	VfConfigKind = "VfConfig"
+)
+
+// +genclient
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// VfConfig holds the set of parameters for configuring a VF.
+// Compared to v1alpha1 the L2 settings of the VF are grouped under link.
+type VfConfig struct {
+	metav1.TypeMeta       `json:",inline"`
+	Driver                string `json:"driver,omitempty"`
+	AddVhostMount         bool   `json:"addVhostMount,omitempty"`
+	IfName                string `json:"ifName,omitempty"`
+	NetAttachDefName      string `json:"netAttachDefName,omitempty"`
+	NetAttachDefNamespace string `json:"netAttachDefNamespace,omitempty"`
+	RequireNumaAlignment  bool   `json:"requireNumaAlignment,omitempty"`
+	// Link holds the MAC address, VLAN and rate limits applied on the VF through its PF
+	Link *LinkConfig `json:"link,omitempty"`
+	// MakeDefaultRoute requests the default route of the pod through this VF, only one device of a pod can request it
+	MakeDefaultRoute bool `json:"makeDefaultRoute,omitempty"`
+	// StaticIPs are the addresses in CIDR notation pinned on the VF, passed as the ips CNI capability (static IPAM)
+	StaticIPs []string `json:"staticIPs,omitempty"`
+	// WritePciAddressFile is the absolute container path of a file holding the VF PCI address, for the apps not reading the environment
+	WritePciAddressFile string `json:"writePciAddressFile,omitempty"`
+	// DeviceNodes is a list of additional host device nodes to expose to the container
+	DeviceNodes []string `json:"deviceNodes,omitempty"`
+	// Mounts is a list of additional host paths to mount into the container
+	Mounts []Mount `json:"mounts,omitempty"`
+	// CapabilityArgs are passed to the CNI plugins as runtime capabilities (e.g. bandwidth, portMappings)
+	CapabilityArgs map[string]runtime.RawExtension `json:"capabilityArgs,omitempty"`
+	// ConfigMapRef references a VfConfig held in a ConfigMap, resolved at prepare time.
+	// The fields set inline override the ones of the referenced VfConfig.
+	ConfigMapRef *ConfigMapReference `json:"configMapRef,omitempty"`
+}
+
+// ConfigMapReference locates a VfConfig document in a ConfigMap.
+type ConfigMapReference struct {
+	Name string `json:"name"`
+	// Namespace defaults to the namespace of the claim
+	Namespace string `json:"namespace,omitempty"`
+	// Key defaults to vfConfig
+	Key string `json:"key,omitempty"`
+}
+
+// LinkConfig holds the L2 settings of a VF.
+type LinkConfig struct {
+	MacAddress string `json:"macAddress,omitempty"`
+	// RandomizeMac sets a locally administered MAC address derived from the claim UID and the device name
+	// when no MAC address is set, it is stable across the driver restarts and unique per VF
+	RandomizeMac bool `json:"randomizeMac,omitempty"`
+	Vlan         int  `json:"vlan,omitempty"`
+	// MinTxRate and MaxTxRate are the VF transmit rate limits in Mbps, 0 means no limit
+	MinTxRate int `json:"minTxRate,omitempty"`
+	MaxTxRate int `json:"maxTxRate,omitempty"`
+	// LinkState is the administrative link state of the VF: auto, enable or disable, defaults to auto
+	LinkState string `json:"linkState,omitempty"`
+}
+
+// Mount describes a host path to bind mount into the container.
+// The host path must be under one of the allowed host paths of the node.
+type Mount struct {
+	HostPath      string `json:"hostPath"`
+	ContainerPath string `json:"containerPath"`
+	// ReadOnly defaults to true, the mount is only writable when it is set to false
+	ReadOnly *bool `json:"readOnly,omitempty"`
+}
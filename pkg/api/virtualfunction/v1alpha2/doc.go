@@ -0,0 +1,23 @@
+/*
+ * Copyright 2023 The Kubernetes Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package v1alpha2 is the v1alpha2 version of the VfConfig opaque device configuration.
+// It is decoded by the v1alpha1 Decoder and converted to the v1alpha1 VfConfig the driver works on.
+//
+// +k8s:deepcopy-gen=package
+// +groupName=vf.sriovnetwork.openshift.io
+
+package v1alpha2
@@ -3,8 +3,12 @@ package cdi
 import (
 	"fmt"
 	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
 	"strings"
 
+	"k8s.io/apimachinery/pkg/util/sets"
 	cdiapi "tags.cncf.io/container-device-interface/pkg/cdi"
 	cdiparser "tags.cncf.io/container-device-interface/pkg/parser"
 	cdispec "tags.cncf.io/container-device-interface/specs-go"
@@ -14,18 +18,68 @@ import (
 )
 
 const (
-	cdiVendor = consts.DriverName
-	cdiClass  = "vf"
-	cdiKind   = cdiVendor + "/" + cdiClass
+	// DefaultVendor is the CDI vendor of the devices when it is not overridden
+	DefaultVendor = consts.DriverName
+
+	cdiClass = "vf"
 
 	cdiCommonDeviceName = "dra-driver-sriov"
+
+	// specFileExt is the extension the CDI cache appends to the spec names when writing the spec files
+	specFileExt = ".yaml"
 )
 
 type Handler struct {
 	cache *cdiapi.Cache
+	// specDir is the directory the spec files are written in
+	specDir string
+	// vendor is the CDI vendor of the devices, drivers running side by side must use different vendors
+	// so their CDI device names don't collide
+	vendor string
+	// commonSpec is true once the common spec file is written, the prepared devices reference its device
+	commonSpec bool
+	// specFileMode is the mode set on the written spec files, zero keeps the mode of the CDI cache
+	specFileMode os.FileMode
+	// specFileOwner is the owner set on the written spec files, nil keeps the driver user
+	specFileOwner *FileOwner
+	// specVersion is the version declared by the written spec files, empty uses the minimum version each spec requires
+	specVersion string
+	// envPrefix is prepended to the names of the environment variables of the pod spec files
+	envPrefix string
+}
+
+// oldestSpecMinor is the minor of the oldest CDI spec version, the CDI library requires at least 0.3.0 from a spec
+const oldestSpecMinor = 3
+
+// SupportedSpecVersions are the CDI spec versions the spec files can be written in, oldest first,
+// up to the current version of the CDI library
+var SupportedSpecVersions = specVersions()
+
+// specVersions returns the CDI spec versions from the oldest one to the current version of the CDI library,
+// the spec versions are the minor releases of the 0 major version
+func specVersions() []string {
+	var currentMinor int
+	if _, err := fmt.Sscanf(cdispec.CurrentVersion, "0.%d.0", &currentMinor); err != nil {
+		return []string{cdispec.CurrentVersion}
+	}
+	versions := []string{}
+	for minor := oldestSpecMinor; minor <= currentMinor; minor++ {
+		versions = append(versions, fmt.Sprintf("0.%d.0", minor))
+	}
+	return versions
+}
+
+// FileOwner is the numeric user and group owning the spec files
+type FileOwner struct {
+	UID int
+	GID int
 }
 
-func NewHandler(cdiRootPath string) (*Handler, error) {
+// NewHandler returns a CDI handler writing the spec files in cdiRootPath with the given CDI vendor
+func NewHandler(cdiRootPath, vendor string) (*Handler, error) {
+	if err := ValidateVendor(vendor); err != nil {
+		return nil, err
+	}
 	cache, err := cdiapi.NewCache(
 		cdiapi.WithSpecDirs(cdiRootPath),
 	)
@@ -33,16 +87,90 @@ func NewHandler(cdiRootPath string) (*Handler, error) {
 		return nil, fmt.Errorf("unable to create a new CDI cache: %w", err)
 	}
 	handler := &Handler{
-		cache: cache,
+		cache:   cache,
+		specDir: cdiRootPath,
+		vendor:  vendor,
 	}
 
 	return handler, nil
 }
 
-// NOT used right now
+// SetSpecFilePermissions sets the mode and owner of the spec files written from now on,
+// a zero mode and a nil owner keep the ones of the CDI cache
+func (cdi *Handler) SetSpecFilePermissions(mode os.FileMode, owner *FileOwner) {
+	cdi.specFileMode = mode
+	cdi.specFileOwner = owner
+}
+
+// SetSpecVersion pins the version declared by the spec files written from now on, for the runtimes supporting
+// only older CDI spec versions. An empty version writes each spec in the minimum version it requires.
+func (cdi *Handler) SetSpecVersion(version string) {
+	cdi.specVersion = version
+}
+
+// SetEnvPrefix sets the prefix prepended to the names of the environment variables of the pod spec files written
+// from now on, like the ones injected for each device. An empty prefix keeps the names unchanged.
+func (cdi *Handler) SetEnvPrefix(prefix string) {
+	cdi.envPrefix = prefix
+}
+
+// ValidateSpecVersion checks the CDI spec version is a supported one, an empty version is valid
+func ValidateSpecVersion(version string) error {
+	if version != "" && !slices.Contains(SupportedSpecVersions, version) {
+		return fmt.Errorf("unsupported CDI spec version %q, must be one of %s", version, strings.Join(SupportedSpecVersions, ", "))
+	}
+	return nil
+}
+
+// ParseSpecFileMode parses the octal mode of the spec files, an empty mode returns zero
+func ParseSpecFileMode(mode string) (os.FileMode, error) {
+	if mode == "" {
+		return 0, nil
+	}
+	parsed, err := strconv.ParseUint(mode, 8, 32)
+	if err != nil || parsed > 0o777 {
+		return 0, fmt.Errorf("invalid CDI spec file mode %q, must be an octal permission like 0644", mode)
+	}
+	return os.FileMode(parsed), nil
+}
+
+// ParseSpecFileOwner parses the numeric "uid:gid" owner of the spec files, an empty owner returns nil
+func ParseSpecFileOwner(owner string) (*FileOwner, error) {
+	if owner == "" {
+		return nil, nil
+	}
+	uid, gid, found := strings.Cut(owner, ":")
+	if !found {
+		return nil, fmt.Errorf("invalid CDI spec file owner %q, must be a numeric uid:gid", owner)
+	}
+	parsedUID, err := strconv.ParseUint(uid, 10, 31)
+	if err != nil {
+		return nil, fmt.Errorf("invalid CDI spec file owner %q, must be a numeric uid:gid: %w", owner, err)
+	}
+	parsedGID, err := strconv.ParseUint(gid, 10, 31)
+	if err != nil {
+		return nil, fmt.Errorf("invalid CDI spec file owner %q, must be a numeric uid:gid: %w", owner, err)
+	}
+	return &FileOwner{UID: int(parsedUID), GID: int(parsedGID)}, nil
+}
+
+// ValidateVendor checks the CDI vendor follows the CDI naming rules
+func ValidateVendor(vendor string) error {
+	if err := cdiparser.ValidateVendorName(vendor); err != nil {
+		return fmt.Errorf("invalid CDI vendor %q: %w", vendor, err)
+	}
+	return nil
+}
+
+// kind returns the CDI kind of the spec files
+func (cdi *Handler) kind() string {
+	return cdi.vendor + "/" + cdiClass
+}
+
+// CreateCommonSpecFile writes the spec of the common device exposing the node and driver names to the containers
 func (cdi *Handler) CreateCommonSpecFile() error {
 	spec := &cdispec.Spec{
-		Kind: cdiKind,
+		Kind: cdi.kind(),
 		Devices: []cdispec.Device{
 			{
 				Name: cdiCommonDeviceName,
@@ -56,26 +184,36 @@ func (cdi *Handler) CreateCommonSpecFile() error {
 		},
 	}
 
-	minVersion, err := cdiapi.MinimumRequiredVersion(spec)
-	if err != nil {
-		return fmt.Errorf("failed to get minimum required CDI spec version: %v", err)
+	if err := cdi.setVersion(spec); err != nil {
+		return err
 	}
-	spec.Version = minVersion
 
 	specName, err := cdiapi.GenerateNameForTransientSpec(spec, cdiCommonDeviceName)
 	if err != nil {
 		return fmt.Errorf("failed to generate Spec name: %w", err)
 	}
 
-	return cdi.cache.WriteSpec(spec, specName)
+	if err := cdi.writeSpec(spec, specName); err != nil {
+		return err
+	}
+	cdi.commonSpec = true
+	return nil
+}
+
+// GetCommonDevices returns the CDI ID of the common device, none when the common spec file was not written
+func (cdi *Handler) GetCommonDevices() []string {
+	if !cdi.commonSpec {
+		return nil
+	}
+	return []string{cdiparser.QualifiedName(cdi.vendor, cdiClass, cdiCommonDeviceName)}
 }
 
 func (cdi *Handler) CreateClaimSpecFile(preparedDevices types.PreparedDevices) error {
 	claimUID := string(preparedDevices[0].ClaimNamespacedName.UID)
-	specName := cdiapi.GenerateTransientSpecName(cdiVendor, cdiClass, claimUID)
+	specName := cdiapi.GenerateTransientSpecName(cdi.vendor, cdiClass, claimUID)
 
 	spec := &cdispec.Spec{
-		Kind:    cdiKind,
+		Kind:    cdi.kind(),
 		Devices: []cdispec.Device{},
 	}
 
@@ -87,18 +225,39 @@ func (cdi *Handler) CreateClaimSpecFile(preparedDevices types.PreparedDevices) e
 
 		spec.Devices = append(spec.Devices, cdiDevice)
 	}
-	minVersion, err := cdiapi.MinimumRequiredVersion(spec)
+	if err := cdi.setVersion(spec); err != nil {
+		return err
+	}
+
+	return cdi.writeSpec(spec, specName)
+}
+
+// VerifyClaimSpecFile checks the CDI device of every prepared device is defined in the claim spec file
+// and referenced by its CDI device IDs. The spec file is read back from the disk, so a spec file
+// partially written or removed fails the prepare instead of the container creation in the kubelet.
+func (cdi *Handler) VerifyClaimSpecFile(preparedDevices types.PreparedDevices) error {
+	claimUID := string(preparedDevices[0].ClaimNamespacedName.UID)
+	specPath := filepath.Join(cdi.specDir, cdiapi.GenerateTransientSpecName(cdi.vendor, cdiClass, claimUID)+specFileExt)
+	spec, err := cdiapi.ReadSpec(specPath, 0)
 	if err != nil {
-		return fmt.Errorf("failed to get minimum required CDI spec version: %v", err)
+		return fmt.Errorf("failed to read CDI spec file %s: %w", specPath, err)
 	}
-	spec.Version = minVersion
 
-	return cdi.cache.WriteSpec(spec, specName)
+	for _, device := range preparedDevices {
+		cdiDeviceID := cdi.GetClaimDevices(claimUID, device.Device.DeviceName)
+		if spec.GetDevice(fmt.Sprintf("%s-%s", claimUID, device.Device.DeviceName)) == nil {
+			return fmt.Errorf("CDI device %s is missing from the spec file %s", cdiDeviceID, specPath)
+		}
+		if !slices.Contains(device.Device.CDIDeviceIDs, cdiDeviceID) {
+			return fmt.Errorf("CDI device %s is not referenced by the device %s", cdiDeviceID, device.Device.DeviceName)
+		}
+	}
+	return nil
 }
 
 func (cdi *Handler) CreateGlobalPodSpecFile(podUID string, pciAddresses []string) error {
-	envs := []string{fmt.Sprintf("SRIOVNETWORK_PCI_ADDRESSES=%s", strings.Join(pciAddresses, ","))}
-	specName := cdiapi.GenerateTransientSpecName(cdiVendor, cdiClass, podUID)
+	envs := []string{fmt.Sprintf("%sSRIOVNETWORK_PCI_ADDRESSES=%s", cdi.envPrefix, strings.Join(pciAddresses, ","))}
+	specName := cdiapi.GenerateTransientSpecName(cdi.vendor, cdiClass, podUID)
 
 	cdiDevice := cdispec.Device{
 		Name: podUID,
@@ -108,28 +267,99 @@ func (cdi *Handler) CreateGlobalPodSpecFile(podUID string, pciAddresses []string
 	}
 
 	spec := &cdispec.Spec{
-		Kind:    cdiKind,
+		Kind:    cdi.kind(),
 		Devices: []cdispec.Device{cdiDevice},
 	}
 
+	if err := cdi.setVersion(spec); err != nil {
+		return err
+	}
+
+	return cdi.writeSpec(spec, specName)
+}
+
+// setVersion sets the version of the spec, the pinned version when one is configured or the minimum version
+// the spec requires. An unsupported pinned version and a spec using features newer than the pinned version fail.
+func (cdi *Handler) setVersion(spec *cdispec.Spec) error {
 	minVersion, err := cdiapi.MinimumRequiredVersion(spec)
 	if err != nil {
 		return fmt.Errorf("failed to get minimum required CDI spec version: %v", err)
 	}
-	spec.Version = minVersion
+	if cdi.specVersion == "" {
+		spec.Version = minVersion
+		return nil
+	}
+	pinned := slices.Index(SupportedSpecVersions, cdi.specVersion)
+	if pinned < 0 {
+		return ValidateSpecVersion(cdi.specVersion)
+	}
+	required := slices.Index(SupportedSpecVersions, minVersion)
+	if required < 0 {
+		return fmt.Errorf("the CDI spec of kind %s requires version %s, not a supported CDI spec version", spec.Kind, minVersion)
+	}
+	if required > pinned {
+		return fmt.Errorf("the CDI spec of kind %s requires version %s, newer than the configured CDI spec version %s", spec.Kind, minVersion, cdi.specVersion)
+	}
+	spec.Version = cdi.specVersion
+	return nil
+}
 
-	return cdi.cache.WriteSpec(spec, specName)
+// writeSpec writes the spec file through the CDI cache and applies the configured mode and owner on it
+func (cdi *Handler) writeSpec(spec *cdispec.Spec, specName string) error {
+	if err := cdi.cache.WriteSpec(spec, specName); err != nil {
+		return err
+	}
+	specPath := filepath.Join(cdi.specDir, specName+specFileExt)
+	if cdi.specFileMode != 0 {
+		if err := os.Chmod(specPath, cdi.specFileMode); err != nil {
+			return fmt.Errorf("failed to set the mode of the CDI spec file %s: %w", specPath, err)
+		}
+	}
+	if cdi.specFileOwner != nil {
+		if err := os.Chown(specPath, cdi.specFileOwner.UID, cdi.specFileOwner.GID); err != nil {
+			return fmt.Errorf("failed to set the owner of the CDI spec file %s: %w", specPath, err)
+		}
+	}
+	return nil
 }
 
 func (cdi *Handler) DeleteSpecFile(uid string) error {
-	specName := cdiapi.GenerateTransientSpecName(cdiVendor, cdiClass, uid)
+	specName := cdiapi.GenerateTransientSpecName(cdi.vendor, cdiClass, uid)
 	return cdi.cache.RemoveSpec(specName)
 }
 
+// DeleteStaleSpecFiles removes the claim and pod spec files whose UID is not in uids, left behind
+// when the driver was killed before unpreparing their claims. The common spec file and the spec files
+// of the other vendors are kept. It returns the names of the removed spec files, sorted.
+func (cdi *Handler) DeleteStaleSpecFiles(uids sets.Set[string]) ([]string, error) {
+	entries, err := os.ReadDir(cdi.specDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list the CDI spec files in %s: %w", cdi.specDir, err)
+	}
+
+	prefix := cdiapi.GenerateTransientSpecName(cdi.vendor, cdiClass, "")
+	removed := []string{}
+	for _, entry := range entries {
+		specName, isSpec := strings.CutSuffix(entry.Name(), specFileExt)
+		if entry.IsDir() || !isSpec {
+			continue
+		}
+		uid, isTransient := strings.CutPrefix(specName, prefix)
+		if !isTransient || uid == cdiCommonDeviceName || uids.Has(uid) {
+			continue
+		}
+		if err := cdi.cache.RemoveSpec(specName); err != nil {
+			return removed, fmt.Errorf("failed to remove the stale CDI spec file %s: %w", entry.Name(), err)
+		}
+		removed = append(removed, entry.Name())
+	}
+	return removed, nil
+}
+
 func (cdi *Handler) GetClaimDevices(claimUID string, device string) string {
-	return cdiparser.QualifiedName(cdiVendor, cdiClass, fmt.Sprintf("%s-%s", claimUID, device))
+	return cdiparser.QualifiedName(cdi.vendor, cdiClass, fmt.Sprintf("%s-%s", claimUID, device))
 }
 
 func (cdi *Handler) GetPodSpecName(podUID string) string {
-	return cdiparser.QualifiedName(cdiVendor, cdiClass, podUID)
+	return cdiparser.QualifiedName(cdi.vendor, cdiClass, podUID)
 }
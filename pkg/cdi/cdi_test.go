@@ -2,10 +2,13 @@ package cdi_test
 
 import (
 	"os"
+	"path/filepath"
+	"syscall"
 
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/sets"
 	"k8s.io/dynamic-resource-allocation/kubeletplugin"
 	drapbv1 "k8s.io/kubelet/pkg/apis/dra/v1beta1"
 	cdiapi "tags.cncf.io/container-device-interface/pkg/cdi"
@@ -31,7 +34,7 @@ var _ = Describe("CDI Handler", func() {
 		tempDir, err = os.MkdirTemp("", "cdi-test-*")
 		Expect(err).NotTo(HaveOccurred())
 
-		handler, err = cdi.NewHandler(tempDir)
+		handler, err = cdi.NewHandler(tempDir, cdi.DefaultVendor)
 		Expect(err).NotTo(HaveOccurred())
 
 		claimUID = "test-claim-uid-12345"
@@ -47,14 +50,19 @@ var _ = Describe("CDI Handler", func() {
 
 	Context("NewHandler", func() {
 		It("should create handler with valid CDI root path", func() {
-			h, err := cdi.NewHandler(tempDir)
+			h, err := cdi.NewHandler(tempDir, cdi.DefaultVendor)
 			Expect(err).NotTo(HaveOccurred())
 			Expect(h).NotTo(BeNil())
 		})
 
+		It("should return error with an invalid CDI vendor", func() {
+			_, err := cdi.NewHandler(tempDir, "Not_A_Vendor!")
+			Expect(err).To(MatchError(ContainSubstring("invalid CDI vendor")))
+		})
+
 		It("should return error with invalid CDI root path", func() {
 			invalidPath := "/non/existent/path/that/should/fail"
-			_, err := cdi.NewHandler(invalidPath)
+			_, err := cdi.NewHandler(invalidPath, cdi.DefaultVendor)
 			// CDI might create directories or handle this differently
 			// The behavior depends on the CDI library implementation
 			// We'll accept either success (if CDI creates dirs) or failure
@@ -96,6 +104,13 @@ var _ = Describe("CDI Handler", func() {
 			err := handler.CreateCommonSpecFile()
 			Expect(err).NotTo(HaveOccurred())
 		})
+
+		It("should return the common device only once the common spec file is written", func() {
+			Expect(handler.GetCommonDevices()).To(BeEmpty())
+
+			Expect(handler.CreateCommonSpecFile()).To(Succeed())
+			Expect(handler.GetCommonDevices()).To(Equal([]string{cdi.DefaultVendor + "/vf=dra-driver-sriov"}))
+		})
 	})
 
 	Context("CreateClaimSpecFile", func() {
@@ -169,6 +184,192 @@ var _ = Describe("CDI Handler", func() {
 		})
 	})
 
+	Context("spec file permissions", func() {
+		specFileModes := func() map[string]os.FileMode {
+			paths, err := filepath.Glob(filepath.Join(tempDir, "*.yaml"))
+			Expect(err).NotTo(HaveOccurred())
+			modes := map[string]os.FileMode{}
+			for _, path := range paths {
+				info, err := os.Stat(path)
+				Expect(err).NotTo(HaveOccurred())
+				modes[filepath.Base(path)] = info.Mode().Perm()
+			}
+			return modes
+		}
+
+		It("should write the spec files with the configured mode and owner", func() {
+			owner := &cdi.FileOwner{UID: os.Getuid(), GID: os.Getgid()}
+			handler.SetSpecFilePermissions(0o644, owner)
+
+			Expect(handler.CreateCommonSpecFile()).To(Succeed())
+			Expect(handler.CreateGlobalPodSpecFile(podUID, []string{pciAddress1})).To(Succeed())
+
+			modes := specFileModes()
+			Expect(modes).To(HaveLen(2))
+			for name, mode := range modes {
+				Expect(mode).To(Equal(os.FileMode(0o644)), name)
+				info, err := os.Stat(filepath.Join(tempDir, name))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(int(info.Sys().(*syscall.Stat_t).Uid)).To(Equal(owner.UID))
+				Expect(int(info.Sys().(*syscall.Stat_t).Gid)).To(Equal(owner.GID))
+			}
+		})
+
+		It("should keep the mode of the CDI cache by default", func() {
+			Expect(handler.CreateGlobalPodSpecFile(podUID, []string{pciAddress1})).To(Succeed())
+
+			for name, mode := range specFileModes() {
+				Expect(mode).To(Equal(os.FileMode(0o600)), name)
+			}
+		})
+
+		It("should parse an octal mode", func() {
+			mode, err := cdi.ParseSpecFileMode("0644")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(mode).To(Equal(os.FileMode(0o644)))
+
+			mode, err = cdi.ParseSpecFileMode("")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(mode).To(BeZero())
+		})
+
+		It("should reject a mode that is not a legal octal permission", func() {
+			for _, mode := range []string{"0648", "rw-r--r--", "01777", "-1"} {
+				_, err := cdi.ParseSpecFileMode(mode)
+				Expect(err).To(MatchError(ContainSubstring("invalid CDI spec file mode")), mode)
+			}
+		})
+
+		It("should parse a numeric owner", func() {
+			owner, err := cdi.ParseSpecFileOwner("1000:2000")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(owner).To(Equal(&cdi.FileOwner{UID: 1000, GID: 2000}))
+
+			owner, err = cdi.ParseSpecFileOwner("")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(owner).To(BeNil())
+		})
+
+		It("should reject an owner that is not a numeric uid:gid", func() {
+			for _, owner := range []string{"1000", "root:root", "1000:", "-1:0"} {
+				_, err := cdi.ParseSpecFileOwner(owner)
+				Expect(err).To(MatchError(ContainSubstring("invalid CDI spec file owner")), owner)
+			}
+		})
+	})
+
+	Context("spec version", func() {
+		var preparedDevices draTypes.PreparedDevices
+
+		claimSpecVersion := func() string {
+			spec, err := cdiapi.ReadSpec(filepath.Join(tempDir, cdiapi.GenerateTransientSpecName(cdi.DefaultVendor, "vf", claimUID)+".yaml"), 0)
+			Expect(err).NotTo(HaveOccurred())
+			return spec.Version
+		}
+
+		BeforeEach(func() {
+			preparedDevices = draTypes.PreparedDevices{{
+				Device:              drapbv1.Device{DeviceName: deviceName},
+				ClaimNamespacedName: kubeletplugin.NamespacedObject{UID: types.UID(claimUID)},
+				ContainerEdits: &cdiapi.ContainerEdits{
+					ContainerEdits: &cdispec.ContainerEdits{Env: []string{"TEST_ENV=test_value"}},
+				},
+			}}
+		})
+
+		It("should declare the configured version in the spec files", func() {
+			handler.SetSpecVersion("0.6.0")
+
+			Expect(handler.CreateClaimSpecFile(preparedDevices)).To(Succeed())
+			Expect(claimSpecVersion()).To(Equal("0.6.0"))
+		})
+
+		It("should declare the minimum required version by default", func() {
+			Expect(handler.CreateClaimSpecFile(preparedDevices)).To(Succeed())
+			Expect(claimSpecVersion()).To(Equal("0.3.0"))
+		})
+
+		It("should fail a spec requiring a newer version than the configured one", func() {
+			handler.SetSpecVersion("0.4.0")
+			// the device names starting with a digit require the version 0.5.0
+			preparedDevices[0].ClaimNamespacedName.UID = "1234-claim-uid"
+
+			Expect(handler.CreateClaimSpecFile(preparedDevices)).To(MatchError(
+				"the CDI spec of kind sriovnetwork.openshift.io/vf requires version 0.5.0, newer than the configured CDI spec version 0.4.0"))
+		})
+
+		It("should fail an unsupported configured version", func() {
+			handler.SetSpecVersion("0.9.0")
+
+			Expect(handler.CreateClaimSpecFile(preparedDevices)).To(MatchError(ContainSubstring(`unsupported CDI spec version "0.9.0"`)))
+		})
+
+		It("should support the versions up to the one of the CDI library", func() {
+			Expect(cdi.SupportedSpecVersions[0]).To(Equal("0.3.0"))
+			Expect(cdi.SupportedSpecVersions[len(cdi.SupportedSpecVersions)-1]).To(Equal(cdispec.CurrentVersion))
+		})
+
+		It("should validate the supported versions", func() {
+			Expect(cdi.ValidateSpecVersion("")).To(Succeed())
+			Expect(cdi.ValidateSpecVersion("0.6.0")).To(Succeed())
+			Expect(cdi.ValidateSpecVersion("0.8.0")).To(Succeed())
+			for _, version := range []string{"0.2.0", "v0.6.0", "0.9.0", "latest"} {
+				Expect(cdi.ValidateSpecVersion(version)).To(MatchError(ContainSubstring("unsupported CDI spec version")), version)
+			}
+		})
+	})
+
+	Context("VerifyClaimSpecFile", func() {
+		var preparedDevices draTypes.PreparedDevices
+
+		newPreparedDevice := func(name string) *draTypes.PreparedDevice {
+			return &draTypes.PreparedDevice{
+				Device: drapbv1.Device{
+					DeviceName:   name,
+					CDIDeviceIDs: []string{handler.GetClaimDevices(claimUID, name)},
+				},
+				ClaimNamespacedName: kubeletplugin.NamespacedObject{
+					UID: types.UID(claimUID),
+				},
+				ContainerEdits: &cdiapi.ContainerEdits{
+					ContainerEdits: &cdispec.ContainerEdits{
+						Env: []string{"TEST_ENV=test_value"},
+					},
+				},
+			}
+		}
+
+		BeforeEach(func() {
+			preparedDevices = draTypes.PreparedDevices{newPreparedDevice(deviceName), newPreparedDevice("test-device-2")}
+		})
+
+		It("should succeed when every device is in the claim spec file", func() {
+			Expect(handler.CreateClaimSpecFile(preparedDevices)).To(Succeed())
+			Expect(handler.VerifyClaimSpecFile(preparedDevices)).To(Succeed())
+		})
+
+		It("should fail when a device is missing from the claim spec file", func() {
+			Expect(handler.CreateClaimSpecFile(preparedDevices[:1])).To(Succeed())
+
+			err := handler.VerifyClaimSpecFile(preparedDevices)
+			Expect(err).To(MatchError(ContainSubstring("CDI device %s is missing from the spec file",
+				handler.GetClaimDevices(claimUID, "test-device-2"))))
+		})
+
+		It("should fail when the claim spec file does not exist", func() {
+			err := handler.VerifyClaimSpecFile(preparedDevices)
+			Expect(err).To(MatchError(ContainSubstring("failed to read CDI spec file")))
+		})
+
+		It("should fail when a device does not reference its CDI device", func() {
+			preparedDevices[1].Device.CDIDeviceIDs = nil
+			Expect(handler.CreateClaimSpecFile(preparedDevices)).To(Succeed())
+
+			err := handler.VerifyClaimSpecFile(preparedDevices)
+			Expect(err).To(MatchError(ContainSubstring("is not referenced by the device test-device-2")))
+		})
+	})
+
 	Context("CreateGlobalPodSpecFile", func() {
 		It("should create global pod spec file successfully", func() {
 			pciAddresses := []string{pciAddress1, pciAddress2}
@@ -202,6 +403,16 @@ var _ = Describe("CDI Handler", func() {
 			// The env var should contain comma-separated PCI addresses
 			// We can't easily verify this without accessing the spec content
 		})
+
+		It("should prepend the env prefix to the PCI addresses variable", func() {
+			handler.SetEnvPrefix("ACME_")
+
+			Expect(handler.CreateGlobalPodSpecFile(podUID, []string{pciAddress1, pciAddress2})).To(Succeed())
+			spec, err := cdiapi.ReadSpec(filepath.Join(tempDir, cdiapi.GenerateTransientSpecName(cdi.DefaultVendor, "vf", podUID)+".yaml"), 0)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(spec.Devices).To(HaveLen(1))
+			Expect(spec.Devices[0].ContainerEdits.Env).To(Equal([]string{"ACME_SRIOVNETWORK_PCI_ADDRESSES=0000:01:00.0,0000:01:00.1"}))
+		})
 	})
 
 	Context("DeleteSpecFile", func() {
@@ -234,6 +445,53 @@ var _ = Describe("CDI Handler", func() {
 		})
 	})
 
+	Context("DeleteStaleSpecFiles", func() {
+		claimSpec := func(uid string) draTypes.PreparedDevices {
+			return draTypes.PreparedDevices{
+				{
+					Device:              drapbv1.Device{DeviceName: deviceName},
+					ClaimNamespacedName: kubeletplugin.NamespacedObject{UID: types.UID(uid)},
+					ContainerEdits: &cdiapi.ContainerEdits{
+						ContainerEdits: &cdispec.ContainerEdits{Env: []string{"TEST_ENV=test_value"}},
+					},
+				},
+			}
+		}
+
+		BeforeEach(func() {
+			Expect(handler.CreateCommonSpecFile()).To(Succeed())
+			Expect(handler.CreateClaimSpecFile(claimSpec(claimUID))).To(Succeed())
+			Expect(handler.CreateGlobalPodSpecFile(podUID, []string{pciAddress1})).To(Succeed())
+			Expect(handler.CreateClaimSpecFile(claimSpec("orphan-claim-uid"))).To(Succeed())
+		})
+
+		It("should remove the spec files of the claims missing from the checkpoint", func() {
+			removed, err := handler.DeleteStaleSpecFiles(sets.New(claimUID, podUID))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(removed).To(Equal([]string{"sriovnetwork.openshift.io-vf_orphan-claim-uid.yaml"}))
+
+			Expect(filepath.Join(tempDir, "sriovnetwork.openshift.io-vf_orphan-claim-uid.yaml")).NotTo(BeAnExistingFile())
+			Expect(filepath.Join(tempDir, "sriovnetwork.openshift.io-vf_"+claimUID+".yaml")).To(BeAnExistingFile())
+			Expect(filepath.Join(tempDir, "sriovnetwork.openshift.io-vf_"+podUID+".yaml")).To(BeAnExistingFile())
+			Expect(filepath.Join(tempDir, "sriovnetwork.openshift.io-vf_dra-driver-sriov.yaml")).To(BeAnExistingFile())
+		})
+
+		It("should keep the spec files of the other vendors", func() {
+			otherHandler, err := cdi.NewHandler(tempDir, "example.com")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(otherHandler.CreateClaimSpecFile(claimSpec("other-claim-uid"))).To(Succeed())
+
+			removed, err := handler.DeleteStaleSpecFiles(sets.New[string]())
+			Expect(err).NotTo(HaveOccurred())
+			Expect(removed).To(ConsistOf(
+				"sriovnetwork.openshift.io-vf_"+claimUID+".yaml",
+				"sriovnetwork.openshift.io-vf_"+podUID+".yaml",
+				"sriovnetwork.openshift.io-vf_orphan-claim-uid.yaml",
+			))
+			Expect(filepath.Join(tempDir, "example.com-vf_other-claim-uid.yaml")).To(BeAnExistingFile())
+		})
+	})
+
 	Context("GetClaimDevices", func() {
 		It("should return correct qualified device name", func() {
 			result := handler.GetClaimDevices(claimUID, deviceName)
@@ -292,6 +550,40 @@ var _ = Describe("CDI Handler", func() {
 		})
 	})
 
+	Context("Overridden vendor", func() {
+		It("should generate the CDI device IDs with the overridden vendor", func() {
+			vendorHandler, err := cdi.NewHandler(tempDir, "example.com")
+			Expect(err).NotTo(HaveOccurred())
+
+			err = vendorHandler.CreateClaimSpecFile(draTypes.PreparedDevices{
+				{
+					Device: drapbv1.Device{
+						DeviceName: deviceName,
+					},
+					ClaimNamespacedName: kubeletplugin.NamespacedObject{
+						UID: types.UID(claimUID),
+					},
+					ContainerEdits: &cdiapi.ContainerEdits{
+						ContainerEdits: &cdispec.ContainerEdits{
+							Env: []string{"TEST_ENV=test_value"},
+						},
+					},
+				},
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			claimDevice := vendorHandler.GetClaimDevices(claimUID, deviceName)
+			Expect(claimDevice).To(Equal("example.com/vf=" + claimUID + "-" + deviceName))
+			Expect(vendorHandler.GetPodSpecName(podUID)).To(Equal("example.com/vf=" + podUID))
+
+			// the device written in the spec file resolves to the ID handed to the runtime
+			cache, err := cdiapi.NewCache(cdiapi.WithSpecDirs(tempDir), cdiapi.WithAutoRefresh(false))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(cache.GetDevice(claimDevice)).NotTo(BeNil())
+			Expect(cache.GetDevice(handler.GetClaimDevices(claimUID, deviceName))).To(BeNil())
+		})
+	})
+
 	Context("Integration scenarios", func() {
 		It("should handle complete workflow: create claim spec, create pod spec, then cleanup", func() {
 			// Create prepared devices
@@ -0,0 +1,168 @@
+// Package claimstatus centralizes the ResourceClaim status updates done by the driver,
+// so the prepare, attach and detach paths don't race with the scheduler, kubelet or each other.
+package claimstatus
+
+import (
+	"context"
+	"encoding/json"
+
+	resourceapi "k8s.io/api/resource/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	coreclientset "k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/util/retry"
+	"k8s.io/klog/v2"
+
+	"github.com/SchSeba/dra-driver-sriov/pkg/consts"
+	"github.com/SchSeba/dra-driver-sriov/pkg/types"
+)
+
+// MutateDevicesFunc returns the updated devices status list of a ResourceClaim
+type MutateDevicesFunc func(devices []resourceapi.AllocatedDeviceStatus) []resourceapi.AllocatedDeviceStatus
+
+// UpdateDevices re-fetches the ResourceClaim, applies mutate to the fresh devices status and updates it.
+// On conflict the whole sequence is retried so changes made by other writers are never overwritten.
+func UpdateDevices(ctx context.Context, client coreclientset.Interface, namespace, name string, mutate MutateDevicesFunc) error {
+	logger := klog.FromContext(ctx).WithName("claimstatus.UpdateDevices")
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		claim, err := client.ResourceV1().ResourceClaims(namespace).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+
+		claim.Status.Devices = mutate(claim.Status.Devices)
+		_, err = client.ResourceV1().ResourceClaims(namespace).UpdateStatus(ctx, claim, metav1.UpdateOptions{})
+		if err != nil {
+			logger.V(2).Info("Failed to update claim status", "claim", claim.UID, "error", err.Error())
+		}
+		return err
+	})
+}
+
+// SetDevices returns a MutateDevicesFunc adding the given devices status, replacing the existing
+// entries for the same driver, pool and device.
+func SetDevices(newDevices []resourceapi.AllocatedDeviceStatus) MutateDevicesFunc {
+	return func(devices []resourceapi.AllocatedDeviceStatus) []resourceapi.AllocatedDeviceStatus {
+		for _, newDevice := range newDevices {
+			idx := findDevice(devices, newDevice.Driver, newDevice.Pool, newDevice.Device)
+			if idx == -1 {
+				devices = append(devices, newDevice)
+				continue
+			}
+			devices[idx] = newDevice
+		}
+		return devices
+	}
+}
+
+// SetNetworkData returns a MutateDevicesFunc setting the network data of a device of this driver.
+// A nil networkData clears the network data of the device.
+func SetNetworkData(pool, device string, networkData *resourceapi.NetworkDeviceData) MutateDevicesFunc {
+	return func(devices []resourceapi.AllocatedDeviceStatus) []resourceapi.AllocatedDeviceStatus {
+		idx := findDevice(devices, consts.DriverName, pool, device)
+		if idx == -1 {
+			if networkData == nil {
+				return devices
+			}
+			return append(devices, resourceapi.AllocatedDeviceStatus{
+				Driver:      consts.DriverName,
+				Pool:        pool,
+				Device:      device,
+				NetworkData: networkData,
+			})
+		}
+		devices[idx].NetworkData = networkData
+		return devices
+	}
+}
+
+// NetworkStatusKey is the key of the device status data holding the routes and DNS of the attached device,
+// next to the fields of the config applied on prepare
+const NetworkStatusKey = "network"
+
+// SetNetworkStatus returns a MutateDevicesFunc setting the network status of a device of this driver
+// under the network key of its status data. A nil networkStatus removes the key.
+// Data that is not a JSON object is left untouched.
+func SetNetworkStatus(pool, device string, networkStatus *types.NetworkStatus) MutateDevicesFunc {
+	return func(devices []resourceapi.AllocatedDeviceStatus) []resourceapi.AllocatedDeviceStatus {
+		idx := findDevice(devices, consts.DriverName, pool, device)
+		if idx == -1 {
+			if networkStatus == nil {
+				return devices
+			}
+			devices = append(devices, resourceapi.AllocatedDeviceStatus{
+				Driver: consts.DriverName,
+				Pool:   pool,
+				Device: device,
+			})
+			idx = len(devices) - 1
+		}
+
+		data := map[string]json.RawMessage{}
+		if devices[idx].Data != nil && len(devices[idx].Data.Raw) > 0 {
+			if err := json.Unmarshal(devices[idx].Data.Raw, &data); err != nil {
+				return devices
+			}
+			if data == nil {
+				data = map[string]json.RawMessage{}
+			}
+		}
+		if networkStatus == nil {
+			if _, found := data[NetworkStatusKey]; !found {
+				return devices
+			}
+			delete(data, NetworkStatusKey)
+		} else {
+			rawNetworkStatus, err := json.Marshal(networkStatus)
+			if err != nil {
+				return devices
+			}
+			data[NetworkStatusKey] = rawNetworkStatus
+		}
+		rawData, err := json.Marshal(data)
+		if err != nil {
+			return devices
+		}
+		devices[idx].Data = &runtime.RawExtension{Raw: rawData}
+		return devices
+	}
+}
+
+// All returns a MutateDevicesFunc applying the given mutations in order
+func All(mutates ...MutateDevicesFunc) MutateDevicesFunc {
+	return func(devices []resourceapi.AllocatedDeviceStatus) []resourceapi.AllocatedDeviceStatus {
+		for _, mutate := range mutates {
+			devices = mutate(devices)
+		}
+		return devices
+	}
+}
+
+// SetCondition returns a MutateDevicesFunc setting a condition on the status of a device of this driver,
+// adding the device status entry if it's missing.
+func SetCondition(pool, device string, condition metav1.Condition) MutateDevicesFunc {
+	return func(devices []resourceapi.AllocatedDeviceStatus) []resourceapi.AllocatedDeviceStatus {
+		idx := findDevice(devices, consts.DriverName, pool, device)
+		if idx == -1 {
+			devices = append(devices, resourceapi.AllocatedDeviceStatus{
+				Driver: consts.DriverName,
+				Pool:   pool,
+				Device: device,
+			})
+			idx = len(devices) - 1
+		}
+		meta.SetStatusCondition(&devices[idx].Conditions, condition)
+		return devices
+	}
+}
+
+// findDevice returns the index of the device status matching driver, pool and device or -1
+func findDevice(devices []resourceapi.AllocatedDeviceStatus, driver, pool, device string) int {
+	for idx := range devices {
+		if devices[idx].Driver == driver && devices[idx].Pool == pool && devices[idx].Device == device {
+			return idx
+		}
+	}
+	return -1
+}
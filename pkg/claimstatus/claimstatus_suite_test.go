@@ -0,0 +1,13 @@
+package claimstatus_test
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+func TestClaimStatus(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "ClaimStatus Suite")
+}
@@ -0,0 +1,231 @@
+package claimstatus_test
+
+import (
+	"context"
+	"fmt"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	resourceapi "k8s.io/api/resource/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+
+	"github.com/SchSeba/dra-driver-sriov/pkg/claimstatus"
+	"github.com/SchSeba/dra-driver-sriov/pkg/consts"
+	"github.com/SchSeba/dra-driver-sriov/pkg/types"
+)
+
+var _ = Describe("UpdateDevices", func() {
+	var (
+		ctx       context.Context
+		client    *fake.Clientset
+		claimsGVR schema.GroupVersionResource
+	)
+
+	getClaim := func() *resourceapi.ResourceClaim {
+		claim, err := client.ResourceV1().ResourceClaims("default").Get(ctx, "test-claim", metav1.GetOptions{})
+		Expect(err).NotTo(HaveOccurred())
+		return claim
+	}
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		claimsGVR = resourceapi.SchemeGroupVersion.WithResource("resourceclaims")
+		client = fake.NewSimpleClientset(&resourceapi.ResourceClaim{
+			ObjectMeta: metav1.ObjectMeta{Name: "test-claim", Namespace: "default", UID: "test-claim-uid"},
+			Status: resourceapi.ResourceClaimStatus{
+				Devices: []resourceapi.AllocatedDeviceStatus{
+					{Driver: consts.DriverName, Pool: "node1", Device: "0000-01-00-1"},
+				},
+			},
+		})
+	})
+
+	// injectConflict makes the first status update fail with a conflict after another writer
+	// changed the claim, like the scheduler or another driver would do.
+	injectConflict := func(otherWriter func(claim *resourceapi.ResourceClaim)) {
+		conflicted := false
+		client.PrependReactor("update", "resourceclaims", func(action k8stesting.Action) (bool, runtime.Object, error) {
+			if conflicted || action.GetSubresource() != "status" {
+				return false, nil, nil
+			}
+			conflicted = true
+
+			obj, err := client.Tracker().Get(claimsGVR, "default", "test-claim")
+			Expect(err).NotTo(HaveOccurred())
+			claim := obj.(*resourceapi.ResourceClaim).DeepCopy()
+			otherWriter(claim)
+			Expect(client.Tracker().Update(claimsGVR, claim, "default")).To(Succeed())
+
+			return true, nil, apierrors.NewConflict(claimsGVR.GroupResource(), "test-claim", fmt.Errorf("object has been modified"))
+		})
+	}
+
+	It("should keep the changes of a conflicting writer", func() {
+		injectConflict(func(claim *resourceapi.ResourceClaim) {
+			claim.Status.Devices = append(claim.Status.Devices, resourceapi.AllocatedDeviceStatus{
+				Driver: "other.driver.io", Pool: "node1", Device: "gpu-0",
+			})
+		})
+
+		networkData := &resourceapi.NetworkDeviceData{InterfaceName: "net1", IPs: []string{"10.0.0.5/24"}}
+		err := claimstatus.UpdateDevices(ctx, client, "default", "test-claim",
+			claimstatus.SetNetworkData("node1", "0000-01-00-1", networkData))
+		Expect(err).NotTo(HaveOccurred())
+
+		devices := getClaim().Status.Devices
+		Expect(devices).To(HaveLen(2))
+		Expect(devices[0].NetworkData).To(Equal(networkData))
+		Expect(devices[1].Driver).To(Equal("other.driver.io"))
+	})
+
+	It("should converge when attach and detach updates conflict", func() {
+		injectConflict(func(claim *resourceapi.ResourceClaim) {
+			claim.Status.Devices[0].NetworkData = &resourceapi.NetworkDeviceData{InterfaceName: "stale"}
+		})
+
+		Expect(claimstatus.UpdateDevices(ctx, client, "default", "test-claim",
+			claimstatus.SetNetworkData("node1", "0000-01-00-1", nil))).To(Succeed())
+
+		devices := getClaim().Status.Devices
+		Expect(devices).To(HaveLen(1))
+		Expect(devices[0].NetworkData).To(BeNil())
+	})
+
+	It("should return the error when the claim does not exist", func() {
+		err := claimstatus.UpdateDevices(ctx, client, "default", "missing-claim",
+			claimstatus.SetNetworkData("node1", "0000-01-00-1", nil))
+		Expect(apierrors.IsNotFound(err)).To(BeTrue())
+	})
+})
+
+var _ = Describe("SetDevices", func() {
+	It("should replace the matching entries and append the new ones", func() {
+		devices := []resourceapi.AllocatedDeviceStatus{
+			{Driver: consts.DriverName, Pool: "node1", Device: "0000-01-00-1"},
+			{Driver: "other.driver.io", Pool: "node1", Device: "gpu-0"},
+		}
+		data := &runtime.RawExtension{Raw: []byte(`{}`)}
+
+		devices = claimstatus.SetDevices([]resourceapi.AllocatedDeviceStatus{
+			{Driver: consts.DriverName, Pool: "node1", Device: "0000-01-00-1", Data: data},
+			{Driver: consts.DriverName, Pool: "node1", Device: "0000-01-00-2", Data: data},
+		})(devices)
+
+		Expect(devices).To(HaveLen(3))
+		Expect(devices[0].Data).To(Equal(data))
+		Expect(devices[1].Driver).To(Equal("other.driver.io"))
+		Expect(devices[2].Device).To(Equal("0000-01-00-2"))
+	})
+})
+
+var _ = Describe("SetCondition", func() {
+	var condition metav1.Condition
+
+	BeforeEach(func() {
+		condition = metav1.Condition{
+			Type:   "NetworkAttached",
+			Status: metav1.ConditionFalse,
+			Reason: "HostNetwork",
+		}
+	})
+
+	It("should set the condition on the matching entry", func() {
+		devices := []resourceapi.AllocatedDeviceStatus{
+			{Driver: consts.DriverName, Pool: "node1", Device: "0000-01-00-1"},
+		}
+
+		devices = claimstatus.SetCondition("node1", "0000-01-00-1", condition)(devices)
+
+		Expect(devices).To(HaveLen(1))
+		Expect(devices[0].Conditions).To(HaveLen(1))
+		Expect(devices[0].Conditions[0].Reason).To(Equal("HostNetwork"))
+	})
+
+	It("should replace an existing condition of the same type", func() {
+		devices := []resourceapi.AllocatedDeviceStatus{
+			{Driver: consts.DriverName, Pool: "node1", Device: "0000-01-00-1", Conditions: []metav1.Condition{
+				{Type: "NetworkAttached", Status: metav1.ConditionTrue, Reason: "Attached"},
+			}},
+		}
+
+		devices = claimstatus.SetCondition("node1", "0000-01-00-1", condition)(devices)
+
+		Expect(devices[0].Conditions).To(HaveLen(1))
+		Expect(devices[0].Conditions[0].Status).To(Equal(metav1.ConditionFalse))
+	})
+
+	It("should add the device entry when it is missing", func() {
+		devices := claimstatus.SetCondition("node1", "0000-01-00-1", condition)(nil)
+
+		Expect(devices).To(HaveLen(1))
+		Expect(devices[0].Driver).To(Equal(consts.DriverName))
+		Expect(devices[0].Conditions).To(HaveLen(1))
+	})
+})
+
+var _ = Describe("SetNetworkStatus", func() {
+	var networkStatus *types.NetworkStatus
+
+	BeforeEach(func() {
+		networkStatus = &types.NetworkStatus{
+			Routes: []types.NetworkRoute{{Dst: "0.0.0.0/0", GW: "192.168.1.1"}},
+			DNS:    &types.NetworkDNS{Nameservers: []string{"192.168.1.53"}},
+		}
+	})
+
+	It("should set the network key next to the existing data", func() {
+		devices := []resourceapi.AllocatedDeviceStatus{
+			{Driver: consts.DriverName, Pool: "node1", Device: "0000-01-00-1", Data: &runtime.RawExtension{
+				Raw: []byte(`{"vlan":100}`),
+			}},
+		}
+
+		devices = claimstatus.SetNetworkStatus("node1", "0000-01-00-1", networkStatus)(devices)
+
+		Expect(devices).To(HaveLen(1))
+		Expect(string(devices[0].Data.Raw)).To(MatchJSON(
+			`{"vlan":100,"network":{"routes":[{"dst":"0.0.0.0/0","gw":"192.168.1.1"}],"dns":{"nameservers":["192.168.1.53"]}}}`))
+	})
+
+	It("should remove the network key when the network status is nil", func() {
+		devices := []resourceapi.AllocatedDeviceStatus{
+			{Driver: consts.DriverName, Pool: "node1", Device: "0000-01-00-1", Data: &runtime.RawExtension{
+				Raw: []byte(`{"vlan":100,"network":{"routes":[{"dst":"0.0.0.0/0"}]}}`),
+			}},
+		}
+
+		devices = claimstatus.SetNetworkStatus("node1", "0000-01-00-1", nil)(devices)
+
+		Expect(string(devices[0].Data.Raw)).To(MatchJSON(`{"vlan":100}`))
+	})
+
+	It("should leave the data untouched when it is not a JSON object", func() {
+		devices := []resourceapi.AllocatedDeviceStatus{
+			{Driver: consts.DriverName, Pool: "node1", Device: "0000-01-00-1", Data: &runtime.RawExtension{
+				Raw: []byte(`["not","an","object"]`),
+			}},
+		}
+
+		devices = claimstatus.SetNetworkStatus("node1", "0000-01-00-1", networkStatus)(devices)
+
+		Expect(string(devices[0].Data.Raw)).To(Equal(`["not","an","object"]`))
+	})
+
+	It("should apply the network data and the network status together", func() {
+		networkData := &resourceapi.NetworkDeviceData{InterfaceName: "net1"}
+
+		devices := claimstatus.All(
+			claimstatus.SetNetworkData("node1", "0000-01-00-1", networkData),
+			claimstatus.SetNetworkStatus("node1", "0000-01-00-1", networkStatus),
+		)(nil)
+
+		Expect(devices).To(HaveLen(1))
+		Expect(devices[0].NetworkData).To(Equal(networkData))
+		Expect(string(devices[0].Data.Raw)).To(ContainSubstring(`"network"`))
+	})
+})
@@ -21,28 +21,50 @@ package cni
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"syscall"
 
+	"github.com/SchSeba/dra-driver-sriov/pkg/tracing"
 	"github.com/SchSeba/dra-driver-sriov/pkg/types"
 	"github.com/containerd/nri/pkg/api"
 	"github.com/containernetworking/cni/libcni"
+	cnitypes "github.com/containernetworking/cni/pkg/types"
+	"github.com/containernetworking/cni/pkg/version"
 	netattdefclientutils "github.com/k8snetworkplumbingwg/network-attachment-definition-client/pkg/utils"
+	"go.opentelemetry.io/otel/trace"
 	resourcev1 "k8s.io/api/resource/v1"
 	"k8s.io/klog/v2"
 )
 
+// DefaultRouteCapability is the CNI runtime capability set on the network of the device requesting the default route.
+// It is not one of the CNI conventions and no reference plugin reads it: it is meant for a plugin of the chain
+// installing the routes, e.g. a route-override like meta plugin, which declares it in its capabilities.
+const DefaultRouteCapability = "default-route"
+
+// IPsCapability is the CNI runtime capability carrying the static IPs of the VfConfig, consumed by the static IPAM
+const IPsCapability = "ips"
+
 // Runtime represents a CNI (Container Network Interface) runtime environment
 // that manages the lifecycle of network attachments for Pods via ResourceClaims.
 type Runtime struct {
 	CNIConfig  libcni.CNI
 	DriverName string
+	// CacheDir is the directory of the libcni cache, empty means the libcni default
+	CacheDir string
 }
 
 // New creates and returns a new CNI Runtime instance.
+// The libcni cache is kept in cacheDir, the libcni default is used when it is empty.
 func New(
 	driverName string,
 	cniPath []string,
+	cacheDir string,
 ) *Runtime {
 	exec := &RawExec{
 		Stderr: os.Stderr,
@@ -50,8 +72,9 @@ func New(
 	}
 
 	rntm := &Runtime{
-		CNIConfig:  libcni.NewCNIConfig(cniPath, exec),
+		CNIConfig:  libcni.NewCNIConfigWithCacheDir(cniPath, cacheDir, exec),
 		DriverName: driverName,
+		CacheDir:   cacheDir,
 	}
 
 	return rntm
@@ -63,7 +86,11 @@ func New(
 // to update the ResourceClaim's status with allocated device information.
 // If a request fails, an error is returned together with the previous successful device status up to date.
 // If the status of a device is already set, CNI ADD will be skipped and the existing status will be preserved.
-func (rntm *Runtime) AttachNetwork(ctx context.Context, pod *api.PodSandbox, podNetworkNamespace string, deviceConfig *types.PreparedDevice) (*resourcev1.NetworkDeviceData, error) {
+// The routes and DNS of the CNI result, which the network data can't carry, are returned as the network status.
+func (rntm *Runtime) AttachNetwork(ctx context.Context, pod *api.PodSandbox, podNetworkNamespace string, deviceConfig *types.PreparedDevice) (_ *resourcev1.NetworkDeviceData, _ *types.NetworkStatus, err error) {
+	ctx, span := startSpan(ctx, "AttachNetwork", deviceConfig)
+	defer func() { tracing.End(span, err) }()
+
 	rt := &libcni.RuntimeConf{
 		ContainerID: pod.Id,
 		NetNS:       podNetworkNamespace,
@@ -76,27 +103,104 @@ func (rntm *Runtime) AttachNetwork(ctx context.Context, pod *api.PodSandbox, pod
 			{"K8S_POD_UID", pod.Uid},
 		},
 	}
+	// Pass the MAC requested in the VfConfig to sriov-cni so it applies it itself, the VLAN is set in the netconf,
+	// and the capability args, the default route request and the static IPs to the plugins of the chain consuming them
+	if deviceConfig.Config != nil {
+		if deviceConfig.Config.MacAddress != "" {
+			rt.Args = append(rt.Args, [2]string{"MAC", deviceConfig.Config.MacAddress})
+		}
+		capabilityArgs, err := deviceConfig.Config.GetCapabilityArgs()
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to get capability args: %v", err)
+		}
+		// the plugins of the chain handling the routes declare the default-route capability
+		if deviceConfig.Config.MakeDefaultRoute {
+			if capabilityArgs == nil {
+				capabilityArgs = map[string]interface{}{}
+			}
+			capabilityArgs[DefaultRouteCapability] = true
+		}
+		if len(deviceConfig.Config.StaticIPs) > 0 {
+			if capabilityArgs == nil {
+				capabilityArgs = map[string]interface{}{}
+			}
+			capabilityArgs[IPsCapability] = deviceConfig.Config.StaticIPs
+		}
+		rt.CapabilityArgs = capabilityArgs
+	}
 	rawNetConf, err := netattdefclientutils.GetCNIConfigFromSpec(deviceConfig.NetAttachDefConfig, rntm.DriverName)
 	if err != nil {
-		return nil, fmt.Errorf("failed to GetCNIConfigFromSpec: %v", err)
+		return nil, nil, fmt.Errorf("failed to GetCNIConfigFromSpec: %v", err)
 	}
 
-	pluginConf, err := libcni.NetworkPluginConfFromBytes(rawNetConf)
-	if err != nil {
-		return nil, fmt.Errorf("failed to NetworkPluginConfFromBytes: %v", err)
-	}
 	klog.FromContext(ctx).V(3).Info("Runtime.AttachNetwork", "deviceConfig", deviceConfig)
 
-	cniResult, err := rntm.CNIConfig.AddNetwork(ctx, pluginConf, rt)
-	if err != nil {
-		return nil, fmt.Errorf("failed to AddNetwork: %v", err)
+	staticIPs := []string{}
+	makeDefaultRoute := false
+	if deviceConfig.Config != nil {
+		staticIPs = deviceConfig.Config.StaticIPs
+		makeDefaultRoute = deviceConfig.Config.MakeDefaultRoute
+	}
+
+	var cniResult cnitypes.Result
+	if isConfList(rawNetConf) {
+		confList, err := libcni.NetworkConfFromBytes(rawNetConf)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to NetworkConfFromBytes: %v", err)
+		}
+		if len(staticIPs) > 0 && !declaresCapability(IPsCapability, confList.Plugins...) {
+			return nil, nil, fmt.Errorf("network %s has no plugin declaring the %s capability, the static IPs can't be applied", confList.Name, IPsCapability)
+		}
+		if makeDefaultRoute && !declaresCapability(DefaultRouteCapability, confList.Plugins...) {
+			return nil, nil, fmt.Errorf("network %s has no plugin declaring the %s capability, the default route can't be applied", confList.Name, DefaultRouteCapability)
+		}
+		cniResult, err = rntm.CNIConfig.AddNetworkList(ctx, confList, rt)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to AddNetworkList: %v", err)
+		}
+	} else {
+		pluginConf, err := libcni.NetworkPluginConfFromBytes(rawNetConf)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to NetworkPluginConfFromBytes: %v", err)
+		}
+		if len(staticIPs) > 0 && !declaresCapability(IPsCapability, pluginConf) {
+			return nil, nil, fmt.Errorf("network %s doesn't declare the %s capability, the static IPs can't be applied", pluginConf.Network.Name, IPsCapability)
+		}
+		if makeDefaultRoute && !declaresCapability(DefaultRouteCapability, pluginConf) {
+			return nil, nil, fmt.Errorf("network %s doesn't declare the %s capability, the default route can't be applied", pluginConf.Network.Name, DefaultRouteCapability)
+		}
+		cniResult, err = rntm.CNIConfig.AddNetwork(ctx, pluginConf, rt)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to AddNetwork: %v", err)
+		}
 	}
 	if cniResult == nil {
-		return nil, fmt.Errorf("cni result is nil")
+		return nil, nil, fmt.Errorf("cni result is nil")
 	}
 
 	klog.FromContext(ctx).V(3).Info("Runtime.AttachedNetwork", "cniResult", cniResult)
-	return cniResultToNetworkData(cniResult)
+	networkData, networkStatus, err := cniResultToNetworkData(cniResult, deviceConfig.IfName)
+	if err != nil {
+		return nil, nil, err
+	}
+	// only the addresses returned by the plugins are reported, a pinned address missing from them is logged
+	for _, staticIP := range staticIPs {
+		if !slices.Contains(networkData.IPs, staticIP) {
+			klog.FromContext(ctx).Info("The static IP is missing from the CNI result, the chain did not apply it", "ifName", deviceConfig.IfName, "staticIP", staticIP, "ips", networkData.IPs)
+		}
+	}
+	return networkData, networkStatus, nil
+}
+
+// declaresCapability returns true if one of the plugins declares the CNI runtime capability,
+// libcni only passes the capability args to the plugins declaring them
+func declaresCapability(capability string, plugins ...*libcni.PluginConfig) bool {
+	for _, plugin := range plugins {
+		if plugin.Network.Capabilities[capability] {
+			return true
+		}
+	}
+	return false
 }
 
 // DetachNetworks detaches all network interfaces associated with a given pod.
@@ -106,7 +210,10 @@ func (rntm *Runtime) DetachNetwork(
 	pod *api.PodSandbox,
 	podNetworkNamespace string,
 	deviceConfig *types.PreparedDevice,
-) error {
+) (err error) {
+	ctx, span := startSpan(ctx, "DetachNetwork", deviceConfig)
+	defer func() { tracing.End(span, err) }()
+
 	klog.FromContext(ctx).Info("Runtime.DetachNetwork", "deviceConfig", deviceConfig)
 	rt := &libcni.RuntimeConf{
 		ContainerID: pod.Id,
@@ -120,20 +227,200 @@ func (rntm *Runtime) DetachNetwork(
 			{"K8S_POD_UID", pod.Uid},
 		},
 	}
+	// plugins like portmap need the same capability args on DEL to clean up
+	if deviceConfig.Config != nil {
+		capabilityArgs, err := deviceConfig.Config.GetCapabilityArgs()
+		if err != nil {
+			return fmt.Errorf("failed to get capability args: %v", err)
+		}
+		rt.CapabilityArgs = capabilityArgs
+	}
 	rawNetConf, err := netattdefclientutils.GetCNIConfigFromSpec(deviceConfig.NetAttachDefConfig, rntm.DriverName)
 	if err != nil {
 		return fmt.Errorf("failed to GetCNIConfigFromSpec: %v", err)
 	}
 
+	logger := klog.FromContext(ctx)
+	logger.V(3).Info("Runtime.DetachNetwork", "deviceConfig", deviceConfig)
+
+	if isConfList(rawNetConf) {
+		confList, err := libcni.NetworkConfFromBytes(rawNetConf)
+		if err != nil {
+			return fmt.Errorf("failed to NetworkConfFromBytes: %v", err)
+		}
+		complete, err := rntm.delNetworkList(ctx, confList, rt)
+		if err != nil {
+			return fmt.Errorf("failed to DelNetworkList: %w", err)
+		}
+		if complete {
+			rntm.removeCachedResult(ctx, confList.Name, rt)
+		}
+		return nil
+	}
+
 	pluginConf, err := libcni.NetworkPluginConfFromBytes(rawNetConf)
 	if err != nil {
 		return fmt.Errorf("failed to NetworkPluginConfFromBytes: %v", err)
 	}
-	klog.FromContext(ctx).V(3).Info("Runtime.DetachNetwork", "deviceConfig", deviceConfig)
 	err = rntm.CNIConfig.DelNetwork(ctx, pluginConf, rt)
 	if err != nil {
-		return fmt.Errorf("failed to DelNetwork: %v", err)
+		if !isNotFoundError(err) {
+			return fmt.Errorf("failed to DelNetwork: %v", err)
+		}
+		logger.Info("Interface already deleted, ignoring DelNetwork error", "ifName", deviceConfig.IfName, "error", err.Error())
+		return nil
 	}
+	rntm.removeCachedResult(ctx, pluginConf.Network.Name, rt)
 
 	return nil
 }
+
+// delNetworkList runs the DEL of the plugins of the list in reverse order like libcni, but goes on past a failing
+// plugin so the plugins releasing the IPAM leases still run. Every plugin gets the cached result as prevResult.
+// A plugin reporting the interface already gone is done, the other failures are returned together.
+// It returns true when the DEL of every plugin succeeded, otherwise the cache entry is kept.
+func (rntm *Runtime) delNetworkList(ctx context.Context, list *libcni.NetworkConfigList, rt *libcni.RuntimeConf) (bool, error) {
+	logger := klog.FromContext(ctx)
+
+	// the cached result on DEL was added in CNI spec version 0.4.0
+	var cachedResult cnitypes.Result
+	if gtet, err := version.GreaterThanOrEqualTo(list.CNIVersion, "0.4.0"); err != nil {
+		return false, err
+	} else if gtet {
+		if cachedResult, err = rntm.CNIConfig.GetNetworkListCachedResult(list, rt); err != nil {
+			logger.Error(err, "Failed to read the cached result, deleting without it", "network", list.Name)
+			cachedResult = nil
+		}
+	}
+	// libcni removes the cache entry after the DEL of each plugin, it is written back when a plugin fails
+	cacheEntry := rntm.readCachedResult(list.Name, rt)
+
+	complete := true
+	var errs []error
+	for i := len(list.Plugins) - 1; i >= 0; i-- {
+		plugin := list.Plugins[i]
+		pluginConf, err := listPluginConfig(list, plugin, cachedResult)
+		if err == nil {
+			err = rntm.CNIConfig.DelNetwork(ctx, pluginConf, rt)
+		}
+		if err == nil {
+			continue
+		}
+		complete = false
+		if isNotFoundError(err) {
+			logger.Info("Interface already deleted, ignoring the plugin DEL error", "plugin", plugin.Network.Type, "ifName", rt.IfName, "error", err.Error())
+			continue
+		}
+		errs = append(errs, fmt.Errorf("plugin type=%q failed (delete): %w", plugin.Network.Type, err))
+	}
+	if !complete {
+		rntm.restoreCachedResult(ctx, list.Name, rt, cacheEntry)
+	}
+	return complete, errors.Join(errs...)
+}
+
+// listPluginConfig returns the config of a plugin of the list with the name and the version of the list
+// and the previous result injected, as libcni does when it runs the list
+func listPluginConfig(list *libcni.NetworkConfigList, plugin *libcni.PluginConfig, prevResult cnitypes.Result) (*libcni.PluginConfig, error) {
+	inject := map[string]interface{}{
+		"name":       list.Name,
+		"cniVersion": list.CNIVersion,
+	}
+	if prevResult != nil {
+		inject["prevResult"] = prevResult
+	}
+	return libcni.InjectConf(plugin, inject)
+}
+
+// readCachedResult returns the libcni cache entry of the attachment, nil when there is none
+func (rntm *Runtime) readCachedResult(netName string, rt *libcni.RuntimeConf) []byte {
+	entry, err := os.ReadFile(cachedResultPath(rntm.cacheDir(), netName, rt.ContainerID, rt.IfName))
+	if err != nil {
+		return nil
+	}
+	return entry
+}
+
+// restoreCachedResult writes back the libcni cache entry of the attachment when it was removed.
+// A failure is logged, the retried DEL then runs without the previous result.
+func (rntm *Runtime) restoreCachedResult(ctx context.Context, netName string, rt *libcni.RuntimeConf, entry []byte) {
+	if entry == nil {
+		return
+	}
+	path := cachedResultPath(rntm.cacheDir(), netName, rt.ContainerID, rt.IfName)
+	if _, err := os.Stat(path); err == nil {
+		return
+	}
+	if err := os.WriteFile(path, entry, 0600); err != nil {
+		klog.FromContext(ctx).Error(err, "Failed to restore the CNI cache entry", "path", path)
+	}
+}
+
+// cacheDir returns the directory of the libcni cache
+func (rntm *Runtime) cacheDir() string {
+	if rntm.CacheDir == "" {
+		return libcni.CacheDir
+	}
+	return rntm.CacheDir
+}
+
+// removeCachedResult removes the libcni cache entry of the attachment once the DEL of every plugin succeeded.
+// The entry of a DEL that failed or found the interface already deleted is kept with the previous result.
+// A failure is logged and doesn't fail the detach.
+func (rntm *Runtime) removeCachedResult(ctx context.Context, netName string, rt *libcni.RuntimeConf) {
+	path := cachedResultPath(rntm.cacheDir(), netName, rt.ContainerID, rt.IfName)
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		klog.FromContext(ctx).Error(err, "Failed to remove the CNI cache entry", "path", path)
+	}
+}
+
+// cachedResultPath returns the path of the libcni cache entry of an attachment
+func cachedResultPath(cacheDir, netName, containerID, ifName string) string {
+	return filepath.Join(cacheDir, "results", fmt.Sprintf("%s-%s-%s", netName, containerID, ifName))
+}
+
+// notFoundErrors are the messages reported by the CNI plugins when the interface is already gone,
+// e.g. when cleaning up a crashed pod
+var notFoundErrors = []string{
+	"link not found",
+	"no such device",
+	"no such network interface",
+}
+
+// isNotFoundError returns true if the CNI DEL error of a plugin reports an interface that was already deleted.
+// DEL must be idempotent per the CNI spec, so these errors are not failures. Only the message and the
+// details of the error reported by the plugin are matched, not the failures to run it.
+func isNotFoundError(err error) bool {
+	if errors.Is(err, syscall.ENODEV) {
+		return true
+	}
+	var pluginErr *cnitypes.Error
+	if !errors.As(err, &pluginErr) {
+		return false
+	}
+	message := strings.ToLower(pluginErr.Msg + " " + pluginErr.Details)
+	for _, notFoundError := range notFoundErrors {
+		if strings.Contains(message, notFoundError) {
+			return true
+		}
+	}
+	return false
+}
+
+// isConfList returns true if the raw network configuration is a conflist (has a "plugins" list)
+// instead of a single plugin configuration.
+func isConfList(rawNetConf []byte) bool {
+	var rawConfig map[string]json.RawMessage
+	if err := json.Unmarshal(rawNetConf, &rawConfig); err != nil {
+		return false
+	}
+	_, ok := rawConfig["plugins"]
+	return ok
+}
+
+// startSpan starts the span of a CNI operation on the device
+func startSpan(ctx context.Context, name string, deviceConfig *types.PreparedDevice) (context.Context, trace.Span) {
+	return tracing.Start(ctx, name,
+		tracing.AttributeClaimUID.String(string(deviceConfig.ClaimNamespacedName.UID)),
+		tracing.AttributeDevice.String(deviceConfig.Device.DeviceName))
+}
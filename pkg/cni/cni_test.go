@@ -3,12 +3,20 @@ package cni_test
 import (
 	"bytes"
 	"context"
+	"fmt"
+	"net"
 	"os"
+	"path/filepath"
 
 	"github.com/containerd/nri/pkg/api"
+	cnitypes "github.com/containernetworking/cni/pkg/types"
+	cni100 "github.com/containernetworking/cni/pkg/types/100"
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
+	k8sruntime "k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/utils/ptr"
 
+	configapi "github.com/SchSeba/dra-driver-sriov/pkg/api/virtualfunction/v1alpha1"
 	"github.com/SchSeba/dra-driver-sriov/pkg/cni"
 	"github.com/SchSeba/dra-driver-sriov/pkg/types"
 )
@@ -25,7 +33,7 @@ var _ = Describe("CNI", func() {
 		ctx = context.Background()
 
 		// Create runtime
-		runtime = cni.New("test-driver", []string{"/opt/cni/bin"})
+		runtime = cni.New("test-driver", []string{"/opt/cni/bin"}, "")
 
 		pod = &api.PodSandbox{
 			Id:        "test-container-id",
@@ -42,7 +50,7 @@ var _ = Describe("CNI", func() {
 			driverName := "test-driver"
 			cniPath := []string{"/opt/cni/bin"}
 
-			runtime := cni.New(driverName, cniPath)
+			runtime := cni.New(driverName, cniPath, "")
 
 			Expect(runtime).NotTo(BeNil())
 			Expect(runtime.DriverName).To(Equal(driverName))
@@ -50,7 +58,7 @@ var _ = Describe("CNI", func() {
 		})
 
 		It("should handle empty CNI path", func() {
-			runtime := cni.New("test-driver", []string{})
+			runtime := cni.New("test-driver", []string{}, "")
 
 			Expect(runtime).NotTo(BeNil())
 			Expect(runtime.DriverName).To(Equal("test-driver"))
@@ -58,7 +66,7 @@ var _ = Describe("CNI", func() {
 
 		It("should handle multiple CNI paths", func() {
 			paths := []string{"/opt/cni/bin", "/usr/local/bin"}
-			runtime := cni.New("test-driver", paths)
+			runtime := cni.New("test-driver", paths, "")
 
 			Expect(runtime).NotTo(BeNil())
 			Expect(runtime.DriverName).To(Equal("test-driver"))
@@ -72,7 +80,7 @@ var _ = Describe("CNI", func() {
 				NetAttachDefConfig: `invalid json`,
 			}
 
-			_, err := runtime.AttachNetwork(ctx, pod, netNS, invalidConfig)
+			_, _, err := runtime.AttachNetwork(ctx, pod, netNS, invalidConfig)
 
 			Expect(err).To(HaveOccurred())
 			Expect(err.Error()).To(ContainSubstring("failed to GetCNIConfigFromSpec"))
@@ -84,7 +92,7 @@ var _ = Describe("CNI", func() {
 				NetAttachDefConfig: `{}`,
 			}
 
-			_, err := runtime.AttachNetwork(ctx, pod, netNS, emptyConfig)
+			_, _, err := runtime.AttachNetwork(ctx, pod, netNS, emptyConfig)
 
 			Expect(err).To(HaveOccurred())
 		})
@@ -115,6 +123,310 @@ var _ = Describe("CNI", func() {
 		})
 	})
 
+	Context("AttachNetwork runtime arguments", func() {
+		var (
+			fakeCNI *cni.FakeCNI
+			device  *types.PreparedDevice
+		)
+
+		BeforeEach(func() {
+			fakeCNI = &cni.FakeCNI{}
+			runtime.CNIConfig = fakeCNI
+			device = &types.PreparedDevice{
+				IfName:             "net1",
+				NetAttachDefConfig: `{"cniVersion":"1.0.0","name":"test-net","type":"sriov"}`,
+				Config:             &configapi.VfConfig{},
+			}
+		})
+
+		It("should pass the MAC address from the VfConfig", func() {
+			device.Config.MacAddress = "aa:bb:cc:dd:ee:01"
+
+			_, _, err := runtime.AttachNetwork(ctx, pod, netNS, device)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(fakeCNI.AddCalls).To(HaveLen(1))
+			Expect(fakeCNI.AddCalls[0].Args).To(ContainElement([2]string{"MAC", "aa:bb:cc:dd:ee:01"}))
+		})
+
+		It("should not pass MAC or VLAN when they are not set", func() {
+			_, _, err := runtime.AttachNetwork(ctx, pod, netNS, device)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(fakeCNI.AddCalls).To(HaveLen(1))
+			for _, arg := range fakeCNI.AddCalls[0].Args {
+				Expect(arg[0]).NotTo(BeElementOf("MAC", "VLAN"))
+			}
+			Expect(fakeCNI.AddCalls[0].CapabilityArgs).To(BeNil())
+		})
+
+		It("should pass the capability args from the VfConfig on attach and detach", func() {
+			device.Config.CapabilityArgs = map[string]k8sruntime.RawExtension{
+				"bandwidth": {Raw: []byte(`{"ingressRate":1000000,"ingressBurst":2000000}`)},
+			}
+
+			_, _, err := runtime.AttachNetwork(ctx, pod, netNS, device)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(fakeCNI.AddCalls).To(HaveLen(1))
+			Expect(fakeCNI.AddCalls[0].CapabilityArgs).To(HaveKeyWithValue("bandwidth", map[string]interface{}{
+				"ingressRate":  float64(1000000),
+				"ingressBurst": float64(2000000),
+			}))
+
+			Expect(runtime.DetachNetwork(ctx, pod, netNS, device)).To(Succeed())
+			Expect(fakeCNI.DelCalls).To(HaveLen(1))
+			Expect(fakeCNI.DelCalls[0].CapabilityArgs).To(HaveKey("bandwidth"))
+		})
+
+		It("should pass the default route capability when the VfConfig requests it", func() {
+			device.NetAttachDefConfig = `{"cniVersion":"1.0.0","name":"test-net","plugins":[{"type":"sriov"},{"type":"route-override","capabilities":{"default-route":true}}]}`
+			device.Config.MakeDefaultRoute = true
+			device.Config.CapabilityArgs = map[string]k8sruntime.RawExtension{
+				"bandwidth": {Raw: []byte(`{"ingressRate":1000000}`)},
+			}
+
+			_, _, err := runtime.AttachNetwork(ctx, pod, netNS, device)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(fakeCNI.AddCalls).To(HaveLen(1))
+			Expect(fakeCNI.AddCalls[0].CapabilityArgs).To(HaveKeyWithValue(cni.DefaultRouteCapability, true))
+			Expect(fakeCNI.AddCalls[0].CapabilityArgs).To(HaveKey("bandwidth"))
+		})
+
+		It("should fail the default route on a network without the default-route capability", func() {
+			device.Config.MakeDefaultRoute = true
+
+			_, _, err := runtime.AttachNetwork(ctx, pod, netNS, device)
+			Expect(err).To(MatchError("network test-net doesn't declare the default-route capability, the default route can't be applied"))
+			Expect(fakeCNI.AddCalls).To(BeEmpty())
+		})
+
+		It("should pass the static IPs as the ips capability and report the IPs of the result", func() {
+			device.NetAttachDefConfig = `{"cniVersion":"1.0.0","name":"test-net","type":"sriov","capabilities":{"ips":true}}`
+			device.Config.StaticIPs = []string{"192.168.1.10/24", "fd00::10/64"}
+			fakeCNI.AddResult = &cni100.Result{
+				CNIVersion: "1.0.0",
+				IPs:        []*cni100.IPConfig{{Address: net.IPNet{IP: net.ParseIP("192.168.1.10"), Mask: net.CIDRMask(24, 32)}}},
+			}
+
+			networkData, _, err := runtime.AttachNetwork(ctx, pod, netNS, device)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(fakeCNI.AddCalls).To(HaveLen(1))
+			Expect(fakeCNI.AddCalls[0].CapabilityArgs).To(HaveKeyWithValue(cni.IPsCapability, []string{"192.168.1.10/24", "fd00::10/64"}))
+			Expect(networkData.IPs).To(Equal([]string{"192.168.1.10/24"}))
+		})
+
+		It("should not report the static IPs missing from the result", func() {
+			device.NetAttachDefConfig = `{"cniVersion":"1.0.0","name":"test-net","plugins":[{"type":"sriov","capabilities":{"ips":true}},{"type":"tuning"}]}`
+			device.Config.StaticIPs = []string{"192.168.1.10/24"}
+
+			networkData, _, err := runtime.AttachNetwork(ctx, pod, netNS, device)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(networkData.IPs).To(BeEmpty())
+		})
+
+		It("should fail the attach of static IPs on a network without the ips capability", func() {
+			device.Config.StaticIPs = []string{"192.168.1.10/24"}
+
+			_, _, err := runtime.AttachNetwork(ctx, pod, netNS, device)
+			Expect(err).To(MatchError(ContainSubstring("network test-net doesn't declare the ips capability")))
+
+			device.NetAttachDefConfig = `{"cniVersion":"1.0.0","name":"test-chain","plugins":[{"type":"sriov"},{"type":"tuning"}]}`
+			_, _, err = runtime.AttachNetwork(ctx, pod, netNS, device)
+			Expect(err).To(MatchError(ContainSubstring("network test-chain has no plugin declaring the ips capability")))
+			Expect(fakeCNI.AddCalls).To(BeEmpty())
+		})
+
+		It("should fail when a capability arg is not valid JSON", func() {
+			device.Config.CapabilityArgs = map[string]k8sruntime.RawExtension{
+				"bandwidth": {Raw: []byte(`{"ingressRate":`)},
+			}
+
+			_, _, err := runtime.AttachNetwork(ctx, pod, netNS, device)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring(`invalid capability arg "bandwidth"`))
+			Expect(fakeCNI.AddCalls).To(BeEmpty())
+		})
+	})
+
+	Context("Network configuration lists", func() {
+		var (
+			fakeCNI *cni.FakeCNI
+			device  *types.PreparedDevice
+		)
+
+		BeforeEach(func() {
+			fakeCNI = &cni.FakeCNI{}
+			runtime.CNIConfig = fakeCNI
+			device = &types.PreparedDevice{
+				IfName: "net1",
+				NetAttachDefConfig: `{
+					"cniVersion": "1.0.0",
+					"name": "test-chain",
+					"plugins": [
+						{"type": "sriov", "deviceID": "0000:01:00.1"},
+						{"type": "tuning", "sysctl": {"net.ipv4.conf.net1.arp_notify": "1"}}
+					]
+				}`,
+			}
+		})
+
+		It("should invoke the whole plugin chain on attach", func() {
+			_, _, err := runtime.AttachNetwork(ctx, pod, netNS, device)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(fakeCNI.AddCalls).To(HaveLen(1))
+			Expect(fakeCNI.AddPluginTypes).To(Equal([]string{"sriov", "tuning"}))
+		})
+
+		It("should invoke the whole plugin chain in reverse order on detach", func() {
+			Expect(runtime.DetachNetwork(ctx, pod, netNS, device)).To(Succeed())
+			Expect(fakeCNI.DelCalls).To(HaveLen(2))
+			Expect(fakeCNI.DelPluginTypes).To(Equal([]string{"tuning", "sriov"}))
+		})
+
+		It("should pass the cached result and the list name to every plugin on detach", func() {
+			fakeCNI.CachedResult = &cni100.Result{
+				CNIVersion: "1.0.0",
+				IPs:        []*cni100.IPConfig{{Address: net.IPNet{IP: net.ParseIP("192.168.1.10"), Mask: net.CIDRMask(24, 32)}}},
+			}
+
+			Expect(runtime.DetachNetwork(ctx, pod, netNS, device)).To(Succeed())
+			Expect(fakeCNI.DelConfigs).To(HaveLen(2))
+			for _, config := range fakeCNI.DelConfigs {
+				Expect(string(config)).To(ContainSubstring(`"name":"test-chain"`))
+				Expect(string(config)).To(ContainSubstring(`"prevResult"`))
+				Expect(string(config)).To(ContainSubstring("192.168.1.10/24"))
+			}
+		})
+
+		It("should run the DEL of the remaining plugins after a plugin failed", func() {
+			fakeCNI.DelErrByPluginType = map[string]error{
+				"tuning": &cnitypes.Error{Code: 999, Msg: "failed to restore sysctl", Details: "permission denied"},
+			}
+
+			err := runtime.DetachNetwork(ctx, pod, netNS, device)
+			Expect(err).To(MatchError(ContainSubstring(`plugin type="tuning" failed (delete)`)))
+			Expect(fakeCNI.DelPluginTypes).To(Equal([]string{"tuning", "sriov"}))
+		})
+
+		It("should keep using the single plugin path for a plain configuration", func() {
+			device.NetAttachDefConfig = `{"cniVersion":"1.0.0","name":"test-net","type":"sriov"}`
+
+			_, _, err := runtime.AttachNetwork(ctx, pod, netNS, device)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(runtime.DetachNetwork(ctx, pod, netNS, device)).To(Succeed())
+			Expect(fakeCNI.AddPluginTypes).To(Equal([]string{"sriov"}))
+			Expect(fakeCNI.DelPluginTypes).To(Equal([]string{"sriov"}))
+		})
+
+		It("should treat an already deleted interface as detached", func() {
+			fakeCNI.DelErrByPluginType = map[string]error{
+				"tuning": &cnitypes.Error{Code: 999, Msg: "failed to find netdev", Details: "Link not found"},
+			}
+
+			Expect(runtime.DetachNetwork(ctx, pod, netNS, device)).To(Succeed())
+			// the plugin releasing the IP still runs
+			Expect(fakeCNI.DelPluginTypes).To(Equal([]string{"tuning", "sriov"}))
+
+			device.NetAttachDefConfig = `{"cniVersion":"1.0.0","name":"test-net","type":"sriov"}`
+			fakeCNI.DelErr = &cnitypes.Error{Code: 999, Msg: "failed to get VF netdev: no such device"}
+			Expect(runtime.DetachNetwork(ctx, pod, netNS, device)).To(Succeed())
+		})
+
+		It("should not match the not found messages outside the plugin errors", func() {
+			device.NetAttachDefConfig = `{"cniVersion":"1.0.0","name":"test-net","type":"sriov"}`
+			fakeCNI.DelErr = fmt.Errorf("failed to find plugin \"sriov\": no such device")
+
+			Expect(runtime.DetachNetwork(ctx, pod, netNS, device)).NotTo(Succeed())
+		})
+
+		It("should return the genuine DEL failures", func() {
+			fakeCNI.DelErr = fmt.Errorf("failed to restore VF: permission denied")
+
+			err := runtime.DetachNetwork(ctx, pod, netNS, device)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("failed to DelNetworkList"))
+		})
+
+		It("should return error for an invalid plugin list", func() {
+			device.NetAttachDefConfig = `{"cniVersion":"1.0.0","name":"test-chain","plugins":[]}`
+
+			_, _, err := runtime.AttachNetwork(ctx, pod, netNS, device)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("failed to NetworkConfFromBytes"))
+		})
+	})
+
+	Context("Cache directory", func() {
+		var (
+			fakeCNI   *cni.FakeCNI
+			device    *types.PreparedDevice
+			cacheDir  string
+			cachePath string
+		)
+
+		BeforeEach(func() {
+			var err error
+			cacheDir, err = os.MkdirTemp("", "cni-cache-*")
+			Expect(err).NotTo(HaveOccurred())
+			DeferCleanup(os.RemoveAll, cacheDir)
+
+			runtime = cni.New("test-driver", []string{"/opt/cni/bin"}, cacheDir)
+			fakeCNI = &cni.FakeCNI{}
+			runtime.CNIConfig = fakeCNI
+			device = &types.PreparedDevice{
+				IfName:             "net1",
+				NetAttachDefConfig: `{"cniVersion":"1.0.0","name":"test-net","type":"sriov"}`,
+			}
+
+			// the entry libcni writes on ADD
+			cachePath = filepath.Join(cacheDir, "results", "test-net-test-container-id-net1")
+			Expect(os.MkdirAll(filepath.Dir(cachePath), 0700)).To(Succeed())
+			Expect(os.WriteFile(cachePath, []byte(`{"kind":"cniCacheV1"}`), 0600)).To(Succeed())
+		})
+
+		It("should keep the libcni cache in the given directory", func() {
+			Expect(cni.New("test-driver", []string{"/opt/cni/bin"}, cacheDir).CacheDir).To(Equal(cacheDir))
+		})
+
+		It("should remove the cache entry after detach", func() {
+			Expect(runtime.DetachNetwork(ctx, pod, netNS, device)).To(Succeed())
+			Expect(fakeCNI.DelCalls).To(HaveLen(1))
+			Expect(cachePath).NotTo(BeAnExistingFile())
+		})
+
+		It("should keep the cache entry of an interface already deleted", func() {
+			fakeCNI.DelErr = &cnitypes.Error{Code: 999, Msg: "failed to get VF netdev: no such device"}
+
+			Expect(runtime.DetachNetwork(ctx, pod, netNS, device)).To(Succeed())
+			Expect(cachePath).To(BeAnExistingFile())
+		})
+
+		It("should only remove the cache entry of a list once the DEL of every plugin succeeded", func() {
+			device.NetAttachDefConfig = `{"cniVersion":"1.0.0","name":"test-net","plugins":[{"type":"sriov"},{"type":"tuning"}]}`
+			fakeCNI.DelErrByPluginType = map[string]error{
+				"tuning": &cnitypes.Error{Code: 999, Msg: "failed to find netdev", Details: "Link not found"},
+			}
+
+			Expect(runtime.DetachNetwork(ctx, pod, netNS, device)).To(Succeed())
+			Expect(cachePath).To(BeAnExistingFile())
+
+			fakeCNI.DelErrByPluginType = nil
+			Expect(runtime.DetachNetwork(ctx, pod, netNS, device)).To(Succeed())
+			Expect(cachePath).NotTo(BeAnExistingFile())
+		})
+
+		It("should keep the cache entry when the detach fails", func() {
+			fakeCNI.DelErr = fmt.Errorf("failed to restore VF: permission denied")
+
+			Expect(runtime.DetachNetwork(ctx, pod, netNS, device)).NotTo(Succeed())
+			Expect(cachePath).To(BeAnExistingFile())
+		})
+
+		It("should not fail the detach when there is no cache entry", func() {
+			Expect(os.Remove(cachePath)).To(Succeed())
+
+			Expect(runtime.DetachNetwork(ctx, pod, netNS, device)).To(Succeed())
+		})
+	})
+
 	Context("RawExec", func() {
 		var rawExec *cni.RawExec
 
@@ -174,6 +486,155 @@ var _ = Describe("CNI", func() {
 	})
 
 	// Note: cniResultToNetworkData function is internal and tested indirectly through AttachNetwork
+	Context("AttachNetwork result conversion", func() {
+		var (
+			fakeCNI *cni.FakeCNI
+			device  *types.PreparedDevice
+		)
+
+		BeforeEach(func() {
+			fakeCNI = &cni.FakeCNI{}
+			runtime.CNIConfig = fakeCNI
+			device = &types.PreparedDevice{
+				IfName:             "net1",
+				NetAttachDefConfig: `{"cniVersion":"1.0.0","name":"test-net","type":"sriov"}`,
+			}
+		})
+
+		It("should keep both IPv4 and IPv6 addresses for dual-stack results", func() {
+			_, ipv4, err := net.ParseCIDR("192.168.1.10/24")
+			Expect(err).NotTo(HaveOccurred())
+			ipv4.IP = net.ParseIP("192.168.1.10")
+			_, ipv6, err := net.ParseCIDR("fd00::10/64")
+			Expect(err).NotTo(HaveOccurred())
+			ipv6.IP = net.ParseIP("fd00::10")
+
+			fakeCNI.AddResult = &cni100.Result{
+				CNIVersion: "1.0.0",
+				Interfaces: []*cni100.Interface{
+					{Name: "net1", Mac: "aa:bb:cc:dd:ee:01", Sandbox: netNS},
+				},
+				IPs: []*cni100.IPConfig{
+					{Interface: ptr.To(0), Address: *ipv4},
+					{Interface: ptr.To(0), Address: *ipv6},
+				},
+			}
+
+			networkData, networkStatus, err := runtime.AttachNetwork(ctx, pod, netNS, device)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(networkData.IPs).To(Equal([]string{"192.168.1.10/24", "fd00::10/64"}))
+			Expect(networkData.InterfaceName).To(Equal("net1"))
+			Expect(networkData.HardwareAddress).To(Equal("aa:bb:cc:dd:ee:01"))
+			// the result has neither routes nor DNS
+			Expect(networkStatus).To(BeNil())
+		})
+
+		It("should return the routes and DNS of the result", func() {
+			_, defaultRoute, err := net.ParseCIDR("0.0.0.0/0")
+			Expect(err).NotTo(HaveOccurred())
+			_, subnetRoute, err := net.ParseCIDR("10.10.0.0/16")
+			Expect(err).NotTo(HaveOccurred())
+
+			fakeCNI.AddResult = &cni100.Result{
+				CNIVersion: "1.0.0",
+				Interfaces: []*cni100.Interface{
+					{Name: "net1", Mac: "aa:bb:cc:dd:ee:01", Sandbox: netNS},
+				},
+				Routes: []*cnitypes.Route{
+					{Dst: *defaultRoute, GW: net.ParseIP("192.168.1.1")},
+					{Dst: *subnetRoute},
+				},
+				DNS: cnitypes.DNS{
+					Nameservers: []string{"192.168.1.53"},
+					Domain:      "example.com",
+					Search:      []string{"example.com"},
+				},
+			}
+
+			_, networkStatus, err := runtime.AttachNetwork(ctx, pod, netNS, device)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(networkStatus).To(Equal(&types.NetworkStatus{
+				Routes: []types.NetworkRoute{
+					{Dst: "0.0.0.0/0", GW: "192.168.1.1"},
+					{Dst: "10.10.0.0/16"},
+				},
+				DNS: &types.NetworkDNS{
+					Nameservers: []string{"192.168.1.53"},
+					Domain:      "example.com",
+					Search:      []string{"example.com"},
+				},
+			}))
+		})
+
+		It("should use the pod interface referenced by the IPs", func() {
+			_, ipv4, err := net.ParseCIDR("10.0.0.5/24")
+			Expect(err).NotTo(HaveOccurred())
+			ipv4.IP = net.ParseIP("10.0.0.5")
+
+			fakeCNI.AddResult = &cni100.Result{
+				CNIVersion: "1.0.0",
+				Interfaces: []*cni100.Interface{
+					{Name: "host-side", Mac: "aa:bb:cc:dd:ee:00"},
+					{Name: "tmp0", Mac: "aa:bb:cc:dd:ee:02", Sandbox: netNS},
+					{Name: "net1", Mac: "aa:bb:cc:dd:ee:01", Sandbox: netNS},
+				},
+				IPs: []*cni100.IPConfig{
+					{Interface: ptr.To(2), Address: *ipv4},
+				},
+			}
+
+			networkData, _, err := runtime.AttachNetwork(ctx, pod, netNS, device)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(networkData.IPs).To(Equal([]string{"10.0.0.5/24"}))
+			Expect(networkData.InterfaceName).To(Equal("net1"))
+			Expect(networkData.HardwareAddress).To(Equal("aa:bb:cc:dd:ee:01"))
+		})
+
+		It("should record the pod interface named after the requested ifName of a multi-interface result", func() {
+			macvlanIP := net.IPNet{IP: net.ParseIP("10.0.0.5"), Mask: net.CIDRMask(24, 32)}
+			vfIP := net.IPNet{IP: net.ParseIP("10.1.0.5"), Mask: net.CIDRMask(24, 32)}
+
+			// a macvlan on top of the VF, the first interface and the first address are the macvlan ones
+			fakeCNI.AddResult = &cni100.Result{
+				CNIVersion: "1.0.0",
+				Interfaces: []*cni100.Interface{
+					{Name: "macvlan0", Mac: "aa:bb:cc:dd:ee:02", Sandbox: netNS},
+					{Name: "net1", Mac: "aa:bb:cc:dd:ee:01", Sandbox: netNS},
+				},
+				IPs: []*cni100.IPConfig{
+					{Interface: ptr.To(0), Address: macvlanIP},
+					{Interface: ptr.To(1), Address: vfIP},
+				},
+			}
+
+			networkData, _, err := runtime.AttachNetwork(ctx, pod, netNS, device)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(networkData.InterfaceName).To(Equal("net1"))
+			Expect(networkData.HardwareAddress).To(Equal("aa:bb:cc:dd:ee:01"))
+			Expect(networkData.IPs).To(Equal([]string{"10.1.0.5/24"}))
+		})
+
+		It("should fall back to the interface referenced by the IPs when no interface has the requested ifName", func() {
+			device.IfName = "net2"
+			vfIP := net.IPNet{IP: net.ParseIP("10.1.0.5"), Mask: net.CIDRMask(24, 32)}
+
+			fakeCNI.AddResult = &cni100.Result{
+				CNIVersion: "1.0.0",
+				Interfaces: []*cni100.Interface{
+					{Name: "macvlan0", Mac: "aa:bb:cc:dd:ee:02", Sandbox: netNS},
+					{Name: "net1", Mac: "aa:bb:cc:dd:ee:01", Sandbox: netNS},
+				},
+				IPs: []*cni100.IPConfig{
+					{Interface: ptr.To(1), Address: vfIP},
+				},
+			}
+
+			networkData, _, err := runtime.AttachNetwork(ctx, pod, netNS, device)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(networkData.InterfaceName).To(Equal("net1"))
+			Expect(networkData.IPs).To(Equal([]string{"10.1.0.5/24"}))
+		})
+	})
 
 	Context("Integration scenarios", func() {
 		It("should handle multiple device configurations", func() {
@@ -184,7 +645,7 @@ var _ = Describe("CNI", func() {
 			}
 
 			for _, device := range devices {
-				_, err := runtime.AttachNetwork(ctx, pod, netNS, device)
+				_, _, err := runtime.AttachNetwork(ctx, pod, netNS, device)
 				Expect(err).To(HaveOccurred()) // Expected to fail due to invalid config
 			}
 		})
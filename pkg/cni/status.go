@@ -22,27 +22,103 @@ import (
 	cnitypes "github.com/containernetworking/cni/pkg/types"
 	cni100 "github.com/containernetworking/cni/pkg/types/100"
 	resourcev1 "k8s.io/api/resource/v1"
+
+	"github.com/SchSeba/dra-driver-sriov/pkg/types"
 )
 
-func cniResultToNetworkData(result cnitypes.Result) (*resourcev1.NetworkDeviceData, error) {
+// cniResultToNetworkData converts the CNI result to the device network data and the network status holding
+// the routes and DNS of the result, the network status is nil when the result has neither.
+// A chain can return several pod interfaces (e.g. a macvlan on top of the VF), the one named ifName is reported
+// with its own IPs. Without it, the interface referenced by the IPs or the first pod interface is reported.
+func cniResultToNetworkData(result cnitypes.Result, ifName string) (*resourcev1.NetworkDeviceData, *types.NetworkStatus, error) {
 	networkData := &resourcev1.NetworkDeviceData{}
 
 	cniResult, err := cni100.NewResultFromResult(result)
 	if err != nil {
-		return nil, fmt.Errorf("failed to NewResultFromResult result (%v): %v", result, err)
+		return nil, nil, fmt.Errorf("failed to NewResultFromResult result (%v): %v", result, err)
+	}
+
+	if ifNameIdx := findSandboxInterface(cniResult, ifName); ifNameIdx != -1 {
+		for _, ip := range cniResult.IPs {
+			// the addresses not referencing an interface can't be told apart, they are kept
+			if ip.Interface == nil || *ip.Interface == ifNameIdx {
+				networkData.IPs = append(networkData.IPs, ip.Address.String())
+			}
+		}
+		networkData.InterfaceName = cniResult.Interfaces[ifNameIdx].Name
+		networkData.HardwareAddress = cniResult.Interfaces[ifNameIdx].Mac
+		return networkData, cniResultToNetworkStatus(cniResult), nil
 	}
 
+	// Keep every address (IPv4 and IPv6) in the order returned by the plugin
+	podInterfaceIdx := -1
 	for _, ip := range cniResult.IPs {
 		networkData.IPs = append(networkData.IPs, ip.Address.String())
+		if podInterfaceIdx == -1 && ip.Interface != nil && isSandboxInterface(cniResult, *ip.Interface) {
+			podInterfaceIdx = *ip.Interface
+		}
+	}
+
+	// Fallback to the first interface with sandbox information if the IPs don't reference one
+	if podInterfaceIdx == -1 {
+		for idx := range cniResult.Interfaces {
+			if isSandboxInterface(cniResult, idx) {
+				podInterfaceIdx = idx
+				break
+			}
+		}
+	}
+
+	if podInterfaceIdx != -1 {
+		networkData.InterfaceName = cniResult.Interfaces[podInterfaceIdx].Name
+		networkData.HardwareAddress = cniResult.Interfaces[podInterfaceIdx].Mac
+	}
+
+	return networkData, cniResultToNetworkStatus(cniResult), nil
+}
+
+// cniResultToNetworkStatus returns the routes and DNS of the CNI result, nil when it has neither
+func cniResultToNetworkStatus(result *cni100.Result) *types.NetworkStatus {
+	status := &types.NetworkStatus{}
+	for _, route := range result.Routes {
+		if route == nil {
+			continue
+		}
+		networkRoute := types.NetworkRoute{Dst: route.Dst.String()}
+		if route.GW != nil {
+			networkRoute.GW = route.GW.String()
+		}
+		status.Routes = append(status.Routes, networkRoute)
 	}
+	if !result.DNS.IsEmpty() {
+		status.DNS = &types.NetworkDNS{
+			Nameservers: result.DNS.Nameservers,
+			Domain:      result.DNS.Domain,
+			Search:      result.DNS.Search,
+			Options:     result.DNS.Options,
+		}
+	}
+	if len(status.Routes) == 0 && status.DNS == nil {
+		return nil
+	}
+	return status
+}
 
-	for _, ifs := range cniResult.Interfaces {
-		// Only pod interfaces can have sandbox information
-		if ifs.Sandbox != "" {
-			networkData.InterfaceName = ifs.Name
-			networkData.HardwareAddress = ifs.Mac
+// findSandboxInterface returns the index of the pod interface named ifName in the result, or -1
+func findSandboxInterface(result *cni100.Result, ifName string) int {
+	if ifName == "" {
+		return -1
+	}
+	for idx, iface := range result.Interfaces {
+		if iface != nil && iface.Name == ifName && isSandboxInterface(result, idx) {
+			return idx
 		}
 	}
+	return -1
+}
 
-	return networkData, nil
+// isSandboxInterface returns true if idx references an interface inside the pod sandbox.
+// Only pod interfaces can have sandbox information.
+func isSandboxInterface(result *cni100.Result, idx int) bool {
+	return idx >= 0 && idx < len(result.Interfaces) && result.Interfaces[idx].Sandbox != ""
 }
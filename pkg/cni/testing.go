@@ -0,0 +1,101 @@
+package cni
+
+import (
+	"context"
+	"sync"
+
+	"github.com/containernetworking/cni/libcni"
+	cnitypes "github.com/containernetworking/cni/pkg/types"
+	cni100 "github.com/containernetworking/cni/pkg/types/100"
+)
+
+// FakeCNI is a libcni.CNI implementation that records the ADD/DEL calls it receives instead of
+// executing plugins. It is meant to be injected into Runtime.CNIConfig in tests.
+type FakeCNI struct {
+	libcni.CNI
+
+	mu       sync.Mutex
+	AddCalls []*libcni.RuntimeConf
+	DelCalls []*libcni.RuntimeConf
+	// AddPluginTypes and DelPluginTypes record the type of every plugin invoked, in order
+	AddPluginTypes []string
+	DelPluginTypes []string
+	// DelConfigs records the config of every plugin invoked by DelNetwork, in order
+	DelConfigs [][]byte
+
+	AddResult cnitypes.Result
+	AddErr    error
+	DelErr    error
+	// DelErrByIfName fails the DEL of the given interfaces, it takes precedence over DelErr
+	DelErrByIfName map[string]error
+	// DelErrByPluginType fails the DEL of the given plugins invoked by DelNetwork, it takes precedence over DelErrByIfName
+	DelErrByPluginType map[string]error
+	// CachedResult is the cached result of the network lists
+	CachedResult cnitypes.Result
+}
+
+// AddNetwork records the call and returns the configured result
+func (f *FakeCNI) AddNetwork(_ context.Context, net *libcni.PluginConfig, rt *libcni.RuntimeConf) (cnitypes.Result, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.AddCalls = append(f.AddCalls, rt)
+	f.AddPluginTypes = append(f.AddPluginTypes, net.Network.Type)
+	return f.addResult()
+}
+
+// AddNetworkList records the call and the whole plugin chain and returns the configured result
+func (f *FakeCNI) AddNetworkList(_ context.Context, list *libcni.NetworkConfigList, rt *libcni.RuntimeConf) (cnitypes.Result, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.AddCalls = append(f.AddCalls, rt)
+	for _, plugin := range list.Plugins {
+		f.AddPluginTypes = append(f.AddPluginTypes, plugin.Network.Type)
+	}
+	return f.addResult()
+}
+
+func (f *FakeCNI) addResult() (cnitypes.Result, error) {
+	if f.AddErr != nil {
+		return nil, f.AddErr
+	}
+	if f.AddResult != nil {
+		return f.AddResult, nil
+	}
+	return &cni100.Result{CNIVersion: cni100.ImplementedSpecVersion}, nil
+}
+
+// DelNetwork records the call and returns the configured error
+func (f *FakeCNI) DelNetwork(_ context.Context, net *libcni.PluginConfig, rt *libcni.RuntimeConf) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.DelCalls = append(f.DelCalls, rt)
+	f.DelPluginTypes = append(f.DelPluginTypes, net.Network.Type)
+	f.DelConfigs = append(f.DelConfigs, net.Bytes)
+	if err, found := f.DelErrByPluginType[net.Network.Type]; found {
+		return err
+	}
+	return f.delErr(rt)
+}
+
+// DelNetworkList records the call and the whole plugin chain and returns the configured error
+func (f *FakeCNI) DelNetworkList(_ context.Context, list *libcni.NetworkConfigList, rt *libcni.RuntimeConf) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.DelCalls = append(f.DelCalls, rt)
+	for _, plugin := range list.Plugins {
+		f.DelPluginTypes = append(f.DelPluginTypes, plugin.Network.Type)
+	}
+	return f.delErr(rt)
+}
+
+// GetNetworkListCachedResult returns the configured cached result
+func (f *FakeCNI) GetNetworkListCachedResult(_ *libcni.NetworkConfigList, _ *libcni.RuntimeConf) (cnitypes.Result, error) {
+	return f.CachedResult, nil
+}
+
+func (f *FakeCNI) delErr(rt *libcni.RuntimeConf) error {
+	if err, found := f.DelErrByIfName[rt.IfName]; found {
+		return err
+	}
+	return f.DelErr
+}
@@ -26,6 +26,7 @@ const (
 	GroupName                  = "sriovnetwork.openshift.io"
 	DriverName                 = "sriovnetwork.openshift.io"
 	DriverPluginCheckpointFile = "checkpoint.json"
+	DriverPluginLockFile       = "driver.lock"
 
 	StandardAttributePrefix = "resource.kubernetes.io"
 
@@ -35,14 +36,79 @@ const (
 	AttributeVendorID         = DriverName + "/vendor"
 	AttributeDeviceID         = DriverName + "/deviceID"
 	AttributePFDeviceID       = DriverName + "/pfDeviceID"
+	AttributePFMac            = DriverName + "/pfMac"
+	AttributePFIndex          = DriverName + "/pfIndex"
 	AttributeVFID             = DriverName + "/vfID"
 	AttributeResourceName     = DriverName + "/resourceName"
+	AttributeLinkUp           = DriverName + "/linkUp"
+	AttributeRepresentor      = DriverName + "/representor"
+	AttributeVFTotalMsix      = DriverName + "/vfTotalMsix"
+	AttributeBondName         = DriverName + "/bondName"
+	AttributePFUtilization    = DriverName + "/pfUtilization"
+	AttributePciRoot          = DriverName + "/pciRoot"
 	AttributeNumaNode         = StandardAttributePrefix + "/numaNode"
 	AttributeParentPciAddress = StandardAttributePrefix + "/pcieRoot"
 
+	// VF group attributes, a VF group device allocates all the VFs of a PF together
+	AttributeVFGroup     = DriverName + "/vfGroup"
+	AttributeVFGroupSize = DriverName + "/vfGroupSize"
+
+	// Vendor specific capability attributes
+	AttributeSupportsSwitchdevOffload = DriverName + "/supportsSwitchdevOffload"
+	AttributeSupportsRateLimiting     = DriverName + "/supportsRateLimiting"
+	AttributeSupportsVlan             = DriverName + "/supportsVlan"
+
+	// ExtraAttributeDomain is the domain of the device attributes given by the operator
+	ExtraAttributeDomain = "extra." + DriverName
+
+	// TaintKeyLinkDown taints the VFs of a PF whose link is down so the scheduler avoids them
+	TaintKeyLinkDown = DriverName + "/linkDown"
+
+	// PF eswitch modes
+	EswitchModeLegacy    = "legacy"
+	EswitchModeSwitchdev = "switchdev"
+
+	// Device naming schemes
+	DeviceNamingPCI     = "pci"
+	DeviceNamingPFIndex = "pfindex"
+
+	// Network namespace resolution strategies
+	NetnsResolutionAuto   = "auto"
+	NetnsResolutionNRI    = "nri"
+	NetnsResolutionProcfs = "procfs"
+
+	// What to do with a checkpoint failing the checksum verification on startup
+	CheckpointCorruptionPolicyFail       = "fail"
+	CheckpointCorruptionPolicyQuarantine = "quarantine"
+
+	// Device status condition reporting if the pod network was attached
+	ConditionTypeNetworkAttached = "NetworkAttached"
+	ReasonHostNetwork            = "HostNetwork"
+
+	// Device status condition reporting if the devices of the claim were prepared on the node
+	ConditionTypePrepared = "Prepared"
+	ReasonPrepared        = "Prepared"
+	ReasonPrepareFailed   = "PrepareFailed"
+
+	// Node condition reporting the driver health, with the reasons of its status
+	NodeConditionDriverReady  = "SriovDraDriverReady"
+	ReasonDriverReady         = "DriverReady"
+	ReasonDiscoveryFailed     = "DiscoveryFailed"
+	ReasonNoDevicesDiscovered = "NoDevicesDiscovered"
+	ReasonDriverStopped       = "DriverStopped"
+
 	// Network device constants
 	NetClass  = 0x02 // Network controller class
 	SysBusPci = "/sys/bus/pci/devices"
+	// NoNumaAffinity is the numa_node of a PCI device without NUMA affinity
+	NoNumaAffinity = "-1"
+
+	// CNIBinDir is the directory holding the CNI plugin binaries
+	CNIBinDir = "/opt/cni/bin"
+	// CNICacheDirName is the directory under the driver plugin path holding the libcni cache when it is isolated
+	CNICacheDirName = "cni-cache"
+	// PCIAddressFilesDirName is the directory under the driver plugin path holding the PCI address files mounted in the containers
+	PCIAddressFilesDirName = "pci-address-files"
 )
 
 var Backoff = wait.Backoff{
@@ -0,0 +1,156 @@
+// Package debug serves a read-only HTTP endpoint exposing the driver state as JSON for field debugging.
+package debug
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	k8stypes "k8s.io/apimachinery/pkg/types"
+	"k8s.io/klog/v2"
+
+	"github.com/SchSeba/dra-driver-sriov/pkg/devicestate"
+	"github.com/SchSeba/dra-driver-sriov/pkg/podmanager"
+	"github.com/SchSeba/dra-driver-sriov/pkg/types"
+)
+
+const (
+	// StatePath is the endpoint returning the driver State
+	StatePath = "/debug/state"
+
+	shutdownTimeout = 5 * time.Second
+)
+
+// State is the driver state returned by the debug endpoint
+type State struct {
+	AllocatableDevices types.AllocatableDevices              `json:"allocatableDevices"`
+	PreparedDevices    map[k8stypes.UID][]PreparedDeviceInfo `json:"preparedDevices"`
+	PodsByClaim        map[k8stypes.UID][]k8stypes.UID       `json:"podsByClaim"`
+}
+
+// PreparedDeviceInfo is the debug view of a prepared device.
+// The NetworkAttachmentDefinition config and the CDI edits are left out on purpose.
+// The IPs are the ones of the network data of the attached device, empty until the device is attached.
+type PreparedDeviceInfo struct {
+	DeviceName     string       `json:"deviceName"`
+	PoolName       string       `json:"poolName"`
+	ClaimName      string       `json:"claimName"`
+	ClaimNamespace string       `json:"claimNamespace"`
+	ClaimUID       k8stypes.UID `json:"claimUID"`
+	PciAddress     string       `json:"pciAddress"`
+	IfName         string       `json:"ifName"`
+	IPs            []string     `json:"ips,omitempty"`
+	OriginalDriver string       `json:"originalDriver,omitempty"`
+}
+
+// Server is the debug HTTP server
+type Server struct {
+	server *http.Server
+	wg     sync.WaitGroup
+}
+
+// Start serves the debug endpoint on localhost at the configured port, a negative port disables it
+func Start(ctx context.Context, config *types.Config, deviceStateManager *devicestate.Manager, podManager *podmanager.PodManager) (*Server, error) {
+	logger := klog.FromContext(ctx).WithName("debug")
+
+	port := config.Flags.DebugHTTPPort
+	if port < 0 {
+		return nil, nil
+	}
+
+	addr := net.JoinHostPort("localhost", strconv.Itoa(port))
+	lis, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen for debug endpoint at %s: %w", addr, err)
+	}
+
+	s := &Server{
+		server: &http.Server{
+			Handler:           NewHandler(deviceStateManager, podManager),
+			ReadHeaderTimeout: shutdownTimeout,
+		},
+	}
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		logger.Info("starting debug endpoint", "address", lis.Addr().String())
+		if err := s.server.Serve(lis); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logger.Error(err, "failed to serve debug endpoint")
+		}
+	}()
+
+	return s, nil
+}
+
+// Stop shuts down the debug HTTP server
+func (s *Server) Stop(logger klog.Logger) {
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := s.server.Shutdown(ctx); err != nil {
+		logger.Error(err, "failed to shutdown debug endpoint")
+	}
+	s.wg.Wait()
+}
+
+// NewHandler returns the read-only handler serving the driver State
+func NewHandler(deviceStateManager *devicestate.Manager, podManager *podmanager.PodManager) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc(StatePath, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", http.MethodGet)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(getState(deviceStateManager, podManager)); err != nil {
+			klog.FromContext(r.Context()).Error(err, "failed to encode debug state")
+		}
+	})
+	return mux
+}
+
+// getState collects the allocatable devices, the devices prepared for each pod and the pods of each claim.
+// The prepared devices come from a single pod manager snapshot so both views are consistent.
+func getState(deviceStateManager *devicestate.Manager, podManager *podmanager.PodManager) *State {
+	state := &State{
+		AllocatableDevices: deviceStateManager.GetAllocatableDevices(),
+		PreparedDevices:    map[k8stypes.UID][]PreparedDeviceInfo{},
+		PodsByClaim:        map[k8stypes.UID][]k8stypes.UID{},
+	}
+
+	for podUID, preparedDevicesByClaimID := range podManager.Dump() {
+		for claimUID, preparedDevices := range preparedDevicesByClaimID {
+			state.PodsByClaim[claimUID] = append(state.PodsByClaim[claimUID], podUID)
+			for _, preparedDevice := range preparedDevices {
+				var ips []string
+				if attached, found := podManager.GetAttached(podUID, preparedDevice); found && attached.NetworkDeviceData != nil {
+					ips = attached.NetworkDeviceData.IPs
+				}
+				state.PreparedDevices[podUID] = append(state.PreparedDevices[podUID], PreparedDeviceInfo{
+					DeviceName:     preparedDevice.Device.DeviceName,
+					PoolName:       preparedDevice.Device.PoolName,
+					ClaimName:      preparedDevice.ClaimNamespacedName.Name,
+					ClaimNamespace: preparedDevice.ClaimNamespacedName.Namespace,
+					ClaimUID:       preparedDevice.ClaimNamespacedName.UID,
+					PciAddress:     preparedDevice.PciAddress,
+					IfName:         preparedDevice.IfName,
+					IPs:            ips,
+					OriginalDriver: preparedDevice.OriginalDriver,
+				})
+			}
+		}
+	}
+	for _, podUIDs := range state.PodsByClaim {
+		slices.Sort(podUIDs)
+	}
+
+	return state
+}
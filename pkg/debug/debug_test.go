@@ -0,0 +1,186 @@
+package debug_test
+
+import (
+	"context"
+
+	"encoding/json"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"os"
+
+	"github.com/jaypipes/ghw"
+	"github.com/jaypipes/ghw/pkg/pci"
+	"github.com/jaypipes/pcidb"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"go.uber.org/mock/gomock"
+	resourceapi "k8s.io/api/resource/v1"
+	k8stypes "k8s.io/apimachinery/pkg/types"
+	"k8s.io/dynamic-resource-allocation/kubeletplugin"
+	drapbv1 "k8s.io/kubelet/pkg/apis/dra/v1beta1"
+
+	"github.com/SchSeba/dra-driver-sriov/pkg/cdi"
+	"github.com/SchSeba/dra-driver-sriov/pkg/consts"
+	"github.com/SchSeba/dra-driver-sriov/pkg/debug"
+	"github.com/SchSeba/dra-driver-sriov/pkg/devicestate"
+	"github.com/SchSeba/dra-driver-sriov/pkg/host"
+	mock_host "github.com/SchSeba/dra-driver-sriov/pkg/host/mock"
+	"github.com/SchSeba/dra-driver-sriov/pkg/podmanager"
+	draTypes "github.com/SchSeba/dra-driver-sriov/pkg/types"
+)
+
+var _ = Describe("Debug endpoint", func() {
+	var (
+		tempDir      string
+		mockCtrl     *gomock.Controller
+		mockHost     *mock_host.MockInterface
+		originalHost host.Interface
+		handler      http.Handler
+		podManager   *podmanager.PodManager
+	)
+
+	BeforeEach(func() {
+		var err error
+		tempDir, err = os.MkdirTemp("", "debug-test-*")
+		Expect(err).NotTo(HaveOccurred())
+
+		mockCtrl = gomock.NewController(GinkgoT())
+		mockHost = mock_host.NewMockInterface(mockCtrl)
+		originalHost = host.GetHelpers()
+		host.Helpers = mockHost
+
+		mockHost.EXPECT().PCI().Return(&ghw.PCIInfo{
+			Devices: []*pci.Device{{
+				Address: "0000:01:00.0",
+				Vendor:  &pcidb.Vendor{ID: "8086"},
+				Product: &pcidb.Product{ID: "158b"},
+				Class:   &pcidb.Class{ID: "02"},
+			}},
+		}, nil)
+		mockHost.EXPECT().IsSriovVF("0000:01:00.0").Return(false)
+		mockHost.EXPECT().TryGetInterfaceName("0000:01:00.0").Return("eth0")
+		mockHost.EXPECT().GetNicSriovMode("0000:01:00.0").Return("legacy")
+		mockHost.EXPECT().GetSriovNumVFs("0000:01:00.0").Return(2, nil)
+		mockHost.EXPECT().GetSriovTotalVFs("0000:01:00.0").Return(64, nil)
+		mockHost.EXPECT().GetSriovVFTotalMsix("0000:01:00.0").Return(0, fs.ErrNotExist)
+		mockHost.EXPECT().GetPermanentMacAddress("eth0").Return("aa:bb:cc:dd:ee:01", nil)
+		mockHost.EXPECT().IsLinkUp("eth0").Return(true, nil)
+		mockHost.EXPECT().GetBondMaster("eth0").Return("", nil)
+		mockHost.EXPECT().GetNumaNode("0000:01:00.0").Return("0", nil)
+		mockHost.EXPECT().GetParentPciAddress("0000:01:00.0").Return("0000:00:01.0", nil)
+		mockHost.EXPECT().GetPciRootPort("0000:01:00.0").Return("0000:00:01.0", nil)
+		mockHost.EXPECT().GetEswitchMode("0000:01:00.0").Return("switchdev", nil)
+		mockHost.EXPECT().GetDriverByBusAndDevice("0000:01:00.0").Return("ice", nil)
+		mockHost.EXPECT().GetVFList("0000:01:00.0").Return([]host.VFInfo{
+			{PciAddress: "0000:01:00.1", VFID: 0, DeviceID: "154c"},
+			{PciAddress: "0000:01:00.2", VFID: 1, DeviceID: "154c"},
+		}, nil)
+
+		config := &draTypes.Config{
+			Flags: &draTypes.Flags{
+				KubeletPluginsDirectoryPath: tempDir,
+				DefaultInterfacePrefix:      "net",
+			},
+		}
+		cdiHandler, err := cdi.NewHandler(tempDir, cdi.DefaultVendor)
+		Expect(err).NotTo(HaveOccurred())
+		deviceStateManager, err := devicestate.NewManager(context.Background(), config, cdiHandler)
+		Expect(err).NotTo(HaveOccurred())
+		podManager, err = podmanager.NewPodManager(config)
+		Expect(err).NotTo(HaveOccurred())
+
+		handler = debug.NewHandler(deviceStateManager, podManager)
+	})
+
+	AfterEach(func() {
+		host.Helpers = originalHost
+		mockCtrl.Finish()
+		os.RemoveAll(tempDir)
+	})
+
+	get := func(method string) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, httptest.NewRequest(method, debug.StatePath, nil))
+		return recorder
+	}
+
+	It("should return the allocatable and prepared devices as JSON", func() {
+		Expect(podManager.Set("pod-uid", "claim-uid", draTypes.PreparedDevices{
+			{
+				Device: drapbv1.Device{DeviceName: "0000-01-00-1", PoolName: "node1"},
+				ClaimNamespacedName: kubeletplugin.NamespacedObject{
+					NamespacedName: k8stypes.NamespacedName{Namespace: "default", Name: "claim"},
+					UID:            "claim-uid",
+				},
+				PciAddress:         "0000:01:00.1",
+				IfName:             "net1",
+				PodUID:             "pod-uid",
+				NetAttachDefConfig: `{"cniVersion":"1.0.0","name":"test-net","type":"sriov"}`,
+			},
+		})).To(Succeed())
+
+		recorder := get(http.MethodGet)
+		Expect(recorder.Code).To(Equal(http.StatusOK))
+		Expect(recorder.Header().Get("Content-Type")).To(Equal("application/json"))
+
+		state := debug.State{}
+		Expect(json.Unmarshal(recorder.Body.Bytes(), &state)).To(Succeed())
+		Expect(state.AllocatableDevices).To(HaveLen(2))
+		Expect(state.AllocatableDevices).To(HaveKey("0000-01-00-2"))
+		Expect(*state.AllocatableDevices["0000-01-00-1"].Attributes[consts.AttributePFName].StringValue).To(Equal("eth0"))
+		Expect(state.PreparedDevices).To(HaveKeyWithValue(k8stypes.UID("pod-uid"), []debug.PreparedDeviceInfo{
+			{
+				DeviceName:     "0000-01-00-1",
+				PoolName:       "node1",
+				ClaimName:      "claim",
+				ClaimNamespace: "default",
+				ClaimUID:       "claim-uid",
+				PciAddress:     "0000:01:00.1",
+				IfName:         "net1",
+			},
+		}))
+		Expect(state.PodsByClaim).To(HaveKeyWithValue(k8stypes.UID("claim-uid"), []k8stypes.UID{"pod-uid"}))
+		Expect(recorder.Body.String()).NotTo(ContainSubstring("cniVersion"))
+	})
+
+	It("should return the IPs of the attached devices", func() {
+		preparedDevice := &draTypes.PreparedDevice{
+			Device: drapbv1.Device{DeviceName: "0000-01-00-1", PoolName: "node1"},
+			ClaimNamespacedName: kubeletplugin.NamespacedObject{
+				NamespacedName: k8stypes.NamespacedName{Namespace: "default", Name: "claim"},
+				UID:            "claim-uid",
+			},
+			PciAddress: "0000:01:00.1",
+			IfName:     "net1",
+			PodUID:     "pod-uid",
+		}
+		Expect(podManager.Set("pod-uid", "claim-uid", draTypes.PreparedDevices{preparedDevice})).To(Succeed())
+		podManager.SetAttached("pod-uid", &draTypes.NetworkDataChanStruct{
+			PreparedDevice:    preparedDevice,
+			NetworkDeviceData: &resourceapi.NetworkDeviceData{InterfaceName: "net1", IPs: []string{"192.168.1.10/24"}},
+		})
+
+		state := debug.State{}
+		Expect(json.Unmarshal(get(http.MethodGet).Body.Bytes(), &state)).To(Succeed())
+		Expect(state.PreparedDevices["pod-uid"]).To(HaveLen(1))
+		Expect(state.PreparedDevices["pod-uid"][0].IPs).To(Equal([]string{"192.168.1.10/24"}))
+	})
+
+	It("should return an empty prepared devices map when nothing is prepared", func() {
+		recorder := get(http.MethodGet)
+		Expect(recorder.Code).To(Equal(http.StatusOK))
+
+		raw := map[string]json.RawMessage{}
+		Expect(json.Unmarshal(recorder.Body.Bytes(), &raw)).To(Succeed())
+		Expect(raw).To(HaveKey("allocatableDevices"))
+		Expect(string(raw["preparedDevices"])).To(Equal("{}"))
+		Expect(string(raw["podsByClaim"])).To(Equal("{}"))
+	})
+
+	It("should be read-only", func() {
+		recorder := get(http.MethodPost)
+		Expect(recorder.Code).To(Equal(http.StatusMethodNotAllowed))
+		Expect(recorder.Header().Get("Allow")).To(Equal(http.MethodGet))
+	})
+})
@@ -0,0 +1,80 @@
+package debug
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	k8stypes "k8s.io/apimachinery/pkg/types"
+)
+
+// fetchTimeout bounds the request of the state to the running driver
+const fetchTimeout = 10 * time.Second
+
+// FetchState reads the State from the debug endpoint of the driver running on the node at the given port
+func FetchState(ctx context.Context, port int) (*State, error) {
+	if port < 0 {
+		return nil, fmt.Errorf("the debug endpoint is disabled, set the debug HTTP port of the driver")
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, fetchTimeout)
+	defer cancel()
+	url := "http://" + net.JoinHostPort("localhost", strconv.Itoa(port)) + StatePath
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query the debug endpoint at %s: %w", url, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status from the debug endpoint at %s: %s", url, resp.Status)
+	}
+
+	state := &State{}
+	if err := json.NewDecoder(resp.Body).Decode(state); err != nil {
+		return nil, fmt.Errorf("failed to decode the driver state: %w", err)
+	}
+	return state, nil
+}
+
+// PrintPreparedClaims writes a table of the prepared devices with their pod and claim,
+// ordered by pod UID, claim and device name
+func PrintPreparedClaims(w io.Writer, state *State) error {
+	podUIDs := make([]k8stypes.UID, 0, len(state.PreparedDevices))
+	for podUID := range state.PreparedDevices {
+		podUIDs = append(podUIDs, podUID)
+	}
+	slices.Sort(podUIDs)
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "POD UID\tCLAIM\tDEVICE\tPCI ADDRESS\tINTERFACE\tIPS")
+	for _, podUID := range podUIDs {
+		devices := slices.Clone(state.PreparedDevices[podUID])
+		slices.SortFunc(devices, func(a, b PreparedDeviceInfo) int {
+			if c := strings.Compare(a.ClaimNamespace+"/"+a.ClaimName, b.ClaimNamespace+"/"+b.ClaimName); c != 0 {
+				return c
+			}
+			return strings.Compare(a.DeviceName, b.DeviceName)
+		})
+		for _, device := range devices {
+			ips := "-"
+			if len(device.IPs) > 0 {
+				ips = strings.Join(device.IPs, ",")
+			}
+			fmt.Fprintf(tw, "%s\t%s/%s\t%s\t%s\t%s\t%s\n", podUID, device.ClaimNamespace, device.ClaimName,
+				device.DeviceName, device.PciAddress, device.IfName, ips)
+		}
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,73 @@
+package debug_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	k8stypes "k8s.io/apimachinery/pkg/types"
+
+	"github.com/SchSeba/dra-driver-sriov/pkg/debug"
+)
+
+var _ = Describe("Status", func() {
+	var state *debug.State
+
+	BeforeEach(func() {
+		state = &debug.State{
+			PreparedDevices: map[k8stypes.UID][]debug.PreparedDeviceInfo{
+				"pod-2": {
+					{DeviceName: "0000-01-00-3", ClaimName: "claim-b", ClaimNamespace: "default", PciAddress: "0000:01:00.3", IfName: "net1"},
+				},
+				"pod-1": {
+					{DeviceName: "0000-01-00-2", ClaimName: "claim-a", ClaimNamespace: "default", PciAddress: "0000:01:00.2", IfName: "net2"},
+					{DeviceName: "0000-01-00-1", ClaimName: "claim-a", ClaimNamespace: "default", PciAddress: "0000:01:00.1", IfName: "net1",
+						IPs: []string{"192.168.1.10/24", "fd00::10/64"}},
+				},
+			},
+		}
+	})
+
+	It("should print a table of the prepared devices ordered by pod, claim and device", func() {
+		var out bytes.Buffer
+		Expect(debug.PrintPreparedClaims(&out, state)).To(Succeed())
+		Expect(out.String()).To(Equal(
+			"POD UID  CLAIM            DEVICE        PCI ADDRESS   INTERFACE  IPS\n" +
+				"pod-1    default/claim-a  0000-01-00-1  0000:01:00.1  net1       192.168.1.10/24,fd00::10/64\n" +
+				"pod-1    default/claim-a  0000-01-00-2  0000:01:00.2  net2       -\n" +
+				"pod-2    default/claim-b  0000-01-00-3  0000:01:00.3  net1       -\n"))
+	})
+
+	It("should print only the header when nothing is prepared", func() {
+		var out bytes.Buffer
+		Expect(debug.PrintPreparedClaims(&out, &debug.State{})).To(Succeed())
+		Expect(out.String()).To(Equal("POD UID  CLAIM  DEVICE  PCI ADDRESS  INTERFACE  IPS\n"))
+	})
+
+	It("should fetch the state from the debug endpoint", func() {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			Expect(r.URL.Path).To(Equal(debug.StatePath))
+			Expect(json.NewEncoder(w).Encode(state)).To(Succeed())
+		}))
+		DeferCleanup(server.Close)
+		_, port, err := net.SplitHostPort(server.Listener.Addr().String())
+		Expect(err).NotTo(HaveOccurred())
+		portNumber, err := strconv.Atoi(port)
+		Expect(err).NotTo(HaveOccurred())
+
+		fetched, err := debug.FetchState(context.Background(), portNumber)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(fetched.PreparedDevices).To(Equal(state.PreparedDevices))
+	})
+
+	It("should fail when the debug endpoint is disabled", func() {
+		_, err := debug.FetchState(context.Background(), -1)
+		Expect(err).To(MatchError(ContainSubstring("the debug endpoint is disabled")))
+	})
+})
@@ -0,0 +1,62 @@
+package devicestate
+
+import (
+	"fmt"
+	"strings"
+
+	resourceapi "k8s.io/api/resource/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/utils/ptr"
+
+	"github.com/SchSeba/dra-driver-sriov/pkg/consts"
+	drasriovtypes "github.com/SchSeba/dra-driver-sriov/pkg/types"
+)
+
+// ExtraDeviceAttributes are the string attributes added to every discovered device
+type ExtraDeviceAttributes map[resourceapi.QualifiedName]string
+
+// ParseExtraDeviceAttributes parses comma separated key=value pairs into attributes under the
+// consts.ExtraAttributeDomain domain, so they can't collide with the attributes set by the discovery.
+// The keys must be C identifiers of at most 32 characters, as the resource slice attribute names.
+func ParseExtraDeviceAttributes(value string) (ExtraDeviceAttributes, error) {
+	attributes := ExtraDeviceAttributes{}
+	for _, pair := range strings.Split(value, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		key, attributeValue, found := strings.Cut(pair, "=")
+		if !found {
+			return nil, fmt.Errorf("invalid extra device attribute %q, must be key=value", pair)
+		}
+		key = strings.TrimSpace(key)
+		if errs := validation.IsCIdentifier(key); len(errs) > 0 {
+			return nil, fmt.Errorf("invalid extra device attribute name %q: %s", key, strings.Join(errs, ", "))
+		}
+		if len(key) > resourceapi.DeviceMaxIDLength {
+			return nil, fmt.Errorf("invalid extra device attribute name %q: must be no more than %d characters", key, resourceapi.DeviceMaxIDLength)
+		}
+		if len(attributeValue) > resourceapi.DeviceAttributeMaxValueLength {
+			return nil, fmt.Errorf("invalid extra device attribute %q value: must be no more than %d characters", key, resourceapi.DeviceAttributeMaxValueLength)
+		}
+		name := resourceapi.QualifiedName(consts.ExtraAttributeDomain + "/" + key)
+		if _, exists := attributes[name]; exists {
+			return nil, fmt.Errorf("duplicate extra device attribute %q", key)
+		}
+		attributes[name] = attributeValue
+	}
+	return attributes, nil
+}
+
+// addExtraAttributes sets the extra attributes on all the devices
+func addExtraAttributes(devices drasriovtypes.AllocatableDevices, attributes ExtraDeviceAttributes) {
+	if len(attributes) == 0 {
+		return
+	}
+	for deviceName, device := range devices {
+		for name, value := range attributes {
+			device.Attributes[name] = resourceapi.DeviceAttribute{StringValue: ptr.To(value)}
+		}
+		devices[deviceName] = device
+	}
+}
@@ -0,0 +1,47 @@
+package devicestate_test
+
+import (
+	"strings"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	resourceapi "k8s.io/api/resource/v1"
+
+	"github.com/SchSeba/dra-driver-sriov/pkg/consts"
+	"github.com/SchSeba/dra-driver-sriov/pkg/devicestate"
+)
+
+var _ = Describe("ParseExtraDeviceAttributes", func() {
+	It("should namespace the attributes under the extra domain", func() {
+		attributes, err := devicestate.ParseExtraDeviceAttributes("rack=r1, zone=eu-west-1a,empty=")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(attributes).To(Equal(devicestate.ExtraDeviceAttributes{
+			resourceapi.QualifiedName(consts.ExtraAttributeDomain + "/rack"):  "r1",
+			resourceapi.QualifiedName(consts.ExtraAttributeDomain + "/zone"):  "eu-west-1a",
+			resourceapi.QualifiedName(consts.ExtraAttributeDomain + "/empty"): "",
+		}))
+	})
+
+	It("should return no attribute for an empty value", func() {
+		attributes, err := devicestate.ParseExtraDeviceAttributes("")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(attributes).To(BeEmpty())
+	})
+
+	It("should reject the keys that are not valid attribute names", func() {
+		for _, value := range []string{"rack", "=r1", "my-rack=r1", "1rack=r1", "example.com/rack=r1", strings.Repeat("a", 33) + "=r1"} {
+			_, err := devicestate.ParseExtraDeviceAttributes(value)
+			Expect(err).To(HaveOccurred(), value)
+		}
+	})
+
+	It("should reject a value longer than the attribute value limit", func() {
+		_, err := devicestate.ParseExtraDeviceAttributes("rack=" + strings.Repeat("a", 65))
+		Expect(err).To(MatchError(ContainSubstring(`invalid extra device attribute "rack" value`)))
+	})
+
+	It("should reject a duplicate key", func() {
+		_, err := devicestate.ParseExtraDeviceAttributes("rack=r1,rack=r2")
+		Expect(err).To(MatchError(ContainSubstring(`duplicate extra device attribute "rack"`)))
+	})
+})
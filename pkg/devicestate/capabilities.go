@@ -0,0 +1,110 @@
+package devicestate
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+
+	resourceapi "k8s.io/api/resource/v1"
+	"k8s.io/klog/v2"
+	"k8s.io/utils/ptr"
+
+	configapi "github.com/SchSeba/dra-driver-sriov/pkg/api/virtualfunction/v1alpha1"
+	"github.com/SchSeba/dra-driver-sriov/pkg/consts"
+	"github.com/SchSeba/dra-driver-sriov/pkg/host"
+)
+
+// rateLimitCapableDrivers lists the PF drivers known to set the VF transmit rates, there is no way to probe
+// the support from the device so the rate limiting capability is only reported for them
+var rateLimitCapableDrivers = []string{"mlx5_core", "ice", "i40e"}
+
+// ProbeCapabilities detects the capabilities of an SR-IOV PF from the device itself whatever its vendor,
+// they are added as attributes to all the VFs of the PF. A capability that can't be probed is left out,
+// so the features needing it are not rejected before they are applied.
+func ProbeCapabilities(pfPciAddress string, totalVFs int) map[resourceapi.QualifiedName]resourceapi.DeviceAttribute {
+	if totalVFs == 0 {
+		return nil
+	}
+	logger := klog.LoggerWithName(klog.Background(), "ProbeCapabilities")
+	attributes := map[resourceapi.QualifiedName]resourceapi.DeviceAttribute{}
+
+	// the eswitch mode is only reported by the drivers able to switch the PF to switchdev
+	if mode, err := host.GetHelpers().GetEswitchMode(pfPciAddress); err != nil {
+		logger.Error(err, "Failed to probe the PF eswitch", "address", pfPciAddress)
+	} else {
+		attributes[consts.AttributeSupportsSwitchdevOffload] = resourceapi.DeviceAttribute{BoolValue: ptr.To(mode != "")}
+	}
+
+	driver, err := host.GetHelpers().GetDriverByBusAndDevice(pfPciAddress)
+	if err != nil {
+		logger.Error(err, "Failed to get the PF driver", "address", pfPciAddress)
+	} else if slices.Contains(rateLimitCapableDrivers, driver) {
+		attributes[consts.AttributeSupportsRateLimiting] = resourceapi.DeviceAttribute{BoolValue: ptr.To(true)}
+	}
+	return attributes
+}
+
+// requiredCapability is a VfConfig feature that needs a capability attribute on the allocated device
+type requiredCapability struct {
+	feature   string
+	attribute resourceapi.QualifiedName
+	requested func(config *configapi.VfConfig) bool
+}
+
+// requiredCapabilities lists the VfConfig features checked against the device capabilities before they are applied
+var requiredCapabilities = []requiredCapability{
+	{
+		feature:   "tx rate limiting",
+		attribute: consts.AttributeSupportsRateLimiting,
+		requested: func(config *configapi.VfConfig) bool {
+			return config.MinTxRate != 0 || config.MaxTxRate != 0
+		},
+	},
+	{
+		feature:   "vlan",
+		attribute: consts.AttributeSupportsVlan,
+		requested: func(config *configapi.VfConfig) bool {
+			return config.Vlan != 0
+		},
+	},
+}
+
+// checkDeviceCapabilities returns an error naming the first feature requested by the config that the device
+// is known not to support. A missing capability attribute means the support is unknown and the feature is
+// applied, the host call setting it fails the prepare when the PF can't honor it.
+func checkDeviceCapabilities(device resourceapi.Device, config *configapi.VfConfig) error {
+	for _, capability := range requiredCapabilities {
+		if !capability.requested(config) {
+			continue
+		}
+		attribute, found := device.Attributes[capability.attribute]
+		if found && attribute.BoolValue != nil && !*attribute.BoolValue {
+			return fmt.Errorf("device %s does not support %s requested by the config (%s is false)", device.Name, capability.feature, capability.attribute)
+		}
+	}
+	return nil
+}
+
+// checkClaimCapabilities checks the capabilities of every device of the claim driven by the driver against its config,
+// so the devices of all the PFs that can't honor the config are reported at once before any device is changed
+func (s *Manager) checkClaimCapabilities(claim *resourceapi.ResourceClaim, configs []*configapi.VfConfig) error {
+	s.allocatableMu.RLock()
+	defer s.allocatableMu.RUnlock()
+	var errs []error
+	for i, result := range claim.Status.Allocation.Devices.Results {
+		if result.Driver != consts.DriverName {
+			continue
+		}
+		device, found := s.allocatable[result.Device]
+		if !found {
+			continue
+		}
+		if err := checkDeviceCapabilities(device, configs[i]); err != nil {
+			if pfName := s.getPFName(result.Device); pfName != "" {
+				err = fmt.Errorf("%w on PF %s", err, pfName)
+			}
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,111 @@
+package devicestate_test
+
+import (
+	"fmt"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/vishvananda/netlink"
+	resourceapi "k8s.io/api/resource/v1"
+	"k8s.io/utils/ptr"
+
+	"github.com/SchSeba/dra-driver-sriov/pkg/consts"
+	"github.com/SchSeba/dra-driver-sriov/pkg/devicestate"
+	"github.com/SchSeba/dra-driver-sriov/pkg/host"
+)
+
+var _ = Describe("ProbeCapabilities", func() {
+	var (
+		fs           *host.FakeFilesystem
+		fakeNetlink  *host.FakeNetlink
+		tearDown     func()
+		originalHost host.Interface
+	)
+
+	BeforeEach(func() {
+		originalHost = host.GetHelpers()
+		fakeNetlink = &host.FakeNetlink{}
+		host.Helpers = host.NewHostWithNetlink(fakeNetlink)
+		fs = &host.FakeFilesystem{
+			Dirs: []string{
+				"sys/bus/pci/devices/0000:01:00.0",
+				"sys/bus/pci/drivers/mlx5_core",
+				"sys/bus/pci/drivers/ixgbe",
+			},
+		}
+	})
+
+	AfterEach(func() {
+		host.Helpers = originalHost
+		if tearDown != nil {
+			tearDown()
+		}
+	})
+
+	useDriver := func(driver string) {
+		fs.Symlinks = map[string]string{
+			"sys/bus/pci/devices/0000:01:00.0/driver": "../../../../bus/pci/drivers/" + driver,
+		}
+		tearDown = fs.Use()
+	}
+
+	useEswitchMode := func(mode string) {
+		fakeNetlink.DevlinkDevices = []*netlink.DevlinkDevice{
+			{BusName: "pci", DeviceName: "0000:01:00.0", Attrs: netlink.DevlinkDevAttrs{Eswitch: netlink.DevlinkDevEswitchAttr{Mode: mode}}},
+		}
+	}
+
+	It("should report switchdev offload for a PF reporting its eswitch mode", func() {
+		useEswitchMode("legacy")
+		useDriver("mlx5_core")
+
+		attributes := devicestate.ProbeCapabilities("0000:01:00.0", 64)
+		Expect(attributes).To(HaveKeyWithValue(resourceapi.QualifiedName(consts.AttributeSupportsSwitchdevOffload),
+			resourceapi.DeviceAttribute{BoolValue: ptr.To(true)}))
+		Expect(attributes).To(HaveKeyWithValue(resourceapi.QualifiedName(consts.AttributeSupportsRateLimiting),
+			resourceapi.DeviceAttribute{BoolValue: ptr.To(true)}))
+		// the VF VLAN support can't be probed without changing a VF, it is never reported
+		Expect(attributes).NotTo(HaveKey(resourceapi.QualifiedName(consts.AttributeSupportsVlan)))
+	})
+
+	It("should not report switchdev offload for a PF without eswitch", func() {
+		useEswitchMode("")
+		useDriver("ixgbe")
+
+		attributes := devicestate.ProbeCapabilities("0000:01:00.0", 64)
+		Expect(attributes).To(HaveKeyWithValue(resourceapi.QualifiedName(consts.AttributeSupportsSwitchdevOffload),
+			resourceapi.DeviceAttribute{BoolValue: ptr.To(false)}))
+		Expect(attributes).NotTo(HaveKey(resourceapi.QualifiedName(consts.AttributeSupportsRateLimiting)))
+	})
+
+	It("should not report switchdev offload for a PF without devlink device", func() {
+		useDriver("ixgbe")
+
+		attributes := devicestate.ProbeCapabilities("0000:01:00.0", 64)
+		Expect(attributes).To(HaveKeyWithValue(resourceapi.QualifiedName(consts.AttributeSupportsSwitchdevOffload),
+			resourceapi.DeviceAttribute{BoolValue: ptr.To(false)}))
+	})
+
+	It("should not report the capabilities that can't be probed", func() {
+		tearDown = fs.Use()
+		host.Helpers = host.NewHostWithNetlink(&failingDevlink{})
+
+		Expect(devicestate.ProbeCapabilities("0000:01:00.0", 64)).To(BeEmpty())
+	})
+
+	It("should not add attributes for a device without SR-IOV support", func() {
+		useEswitchMode("switchdev")
+		useDriver("mlx5_core")
+
+		Expect(devicestate.ProbeCapabilities("0000:01:00.0", 0)).To(BeEmpty())
+	})
+})
+
+// failingDevlink fails every devlink call with an error that is not a missing device
+type failingDevlink struct {
+	host.FakeNetlink
+}
+
+func (f *failingDevlink) DevLinkGetDeviceByName(bus, device string) (*netlink.DevlinkDevice, error) {
+	return nil, fmt.Errorf("netlink receive: operation not permitted")
+}
@@ -0,0 +1,94 @@
+package devicestate
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	configapi "github.com/SchSeba/dra-driver-sriov/pkg/api/virtualfunction/v1alpha1"
+)
+
+// configMapCacheTTL is how long a VfConfig read from a ConfigMap is reused before the ConfigMap is read again
+const configMapCacheTTL = time.Minute
+
+// cachedConfigMapConfig is a VfConfig read from a ConfigMap with the time it was read
+type cachedConfigMapConfig struct {
+	config  *configapi.VfConfig
+	fetched time.Time
+}
+
+// resolveConfigMapRef returns the config merged on top of the VfConfig of its ConfigMap reference,
+// the fields set inline take precedence. The config is returned as is when it has no reference.
+// The referenced ConfigMap must be in the namespace of the claim, a claim can't read the configs of another namespace.
+func (s *Manager) resolveConfigMapRef(ctx context.Context, claimNamespace string, config *configapi.VfConfig) (*configapi.VfConfig, error) {
+	if config.ConfigMapRef == nil {
+		return config, nil
+	}
+	ref := *config.ConfigMapRef
+	if ref.Name == "" {
+		return nil, fmt.Errorf("configMapRef has no name set")
+	}
+	if ref.Namespace == "" {
+		ref.Namespace = claimNamespace
+	}
+	if ref.Namespace != claimNamespace {
+		return nil, fmt.Errorf("configMapRef namespace %s must be the namespace of the claim %s", ref.Namespace, claimNamespace)
+	}
+	if ref.Key == "" {
+		ref.Key = configapi.DefaultConfigMapKey
+	}
+
+	referenced, err := s.getConfigMapConfig(ctx, ref)
+	if err != nil {
+		return nil, err
+	}
+	resolved := referenced.DeepCopy()
+	resolved.Override(config)
+	// the values valid on their own can conflict once merged, e.g. a min tx rate above the referenced max
+	if err := resolved.ValidateClaim(); err != nil {
+		return nil, fmt.Errorf("invalid config merged on top of the VfConfig ConfigMap %s/%s: %w", ref.Namespace, ref.Name, err)
+	}
+	klog.FromContext(ctx).V(4).Info("Resolved the config of the ConfigMap reference", "configMap", ref.Namespace+"/"+ref.Name, "key", ref.Key)
+	return resolved, nil
+}
+
+// getConfigMapConfig returns the VfConfig held in the ConfigMap key, cached for configMapCacheTTL.
+// The lookup errors are not cached so a ConfigMap created or fixed later is picked up on the next prepare.
+func (s *Manager) getConfigMapConfig(ctx context.Context, ref configapi.ConfigMapReference) (*configapi.VfConfig, error) {
+	s.configMapConfigsMu.Lock()
+	cached, found := s.configMapConfigs[ref]
+	s.configMapConfigsMu.Unlock()
+	if found && s.clock.Since(cached.fetched) < configMapCacheTTL {
+		return cached.config, nil
+	}
+
+	configMap := &corev1.ConfigMap{}
+	if err := s.k8sClient.Get(ctx, client.ObjectKey{Namespace: ref.Namespace, Name: ref.Name}, configMap); err != nil {
+		return nil, fmt.Errorf("error getting the VfConfig ConfigMap %s/%s: %w", ref.Namespace, ref.Name, err)
+	}
+	data, found := configMap.Data[ref.Key]
+	if !found {
+		return nil, fmt.Errorf("VfConfig ConfigMap %s/%s has no %s key", ref.Namespace, ref.Name, ref.Key)
+	}
+	decodedConfig, err := runtime.Decode(configapi.Decoder, []byte(data))
+	if err != nil {
+		return nil, fmt.Errorf("error decoding the %s key of the VfConfig ConfigMap %s/%s: %w", ref.Key, ref.Namespace, ref.Name, err)
+	}
+	vfConfig, ok := decodedConfig.(*configapi.VfConfig)
+	if !ok {
+		return nil, fmt.Errorf("the %s key of ConfigMap %s/%s does not contain a VfConfig", ref.Key, ref.Namespace, ref.Name)
+	}
+	if err := vfConfig.ValidateReferenced(); err != nil {
+		return nil, fmt.Errorf("invalid VfConfig in the %s key of ConfigMap %s/%s: %w", ref.Key, ref.Namespace, ref.Name, err)
+	}
+
+	s.configMapConfigsMu.Lock()
+	s.configMapConfigs[ref] = cachedConfigMapConfig{config: vfConfig, fetched: s.clock.Now()}
+	s.configMapConfigsMu.Unlock()
+	return vfConfig, nil
+}
@@ -0,0 +1,13 @@
+package devicestate_test
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+func TestDeviceState(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "DeviceState Suite")
+}
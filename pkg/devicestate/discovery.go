@@ -1,11 +1,21 @@
 package devicestate
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"io/fs"
+	"runtime"
+	"sort"
 	"strconv"
 	"strings"
 
+	"github.com/jaypipes/ghw"
+	"github.com/jaypipes/ghw/pkg/pci"
 	resourceapi "k8s.io/api/resource/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/client-go/util/retry"
+	"k8s.io/client-go/util/workqueue"
 	"k8s.io/klog/v2"
 	"k8s.io/utils/ptr"
 
@@ -14,6 +24,9 @@ import (
 	"github.com/SchSeba/dra-driver-sriov/pkg/types"
 )
 
+// pciRetryBackoff is the backoff of the PCI info reads
+var pciRetryBackoff = consts.Backoff
+
 type PFInfo struct {
 	PciAddress       string
 	NetName          string
@@ -21,21 +34,86 @@ type PFInfo struct {
 	DeviceID         string
 	Address          string
 	EswitchMode      string
+	MacAddress       string
 	NumaNode         string
 	ParentPciAddress string
+	NumVFs           int
+	TotalVFs         int
+	// VFTotalMsix is the MSI-X vector pool shared by the VFs, nil when the PF doesn't expose it
+	VFTotalMsix *int
+	// LinkUp is the operational state of the PF netdev, nil when it can't be determined
+	LinkUp *bool
+	// BondName is the bond the PF is a slave of, empty when the PF is not bonded
+	BondName string
+	// Utilization is the percentage of the VFs of the PF that are enabled, nil when the PF reports no VFs
+	Utilization *int
+	// PciRoot is the PCIe root port upstream of the PF and its VFs, empty when it can't be determined
+	PciRoot string
 }
 
-func DiscoverSriovDevices() (types.AllocatableDevices, error) {
-	logger := klog.LoggerWithName(klog.Background(), "DiscoverSriovDevices")
-	pfList := []PFInfo{}
-	resourceList := types.AllocatableDevices{}
+// PFSriovCapabilities holds the SR-IOV capabilities of a PF read from the host
+type PFSriovCapabilities struct {
+	NumVFs      int
+	TotalVFs    int
+	EswitchMode string
+	// VFTotalMsix is nil when the PF doesn't support dynamic MSI-X assignment to its VFs
+	VFTotalMsix *int
+}
+
+// getPFSriovCapabilities reads the SR-IOV capabilities of the PF from the host, a value that can't be read is
+// logged and left to its zero value. It is called once per PF and discovery pass, the VFs share the result.
+func getPFSriovCapabilities(logger klog.Logger, pfPciAddress string) *PFSriovCapabilities {
+	numVFs, err := host.GetHelpers().GetSriovNumVFs(pfPciAddress)
+	if err != nil {
+		logger.Error(err, "Failed to get the number of enabled VFs", "address", pfPciAddress)
+	}
+	totalVFs, err := host.GetHelpers().GetSriovTotalVFs(pfPciAddress)
+	if err != nil {
+		logger.Error(err, "Failed to get the total number of VFs", "address", pfPciAddress)
+	}
+	var vfTotalMsix *int
+	if msix, err := host.GetHelpers().GetSriovVFTotalMsix(pfPciAddress); err == nil {
+		vfTotalMsix = ptr.To(msix)
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		logger.Error(err, "Failed to get the MSI-X vectors of the VFs", "address", pfPciAddress)
+	}
+	return &PFSriovCapabilities{
+		NumVFs:      numVFs,
+		TotalVFs:    totalVFs,
+		EswitchMode: host.GetHelpers().GetNicSriovMode(pfPciAddress),
+		VFTotalMsix: vfTotalMsix,
+	}
+}
+
+// DiscoverSriovDevices returns the VFs of the node named according to the naming scheme.
+// The devices dropped by the filter are removed before the maxVFs cap is applied, a nil filter keeps every device.
+// When maxVFs is positive, at most maxVFs devices are returned, the ones with the lowest PCI addresses.
+// The PCI devices and then the PFs are walked by up to concurrency workers, zero uses one per CPU, the result
+// doesn't depend on it. The discovery is aborted between the PFs and the VFs once the context is cancelled.
+func DiscoverSriovDevices(ctx context.Context, deviceNaming string, maxVFs int, filter DeviceFilter, concurrency int) (types.AllocatableDevices, error) {
+	logger := klog.LoggerWithName(klog.FromContext(ctx), "DiscoverSriovDevices")
+	if deviceNaming == "" {
+		deviceNaming = consts.DeviceNamingPCI
+	}
+	if deviceNaming != consts.DeviceNamingPCI && deviceNaming != consts.DeviceNamingPFIndex {
+		return nil, fmt.Errorf("unknown device naming scheme %q, must be %s or %s", deviceNaming, consts.DeviceNamingPCI, consts.DeviceNamingPFIndex)
+	}
+	if maxVFs < 0 {
+		return nil, fmt.Errorf("invalid maximum number of VFs per node %d, must not be negative", maxVFs)
+	}
+	if concurrency < 0 {
+		return nil, fmt.Errorf("invalid discovery concurrency %d, must not be negative", concurrency)
+	}
+	if concurrency == 0 {
+		concurrency = runtime.NumCPU()
+	}
 
-	logger.Info("Starting SR-IOV device discovery")
+	logger.Info("Starting SR-IOV device discovery", "concurrency", concurrency)
 
-	pci, err := host.GetHelpers().PCI()
+	pci, err := getPCIInfo()
 	if err != nil {
 		logger.Error(err, "Failed to get PCI info")
-		return nil, fmt.Errorf("error getting PCI info: %v", err)
+		return nil, err
 	}
 
 	devices := pci.Devices
@@ -46,136 +124,382 @@ func DiscoverSriovDevices() (types.AllocatableDevices, error) {
 
 	logger.Info("Found PCI devices", "count", len(devices))
 
-	for _, device := range devices {
-		logger.V(2).Info("Processing PCI device", "address", device.Address, "class", device.Class.ID)
+	// each worker fills the slot of its device, so the PF list keeps the PCI devices order
+	pfSlots := make([]*PFInfo, len(devices))
+	workqueue.ParallelizeUntil(ctx, concurrency, len(devices), func(i int) {
+		// a slow sysfs walk on a degraded node must not block past the caller deadline
+		if ctx.Err() != nil {
+			return
+		}
+		pfSlots[i] = discoverPF(logger, devices[i])
+	})
+	if err := ctx.Err(); err != nil {
+		return nil, fmt.Errorf("device discovery cancelled: %w", err)
+	}
+	pfList := []PFInfo{}
+	for _, pfInfo := range pfSlots {
+		if pfInfo != nil {
+			pfList = append(pfList, *pfInfo)
+		}
+	}
 
-		devClass, err := strconv.ParseInt(device.Class.ID, 16, 64)
-		if err != nil {
-			logger.Error(err, "Unable to parse device class, skipping device",
-				"address", device.Address, "class", device.Class.ID)
-			continue
+	logger.Info("Processing SR-IOV PF devices", "pfCount", len(pfList))
+
+	// Sort PFs by PCI address so the PF index attribute is stable across restarts
+	sort.Slice(pfList, func(i, j int) bool {
+		return pfList[i].PciAddress < pfList[j].PciAddress
+	})
+
+	pfDevices := make([][]resourceapi.Device, len(pfList))
+	pfErrors := make([]error, len(pfList))
+	workqueue.ParallelizeUntil(ctx, concurrency, len(pfList), func(pfIndex int) {
+		pfDevices[pfIndex], pfErrors[pfIndex] = discoverVFs(ctx, logger, deviceNaming, pfIndex, pfList[pfIndex])
+	})
+	if err := ctx.Err(); err != nil {
+		return nil, fmt.Errorf("device discovery cancelled: %w", err)
+	}
+
+	// the devices are merged in the PF order, a name collision keeps the device of the last PF like a serial walk
+	resourceList := types.AllocatableDevices{}
+	for pfIndex := range pfList {
+		if pfErrors[pfIndex] != nil {
+			return nil, pfErrors[pfIndex]
 		}
-		if devClass != consts.NetClass {
-			logger.V(3).Info("Skipping non-network device", "address", device.Address, "class", devClass)
-			continue
+		for _, device := range pfDevices[pfIndex] {
+			resourceList[device.Name] = device
 		}
+	}
+
+	filtered, err := filterDevices(resourceList, filter)
+	if err != nil {
+		return nil, err
+	}
+	if len(filtered) > 0 {
+		logger.Info("Skipping the devices dropped by the device filters", "filteredCount", len(filtered), "filteredDevices", filtered)
+	}
+
+	if skipped := capDevices(resourceList, maxVFs); len(skipped) > 0 {
+		logger.Error(nil, "Discovered more VFs than the maximum per node, skipping the devices with the highest PCI addresses",
+			"maxVFs", maxVFs, "skippedCount", len(skipped), "skippedDevices", skipped)
+	}
+
+	logger.Info("SR-IOV device discovery completed", "totalDevices", len(resourceList))
+	return resourceList, nil
+}
+
+// discoverPF reads the PF information of a PCI device, nil when the device is not a SR-IOV capable network PF
+func discoverPF(logger klog.Logger, device *pci.Device) *PFInfo {
+	logger.V(2).Info("Processing PCI device", "address", device.Address, "class", device.Class.ID)
+
+	devClass, err := strconv.ParseInt(device.Class.ID, 16, 64)
+	if err != nil {
+		logger.Error(err, "Unable to parse device class, skipping device",
+			"address", device.Address, "class", device.Class.ID)
+		return nil
+	}
+	if devClass != consts.NetClass {
+		logger.V(3).Info("Skipping non-network device", "address", device.Address, "class", devClass)
+		return nil
+	}
+
+	if host.GetHelpers().IsSriovVF(device.Address) {
+		logger.V(2).Info("Skipping VF device", "address", device.Address)
+		return nil
+	}
+
+	pfNetName := host.GetHelpers().TryGetInterfaceName(device.Address)
+	if pfNetName == "" {
+		logger.Error(nil, "Unable to get interface name for device, skipping", "address", device.Address)
+		return nil
+	}
+
+	pfSriovCapabilities := getPFSriovCapabilities(logger, device.Address)
+
+	// Get the PF permanent MAC address, VFs sharing the PF report the same value
+	pfMacAddress, err := host.GetHelpers().GetPermanentMacAddress(pfNetName)
+	if err != nil {
+		logger.Error(err, "Failed to get PF MAC address", "address", device.Address, "interface", pfNetName)
+		pfMacAddress = "" // Leave empty if we can't determine it
+	}
+
+	// Get NUMA node information
+	numaNode, err := host.GetHelpers().GetNumaNode(device.Address)
+	if err != nil {
+		logger.Error(err, "Failed to get NUMA node, using default", "address", device.Address)
+		numaNode = "0" // Default to node 0 if we can't determine it
+	}
+
+	// Get parent PCI address information
+	parentPciAddress, err := host.GetHelpers().GetParentPciAddress(device.Address)
+	if err != nil {
+		logger.Error(err, "Failed to get parent PCI address", "address", device.Address)
+		parentPciAddress = "" // Leave empty if we can't determine it
+	}
+
+	// Get the root port of the PF so claims can spread the VFs over the PCIe upstream links
+	pciRoot, err := host.GetHelpers().GetPciRootPort(device.Address)
+	if err != nil {
+		logger.Error(err, "Failed to get PCI root port", "address", device.Address)
+		pciRoot = "" // Leave empty if we can't determine it
+	}
+
+	// Get the PF link state so claims can avoid VFs on a down uplink
+	var pfLinkUp *bool
+	if linkUp, err := host.GetHelpers().IsLinkUp(pfNetName); err != nil {
+		logger.Error(err, "Failed to get PF link state", "address", device.Address, "interface", pfNetName)
+	} else {
+		pfLinkUp = ptr.To(linkUp)
+	}
 
-		// TODO: exclude devices used by host system
-		if host.GetHelpers().IsSriovVF(device.Address) {
-			logger.V(2).Info("Skipping VF device", "address", device.Address)
+	// Get the bond of the PF so claims can spread the VFs over the legs of different bonds
+	bondName, err := host.GetHelpers().GetBondMaster(pfNetName)
+	if err != nil {
+		logger.Error(err, "Failed to get PF bond master", "address", device.Address, "interface", pfNetName)
+	}
+
+	logger.Info("Found SR-IOV PF device",
+		"address", device.Address,
+		"interface", pfNetName,
+		"vendor", device.Vendor.ID,
+		"device", device.Product.ID,
+		"eswitchMode", pfSriovCapabilities.EswitchMode,
+		"numVFs", pfSriovCapabilities.NumVFs,
+		"totalVFs", pfSriovCapabilities.TotalVFs,
+		"macAddress", pfMacAddress,
+		"numaNode", numaNode,
+		"parentPciAddress", parentPciAddress,
+		"pciRoot", pciRoot,
+		"bondName", bondName)
+
+	return &PFInfo{
+		PciAddress:       device.Address,
+		NetName:          pfNetName,
+		VendorID:         device.Vendor.ID,
+		DeviceID:         device.Product.ID,
+		Address:          device.Address,
+		EswitchMode:      pfSriovCapabilities.EswitchMode,
+		MacAddress:       pfMacAddress,
+		NumaNode:         numaNode,
+		ParentPciAddress: parentPciAddress,
+		NumVFs:           pfSriovCapabilities.NumVFs,
+		TotalVFs:         pfSriovCapabilities.TotalVFs,
+		VFTotalMsix:      pfSriovCapabilities.VFTotalMsix,
+		LinkUp:           pfLinkUp,
+		BondName:         bondName,
+		Utilization:      pfUtilization(pfSriovCapabilities.NumVFs, pfSriovCapabilities.TotalVFs),
+		PciRoot:          pciRoot,
+	}
+}
+
+// discoverVFs returns the devices of the VFs of a PF in the VF list order
+func discoverVFs(ctx context.Context, logger klog.Logger, deviceNaming string, pfIndex int, pfInfo PFInfo) ([]resourceapi.Device, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, fmt.Errorf("device discovery cancelled: %w", err)
+	}
+	logger.V(1).Info("Getting VF list for PF", "pf", pfInfo.NetName, "address", pfInfo.Address)
+
+	vfList, err := host.GetHelpers().GetVFList(pfInfo.Address)
+	if err != nil {
+		logger.Error(err, "Failed to get VF list for PF", "pf", pfInfo.NetName, "address", pfInfo.Address)
+		return nil, fmt.Errorf("error getting VF list: %v", err)
+	}
+
+	logger.Info("Found VFs for PF", "pf", pfInfo.NetName, "vfCount", len(vfList))
+	if len(vfList) != pfInfo.NumVFs {
+		logger.V(1).Info("VF count differs from the enabled VFs of the PF", "pf", pfInfo.NetName, "vfCount", len(vfList), "numVFs", pfInfo.NumVFs)
+	}
+
+	pfCapabilities := ProbeCapabilities(pfInfo.Address, pfInfo.TotalVFs)
+	logger.V(2).Info("Probed PF capabilities", "pf", pfInfo.NetName, "capabilities", pfCapabilities)
+
+	devices := make([]resourceapi.Device, 0, len(vfList))
+	for _, vfInfo := range vfList {
+		if err := ctx.Err(); err != nil {
+			return nil, fmt.Errorf("device discovery cancelled: %w", err)
+		}
+		deviceName := getDeviceName(deviceNaming, pfInfo, vfInfo)
+		if errs := validation.IsDNS1123Label(deviceName); len(errs) > 0 {
+			logger.Error(nil, "Device name is not a valid DNS label, skipping VF", "deviceName", deviceName, "vfAddress", vfInfo.PciAddress, "errors", errs)
 			continue
 		}
 
-		pfNetName := host.GetHelpers().TryGetInterfaceName(device.Address)
-		if pfNetName == "" {
-			logger.Error(nil, "Unable to get interface name for device, skipping", "address", device.Address)
-			continue
+		logger.V(2).Info("Adding VF device to resource list",
+			"deviceName", deviceName,
+			"vfAddress", vfInfo.PciAddress,
+			"vfID", vfInfo.VFID,
+			"vfDeviceID", vfInfo.DeviceID,
+			"pfDeviceID", pfInfo.DeviceID,
+			"pf", pfInfo.NetName)
+
+		device := resourceapi.Device{
+			Name: deviceName,
+			Attributes: map[resourceapi.QualifiedName]resourceapi.DeviceAttribute{
+				consts.AttributeVendorID: {
+					StringValue: ptr.To(pfInfo.VendorID),
+				},
+				consts.AttributeDeviceID: {
+					StringValue: ptr.To(vfInfo.DeviceID),
+				},
+				consts.AttributePFDeviceID: {
+					StringValue: ptr.To(pfInfo.DeviceID),
+				},
+				consts.AttributePciAddress: {
+					StringValue: ptr.To(vfInfo.PciAddress),
+				},
+				consts.AttributePFName: {
+					StringValue: ptr.To(pfInfo.NetName),
+				},
+				consts.AttributePFIndex: {
+					IntValue: ptr.To(int64(pfIndex)),
+				},
+				consts.AttributeEswitchMode: {
+					StringValue: ptr.To(pfInfo.EswitchMode),
+				},
+				consts.AttributeVFID: {
+					IntValue: ptr.To(int64(vfInfo.VFID)),
+				},
+				consts.AttributeNumaNode: {
+					IntValue: func() *int64 {
+						numaNodeInt, err := strconv.ParseInt(pfInfo.NumaNode, 10, 64)
+						if err != nil {
+							// Default to -1 if parsing fails
+							return ptr.To(int64(-1))
+						}
+						return ptr.To(numaNodeInt)
+					}(),
+				},
+				consts.AttributeParentPciAddress: {
+					StringValue: ptr.To(pfInfo.ParentPciAddress),
+				},
+			},
+		}
+		for name, attribute := range pfCapabilities {
+			device.Attributes[name] = attribute
+		}
+		if pfInfo.MacAddress != "" {
+			device.Attributes[consts.AttributePFMac] = resourceapi.DeviceAttribute{
+				StringValue: ptr.To(pfInfo.MacAddress),
+			}
+		}
+		// the MSI-X pool of the PF bounds the interrupt vectors, and so the RSS queues, a VF can get
+		if pfInfo.VFTotalMsix != nil {
+			device.Attributes[consts.AttributeVFTotalMsix] = resourceapi.DeviceAttribute{
+				IntValue: ptr.To(int64(*pfInfo.VFTotalMsix)),
+			}
 		}
+		// the share of the enabled VFs lets the schedulers prefer the less loaded PFs
+		if pfInfo.Utilization != nil {
+			device.Attributes[consts.AttributePFUtilization] = resourceapi.DeviceAttribute{
+				IntValue: ptr.To(int64(*pfInfo.Utilization)),
+			}
+		}
+		// the VFs behind the same root port share its PCIe bandwidth
+		if pfInfo.PciRoot != "" {
+			device.Attributes[consts.AttributePciRoot] = resourceapi.DeviceAttribute{
+				StringValue: ptr.To(pfInfo.PciRoot),
+			}
+		}
+		if pfInfo.BondName != "" {
+			device.Attributes[consts.AttributeBondName] = resourceapi.DeviceAttribute{
+				StringValue: ptr.To(pfInfo.BondName),
+			}
+		}
+		if pfInfo.LinkUp != nil {
+			device.Attributes[consts.AttributeLinkUp] = resourceapi.DeviceAttribute{
+				BoolValue: ptr.To(*pfInfo.LinkUp),
+			}
+			setLinkDownTaint(&device, *pfInfo.LinkUp)
+		}
+		// the host keeps the representor of a switchdev VF, publish it so TC/OVS rules can target it
+		if pfInfo.EswitchMode == consts.EswitchModeSwitchdev {
+			if representor, err := host.GetHelpers().GetVFRepresentor(pfInfo.NetName, vfInfo.VFID); err != nil {
+				logger.V(2).Info("Failed to get the VF representor", "pf", pfInfo.NetName, "vfID", vfInfo.VFID, "error", err.Error())
+			} else {
+				device.Attributes[consts.AttributeRepresentor] = resourceapi.DeviceAttribute{
+					StringValue: ptr.To(representor),
+				}
+			}
+		}
+		devices = append(devices, device)
+	}
+	return devices, nil
+}
 
-		eswitchMode := host.GetHelpers().GetNicSriovMode(device.Address)
+// getPCIInfo reads the PCI devices, retrying with pciRetryBackoff as sysfs can be briefly unreadable when the
+// container starts. On the final failure the error says whether the host /sys/bus/pci/devices is usable.
+func getPCIInfo() (*ghw.PCIInfo, error) {
+	logger := klog.LoggerWithName(klog.Background(), "getPCIInfo")
 
-		// Get NUMA node information
-		numaNode, err := host.GetHelpers().GetNumaNode(device.Address)
+	var pci *ghw.PCIInfo
+	err := retry.OnError(pciRetryBackoff, func(error) bool { return true }, func() error {
+		var err error
+		pci, err = host.GetHelpers().PCI()
 		if err != nil {
-			logger.Error(err, "Failed to get NUMA node, using default", "address", device.Address)
-			numaNode = "0" // Default to node 0 if we can't determine it
+			logger.V(2).Info("Failed to get PCI info, retrying", "error", err.Error())
 		}
+		return err
+	})
+	if err == nil {
+		return pci, nil
+	}
 
-		// Get parent PCI address information
-		parentPciAddress, err := host.GetHelpers().GetParentPciAddress(device.Address)
-		if err != nil {
-			logger.Error(err, "Failed to get parent PCI address", "address", device.Address)
-			parentPciAddress = "" // Leave empty if we can't determine it
-		}
-
-		logger.Info("Found SR-IOV PF device",
-			"address", device.Address,
-			"interface", pfNetName,
-			"vendor", device.Vendor.ID,
-			"device", device.Product.ID,
-			"eswitchMode", eswitchMode,
-			"numaNode", numaNode,
-			"parentPciAddress", parentPciAddress)
-
-		pfList = append(pfList, PFInfo{
-			PciAddress:       device.Address,
-			NetName:          pfNetName,
-			VendorID:         device.Vendor.ID,
-			DeviceID:         device.Product.ID,
-			Address:          device.Address,
-			EswitchMode:      eswitchMode,
-			NumaNode:         numaNode,
-			ParentPciAddress: parentPciAddress,
-		})
+	if sysErr := host.GetHelpers().CheckSysBusPci(); sysErr != nil {
+		return nil, fmt.Errorf("error getting PCI info: %v: %v, make sure the host /sys is mounted in the driver container", err, sysErr)
 	}
+	return nil, fmt.Errorf("error getting PCI info: %v", err)
+}
 
-	logger.Info("Processing SR-IOV PF devices", "pfCount", len(pfList))
+// capDevices removes the devices above maxVFs from the resource list and returns the names of the removed devices.
+// The devices are sorted by PCI address so the same VFs are kept across restarts, zero means no limit.
+func capDevices(resourceList types.AllocatableDevices, maxVFs int) []string {
+	if maxVFs == 0 || len(resourceList) <= maxVFs {
+		return nil
+	}
 
-	for _, pfInfo := range pfList {
-		logger.V(1).Info("Getting VF list for PF", "pf", pfInfo.NetName, "address", pfInfo.Address)
+	deviceNames := make([]string, 0, len(resourceList))
+	for deviceName := range resourceList {
+		deviceNames = append(deviceNames, deviceName)
+	}
+	pciAddress := func(deviceName string) string {
+		return *resourceList[deviceName].Attributes[consts.AttributePciAddress].StringValue
+	}
+	sort.Slice(deviceNames, func(i, j int) bool {
+		return pciAddress(deviceNames[i]) < pciAddress(deviceNames[j])
+	})
 
-		vfList, err := host.GetHelpers().GetVFList(pfInfo.Address)
-		if err != nil {
-			logger.Error(err, "Failed to get VF list for PF", "pf", pfInfo.NetName, "address", pfInfo.Address)
-			return nil, fmt.Errorf("error getting VF list: %v", err)
-		}
-
-		logger.Info("Found VFs for PF", "pf", pfInfo.NetName, "vfCount", len(vfList))
-
-		for _, vfInfo := range vfList {
-			deviceName := strings.ReplaceAll(vfInfo.PciAddress, ":", "-")
-			deviceName = strings.ReplaceAll(deviceName, ".", "-")
-
-			logger.V(2).Info("Adding VF device to resource list",
-				"deviceName", deviceName,
-				"vfAddress", vfInfo.PciAddress,
-				"vfID", vfInfo.VFID,
-				"vfDeviceID", vfInfo.DeviceID,
-				"pfDeviceID", pfInfo.DeviceID,
-				"pf", pfInfo.NetName)
-
-			resourceList[deviceName] = resourceapi.Device{
-				Name: deviceName,
-				Attributes: map[resourceapi.QualifiedName]resourceapi.DeviceAttribute{
-					consts.AttributeVendorID: {
-						StringValue: ptr.To(pfInfo.VendorID),
-					},
-					consts.AttributeDeviceID: {
-						StringValue: ptr.To(vfInfo.DeviceID),
-					},
-					consts.AttributePFDeviceID: {
-						StringValue: ptr.To(pfInfo.DeviceID),
-					},
-					consts.AttributePciAddress: {
-						StringValue: ptr.To(vfInfo.PciAddress),
-					},
-					consts.AttributePFName: {
-						StringValue: ptr.To(pfInfo.NetName),
-					},
-					consts.AttributeEswitchMode: {
-						StringValue: ptr.To(pfInfo.EswitchMode),
-					},
-					consts.AttributeVFID: {
-						IntValue: ptr.To(int64(vfInfo.VFID)),
-					},
-					consts.AttributeNumaNode: {
-						IntValue: func() *int64 {
-							numaNodeInt, err := strconv.ParseInt(pfInfo.NumaNode, 10, 64)
-							if err != nil {
-								// Default to -1 if parsing fails
-								return ptr.To(int64(-1))
-							}
-							return ptr.To(numaNodeInt)
-						}(),
-					},
-					consts.AttributeParentPciAddress: {
-						StringValue: ptr.To(pfInfo.ParentPciAddress),
-					},
-				},
-			}
-		}
+	skipped := deviceNames[maxVFs:]
+	for _, deviceName := range skipped {
+		delete(resourceList, deviceName)
 	}
+	return skipped
+}
 
-	logger.Info("SR-IOV device discovery completed", "totalDevices", len(resourceList))
-	return resourceList, nil
+// pfUtilization returns the percentage of the VFs of a PF that are enabled, nil when the PF reports no VFs
+func pfUtilization(numVFs, totalVFs int) *int {
+	if totalVFs <= 0 {
+		return nil
+	}
+	return ptr.To(numVFs * 100 / totalVFs)
+}
+
+// getDeviceName returns the name of the VF device according to the naming scheme:
+// the VF PCI address (0000-3b-02-0) or the PF name and VF index (ens1f0-vf2) which survives PCI renumbering
+func getDeviceName(deviceNaming string, pfInfo PFInfo, vfInfo host.VFInfo) string {
+	if deviceNaming == consts.DeviceNamingPFIndex {
+		return fmt.Sprintf("%s-vf%d", toDNSLabel(pfInfo.NetName), vfInfo.VFID)
+	}
+
+	deviceName := strings.ReplaceAll(vfInfo.PciAddress, ":", "-")
+	return strings.ReplaceAll(deviceName, ".", "-")
+}
+
+// toDNSLabel lowercases the name and replaces the characters not allowed in a DNS label with '-'
+func toDNSLabel(name string) string {
+	label := strings.Map(func(r rune) rune {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') {
+			return r
+		}
+		return '-'
+	}, strings.ToLower(name))
+	return strings.Trim(label, "-")
 }
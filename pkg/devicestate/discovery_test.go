@@ -0,0 +1,560 @@
+package devicestate_test
+
+import (
+	"context"
+
+	"fmt"
+	"io/fs"
+	"time"
+
+	"github.com/jaypipes/ghw"
+	"github.com/jaypipes/ghw/pkg/pci"
+	"github.com/jaypipes/pcidb"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"go.uber.org/mock/gomock"
+	resourceapi "k8s.io/api/resource/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/utils/ptr"
+
+	"github.com/SchSeba/dra-driver-sriov/pkg/consts"
+	"github.com/SchSeba/dra-driver-sriov/pkg/devicestate"
+	"github.com/SchSeba/dra-driver-sriov/pkg/host"
+	mock_host "github.com/SchSeba/dra-driver-sriov/pkg/host/mock"
+)
+
+// newPFDevice returns a network class PCI device usable as a PF in discovery tests
+func newPFDevice(address string) *pci.Device {
+	return &pci.Device{
+		Address: address,
+		Vendor:  &pcidb.Vendor{ID: "8086"},
+		Product: &pcidb.Product{ID: "158b"},
+		Class:   &pcidb.Class{ID: "02"},
+	}
+}
+
+var _ = Describe("DiscoverSriovDevices", func() {
+	var (
+		mockCtrl     *gomock.Controller
+		mockHost     *mock_host.MockInterface
+		originalHost host.Interface
+		// vfTotalMsix is the sriov_vf_total_msix of the PFs set up by expectPF, nil when the file is absent
+		vfTotalMsix *int
+		// totalVFs is the sriov_totalvfs of the PFs set up by expectPF
+		totalVFs int
+	)
+
+	BeforeEach(func() {
+		vfTotalMsix = nil
+		totalVFs = 64
+		mockCtrl = gomock.NewController(GinkgoT())
+		mockHost = mock_host.NewMockInterface(mockCtrl)
+		originalHost = host.GetHelpers()
+		host.Helpers = mockHost
+	})
+
+	AfterEach(func() {
+		host.Helpers = originalHost
+		mockCtrl.Finish()
+	})
+
+	// expectPF sets up the host expectations for a PF and its VFs
+	expectPF := func(pfAddress, pfName, pfMac string, vfs []host.VFInfo) {
+		mockHost.EXPECT().IsSriovVF(pfAddress).Return(false).AnyTimes()
+		mockHost.EXPECT().TryGetInterfaceName(pfAddress).Return(pfName).AnyTimes()
+		mockHost.EXPECT().GetNicSriovMode(pfAddress).Return("legacy").AnyTimes()
+		mockHost.EXPECT().GetSriovNumVFs(pfAddress).Return(len(vfs), nil).AnyTimes()
+		mockHost.EXPECT().GetSriovTotalVFs(pfAddress).DoAndReturn(func(string) (int, error) { return totalVFs, nil }).AnyTimes()
+		mockHost.EXPECT().GetSriovVFTotalMsix(pfAddress).DoAndReturn(func(string) (int, error) {
+			if vfTotalMsix == nil {
+				return 0, fs.ErrNotExist
+			}
+			return *vfTotalMsix, nil
+		}).AnyTimes()
+		mockHost.EXPECT().GetPermanentMacAddress(pfName).Return(pfMac, nil).AnyTimes()
+		mockHost.EXPECT().IsLinkUp(pfName).Return(true, nil).AnyTimes()
+		mockHost.EXPECT().GetBondMaster(pfName).Return("", nil).AnyTimes()
+		mockHost.EXPECT().GetNumaNode(pfAddress).Return("0", nil).AnyTimes()
+		mockHost.EXPECT().GetParentPciAddress(pfAddress).Return("0000:00:01.0", nil).AnyTimes()
+		mockHost.EXPECT().GetPciRootPort(pfAddress).Return("0000:00:01.0", nil).AnyTimes()
+		mockHost.EXPECT().GetVFList(pfAddress).Return(vfs, nil).AnyTimes()
+		mockHost.EXPECT().GetEswitchMode(pfAddress).Return("switchdev", nil).AnyTimes()
+		mockHost.EXPECT().GetDriverByBusAndDevice(pfAddress).Return("ice", nil).AnyTimes()
+	}
+
+	It("should expose the parent PF MAC address on every VF", func() {
+		mockHost.EXPECT().PCI().Return(&ghw.PCIInfo{
+			Devices: []*pci.Device{newPFDevice("0000:01:00.0")},
+		}, nil)
+		expectPF("0000:01:00.0", "eth0", "aa:bb:cc:dd:ee:01", []host.VFInfo{
+			{PciAddress: "0000:01:00.2", VFID: 0, DeviceID: "154c"},
+			{PciAddress: "0000:01:00.3", VFID: 1, DeviceID: "154c"},
+		})
+
+		devices, err := devicestate.DiscoverSriovDevices(context.Background(), consts.DeviceNamingPCI, 0, nil, 0)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(devices).To(HaveLen(2))
+		for _, device := range devices {
+			Expect(device.Attributes).To(HaveKey(resourceapi.QualifiedName(consts.AttributePFMac)))
+			Expect(device.Attributes[consts.AttributePFMac].StringValue).To(Equal(ptr.To("aa:bb:cc:dd:ee:01")))
+			Expect(device.Attributes[consts.AttributeSupportsSwitchdevOffload].BoolValue).To(Equal(ptr.To(true)))
+		}
+	})
+
+	It("should omit the PF MAC address attribute when it cannot be determined", func() {
+		mockHost.EXPECT().PCI().Return(&ghw.PCIInfo{
+			Devices: []*pci.Device{newPFDevice("0000:01:00.0")},
+		}, nil)
+		mockHost.EXPECT().GetPermanentMacAddress("eth0").Return("", fmt.Errorf("no such device"))
+		expectPF("0000:01:00.0", "eth0", "", []host.VFInfo{
+			{PciAddress: "0000:01:00.2", VFID: 0, DeviceID: "154c"},
+		})
+
+		devices, err := devicestate.DiscoverSriovDevices(context.Background(), consts.DeviceNamingPCI, 0, nil, 0)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(devices).To(HaveKey("0000-01-00-2"))
+		Expect(devices["0000-01-00-2"].Attributes).NotTo(HaveKey(resourceapi.QualifiedName(consts.AttributePFMac)))
+	})
+
+	It("should expose the bond of the PFs on the VFs of both legs", func() {
+		mockHost.EXPECT().PCI().Return(&ghw.PCIInfo{
+			Devices: []*pci.Device{newPFDevice("0000:01:00.0"), newPFDevice("0000:01:00.1"), newPFDevice("0000:81:00.0")},
+		}, nil)
+		mockHost.EXPECT().GetBondMaster("eth0").Return("bond0", nil)
+		mockHost.EXPECT().GetBondMaster("eth1").Return("bond0", nil)
+		mockHost.EXPECT().GetBondMaster("eth2").Return("", fmt.Errorf("permission denied"))
+		expectPF("0000:01:00.0", "eth0", "aa:bb:cc:dd:ee:01", []host.VFInfo{
+			{PciAddress: "0000:01:00.2", VFID: 0, DeviceID: "154c"},
+		})
+		expectPF("0000:01:00.1", "eth1", "aa:bb:cc:dd:ee:02", []host.VFInfo{
+			{PciAddress: "0000:01:01.2", VFID: 0, DeviceID: "154c"},
+		})
+		expectPF("0000:81:00.0", "eth2", "aa:bb:cc:dd:ee:03", []host.VFInfo{
+			{PciAddress: "0000:81:00.2", VFID: 0, DeviceID: "154c"},
+		})
+
+		devices, err := devicestate.DiscoverSriovDevices(context.Background(), consts.DeviceNamingPCI, 0, nil, 0)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(devices["0000-01-00-2"].Attributes[consts.AttributeBondName].StringValue).To(Equal(ptr.To("bond0")))
+		Expect(devices["0000-01-01-2"].Attributes[consts.AttributeBondName].StringValue).To(Equal(ptr.To("bond0")))
+		Expect(devices["0000-81-00-2"].Attributes).NotTo(HaveKey(resourceapi.QualifiedName(consts.AttributeBondName)))
+	})
+
+	It("should expose the same PCI root port on the VFs behind the same root port", func() {
+		mockHost.EXPECT().PCI().Return(&ghw.PCIInfo{
+			Devices: []*pci.Device{newPFDevice("0000:01:00.0"), newPFDevice("0000:02:00.0"), newPFDevice("0000:81:00.0"), newPFDevice("0000:82:00.0")},
+		}, nil)
+		// the first two PFs are behind a switch under the same root port
+		mockHost.EXPECT().GetPciRootPort("0000:01:00.0").Return("0000:00:01.0", nil)
+		mockHost.EXPECT().GetPciRootPort("0000:02:00.0").Return("0000:00:01.0", nil)
+		mockHost.EXPECT().GetPciRootPort("0000:81:00.0").Return("0000:80:03.0", nil)
+		mockHost.EXPECT().GetPciRootPort("0000:82:00.0").Return("", fmt.Errorf("no PCI host bridge"))
+		for i, pfAddress := range []string{"0000:01:00.0", "0000:02:00.0", "0000:81:00.0", "0000:82:00.0"} {
+			expectPF(pfAddress, fmt.Sprintf("eth%d", i), "aa:bb:cc:dd:ee:01", []host.VFInfo{
+				{PciAddress: pfAddress[:8] + "00.2", VFID: 0, DeviceID: "154c"},
+				{PciAddress: pfAddress[:8] + "00.3", VFID: 1, DeviceID: "154c"},
+			})
+		}
+
+		devices, err := devicestate.DiscoverSriovDevices(context.Background(), consts.DeviceNamingPCI, 0, nil, 0)
+		Expect(err).NotTo(HaveOccurred())
+		for _, deviceName := range []string{"0000-01-00-2", "0000-01-00-3", "0000-02-00-2", "0000-02-00-3"} {
+			Expect(devices[deviceName].Attributes[consts.AttributePciRoot].StringValue).To(Equal(ptr.To("0000:00:01.0")), deviceName)
+		}
+		Expect(devices["0000-81-00-2"].Attributes[consts.AttributePciRoot].StringValue).To(Equal(ptr.To("0000:80:03.0")))
+		Expect(devices["0000-81-00-3"].Attributes[consts.AttributePciRoot].StringValue).To(Equal(ptr.To("0000:80:03.0")))
+		Expect(devices["0000-82-00-2"].Attributes).NotTo(HaveKey(resourceapi.QualifiedName(consts.AttributePciRoot)))
+	})
+
+	It("should expose the PF link state and omit it when it cannot be determined", func() {
+		mockHost.EXPECT().PCI().Return(&ghw.PCIInfo{
+			Devices: []*pci.Device{newPFDevice("0000:01:00.0"), newPFDevice("0000:81:00.0")},
+		}, nil)
+		mockHost.EXPECT().IsLinkUp("eth0").Return(false, nil)
+		mockHost.EXPECT().IsLinkUp("eth1").Return(false, fmt.Errorf("no such device"))
+		expectPF("0000:01:00.0", "eth0", "aa:bb:cc:dd:ee:01", []host.VFInfo{
+			{PciAddress: "0000:01:00.2", VFID: 0, DeviceID: "154c"},
+		})
+		expectPF("0000:81:00.0", "eth1", "aa:bb:cc:dd:ee:02", []host.VFInfo{
+			{PciAddress: "0000:81:00.2", VFID: 0, DeviceID: "154c"},
+		})
+
+		devices, err := devicestate.DiscoverSriovDevices(context.Background(), consts.DeviceNamingPCI, 0, nil, 0)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(devices["0000-01-00-2"].Attributes[consts.AttributeLinkUp].BoolValue).To(Equal(ptr.To(false)))
+		Expect(devices["0000-81-00-2"].Attributes).NotTo(HaveKey(resourceapi.QualifiedName(consts.AttributeLinkUp)))
+	})
+
+	It("should assign PF indices sorted by PCI address", func() {
+		mockHost.EXPECT().PCI().Return(&ghw.PCIInfo{
+			Devices: []*pci.Device{
+				newPFDevice("0000:81:00.0"),
+				newPFDevice("0000:01:00.0"),
+			},
+		}, nil)
+		expectPF("0000:81:00.0", "eth1", "aa:bb:cc:dd:ee:02", []host.VFInfo{
+			{PciAddress: "0000:81:00.2", VFID: 0, DeviceID: "154c"},
+		})
+		expectPF("0000:01:00.0", "eth0", "aa:bb:cc:dd:ee:01", []host.VFInfo{
+			{PciAddress: "0000:01:00.2", VFID: 0, DeviceID: "154c"},
+		})
+
+		devices, err := devicestate.DiscoverSriovDevices(context.Background(), consts.DeviceNamingPCI, 0, nil, 0)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(devices).To(HaveLen(2))
+		Expect(devices["0000-01-00-2"].Attributes[consts.AttributePFIndex].IntValue).To(Equal(ptr.To(int64(0))))
+		Expect(devices["0000-81-00-2"].Attributes[consts.AttributePFIndex].IntValue).To(Equal(ptr.To(int64(1))))
+	})
+
+	It("should read the PF SR-IOV capabilities once per PF", func() {
+		mockHost.EXPECT().PCI().Return(&ghw.PCIInfo{
+			Devices: []*pci.Device{newPFDevice("0000:01:00.0")},
+		}, nil)
+		mockHost.EXPECT().IsSriovVF("0000:01:00.0").Return(false)
+		mockHost.EXPECT().TryGetInterfaceName("0000:01:00.0").Return("eth0")
+		mockHost.EXPECT().GetSriovNumVFs("0000:01:00.0").Return(3, nil).Times(1)
+		mockHost.EXPECT().GetSriovTotalVFs("0000:01:00.0").Return(64, nil).Times(1)
+		mockHost.EXPECT().GetSriovVFTotalMsix("0000:01:00.0").Return(0, fs.ErrNotExist).Times(1)
+		mockHost.EXPECT().GetNicSriovMode("0000:01:00.0").Return("switchdev").Times(1)
+		mockHost.EXPECT().GetPermanentMacAddress("eth0").Return("aa:bb:cc:dd:ee:01", nil)
+		mockHost.EXPECT().IsLinkUp("eth0").Return(true, nil)
+		mockHost.EXPECT().GetBondMaster("eth0").Return("", nil)
+		mockHost.EXPECT().GetNumaNode("0000:01:00.0").Return("0", nil)
+		mockHost.EXPECT().GetParentPciAddress("0000:01:00.0").Return("0000:00:01.0", nil)
+		mockHost.EXPECT().GetPciRootPort("0000:01:00.0").Return("0000:00:01.0", nil)
+		mockHost.EXPECT().GetEswitchMode("0000:01:00.0").Return("switchdev", nil)
+		mockHost.EXPECT().GetDriverByBusAndDevice("0000:01:00.0").Return("ice", nil)
+		mockHost.EXPECT().GetVFList("0000:01:00.0").Return([]host.VFInfo{
+			{PciAddress: "0000:01:00.2", VFID: 0, DeviceID: "154c"},
+			{PciAddress: "0000:01:00.3", VFID: 1, DeviceID: "154c"},
+			{PciAddress: "0000:01:00.4", VFID: 2, DeviceID: "154c"},
+		}, nil)
+		mockHost.EXPECT().GetVFRepresentor("eth0", 0).Return("eth0_0", nil)
+		mockHost.EXPECT().GetVFRepresentor("eth0", 1).Return("eth0_1", nil)
+		mockHost.EXPECT().GetVFRepresentor("eth0", 2).Return("", fmt.Errorf("no representor found for VF 2 on PF eth0"))
+
+		devices, err := devicestate.DiscoverSriovDevices(context.Background(), consts.DeviceNamingPCI, 0, nil, 0)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(devices).To(HaveLen(3))
+		for _, device := range devices {
+			Expect(device.Attributes[consts.AttributeEswitchMode].StringValue).To(Equal(ptr.To("switchdev")))
+		}
+		Expect(devices["0000-01-00-2"].Attributes[consts.AttributeRepresentor].StringValue).To(Equal(ptr.To("eth0_0")))
+		Expect(devices["0000-01-00-3"].Attributes[consts.AttributeRepresentor].StringValue).To(Equal(ptr.To("eth0_1")))
+		Expect(devices["0000-01-00-4"].Attributes).NotTo(HaveKey(resourceapi.QualifiedName(consts.AttributeRepresentor)))
+	})
+
+	It("should not look up representors on a legacy PF", func() {
+		mockHost.EXPECT().PCI().Return(&ghw.PCIInfo{
+			Devices: []*pci.Device{newPFDevice("0000:01:00.0")},
+		}, nil)
+		expectPF("0000:01:00.0", "eth0", "aa:bb:cc:dd:ee:01", []host.VFInfo{
+			{PciAddress: "0000:01:00.2", VFID: 0, DeviceID: "154c"},
+		})
+
+		devices, err := devicestate.DiscoverSriovDevices(context.Background(), consts.DeviceNamingPCI, 0, nil, 0)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(devices["0000-01-00-2"].Attributes).NotTo(HaveKey(resourceapi.QualifiedName(consts.AttributeRepresentor)))
+	})
+
+	It("should expose the MSI-X vectors of the PF on every VF", func() {
+		vfTotalMsix = ptr.To(1024)
+		mockHost.EXPECT().PCI().Return(&ghw.PCIInfo{
+			Devices: []*pci.Device{newPFDevice("0000:01:00.0")},
+		}, nil)
+		expectPF("0000:01:00.0", "eth0", "aa:bb:cc:dd:ee:01", []host.VFInfo{
+			{PciAddress: "0000:01:00.2", VFID: 0, DeviceID: "154c"},
+			{PciAddress: "0000:01:00.3", VFID: 1, DeviceID: "154c"},
+		})
+
+		devices, err := devicestate.DiscoverSriovDevices(context.Background(), consts.DeviceNamingPCI, 0, nil, 0)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(devices).To(HaveLen(2))
+		for _, device := range devices {
+			Expect(device.Attributes[consts.AttributeVFTotalMsix].IntValue).To(Equal(ptr.To(int64(1024))))
+		}
+	})
+
+	It("should omit the MSI-X vectors when the PF doesn't expose them", func() {
+		mockHost.EXPECT().PCI().Return(&ghw.PCIInfo{
+			Devices: []*pci.Device{newPFDevice("0000:01:00.0")},
+		}, nil)
+		expectPF("0000:01:00.0", "eth0", "aa:bb:cc:dd:ee:01", []host.VFInfo{
+			{PciAddress: "0000:01:00.2", VFID: 0, DeviceID: "154c"},
+		})
+
+		devices, err := devicestate.DiscoverSriovDevices(context.Background(), consts.DeviceNamingPCI, 0, nil, 0)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(devices["0000-01-00-2"].Attributes).NotTo(HaveKey(resourceapi.QualifiedName(consts.AttributeVFTotalMsix)))
+	})
+
+	It("should expose the share of the enabled VFs of the PF on every VF", func() {
+		totalVFs = 8
+		mockHost.EXPECT().PCI().Return(&ghw.PCIInfo{
+			Devices: []*pci.Device{newPFDevice("0000:01:00.0")},
+		}, nil)
+		expectPF("0000:01:00.0", "eth0", "aa:bb:cc:dd:ee:01", []host.VFInfo{
+			{PciAddress: "0000:01:00.2", VFID: 0, DeviceID: "154c"},
+			{PciAddress: "0000:01:00.3", VFID: 1, DeviceID: "154c"},
+			{PciAddress: "0000:01:00.4", VFID: 2, DeviceID: "154c"},
+			{PciAddress: "0000:01:00.5", VFID: 3, DeviceID: "154c"},
+		})
+
+		devices, err := devicestate.DiscoverSriovDevices(context.Background(), consts.DeviceNamingPCI, 0, nil, 0)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(devices).To(HaveLen(4))
+		for _, device := range devices {
+			Expect(device.Attributes[consts.AttributePFUtilization].IntValue).To(Equal(ptr.To(int64(50))))
+		}
+	})
+
+	It("should omit the PF utilization when the PF reports no VFs", func() {
+		totalVFs = 0
+		mockHost.EXPECT().PCI().Return(&ghw.PCIInfo{
+			Devices: []*pci.Device{newPFDevice("0000:01:00.0")},
+		}, nil)
+		expectPF("0000:01:00.0", "eth0", "aa:bb:cc:dd:ee:01", []host.VFInfo{
+			{PciAddress: "0000:01:00.2", VFID: 0, DeviceID: "154c"},
+		})
+
+		devices, err := devicestate.DiscoverSriovDevices(context.Background(), consts.DeviceNamingPCI, 0, nil, 0)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(devices["0000-01-00-2"].Attributes).NotTo(HaveKey(resourceapi.QualifiedName(consts.AttributePFUtilization)))
+	})
+
+	It("should skip the reserved VFs and keep their siblings", func() {
+		mockHost.EXPECT().PCI().Return(&ghw.PCIInfo{
+			Devices: []*pci.Device{newPFDevice("0000:01:00.0")},
+		}, nil)
+		expectPF("0000:01:00.0", "eth0", "aa:bb:cc:dd:ee:01", []host.VFInfo{
+			{PciAddress: "0000:01:00.2", VFID: 0, DeviceID: "154c"},
+			{PciAddress: "0000:01:00.3", VFID: 1, DeviceID: "154c"},
+			{PciAddress: "0000:01:00.4", VFID: 2, DeviceID: "154c"},
+		})
+
+		// the address of the PF and an address sharing a prefix with a VF are not matched
+		filter := devicestate.NewDenyFilter([]string{"0000:01:00.3", "0000:01:00.0", "0000:01:00.2x"})
+		devices, err := devicestate.DiscoverSriovDevices(context.Background(), consts.DeviceNamingPCI, 0, filter, 0)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(devices).To(HaveLen(2))
+		Expect(devices).To(HaveKey("0000-01-00-2"))
+		Expect(devices).To(HaveKey("0000-01-00-4"))
+	})
+
+	It("should abort the discovery once the context is cancelled", func() {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		mockHost.EXPECT().PCI().Return(&ghw.PCIInfo{
+			Devices: []*pci.Device{newPFDevice("0000:01:00.0"), newPFDevice("0000:02:00.0")},
+		}, nil)
+		// the context is cancelled while the first PF is walked by the single worker, the second PF has no expectations
+		mockHost.EXPECT().IsLinkUp("eth0").DoAndReturn(func(string) (bool, error) {
+			cancel()
+			return true, nil
+		})
+		expectPF("0000:01:00.0", "eth0", "aa:bb:cc:dd:ee:01", []host.VFInfo{
+			{PciAddress: "0000:01:00.2", VFID: 0, DeviceID: "154c"},
+		})
+
+		_, err := devicestate.DiscoverSriovDevices(ctx, consts.DeviceNamingPCI, 0, nil, 1)
+		Expect(err).To(MatchError(context.Canceled))
+		Expect(err).To(MatchError(ContainSubstring("device discovery cancelled")))
+	})
+
+	Context("PCI info failures", func() {
+		BeforeEach(func() {
+			DeferCleanup(devicestate.SetPCIRetryBackoff(wait.Backoff{Duration: time.Millisecond, Steps: 3}))
+		})
+
+		It("should retry a failing PCI info read", func() {
+			gomock.InOrder(
+				mockHost.EXPECT().PCI().Return(nil, fmt.Errorf("unable to read /sys/bus/pci/devices")),
+				mockHost.EXPECT().PCI().Return(&ghw.PCIInfo{
+					Devices: []*pci.Device{newPFDevice("0000:01:00.0")},
+				}, nil),
+			)
+			expectPF("0000:01:00.0", "eth0", "aa:bb:cc:dd:ee:01", []host.VFInfo{
+				{PciAddress: "0000:01:00.2", VFID: 0, DeviceID: "154c"},
+			})
+
+			devices, err := devicestate.DiscoverSriovDevices(context.Background(), consts.DeviceNamingPCI, 0, nil, 0)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(devices).To(HaveLen(1))
+		})
+
+		It("should report an unusable sysfs after the last attempt", func() {
+			mockHost.EXPECT().PCI().Return(nil, fmt.Errorf("unable to read /sys/bus/pci/devices")).Times(3)
+			mockHost.EXPECT().CheckSysBusPci().Return(fmt.Errorf("/sys/bus/pci/devices is not readable: %w", fs.ErrNotExist))
+
+			_, err := devicestate.DiscoverSriovDevices(context.Background(), consts.DeviceNamingPCI, 0, nil, 0)
+			Expect(err).To(MatchError(ContainSubstring("make sure the host /sys is mounted in the driver container")))
+			Expect(err).To(MatchError(ContainSubstring("/sys/bus/pci/devices is not readable")))
+		})
+
+		It("should return the PCI info error when sysfs is usable", func() {
+			mockHost.EXPECT().PCI().Return(nil, fmt.Errorf("unexpected PCI class file")).Times(3)
+			mockHost.EXPECT().CheckSysBusPci().Return(nil)
+
+			_, err := devicestate.DiscoverSriovDevices(context.Background(), consts.DeviceNamingPCI, 0, nil, 0)
+			Expect(err).To(MatchError("error getting PCI info: unexpected PCI class file"))
+		})
+	})
+
+	Context("device naming", func() {
+		BeforeEach(func() {
+			mockHost.EXPECT().PCI().Return(&ghw.PCIInfo{
+				Devices: []*pci.Device{newPFDevice("0000:3b:00.0")},
+			}, nil).AnyTimes()
+		})
+
+		expectValidNames := func(devices map[string]resourceapi.Device) {
+			for name, device := range devices {
+				Expect(validation.IsDNS1123Label(name)).To(BeEmpty())
+				Expect(device.Name).To(Equal(name))
+			}
+		}
+
+		It("should name the devices after the VF PCI address with the pci scheme", func() {
+			expectPF("0000:3b:00.0", "ens1f0", "aa:bb:cc:dd:ee:01", []host.VFInfo{
+				{PciAddress: "0000:3b:02.0", VFID: 0, DeviceID: "154c"},
+				{PciAddress: "0000:3b:02.1", VFID: 1, DeviceID: "154c"},
+			})
+
+			devices, err := devicestate.DiscoverSriovDevices(context.Background(), consts.DeviceNamingPCI, 0, nil, 0)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(devices).To(HaveKey("0000-3b-02-0"))
+			Expect(devices).To(HaveKey("0000-3b-02-1"))
+			expectValidNames(devices)
+		})
+
+		It("should name the devices after the PF name and VF index with the pfindex scheme", func() {
+			expectPF("0000:3b:00.0", "ens1f0", "aa:bb:cc:dd:ee:01", []host.VFInfo{
+				{PciAddress: "0000:3b:02.0", VFID: 0, DeviceID: "154c"},
+				{PciAddress: "0000:3b:02.1", VFID: 1, DeviceID: "154c"},
+			})
+
+			devices, err := devicestate.DiscoverSriovDevices(context.Background(), consts.DeviceNamingPFIndex, 0, nil, 0)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(devices).To(HaveKey("ens1f0-vf0"))
+			Expect(devices).To(HaveKey("ens1f0-vf1"))
+			Expect(devices["ens1f0-vf1"].Attributes[consts.AttributePciAddress].StringValue).To(Equal(ptr.To("0000:3b:02.1")))
+			expectValidNames(devices)
+		})
+
+		It("should make the PF name DNS label safe with the pfindex scheme", func() {
+			expectPF("0000:3b:00.0", "Uplink_0.", "aa:bb:cc:dd:ee:01", []host.VFInfo{
+				{PciAddress: "0000:3b:02.0", VFID: 3, DeviceID: "154c"},
+			})
+
+			devices, err := devicestate.DiscoverSriovDevices(context.Background(), consts.DeviceNamingPFIndex, 0, nil, 0)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(devices).To(HaveKey("uplink-0-vf3"))
+			expectValidNames(devices)
+		})
+
+		It("should reject an unknown naming scheme", func() {
+			_, err := devicestate.DiscoverSriovDevices(context.Background(), "serial", 0, nil, 0)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring(`unknown device naming scheme "serial"`))
+		})
+	})
+
+	Context("max VFs per node", func() {
+		BeforeEach(func() {
+			mockHost.EXPECT().PCI().Return(&ghw.PCIInfo{
+				Devices: []*pci.Device{
+					newPFDevice("0000:81:00.0"),
+					newPFDevice("0000:01:00.0"),
+				},
+			}, nil).AnyTimes()
+			// the VF lists are not sorted by PCI address to check the selection does not depend on the host order
+			expectPF("0000:81:00.0", "eth1", "aa:bb:cc:dd:ee:02", []host.VFInfo{
+				{PciAddress: "0000:81:00.3", VFID: 1, DeviceID: "154c"},
+				{PciAddress: "0000:81:00.2", VFID: 0, DeviceID: "154c"},
+			})
+			expectPF("0000:01:00.0", "eth0", "aa:bb:cc:dd:ee:01", []host.VFInfo{
+				{PciAddress: "0000:01:00.4", VFID: 2, DeviceID: "154c"},
+				{PciAddress: "0000:01:00.2", VFID: 0, DeviceID: "154c"},
+				{PciAddress: "0000:01:00.3", VFID: 1, DeviceID: "154c"},
+			})
+		})
+
+		It("should advertise only the VFs with the lowest PCI addresses", func() {
+			devices, err := devicestate.DiscoverSriovDevices(context.Background(), consts.DeviceNamingPCI, 4, nil, 0)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(devices).To(HaveLen(4))
+			Expect(devices).To(HaveKey("0000-01-00-2"))
+			Expect(devices).To(HaveKey("0000-01-00-3"))
+			Expect(devices).To(HaveKey("0000-01-00-4"))
+			Expect(devices).To(HaveKey("0000-81-00-2"))
+		})
+
+		It("should select the same VFs on every discovery", func() {
+			first, err := devicestate.DiscoverSriovDevices(context.Background(), consts.DeviceNamingPFIndex, 3, nil, 0)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(first).To(HaveLen(3))
+			for range 5 {
+				devices, err := devicestate.DiscoverSriovDevices(context.Background(), consts.DeviceNamingPFIndex, 3, nil, 0)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(devices).To(Equal(first))
+			}
+			Expect(first).To(HaveKey("eth0-vf0"))
+			Expect(first).To(HaveKey("eth0-vf1"))
+			Expect(first).To(HaveKey("eth0-vf2"))
+		})
+
+		It("should advertise all the VFs when the cap is not reached", func() {
+			devices, err := devicestate.DiscoverSriovDevices(context.Background(), consts.DeviceNamingPCI, 10, nil, 0)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(devices).To(HaveLen(5))
+		})
+
+		It("should reject a negative cap", func() {
+			_, err := devicestate.DiscoverSriovDevices(context.Background(), consts.DeviceNamingPCI, -1, nil, 0)
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Context("discovery concurrency", func() {
+		BeforeEach(func() {
+			pfDevices := []*pci.Device{}
+			for pf := 0; pf < 16; pf++ {
+				pfAddress := fmt.Sprintf("0000:%02x:00.0", 0x81-pf)
+				pfDevices = append(pfDevices, newPFDevice(pfAddress))
+				vfs := []host.VFInfo{}
+				for vf := 0; vf < 8; vf++ {
+					vfs = append(vfs, host.VFInfo{PciAddress: fmt.Sprintf("0000:%02x:01.%d", 0x81-pf, vf), VFID: vf, DeviceID: "154c"})
+				}
+				expectPF(pfAddress, fmt.Sprintf("eth%d", pf), fmt.Sprintf("aa:bb:cc:dd:ee:%02x", pf), vfs)
+			}
+			mockHost.EXPECT().PCI().Return(&ghw.PCIInfo{Devices: pfDevices}, nil).AnyTimes()
+		})
+
+		It("should discover the same devices as a serial discovery", func() {
+			for _, naming := range []string{consts.DeviceNamingPCI, consts.DeviceNamingPFIndex} {
+				serial, err := devicestate.DiscoverSriovDevices(context.Background(), naming, 0, nil, 1)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(serial).To(HaveLen(128))
+
+				for _, concurrency := range []int{0, 4, 32} {
+					parallel, err := devicestate.DiscoverSriovDevices(context.Background(), naming, 0, nil, concurrency)
+					Expect(err).NotTo(HaveOccurred())
+					Expect(parallel).To(Equal(serial), "naming %s, concurrency %d", naming, concurrency)
+				}
+			}
+		})
+
+		It("should keep the PF indices sorted by PCI address", func() {
+			devices, err := devicestate.DiscoverSriovDevices(context.Background(), consts.DeviceNamingPCI, 0, nil, 8)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(devices["0000-72-01-0"].Attributes[consts.AttributePFIndex].IntValue).To(Equal(ptr.To(int64(0))))
+			Expect(devices["0000-81-01-7"].Attributes[consts.AttributePFIndex].IntValue).To(Equal(ptr.To(int64(15))))
+		})
+
+		It("should reject a negative concurrency", func() {
+			_, err := devicestate.DiscoverSriovDevices(context.Background(), consts.DeviceNamingPCI, 0, nil, -1)
+			Expect(err).To(MatchError(ContainSubstring("invalid discovery concurrency -1")))
+		})
+	})
+})
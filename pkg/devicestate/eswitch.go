@@ -0,0 +1,93 @@
+package devicestate
+
+import (
+	"fmt"
+	"strconv"
+
+	resourceapi "k8s.io/api/resource/v1"
+	"k8s.io/klog/v2"
+	"k8s.io/utils/ptr"
+
+	"github.com/SchSeba/dra-driver-sriov/pkg/consts"
+	"github.com/SchSeba/dra-driver-sriov/pkg/host"
+)
+
+// ManageEswitchMode sets the eswitch mode of the SR-IOV capable PFs of the node to the given mode when it differs.
+// Only the PFs kept by the device filter are managed, they are matched by their PCI address and netdev name
+// like the VFs are matched by their parent PF, a nil filter keeps every PF.
+//
+// Changing the eswitch mode destroys the VFs of the PF and the driver doesn't manage a VF pool config
+// to recreate them, so the mode of a PF with enabled VFs is never changed. A PF failing to be changed is
+// logged and skipped, the other PFs are still managed and the driver starts.
+func ManageEswitchMode(mode string, filter DeviceFilter) error {
+	logger := klog.LoggerWithName(klog.Background(), "ManageEswitchMode")
+	if mode != consts.EswitchModeLegacy && mode != consts.EswitchModeSwitchdev {
+		return fmt.Errorf("unknown eswitch mode %q, must be %s or %s", mode, consts.EswitchModeLegacy, consts.EswitchModeSwitchdev)
+	}
+
+	pci, err := host.GetHelpers().PCI()
+	if err != nil {
+		return fmt.Errorf("error getting PCI info: %v", err)
+	}
+
+	if refreshable, ok := filter.(RefreshableFilter); ok {
+		if err := refreshable.Refresh(); err != nil {
+			return fmt.Errorf("failed to refresh the device filters: %w", err)
+		}
+	}
+
+	for _, device := range pci.Devices {
+		devClass, err := strconv.ParseInt(device.Class.ID, 16, 64)
+		if err != nil || devClass != consts.NetClass {
+			continue
+		}
+		if host.GetHelpers().IsSriovVF(device.Address) {
+			continue
+		}
+		if totalVFs, err := host.GetHelpers().GetSriovTotalVFs(device.Address); err != nil || totalVFs == 0 {
+			logger.V(3).Info("Skipping PF without SR-IOV support", "address", device.Address)
+			continue
+		}
+
+		if filter != nil && !filter.Keep(pfFilterDevice(device.Address)) {
+			logger.V(2).Info("Skipping PF dropped by the device filters", "address", device.Address)
+			continue
+		}
+
+		currentMode := host.GetHelpers().GetNicSriovMode(device.Address)
+		if currentMode == mode {
+			logger.V(2).Info("PF already in the requested eswitch mode", "address", device.Address, "mode", mode)
+			continue
+		}
+
+		numVFs, err := host.GetHelpers().GetSriovNumVFs(device.Address)
+		if err != nil {
+			logger.Error(err, "Skipping PF, failed to get its enabled VFs", "address", device.Address)
+			continue
+		}
+		if numVFs > 0 {
+			logger.Error(nil, "Skipping PF with enabled VFs, they must be removed before changing its eswitch mode",
+				"address", device.Address, "numVFs", numVFs, "from", currentMode, "to", mode)
+			continue
+		}
+
+		logger.Info("Changing PF eswitch mode", "address", device.Address, "from", currentMode, "to", mode)
+		if err := host.GetHelpers().SetNicSriovMode(device.Address, mode); err != nil {
+			logger.Error(err, "Skipping PF, failed to change its eswitch mode", "address", device.Address, "from", currentMode, "to", mode)
+		}
+	}
+
+	return nil
+}
+
+// pfFilterDevice returns the device the filters decide on for a PF, its PCI address and netdev name
+// take the place of the PCI address and parent PF name of a VF
+func pfFilterDevice(pciAddress string) resourceapi.Device {
+	attributes := map[resourceapi.QualifiedName]resourceapi.DeviceAttribute{
+		consts.AttributePciAddress: {StringValue: ptr.To(pciAddress)},
+	}
+	if pfName := host.GetHelpers().TryGetInterfaceName(pciAddress); pfName != "" {
+		attributes[consts.AttributePFName] = resourceapi.DeviceAttribute{StringValue: ptr.To(pfName)}
+	}
+	return resourceapi.Device{Attributes: attributes}
+}
@@ -0,0 +1,105 @@
+package devicestate_test
+
+import (
+	"fmt"
+
+	"github.com/jaypipes/ghw"
+	"github.com/jaypipes/ghw/pkg/pci"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"go.uber.org/mock/gomock"
+
+	"github.com/SchSeba/dra-driver-sriov/pkg/devicestate"
+	"github.com/SchSeba/dra-driver-sriov/pkg/host"
+	mock_host "github.com/SchSeba/dra-driver-sriov/pkg/host/mock"
+)
+
+var _ = Describe("ManageEswitchMode", func() {
+	var (
+		mockCtrl     *gomock.Controller
+		mockHost     *mock_host.MockInterface
+		originalHost host.Interface
+	)
+
+	BeforeEach(func() {
+		mockCtrl = gomock.NewController(GinkgoT())
+		mockHost = mock_host.NewMockInterface(mockCtrl)
+		originalHost = host.GetHelpers()
+		host.Helpers = mockHost
+
+		mockHost.EXPECT().PCI().Return(&ghw.PCIInfo{
+			Devices: []*pci.Device{newPFDevice("0000:01:00.0")},
+		}, nil).AnyTimes()
+		mockHost.EXPECT().IsSriovVF("0000:01:00.0").Return(false).AnyTimes()
+		mockHost.EXPECT().TryGetInterfaceName("0000:01:00.0").Return("eth0").AnyTimes()
+	})
+
+	AfterEach(func() {
+		host.Helpers = originalHost
+		mockCtrl.Finish()
+	})
+
+	It("should skip a PF already in the requested mode", func() {
+		mockHost.EXPECT().GetSriovTotalVFs("0000:01:00.0").Return(64, nil)
+		mockHost.EXPECT().GetNicSriovMode("0000:01:00.0").Return("switchdev")
+
+		Expect(devicestate.ManageEswitchMode("switchdev", nil)).To(Succeed())
+	})
+
+	It("should change the mode of a PF without VFs", func() {
+		mockHost.EXPECT().GetSriovTotalVFs("0000:01:00.0").Return(64, nil)
+		mockHost.EXPECT().GetNicSriovMode("0000:01:00.0").Return("legacy")
+		mockHost.EXPECT().GetSriovNumVFs("0000:01:00.0").Return(0, nil)
+		mockHost.EXPECT().SetNicSriovMode("0000:01:00.0", "switchdev").Return(nil)
+
+		Expect(devicestate.ManageEswitchMode("switchdev", nil)).To(Succeed())
+	})
+
+	It("should skip a PF with VFs without failing", func() {
+		mockHost.EXPECT().GetSriovTotalVFs("0000:01:00.0").Return(64, nil)
+		mockHost.EXPECT().GetNicSriovMode("0000:01:00.0").Return("legacy")
+		mockHost.EXPECT().GetSriovNumVFs("0000:01:00.0").Return(4, nil)
+		mockHost.EXPECT().SetNicSriovMode(gomock.Any(), gomock.Any()).Times(0)
+
+		Expect(devicestate.ManageEswitchMode("switchdev", nil)).To(Succeed())
+	})
+
+	It("should skip a PF failing to be changed without failing", func() {
+		mockHost.EXPECT().GetSriovTotalVFs("0000:01:00.0").Return(64, nil)
+		mockHost.EXPECT().GetNicSriovMode("0000:01:00.0").Return("legacy")
+		mockHost.EXPECT().GetSriovNumVFs("0000:01:00.0").Return(0, nil)
+		mockHost.EXPECT().SetNicSriovMode("0000:01:00.0", "switchdev").Return(fmt.Errorf("operation not supported"))
+
+		Expect(devicestate.ManageEswitchMode("switchdev", nil)).To(Succeed())
+	})
+
+	It("should skip a PF dropped by the device filters", func() {
+		mockHost.EXPECT().GetSriovTotalVFs("0000:01:00.0").Return(64, nil)
+		mockHost.EXPECT().GetDefaultRouteInterfaces().Return([]string{"eth0"}, nil)
+		mockHost.EXPECT().GetNicSriovMode(gomock.Any()).Times(0)
+
+		Expect(devicestate.ManageEswitchMode("switchdev", devicestate.NewDefaultRouteFilter(nil))).To(Succeed())
+	})
+
+	It("should change the mode of a PF kept by the device filters", func() {
+		mockHost.EXPECT().GetSriovTotalVFs("0000:01:00.0").Return(64, nil)
+		mockHost.EXPECT().GetDefaultRouteInterfaces().Return([]string{"eth1"}, nil)
+		mockHost.EXPECT().GetNicSriovMode("0000:01:00.0").Return("legacy")
+		mockHost.EXPECT().GetSriovNumVFs("0000:01:00.0").Return(0, nil)
+		mockHost.EXPECT().SetNicSriovMode("0000:01:00.0", "switchdev").Return(nil)
+
+		Expect(devicestate.ManageEswitchMode("switchdev", devicestate.NewDefaultRouteFilter(nil))).To(Succeed())
+	})
+
+	It("should skip a PF without SR-IOV support", func() {
+		mockHost.EXPECT().GetSriovTotalVFs("0000:01:00.0").Return(0, nil)
+
+		Expect(devicestate.ManageEswitchMode("switchdev", nil)).To(Succeed())
+	})
+
+	It("should reject an unknown mode", func() {
+		err := devicestate.ManageEswitchMode("offload", nil)
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).To(ContainSubstring(`unknown eswitch mode "offload"`))
+	})
+})
@@ -0,0 +1,57 @@
+package devicestate
+
+import (
+	"context"
+	"net"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/utils/clock"
+
+	"github.com/SchSeba/dra-driver-sriov/pkg/host"
+	"github.com/SchSeba/dra-driver-sriov/pkg/types"
+)
+
+// SetPCIRetryBackoff replaces the backoff of the PCI info reads and returns a function restoring it.
+func SetPCIRetryBackoff(backoff wait.Backoff) func() {
+	original := pciRetryBackoff
+	pciRetryBackoff = backoff
+	return func() {
+		pciRetryBackoff = original
+	}
+}
+
+// SetClock replaces the clock of the manager
+func (s *Manager) SetClock(clk clock.Clock) {
+	s.clock = clk
+}
+
+// SetVerifyClaimSpecFile replaces the verification of the claim CDI spec file of the manager
+func (s *Manager) SetVerifyClaimSpecFile(verify func(types.PreparedDevices) error) {
+	s.verifyClaimSpecFile = verify
+}
+
+// AllocatableDevices returns the allocatable devices without copying them, so the tests can change their attributes
+func (s *Manager) AllocatableDevices() types.AllocatableDevices {
+	return s.allocatable
+}
+
+// NewDeviceFilter exposes newDeviceFilter
+func NewDeviceFilter(flags *types.Flags) (RefreshableFilter, error) {
+	filter, err := newDeviceFilter(flags)
+	if err != nil {
+		return nil, err
+	}
+	return filter.(DeviceFilterChain), nil
+}
+
+// RandomizedMacAddress exposes randomizedMacAddress
+func RandomizedMacAddress(claimUID, deviceName string) net.HardwareAddr {
+	return randomizedMacAddress(claimUID, deviceName)
+}
+
+// WatchPCIEvents exposes watchPCIEvents to inject the events and the clock
+func WatchPCIEvents(ctx context.Context, events <-chan host.PCIEvent, debounceWindow time.Duration,
+	clk clock.Clock, rediscover func(context.Context) error) {
+	watchPCIEvents(ctx, events, debounceWindow, clk, rediscover)
+}
@@ -0,0 +1,233 @@
+package devicestate
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	resourceapi "k8s.io/api/resource/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+
+	"github.com/SchSeba/dra-driver-sriov/pkg/consts"
+	"github.com/SchSeba/dra-driver-sriov/pkg/host"
+	"github.com/SchSeba/dra-driver-sriov/pkg/types"
+)
+
+// pciAddressRegexp matches a PCI address as written in sysfs
+var pciAddressRegexp = regexp.MustCompile(`^[0-9a-f]{4}:[0-9a-f]{2}:[0-9a-f]{2}\.[0-7]$`)
+
+// DeviceFilter decides if a discovered device is published
+type DeviceFilter interface {
+	// Keep returns false when the device must not be published
+	Keep(device resourceapi.Device) bool
+}
+
+// RefreshableFilter is a DeviceFilter deciding on a host state it reads once per discovery instead of once per device
+type RefreshableFilter interface {
+	DeviceFilter
+	// Refresh reads the host state the next Keep calls decide on
+	Refresh() error
+}
+
+// DeviceFilterFunc adapts a function to the DeviceFilter interface
+type DeviceFilterFunc func(device resourceapi.Device) bool
+
+// Keep calls the function
+func (f DeviceFilterFunc) Keep(device resourceapi.Device) bool {
+	return f(device)
+}
+
+// DeviceFilterChain keeps the devices kept by all of its filters, an empty chain keeps every device
+type DeviceFilterChain []DeviceFilter
+
+// Keep returns false as soon as a filter of the chain drops the device
+func (c DeviceFilterChain) Keep(device resourceapi.Device) bool {
+	for _, filter := range c {
+		if !filter.Keep(device) {
+			return false
+		}
+	}
+	return true
+}
+
+// Refresh refreshes the refreshable filters of the chain
+func (c DeviceFilterChain) Refresh() error {
+	for _, filter := range c {
+		if refreshable, ok := filter.(RefreshableFilter); ok {
+			if err := refreshable.Refresh(); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// NewDenyFilter drops the VFs whose PCI address or parent PF name is listed, the entries must match exactly
+func NewDenyFilter(names []string) DeviceFilter {
+	denied := sets.New(names...)
+	return DeviceFilterFunc(func(device resourceapi.Device) bool {
+		return !matchesDevice(denied, device)
+	})
+}
+
+// hostUsedFilter drops the VFs the host is using, each detection is enabled on its own
+type hostUsedFilter struct {
+	// upNetdevs drops the VFs with a netdev that is up in the host namespace
+	upNetdevs bool
+	// defaultRoute drops the VFs of the PFs carrying the node default route, except the ones of the allowed PFs
+	defaultRoute bool
+	allowedPFs   sets.Set[string]
+
+	mu sync.Mutex
+	// uplinks are the interfaces carrying the default route, read on refresh
+	uplinks sets.Set[string]
+}
+
+// NewHostUsedFilter drops the VFs the host is using, i.e. the VFs with a netdev that is up in the host namespace.
+// A VF handed to a pod is moved out of the host namespace and a VF bound to a userspace driver has no netdev,
+// so neither of them is dropped.
+func NewHostUsedFilter() RefreshableFilter {
+	return newHostUsedFilter(true, false, nil)
+}
+
+// NewDefaultRouteFilter drops the VFs whose parent PF carries the node default route, directly or through a VLAN,
+// a bond or a bridge on top of it, so the management uplink of the node is never handed to a pod.
+// The VFs of the PFs whose name is allowed are kept.
+func NewDefaultRouteFilter(allowedPFs []string) RefreshableFilter {
+	return newHostUsedFilter(false, true, allowedPFs)
+}
+
+// newHostUsedFilter returns a filter dropping the VFs with a netdev up on the host and/or the VFs of the PFs
+// carrying the default route
+func newHostUsedFilter(upNetdevs, defaultRoute bool, allowedPFs []string) *hostUsedFilter {
+	return &hostUsedFilter{
+		upNetdevs:    upNetdevs,
+		defaultRoute: defaultRoute,
+		allowedPFs:   sets.New(allowedPFs...),
+		uplinks:      sets.New[string](),
+	}
+}
+
+// Refresh reads the interfaces carrying the default route, nothing is read when the default route isn't checked
+func (f *hostUsedFilter) Refresh() error {
+	if !f.defaultRoute {
+		return nil
+	}
+	uplinks, err := host.GetHelpers().GetDefaultRouteInterfaces()
+	if err != nil {
+		return fmt.Errorf("failed to get the interfaces carrying the default route: %w", err)
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.uplinks = sets.New(uplinks...)
+	return nil
+}
+
+// Keep returns false for the VFs of a PF carrying the default route and not allowed, and for the VFs with a netdev up
+func (f *hostUsedFilter) Keep(device resourceapi.Device) bool {
+	if f.defaultRoute && f.carriesDefaultRoute(device) {
+		return false
+	}
+	if f.upNetdevs {
+		pciAddress := device.Attributes[consts.AttributePciAddress].StringValue
+		if pciAddress == nil {
+			return true
+		}
+		netName := host.GetHelpers().TryGetInterfaceName(*pciAddress)
+		if netName == "" {
+			return true
+		}
+		linkUp, err := host.GetHelpers().IsLinkUp(netName)
+		return err != nil || !linkUp
+	}
+	return true
+}
+
+// carriesDefaultRoute returns true when the parent PF of the device carries the default route and is not allowed
+func (f *hostUsedFilter) carriesDefaultRoute(device resourceapi.Device) bool {
+	pfName := device.Attributes[consts.AttributePFName].StringValue
+	if pfName == nil || f.allowedPFs.Has(*pfName) {
+		return false
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.uplinks.Has(*pfName)
+}
+
+// newDeviceFilter returns the filters configured by the flags, the reserved VFs are denied by their PCI address
+func newDeviceFilter(flags *types.Flags) (DeviceFilter, error) {
+	filters := DeviceFilterChain{}
+	if flags.ReservedVFs != "" {
+		reservedVFs, err := parsePCIAddressList(flags.ReservedVFs)
+		if err != nil {
+			return nil, fmt.Errorf("invalid reserved VFs: %w", err)
+		}
+		filters = append(filters, NewDenyFilter(reservedVFs))
+	}
+	if flags.ExcludeHostUsedVFs || flags.ExcludeDefaultRoutePF {
+		filters = append(filters, newHostUsedFilter(flags.ExcludeHostUsedVFs, flags.ExcludeDefaultRoutePF, parseNameList(flags.DefaultRoutePFAllowlist)))
+	}
+	return filters, nil
+}
+
+// parsePCIAddressList parses a comma separated list of PCI addresses in the sysfs format, e.g. 0000:01:00.2
+func parsePCIAddressList(value string) ([]string, error) {
+	pciAddresses := []string{}
+	for _, pciAddress := range strings.Split(value, ",") {
+		pciAddress = strings.TrimSpace(pciAddress)
+		if pciAddress == "" {
+			continue
+		}
+		if !pciAddressRegexp.MatchString(pciAddress) {
+			return nil, fmt.Errorf("%q is not a PCI address, expected the domain:bus:device.function format, e.g. 0000:01:00.2", pciAddress)
+		}
+		pciAddresses = append(pciAddresses, pciAddress)
+	}
+	return pciAddresses, nil
+}
+
+// parseNameList parses a comma separated list of names, the empty entries are skipped
+func parseNameList(value string) []string {
+	names := []string{}
+	for _, name := range strings.Split(value, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// matchesDevice returns true when the PCI address or the parent PF name of the device is in the set
+func matchesDevice(names sets.Set[string], device resourceapi.Device) bool {
+	for _, attribute := range []resourceapi.QualifiedName{consts.AttributePciAddress, consts.AttributePFName} {
+		if value := device.Attributes[attribute].StringValue; value != nil && names.Has(*value) {
+			return true
+		}
+	}
+	return false
+}
+
+// filterDevices removes the devices dropped by the filter from the resource list and returns the sorted names of
+// the removed devices, a nil filter keeps every device. A refreshable filter is refreshed first.
+func filterDevices(resourceList types.AllocatableDevices, filter DeviceFilter) ([]string, error) {
+	if filter == nil {
+		return nil, nil
+	}
+	if refreshable, ok := filter.(RefreshableFilter); ok {
+		if err := refreshable.Refresh(); err != nil {
+			return nil, fmt.Errorf("failed to refresh the device filters: %w", err)
+		}
+	}
+
+	var filtered []string
+	for deviceName, device := range resourceList {
+		if !filter.Keep(device) {
+			filtered = append(filtered, deviceName)
+			delete(resourceList, deviceName)
+		}
+	}
+	sort.Strings(filtered)
+	return filtered, nil
+}
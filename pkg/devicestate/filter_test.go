@@ -0,0 +1,179 @@
+package devicestate_test
+
+import (
+	"context"
+
+	"fmt"
+
+	"github.com/jaypipes/ghw"
+	"github.com/jaypipes/ghw/pkg/pci"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"go.uber.org/mock/gomock"
+	resourceapi "k8s.io/api/resource/v1"
+	"k8s.io/utils/ptr"
+
+	"github.com/SchSeba/dra-driver-sriov/pkg/consts"
+	"github.com/SchSeba/dra-driver-sriov/pkg/devicestate"
+	"github.com/SchSeba/dra-driver-sriov/pkg/host"
+	mock_host "github.com/SchSeba/dra-driver-sriov/pkg/host/mock"
+	"github.com/SchSeba/dra-driver-sriov/pkg/types"
+)
+
+// newFilterDevice returns a device with the attributes the built-in filters look at
+func newFilterDevice(pciAddress, pfName string) resourceapi.Device {
+	return resourceapi.Device{
+		Name: pciAddress,
+		Attributes: map[resourceapi.QualifiedName]resourceapi.DeviceAttribute{
+			consts.AttributePciAddress: {StringValue: ptr.To(pciAddress)},
+			consts.AttributePFName:     {StringValue: ptr.To(pfName)},
+		},
+	}
+}
+
+var _ = Describe("DeviceFilter", func() {
+	var (
+		mockCtrl     *gomock.Controller
+		mockHost     *mock_host.MockInterface
+		originalHost host.Interface
+	)
+
+	BeforeEach(func() {
+		mockCtrl = gomock.NewController(GinkgoT())
+		mockHost = mock_host.NewMockInterface(mockCtrl)
+		originalHost = host.GetHelpers()
+		host.Helpers = mockHost
+	})
+
+	AfterEach(func() {
+		host.Helpers = originalHost
+		mockCtrl.Finish()
+	})
+
+	It("should keep every device with an empty chain", func() {
+		Expect(devicestate.DeviceFilterChain{}.Keep(newFilterDevice("0000:01:00.2", "eth0"))).To(BeTrue())
+	})
+
+	It("should match the deny filter on the PCI address and the PF name", func() {
+		deny := devicestate.NewDenyFilter([]string{"eth0", "0000:81:00.2"})
+		Expect(deny.Keep(newFilterDevice("0000:01:00.2", "eth0"))).To(BeFalse())
+		Expect(deny.Keep(newFilterDevice("0000:81:00.2", "eth1"))).To(BeFalse())
+		Expect(deny.Keep(newFilterDevice("0000:81:00.3", "eth1"))).To(BeTrue())
+	})
+
+	It("should drop the VFs with a netdev up on the host", func() {
+		mockHost.EXPECT().TryGetInterfaceName("0000:01:00.2").Return("eth0v0")
+		mockHost.EXPECT().IsLinkUp("eth0v0").Return(true, nil)
+		mockHost.EXPECT().TryGetInterfaceName("0000:01:00.3").Return("eth0v1")
+		mockHost.EXPECT().IsLinkUp("eth0v1").Return(false, nil)
+		mockHost.EXPECT().TryGetInterfaceName("0000:01:00.4").Return("")
+
+		hostUsed := devicestate.NewHostUsedFilter()
+		Expect(hostUsed.Refresh()).To(Succeed())
+		Expect(hostUsed.Keep(newFilterDevice("0000:01:00.2", "eth0"))).To(BeFalse())
+		Expect(hostUsed.Keep(newFilterDevice("0000:01:00.3", "eth0"))).To(BeTrue())
+		Expect(hostUsed.Keep(newFilterDevice("0000:01:00.4", "eth0"))).To(BeTrue())
+	})
+
+	It("should drop the VFs of the PFs carrying the default route unless allowed", func() {
+		mockHost.EXPECT().GetDefaultRouteInterfaces().Return([]string{"bond0", "eth0", "eth1", "eth1.100"}, nil)
+
+		defaultRoute := devicestate.NewDefaultRouteFilter([]string{"eth1"})
+		Expect(defaultRoute.Refresh()).To(Succeed())
+		Expect(defaultRoute.Keep(newFilterDevice("0000:01:00.2", "eth0"))).To(BeFalse())
+		Expect(defaultRoute.Keep(newFilterDevice("0000:81:00.2", "eth1"))).To(BeTrue())
+		Expect(defaultRoute.Keep(newFilterDevice("0000:82:00.2", "eth2"))).To(BeTrue())
+	})
+
+	It("should fail the refresh of the chain when the default route can't be read", func() {
+		mockHost.EXPECT().GetDefaultRouteInterfaces().Return(nil, fmt.Errorf("netlink error"))
+
+		chain := devicestate.DeviceFilterChain{
+			devicestate.NewDenyFilter([]string{"0000:01:00.2"}),
+			devicestate.NewDefaultRouteFilter(nil),
+		}
+		Expect(chain.Refresh()).To(MatchError("failed to get the interfaces carrying the default route: netlink error"))
+	})
+
+	It("should build the filters of the flags", func() {
+		mockHost.EXPECT().GetDefaultRouteInterfaces().Return([]string{"eth1"}, nil)
+		mockHost.EXPECT().TryGetInterfaceName("0000:01:00.2").Return("eth0v0")
+		mockHost.EXPECT().IsLinkUp("eth0v0").Return(true, nil)
+		mockHost.EXPECT().TryGetInterfaceName("0000:01:00.3").Return("eth0v1")
+		mockHost.EXPECT().IsLinkUp("eth0v1").Return(false, nil)
+
+		filter, err := devicestate.NewDeviceFilter(&types.Flags{
+			ReservedVFs:             "0000:01:00.4",
+			ExcludeHostUsedVFs:      true,
+			ExcludeDefaultRoutePF:   true,
+			DefaultRoutePFAllowlist: "eth2",
+		})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(filter.Refresh()).To(Succeed())
+		// reserved
+		Expect(filter.Keep(newFilterDevice("0000:01:00.4", "eth0"))).To(BeFalse())
+		// netdev up on the host
+		Expect(filter.Keep(newFilterDevice("0000:01:00.2", "eth0"))).To(BeFalse())
+		Expect(filter.Keep(newFilterDevice("0000:01:00.3", "eth0"))).To(BeTrue())
+		// VF of the PF carrying the default route, its netdev is not checked
+		Expect(filter.Keep(newFilterDevice("0000:81:00.2", "eth1"))).To(BeFalse())
+	})
+
+	It("should reject a reserved VF that is not a PCI address", func() {
+		_, err := devicestate.NewDeviceFilter(&types.Flags{ReservedVFs: "eth0"})
+		Expect(err).To(MatchError(ContainSubstring("invalid reserved VFs")))
+	})
+
+	It("should keep only the devices kept by every filter of the chain", func() {
+		mockHost.EXPECT().TryGetInterfaceName("0000:01:00.3").Return("eth0v1")
+		mockHost.EXPECT().IsLinkUp("eth0v1").Return(true, nil)
+		mockHost.EXPECT().TryGetInterfaceName("0000:01:00.4").Return("")
+
+		chain := devicestate.DeviceFilterChain{
+			devicestate.NewDenyFilter([]string{"eth1"}),
+			devicestate.NewDenyFilter([]string{"0000:01:00.2"}),
+			devicestate.NewHostUsedFilter(),
+		}
+		// dropped by the first deny filter, the next filters are not called
+		Expect(chain.Keep(newFilterDevice("0000:81:00.2", "eth1"))).To(BeFalse())
+		// dropped by the second deny filter
+		Expect(chain.Keep(newFilterDevice("0000:01:00.2", "eth0"))).To(BeFalse())
+		// dropped by the host used filter
+		Expect(chain.Keep(newFilterDevice("0000:01:00.3", "eth0"))).To(BeFalse())
+		Expect(chain.Keep(newFilterDevice("0000:01:00.4", "eth0"))).To(BeTrue())
+	})
+
+	It("should apply the filters before capping the discovered devices", func() {
+		mockHost.EXPECT().PCI().Return(&ghw.PCIInfo{
+			Devices: []*pci.Device{newPFDevice("0000:01:00.0")},
+		}, nil)
+		mockHost.EXPECT().IsSriovVF("0000:01:00.0").Return(false)
+		mockHost.EXPECT().TryGetInterfaceName("0000:01:00.0").Return("eth0")
+		mockHost.EXPECT().GetSriovNumVFs("0000:01:00.0").Return(3, nil)
+		mockHost.EXPECT().GetSriovTotalVFs("0000:01:00.0").Return(64, nil)
+		mockHost.EXPECT().GetSriovVFTotalMsix("0000:01:00.0").Return(0, fmt.Errorf("not supported"))
+		mockHost.EXPECT().GetNicSriovMode("0000:01:00.0").Return("legacy")
+		mockHost.EXPECT().GetPermanentMacAddress("eth0").Return("aa:bb:cc:dd:ee:01", nil)
+		mockHost.EXPECT().IsLinkUp("eth0").Return(true, nil)
+		mockHost.EXPECT().GetBondMaster("eth0").Return("", nil)
+		mockHost.EXPECT().GetNumaNode("0000:01:00.0").Return("0", nil)
+		mockHost.EXPECT().GetParentPciAddress("0000:01:00.0").Return("0000:00:01.0", nil)
+		mockHost.EXPECT().GetPciRootPort("0000:01:00.0").Return("0000:00:01.0", nil)
+		mockHost.EXPECT().GetEswitchMode("0000:01:00.0").Return("switchdev", nil)
+		mockHost.EXPECT().GetDriverByBusAndDevice("0000:01:00.0").Return("ice", nil)
+		mockHost.EXPECT().GetVFList("0000:01:00.0").Return([]host.VFInfo{
+			{PciAddress: "0000:01:00.2", VFID: 0, DeviceID: "154c"},
+			{PciAddress: "0000:01:00.3", VFID: 1, DeviceID: "154c"},
+			{PciAddress: "0000:01:00.4", VFID: 2, DeviceID: "154c"},
+		}, nil)
+
+		filter := devicestate.DeviceFilterChain{
+			devicestate.NewDenyFilter([]string{"eth1"}),
+			devicestate.NewDenyFilter([]string{"0000:01:00.2"}),
+		}
+		devices, err := devicestate.DiscoverSriovDevices(context.Background(), consts.DeviceNamingPCI, 1, filter, 0)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(devices).To(HaveLen(1))
+		Expect(devices).To(HaveKey("0000-01-00-3"))
+	})
+})
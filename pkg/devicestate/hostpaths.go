@@ -0,0 +1,63 @@
+package devicestate
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// deniedHostPaths can never be exposed to a container, neither directly nor through a path under them,
+// except for the root which is only denied as a whole
+var deniedHostPaths = []string{"/", "/proc", "/sys", "/etc", "/run", "/var/run"}
+
+// ParseAllowedHostPaths parses the comma separated host paths the device nodes and the mounts of the
+// configs must be under. The paths must be absolute and can't be, or be under, one of the denied paths.
+func ParseAllowedHostPaths(value string) ([]string, error) {
+	var allowedHostPaths []string
+	for _, path := range parseNameList(value) {
+		if !filepath.IsAbs(path) {
+			return nil, fmt.Errorf("invalid allowed host path %q: must be absolute", path)
+		}
+		path = filepath.Clean(path)
+		if err := checkDeniedHostPath(path); err != nil {
+			return nil, fmt.Errorf("invalid allowed host path %q: %w", path, err)
+		}
+		allowedHostPaths = append(allowedHostPaths, path)
+	}
+	return allowedHostPaths, nil
+}
+
+// checkHostPath checks a host path requested by a config is under one of the allowed host paths
+func (s *Manager) checkHostPath(path string) error {
+	path = filepath.Clean(path)
+	if err := checkDeniedHostPath(path); err != nil {
+		return err
+	}
+	for _, allowed := range s.allowedHostPaths {
+		if isUnderPath(path, allowed) {
+			return nil
+		}
+	}
+	if len(s.allowedHostPaths) == 0 {
+		return fmt.Errorf("no host path is allowed on this node")
+	}
+	return fmt.Errorf("not under the allowed host paths %s", strings.Join(s.allowedHostPaths, ", "))
+}
+
+// checkDeniedHostPath fails for a clean absolute path that is, or is under, one of the denied host paths
+func checkDeniedHostPath(path string) error {
+	for _, denied := range deniedHostPaths {
+		if path == denied || (denied != "/" && isUnderPath(path, denied)) {
+			return fmt.Errorf("the host path %s is denied", denied)
+		}
+	}
+	return nil
+}
+
+// isUnderPath returns true when the clean path is the parent path or one of its descendants
+func isUnderPath(path, parent string) bool {
+	if parent == "/" {
+		return true
+	}
+	return path == parent || strings.HasPrefix(path, parent+"/")
+}
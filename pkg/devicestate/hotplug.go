@@ -0,0 +1,133 @@
+package devicestate
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"sort"
+	"time"
+
+	"k8s.io/klog/v2"
+	"k8s.io/utils/clock"
+
+	"github.com/SchSeba/dra-driver-sriov/pkg/consts"
+	"github.com/SchSeba/dra-driver-sriov/pkg/host"
+)
+
+// Rediscover runs the device discovery again and republishes the resources when VFs appeared or disappeared,
+// e.g. after a NIC is hot-plugged or its VFs are created. The devices still present keep their current attributes,
+// so the resource names and link state set since the startup are not lost. The size and counters of the VF groups
+// are refreshed.
+func (s *Manager) Rediscover(ctx context.Context) error {
+	logger := klog.FromContext(ctx).WithName("Rediscover")
+
+	discovered, err := DiscoverSriovDevices(ctx, s.deviceNaming, s.maxVFsPerNode, s.deviceFilter, s.discoveryConcurrency)
+	if err != nil {
+		err = fmt.Errorf("error rediscovering the devices: %w", err)
+		s.allocatableMu.Lock()
+		s.discoveryErr = err
+		s.allocatableMu.Unlock()
+		return err
+	}
+	if s.advertiseVFGroups {
+		addVFGroups(discovered)
+	}
+	addExtraAttributes(discovered, s.extraAttributes)
+
+	s.allocatableMu.Lock()
+	s.discoveryErr = nil
+	added := []string{}
+	removed := []string{}
+	for deviceName, device := range discovered {
+		current, exists := s.allocatable[deviceName]
+		if !exists {
+			s.allocatable[deviceName] = device
+			added = append(added, deviceName)
+			continue
+		}
+		// the VF group size and the counters follow the VFs added to or removed from the PF
+		current.ConsumesCounters = device.ConsumesCounters
+		if isVFGroup(current) {
+			current.Attributes = maps.Clone(current.Attributes)
+			current.Attributes[consts.AttributeVFGroupSize] = device.Attributes[consts.AttributeVFGroupSize]
+		}
+		s.allocatable[deviceName] = current
+	}
+	for deviceName := range s.allocatable {
+		if _, exists := discovered[deviceName]; !exists {
+			delete(s.allocatable, deviceName)
+			removed = append(removed, deviceName)
+		}
+	}
+	s.allocatableMu.Unlock()
+
+	if len(added) == 0 && len(removed) == 0 {
+		logger.V(2).Info("No device added or removed")
+		return nil
+	}
+	sort.Strings(added)
+	sort.Strings(removed)
+	logger.Info("Devices changed", "added", added, "removed", removed)
+	if s.republishCallback == nil {
+		logger.V(2).Info("No republish callback available - resources will be updated on next periodic refresh")
+		return nil
+	}
+	if err := s.republishCallback(ctx); err != nil {
+		return fmt.Errorf("failed to republish resources: %w", err)
+	}
+	return nil
+}
+
+// DiscoveryError returns the error of the last rediscovery, nil when it succeeded or none ran
+func (s *Manager) DiscoveryError() error {
+	s.allocatableMu.RLock()
+	defer s.allocatableMu.RUnlock()
+	return s.discoveryErr
+}
+
+// RunHotplugWatcher rediscovers the devices when a network PCI device is added to or removed from the host,
+// until the context is done. The events received within the debounce window of each other trigger a single
+// rediscovery, a NIC coming up with its VFs emits a burst of them.
+func (s *Manager) RunHotplugWatcher(ctx context.Context, debounceWindow time.Duration) {
+	logger := klog.FromContext(ctx).WithName("RunHotplugWatcher")
+
+	events, err := host.GetHelpers().SubscribePCIEvents(ctx)
+	if err != nil {
+		logger.Error(err, "Failed to subscribe to the PCI events, hot-plugged devices are discovered on restart only")
+		return
+	}
+	watchPCIEvents(ctx, events, debounceWindow, clock.RealClock{}, s.Rediscover)
+}
+
+// watchPCIEvents calls rediscover once no event was received for the debounce window,
+// it returns when the context is done or the events channel is closed
+func watchPCIEvents(ctx context.Context, events <-chan host.PCIEvent, debounceWindow time.Duration,
+	clk clock.Clock, rediscover func(context.Context) error) {
+	logger := klog.FromContext(ctx).WithName("watchPCIEvents")
+
+	var timer clock.Timer
+	var fired <-chan time.Time
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case event, ok := <-events:
+			if !ok {
+				logger.Info("PCI events subscription closed, hot-plugged devices are discovered on restart only")
+				return
+			}
+			logger.V(2).Info("PCI device event", "action", event.Action, "pciAddress", event.PciAddress)
+			// restart the window, a new timer drops the expiry of the previous one if it was not consumed yet
+			if timer != nil {
+				timer.Stop()
+			}
+			timer = clk.NewTimer(debounceWindow)
+			fired = timer.C()
+		case <-fired:
+			fired = nil
+			if err := rediscover(ctx); err != nil {
+				logger.Error(err, "Failed to rediscover the devices")
+			}
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package devicestate_test
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	testingclock "k8s.io/utils/clock/testing"
+
+	"github.com/SchSeba/dra-driver-sriov/pkg/devicestate"
+	"github.com/SchSeba/dra-driver-sriov/pkg/host"
+)
+
+var _ = Describe("WatchPCIEvents", func() {
+	const debounceWindow = 5 * time.Second
+
+	var (
+		ctx         context.Context
+		cancel      context.CancelFunc
+		fakeClock   *testingclock.FakeClock
+		events      chan host.PCIEvent
+		rediscovers atomic.Int32
+		done        chan struct{}
+	)
+
+	BeforeEach(func() {
+		ctx, cancel = context.WithCancel(context.Background())
+		fakeClock = testingclock.NewFakeClock(time.Now())
+		events = make(chan host.PCIEvent)
+		rediscovers.Store(0)
+		done = make(chan struct{})
+
+		go func() {
+			defer close(done)
+			devicestate.WatchPCIEvents(ctx, events, debounceWindow, fakeClock, func(context.Context) error {
+				rediscovers.Add(1)
+				return nil
+			})
+		}()
+	})
+
+	AfterEach(func() {
+		cancel()
+		Eventually(done).Should(BeClosed())
+	})
+
+	It("should rediscover once after a burst of device-add events", func() {
+		// an unbuffered send returns once the previous event is handled
+		for _, pciAddress := range []string{"0000:01:00.0", "0000:01:00.1", "0000:01:00.2"} {
+			events <- host.PCIEvent{Action: host.PCIEventAdd, PciAddress: pciAddress}
+		}
+		Eventually(fakeClock.HasWaiters).Should(BeTrue())
+		fakeClock.Step(debounceWindow - time.Millisecond)
+		Consistently(rediscovers.Load, 100*time.Millisecond).Should(BeZero())
+
+		fakeClock.Step(time.Millisecond)
+		Eventually(rediscovers.Load).Should(Equal(int32(1)))
+		Consistently(rediscovers.Load, 100*time.Millisecond).Should(Equal(int32(1)))
+		Expect(fakeClock.HasWaiters()).To(BeFalse())
+	})
+
+	It("should rediscover again for a later event", func() {
+		events <- host.PCIEvent{Action: host.PCIEventAdd, PciAddress: "0000:01:00.0"}
+		Eventually(fakeClock.HasWaiters).Should(BeTrue())
+		fakeClock.Step(debounceWindow)
+		Eventually(rediscovers.Load).Should(Equal(int32(1)))
+
+		events <- host.PCIEvent{Action: host.PCIEventRemove, PciAddress: "0000:01:00.0"}
+		Eventually(fakeClock.HasWaiters).Should(BeTrue())
+		fakeClock.Step(debounceWindow)
+		Eventually(rediscovers.Load).Should(Equal(int32(2)))
+	})
+
+	It("should stop when the events channel is closed", func() {
+		close(events)
+		Eventually(done).Should(BeClosed())
+		Expect(rediscovers.Load()).To(BeZero())
+	})
+})
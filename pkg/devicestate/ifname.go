@@ -0,0 +1,74 @@
+package devicestate
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"text/template"
+)
+
+// maxIfNameLength is the longest Linux interface name, IFNAMSIZ without the terminating null byte
+const maxIfNameLength = 15
+
+// IfNameTemplate renders the interface names of the devices prepared without an ifName in their config
+type IfNameTemplate struct {
+	tmpl *template.Template
+}
+
+// IfNameTemplateData is the data the interface name template is rendered with
+type IfNameTemplateData struct {
+	// Index is the position of the device among the devices without an ifName in the prepared claims of the pod
+	Index int
+}
+
+// ParseIfNameTemplate parses an interface name template like "sriov{{.Index}}", an empty template returns nil.
+// The template is rendered for the first two indices so a template making invalid names, or the same
+// name for every device, fails the startup.
+func ParseIfNameTemplate(text string) (*IfNameTemplate, error) {
+	if text == "" {
+		return nil, nil
+	}
+	tmpl, err := template.New("ifname").Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("invalid interface name template %q: %w", text, err)
+	}
+	ifNameTemplate := &IfNameTemplate{tmpl: tmpl}
+	first, err := ifNameTemplate.Render(0)
+	if err != nil {
+		return nil, fmt.Errorf("invalid interface name template %q: %w", text, err)
+	}
+	second, err := ifNameTemplate.Render(1)
+	if err != nil {
+		return nil, fmt.Errorf("invalid interface name template %q: %w", text, err)
+	}
+	if first == second {
+		return nil, fmt.Errorf("invalid interface name template %q: renders the same name %q for every index, it must use {{.Index}}", text, first)
+	}
+	return ifNameTemplate, nil
+}
+
+// Render returns the interface name of the device at the given index, failing when it is not a valid Linux interface name
+func (t *IfNameTemplate) Render(index int) (string, error) {
+	var ifName bytes.Buffer
+	if err := t.tmpl.Execute(&ifName, IfNameTemplateData{Index: index}); err != nil {
+		return "", fmt.Errorf("failed to render the interface name for index %d: %w", index, err)
+	}
+	if err := validateIfName(ifName.String()); err != nil {
+		return "", err
+	}
+	return ifName.String(), nil
+}
+
+// validateIfName checks the name is accepted by the kernel as an interface name
+func validateIfName(ifName string) error {
+	if ifName == "" || ifName == "." || ifName == ".." {
+		return fmt.Errorf("invalid interface name %q", ifName)
+	}
+	if len(ifName) > maxIfNameLength {
+		return fmt.Errorf("invalid interface name %q: must be no more than %d characters", ifName, maxIfNameLength)
+	}
+	if strings.ContainsAny(ifName, "/: \t\n\v\f\r") {
+		return fmt.Errorf("invalid interface name %q: must not contain '/', ':' or whitespaces", ifName)
+	}
+	return nil
+}
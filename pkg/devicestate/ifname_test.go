@@ -0,0 +1,55 @@
+package devicestate_test
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/SchSeba/dra-driver-sriov/pkg/devicestate"
+)
+
+var _ = Describe("ParseIfNameTemplate", func() {
+	It("should render the template for every index", func() {
+		ifNameTemplate, err := devicestate.ParseIfNameTemplate("sriov{{.Index}}")
+		Expect(err).NotTo(HaveOccurred())
+
+		ifName, err := ifNameTemplate.Render(0)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(ifName).To(Equal("sriov0"))
+
+		ifName, err = ifNameTemplate.Render(1)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(ifName).To(Equal("sriov1"))
+	})
+
+	It("should return nil for an empty template", func() {
+		ifNameTemplate, err := devicestate.ParseIfNameTemplate("")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(ifNameTemplate).To(BeNil())
+	})
+
+	It("should reject a name longer than 15 characters", func() {
+		ifNameTemplate, err := devicestate.ParseIfNameTemplate("abcdefghijklmn{{.Index}}")
+		Expect(err).NotTo(HaveOccurred())
+		_, err = ifNameTemplate.Render(10)
+		Expect(err).To(MatchError(`invalid interface name "abcdefghijklmn10": must be no more than 15 characters`))
+
+		_, err = devicestate.ParseIfNameTemplate("abcdefghijklmnop{{.Index}}")
+		Expect(err).To(MatchError(ContainSubstring("must be no more than 15 characters")))
+	})
+
+	DescribeTable("should reject the templates making invalid names",
+		func(text, reason string) {
+			_, err := devicestate.ParseIfNameTemplate(text)
+			Expect(err).To(MatchError(ContainSubstring(reason)))
+		},
+		Entry("slash", "net/{{.Index}}", "must not contain '/', ':' or whitespaces"),
+		Entry("colon", "net:{{.Index}}", "must not contain '/', ':' or whitespaces"),
+		Entry("space", "net {{.Index}}", "must not contain '/', ':' or whitespaces"),
+		Entry("empty name", "{{if false}}net{{end}}", `invalid interface name ""`),
+		Entry("dot", ".", `invalid interface name "."`),
+		Entry("same name for every index", "sriov", `renders the same name "sriov" for every index`),
+		Entry("index ignored", "net{{if .Index}}{{end}}", `renders the same name "net" for every index`),
+		Entry("unknown field", "net{{.Device}}", "can't evaluate field Device"),
+		Entry("syntax error", "net{{.Index", "unclosed action"),
+	)
+})
@@ -0,0 +1,102 @@
+package devicestate
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	resourceapi "k8s.io/api/resource/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/klog/v2"
+	"k8s.io/utils/ptr"
+
+	"github.com/SchSeba/dra-driver-sriov/pkg/consts"
+	"github.com/SchSeba/dra-driver-sriov/pkg/host"
+)
+
+// RefreshLinkState updates the link up attribute of the devices from the current link state of their PF
+// and triggers a republish when it changed. Devices whose PF link state can't be read keep the last known value.
+func (s *Manager) RefreshLinkState(ctx context.Context) error {
+	logger := klog.FromContext(ctx).WithName("RefreshLinkState")
+
+	s.allocatableMu.Lock()
+	changesMade := false
+	// linkStates caches the link state per PF name, nil when it can't be read
+	linkStates := map[string]*bool{}
+	for deviceName, device := range s.allocatable {
+		pfName := s.getPFName(deviceName)
+		if pfName == "" {
+			continue
+		}
+		linkUp, checked := linkStates[pfName]
+		if !checked {
+			up, err := host.GetHelpers().IsLinkUp(pfName)
+			if err != nil {
+				logger.Error(err, "Failed to get PF link state", "pf", pfName)
+			} else {
+				linkUp = ptr.To(up)
+			}
+			linkStates[pfName] = linkUp
+		}
+		if linkUp == nil {
+			continue
+		}
+
+		if existingAttr, exists := device.Attributes[consts.AttributeLinkUp]; exists &&
+			existingAttr.BoolValue != nil && *existingAttr.BoolValue == *linkUp {
+			continue
+		}
+		// the attributes may be shared with the devices handed out, they are changed on a copy
+		device = *device.DeepCopy()
+		device.Attributes[consts.AttributeLinkUp] = resourceapi.DeviceAttribute{
+			BoolValue: ptr.To(*linkUp),
+		}
+		setLinkDownTaint(&device, *linkUp)
+		s.allocatable[deviceName] = device
+		changesMade = true
+		logger.V(2).Info("Updated device link state", "deviceName", deviceName, "pf", pfName, "linkUp", *linkUp)
+	}
+	s.allocatableMu.Unlock()
+
+	if !changesMade {
+		return nil
+	}
+	logger.Info("PF link state changed", "linkStates", linkStates)
+	if s.republishCallback == nil {
+		logger.V(2).Info("No republish callback available - resources will be updated on next periodic refresh")
+		return nil
+	}
+	if err := s.republishCallback(ctx); err != nil {
+		return fmt.Errorf("failed to republish resources: %w", err)
+	}
+	return nil
+}
+
+// RunLinkStateRefresher refreshes the link up attribute of the devices every interval until the context is done
+func (s *Manager) RunLinkStateRefresher(ctx context.Context, interval time.Duration) {
+	logger := klog.FromContext(ctx).WithName("RunLinkStateRefresher")
+	wait.UntilWithContext(ctx, func(ctx context.Context) {
+		if err := s.RefreshLinkState(ctx); err != nil {
+			logger.Error(err, "Failed to refresh the device link state")
+		}
+	}, interval)
+}
+
+// setLinkDownTaint taints the device with a NoSchedule taint while the link of its PF is down,
+// so the scheduler avoids it without withdrawing it from the resource slice, and removes the taint once the link is up
+func setLinkDownTaint(device *resourceapi.Device, linkUp bool) {
+	// build a new slice, the current one may be shared with the published devices
+	var taints []resourceapi.DeviceTaint
+	for _, taint := range device.Taints {
+		if taint.Key != consts.TaintKeyLinkDown {
+			taints = append(taints, taint)
+		}
+	}
+	if !linkUp {
+		taints = append(taints, resourceapi.DeviceTaint{
+			Key:    consts.TaintKeyLinkDown,
+			Effect: resourceapi.DeviceTaintEffectNoSchedule,
+		})
+	}
+	device.Taints = taints
+}
@@ -0,0 +1,106 @@
+package devicestate
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/klog/v2"
+
+	drasriovtypes "github.com/SchSeba/dra-driver-sriov/pkg/types"
+)
+
+// drainedPFsPollInterval is the interval between the reads of the drained PFs file
+const drainedPFsPollInterval = 10 * time.Second
+
+// GetPublishableDevices returns a copy of the allocatable devices without the VFs of the drained PFs
+func (s *Manager) GetPublishableDevices() drasriovtypes.AllocatableDevices {
+	s.allocatableMu.RLock()
+	defer s.allocatableMu.RUnlock()
+
+	devices := make(drasriovtypes.AllocatableDevices, len(s.allocatable))
+	for deviceName, device := range s.allocatable {
+		if s.drainedPFs.Has(s.getPFName(deviceName)) {
+			continue
+		}
+		devices[deviceName] = *device.DeepCopy()
+	}
+	return devices
+}
+
+// SetDrainedPFs replaces the PFs whose VFs are not published and triggers a republish when they changed.
+// The devices stay allocatable so the claims already prepared on the drained PFs are left intact.
+func (s *Manager) SetDrainedPFs(ctx context.Context, pfNames []string) error {
+	logger := klog.FromContext(ctx).WithName("SetDrainedPFs")
+
+	drainedPFs := sets.New(pfNames...)
+	s.allocatableMu.Lock()
+	changed := !s.drainedPFs.Equal(drainedPFs)
+	s.drainedPFs = drainedPFs
+	s.allocatableMu.Unlock()
+
+	if !changed {
+		return nil
+	}
+	logger.Info("Drained PFs changed", "drainedPFs", sets.List(drainedPFs))
+	if s.republishCallback == nil {
+		logger.V(2).Info("No republish callback available - resources will be updated on next periodic refresh")
+		return nil
+	}
+	if err := s.republishCallback(ctx); err != nil {
+		return fmt.Errorf("failed to republish resources: %w", err)
+	}
+	return nil
+}
+
+// RefreshDrainedPFs reads the drained PFs file and applies it with SetDrainedPFs.
+// The file lists one PF interface name per line, empty lines and lines starting with # are ignored.
+// A missing file means no PF is drained.
+func (s *Manager) RefreshDrainedPFs(ctx context.Context, path string) error {
+	pfNames, err := readDrainedPFsFile(path)
+	if err != nil {
+		return err
+	}
+	return s.SetDrainedPFs(ctx, pfNames)
+}
+
+// RunDrainedPFsWatcher refreshes the drained PFs from the file until the context is done
+func (s *Manager) RunDrainedPFsWatcher(ctx context.Context, path string) {
+	logger := klog.FromContext(ctx).WithName("RunDrainedPFsWatcher")
+	wait.UntilWithContext(ctx, func(ctx context.Context) {
+		if err := s.RefreshDrainedPFs(ctx, path); err != nil {
+			logger.Error(err, "Failed to refresh the drained PFs", "path", path)
+		}
+	}, drainedPFsPollInterval)
+}
+
+// readDrainedPFsFile returns the PF names listed in the drained PFs file
+func readDrainedPFsFile(path string) ([]string, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("error reading drained PFs file %s: %w", path, err)
+	}
+
+	pfNames := []string{}
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		pfNames = append(pfNames, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error parsing drained PFs file %s: %w", path, err)
+	}
+	return pfNames, nil
+}
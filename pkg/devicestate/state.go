@@ -2,23 +2,35 @@ package devicestate
 
 import (
 	"context"
+	"crypto/sha256"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
 	"strings"
+	"sync"
+	"time"
 
 	configapi "github.com/SchSeba/dra-driver-sriov/pkg/api/virtualfunction/v1alpha1"
 	"github.com/SchSeba/dra-driver-sriov/pkg/cdi"
 	"github.com/SchSeba/dra-driver-sriov/pkg/consts"
 	"github.com/SchSeba/dra-driver-sriov/pkg/flags"
 	"github.com/SchSeba/dra-driver-sriov/pkg/host"
+	"github.com/SchSeba/dra-driver-sriov/pkg/preparewebhook"
+	"github.com/SchSeba/dra-driver-sriov/pkg/tracing"
 	drasriovtypes "github.com/SchSeba/dra-driver-sriov/pkg/types"
 	netattdefv1 "github.com/k8snetworkplumbingwg/network-attachment-definition-client/pkg/apis/k8s.cni.cncf.io/v1"
 	resourceapi "k8s.io/api/resource/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	k8stypes "k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/sets"
 	"k8s.io/dynamic-resource-allocation/kubeletplugin"
 	"k8s.io/klog/v2"
 	drapbv1 "k8s.io/kubelet/pkg/apis/dra/v1beta1"
+	"k8s.io/utils/clock"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	cdiapi "tags.cncf.io/container-device-interface/pkg/cdi"
 	cdispec "tags.cncf.io/container-device-interface/specs-go"
@@ -28,42 +40,183 @@ type Manager struct {
 	k8sClient              flags.ClientSets
 	cdi                    *cdi.Handler
 	defaultInterfacePrefix string
-	allocatable            drasriovtypes.AllocatableDevices
-	republishCallback      func(context.Context) error
+	// ifNameTemplate names the interfaces of the devices without an ifName instead of the prefix, nil when not configured
+	ifNameTemplate *IfNameTemplate
+	allocatable    drasriovtypes.AllocatableDevices
+	// allocatableMu guards the allocatable devices and the drained PFs, the devices are deep copied
+	// before they are changed or handed out so the published devices never share their attributes
+	allocatableMu     sync.RWMutex
+	republishCallback func(context.Context) error
+	// podDevicesLookup returns the devices already prepared for a pod, nil until the pod manager is set up
+	podDevicesLookup func(podUID k8stypes.UID) (drasriovtypes.PreparedDevices, bool)
+	// drainedPFs are the names of the PFs whose VFs are not published
+	drainedPFs sets.Set[string]
+	// discoveryErr is the error of the last rediscovery, nil when it succeeded
+	discoveryErr error
+
+	// maxAllocationsPerPF limits the number of prepared VFs per PF, zero means no limit
+	maxAllocationsPerPF int
+	// verifyVFReset reads back the VF configuration after the reset on unprepare
+	verifyVFReset bool
+	// vfResetGracePeriod is waited before the VFs are reset on unprepare so the CNI DEL settles, zero means no wait
+	vfResetGracePeriod time.Duration
+	// vfNetdevWaitTimeout bounds the wait for the netdev of a VF on prepare, zero means no wait
+	vfNetdevWaitTimeout time.Duration
+	clock               clock.Clock
+	// verifyClaimSpecFile checks the claim CDI spec file once written
+	verifyClaimSpecFile func(drasriovtypes.PreparedDevices) error
+	// pciAddressFilesDir holds the PCI address files mounted in the containers requesting them
+	pciAddressFilesDir string
+	// envPrefix is prepended to the names of the environment variables injected for each device
+	envPrefix string
+	// defaultVfConfig is the node default config the claim configs are applied on, nil when not set
+	defaultVfConfig *configapi.VfConfig
+	// preparedPerPF counts the prepared VFs indexed by PF name
+	preparedPerPF map[string]int
+	// groupedPFs are the names of the PFs whose VFs are prepared for a VF group
+	groupedPFs      sets.Set[string]
+	preparedPerPFMu sync.Mutex
+	// prepareWebhook reviews the claims before they are prepared, nil when not configured
+	prepareWebhook *preparewebhook.Client
+	// configMapConfigs caches the VfConfigs read from the ConfigMaps referenced by the claim configs
+	configMapConfigs   map[configapi.ConfigMapReference]cachedConfigMapConfig
+	configMapConfigsMu sync.Mutex
+	// allowedHostPaths are the host paths the device nodes and the mounts of the configs must be under
+	allowedHostPaths []string
+
+	// deviceNaming, maxVFsPerNode, deviceFilter, discoveryConcurrency, extraAttributes and advertiseVFGroups
+	// are the discovery settings reused by the rediscovery
+	deviceNaming         string
+	maxVFsPerNode        int
+	deviceFilter         DeviceFilter
+	discoveryConcurrency int
+	extraAttributes      ExtraDeviceAttributes
+	advertiseVFGroups    bool
 }
 
-func NewManager(config *drasriovtypes.Config, cdi *cdi.Handler) (*Manager, error) {
-	allocatable, err := DiscoverSriovDevices()
+func NewManager(ctx context.Context, config *drasriovtypes.Config, cdi *cdi.Handler) (*Manager, error) {
+	deviceFilter, err := newDeviceFilter(config.Flags)
+	if err != nil {
+		return nil, err
+	}
+
+	if config.Flags.ManageEswitchMode != "" {
+		if err := ManageEswitchMode(config.Flags.ManageEswitchMode, deviceFilter); err != nil {
+			return nil, fmt.Errorf("error setting the PF eswitch mode: %w", err)
+		}
+	}
+
+	// the common spec can conflict with another component managing the same CDI directory
+	if !config.Flags.SkipCDICommonSpec {
+		if err := cdi.CreateCommonSpecFile(); err != nil {
+			return nil, fmt.Errorf("error creating the CDI common spec file: %w", err)
+		}
+	}
+
+	extraAttributes, err := ParseExtraDeviceAttributes(config.Flags.ExtraDeviceAttributes)
+	if err != nil {
+		return nil, err
+	}
+
+	ifNameTemplate, err := ParseIfNameTemplate(config.Flags.IfNameTemplate)
+	if err != nil {
+		return nil, err
+	}
+
+	allowedHostPaths, err := ParseAllowedHostPaths(config.Flags.AllowedHostPaths)
+	if err != nil {
+		return nil, err
+	}
+
+	allocatable, err := DiscoverSriovDevices(ctx, config.Flags.DeviceNaming, config.Flags.MaxVFsPerNode, deviceFilter, config.Flags.DiscoveryConcurrency)
 	if err != nil {
 		return nil, fmt.Errorf("error enumerating all possible devices: %v", err)
 	}
+	if config.Flags.AdvertiseVFGroups {
+		addVFGroups(allocatable)
+	}
+	addExtraAttributes(allocatable, extraAttributes)
+
+	var defaultVfConfig *configapi.VfConfig
+	if config.Flags.DefaultVfConfigFile != "" {
+		defaultVfConfig, err = loadDefaultVfConfig(configapi.Decoder, config.Flags.DefaultVfConfigFile)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var prepareWebhook *preparewebhook.Client
+	if config.Flags.PrepareWebhookURL != "" {
+		prepareWebhook, err = preparewebhook.New(config.Flags.PrepareWebhookURL, config.Flags.PrepareWebhookCAFile)
+		if err != nil {
+			return nil, err
+		}
+	}
 
 	state := &Manager{
 		k8sClient:              config.K8sClient,
 		defaultInterfacePrefix: config.Flags.DefaultInterfacePrefix,
+		ifNameTemplate:         ifNameTemplate,
 		cdi:                    cdi,
 		allocatable:            allocatable,
+		maxAllocationsPerPF:    config.Flags.MaxAllocationsPerPF,
+		verifyVFReset:          config.Flags.VerifyVFReset,
+		vfResetGracePeriod:     config.Flags.VFResetGracePeriod,
+		vfNetdevWaitTimeout:    config.Flags.VFNetdevWaitTimeout,
+		clock:                  clock.RealClock{},
+		verifyClaimSpecFile:    cdi.VerifyClaimSpecFile,
+		envPrefix:              config.Flags.EnvPrefix,
+		pciAddressFilesDir:     filepath.Join(config.DriverPluginPath(), consts.PCIAddressFilesDirName),
+		defaultVfConfig:        defaultVfConfig,
+		preparedPerPF:          map[string]int{},
+		groupedPFs:             sets.New[string](),
+		prepareWebhook:         prepareWebhook,
+		configMapConfigs:       map[configapi.ConfigMapReference]cachedConfigMapConfig{},
+		deviceNaming:           config.Flags.DeviceNaming,
+		maxVFsPerNode:          config.Flags.MaxVFsPerNode,
+		deviceFilter:           deviceFilter,
+		discoveryConcurrency:   config.Flags.DiscoveryConcurrency,
+		extraAttributes:        extraAttributes,
+		advertiseVFGroups:      config.Flags.AdvertiseVFGroups,
+		allowedHostPaths:       allowedHostPaths,
 	}
 
 	return state, nil
 }
 
-// GetAllocatableDevices returns the allocatable devices
+// GetAllocatableDevices returns a copy of the allocatable devices
 func (s *Manager) GetAllocatableDevices() drasriovtypes.AllocatableDevices {
-	return s.allocatable
+	s.allocatableMu.RLock()
+	defer s.allocatableMu.RUnlock()
+	devices := make(drasriovtypes.AllocatableDevices, len(s.allocatable))
+	for deviceName, device := range s.allocatable {
+		devices[deviceName] = *device.DeepCopy()
+	}
+	return devices
 }
 
+// GetAllocatedDeviceByDeviceName returns a copy of the allocatable device with the given name
 func (s *Manager) GetAllocatedDeviceByDeviceName(deviceName string) (resourceapi.Device, bool) {
+	s.allocatableMu.RLock()
+	defer s.allocatableMu.RUnlock()
 	device, exist := s.allocatable[deviceName]
-	return device, exist
+	if !exist {
+		return resourceapi.Device{}, false
+	}
+	return *device.DeepCopy(), true
 }
 
 // PrepareDevicesForClaim prepares the devices for a given claim
 // It will return the prepared devices for the claim
-func (s *Manager) PrepareDevicesForClaim(ctx context.Context, ifNameIndex *int, claim *resourceapi.ResourceClaim) (drasriovtypes.PreparedDevices, error) {
+func (s *Manager) PrepareDevicesForClaim(ctx context.Context, ifNameIndex *int, claim *resourceapi.ResourceClaim) (_ drasriovtypes.PreparedDevices, err error) {
 	logger := klog.FromContext(ctx).WithName("PrepareDevicesForClaim")
+	ctx, span := tracing.Start(ctx, "PrepareDevicesForClaim", tracing.AttributeClaimUID.String(string(claim.UID)))
+	defer func() { tracing.End(span, err) }()
 
-	resultsConfig, err := getMapOfOpaqueDeviceConfigForDevice(configapi.Decoder, claim.Status.Allocation.Devices.Config)
+	resolveConfigMapRef := func(ctx context.Context, config *configapi.VfConfig) (*configapi.VfConfig, error) {
+		return s.resolveConfigMapRef(ctx, claim.Namespace, config)
+	}
+	resultsConfig, err := getMapOfOpaqueDeviceConfigForDevice(ctx, configapi.Decoder, claim.Status.Allocation.Devices.Config, s.defaultVfConfig, resolveConfigMapRef)
 	if err != nil {
 		logger.Error(err, "failed to create map of opaque device config for device", "claim", *claim)
 		return nil, fmt.Errorf("error creating map of opaque device config for device: %v", err)
@@ -78,36 +231,224 @@ func (s *Manager) PrepareDevicesForClaim(ctx context.Context, ifNameIndex *int,
 		logger.Error(fmt.Errorf("no prepared devices found for claim"), "Prepare failed", "claim", *claim)
 		return nil, fmt.Errorf("no prepared devices found for claim")
 	}
+	// the devices are configured from here, roll them back with the CDI spec file if a later step fails
+	defer func() {
+		if err != nil {
+			s.RollbackPreparedClaim(ctx, string(claim.UID), preparedDevices)
+		}
+	}()
 
 	if err = s.cdi.CreateClaimSpecFile(preparedDevices); err != nil {
 		return nil, fmt.Errorf("unable to create CDI spec file for claim: %v", err)
 	}
+	if err = s.verifyClaimSpecFile(preparedDevices); err != nil {
+		return nil, fmt.Errorf("invalid CDI spec file for claim: %v", err)
+	}
 
 	return preparedDevices, nil
 }
 
+// vfNetdevPollInterval is the period the netdev of a VF is polled at while waiting for it on prepare
+const vfNetdevPollInterval = 100 * time.Millisecond
+
+// RollbackPreparedClaim reverts the devices of a claim whose prepare failed after they were configured,
+// and removes the claim CDI spec file and PCI address files written for them. The pod CDI spec file,
+// shared by the claims of the pod, is left untouched. The failures are logged and the rollback goes on.
+func (s *Manager) RollbackPreparedClaim(ctx context.Context, claimUID string, preparedDevices drasriovtypes.PreparedDevices) {
+	logger := klog.FromContext(ctx).WithName("RollbackPreparedClaim")
+	logger.Info("Rolling back the prepared devices of the claim", "claim", claimUID, "devices", len(preparedDevices))
+
+	s.revertPreparedDevices(ctx, claimUID, preparedDevices)
+	if err := s.cdi.DeleteSpecFile(claimUID); err != nil {
+		logger.Error(err, "Failed to delete the CDI spec file of the claim", "claim", claimUID)
+	}
+	s.releasePFAllocations(s.countPerPF(preparedDevices))
+}
+
+// revertPreparedDevices reverts the configured devices of a claim and removes their PCI address files.
+// The failures are logged and the revert goes on.
+func (s *Manager) revertPreparedDevices(ctx context.Context, claimUID string, preparedDevices drasriovtypes.PreparedDevices) {
+	logger := klog.FromContext(ctx).WithName("revertPreparedDevices")
+
+	if err := s.unprepareDevices(preparedDevices); err != nil {
+		logger.Error(err, "Failed to revert the devices of the claim", "claim", claimUID)
+	}
+	for _, preparedDevice := range preparedDevices {
+		if preparedDevice.Config != nil && preparedDevice.Config.WritePciAddressFile != "" {
+			if err := s.removePciAddressFile(claimUID, preparedDevice.Device.DeviceName); err != nil {
+				logger.Error(err, "Failed to delete the PCI address file of the device", "claim", claimUID, "device", preparedDevice.Device.DeviceName)
+			}
+		}
+	}
+}
+
 func (s *Manager) prepareDevices(ctx context.Context, ifNameIndex *int,
 	claim *resourceapi.ResourceClaim,
 	resultsConfig map[string]*configapi.VfConfig) (drasriovtypes.PreparedDevices, error) {
 	logger := klog.FromContext(ctx).WithName("prepareDevices")
-	preparedDevices := drasriovtypes.PreparedDevices{}
-	for _, result := range claim.Status.Allocation.Devices.Results {
+
+	reservedPerPF, err := s.reservePFAllocations(claim)
+	if err != nil {
+		return nil, err
+	}
+	prepared := false
+	defer func() {
+		if !prepared {
+			s.releasePFAllocations(reservedPerPF)
+		}
+	}()
+
+	// resolve the config of every device first so the prepare webhook reviews the whole claim
+	configs := make([]*configapi.VfConfig, len(claim.Status.Allocation.Devices.Results))
+	review := &preparewebhook.Review{Claim: claim}
+	for i, result := range claim.Status.Allocation.Devices.Results {
 		if result.Driver != consts.DriverName {
 			continue
 		}
 
 		config, ok := resultsConfig[result.Request]
+		if !ok && s.defaultVfConfig != nil {
+			// the claim has no config for the request, the node default config is used as is
+			config, ok = s.defaultVfConfig.DeepCopy(), true
+			logger.V(4).Info("No config for the request, using the node default config", "device", result.Device, "request", result.Request)
+		}
 		if !ok {
 			return nil, fmt.Errorf("config not found for request: %s", result.Request)
 		}
+		logger.V(4).Info("Selected config for device", "device", result.Device, "request", result.Request, "config", config)
 
 		// make changes if needed
 		config.Normalize()
+		configs[i] = config
+		review.Devices = append(review.Devices, preparewebhook.ReviewDevice{
+			Request: result.Request,
+			Pool:    result.Pool,
+			Device:  result.Device,
+			Config:  config,
+		})
+	}
 
-		preparedDevice, err := s.applyConfigOnDevice(ctx, ifNameIndex, claim, config, &result)
-		if err != nil {
-			logger.Error(err, "error applying config on device", "config", config, "result", result)
-			return nil, fmt.Errorf("error applying config on device: %v", err)
+	// refuse the features the devices don't support before changing anything on them
+	if err := s.checkClaimCapabilities(claim, configs); err != nil {
+		return nil, err
+	}
+	if err := s.checkDefaultRoute(claim, configs); err != nil {
+		return nil, err
+	}
+
+	if s.prepareWebhook != nil {
+		if err := s.prepareWebhook.Review(ctx, review); err != nil {
+			return nil, err
+		}
+	}
+
+	preparedDevices, err := s.applyConfigs(ctx, ifNameIndex, claim, configs)
+	if err != nil {
+		return nil, err
+	}
+
+	logger.V(3).Info("Prepared devices", "preparedDevices", preparedDevices)
+	prepared = true
+	return preparedDevices, nil
+}
+
+// checkDefaultRoute refuses a claim requesting the default route for more than one device of its pod, counting the
+// devices of the claim, the VFs of a VF group, and the devices already prepared for the pod by its other claims.
+// It runs before any device is configured so a refused claim leaves the devices untouched.
+func (s *Manager) checkDefaultRoute(claim *resourceapi.ResourceClaim, configs []*configapi.VfConfig) error {
+	owner := ""
+	if s.podDevicesLookup != nil && len(claim.Status.ReservedFor) > 0 {
+		podDevices, _ := s.podDevicesLookup(claim.Status.ReservedFor[0].UID)
+		for _, podDevice := range podDevices {
+			if podDevice.ClaimNamespacedName.UID != claim.UID && podDevice.Config != nil && podDevice.Config.MakeDefaultRoute {
+				owner = podDevice.Device.DeviceName
+			}
+		}
+	}
+
+	for i, result := range claim.Status.Allocation.Devices.Results {
+		if configs[i] == nil || !configs[i].MakeDefaultRoute {
+			continue
+		}
+		if owner != "" {
+			return fmt.Errorf("only one device of a pod can request the default route, requested by %s and %s", owner, result.Device)
+		}
+		s.allocatableMu.RLock()
+		var members []string
+		if isVFGroup(s.allocatable[result.Device]) {
+			members = s.vfGroupMembers(result.Device)
+		}
+		s.allocatableMu.RUnlock()
+		if len(members) > 1 {
+			return fmt.Errorf("only one device of a pod can request the default route, requested by the %d VFs of the group %s", len(members), result.Device)
+		}
+		owner = result.Device
+	}
+	return nil
+}
+
+// applyConfigs applies the resolved configs on the devices of the claim driven by the driver
+func (s *Manager) applyConfigs(ctx context.Context, ifNameIndex *int, claim *resourceapi.ResourceClaim,
+	configs []*configapi.VfConfig) (_ drasriovtypes.PreparedDevices, err error) {
+	logger := klog.FromContext(ctx).WithName("applyConfigs")
+	ctx, span := tracing.Start(ctx, "applyConfig", tracing.AttributeClaimUID.String(string(claim.UID)))
+	defer func() { tracing.End(span, err) }()
+
+	// walk the results by request then device name, so the prepared devices and their
+	// default interface names don't depend on the allocation order
+	results := claim.Status.Allocation.Devices.Results
+	order := make([]int, len(results))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		if results[order[i]].Request != results[order[j]].Request {
+			return results[order[i]].Request < results[order[j]].Request
+		}
+		return results[order[i]].Device < results[order[j]].Device
+	})
+
+	// revert the devices already configured when a later device fails
+	preparedDevices := drasriovtypes.PreparedDevices{}
+	defer func() {
+		if err != nil && len(preparedDevices) > 0 {
+			logger.Info("Reverting the devices configured before the failure", "claim", claim.UID, "devices", len(preparedDevices))
+			s.revertPreparedDevices(ctx, string(claim.UID), preparedDevices)
+		}
+	}()
+	for _, i := range order {
+		result := results[i]
+		if result.Driver != consts.DriverName {
+			continue
+		}
+		config := configs[i]
+
+		// a VF group is prepared as all the VFs of its PF, each configured like a device of the request
+		memberResults := []resourceapi.DeviceRequestAllocationResult{result}
+		groupDeviceName := ""
+		s.allocatableMu.RLock()
+		var members []string
+		if isVFGroup(s.allocatable[result.Device]) {
+			members = s.vfGroupMembers(result.Device)
+		}
+		s.allocatableMu.RUnlock()
+		if members != nil {
+			groupDeviceName = result.Device
+			memberResults = nil
+			for _, member := range members {
+				memberResult := result
+				memberResult.Device = member
+				memberResults = append(memberResults, memberResult)
+			}
+		}
+		for _, memberResult := range memberResults {
+			preparedDevice, err := s.applyConfigOnDevice(ctx, ifNameIndex, claim, config, &memberResult)
+			if err != nil {
+				logger.Error(err, "error applying config on device", "config", config, "result", memberResult)
+				return nil, fmt.Errorf("error applying config on device: %v", err)
+			}
+			preparedDevice.GroupDeviceName = groupDeviceName
+			preparedDevices = append(preparedDevices, preparedDevice)
 		}
 
 		rawConfig, err := json.Marshal(config)
@@ -122,21 +463,152 @@ func (s *Manager) prepareDevices(ctx context.Context, ifNameIndex *int,
 			Driver: result.Driver,
 			Data:   &runtime.RawExtension{Raw: rawConfig},
 		})
-		preparedDevices = append(preparedDevices, preparedDevice)
 	}
-
-	logger.V(3).Info("Prepared devices", "preparedDevices", preparedDevices)
 	return preparedDevices, nil
 }
 
-func (s *Manager) applyConfigOnDevice(ctx context.Context, ifNameIndex *int, claim *resourceapi.ResourceClaim, config *configapi.VfConfig, result *resourceapi.DeviceRequestAllocationResult) (*drasriovtypes.PreparedDevice, error) {
+// reservePFAllocations counts the devices of the claim per PF and reserves them,
+// returning an error if any PF would exceed the maximum number of prepared VFs.
+// A VF group counts all the VFs of its PF and can't share the PF with the VFs prepared by themselves,
+// the scheduler sees them as independent devices.
+func (s *Manager) reservePFAllocations(claim *resourceapi.ResourceClaim) (map[string]int, error) {
+	requestedPerPF := map[string]int{}
+	groupPFs := sets.New[string]()
+	vfPFs := sets.New[string]()
+	s.allocatableMu.RLock()
+	for _, result := range claim.Status.Allocation.Devices.Results {
+		if result.Driver != consts.DriverName {
+			continue
+		}
+		pfName := s.getPFName(result.Device)
+		if pfName == "" {
+			continue
+		}
+		if isVFGroup(s.allocatable[result.Device]) {
+			requestedPerPF[pfName] += len(s.vfGroupMembers(result.Device))
+			groupPFs.Insert(pfName)
+		} else {
+			requestedPerPF[pfName]++
+			vfPFs.Insert(pfName)
+		}
+	}
+	s.allocatableMu.RUnlock()
+
+	s.preparedPerPFMu.Lock()
+	defer s.preparedPerPFMu.Unlock()
+	for pfName := range groupPFs {
+		if vfPFs.Has(pfName) {
+			return nil, fmt.Errorf("the claim requests the VF group of PF %s and some of its VFs", pfName)
+		}
+		if s.preparedPerPF[pfName] > 0 {
+			return nil, fmt.Errorf("the VF group of PF %s needs all its VFs, %d already prepared", pfName, s.preparedPerPF[pfName])
+		}
+	}
+	for pfName := range vfPFs {
+		if s.groupedPFs.Has(pfName) {
+			return nil, fmt.Errorf("the VFs of PF %s are prepared for its VF group", pfName)
+		}
+	}
+	if s.maxAllocationsPerPF > 0 {
+		for pfName, requested := range requestedPerPF {
+			if s.preparedPerPF[pfName]+requested > s.maxAllocationsPerPF {
+				return nil, fmt.Errorf("allocation would exceed the maximum of %d prepared VFs on PF %s (%d already prepared, %d requested)",
+					s.maxAllocationsPerPF, pfName, s.preparedPerPF[pfName], requested)
+			}
+		}
+	}
+	for pfName, requested := range requestedPerPF {
+		s.preparedPerPF[pfName] += requested
+	}
+	s.groupedPFs.Insert(groupPFs.UnsortedList()...)
+	return requestedPerPF, nil
+}
+
+// releasePFAllocations removes previously reserved devices from the per PF counters
+func (s *Manager) releasePFAllocations(reservedPerPF map[string]int) {
+	s.preparedPerPFMu.Lock()
+	defer s.preparedPerPFMu.Unlock()
+	for pfName, reserved := range reservedPerPF {
+		s.preparedPerPF[pfName] -= reserved
+		if s.preparedPerPF[pfName] <= 0 {
+			delete(s.preparedPerPF, pfName)
+			// a VF group holds all the VFs of its PF, it is released with the last of them
+			s.groupedPFs.Delete(pfName)
+		}
+	}
+}
+
+// RecordPreparedDevices adds already prepared devices (e.g. restored from the checkpoint) to the per PF counters
+func (s *Manager) RecordPreparedDevices(preparedDevices drasriovtypes.PreparedDevices) {
+	s.allocatableMu.RLock()
+	defer s.allocatableMu.RUnlock()
+	s.preparedPerPFMu.Lock()
+	defer s.preparedPerPFMu.Unlock()
+	for _, preparedDevice := range preparedDevices {
+		if pfName := s.getPFName(preparedDevice.Device.DeviceName); pfName != "" {
+			s.preparedPerPF[pfName]++
+			if preparedDevice.GroupDeviceName != "" {
+				s.groupedPFs.Insert(pfName)
+			}
+		}
+	}
+}
+
+// ResetPreparedDevices replaces the per PF counters with the given prepared devices, e.g. when the checkpoint
+// is reloaded and another driver instance prepared or unprepared claims since the counters were built
+func (s *Manager) ResetPreparedDevices(preparedDevices drasriovtypes.PreparedDevices) {
+	s.preparedPerPFMu.Lock()
+	s.preparedPerPF = map[string]int{}
+	s.groupedPFs = sets.New[string]()
+	s.preparedPerPFMu.Unlock()
+	s.RecordPreparedDevices(preparedDevices)
+}
+
+// countPerPF counts the prepared devices per PF name, the devices of an unknown PF are not counted
+func (s *Manager) countPerPF(preparedDevices drasriovtypes.PreparedDevices) map[string]int {
+	s.allocatableMu.RLock()
+	defer s.allocatableMu.RUnlock()
+	countPerPF := map[string]int{}
+	for _, preparedDevice := range preparedDevices {
+		if pfName := s.getPFName(preparedDevice.Device.DeviceName); pfName != "" {
+			countPerPF[pfName]++
+		}
+	}
+	return countPerPF
+}
+
+// getPFName returns the name of the PF for an allocatable device, or an empty string if unknown.
+// The caller must hold allocatableMu.
+func (s *Manager) getPFName(deviceName string) string {
+	device, exist := s.allocatable[deviceName]
+	if !exist {
+		return ""
+	}
+	pfName, exist := device.Attributes[consts.AttributePFName]
+	if !exist || pfName.StringValue == nil {
+		return ""
+	}
+	return *pfName.StringValue
+}
+
+func (s *Manager) applyConfigOnDevice(ctx context.Context, ifNameIndex *int, claim *resourceapi.ResourceClaim, config *configapi.VfConfig, result *resourceapi.DeviceRequestAllocationResult) (_ *drasriovtypes.PreparedDevice, err error) {
 	logger := klog.FromContext(ctx).WithName("applyConfigOnDevice")
+	ctx, span := tracing.Start(ctx, "applyConfigOnDevice",
+		tracing.AttributeClaimUID.String(string(claim.UID)), tracing.AttributeDevice.String(result.Device))
+	defer func() { tracing.End(span, err) }()
 	logger.V(3).Info("Applying config on device", "config", config, "result", result)
-	deviceInfo, exist := s.allocatable[result.Device]
+	deviceInfo, exist := s.GetAllocatedDeviceByDeviceName(result.Device)
 	if !exist {
 		return nil, fmt.Errorf("device %s not found in allocatable devices", result.Device)
 	}
 
+	// the config is shared by the devices of the request, each device gets its own MAC address
+	if config.RandomizeMac && config.MacAddress == "" {
+		config = config.DeepCopy()
+		config.MacAddress = randomizedMacAddress(string(claim.UID), result.Device).String()
+		logger.V(2).Info("Randomized the MAC address of the device", "device", result.Device, "mac", config.MacAddress)
+	}
+
 	netAttachDefNamespace := claim.GetNamespace()
 	if config.NetAttachDefNamespace != "" {
 		netAttachDefNamespace = config.NetAttachDefNamespace
@@ -152,12 +624,44 @@ func (s *Manager) applyConfigOnDevice(ctx context.Context, ifNameIndex *int, cla
 	if err != nil {
 		return nil, fmt.Errorf("error converting net attach def config to sriov-cni format: %w", err)
 	}
+	if config.Vlan != 0 {
+		netAttachDefRawConfig, err = drasriovtypes.AddVlanToNetConf(netAttachDefRawConfig, config.Vlan)
+		if err != nil {
+			return nil, fmt.Errorf("error setting the vlan of the net attach def config: %w", err)
+		}
+	}
 	// Bind device to driver if specified in config
 	originalDriver, err := host.GetHelpers().BindDeviceDriver(pciAddress, config)
 	if err != nil {
 		return nil, fmt.Errorf("error binding device %s to driver: %w", pciAddress, err)
 	}
 
+	// the driver can still be probing the VF, the CNI ADD fails without its netdev
+	if s.vfNetdevWaitTimeout > 0 && (config.Driver == "" || !host.GetHelpers().IsDpdkDriver(config.Driver)) {
+		if err := s.waitForVFNetdev(ctx, pciAddress); err != nil {
+			return nil, err
+		}
+	}
+
+	if config.MacAddress != "" {
+		if err := setVFMacAddress(deviceInfo, pciAddress, config.MacAddress); err != nil {
+			return nil, fmt.Errorf("error setting MAC address on device %s: %w", pciAddress, err)
+		}
+	}
+
+	if config.MinTxRate != 0 || config.MaxTxRate != 0 {
+		if err := host.GetHelpers().SetVFRate(pciAddress, config.MinTxRate, config.MaxTxRate); err != nil {
+			return nil, fmt.Errorf("error setting tx rate on device %s: %w", pciAddress, err)
+		}
+	}
+
+	// auto is the link state of a reset VF, it doesn't need to be set
+	if config.LinkState != "" && config.LinkState != configapi.LinkStateAuto {
+		if err := host.GetHelpers().SetVFLinkState(pciAddress, config.LinkState); err != nil {
+			return nil, fmt.Errorf("error setting link state on device %s: %w", pciAddress, err)
+		}
+	}
+
 	// Ensure that the kernel module are loaded if the user request vhost mounts
 	if config.AddVhostMount {
 		if err := host.GetHelpers().EnsureVhostModulesLoaded(); err != nil {
@@ -167,8 +671,8 @@ func (s *Manager) applyConfigOnDevice(ctx context.Context, ifNameIndex *int, cla
 
 	// create environment variables
 	envs := []string{
-		fmt.Sprintf("SRIOVNETWORK_VF_DEVICE_%s=%s", strings.ReplaceAll(result.Device, "-", "_"), *deviceInfo.Attributes[consts.AttributePciAddress].StringValue),
-		fmt.Sprintf("SRIOVNETWORK_NET_ATTACH_DEF_NAME=%s", config.NetAttachDefName),
+		fmt.Sprintf("%sSRIOVNETWORK_VF_DEVICE_%s=%s", s.envPrefix, strings.ReplaceAll(result.Device, "-", "_"), *deviceInfo.Attributes[consts.AttributePciAddress].StringValue),
+		fmt.Sprintf("%sSRIOVNETWORK_NET_ATTACH_DEF_NAME=%s", s.envPrefix, config.NetAttachDefName),
 	}
 
 	// Prepare device nodes slice for potential VFIO devices
@@ -196,7 +700,7 @@ func (s *Manager) applyConfigOnDevice(ctx context.Context, ifNameIndex *int, cla
 			Type:     "c", // character device
 		})
 
-		envs = append(envs, fmt.Sprintf("SRIOVNETWORK_%s_VFIO_DEVICE=%s", strings.ReplaceAll(result.Device, "-", "_"), devFileContainer))
+		envs = append(envs, fmt.Sprintf("%sSRIOVNETWORK_%s_VFIO_DEVICE=%s", s.envPrefix, strings.ReplaceAll(result.Device, "-", "_"), devFileContainer))
 		logger.V(2).Info("Added VFIO device nodes for device", "device", pciAddress, "hostPath", devFileHost, "containerPath", devFileContainer)
 	}
 
@@ -214,16 +718,71 @@ func (s *Manager) applyConfigOnDevice(ctx context.Context, ifNameIndex *int, cla
 		})
 	}
 
+	// add the additional device nodes and mounts requested in the config
+	for _, deviceNode := range config.DeviceNodes {
+		if err := s.checkHostPath(deviceNode); err != nil {
+			return nil, fmt.Errorf("requested device node %s is not allowed: %w", deviceNode, err)
+		}
+		if !host.GetHelpers().PathExists(deviceNode) {
+			return nil, fmt.Errorf("requested device node %s does not exist on the host", deviceNode)
+		}
+		deviceNodes = append(deviceNodes, &cdispec.DeviceNode{
+			Path:     deviceNode,
+			HostPath: deviceNode,
+		})
+	}
+
+	var mounts []*cdispec.Mount
+	for _, mount := range config.Mounts {
+		if err := s.checkHostPath(mount.HostPath); err != nil {
+			return nil, fmt.Errorf("requested mount host path %s is not allowed: %w", mount.HostPath, err)
+		}
+		if !host.GetHelpers().PathExists(mount.HostPath) {
+			return nil, fmt.Errorf("requested mount host path %s does not exist on the host", mount.HostPath)
+		}
+		mountOptions := []string{"rbind", "ro"}
+		if !mount.IsReadOnly() {
+			mountOptions = []string{"rbind", "rw"}
+		}
+		mounts = append(mounts, &cdispec.Mount{
+			HostPath:      mount.HostPath,
+			ContainerPath: mount.ContainerPath,
+			Type:          "bind",
+			Options:       mountOptions,
+		})
+	}
+
+	// some DPDK apps read their PCI address from a file instead of the environment
+	if config.WritePciAddressFile != "" {
+		pciAddressFile, err := s.writePciAddressFile(string(claim.UID), result.Device, pciAddress)
+		if err != nil {
+			return nil, fmt.Errorf("error writing the PCI address file of device %s: %w", pciAddress, err)
+		}
+		mounts = append(mounts, &cdispec.Mount{
+			HostPath:      pciAddressFile,
+			ContainerPath: config.WritePciAddressFile,
+			Type:          "bind",
+			Options:       []string{"rbind", "ro"},
+		})
+	}
+
 	edits := &cdispec.ContainerEdits{
 		Env:         envs,
 		DeviceNodes: deviceNodes,
+		Mounts:      mounts,
 	}
 
 	ifName := config.IfName
-	// if the device name is not set, we use the default interface prefix
-	// and the interface index, we also bump the index.
+	// if the device name is not set, we use the interface name template or the default
+	// interface prefix with the interface index, we also bump the index.
 	if ifName == "" {
 		ifName = fmt.Sprintf("%s%d", s.defaultInterfacePrefix, *ifNameIndex)
+		if s.ifNameTemplate != nil {
+			ifName, err = s.ifNameTemplate.Render(*ifNameIndex)
+			if err != nil {
+				return nil, fmt.Errorf("error naming the interface of device %s: %w", result.Device, err)
+			}
+		}
 		*ifNameIndex++
 	}
 
@@ -239,7 +798,8 @@ func (s *Manager) applyConfigOnDevice(ctx context.Context, ifNameIndex *int, cla
 			RequestNames: []string{result.Request},
 			PoolName:     result.Pool,
 			DeviceName:   result.Device,
-			CDIDeviceIDs: []string{s.cdi.GetClaimDevices(string(claim.UID), result.Device), s.cdi.GetPodSpecName(string(claim.Status.ReservedFor[0].UID))},
+			CDIDeviceIDs: append([]string{s.cdi.GetClaimDevices(string(claim.UID), result.Device), s.cdi.GetPodSpecName(string(claim.Status.ReservedFor[0].UID))},
+				s.cdi.GetCommonDevices()...),
 		},
 		ContainerEdits:     &cdiapi.ContainerEdits{ContainerEdits: edits},
 		NetAttachDefConfig: netAttachDefRawConfig,
@@ -263,9 +823,33 @@ func (s *Manager) getNetAttachDefRawConfig(ctx context.Context, namespace string
 	if err != nil {
 		return "", fmt.Errorf("error getting net attach def for net attach def %s/%s: %w", namespace, netAttachDefName, err)
 	}
+	if err := validateNetAttachDefConfig(netAttachDef.Spec.Config); err != nil {
+		return "", fmt.Errorf("invalid config in net attach def %s/%s: %w", namespace, netAttachDefName, err)
+	}
 	return netAttachDef.Spec.Config, nil
 }
 
+// validateNetAttachDefConfig checks the net attach def config can be used by the CNI runtime,
+// so a broken net attach def fails the prepare instead of the network attach later on
+func validateNetAttachDefConfig(rawConfig string) error {
+	if strings.TrimSpace(rawConfig) == "" {
+		return fmt.Errorf("config is empty")
+	}
+	var netConf map[string]interface{}
+	if err := json.Unmarshal([]byte(rawConfig), &netConf); err != nil {
+		return fmt.Errorf("failed to parse config: %w", err)
+	}
+	if netConf == nil {
+		return fmt.Errorf("config is not a JSON object")
+	}
+	_, hasType := netConf["type"]
+	_, hasPlugins := netConf["plugins"]
+	if !hasType && !hasPlugins {
+		return fmt.Errorf("config must define either a type or a plugins list")
+	}
+	return nil
+}
+
 func (s *Manager) Unprepare(claimUID string, preparedDevices drasriovtypes.PreparedDevices) error {
 	if err := s.unprepareDevices(preparedDevices); err != nil {
 		return fmt.Errorf("unprepare failed: %v", err)
@@ -281,12 +865,93 @@ func (s *Manager) Unprepare(claimUID string, preparedDevices drasriovtypes.Prepa
 		return fmt.Errorf("unable to delete CDI spec file for PodUID: %v", err)
 	}
 
+	for _, preparedDevice := range preparedDevices {
+		if preparedDevice.Config != nil && preparedDevice.Config.WritePciAddressFile != "" {
+			if err := s.removePciAddressFile(claimUID, preparedDevice.Device.DeviceName); err != nil {
+				return fmt.Errorf("unable to delete the PCI address file of device %s: %v", preparedDevice.Device.DeviceName, err)
+			}
+		}
+	}
+
+	s.releasePFAllocations(s.countPerPF(preparedDevices))
+
 	return nil
 }
 
+// pciAddressFilePath returns the host path of the PCI address file of a claim device
+func (s *Manager) pciAddressFilePath(claimUID, deviceName string) string {
+	return filepath.Join(s.pciAddressFilesDir, fmt.Sprintf("%s-%s", claimUID, deviceName))
+}
+
+// writePciAddressFile writes the PCI address of a claim device in the file mounted in the container and returns its host path
+func (s *Manager) writePciAddressFile(claimUID, deviceName, pciAddress string) (string, error) {
+	if err := os.MkdirAll(s.pciAddressFilesDir, 0755); err != nil {
+		return "", err
+	}
+	path := s.pciAddressFilePath(claimUID, deviceName)
+	if err := os.WriteFile(path, []byte(pciAddress+"\n"), 0644); err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+// removePciAddressFile removes the PCI address file of a claim device, a missing file is not an error
+func (s *Manager) removePciAddressFile(claimUID, deviceName string) error {
+	if err := os.Remove(s.pciAddressFilePath(claimUID, deviceName)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// randomizedMacAddress returns a locally administered unicast MAC address derived from the claim UID
+// and the device name, so it is the same for a device of a claim across the driver restarts
+func randomizedMacAddress(claimUID, deviceName string) net.HardwareAddr {
+	sum := sha256.Sum256([]byte(claimUID + "/" + deviceName))
+	mac := net.HardwareAddr(sum[:6])
+	// set the locally administered bit and clear the multicast bit
+	mac[0] = (mac[0] | 0x02) &^ 0x01
+	return mac
+}
+
+// waitForVFNetdev polls the netdev of the VF until it appears, failing once the netdev wait timeout expires
+func (s *Manager) waitForVFNetdev(ctx context.Context, pciAddress string) error {
+	logger := klog.FromContext(ctx).WithName("waitForVFNetdev")
+	deadline := s.clock.Now().Add(s.vfNetdevWaitTimeout)
+	for {
+		if netName := host.GetHelpers().TryGetInterfaceName(pciAddress); netName != "" {
+			return nil
+		}
+		if !s.clock.Now().Before(deadline) {
+			return fmt.Errorf("the netdev of device %s didn't appear within %s, its driver may still be probing it", pciAddress, s.vfNetdevWaitTimeout)
+		}
+		logger.V(2).Info("Waiting for the netdev of the device", "device", pciAddress)
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("error waiting for the netdev of device %s: %w", pciAddress, ctx.Err())
+		case <-s.clock.After(vfNetdevPollInterval):
+		}
+	}
+}
+
+// setVFMacAddress sets the VF MAC address according to the eswitch mode of its PF,
+// switchdev PFs configure it on the VF representor port instead of the legacy VF ndo
+func setVFMacAddress(deviceInfo resourceapi.Device, pciAddress, macAddress string) error {
+	eswitchMode := deviceInfo.Attributes[consts.AttributeEswitchMode].StringValue
+	if eswitchMode != nil && *eswitchMode == consts.EswitchModeSwitchdev {
+		return host.GetHelpers().SetVFRepresentorMacAddress(pciAddress, macAddress)
+	}
+	return host.GetHelpers().SetVFMacAddress(pciAddress, macAddress)
+}
+
 // unprepareDevices reverts the driver configuration for the prepared devices
 func (s *Manager) unprepareDevices(preparedDevices drasriovtypes.PreparedDevices) error {
 	logger := klog.FromContext(context.Background()).WithName("unprepareDevices")
+	// the network was detached from the pod on StopPodSandbox, some drivers still process the CNI DEL
+	// and the reset must not race it
+	if s.vfResetGracePeriod > 0 && len(preparedDevices) > 0 {
+		logger.V(2).Info("Waiting before resetting the VFs", "gracePeriod", s.vfResetGracePeriod)
+		<-s.clock.After(s.vfResetGracePeriod)
+	}
 	for _, preparedDevice := range preparedDevices {
 		// Restore original driver if a driver change was made
 		if preparedDevice.Config.Driver != "" {
@@ -296,6 +961,21 @@ func (s *Manager) unprepareDevices(preparedDevices drasriovtypes.PreparedDevices
 			}
 			logger.V(2).Info("Successfully restored original driver for device", "device", preparedDevice.PciAddress, "originalDriver", preparedDevice.OriginalDriver)
 		}
+
+		// Clear the MAC address, VLAN and rate left on the PF for the VF
+		if err := host.GetHelpers().ResetVF(preparedDevice.PciAddress); err != nil {
+			logger.Error(err, "Failed to reset VF configuration", "device", preparedDevice.PciAddress)
+			if s.verifyVFReset {
+				return fmt.Errorf("failed to reset VF configuration for device %s: %w", preparedDevice.PciAddress, err)
+			}
+			continue
+		}
+		if s.verifyVFReset {
+			if err := host.GetHelpers().VerifyVFReset(preparedDevice.PciAddress); err != nil {
+				logger.Error(err, "VF reset verification failed", "device", preparedDevice.PciAddress)
+				return fmt.Errorf("VF reset verification failed for device %s: %w", preparedDevice.PciAddress, err)
+			}
+		}
 	}
 	return nil
 }
@@ -305,6 +985,8 @@ func (s *Manager) unprepareDevices(preparedDevices drasriovtypes.PreparedDevices
 func (s *Manager) UpdateDeviceResourceNames(ctx context.Context, deviceResourceMap map[string]string) error {
 	logger := klog.FromContext(ctx).WithName("UpdateDeviceResourceNames")
 	logger.V(2).Info("Updating device resource names", "deviceCount", len(deviceResourceMap))
+	s.allocatableMu.Lock()
+	defer s.allocatableMu.Unlock()
 
 	// Track if any changes were made
 	changesMade := false
@@ -312,6 +994,8 @@ func (s *Manager) UpdateDeviceResourceNames(ctx context.Context, deviceResourceM
 	// Update allocatable devices with resource names
 	for deviceName, resourceName := range deviceResourceMap {
 		if device, exists := s.allocatable[deviceName]; exists {
+			// the attributes may be shared with the devices handed out, they are changed on a copy
+			device = *device.DeepCopy()
 			// Add or update the resource name attribute
 			if resourceName != "" {
 				// Set resource name
@@ -347,6 +1031,7 @@ func (s *Manager) UpdateDeviceResourceNames(ctx context.Context, deviceResourceM
 	for deviceName, device := range s.allocatable {
 		if _, inMap := deviceResourceMap[deviceName]; !inMap {
 			if _, exists := device.Attributes[consts.AttributeResourceName]; exists {
+				device = *device.DeepCopy()
 				delete(device.Attributes, consts.AttributeResourceName)
 				s.allocatable[deviceName] = device
 				changesMade = true
@@ -375,6 +1060,12 @@ func (s *Manager) UpdateDeviceResourceNames(ctx context.Context, deviceResourceM
 	return nil
 }
 
+// SetPodDevicesLookup sets the function returning the devices already prepared for a pod,
+// the default route requests of the claims are checked against them
+func (s *Manager) SetPodDevicesLookup(lookup func(podUID k8stypes.UID) (drasriovtypes.PreparedDevices, bool)) {
+	s.podDevicesLookup = lookup
+}
+
 // SetRepublishCallback sets the callback function to trigger resource republishing
 func (s *Manager) SetRepublishCallback(callback func(context.Context) error) {
 	s.republishCallback = callback
@@ -0,0 +1,1692 @@
+package devicestate_test
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/fs"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"github.com/jaypipes/ghw"
+	"github.com/jaypipes/ghw/pkg/pci"
+	netattdefv1 "github.com/k8snetworkplumbingwg/network-attachment-definition-client/pkg/apis/k8s.cni.cncf.io/v1"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.uber.org/mock/gomock"
+	corev1 "k8s.io/api/core/v1"
+	resourceapi "k8s.io/api/resource/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	k8stypes "k8s.io/apimachinery/pkg/types"
+	"k8s.io/klog/v2"
+	"k8s.io/klog/v2/ktesting"
+	drapbv1 "k8s.io/kubelet/pkg/apis/dra/v1beta1"
+	clocktesting "k8s.io/utils/clock/testing"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	configapi "github.com/SchSeba/dra-driver-sriov/pkg/api/virtualfunction/v1alpha1"
+	"github.com/SchSeba/dra-driver-sriov/pkg/cdi"
+	"github.com/SchSeba/dra-driver-sriov/pkg/consts"
+	"github.com/SchSeba/dra-driver-sriov/pkg/devicestate"
+	"github.com/SchSeba/dra-driver-sriov/pkg/flags"
+	"github.com/SchSeba/dra-driver-sriov/pkg/host"
+	mock_host "github.com/SchSeba/dra-driver-sriov/pkg/host/mock"
+	"github.com/SchSeba/dra-driver-sriov/pkg/preparewebhook"
+	"github.com/SchSeba/dra-driver-sriov/pkg/tracing"
+	draTypes "github.com/SchSeba/dra-driver-sriov/pkg/types"
+)
+
+const testVfConfig = `{"apiVersion":"sriovnetwork.openshift.io/v1alpha1","kind":"VfConfig","netAttachDefName":"test-net"}`
+
+// newClaim returns an allocated ResourceClaim reserved for a pod with one result per device
+func newClaim(claimUID, podUID string, devices ...string) *resourceapi.ResourceClaim {
+	return newClaimWithConfig(testVfConfig, claimUID, podUID, devices...)
+}
+
+// newClaimWithConfig returns an allocated ResourceClaim using the given raw VfConfig for all the devices
+func newClaimWithConfig(rawConfig, claimUID, podUID string, devices ...string) *resourceapi.ResourceClaim {
+	results := []resourceapi.DeviceRequestAllocationResult{}
+	for _, device := range devices {
+		results = append(results, resourceapi.DeviceRequestAllocationResult{
+			Request: "vf",
+			Driver:  consts.DriverName,
+			Pool:    "test-node",
+			Device:  device,
+		})
+	}
+
+	return &resourceapi.ResourceClaim{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "claim-" + claimUID,
+			Namespace: "default",
+			UID:       k8stypes.UID(claimUID),
+		},
+		Status: resourceapi.ResourceClaimStatus{
+			Allocation: &resourceapi.AllocationResult{
+				Devices: resourceapi.DeviceAllocationResult{
+					Results: results,
+					Config: []resourceapi.DeviceAllocationConfiguration{
+						{
+							Source:   resourceapi.AllocationConfigSourceClaim,
+							Requests: []string{"vf"},
+							DeviceConfiguration: resourceapi.DeviceConfiguration{
+								Opaque: &resourceapi.OpaqueDeviceConfiguration{
+									Driver:     consts.DriverName,
+									Parameters: runtime.RawExtension{Raw: []byte(rawConfig)},
+								},
+							},
+						},
+					},
+				},
+			},
+			ReservedFor: []resourceapi.ResourceClaimConsumerReference{
+				{Resource: "pods", Name: "pod-" + podUID, UID: k8stypes.UID(podUID)},
+			},
+		},
+	}
+}
+
+var _ = Describe("Manager", func() {
+	var (
+		ctx          context.Context
+		tempDir      string
+		config       *draTypes.Config
+		cdiHandler   *cdi.Handler
+		mockCtrl     *gomock.Controller
+		mockHost     *mock_host.MockInterface
+		originalHost host.Interface
+		ifNameIndex  int
+		eswitchMode  string
+		linkUp       bool
+		vfList       []host.VFInfo
+	)
+
+	BeforeEach(func() {
+		var err error
+		ctx = context.Background()
+		ifNameIndex = 0
+		eswitchMode = consts.EswitchModeLegacy
+		linkUp = true
+		vfList = []host.VFInfo{
+			{PciAddress: "0000:01:00.1", VFID: 0, DeviceID: "154c"},
+			{PciAddress: "0000:01:00.2", VFID: 1, DeviceID: "154c"},
+			{PciAddress: "0000:01:00.3", VFID: 2, DeviceID: "154c"},
+		}
+
+		tempDir, err = os.MkdirTemp("", "devicestate-test-*")
+		Expect(err).NotTo(HaveOccurred())
+		cdiHandler, err = cdi.NewHandler(tempDir, cdi.DefaultVendor)
+		Expect(err).NotTo(HaveOccurred())
+
+		netAttachDef := &netattdefv1.NetworkAttachmentDefinition{
+			ObjectMeta: metav1.ObjectMeta{Name: "test-net", Namespace: "default"},
+			Spec: netattdefv1.NetworkAttachmentDefinitionSpec{
+				Config: `{"cniVersion":"1.0.0","name":"test-net","type":"sriov"}`,
+			},
+		}
+		config = &draTypes.Config{
+			Flags: &draTypes.Flags{
+				DefaultInterfacePrefix: "net",
+				SkipCDICommonSpec:      true,
+			},
+			K8sClient: flags.ClientSets{
+				Client: fake.NewClientBuilder().WithScheme(flags.Scheme).WithObjects(netAttachDef).Build(),
+			},
+		}
+
+		mockCtrl = gomock.NewController(GinkgoT())
+		mockHost = mock_host.NewMockInterface(mockCtrl)
+		originalHost = host.GetHelpers()
+		host.Helpers = mockHost
+
+		mockHost.EXPECT().PCI().DoAndReturn(func() (*ghw.PCIInfo, error) {
+			pfDevice := newPFDevice("0000:01:00.0")
+			pfDevice.Vendor.ID = "8086"
+			return &ghw.PCIInfo{Devices: []*pci.Device{pfDevice}}, nil
+		}).AnyTimes()
+		mockHost.EXPECT().IsSriovVF("0000:01:00.0").Return(false).AnyTimes()
+		mockHost.EXPECT().TryGetInterfaceName("0000:01:00.0").Return("eth0").AnyTimes()
+		mockHost.EXPECT().GetNicSriovMode("0000:01:00.0").DoAndReturn(func(string) string { return eswitchMode }).AnyTimes()
+		mockHost.EXPECT().GetSriovNumVFs("0000:01:00.0").Return(3, nil).AnyTimes()
+		mockHost.EXPECT().GetSriovTotalVFs("0000:01:00.0").Return(64, nil).AnyTimes()
+		mockHost.EXPECT().GetSriovVFTotalMsix("0000:01:00.0").Return(0, fs.ErrNotExist).AnyTimes()
+		mockHost.EXPECT().GetPermanentMacAddress("eth0").Return("aa:bb:cc:dd:ee:01", nil).AnyTimes()
+		mockHost.EXPECT().IsLinkUp("eth0").DoAndReturn(func(string) (bool, error) { return linkUp, nil }).AnyTimes()
+		mockHost.EXPECT().GetBondMaster("eth0").Return("", nil).AnyTimes()
+		mockHost.EXPECT().GetVFRepresentor("eth0", gomock.Any()).DoAndReturn(func(_ string, vfIndex int) (string, error) { return fmt.Sprintf("eth0_%d", vfIndex), nil }).AnyTimes()
+		mockHost.EXPECT().GetNumaNode("0000:01:00.0").Return("0", nil).AnyTimes()
+		mockHost.EXPECT().GetParentPciAddress("0000:01:00.0").Return("0000:00:01.0", nil).AnyTimes()
+		mockHost.EXPECT().GetPciRootPort("0000:01:00.0").Return("0000:00:01.0", nil).AnyTimes()
+		mockHost.EXPECT().GetEswitchMode("0000:01:00.0").Return("switchdev", nil).AnyTimes()
+		mockHost.EXPECT().GetDriverByBusAndDevice("0000:01:00.0").Return("ice", nil).AnyTimes()
+		mockHost.EXPECT().GetVFList("0000:01:00.0").DoAndReturn(func(string) ([]host.VFInfo, error) { return vfList, nil }).AnyTimes()
+		mockHost.EXPECT().BindDeviceDriver(gomock.Any(), gomock.Any()).Return("", nil).AnyTimes()
+	})
+
+	AfterEach(func() {
+		host.Helpers = originalHost
+		mockCtrl.Finish()
+		os.RemoveAll(tempDir)
+	})
+
+	Context("container edits", func() {
+		var manager *devicestate.Manager
+
+		BeforeEach(func() {
+			config.Flags.AllowedHostPaths = "/dev/vfio,/dev/hugepages,/dev/missing,/mnt"
+			var err error
+			manager, err = devicestate.NewManager(ctx, config, cdiHandler)
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("should include the vfio device node and the requested mounts for a vfio-bound VF", func() {
+			rawConfig := `{"apiVersion":"sriovnetwork.openshift.io/v1alpha1","kind":"VfConfig","netAttachDefName":"test-net",` +
+				`"driver":"vfio-pci","deviceNodes":["/dev/vfio/extra"],"mounts":[{"hostPath":"/dev/hugepages","containerPath":"/hugepages"}]}`
+			mockHost.EXPECT().GetVFIODeviceFile("0000:01:00.1").Return("/dev/vfio/42", "/dev/vfio/42", nil)
+			mockHost.EXPECT().PathExists("/dev/vfio/extra").Return(true)
+			mockHost.EXPECT().PathExists("/dev/hugepages").Return(true)
+
+			preparedDevices, err := manager.PrepareDevicesForClaim(ctx, &ifNameIndex, newClaimWithConfig(rawConfig, "claim-1", "pod-1", "0000-01-00-1"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(preparedDevices).To(HaveLen(1))
+
+			edits := preparedDevices[0].ContainerEdits.ContainerEdits
+			devicePaths := []string{}
+			for _, deviceNode := range edits.DeviceNodes {
+				devicePaths = append(devicePaths, deviceNode.Path)
+			}
+			Expect(devicePaths).To(ContainElements("/dev/vfio/42", "/dev/vfio/vfio", "/dev/vfio/extra"))
+			Expect(edits.Mounts).To(HaveLen(1))
+			Expect(edits.Mounts[0].HostPath).To(Equal("/dev/hugepages"))
+			Expect(edits.Mounts[0].ContainerPath).To(Equal("/hugepages"))
+			Expect(edits.Mounts[0].Options).To(Equal([]string{"rbind", "ro"}))
+		})
+
+		It("should mount the host path writable only when readOnly is false", func() {
+			rawConfig := `{"apiVersion":"sriovnetwork.openshift.io/v1alpha1","kind":"VfConfig","netAttachDefName":"test-net",` +
+				`"mounts":[{"hostPath":"/dev/hugepages","containerPath":"/hugepages","readOnly":false}]}`
+			mockHost.EXPECT().PathExists("/dev/hugepages").Return(true)
+
+			preparedDevices, err := manager.PrepareDevicesForClaim(ctx, &ifNameIndex, newClaimWithConfig(rawConfig, "claim-1", "pod-1", "0000-01-00-1"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(preparedDevices[0].ContainerEdits.ContainerEdits.Mounts[0].Options).To(Equal([]string{"rbind", "rw"}))
+		})
+
+		DescribeTable("should reject the host paths that are not allowed",
+			func(rawPaths, reason string) {
+				rawConfig := `{"apiVersion":"sriovnetwork.openshift.io/v1alpha1","kind":"VfConfig","netAttachDefName":"test-net",` + rawPaths + `}`
+
+				_, err := manager.PrepareDevicesForClaim(ctx, &ifNameIndex, newClaimWithConfig(rawConfig, "claim-1", "pod-1", "0000-01-00-1"))
+				Expect(err).To(MatchError(ContainSubstring(reason)))
+			},
+			Entry("device node outside the allowed paths", `"deviceNodes":["/dev/mem"]`,
+				"requested device node /dev/mem is not allowed: not under the allowed host paths"),
+			Entry("mount outside the allowed paths", `"mounts":[{"hostPath":"/var/lib/kubelet","containerPath":"/kubelet"}]`,
+				"requested mount host path /var/lib/kubelet is not allowed: not under the allowed host paths"),
+			Entry("allowed path prefix of another directory", `"mounts":[{"hostPath":"/mnt2","containerPath":"/data"}]`,
+				"requested mount host path /mnt2 is not allowed"),
+			Entry("path escaping the allowed path", `"mounts":[{"hostPath":"/mnt/../etc/shadow","containerPath":"/shadow"}]`,
+				"the host path /etc is denied"),
+			Entry("root", `"mounts":[{"hostPath":"/","containerPath":"/host"}]`,
+				"the host path / is denied"),
+			Entry("procfs", `"mounts":[{"hostPath":"/proc/1/root","containerPath":"/host"}]`,
+				"the host path /proc is denied"),
+			Entry("runtime socket", `"mounts":[{"hostPath":"/var/run/containerd/containerd.sock","containerPath":"/sock"}]`,
+				"the host path /var/run is denied"),
+		)
+
+		It("should reject every device node and mount when no host path is allowed", func() {
+			config.Flags.AllowedHostPaths = ""
+			manager, err := devicestate.NewManager(ctx, config, cdiHandler)
+			Expect(err).NotTo(HaveOccurred())
+			rawConfig := `{"apiVersion":"sriovnetwork.openshift.io/v1alpha1","kind":"VfConfig","netAttachDefName":"test-net","deviceNodes":["/dev/vfio/extra"]}`
+
+			_, err = manager.PrepareDevicesForClaim(ctx, &ifNameIndex, newClaimWithConfig(rawConfig, "claim-1", "pod-1", "0000-01-00-1"))
+			Expect(err).To(MatchError(ContainSubstring("requested device node /dev/vfio/extra is not allowed: no host path is allowed on this node")))
+		})
+
+		DescribeTable("should fail to start with an invalid allowed host path",
+			func(allowedHostPaths, reason string) {
+				config.Flags.AllowedHostPaths = allowedHostPaths
+				_, err := devicestate.NewManager(ctx, config, cdiHandler)
+				Expect(err).To(MatchError(ContainSubstring(reason)))
+			},
+			Entry("relative", "dev/hugepages", `invalid allowed host path "dev/hugepages": must be absolute`),
+			Entry("root", "/dev/hugepages,/", `invalid allowed host path "/": the host path / is denied`),
+			Entry("under a denied path", "/sys/bus/pci", `invalid allowed host path "/sys/bus/pci": the host path /sys is denied`),
+		)
+
+		It("should inject the environment variables without a prefix by default", func() {
+			preparedDevices, err := manager.PrepareDevicesForClaim(ctx, &ifNameIndex, newClaim("claim-1", "pod-1", "0000-01-00-1"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(preparedDevices[0].ContainerEdits.ContainerEdits.Env).To(ConsistOf(
+				"SRIOVNETWORK_VF_DEVICE_0000_01_00_1=0000:01:00.1",
+				"SRIOVNETWORK_NET_ATTACH_DEF_NAME=test-net"))
+		})
+
+		It("should prepend the environment variable prefix to the injected variables", func() {
+			config.Flags.EnvPrefix = "MYAPP_"
+			manager, err := devicestate.NewManager(ctx, config, cdiHandler)
+			Expect(err).NotTo(HaveOccurred())
+
+			preparedDevices, err := manager.PrepareDevicesForClaim(ctx, &ifNameIndex, newClaim("claim-1", "pod-1", "0000-01-00-1"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(preparedDevices[0].ContainerEdits.ContainerEdits.Env).To(ConsistOf(
+				"MYAPP_SRIOVNETWORK_VF_DEVICE_0000_01_00_1=0000:01:00.1",
+				"MYAPP_SRIOVNETWORK_NET_ATTACH_DEF_NAME=test-net"))
+		})
+
+		It("should mount a file holding the PCI address at the requested container path", func() {
+			config.Flags.KubeletPluginsDirectoryPath = tempDir
+			manager, err := devicestate.NewManager(ctx, config, cdiHandler)
+			Expect(err).NotTo(HaveOccurred())
+			rawConfig := `{"apiVersion":"sriovnetwork.openshift.io/v1alpha1","kind":"VfConfig","netAttachDefName":"test-net","writePciAddressFile":"/etc/podinfo/pci-address"}`
+
+			preparedDevices, err := manager.PrepareDevicesForClaim(ctx, &ifNameIndex, newClaimWithConfig(rawConfig, "claim-1", "pod-1", "0000-01-00-1"))
+			Expect(err).NotTo(HaveOccurred())
+
+			edits := preparedDevices[0].ContainerEdits.ContainerEdits
+			Expect(edits.Mounts).To(HaveLen(1))
+			Expect(edits.Mounts[0].ContainerPath).To(Equal("/etc/podinfo/pci-address"))
+			Expect(edits.Mounts[0].Options).To(Equal([]string{"rbind", "ro"}))
+			Expect(edits.Mounts[0].HostPath).To(HavePrefix(config.DriverPluginPath()))
+			Expect(os.ReadFile(edits.Mounts[0].HostPath)).To(Equal([]byte("0000:01:00.1\n")))
+
+			mockHost.EXPECT().ResetVF("0000:01:00.1").Return(nil)
+			Expect(manager.Unprepare("claim-1", preparedDevices)).To(Succeed())
+			Expect(edits.Mounts[0].HostPath).NotTo(BeAnExistingFile())
+		})
+
+		It("should reject a requested device node that does not exist on the host", func() {
+			rawConfig := `{"apiVersion":"sriovnetwork.openshift.io/v1alpha1","kind":"VfConfig","netAttachDefName":"test-net","deviceNodes":["/dev/missing"]}`
+			mockHost.EXPECT().PathExists("/dev/missing").Return(false)
+
+			_, err := manager.PrepareDevicesForClaim(ctx, &ifNameIndex, newClaimWithConfig(rawConfig, "claim-1", "pod-1", "0000-01-00-1"))
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("requested device node /dev/missing does not exist on the host"))
+		})
+
+		It("should reject a requested mount whose host path does not exist", func() {
+			rawConfig := `{"apiVersion":"sriovnetwork.openshift.io/v1alpha1","kind":"VfConfig","netAttachDefName":"test-net",` +
+				`"mounts":[{"hostPath":"/mnt/missing","containerPath":"/data","readOnly":true}]}`
+			mockHost.EXPECT().PathExists("/mnt/missing").Return(false)
+
+			_, err := manager.PrepareDevicesForClaim(ctx, &ifNameIndex, newClaimWithConfig(rawConfig, "claim-1", "pod-1", "0000-01-00-1"))
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("requested mount host path /mnt/missing does not exist on the host"))
+		})
+	})
+
+	Context("ConfigMap reference", func() {
+		var (
+			manager   *devicestate.Manager
+			k8sClient client.Client
+		)
+
+		configMapRefVfConfig := func(inline string) string {
+			return `{"apiVersion":"sriovnetwork.openshift.io/v1alpha1","kind":"VfConfig","configMapRef":{"name":"vf-config"}` + inline + `}`
+		}
+
+		BeforeEach(func() {
+			k8sClient = fake.NewClientBuilder().WithScheme(flags.Scheme).WithObjects(
+				&netattdefv1.NetworkAttachmentDefinition{
+					ObjectMeta: metav1.ObjectMeta{Name: "test-net", Namespace: "default"},
+					Spec: netattdefv1.NetworkAttachmentDefinitionSpec{
+						Config: `{"cniVersion":"1.0.0","name":"test-net","type":"sriov"}`,
+					},
+				},
+				&corev1.ConfigMap{
+					ObjectMeta: metav1.ObjectMeta{Name: "vf-config", Namespace: "default"},
+					Data: map[string]string{
+						configapi.DefaultConfigMapKey: `{"apiVersion":"sriovnetwork.openshift.io/v1alpha1","kind":"VfConfig","netAttachDefName":"test-net","linkState":"disable"}`,
+						"malformed":                   `{"apiVersion":"sriovnetwork.openshift.io/v1alpha1","kind":"VfConfig",`,
+						"txrate":                      `{"apiVersion":"sriovnetwork.openshift.io/v1alpha1","kind":"VfConfig","netAttachDefName":"test-net","maxTxRate":100}`,
+						"vhost":                       `{"apiVersion":"sriovnetwork.openshift.io/v1alpha1","kind":"VfConfig","netAttachDefName":"test-net","netAttachDefNamespace":"other","addVhostMount":true}`,
+					},
+				},
+			).Build()
+			config.K8sClient = flags.ClientSets{Client: k8sClient}
+			var err error
+			manager, err = devicestate.NewManager(ctx, config, cdiHandler)
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("should apply the config of the referenced ConfigMap", func() {
+			mockHost.EXPECT().SetVFLinkState("0000:01:00.1", configapi.LinkStateDisable).Return(nil)
+
+			preparedDevices, err := manager.PrepareDevicesForClaim(ctx, &ifNameIndex, newClaimWithConfig(configMapRefVfConfig(""), "claim-1", "pod-1", "0000-01-00-1"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(preparedDevices[0].Config.NetAttachDefName).To(Equal("test-net"))
+		})
+
+		It("should merge the vhost mount and the net attach def namespace of the ConfigMap and the inline fields", func() {
+			mockHost.EXPECT().EnsureVhostModulesLoaded().Return(nil)
+			rawConfig := `{"apiVersion":"sriovnetwork.openshift.io/v1alpha1","kind":"VfConfig","configMapRef":{"name":"vf-config","key":"vhost"},"netAttachDefNamespace":"default"}`
+
+			preparedDevices, err := manager.PrepareDevicesForClaim(ctx, &ifNameIndex, newClaimWithConfig(rawConfig, "claim-1", "pod-1", "0000-01-00-1"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(preparedDevices[0].Config.AddVhostMount).To(BeTrue())
+			Expect(preparedDevices[0].Config.NetAttachDefNamespace).To(Equal("default"))
+		})
+
+		It("should let the inline fields override the ConfigMap provided values", func() {
+			mockHost.EXPECT().SetVFLinkState("0000:01:00.1", configapi.LinkStateEnable).Return(nil)
+
+			preparedDevices, err := manager.PrepareDevicesForClaim(ctx, &ifNameIndex, newClaimWithConfig(configMapRefVfConfig(`,"linkState":"enable"`), "claim-1", "pod-1", "0000-01-00-1"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(preparedDevices[0].Config.LinkState).To(Equal(configapi.LinkStateEnable))
+			Expect(preparedDevices[0].Config.NetAttachDefName).To(Equal("test-net"))
+		})
+
+		It("should fail the prepare when the ConfigMap doesn't exist", func() {
+			rawConfig := `{"apiVersion":"sriovnetwork.openshift.io/v1alpha1","kind":"VfConfig","configMapRef":{"name":"missing"}}`
+
+			_, err := manager.PrepareDevicesForClaim(ctx, &ifNameIndex, newClaimWithConfig(rawConfig, "claim-1", "pod-1", "0000-01-00-1"))
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("error getting the VfConfig ConfigMap default/missing"))
+		})
+
+		It("should fail the prepare when the ConfigMap key doesn't parse", func() {
+			rawConfig := `{"apiVersion":"sriovnetwork.openshift.io/v1alpha1","kind":"VfConfig","configMapRef":{"name":"vf-config","key":"malformed"}}`
+
+			_, err := manager.PrepareDevicesForClaim(ctx, &ifNameIndex, newClaimWithConfig(rawConfig, "claim-1", "pod-1", "0000-01-00-1"))
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("error decoding the malformed key of the VfConfig ConfigMap default/vf-config"))
+		})
+
+		It("should fail the prepare when the ConfigMap has no such key", func() {
+			rawConfig := `{"apiVersion":"sriovnetwork.openshift.io/v1alpha1","kind":"VfConfig","configMapRef":{"name":"vf-config","key":"other"}}`
+
+			_, err := manager.PrepareDevicesForClaim(ctx, &ifNameIndex, newClaimWithConfig(rawConfig, "claim-1", "pod-1", "0000-01-00-1"))
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("VfConfig ConfigMap default/vf-config has no other key"))
+		})
+
+		It("should fail the prepare when the ConfigMap is in another namespace", func() {
+			rawConfig := `{"apiVersion":"sriovnetwork.openshift.io/v1alpha1","kind":"VfConfig","configMapRef":{"name":"vf-config","namespace":"other"}}`
+
+			_, err := manager.PrepareDevicesForClaim(ctx, &ifNameIndex, newClaimWithConfig(rawConfig, "claim-1", "pod-1", "0000-01-00-1"))
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("configMapRef namespace other must be the namespace of the claim default"))
+		})
+
+		It("should fail the prepare when the merged config is invalid", func() {
+			rawConfig := `{"apiVersion":"sriovnetwork.openshift.io/v1alpha1","kind":"VfConfig","configMapRef":{"name":"vf-config","key":"txrate"},"minTxRate":200}`
+
+			_, err := manager.PrepareDevicesForClaim(ctx, &ifNameIndex, newClaimWithConfig(rawConfig, "claim-1", "pod-1", "0000-01-00-1"))
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("invalid config merged on top of the VfConfig ConfigMap default/vf-config"))
+		})
+
+		It("should cache the ConfigMap lookups", func() {
+			fakeClock := clocktesting.NewFakeClock(time.Now())
+			manager.SetClock(fakeClock)
+			mockHost.EXPECT().SetVFLinkState(gomock.Any(), configapi.LinkStateDisable).Return(nil).Times(2)
+
+			_, err := manager.PrepareDevicesForClaim(ctx, &ifNameIndex, newClaimWithConfig(configMapRefVfConfig(""), "claim-1", "pod-1", "0000-01-00-1"))
+			Expect(err).NotTo(HaveOccurred())
+
+			// the deleted ConfigMap is still served from the cache
+			Expect(k8sClient.Delete(ctx, &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "vf-config", Namespace: "default"}})).To(Succeed())
+			_, err = manager.PrepareDevicesForClaim(ctx, &ifNameIndex, newClaimWithConfig(configMapRefVfConfig(""), "claim-2", "pod-2", "0000-01-00-2"))
+			Expect(err).NotTo(HaveOccurred())
+
+			// and read again once the cache entry expired
+			fakeClock.Step(2 * time.Minute)
+			_, err = manager.PrepareDevicesForClaim(ctx, &ifNameIndex, newClaimWithConfig(configMapRefVfConfig(""), "claim-3", "pod-3", "0000-01-00-3"))
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("error getting the VfConfig ConfigMap default/vf-config"))
+		})
+	})
+
+	Context("net attach def config", func() {
+		var manager *devicestate.Manager
+
+		BeforeEach(func() {
+			config.K8sClient = flags.ClientSets{
+				Client: fake.NewClientBuilder().WithScheme(flags.Scheme).WithObjects(
+					&netattdefv1.NetworkAttachmentDefinition{
+						ObjectMeta: metav1.ObjectMeta{Name: "empty-net", Namespace: "default"},
+						Spec:       netattdefv1.NetworkAttachmentDefinitionSpec{Config: ""},
+					},
+					&netattdefv1.NetworkAttachmentDefinition{
+						ObjectMeta: metav1.ObjectMeta{Name: "malformed-net", Namespace: "default"},
+						Spec:       netattdefv1.NetworkAttachmentDefinitionSpec{Config: `{"cniVersion":"1.0.0",`},
+					},
+				).Build(),
+			}
+			var err error
+			manager, err = devicestate.NewManager(ctx, config, cdiHandler)
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("should fail the prepare when the net attach def has an empty config", func() {
+			rawConfig := `{"apiVersion":"sriovnetwork.openshift.io/v1alpha1","kind":"VfConfig","netAttachDefName":"empty-net"}`
+
+			_, err := manager.PrepareDevicesForClaim(ctx, &ifNameIndex, newClaimWithConfig(rawConfig, "claim-1", "pod-1", "0000-01-00-1"))
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("invalid config in net attach def default/empty-net: config is empty"))
+		})
+
+		It("should fail the prepare when the net attach def config cannot be parsed", func() {
+			rawConfig := `{"apiVersion":"sriovnetwork.openshift.io/v1alpha1","kind":"VfConfig","netAttachDefName":"malformed-net"}`
+
+			_, err := manager.PrepareDevicesForClaim(ctx, &ifNameIndex, newClaimWithConfig(rawConfig, "claim-1", "pod-1", "0000-01-00-1"))
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("invalid config in net attach def default/malformed-net: failed to parse config"))
+		})
+	})
+
+	Context("config decoding", func() {
+		It("should fail the prepare when the config has a misspelled field", func() {
+			manager, err := devicestate.NewManager(ctx, config, cdiHandler)
+			Expect(err).NotTo(HaveOccurred())
+
+			_, err = manager.PrepareDevicesForClaim(ctx, &ifNameIndex, newClaimWithConfig(
+				`{"apiVersion":"sriovnetwork.openshift.io/v1alpha1","kind":"VfConfig","netAttachDefName":"test-net","macAddres":"02:00:00:00:00:01"}`,
+				"claim-1", "pod-1", "0000-01-00-1"))
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("error decoding config parameters of the FromClaim config 0"))
+			Expect(err.Error()).To(ContainSubstring(`unknown field "macAddres"`))
+		})
+
+		It("should prepare the claim with a v1alpha2 config", func() {
+			manager, err := devicestate.NewManager(ctx, config, cdiHandler)
+			Expect(err).NotTo(HaveOccurred())
+
+			preparedDevices, err := manager.PrepareDevicesForClaim(ctx, &ifNameIndex, newClaimWithConfig(
+				`{"apiVersion":"sriovnetwork.openshift.io/v1alpha2","kind":"VfConfig","netAttachDefName":"test-net","link":{"vlan":20}}`,
+				"claim-1", "pod-1", "0000-01-00-1"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(preparedDevices[0].Config.Vlan).To(Equal(20))
+		})
+	})
+
+	Context("prepared devices order", func() {
+		It("should order the prepared devices and their interface names by request then device name", func() {
+			manager, err := devicestate.NewManager(ctx, config, cdiHandler)
+			Expect(err).NotTo(HaveOccurred())
+
+			allocations := [][]resourceapi.DeviceRequestAllocationResult{
+				{{Request: "vf-b", Device: "0000-01-00-1"}, {Request: "vf-a", Device: "0000-01-00-3"}, {Request: "vf-a", Device: "0000-01-00-2"}},
+				{{Request: "vf-a", Device: "0000-01-00-2"}, {Request: "vf-b", Device: "0000-01-00-1"}, {Request: "vf-a", Device: "0000-01-00-3"}},
+				{{Request: "vf-a", Device: "0000-01-00-3"}, {Request: "vf-a", Device: "0000-01-00-2"}, {Request: "vf-b", Device: "0000-01-00-1"}},
+			}
+			for i, results := range allocations {
+				claim := newClaim(fmt.Sprintf("claim-%d", i), "pod-1")
+				for _, result := range results {
+					result.Driver = consts.DriverName
+					result.Pool = "test-node"
+					claim.Status.Allocation.Devices.Results = append(claim.Status.Allocation.Devices.Results, result)
+				}
+				claim.Status.Allocation.Devices.Config[0].Requests = []string{"vf-a", "vf-b"}
+
+				ifNameIndex = 0
+				preparedDevices, err := manager.PrepareDevicesForClaim(ctx, &ifNameIndex, claim)
+				Expect(err).NotTo(HaveOccurred())
+
+				prepared := []string{}
+				for _, preparedDevice := range preparedDevices {
+					prepared = append(prepared, preparedDevice.Device.RequestNames[0]+"/"+preparedDevice.Device.DeviceName+"/"+preparedDevice.IfName)
+				}
+				Expect(prepared).To(Equal([]string{"vf-a/0000-01-00-2/net0", "vf-a/0000-01-00-3/net1", "vf-b/0000-01-00-1/net2"}), "allocation %d", i)
+			}
+		})
+	})
+
+	Context("config selection logging", func() {
+		It("should log which config was selected for the device and why at V(4)", func() {
+			manager, err := devicestate.NewManager(ctx, config, cdiHandler)
+			Expect(err).NotTo(HaveOccurred())
+
+			logger := ktesting.NewLogger(ktesting.NopTL{}, ktesting.NewConfig(ktesting.Verbosity(4), ktesting.BufferLogs(true)))
+			claim := newClaimWithConfig(`{"apiVersion":"sriovnetwork.openshift.io/v1alpha1","kind":"VfConfig","netAttachDefName":"test-net","vlan":20}`,
+				"claim-1", "pod-1", "0000-01-00-1")
+			// the class config comes first in the claim but has a lower precedence than the claim config
+			classConfig := *claim.Status.Allocation.Devices.Config[0].DeepCopy()
+			classConfig.Source = resourceapi.AllocationConfigSourceClass
+			classConfig.Opaque.Parameters.Raw = []byte(`{"apiVersion":"sriovnetwork.openshift.io/v1alpha1","kind":"VfConfig","netAttachDefName":"test-net","vlan":10}`)
+			claim.Status.Allocation.Devices.Config = append([]resourceapi.DeviceAllocationConfiguration{classConfig}, claim.Status.Allocation.Devices.Config...)
+
+			preparedDevices, err := manager.PrepareDevicesForClaim(klog.NewContext(ctx, logger), &ifNameIndex, claim)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(preparedDevices[0].Config.Vlan).To(Equal(20))
+
+			logs := logger.GetSink().(ktesting.Underlier).GetBuffer().String()
+			Expect(logs).To(MatchRegexp(`Applying config on top of the default config.*request="vf".*configIndex=0.*source="FromClass"`))
+			Expect(logs).To(MatchRegexp(`Overriding config with a higher precedence config.*request="vf".*configIndex=1.*source="FromClaim"`))
+			Expect(logs).To(MatchRegexp(`Selected config for device.*device="0000-01-00-1".*request="vf"`))
+		})
+
+		It("should not log the config selection below V(4)", func() {
+			manager, err := devicestate.NewManager(ctx, config, cdiHandler)
+			Expect(err).NotTo(HaveOccurred())
+
+			logger := ktesting.NewLogger(ktesting.NopTL{}, ktesting.NewConfig(ktesting.Verbosity(3), ktesting.BufferLogs(true)))
+			_, err = manager.PrepareDevicesForClaim(klog.NewContext(ctx, logger), &ifNameIndex, newClaim("claim-1", "pod-1", "0000-01-00-1"))
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(logger.GetSink().(ktesting.Underlier).GetBuffer().String()).NotTo(ContainSubstring("Selected config for device"))
+		})
+	})
+
+	Context("node default config", func() {
+		writeDefaultVfConfig := func(rawConfig string) {
+			path := filepath.Join(tempDir, "default-vf-config.json")
+			Expect(os.WriteFile(path, []byte(rawConfig), 0600)).To(Succeed())
+			config.Flags.DefaultVfConfigFile = path
+		}
+
+		It("should apply the default config when the claim has no config", func() {
+			writeDefaultVfConfig(`{"apiVersion":"sriovnetwork.openshift.io/v1alpha1","kind":"VfConfig","netAttachDefName":"test-net","vlan":100}`)
+			manager, err := devicestate.NewManager(ctx, config, cdiHandler)
+			Expect(err).NotTo(HaveOccurred())
+			claim := newClaim("claim-1", "pod-1", "0000-01-00-1")
+			claim.Status.Allocation.Devices.Config = nil
+
+			preparedDevices, err := manager.PrepareDevicesForClaim(ctx, &ifNameIndex, claim)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(preparedDevices).To(HaveLen(1))
+			Expect(preparedDevices[0].Config.NetAttachDefName).To(Equal("test-net"))
+			Expect(preparedDevices[0].Config.Vlan).To(Equal(100))
+		})
+
+		It("should apply the claim config on top of the default config", func() {
+			writeDefaultVfConfig(`{"apiVersion":"sriovnetwork.openshift.io/v1alpha1","kind":"VfConfig","vlan":100,"requireNumaAlignment":true}`)
+			manager, err := devicestate.NewManager(ctx, config, cdiHandler)
+			Expect(err).NotTo(HaveOccurred())
+
+			preparedDevices, err := manager.PrepareDevicesForClaim(ctx, &ifNameIndex, newClaimWithConfig(
+				`{"apiVersion":"sriovnetwork.openshift.io/v1alpha1","kind":"VfConfig","netAttachDefName":"test-net","vlan":20}`,
+				"claim-1", "pod-1", "0000-01-00-1"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(preparedDevices[0].Config.Vlan).To(Equal(20))
+			Expect(preparedDevices[0].Config.RequireNumaAlignment).To(BeTrue())
+		})
+
+		It("should fail to start with an invalid default config", func() {
+			writeDefaultVfConfig(`{"apiVersion":"sriovnetwork.openshift.io/v1alpha1","kind":"VfConfig","vlan":5000}`)
+
+			_, err := devicestate.NewManager(ctx, config, cdiHandler)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("invalid default VF config file"))
+		})
+
+		It("should fail to start with a default config that can't be decoded", func() {
+			writeDefaultVfConfig(`{"apiVersion":"sriovnetwork.openshift.io/v1alpha1","kind":"VfConfig","spoofCheck":true}`)
+
+			_, err := devicestate.NewManager(ctx, config, cdiHandler)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("error decoding default VF config file"))
+		})
+
+		It("should reject a MAC address in the default config", func() {
+			writeDefaultVfConfig(`{"apiVersion":"sriovnetwork.openshift.io/v1alpha1","kind":"VfConfig","macAddress":"02:00:00:00:00:01"}`)
+
+			_, err := devicestate.NewManager(ctx, config, cdiHandler)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("mac address can not be set in the default config"))
+		})
+	})
+
+	Context("claim config validation", func() {
+		It("should fail the prepare of a claim config with an invalid vlan", func() {
+			manager, err := devicestate.NewManager(ctx, config, cdiHandler)
+			Expect(err).NotTo(HaveOccurred())
+
+			_, err = manager.PrepareDevicesForClaim(ctx, &ifNameIndex, newClaimWithConfig(
+				`{"apiVersion":"sriovnetwork.openshift.io/v1alpha1","kind":"VfConfig","netAttachDefName":"test-net","vlan":5000}`,
+				"claim-1", "pod-1", "0000-01-00-1"))
+			Expect(err).To(MatchError(ContainSubstring("invalid FromClaim config 0: invalid vlan 5000: must be between 0 and 4094")))
+		})
+	})
+
+	Context("CDI common spec", func() {
+		var commonSpecPath string
+
+		BeforeEach(func() {
+			commonSpecPath = filepath.Join(tempDir, cdi.DefaultVendor+"-vf_dra-driver-sriov.yaml")
+		})
+
+		It("should create the common spec and reference its device from the prepared devices", func() {
+			config.Flags.SkipCDICommonSpec = false
+			manager, err := devicestate.NewManager(ctx, config, cdiHandler)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(commonSpecPath).To(BeAnExistingFile())
+
+			preparedDevices, err := manager.PrepareDevicesForClaim(ctx, &ifNameIndex, newClaim("claim-1", "pod-1", "0000-01-00-1"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(preparedDevices[0].Device.CDIDeviceIDs).To(ContainElement(cdi.DefaultVendor + "/vf=dra-driver-sriov"))
+		})
+
+		It("should skip the common spec and still prepare the claim by default", func() {
+			manager, err := devicestate.NewManager(ctx, config, cdiHandler)
+			Expect(err).NotTo(HaveOccurred())
+
+			preparedDevices, err := manager.PrepareDevicesForClaim(ctx, &ifNameIndex, newClaim("claim-1", "pod-1", "0000-01-00-1"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(preparedDevices).To(HaveLen(1))
+			Expect(preparedDevices[0].Device.CDIDeviceIDs).To(HaveLen(2))
+			Expect(commonSpecPath).NotTo(BeAnExistingFile())
+		})
+	})
+
+	Context("rediscovery", func() {
+		var (
+			manager     *devicestate.Manager
+			republished int
+		)
+
+		BeforeEach(func() {
+			var err error
+			manager, err = devicestate.NewManager(ctx, config, cdiHandler)
+			Expect(err).NotTo(HaveOccurred())
+			republished = 0
+			manager.SetRepublishCallback(func(context.Context) error {
+				republished++
+				return nil
+			})
+		})
+
+		It("should add the new VFs, remove the gone ones and republish", func() {
+			Expect(manager.UpdateDeviceResourceNames(ctx, map[string]string{"0000-01-00-2": "vendor.com/net"})).To(Succeed())
+			republished = 0
+			vfList = []host.VFInfo{
+				{PciAddress: "0000:01:00.2", VFID: 1, DeviceID: "154c"},
+				{PciAddress: "0000:01:00.3", VFID: 2, DeviceID: "154c"},
+				{PciAddress: "0000:01:00.4", VFID: 3, DeviceID: "154c"},
+			}
+
+			Expect(manager.Rediscover(ctx)).To(Succeed())
+			Expect(republished).To(Equal(1))
+			devices := manager.GetAllocatableDevices()
+			Expect(devices).To(HaveLen(3))
+			Expect(devices).NotTo(HaveKey("0000-01-00-1"))
+			Expect(devices).To(HaveKey("0000-01-00-4"))
+			// the devices still present keep the attributes set since the discovery
+			Expect(devices["0000-01-00-2"].Attributes[consts.AttributeResourceName].StringValue).To(Equal(ptr.To("vendor.com/net")))
+		})
+
+		It("should not republish when the devices did not change", func() {
+			Expect(manager.Rediscover(ctx)).To(Succeed())
+			Expect(republished).To(BeZero())
+		})
+	})
+
+	Context("link state", func() {
+		var manager *devicestate.Manager
+
+		BeforeEach(func() {
+			var err error
+			manager, err = devicestate.NewManager(ctx, config, cdiHandler)
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		deviceLinkUp := func() *bool {
+			device, found := manager.GetAllocatedDeviceByDeviceName("0000-01-00-1")
+			Expect(found).To(BeTrue())
+			return device.Attributes[consts.AttributeLinkUp].BoolValue
+		}
+
+		It("should flip the link up attribute with the PF link state and republish", func() {
+			republished := 0
+			manager.SetRepublishCallback(func(context.Context) error {
+				republished++
+				return nil
+			})
+			Expect(deviceLinkUp()).To(Equal(ptr.To(true)))
+
+			linkUp = false
+			Expect(manager.RefreshLinkState(ctx)).To(Succeed())
+			Expect(deviceLinkUp()).To(Equal(ptr.To(false)))
+			Expect(republished).To(Equal(1))
+
+			linkUp = true
+			Expect(manager.RefreshLinkState(ctx)).To(Succeed())
+			Expect(deviceLinkUp()).To(Equal(ptr.To(true)))
+			Expect(republished).To(Equal(2))
+		})
+
+		publishedTaints := func() []resourceapi.DeviceTaint {
+			device, found := manager.GetPublishableDevices()["0000-01-00-1"]
+			Expect(found).To(BeTrue())
+			return device.Taints
+		}
+
+		It("should not change the devices handed out before the link state changed", func() {
+			published := manager.GetPublishableDevices()
+			allocatable := manager.GetAllocatableDevices()
+
+			linkUp = false
+			Expect(manager.RefreshLinkState(ctx)).To(Succeed())
+			Expect(deviceLinkUp()).To(Equal(ptr.To(false)))
+			Expect(published["0000-01-00-1"].Attributes[consts.AttributeLinkUp].BoolValue).To(Equal(ptr.To(true)))
+			Expect(published["0000-01-00-1"].Taints).To(BeEmpty())
+			Expect(allocatable["0000-01-00-1"].Attributes[consts.AttributeLinkUp].BoolValue).To(Equal(ptr.To(true)))
+		})
+
+		It("should not let the devices handed out change the allocatable devices", func() {
+			device, found := manager.GetAllocatedDeviceByDeviceName("0000-01-00-1")
+			Expect(found).To(BeTrue())
+			device.Attributes[consts.AttributeLinkUp] = resourceapi.DeviceAttribute{BoolValue: ptr.To(false)}
+			delete(manager.GetAllocatableDevices()["0000-01-00-1"].Attributes, consts.AttributeLinkUp)
+
+			Expect(deviceLinkUp()).To(Equal(ptr.To(true)))
+		})
+
+		It("should taint the devices while the PF link is down", func() {
+			Expect(publishedTaints()).To(BeEmpty())
+
+			linkUp = false
+			Expect(manager.RefreshLinkState(ctx)).To(Succeed())
+			Expect(publishedTaints()).To(Equal([]resourceapi.DeviceTaint{{
+				Key:    consts.TaintKeyLinkDown,
+				Effect: resourceapi.DeviceTaintEffectNoSchedule,
+			}}))
+
+			linkUp = true
+			Expect(manager.RefreshLinkState(ctx)).To(Succeed())
+			Expect(publishedTaints()).To(BeEmpty())
+		})
+
+		It("should publish the devices of a PF discovered with its link down tainted", func() {
+			linkUp = false
+			var err error
+			manager, err = devicestate.NewManager(ctx, config, cdiHandler)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(deviceLinkUp()).To(Equal(ptr.To(false)))
+			Expect(publishedTaints()).To(Equal([]resourceapi.DeviceTaint{{
+				Key:    consts.TaintKeyLinkDown,
+				Effect: resourceapi.DeviceTaintEffectNoSchedule,
+			}}))
+		})
+
+		It("should not republish when the link state did not change", func() {
+			manager.SetRepublishCallback(func(context.Context) error {
+				Fail("unexpected republish")
+				return nil
+			})
+
+			Expect(manager.RefreshLinkState(ctx)).To(Succeed())
+			Expect(deviceLinkUp()).To(Equal(ptr.To(true)))
+		})
+	})
+
+	Context("drained PFs", func() {
+		var (
+			manager     *devicestate.Manager
+			republished int
+		)
+
+		BeforeEach(func() {
+			var err error
+			manager, err = devicestate.NewManager(ctx, config, cdiHandler)
+			Expect(err).NotTo(HaveOccurred())
+			republished = 0
+			manager.SetRepublishCallback(func(context.Context) error {
+				republished++
+				return nil
+			})
+		})
+
+		It("should withdraw the VFs of a drained PF and keep the prepared devices", func() {
+			preparedDevices, err := manager.PrepareDevicesForClaim(ctx, &ifNameIndex, newClaim("claim-1", "pod-1", "0000-01-00-1"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(manager.GetPublishableDevices()).To(HaveLen(3))
+
+			Expect(manager.SetDrainedPFs(ctx, []string{"eth0"})).To(Succeed())
+			Expect(republished).To(Equal(1))
+			Expect(manager.GetPublishableDevices()).To(BeEmpty())
+			Expect(manager.GetAllocatableDevices()).To(HaveLen(3))
+			_, found := manager.GetAllocatedDeviceByDeviceName(preparedDevices[0].Device.DeviceName)
+			Expect(found).To(BeTrue())
+
+			mockHost.EXPECT().ResetVF("0000:01:00.1").Return(nil)
+			Expect(manager.Unprepare("claim-1", preparedDevices)).To(Succeed())
+
+			Expect(manager.SetDrainedPFs(ctx, nil)).To(Succeed())
+			Expect(republished).To(Equal(2))
+			Expect(manager.GetPublishableDevices()).To(HaveLen(3))
+		})
+
+		It("should not republish when the drained PFs did not change", func() {
+			Expect(manager.SetDrainedPFs(ctx, []string{"eth1"})).To(Succeed())
+			Expect(manager.SetDrainedPFs(ctx, []string{"eth1"})).To(Succeed())
+			Expect(republished).To(Equal(1))
+			Expect(manager.GetPublishableDevices()).To(HaveLen(3))
+		})
+
+		It("should read the drained PFs from the file", func() {
+			drainedPFsFile := filepath.Join(tempDir, "drained-pfs")
+			Expect(os.WriteFile(drainedPFsFile, []byte("# maintenance\n\neth0\n"), 0600)).To(Succeed())
+
+			Expect(manager.RefreshDrainedPFs(ctx, drainedPFsFile)).To(Succeed())
+			Expect(manager.GetPublishableDevices()).To(BeEmpty())
+
+			Expect(os.Remove(drainedPFsFile)).To(Succeed())
+			Expect(manager.RefreshDrainedPFs(ctx, drainedPFsFile)).To(Succeed())
+			Expect(manager.GetPublishableDevices()).To(HaveLen(3))
+			Expect(republished).To(Equal(2))
+		})
+	})
+
+	Context("extra device attributes", func() {
+		rackAttribute := resourceapi.QualifiedName(consts.ExtraAttributeDomain + "/rack")
+		zoneAttribute := resourceapi.QualifiedName(consts.ExtraAttributeDomain + "/zone")
+
+		It("should add the extra attributes to every discovered device", func() {
+			config.Flags.ExtraDeviceAttributes = "rack=r1,zone=z1"
+			manager, err := devicestate.NewManager(ctx, config, cdiHandler)
+			Expect(err).NotTo(HaveOccurred())
+
+			devices := manager.GetAllocatableDevices()
+			Expect(devices).To(HaveLen(3))
+			for deviceName, device := range devices {
+				Expect(device.Attributes[rackAttribute].StringValue).To(Equal(ptr.To("r1")), deviceName)
+				Expect(device.Attributes[zoneAttribute].StringValue).To(Equal(ptr.To("z1")), deviceName)
+				Expect(device.Attributes[consts.AttributePFName].StringValue).To(Equal(ptr.To("eth0")), deviceName)
+			}
+		})
+
+		It("should add the extra attributes to the rediscovered devices", func() {
+			config.Flags.ExtraDeviceAttributes = "rack=r1"
+			manager, err := devicestate.NewManager(ctx, config, cdiHandler)
+			Expect(err).NotTo(HaveOccurred())
+			vfList = append(vfList, host.VFInfo{PciAddress: "0000:01:00.4", VFID: 3, DeviceID: "154c"})
+
+			Expect(manager.Rediscover(ctx)).To(Succeed())
+			Expect(manager.GetAllocatableDevices()["0000-01-00-4"].Attributes[rackAttribute].StringValue).To(Equal(ptr.To("r1")))
+		})
+
+		It("should fail to start with an invalid extra attribute", func() {
+			config.Flags.ExtraDeviceAttributes = "my-rack=r1"
+			_, err := devicestate.NewManager(ctx, config, cdiHandler)
+			Expect(err).To(MatchError(ContainSubstring(`invalid extra device attribute name "my-rack"`)))
+		})
+	})
+
+	Context("interface name template", func() {
+		BeforeEach(func() {
+			mockHost.EXPECT().ResetVF(gomock.Any()).Return(nil).AnyTimes()
+		})
+
+		It("should name the interfaces without an ifName with the template", func() {
+			config.Flags.IfNameTemplate = "sriov{{.Index}}"
+			manager, err := devicestate.NewManager(ctx, config, cdiHandler)
+			Expect(err).NotTo(HaveOccurred())
+
+			preparedDevices, err := manager.PrepareDevicesForClaim(ctx, &ifNameIndex, newClaim("claim-1", "pod-1", "0000-01-00-1", "0000-01-00-2"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(preparedDevices).To(HaveLen(2))
+			Expect(preparedDevices[0].IfName).To(Equal("sriov0"))
+			Expect(preparedDevices[1].IfName).To(Equal("sriov1"))
+		})
+
+		It("should fail the prepare when the template makes a name too long for the index", func() {
+			config.Flags.IfNameTemplate = "sriov-network-{{.Index}}"
+			manager, err := devicestate.NewManager(ctx, config, cdiHandler)
+			Expect(err).NotTo(HaveOccurred())
+
+			ifNameIndex = 10
+			_, err = manager.PrepareDevicesForClaim(ctx, &ifNameIndex, newClaim("claim-1", "pod-1", "0000-01-00-1"))
+			Expect(err).To(MatchError(ContainSubstring(`invalid interface name "sriov-network-10": must be no more than 15 characters`)))
+		})
+
+		It("should reject a template making invalid names", func() {
+			config.Flags.IfNameTemplate = "sriov/{{.Index}}"
+			_, err := devicestate.NewManager(ctx, config, cdiHandler)
+			Expect(err).To(MatchError(ContainSubstring(`invalid interface name template "sriov/{{.Index}}"`)))
+		})
+	})
+
+	Context("VF groups", func() {
+		var manager *devicestate.Manager
+
+		BeforeEach(func() {
+			var err error
+			config.Flags.AdvertiseVFGroups = true
+			manager, err = devicestate.NewManager(ctx, config, cdiHandler)
+			Expect(err).NotTo(HaveOccurred())
+			mockHost.EXPECT().ResetVF(gomock.Any()).Return(nil).AnyTimes()
+		})
+
+		It("should advertise a VF group device with the PF attributes", func() {
+			devices := manager.GetAllocatableDevices()
+			Expect(devices).To(HaveLen(4))
+			group, found := devices["eth0-all-vfs"]
+			Expect(found).To(BeTrue())
+			Expect(group.Attributes[consts.AttributeVFGroup].BoolValue).To(Equal(ptr.To(true)))
+			Expect(group.Attributes[consts.AttributeVFGroupSize].IntValue).To(Equal(ptr.To(int64(3))))
+			Expect(group.Attributes[consts.AttributePFName].StringValue).To(Equal(ptr.To("eth0")))
+			Expect(group.Attributes).NotTo(HaveKey(resourceapi.QualifiedName(consts.AttributePciAddress)))
+			Expect(group.Attributes).NotTo(HaveKey(resourceapi.QualifiedName(consts.AttributeVFID)))
+		})
+
+		It("should make the VF group and its VFs consume the counters of the PF", func() {
+			devices := manager.GetAllocatableDevices()
+			for _, deviceName := range []string{"0000-01-00-1", "0000-01-00-2", "0000-01-00-3"} {
+				Expect(devices[deviceName].ConsumesCounters).To(HaveLen(1))
+				Expect(devices[deviceName].ConsumesCounters[0].CounterSet).To(Equal("eth0-vfs"))
+				Expect(devices[deviceName].ConsumesCounters[0].Counters["vfs"].Value).To(BeComparableTo(resource.MustParse("1")))
+			}
+			group := devices["eth0-all-vfs"]
+			Expect(group.ConsumesCounters).To(HaveLen(1))
+			Expect(group.ConsumesCounters[0].CounterSet).To(Equal("eth0-vfs"))
+			Expect(group.ConsumesCounters[0].Counters["vfs"].Value).To(BeComparableTo(resource.MustParse("3")))
+
+			counterSets := devicestate.SharedCounters(slices.Collect(maps.Values(devices)))
+			Expect(counterSets).To(HaveLen(1))
+			Expect(counterSets[0].Name).To(Equal("eth0-vfs"))
+			Expect(counterSets[0].Counters["vfs"].Value).To(BeComparableTo(resource.MustParse("3")))
+		})
+
+		It("should resize the VF group and its counters on rediscovery", func() {
+			vfList = append(vfList, host.VFInfo{PciAddress: "0000:01:00.4", VFID: 3, DeviceID: "154c"})
+
+			Expect(manager.Rediscover(ctx)).To(Succeed())
+			devices := manager.GetAllocatableDevices()
+			Expect(devices).To(HaveLen(5))
+			group := devices["eth0-all-vfs"]
+			Expect(group.Attributes[consts.AttributeVFGroupSize].IntValue).To(Equal(ptr.To(int64(4))))
+			Expect(group.ConsumesCounters[0].Counters["vfs"].Value).To(BeComparableTo(resource.MustParse("4")))
+			Expect(devices["0000-01-00-4"].ConsumesCounters[0].CounterSet).To(Equal("eth0-vfs"))
+
+			counterSets := devicestate.SharedCounters(slices.Collect(maps.Values(devices)))
+			Expect(counterSets).To(HaveLen(1))
+			Expect(counterSets[0].Counters["vfs"].Value).To(BeComparableTo(resource.MustParse("4")))
+		})
+
+		It("should prepare all the VFs of the PF with their own container edits", func() {
+			claim := newClaim("claim-1", "pod-1", "eth0-all-vfs")
+			preparedDevices, err := manager.PrepareDevicesForClaim(ctx, &ifNameIndex, claim)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(preparedDevices).To(HaveLen(3))
+
+			for i, preparedDevice := range preparedDevices {
+				pciAddress := fmt.Sprintf("0000:01:00.%d", i+1)
+				deviceName := fmt.Sprintf("0000-01-00-%d", i+1)
+				Expect(preparedDevice.Device.DeviceName).To(Equal(deviceName))
+				Expect(preparedDevice.Device.RequestNames).To(Equal([]string{"vf"}))
+				Expect(preparedDevice.GroupDeviceName).To(Equal("eth0-all-vfs"))
+				Expect(preparedDevice.PciAddress).To(Equal(pciAddress))
+				Expect(preparedDevice.IfName).To(Equal(fmt.Sprintf("net%d", i)))
+				Expect(preparedDevice.ContainerEdits.ContainerEdits.Env).To(ContainElement(
+					fmt.Sprintf("SRIOVNETWORK_VF_DEVICE_0000_01_00_%d=%s", i+1, pciAddress)))
+				Expect(preparedDevice.Device.CDIDeviceIDs).To(ContainElement(cdiHandler.GetClaimDevices("claim-1", deviceName)))
+			}
+			// the claim status only holds the allocated group device
+			Expect(claim.Status.Devices).To(HaveLen(1))
+			Expect(claim.Status.Devices[0].Device).To(Equal("eth0-all-vfs"))
+		})
+
+		It("should not prepare the VF group while a VF of the PF is prepared", func() {
+			_, err := manager.PrepareDevicesForClaim(ctx, &ifNameIndex, newClaim("claim-1", "pod-1", "0000-01-00-1"))
+			Expect(err).NotTo(HaveOccurred())
+
+			_, err = manager.PrepareDevicesForClaim(ctx, &ifNameIndex, newClaim("claim-2", "pod-2", "eth0-all-vfs"))
+			Expect(err).To(MatchError(ContainSubstring("the VF group of PF eth0 needs all its VFs, 1 already prepared")))
+		})
+
+		It("should not prepare a VF of the PF until the VF group is unprepared", func() {
+			preparedDevices, err := manager.PrepareDevicesForClaim(ctx, &ifNameIndex, newClaim("claim-1", "pod-1", "eth0-all-vfs"))
+			Expect(err).NotTo(HaveOccurred())
+
+			_, err = manager.PrepareDevicesForClaim(ctx, &ifNameIndex, newClaim("claim-2", "pod-2", "0000-01-00-1"))
+			Expect(err).To(MatchError(ContainSubstring("the VFs of PF eth0 are prepared for its VF group")))
+
+			Expect(manager.Unprepare("claim-1", preparedDevices)).To(Succeed())
+			_, err = manager.PrepareDevicesForClaim(ctx, &ifNameIndex, newClaim("claim-2", "pod-2", "0000-01-00-1"))
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("should keep the VF group of the devices restored from the checkpoint", func() {
+			manager.RecordPreparedDevices(draTypes.PreparedDevices{
+				{Device: drapbv1.Device{DeviceName: "0000-01-00-1"}, GroupDeviceName: "eth0-all-vfs"},
+			})
+
+			_, err := manager.PrepareDevicesForClaim(ctx, &ifNameIndex, newClaim("claim-1", "pod-1", "0000-01-00-2"))
+			Expect(err).To(MatchError(ContainSubstring("the VFs of PF eth0 are prepared for its VF group")))
+		})
+
+		It("should replace the counters of the devices reloaded from the checkpoint", func() {
+			manager.RecordPreparedDevices(draTypes.PreparedDevices{
+				{Device: drapbv1.Device{DeviceName: "0000-01-00-1"}, GroupDeviceName: "eth0-all-vfs"},
+			})
+
+			// the VF group was unprepared by the other driver instance
+			manager.ResetPreparedDevices(nil)
+			_, err := manager.PrepareDevicesForClaim(ctx, &ifNameIndex, newClaim("claim-1", "pod-1", "0000-01-00-2"))
+			Expect(err).NotTo(HaveOccurred())
+		})
+	})
+
+	Context("reserved VFs", func() {
+		It("should not advertise the reserved VFs", func() {
+			config.Flags.ReservedVFs = "0000:01:00.1, 0000:01:00.3"
+			manager, err := devicestate.NewManager(ctx, config, cdiHandler)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(manager.GetAllocatableDevices()).To(HaveLen(1))
+			Expect(manager.GetAllocatableDevices()).To(HaveKey("0000-01-00-2"))
+		})
+
+		It("should reject a reserved VF that is not a PCI address", func() {
+			config.Flags.ReservedVFs = "0000:01:00.1,eth0"
+			_, err := devicestate.NewManager(ctx, config, cdiHandler)
+			Expect(err).To(MatchError(ContainSubstring(`invalid reserved VFs: "eth0" is not a PCI address`)))
+		})
+	})
+
+	Context("prepare webhook", func() {
+		var allowed bool
+
+		BeforeEach(func() {
+			server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				_ = json.NewEncoder(w).Encode(preparewebhook.Response{Allowed: allowed, Reason: "not allowed in namespace default"})
+			}))
+			DeferCleanup(server.Close)
+			caFile := filepath.Join(tempDir, "webhook-ca.pem")
+			Expect(os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}), 0600)).To(Succeed())
+			config.Flags.PrepareWebhookURL = server.URL
+			config.Flags.PrepareWebhookCAFile = caFile
+		})
+
+		It("should prepare the claim allowed by the webhook", func() {
+			allowed = true
+			manager, err := devicestate.NewManager(ctx, config, cdiHandler)
+			Expect(err).NotTo(HaveOccurred())
+
+			preparedDevices, err := manager.PrepareDevicesForClaim(ctx, &ifNameIndex, newClaim("claim-1", "pod-1", "0000-01-00-1"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(preparedDevices).To(HaveLen(1))
+		})
+
+		It("should not prepare the claim denied by the webhook", func() {
+			allowed = false
+			config.Flags.MaxAllocationsPerPF = 1
+			manager, err := devicestate.NewManager(ctx, config, cdiHandler)
+			Expect(err).NotTo(HaveOccurred())
+
+			claim := newClaim("claim-1", "pod-1", "0000-01-00-1")
+			_, err = manager.PrepareDevicesForClaim(ctx, &ifNameIndex, claim)
+			Expect(err).To(MatchError(ContainSubstring("prepare denied by webhook: not allowed in namespace default")))
+			Expect(claim.Status.Devices).To(BeEmpty())
+
+			// the PF allocation reserved for the denied claim is released
+			allowed = true
+			_, err = manager.PrepareDevicesForClaim(ctx, &ifNameIndex, newClaim("claim-2", "pod-2", "0000-01-00-2"))
+			Expect(err).NotTo(HaveOccurred())
+		})
+	})
+
+	Context("prepare failure", func() {
+		It("should roll back the devices and the claim CDI spec when the spec verification fails", func() {
+			config.Flags.MaxAllocationsPerPF = 1
+			manager, err := devicestate.NewManager(ctx, config, cdiHandler)
+			Expect(err).NotTo(HaveOccurred())
+			manager.SetVerifyClaimSpecFile(func(draTypes.PreparedDevices) error {
+				return fmt.Errorf("spec file truncated")
+			})
+
+			mockHost.EXPECT().ResetVF("0000:01:00.1").Return(nil)
+			_, err = manager.PrepareDevicesForClaim(ctx, &ifNameIndex, newClaim("claim-1", "pod-1", "0000-01-00-1"))
+			Expect(err).To(MatchError(ContainSubstring("spec file truncated")))
+
+			specFiles, err := filepath.Glob(filepath.Join(tempDir, "*claim-1*"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(specFiles).To(BeEmpty())
+
+			// the PF allocation reserved for the failed claim is released
+			manager.SetVerifyClaimSpecFile(func(draTypes.PreparedDevices) error {
+				return nil
+			})
+			_, err = manager.PrepareDevicesForClaim(ctx, &ifNameIndex, newClaim("claim-2", "pod-2", "0000-01-00-2"))
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("should revert the devices configured before the device failing to be configured", func() {
+			config.Flags.AllowedHostPaths = "/dev/vfio"
+			config.Flags.MaxAllocationsPerPF = 2
+			manager, err := devicestate.NewManager(ctx, config, cdiHandler)
+			Expect(err).NotTo(HaveOccurred())
+
+			rawConfig := `{"apiVersion":"sriovnetwork.openshift.io/v1alpha1","kind":"VfConfig","netAttachDefName":"test-net","deviceNodes":["/dev/vfio/extra"]}`
+			gomock.InOrder(
+				mockHost.EXPECT().PathExists("/dev/vfio/extra").Return(true),
+				mockHost.EXPECT().PathExists("/dev/vfio/extra").Return(false),
+			)
+			// only the first device was configured and is reset
+			mockHost.EXPECT().ResetVF("0000:01:00.1").Return(nil)
+			_, err = manager.PrepareDevicesForClaim(ctx, &ifNameIndex, newClaimWithConfig(rawConfig, "claim-1", "pod-1", "0000-01-00-1", "0000-01-00-2"))
+			Expect(err).To(MatchError(ContainSubstring("requested device node /dev/vfio/extra does not exist on the host")))
+
+			// the PF allocations reserved for the failed claim are released
+			mockHost.EXPECT().ResetVF(gomock.Any()).Return(nil).AnyTimes()
+			_, err = manager.PrepareDevicesForClaim(ctx, &ifNameIndex, newClaim("claim-2", "pod-2", "0000-01-00-1", "0000-01-00-2"))
+			Expect(err).NotTo(HaveOccurred())
+		})
+	})
+
+	Context("max allocations per PF", func() {
+		var manager *devicestate.Manager
+
+		BeforeEach(func() {
+			var err error
+			config.Flags.MaxAllocationsPerPF = 2
+			manager, err = devicestate.NewManager(ctx, config, cdiHandler)
+			Expect(err).NotTo(HaveOccurred())
+			mockHost.EXPECT().ResetVF(gomock.Any()).Return(nil).AnyTimes()
+		})
+
+		It("should reject the allocation exceeding the cap on a PF", func() {
+			_, err := manager.PrepareDevicesForClaim(ctx, &ifNameIndex, newClaim("claim-1", "pod-1", "0000-01-00-1"))
+			Expect(err).NotTo(HaveOccurred())
+			_, err = manager.PrepareDevicesForClaim(ctx, &ifNameIndex, newClaim("claim-2", "pod-2", "0000-01-00-2"))
+			Expect(err).NotTo(HaveOccurred())
+
+			_, err = manager.PrepareDevicesForClaim(ctx, &ifNameIndex, newClaim("claim-3", "pod-3", "0000-01-00-3"))
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("exceed the maximum of 2 prepared VFs on PF eth0"))
+		})
+
+		It("should reject a single claim requesting more VFs than the cap", func() {
+			_, err := manager.PrepareDevicesForClaim(ctx, &ifNameIndex, newClaim("claim-1", "pod-1", "0000-01-00-1", "0000-01-00-2", "0000-01-00-3"))
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("exceed the maximum of 2 prepared VFs on PF eth0"))
+		})
+
+		It("should allow a new allocation once a claim is unprepared", func() {
+			preparedDevices, err := manager.PrepareDevicesForClaim(ctx, &ifNameIndex, newClaim("claim-1", "pod-1", "0000-01-00-1", "0000-01-00-2"))
+			Expect(err).NotTo(HaveOccurred())
+
+			_, err = manager.PrepareDevicesForClaim(ctx, &ifNameIndex, newClaim("claim-2", "pod-2", "0000-01-00-3"))
+			Expect(err).To(HaveOccurred())
+
+			Expect(manager.Unprepare("claim-1", preparedDevices)).To(Succeed())
+			_, err = manager.PrepareDevicesForClaim(ctx, &ifNameIndex, newClaim("claim-2", "pod-2", "0000-01-00-3"))
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("should count the devices restored from the checkpoint", func() {
+			manager.RecordPreparedDevices(draTypes.PreparedDevices{
+				{Device: drapbv1.Device{DeviceName: "0000-01-00-1"}},
+				{Device: drapbv1.Device{DeviceName: "0000-01-00-2"}},
+			})
+
+			_, err := manager.PrepareDevicesForClaim(ctx, &ifNameIndex, newClaim("claim-1", "pod-1", "0000-01-00-3"))
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("2 already prepared"))
+		})
+	})
+
+	Context("VF reset on unprepare", func() {
+		prepare := func() (*devicestate.Manager, draTypes.PreparedDevices) {
+			manager, err := devicestate.NewManager(ctx, config, cdiHandler)
+			Expect(err).NotTo(HaveOccurred())
+			preparedDevices, err := manager.PrepareDevicesForClaim(ctx, &ifNameIndex, newClaim("claim-1", "pod-1", "0000-01-00-1"))
+			Expect(err).NotTo(HaveOccurred())
+			return manager, preparedDevices
+		}
+
+		It("should reset the VF without verifying it by default", func() {
+			manager, preparedDevices := prepare()
+			mockHost.EXPECT().ResetVF("0000:01:00.1").Return(nil)
+
+			Expect(manager.Unprepare("claim-1", preparedDevices)).To(Succeed())
+		})
+
+		It("should not fail the unprepare when the reset fails without verification", func() {
+			manager, preparedDevices := prepare()
+			mockHost.EXPECT().ResetVF("0000:01:00.1").Return(fmt.Errorf("netlink failed"))
+
+			Expect(manager.Unprepare("claim-1", preparedDevices)).To(Succeed())
+		})
+
+		It("should succeed when the verification reads back a cleared VF", func() {
+			config.Flags.VerifyVFReset = true
+			manager, preparedDevices := prepare()
+			mockHost.EXPECT().ResetVF("0000:01:00.1").Return(nil)
+			mockHost.EXPECT().VerifyVFReset("0000:01:00.1").Return(nil)
+
+			Expect(manager.Unprepare("claim-1", preparedDevices)).To(Succeed())
+		})
+
+		It("should fail when the verification reads back a stale VF configuration", func() {
+			config.Flags.VerifyVFReset = true
+			manager, preparedDevices := prepare()
+			mockHost.EXPECT().ResetVF("0000:01:00.1").Return(nil)
+			mockHost.EXPECT().VerifyVFReset("0000:01:00.1").Return(fmt.Errorf("VF 0 on PF eth0 was not reset: VLAN 100"))
+
+			err := manager.Unprepare("claim-1", preparedDevices)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("VF reset verification failed for device 0000:01:00.1"))
+			Expect(err.Error()).To(ContainSubstring("VLAN 100"))
+		})
+
+		It("should wait for the grace period before resetting the VF", func() {
+			config.Flags.VFResetGracePeriod = 2 * time.Second
+			manager, preparedDevices := prepare()
+			fakeClock := clocktesting.NewFakeClock(time.Now())
+			manager.SetClock(fakeClock)
+
+			reset := make(chan struct{})
+			mockHost.EXPECT().ResetVF("0000:01:00.1").DoAndReturn(func(string) error {
+				close(reset)
+				return nil
+			})
+			done := make(chan error, 1)
+			go func() { done <- manager.Unprepare("claim-1", preparedDevices) }()
+
+			Eventually(fakeClock.HasWaiters).Should(BeTrue())
+			fakeClock.Step(time.Second)
+			Consistently(reset, 100*time.Millisecond).ShouldNot(BeClosed())
+
+			fakeClock.Step(time.Second)
+			Eventually(reset).Should(BeClosed())
+			Eventually(done).Should(Receive(BeNil()))
+		})
+
+		It("should fail when the reset fails with verification enabled", func() {
+			config.Flags.VerifyVFReset = true
+			manager, preparedDevices := prepare()
+			mockHost.EXPECT().ResetVF("0000:01:00.1").Return(fmt.Errorf("netlink failed"))
+
+			err := manager.Unprepare("claim-1", preparedDevices)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("failed to reset VF configuration for device 0000:01:00.1"))
+		})
+	})
+
+	Context("VF netdev wait", func() {
+		var (
+			manager   *devicestate.Manager
+			fakeClock *clocktesting.FakeClock
+		)
+
+		BeforeEach(func() {
+			config.Flags.VFNetdevWaitTimeout = time.Second
+			var err error
+			manager, err = devicestate.NewManager(ctx, config, cdiHandler)
+			Expect(err).NotTo(HaveOccurred())
+			fakeClock = clocktesting.NewFakeClock(time.Now())
+			manager.SetClock(fakeClock)
+		})
+
+		It("should prepare the VF once its netdev appears", func() {
+			gomock.InOrder(
+				mockHost.EXPECT().TryGetInterfaceName("0000:01:00.1").Return(""),
+				mockHost.EXPECT().TryGetInterfaceName("0000:01:00.1").Return("eth0v0"),
+			)
+
+			done := make(chan error, 1)
+			go func() {
+				_, err := manager.PrepareDevicesForClaim(ctx, &ifNameIndex, newClaim("claim-1", "pod-1", "0000-01-00-1"))
+				done <- err
+			}()
+
+			Eventually(fakeClock.HasWaiters).Should(BeTrue())
+			Consistently(done, 100*time.Millisecond).ShouldNot(Receive())
+			fakeClock.Step(100 * time.Millisecond)
+			Eventually(done).Should(Receive(BeNil()))
+		})
+
+		It("should fail the prepare when the netdev doesn't appear in time", func() {
+			mockHost.EXPECT().TryGetInterfaceName("0000:01:00.1").Return("").AnyTimes()
+
+			done := make(chan error, 1)
+			go func() {
+				_, err := manager.PrepareDevicesForClaim(ctx, &ifNameIndex, newClaim("claim-1", "pod-1", "0000-01-00-1"))
+				done <- err
+			}()
+
+			for range 10 {
+				Eventually(fakeClock.HasWaiters).Should(BeTrue())
+				fakeClock.Step(100 * time.Millisecond)
+			}
+			var err error
+			Eventually(done).Should(Receive(&err))
+			Expect(err).To(MatchError(ContainSubstring("the netdev of device 0000:01:00.1 didn't appear within 1s")))
+		})
+
+		It("should not wait for the netdev of a VF bound to a userspace driver", func() {
+			rawConfig := `{"apiVersion":"sriovnetwork.openshift.io/v1alpha1","kind":"VfConfig","netAttachDefName":"test-net","driver":"vfio-pci"}`
+			mockHost.EXPECT().IsDpdkDriver("vfio-pci").Return(true)
+			mockHost.EXPECT().GetVFIODeviceFile("0000:01:00.1").Return("/dev/vfio/42", "/dev/vfio/42", nil)
+
+			_, err := manager.PrepareDevicesForClaim(ctx, &ifNameIndex, newClaimWithConfig(rawConfig, "claim-1", "pod-1", "0000-01-00-1"))
+			Expect(err).NotTo(HaveOccurred())
+		})
+	})
+
+	Context("MAC address", func() {
+		const macVfConfig = `{"apiVersion":"sriovnetwork.openshift.io/v1alpha1","kind":"VfConfig","netAttachDefName":"test-net","macAddress":"02:00:00:00:00:01"}`
+
+		It("should set the MAC address through the PF on a legacy PF", func() {
+			manager, err := devicestate.NewManager(ctx, config, cdiHandler)
+			Expect(err).NotTo(HaveOccurred())
+			mockHost.EXPECT().SetVFMacAddress("0000:01:00.1", "02:00:00:00:00:01").Return(nil)
+
+			_, err = manager.PrepareDevicesForClaim(ctx, &ifNameIndex, newClaimWithConfig(macVfConfig, "claim-1", "pod-1", "0000-01-00-1"))
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("should set the MAC address on the representor on a switchdev PF", func() {
+			eswitchMode = consts.EswitchModeSwitchdev
+			manager, err := devicestate.NewManager(ctx, config, cdiHandler)
+			Expect(err).NotTo(HaveOccurred())
+			mockHost.EXPECT().SetVFRepresentorMacAddress("0000:01:00.1", "02:00:00:00:00:01").Return(nil)
+
+			_, err = manager.PrepareDevicesForClaim(ctx, &ifNameIndex, newClaimWithConfig(macVfConfig, "claim-1", "pod-1", "0000-01-00-1"))
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("should fail the prepare when the MAC address cannot be set", func() {
+			manager, err := devicestate.NewManager(ctx, config, cdiHandler)
+			Expect(err).NotTo(HaveOccurred())
+			mockHost.EXPECT().SetVFMacAddress("0000:01:00.1", "02:00:00:00:00:01").Return(fmt.Errorf("netlink failed"))
+
+			_, err = manager.PrepareDevicesForClaim(ctx, &ifNameIndex, newClaimWithConfig(macVfConfig, "claim-1", "pod-1", "0000-01-00-1"))
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("error setting MAC address on device 0000:01:00.1"))
+		})
+
+		Context("randomized", func() {
+			const randomizeMacVfConfig = `{"apiVersion":"sriovnetwork.openshift.io/v1alpha1","kind":"VfConfig","netAttachDefName":"test-net","randomizeMac":true}`
+
+			It("should derive the same locally administered unicast MAC address from the same claim and device", func() {
+				mac := devicestate.RandomizedMacAddress("claim-uid-1", "0000-01-00-1")
+				Expect(devicestate.RandomizedMacAddress("claim-uid-1", "0000-01-00-1")).To(Equal(mac))
+				Expect(mac).To(HaveLen(6))
+				Expect(mac[0]&0x02).To(Equal(byte(0x02)), "locally administered bit")
+				Expect(mac[0]&0x01).To(BeZero(), "multicast bit")
+
+				Expect(devicestate.RandomizedMacAddress("claim-uid-1", "0000-01-00-2")).NotTo(Equal(mac))
+				Expect(devicestate.RandomizedMacAddress("claim-uid-2", "0000-01-00-1")).NotTo(Equal(mac))
+			})
+
+			It("should set a different randomized MAC address on each VF of the claim", func() {
+				manager, err := devicestate.NewManager(ctx, config, cdiHandler)
+				Expect(err).NotTo(HaveOccurred())
+				mac1 := devicestate.RandomizedMacAddress("claim-1", "0000-01-00-1").String()
+				mac2 := devicestate.RandomizedMacAddress("claim-1", "0000-01-00-2").String()
+				mockHost.EXPECT().SetVFMacAddress("0000:01:00.1", mac1).Return(nil)
+				mockHost.EXPECT().SetVFMacAddress("0000:01:00.2", mac2).Return(nil)
+
+				preparedDevices, err := manager.PrepareDevicesForClaim(ctx, &ifNameIndex,
+					newClaimWithConfig(randomizeMacVfConfig, "claim-1", "pod-1", "0000-01-00-1", "0000-01-00-2"))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(preparedDevices).To(HaveLen(2))
+				// the MAC address is passed to the CNI like a configured one
+				Expect(preparedDevices[0].Config.MacAddress).To(Equal(mac1))
+				Expect(preparedDevices[1].Config.MacAddress).To(Equal(mac2))
+			})
+
+			It("should keep the configured MAC address", func() {
+				manager, err := devicestate.NewManager(ctx, config, cdiHandler)
+				Expect(err).NotTo(HaveOccurred())
+				mockHost.EXPECT().SetVFMacAddress("0000:01:00.1", "02:00:00:00:00:01").Return(nil)
+
+				_, err = manager.PrepareDevicesForClaim(ctx, &ifNameIndex, newClaimWithConfig(
+					`{"apiVersion":"sriovnetwork.openshift.io/v1alpha1","kind":"VfConfig","netAttachDefName":"test-net","macAddress":"02:00:00:00:00:01","randomizeMac":true}`,
+					"claim-1", "pod-1", "0000-01-00-1"))
+				Expect(err).NotTo(HaveOccurred())
+			})
+		})
+
+		It("should not set the MAC address when the config has none", func() {
+			manager, err := devicestate.NewManager(ctx, config, cdiHandler)
+			Expect(err).NotTo(HaveOccurred())
+
+			_, err = manager.PrepareDevicesForClaim(ctx, &ifNameIndex, newClaim("claim-1", "pod-1", "0000-01-00-1"))
+			Expect(err).NotTo(HaveOccurred())
+		})
+	})
+
+	Context("tx rate", func() {
+		const rateVfConfig = `{"apiVersion":"sriovnetwork.openshift.io/v1alpha1","kind":"VfConfig","netAttachDefName":"test-net","minTxRate":100,"maxTxRate":1000}`
+
+		It("should set the tx rate on a device supporting rate limiting", func() {
+			manager, err := devicestate.NewManager(ctx, config, cdiHandler)
+			Expect(err).NotTo(HaveOccurred())
+			mockHost.EXPECT().SetVFRate("0000:01:00.1", 100, 1000).Return(nil)
+
+			_, err = manager.PrepareDevicesForClaim(ctx, &ifNameIndex, newClaimWithConfig(rateVfConfig, "claim-1", "pod-1", "0000-01-00-1"))
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("should set the tx rate on a device whose rate limiting support is unknown", func() {
+			manager, err := devicestate.NewManager(ctx, config, cdiHandler)
+			Expect(err).NotTo(HaveOccurred())
+			delete(manager.AllocatableDevices()["0000-01-00-1"].Attributes, consts.AttributeSupportsRateLimiting)
+			mockHost.EXPECT().SetVFRate("0000:01:00.1", 100, 1000).Return(nil)
+
+			_, err = manager.PrepareDevicesForClaim(ctx, &ifNameIndex, newClaimWithConfig(rateVfConfig, "claim-1", "pod-1", "0000-01-00-1"))
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("should reject rate limiting on a device known not to support it", func() {
+			manager, err := devicestate.NewManager(ctx, config, cdiHandler)
+			Expect(err).NotTo(HaveOccurred())
+			manager.AllocatableDevices()["0000-01-00-1"].Attributes[consts.AttributeSupportsRateLimiting] = resourceapi.DeviceAttribute{BoolValue: ptr.To(false)}
+
+			_, err = manager.PrepareDevicesForClaim(ctx, &ifNameIndex, newClaimWithConfig(rateVfConfig, "claim-1", "pod-1", "0000-01-00-1"))
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("device 0000-01-00-1 does not support tx rate limiting requested by the config"))
+		})
+	})
+
+	Context("link state", func() {
+		linkStateVfConfig := func(linkState string) string {
+			return fmt.Sprintf(`{"apiVersion":"sriovnetwork.openshift.io/v1alpha1","kind":"VfConfig","netAttachDefName":"test-net","linkState":%q}`, linkState)
+		}
+
+		DescribeTable("should set the configured link state on the VF",
+			func(linkState string) {
+				manager, err := devicestate.NewManager(ctx, config, cdiHandler)
+				Expect(err).NotTo(HaveOccurred())
+				mockHost.EXPECT().SetVFLinkState("0000:01:00.1", linkState).Return(nil)
+
+				_, err = manager.PrepareDevicesForClaim(ctx, &ifNameIndex, newClaimWithConfig(linkStateVfConfig(linkState), "claim-1", "pod-1", "0000-01-00-1"))
+				Expect(err).NotTo(HaveOccurred())
+			},
+			Entry("enable", configapi.LinkStateEnable),
+			Entry("disable", configapi.LinkStateDisable),
+		)
+
+		It("should not set the auto link state of a reset VF", func() {
+			manager, err := devicestate.NewManager(ctx, config, cdiHandler)
+			Expect(err).NotTo(HaveOccurred())
+
+			preparedDevices, err := manager.PrepareDevicesForClaim(ctx, &ifNameIndex, newClaimWithConfig(linkStateVfConfig(configapi.LinkStateAuto), "claim-1", "pod-1", "0000-01-00-1"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(preparedDevices[0].Config.LinkState).To(Equal(configapi.LinkStateAuto))
+		})
+
+		It("should fail the preparation when the link state can't be set", func() {
+			manager, err := devicestate.NewManager(ctx, config, cdiHandler)
+			Expect(err).NotTo(HaveOccurred())
+			mockHost.EXPECT().SetVFLinkState("0000:01:00.1", configapi.LinkStateDisable).Return(fmt.Errorf("operation not supported"))
+
+			_, err = manager.PrepareDevicesForClaim(ctx, &ifNameIndex, newClaimWithConfig(linkStateVfConfig(configapi.LinkStateDisable), "claim-1", "pod-1", "0000-01-00-1"))
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("error setting link state on device 0000:01:00.1"))
+		})
+	})
+
+	Context("vlan", func() {
+		const vlanVfConfig = `{"apiVersion":"sriovnetwork.openshift.io/v1alpha1","kind":"VfConfig","netAttachDefName":"test-net","vlan":100}`
+
+		var manager *devicestate.Manager
+
+		BeforeEach(func() {
+			var err error
+			manager, err = devicestate.NewManager(ctx, config, cdiHandler)
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		// moveToPFWithoutVlan makes the device look like a VF of a second PF known not to set a VF VLAN
+		moveToPFWithoutVlan := func(deviceName string) {
+			device := manager.AllocatableDevices()[deviceName]
+			device.Attributes[consts.AttributePFName] = resourceapi.DeviceAttribute{StringValue: ptr.To("eth1")}
+			device.Attributes[consts.AttributeSupportsVlan] = resourceapi.DeviceAttribute{BoolValue: ptr.To(false)}
+		}
+
+		It("should prepare the devices whose vlan support is unknown", func() {
+			Expect(manager.GetAllocatableDevices()["0000-01-00-1"].Attributes).NotTo(HaveKey(resourceapi.QualifiedName(consts.AttributeSupportsVlan)))
+
+			_, err := manager.PrepareDevicesForClaim(ctx, &ifNameIndex, newClaimWithConfig(vlanVfConfig, "claim-1", "pod-1", "0000-01-00-1", "0000-01-00-2"))
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("should set the vlan in the netconf passed to sriov-cni", func() {
+			preparedDevices, err := manager.PrepareDevicesForClaim(ctx, &ifNameIndex, newClaimWithConfig(vlanVfConfig, "claim-1", "pod-1", "0000-01-00-1"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(preparedDevices[0].NetAttachDefConfig).To(ContainSubstring(`"vlan":100`))
+		})
+
+		It("should reject the claim naming the device of the PF that can't set the vlan", func() {
+			moveToPFWithoutVlan("0000-01-00-2")
+
+			_, err := manager.PrepareDevicesForClaim(ctx, &ifNameIndex, newClaimWithConfig(vlanVfConfig, "claim-1", "pod-1", "0000-01-00-1", "0000-01-00-2"))
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("device 0000-01-00-2 does not support vlan requested by the config"))
+			Expect(err.Error()).To(ContainSubstring("on PF eth1"))
+			Expect(err.Error()).NotTo(ContainSubstring("device 0000-01-00-1"))
+		})
+
+		It("should report every device that can't set the vlan at once", func() {
+			moveToPFWithoutVlan("0000-01-00-2")
+			moveToPFWithoutVlan("0000-01-00-3")
+
+			_, err := manager.PrepareDevicesForClaim(ctx, &ifNameIndex, newClaimWithConfig(vlanVfConfig, "claim-1", "pod-1", "0000-01-00-1", "0000-01-00-2", "0000-01-00-3"))
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("device 0000-01-00-2 does not support vlan"))
+			Expect(err.Error()).To(ContainSubstring("device 0000-01-00-3 does not support vlan"))
+		})
+	})
+
+	Context("default route", func() {
+		const defaultRouteVfConfig = `{"apiVersion":"sriovnetwork.openshift.io/v1alpha1","kind":"VfConfig","netAttachDefName":"test-net","makeDefaultRoute":true}`
+
+		var (
+			manager    *devicestate.Manager
+			podDevices draTypes.PreparedDevices
+		)
+
+		BeforeEach(func() {
+			var err error
+			manager, err = devicestate.NewManager(ctx, config, cdiHandler)
+			Expect(err).NotTo(HaveOccurred())
+			podDevices = nil
+			manager.SetPodDevicesLookup(func(podUID k8stypes.UID) (draTypes.PreparedDevices, bool) {
+				return podDevices, len(podDevices) > 0
+			})
+		})
+
+		It("should prepare a single device requesting the default route", func() {
+			preparedDevices, err := manager.PrepareDevicesForClaim(ctx, &ifNameIndex, newClaimWithConfig(defaultRouteVfConfig, "claim-1", "pod-1", "0000-01-00-1"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(preparedDevices[0].Config.MakeDefaultRoute).To(BeTrue())
+		})
+
+		It("should reject a claim requesting the default route for two devices before configuring them", func() {
+			rawConfig := `{"apiVersion":"sriovnetwork.openshift.io/v1alpha1","kind":"VfConfig","netAttachDefName":"test-net","makeDefaultRoute":true,"linkState":"disable"}`
+			mockHost.EXPECT().SetVFLinkState(gomock.Any(), gomock.Any()).Times(0)
+
+			_, err := manager.PrepareDevicesForClaim(ctx, &ifNameIndex, newClaimWithConfig(rawConfig, "claim-1", "pod-1", "0000-01-00-1", "0000-01-00-2"))
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("only one device of a pod can request the default route, requested by 0000-01-00-1 and 0000-01-00-2"))
+		})
+
+		It("should reject a claim requesting the default route already requested by another claim of the pod", func() {
+			preparedDevices, err := manager.PrepareDevicesForClaim(ctx, &ifNameIndex, newClaimWithConfig(defaultRouteVfConfig, "claim-1", "pod-1", "0000-01-00-1"))
+			Expect(err).NotTo(HaveOccurred())
+			podDevices = preparedDevices
+
+			_, err = manager.PrepareDevicesForClaim(ctx, &ifNameIndex, newClaimWithConfig(defaultRouteVfConfig, "claim-2", "pod-1", "0000-01-00-2"))
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("requested by 0000-01-00-1 and 0000-01-00-2"))
+
+			// the devices of the claim itself don't count, e.g. for a claim prepared again
+			_, err = manager.PrepareDevicesForClaim(ctx, &ifNameIndex, newClaimWithConfig(defaultRouteVfConfig, "claim-1", "pod-1", "0000-01-00-3"))
+			Expect(err).NotTo(HaveOccurred())
+		})
+	})
+
+	Context("tracing", func() {
+		var exporter *tracetest.InMemoryExporter
+
+		BeforeEach(func() {
+			exporter = tracetest.NewInMemoryExporter()
+			provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+			originalProvider := otel.GetTracerProvider()
+			otel.SetTracerProvider(provider)
+			DeferCleanup(func() {
+				otel.SetTracerProvider(originalProvider)
+				Expect(provider.Shutdown(context.Background())).To(Succeed())
+			})
+		})
+
+		// findSpan returns the exported span with the given name and device, an empty device matches any span
+		findSpan := func(name, device string) tracetest.SpanStub {
+			for _, span := range exporter.GetSpans() {
+				if span.Name != name {
+					continue
+				}
+				if device == "" || slices.Contains(span.Attributes, tracing.AttributeDevice.String(device)) {
+					return span
+				}
+			}
+			Fail(fmt.Sprintf("span %s of device %q not found", name, device))
+			return tracetest.SpanStub{}
+		}
+
+		It("should nest the per device spans under the applyConfig span under the prepare span", func() {
+			manager, err := devicestate.NewManager(ctx, config, cdiHandler)
+			Expect(err).NotTo(HaveOccurred())
+
+			_, err = manager.PrepareDevicesForClaim(ctx, &ifNameIndex, newClaim("claim-1", "pod-1", "0000-01-00-1", "0000-01-00-2"))
+			Expect(err).NotTo(HaveOccurred())
+
+			prepareSpan := findSpan("PrepareDevicesForClaim", "")
+			Expect(prepareSpan.Parent.IsValid()).To(BeFalse())
+			Expect(prepareSpan.Attributes).To(ContainElement(tracing.AttributeClaimUID.String("claim-1")))
+
+			applyConfigSpan := findSpan("applyConfig", "")
+			Expect(applyConfigSpan.Parent.SpanID()).To(Equal(prepareSpan.SpanContext.SpanID()))
+
+			for _, device := range []string{"0000-01-00-1", "0000-01-00-2"} {
+				deviceSpan := findSpan("applyConfigOnDevice", device)
+				Expect(deviceSpan.Parent.SpanID()).To(Equal(applyConfigSpan.SpanContext.SpanID()))
+				Expect(deviceSpan.SpanContext.TraceID()).To(Equal(prepareSpan.SpanContext.TraceID()))
+				Expect(deviceSpan.Attributes).To(ContainElement(tracing.AttributeClaimUID.String("claim-1")))
+			}
+		})
+
+		It("should record the error of a failed prepare on the spans", func() {
+			manager, err := devicestate.NewManager(ctx, config, cdiHandler)
+			Expect(err).NotTo(HaveOccurred())
+
+			_, err = manager.PrepareDevicesForClaim(ctx, &ifNameIndex, newClaim("claim-1", "pod-1", "0000-01-00-9"))
+			Expect(err).To(HaveOccurred())
+
+			Expect(findSpan("applyConfigOnDevice", "0000-01-00-9").Status.Code).To(Equal(codes.Error))
+			Expect(findSpan("PrepareDevicesForClaim", "").Status.Code).To(Equal(codes.Error))
+		})
+	})
+})
@@ -1,7 +1,9 @@
 package devicestate
 
 import (
+	"context"
 	"fmt"
+	"os"
 
 	resourceapi "k8s.io/api/resource/v1"
 	"k8s.io/apimachinery/pkg/runtime"
@@ -22,21 +24,35 @@ import (
 // All of the configs relevant to the driver from the list of possibleConfigs
 // will be returned in order of precedence (from lowest to highest). If no
 // configs are found, nil is returned.
+//
+// The configs are applied on top of the node default config when one is set,
+// in that case no error is returned when no config is found.
+//
+// A config referencing a ConfigMap is merged on top of the config read from it
+// by resolveConfigMapRef before it is applied.
+//
+// The selection of the configs is logged at V(4), the config index is the
+// position of the config in possibleConfigs.
 func getMapOfOpaqueDeviceConfigForDevice(
+	ctx context.Context,
 	decoder runtime.Decoder,
 	possibleConfigs []resourceapi.DeviceAllocationConfiguration,
+	nodeDefaultConfig *configapi.VfConfig,
+	resolveConfigMapRef func(context.Context, *configapi.VfConfig) (*configapi.VfConfig, error),
 ) (map[string]*configapi.VfConfig, error) {
-	// Collect all configs in order of reverse precedence.
-	var classConfigs []resourceapi.DeviceAllocationConfiguration
-	var claimConfigs []resourceapi.DeviceAllocationConfiguration
-	var candidateConfigs []resourceapi.DeviceAllocationConfiguration
+	logger := klog.FromContext(ctx).WithName("getMapOfOpaqueDeviceConfigForDevice")
 
-	for _, config := range possibleConfigs {
+	// Collect the indices of all configs in order of reverse precedence.
+	var classConfigs []int
+	var claimConfigs []int
+	var candidateConfigs []int
+
+	for index, config := range possibleConfigs {
 		switch config.Source {
 		case resourceapi.AllocationConfigSourceClass:
-			classConfigs = append(classConfigs, config)
+			classConfigs = append(classConfigs, index)
 		case resourceapi.AllocationConfigSourceClaim:
-			claimConfigs = append(claimConfigs, config)
+			claimConfigs = append(claimConfigs, index)
 		default:
 			return nil, fmt.Errorf("invalid config source: %v", config.Source)
 		}
@@ -47,7 +63,8 @@ func getMapOfOpaqueDeviceConfigForDevice(
 	// Decode all configs that are relevant for the driver.
 	resultConfigs := make(map[string]*configapi.VfConfig)
 
-	for _, config := range candidateConfigs {
+	for _, configIndex := range candidateConfigs {
+		config := possibleConfigs[configIndex]
 		// If this is nil, the driver doesn't support some future API extension
 		// and needs to be updated.
 		if config.DeviceConfiguration.Opaque == nil {
@@ -59,29 +76,77 @@ func getMapOfOpaqueDeviceConfigForDevice(
 		// an error -- drivers must skip over other driver's configs in order
 		// to support this.
 		if config.DeviceConfiguration.Opaque.Driver != consts.DriverName {
+			logger.V(4).Info("Skipping config of another driver", "configIndex", configIndex, "source", config.Source, "driver", config.DeviceConfiguration.Opaque.Driver)
 			continue
 		}
 
+		// The decoder is strict, a misspelled or unknown field fails the decoding
+		// instead of being dropped, the error names the field.
 		decodedConfig, err := runtime.Decode(decoder, config.DeviceConfiguration.Opaque.Parameters.Raw)
 		if err != nil {
-			return nil, fmt.Errorf("error decoding config parameters: %w", err)
+			return nil, fmt.Errorf("error decoding config parameters of the %s config %d: %w", config.Source, configIndex, err)
 		}
 		vfConfig, ok := decodedConfig.(*configapi.VfConfig)
 		if !ok {
 			return nil, fmt.Errorf("decoded config is not a VfConfig")
 		}
+		vfConfig, err = resolveConfigMapRef(ctx, vfConfig)
+		if err != nil {
+			return nil, fmt.Errorf("error resolving the ConfigMap reference of the %s config %d: %w", config.Source, configIndex, err)
+		}
+		if err := vfConfig.ValidateClaim(); err != nil {
+			return nil, fmt.Errorf("invalid %s config %d: %w", config.Source, configIndex, err)
+		}
 		for _, request := range config.Requests {
 			resultConfig, found := resultConfigs[request]
 			if !found {
-				resultConfig = configapi.DefaultVfConfig()
+				// the first config matching the request is applied on top of the defaults,
+				// the next ones override it
+				resultConfig = newDefaultVfConfig(nodeDefaultConfig)
+				logger.V(4).Info("Applying config on top of the default config", "request", request, "configIndex", configIndex, "source", config.Source)
+			} else {
+				logger.V(4).Info("Overriding config with a higher precedence config", "request", request, "configIndex", configIndex, "source", config.Source)
 			}
 			resultConfig.Override(vfConfig)
 			resultConfigs[request] = resultConfig
 		}
 	}
 	klog.V(3).InfoS("Result configs", "resultConfigs", resultConfigs)
-	if len(resultConfigs) == 0 {
+	if len(resultConfigs) == 0 && nodeDefaultConfig == nil {
 		return resultConfigs, fmt.Errorf("no configs constructed for driver")
 	}
 	return resultConfigs, nil
 }
+
+// newDefaultVfConfig returns the config the claim configs are applied on:
+// a copy of the node default config when set, the API default config otherwise
+func newDefaultVfConfig(nodeDefaultConfig *configapi.VfConfig) *configapi.VfConfig {
+	if nodeDefaultConfig == nil {
+		return configapi.DefaultVfConfig()
+	}
+	return nodeDefaultConfig.DeepCopy()
+}
+
+// loadDefaultVfConfig reads the node default VfConfig from a JSON file.
+// The fields set in the file are applied on top of the API default config.
+func loadDefaultVfConfig(decoder runtime.Decoder, path string) (*configapi.VfConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading default VF config file %s: %w", path, err)
+	}
+	decodedConfig, err := runtime.Decode(decoder, data)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding default VF config file %s: %w", path, err)
+	}
+	vfConfig, ok := decodedConfig.(*configapi.VfConfig)
+	if !ok {
+		return nil, fmt.Errorf("default VF config file %s does not contain a VfConfig", path)
+	}
+	if err := vfConfig.ValidateDefaults(); err != nil {
+		return nil, fmt.Errorf("invalid default VF config file %s: %w", path, err)
+	}
+
+	defaultConfig := configapi.DefaultVfConfig()
+	defaultConfig.Override(vfConfig)
+	return defaultConfig, nil
+}
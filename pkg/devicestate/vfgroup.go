@@ -0,0 +1,132 @@
+package devicestate
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"sort"
+	"strings"
+
+	resourceapi "k8s.io/api/resource/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/utils/ptr"
+
+	"github.com/SchSeba/dra-driver-sriov/pkg/consts"
+	"github.com/SchSeba/dra-driver-sriov/pkg/types"
+)
+
+// vfAttributes are the attributes describing a single VF, they are not copied on the VF group devices
+var vfAttributes = []resourceapi.QualifiedName{
+	consts.AttributePciAddress,
+	consts.AttributeVFID,
+	consts.AttributeRepresentor,
+	consts.AttributeResourceName,
+}
+
+// vfGroupCounter is the counter of the VFs of a PF, every VF consumes one and the VF group consumes all of them
+const vfGroupCounter = "vfs"
+
+// vfGroupCounterSetName returns the name of the counter set shared by the VFs and the VF group device of a PF
+func vfGroupCounterSetName(pfName string) string {
+	return fmt.Sprintf("%s-vfs", toDNSLabel(pfName))
+}
+
+// vfGroupCounterConsumption returns the consumption of count VFs from the counter set of a PF
+func vfGroupCounterConsumption(pfName string, count int) []resourceapi.DeviceCounterConsumption {
+	return []resourceapi.DeviceCounterConsumption{{
+		CounterSet: vfGroupCounterSetName(pfName),
+		Counters: map[string]resourceapi.Counter{
+			vfGroupCounter: {Value: *resource.NewQuantity(int64(count), resource.DecimalSI)},
+		},
+	}}
+}
+
+// vfGroupDeviceName returns the name of the VF group device of a PF
+func vfGroupDeviceName(pfName string) string {
+	return fmt.Sprintf("%s-all-vfs", toDNSLabel(pfName))
+}
+
+// addVFGroups adds a VF group device per PF of the discovered VFs, allocating all of them together.
+// The group device carries the PF attributes of its VFs, so the claims select it like a VF of the PF.
+// The VFs and the group consume the shared counters of their PF, so the scheduler never allocates
+// the group together with one of its VFs.
+func addVFGroups(devices types.AllocatableDevices) {
+	vfsByPF := map[string][]string{}
+	for deviceName := range devices {
+		pfName := devices[deviceName].Attributes[consts.AttributePFName].StringValue
+		if pfName == nil {
+			continue
+		}
+		vfsByPF[*pfName] = append(vfsByPF[*pfName], deviceName)
+	}
+
+	for pfName, vfNames := range vfsByPF {
+		sort.Strings(vfNames)
+		for _, vfName := range vfNames {
+			vf := devices[vfName]
+			vf.ConsumesCounters = vfGroupCounterConsumption(pfName, 1)
+			devices[vfName] = vf
+		}
+		vf := devices[vfNames[0]]
+
+		attributes := maps.Clone(vf.Attributes)
+		for _, name := range vfAttributes {
+			delete(attributes, name)
+		}
+		attributes[consts.AttributeVFGroup] = resourceapi.DeviceAttribute{BoolValue: ptr.To(true)}
+		attributes[consts.AttributeVFGroupSize] = resourceapi.DeviceAttribute{IntValue: ptr.To(int64(len(vfNames)))}
+
+		groupName := vfGroupDeviceName(pfName)
+		devices[groupName] = resourceapi.Device{
+			Name:             groupName,
+			Attributes:       attributes,
+			Taints:           slices.Clone(vf.Taints),
+			ConsumesCounters: vfGroupCounterConsumption(pfName, len(vfNames)),
+		}
+	}
+}
+
+// SharedCounters returns the counter sets consumed by the devices, sized by their largest consumption.
+// The VF group device consumes all the VFs of its PF, so the counter set of a PF holds one counter per VF.
+// The counter sets are sorted by name so the published slices stay stable.
+func SharedCounters(devices []resourceapi.Device) []resourceapi.CounterSet {
+	counterSets := map[string]resourceapi.CounterSet{}
+	for _, device := range devices {
+		for _, consumption := range device.ConsumesCounters {
+			counterSet, found := counterSets[consumption.CounterSet]
+			if !found {
+				counterSet = resourceapi.CounterSet{Name: consumption.CounterSet, Counters: map[string]resourceapi.Counter{}}
+				counterSets[consumption.CounterSet] = counterSet
+			}
+			for name, counter := range consumption.Counters {
+				if current, found := counterSet.Counters[name]; !found || counter.Value.Cmp(current.Value) > 0 {
+					counterSet.Counters[name] = resourceapi.Counter{Value: counter.Value.DeepCopy()}
+				}
+			}
+		}
+	}
+	return slices.SortedFunc(maps.Values(counterSets), func(a, b resourceapi.CounterSet) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+}
+
+// isVFGroup returns true when the allocatable device is a VF group
+func isVFGroup(device resourceapi.Device) bool {
+	vfGroup := device.Attributes[consts.AttributeVFGroup].BoolValue
+	return vfGroup != nil && *vfGroup
+}
+
+// vfGroupMembers returns the names of the VFs of the PF of a VF group device, sorted.
+// The caller must hold allocatableMu.
+func (s *Manager) vfGroupMembers(groupName string) []string {
+	pfName := s.getPFName(groupName)
+	members := []string{}
+	for deviceName, device := range s.allocatable {
+		if isVFGroup(device) || s.getPFName(deviceName) != pfName {
+			continue
+		}
+		members = append(members, deviceName)
+	}
+	sort.Strings(members)
+	return members
+}
@@ -4,18 +4,21 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"time"
 
+	"github.com/SchSeba/dra-driver-sriov/pkg/claimstatus"
 	"github.com/SchSeba/dra-driver-sriov/pkg/consts"
 	resourceapi "k8s.io/api/resource/v1"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	k8stypes "k8s.io/apimachinery/pkg/types"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
-	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/dynamic-resource-allocation/kubeletplugin"
 	"k8s.io/klog/v2"
 )
 
+// PrepareResourceClaims prepares the claims of a pod. Only the driver level errors, where no claim can be
+// prepared, are returned, the failures of a claim or of the pod are stored in the result of its claims.
 func (d *Driver) PrepareResourceClaims(ctx context.Context, claims []*resourceapi.ResourceClaim) (map[k8stypes.UID]kubeletplugin.PrepareResult, error) {
 	result := make(map[k8stypes.UID]kubeletplugin.PrepareResult)
 	if len(claims) == 0 {
@@ -23,6 +26,9 @@ func (d *Driver) PrepareResourceClaims(ctx context.Context, claims []*resourceap
 	}
 	logger := klog.FromContext(ctx).WithName("PrepareResourceClaims")
 	logger.V(3).Info("claims", "claims", claims)
+	if err := d.reloadPreparedClaims(); err != nil {
+		return nil, err
+	}
 
 	// we share this between all the claims so we can enumerate network interfaces
 	ifNameIndex := 0
@@ -37,30 +43,122 @@ func (d *Driver) PrepareResourceClaims(ctx context.Context, claims []*resourceap
 		}
 	}
 
-	preparedDevices, exists := d.podManager.GetDevicesByPodUID(claims[0].Status.ReservedFor[0].UID)
-	if !exists && len(claims) > 0 {
-		logger.Error(fmt.Errorf("no prepared devices found for pod %s", claims[0].Status.ReservedFor[0].UID), "Error preparing devices for claim")
-		return result, fmt.Errorf("no prepared devices found for pod %s", claims[0].Status.ReservedFor[0].UID)
+	if err := d.preparePod(ctx, claims, result); err != nil {
+		for _, claim := range claims {
+			if result[claim.UID].Err == nil {
+				result[claim.UID] = kubeletplugin.PrepareResult{Err: err}
+			}
+		}
+	}
+
+	// the prepare errors only reach the kubelet events, report them on the claim too
+	for _, claim := range claims {
+		if err := result[claim.UID].Err; err != nil {
+			d.reportPrepareFailure(ctx, claim, err)
+		}
+	}
+
+	logger.V(3).Info("Prepared claims", "result", result)
+	return result, nil
+}
+
+// reportPrepareFailure sets the Prepared condition of the devices of the claim to false with the prepare error.
+// Nothing is reported for a claim not allocated yet, it has no device to set the condition on.
+func (d *Driver) reportPrepareFailure(ctx context.Context, claim *resourceapi.ResourceClaim, prepareErr error) {
+	condition := metav1.Condition{
+		Type:               consts.ConditionTypePrepared,
+		Status:             metav1.ConditionFalse,
+		Reason:             consts.ReasonPrepareFailed,
+		Message:            prepareErr.Error(),
+		ObservedGeneration: claim.Generation,
+	}
+	mutate, found := setDevicesCondition(claim, condition)
+	if !found {
+		return
+	}
+	if err := claimstatus.UpdateDevices(ctx, d.client, claim.Namespace, claim.Name, mutate); err != nil {
+		klog.FromContext(ctx).Error(err, "Failed to report the prepare failure on the claim status", "claim", claim.UID)
+	}
+}
+
+// setDevicesCondition returns a MutateDevicesFunc setting the condition on the devices of this driver allocated
+// to the claim, found is false when the claim has none of them
+func setDevicesCondition(claim *resourceapi.ResourceClaim, condition metav1.Condition) (_ claimstatus.MutateDevicesFunc, found bool) {
+	if claim.Status.Allocation == nil {
+		return nil, false
+	}
+	mutates := []claimstatus.MutateDevicesFunc{}
+	for _, result := range claim.Status.Allocation.Devices.Results {
+		if result.Driver == consts.DriverName {
+			mutates = append(mutates, claimstatus.SetCondition(result.Pool, result.Device, condition))
+		}
+	}
+	return claimstatus.All(mutates...), len(mutates) > 0
+}
+
+// reservedPodCheckTimeout bounds the read of the pod a claim is reserved for, a slow API server must not stall
+// the prepare of the claims until the kubelet gives up on them
+var reservedPodCheckTimeout = 5 * time.Second
+
+// checkReservedForPod fails when the pod the claim is reserved for no longer exists, a pod recreated with
+// the same name has another UID. The kubelet retries the prepare of the failed claim or drops it with the pod.
+// The check is skipped when the pod can't be read within reservedPodCheckTimeout, an unavailable API server
+// must not fail the prepare.
+func (d *Driver) checkReservedForPod(ctx context.Context, claim *resourceapi.ResourceClaim) error {
+	reservedFor := claim.Status.ReservedFor[0]
+	getCtx, cancel := context.WithTimeout(ctx, reservedPodCheckTimeout)
+	defer cancel()
+	pod, err := d.client.CoreV1().Pods(claim.Namespace).Get(getCtx, reservedFor.Name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) || (err == nil && pod.UID != reservedFor.UID) {
+		return fmt.Errorf("pod %s/%s (%s) the claim %s/%s is reserved for no longer exists", claim.Namespace, reservedFor.Name, reservedFor.UID, claim.Namespace, claim.Name)
+	}
+	if err != nil {
+		klog.FromContext(ctx).V(2).Info("Failed to get the pod the claim is reserved for, preparing the claim anyway",
+			"pod", klog.KRef(claim.Namespace, reservedFor.Name), "claim", claim.UID, "error", err.Error())
 	}
+	return nil
+}
+
+// preparePod creates the global spec file of the pod of the claims.
+// Nothing is done when none of the claims was prepared.
+func (d *Driver) preparePod(ctx context.Context, claims []*resourceapi.ResourceClaim, result map[k8stypes.UID]kubeletplugin.PrepareResult) error {
+	logger := klog.FromContext(ctx).WithName("preparePod")
+
+	// the claims of a call all belong to the same pod, take it from a prepared claim
+	var podUID k8stypes.UID
+	for _, claim := range claims {
+		if result[claim.UID].Err == nil {
+			podUID = claim.Status.ReservedFor[0].UID
+			break
+		}
+	}
+	if podUID == "" {
+		return nil
+	}
+
+	preparedDevices, exists := d.podManager.GetDevicesByPodUID(podUID)
+	if !exists {
+		logger.Error(fmt.Errorf("no prepared devices found for pod %s", podUID), "Error preparing devices for claim")
+		return fmt.Errorf("no prepared devices found for pod %s", podUID)
+	}
+
 	// create a global spec file for the pod level environment variables
 	pciAddresses := []string{}
 	for _, preparedDevice := range preparedDevices {
 		device, exist := d.deviceStateManager.GetAllocatedDeviceByDeviceName(preparedDevice.Device.DeviceName)
 		if !exist {
 			logger.Error(fmt.Errorf("device not found for device name %s", preparedDevice.Device.DeviceName), "Error preparing devices for claim")
-			return result, fmt.Errorf("device not found for device name %s", preparedDevice.Device.DeviceName)
+			return fmt.Errorf("device not found for device name %s", preparedDevice.Device.DeviceName)
 		}
 		pciAddresses = append(pciAddresses, *device.Attributes[consts.AttributePciAddress].StringValue)
 	}
 
-	err := d.cdi.CreateGlobalPodSpecFile(string(claims[0].Status.ReservedFor[0].UID), pciAddresses)
+	err := d.cdi.CreateGlobalPodSpecFile(string(podUID), pciAddresses)
 	if err != nil {
-		logger.Error(err, "Error creating global spec file for pod", "pod", claims[0].Status.ReservedFor[0].UID)
-		return result, fmt.Errorf("error creating global spec file for pod: %w", err)
+		logger.Error(err, "Error creating global spec file for pod", "pod", podUID)
+		return fmt.Errorf("error creating global spec file for pod: %w", err)
 	}
-
-	logger.V(3).Info("Prepared claims", "result", result)
-	return result, nil
+	return nil
 }
 
 func (d *Driver) prepareResourceClaim(ctx context.Context, ifNameIndex *int, claim *resourceapi.ResourceClaim) kubeletplugin.PrepareResult {
@@ -102,6 +200,12 @@ func (d *Driver) prepareResourceClaim(ctx context.Context, ifNameIndex *int, cla
 		return kubeletplugin.PrepareResult{Devices: prepared}
 	}
 
+	// the pod can be deleted between its scheduling and the prepare, don't configure the devices for it
+	if err := d.checkReservedForPod(ctx, claim); err != nil {
+		logger.Error(err, "Error preparing devices for claim", "claim", claim.UID)
+		return kubeletplugin.PrepareResult{Err: err}
+	}
+
 	// if the pod claim is not prepared, prepare the devices for the claim
 	preparedDevices, err := d.deviceStateManager.PrepareDevicesForClaim(ctx, ifNameIndex, claim)
 	if err != nil {
@@ -124,42 +228,37 @@ func (d *Driver) prepareResourceClaim(ctx context.Context, ifNameIndex *int, cla
 	err = d.podManager.Set(podUID, claim.UID, preparedDevices)
 	if err != nil {
 		logger.Error(err, "Error setting prepared devices for pod into pod manager", "pod", podUID)
+		// the devices would be left configured without a checkpoint entry to unprepare them
+		d.deviceStateManager.RollbackPreparedClaim(ctx, string(claim.UID), preparedDevices)
+		if err := d.podManager.Unset(podUID, claim.UID); err != nil {
+			logger.Error(err, "Error removing the prepared devices of the claim from the pod manager", "pod", podUID, "claim", claim.UID)
+		}
 		return kubeletplugin.PrepareResult{
 			Err: fmt.Errorf("error setting prepared devices for pod %s into pod manager: %w", podUID, err),
 		}
 	}
 
-	// Store original devices list to preserve across conflict retries
-	originalDevices := claim.Status.Devices
-
-	err = wait.ExponentialBackoffWithContext(ctx, consts.Backoff, func(ctx context.Context) (bool, error) {
-		_, updateErr := d.client.ResourceV1().ResourceClaims(claim.Namespace).UpdateStatus(ctx, claim, metav1.UpdateOptions{})
-		if updateErr != nil {
-			// If this is a conflict error, fetch fresh claim and copy over devices list
-			if apierrors.IsConflict(updateErr) {
-				logger.V(2).Info("Conflict detected, refreshing claim", "claim", claim.UID)
-
-				freshClaim, fetchErr := d.client.ResourceV1().ResourceClaims(claim.Namespace).Get(ctx, claim.Name, metav1.GetOptions{})
-				if fetchErr != nil {
-					logger.V(2).Info("Failed to fetch fresh claim", "claim", claim.UID, "error", fetchErr.Error())
-					return false, nil // Continue retrying
-				}
-
-				// Copy original devices list to fresh claim
-				freshClaim.Status.Devices = originalDevices
-				claim = freshClaim // Use fresh claim for next retry
-
-				logger.V(2).Info("Refreshed claim, retrying status update", "claim", claim.UID)
-			} else {
-				logger.V(2).Info("Retrying claim status update", "claim", claim.UID, "error", updateErr.Error())
-			}
-			return false, nil // Return false to continue retrying, nil to not fail immediately
+	// Only write the status entries of this driver, the rest of the list is taken from the fresh claim
+	driverDevices := []resourceapi.AllocatedDeviceStatus{}
+	for _, device := range claim.Status.Devices {
+		if device.Driver == consts.DriverName {
+			driverDevices = append(driverDevices, device)
 		}
-		return true, nil // Success
-	})
-
+	}
+	mutate := claimstatus.SetDevices(driverDevices)
+	// clear the failure reported by a previous attempt
+	if setPrepared, found := setDevicesCondition(claim, metav1.Condition{
+		Type:               consts.ConditionTypePrepared,
+		Status:             metav1.ConditionTrue,
+		Reason:             consts.ReasonPrepared,
+		Message:            "the devices of the claim are prepared on the node",
+		ObservedGeneration: claim.Generation,
+	}); found {
+		mutate = claimstatus.All(mutate, setPrepared)
+	}
+	err = claimstatus.UpdateDevices(ctx, d.client, claim.Namespace, claim.Name, mutate)
 	if err != nil {
-		logger.Error(err, "Failed to update claim status after retries", "claim", claim.UID)
+		logger.Error(err, "Failed to update claim status", "claim", claim.UID)
 	}
 
 	logger.V(3).Info("Returning prepared devices for claim", "claim", claim.UID, "prepared", prepared)
@@ -170,6 +269,9 @@ func (d *Driver) UnprepareResourceClaims(ctx context.Context, claims []kubeletpl
 	logger := klog.FromContext(ctx).WithName("UnprepareResourceClaims")
 	logger.V(1).Info("UnprepareResourceClaims is called", "number of claims", len(claims))
 	logger.V(3).Info("claims", "claims", claims)
+	if err := d.reloadPreparedClaims(); err != nil {
+		return nil, err
+	}
 	result := make(map[k8stypes.UID]error)
 
 	for _, claim := range claims {
@@ -203,6 +305,22 @@ func (d *Driver) unprepareResourceClaim(ctx context.Context, claim kubeletplugin
 	return nil
 }
 
+// reloadPreparedClaims reads the prepared claims back from the checkpoint during a seamless upgrade,
+// the other driver instance running side by side may have prepared or unprepared claims since the last call
+func (d *Driver) reloadPreparedClaims() error {
+	if !d.config.Flags.SeamlessUpgrade {
+		return nil
+	}
+	if err := d.podManager.Reload(); err != nil {
+		return fmt.Errorf("error reloading the prepared claims from the checkpoint: %w", err)
+	}
+	// the per PF counters must follow the claims prepared or unprepared by the other instance
+	d.deviceStateManager.ResetPreparedDevices(d.podManager.GetPreparedDevices())
+	return nil
+}
+
+// HandleError is called by the kubelet plugin for its background errors, the errors of the
+// prepare and unprepare calls are returned to the kubelet instead. A fatal error stops the driver.
 func (d *Driver) HandleError(ctx context.Context, err error, msg string) {
 	utilruntime.HandleErrorWithContext(ctx, err, msg)
 	if !errors.Is(err, kubeletplugin.ErrRecoverable) && d.cancelCtx != nil {
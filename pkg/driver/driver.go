@@ -22,12 +22,17 @@ import (
 	"maps"
 	"os"
 	"path"
+	"time"
 
+	corev1 "k8s.io/api/core/v1"
 	resourceapi "k8s.io/api/resource/v1"
+	k8stypes "k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
 	coreclientset "k8s.io/client-go/kubernetes"
 	"k8s.io/dynamic-resource-allocation/kubeletplugin"
 	"k8s.io/dynamic-resource-allocation/resourceslice"
 	"k8s.io/klog/v2"
+	"k8s.io/utils/clock"
 
 	"github.com/SchSeba/dra-driver-sriov/pkg/cdi"
 	"github.com/SchSeba/dra-driver-sriov/pkg/consts"
@@ -45,6 +50,10 @@ type Driver struct {
 	cancelCtx          func(error)
 	config             *sriovdratype.Config
 	cdi                *cdi.Handler
+	// publishDebouncer coalesces the republish requests, nil when the debounce window is zero
+	publishDebouncer *publishDebouncer
+	// nodeCondition reports the driver health on the node, nil when the node condition is not published
+	nodeCondition *nodeConditionReporter
 }
 
 // Start creates a new DRA driver and starts the kubelet plugin and the healthcheck service after publishing
@@ -59,21 +68,30 @@ func Start(ctx context.Context, config *sriovdratype.Config, deviceStateManager
 		cdi:                cdi,
 	}
 
-	helper, err := kubeletplugin.Start(
-		ctx,
-		driver,
+	options := []kubeletplugin.Option{
 		kubeletplugin.KubeClient(config.K8sClient.Interface),
 		kubeletplugin.NodeName(config.Flags.NodeName),
 		kubeletplugin.DriverName(consts.DriverName),
 		kubeletplugin.RegistrarDirectoryPath(config.Flags.KubeletRegistrarDirectoryPath),
 		kubeletplugin.PluginDataDirectoryPath(config.DriverPluginPath()),
-	)
+	}
+	if config.Flags.SeamlessUpgrade {
+		// the sockets are suffixed with the pod UID so the old and new instances register side by side,
+		// their prepare and unprepare calls are serialized through a lock file in the plugin data directory
+		options = append(options, kubeletplugin.RollingUpdate(k8stypes.UID(config.Flags.PodUID)))
+	}
+
+	helper, err := startKubeletPluginWithRetry(ctx, driver, config.Flags.RegistrationRetryTimeout, options...)
 	if err != nil {
 		klog.FromContext(ctx).Error(err, "Failed to start DRA kubelet plugin")
 		return nil, err
 	}
 	driver.helper = helper
 
+	if config.Flags.RepublishDebounceWindow > 0 {
+		driver.publishDebouncer = newPublishDebouncer(ctx, config.Flags.RepublishDebounceWindow, clock.RealClock{}, driver.PublishResources)
+	}
+
 	driver.healthcheck, err = startHealthcheck(ctx, config)
 	if err != nil {
 		return nil, fmt.Errorf("start healthcheck: %w", err)
@@ -83,23 +101,54 @@ func Start(ctx context.Context, config *sriovdratype.Config, deviceStateManager
 	if err = driver.PublishResources(ctx); err != nil {
 		return nil, fmt.Errorf("failed to publish resources: %w", err)
 	}
+	driver.warnIfNoDevices(ctx)
+
+	if config.Flags.PublishNodeCondition {
+		driver.nodeCondition = newNodeConditionReporter(driver.client, config.Flags.NodeName, clock.RealClock{})
+		go wait.UntilWithContext(ctx, driver.refreshNodeCondition, nodeConditionInterval)
+	}
 	return driver, nil
 }
 
+// warnIfNoDevices logs a warning when the discovery found no device, so a misconfigured node publishing
+// an empty resource slice stands out from a healthy one. The driver keeps running and publishes the VFs
+// created later through the rediscovery. It returns true when no device was found.
+func (d *Driver) warnIfNoDevices(ctx context.Context) bool {
+	if len(d.deviceStateManager.GetAllocatableDevices()) > 0 {
+		return false
+	}
+	klog.FromContext(ctx).Info("WARNING: no SR-IOV device discovered on the node, an empty resource slice is published "+
+		"until VFs are discovered. Check the PFs have VFs enabled and are not filtered out",
+		"node", d.config.Flags.NodeName)
+	return true
+}
+
 // Shutdown shuts down the driver
 func (d *Driver) Shutdown(logger klog.Logger) error {
+	if d.publishDebouncer != nil {
+		d.publishDebouncer.stop()
+	}
 	if d.healthcheck != nil {
 		d.healthcheck.Stop(logger)
 	}
+	if d.nodeCondition != nil {
+		// the main context is done at this point, bound the patch on its own
+		ctx, cancel := context.WithTimeout(klog.NewContext(context.Background(), logger), 5*time.Second)
+		if err := d.nodeCondition.report(ctx, corev1.ConditionFalse, consts.ReasonDriverStopped, "the driver is shutting down"); err != nil {
+			logger.Error(err, "Failed to report the driver shutdown on the node condition")
+		}
+		cancel()
+	}
 	d.helper.Stop()
 
-	// remove the socket files
+	// remove the socket files, a new instance can't remove the sockets left by an older one
 	// TODO: this is not needed after https://github.com/kubernetes/kubernetes/pull/133934 is merged
-	err := os.Remove(path.Join(d.config.Flags.KubeletRegistrarDirectoryPath, consts.DriverName+"-reg.sock"))
+	uidPart := socketUIDSuffix(d.config.Flags)
+	err := os.Remove(path.Join(d.config.Flags.KubeletRegistrarDirectoryPath, consts.DriverName+uidPart+"-reg.sock"))
 	if err != nil && !os.IsNotExist(err) {
 		return fmt.Errorf("error removing socket file: %w", err)
 	}
-	err = os.Remove(path.Join(d.config.DriverPluginPath(), "dra.sock"))
+	err = os.Remove(path.Join(d.config.DriverPluginPath(), "dra"+uidPart+".sock"))
 	if err != nil && !os.IsNotExist(err) {
 		return fmt.Errorf("error removing socket file: %w", err)
 	}
@@ -107,26 +156,73 @@ func (d *Driver) Shutdown(logger klog.Logger) error {
 	return nil
 }
 
+// socketUIDSuffix returns the suffix of the registration and DRA socket names, the sockets of the instances
+// running side by side during a seamless upgrade are suffixed with their pod UID
+func socketUIDSuffix(flags *sriovdratype.Flags) string {
+	if !flags.SeamlessUpgrade {
+		return ""
+	}
+	return "-" + flags.PodUID
+}
+
 // PublishResources publishes the devices to the DRA resoruce slice
 func (d *Driver) PublishResources(ctx context.Context) error {
-	devices := make([]resourceapi.Device, 0, len(d.deviceStateManager.GetAllocatableDevices()))
-	for device := range maps.Values(d.deviceStateManager.GetAllocatableDevices()) {
-		devices = append(devices, device)
-	}
-	resources := resourceslice.DriverResources{
-		Pools: map[string]resourceslice.Pool{
-			d.config.Flags.NodeName: {
-				Slices: []resourceslice.Slice{
-					{
-						Devices: devices,
-					},
+	if err := d.helper.PublishResources(ctx, d.driverResources()); err != nil {
+		return err
+	}
+	return nil
+}
+
+// RequestPublish republishes the resources at the end of the debounce window,
+// the requests received within the window result in a single publish.
+// The resources are published right away when debouncing is disabled.
+func (d *Driver) RequestPublish(ctx context.Context) error {
+	if d.publishDebouncer == nil {
+		return d.PublishResources(ctx)
+	}
+	d.publishDebouncer.request()
+	return nil
+}
+
+// driverResources returns the publishable devices in a single slice of the configured pool,
+// or of one pool per NUMA node when the pools are split by NUMA node
+func (d *Driver) driverResources() resourceslice.DriverResources {
+	publishableDevices := d.deviceStateManager.GetPublishableDevices()
+	devicesByPool := map[string][]resourceapi.Device{}
+	for device := range maps.Values(publishableDevices) {
+		poolName := d.config.PoolName()
+		if d.config.Flags.SplitPoolsByNUMA {
+			poolName = numaPoolName(poolName, device)
+		}
+		devicesByPool[poolName] = append(devicesByPool[poolName], device)
+	}
+	// keep publishing an empty pool when there is no device so the stale slices are removed
+	if len(devicesByPool) == 0 {
+		devicesByPool[d.config.PoolName()] = []resourceapi.Device{}
+	}
+
+	pools := make(map[string]resourceslice.Pool, len(devicesByPool))
+	for poolName, devices := range devicesByPool {
+		pools[poolName] = resourceslice.Pool{
+			Slices: []resourceslice.Slice{
+				{
+					Devices:        devices,
+					SharedCounters: devicestate.SharedCounters(devices),
 				},
 			},
-		},
+		}
+	}
+	return resourceslice.DriverResources{
+		Pools: pools,
 	}
+}
 
-	if err := d.helper.PublishResources(ctx, resources); err != nil {
-		return err
+// numaPoolName returns the "<pool>-numaN" pool of the NUMA node of the device, the devices without the NUMA node
+// attribute stay in the base pool. The devices of a PF without NUMA affinity are published on NUMA node 0.
+func numaPoolName(basePoolName string, device resourceapi.Device) string {
+	numaNode := device.Attributes[consts.AttributeNumaNode].IntValue
+	if numaNode == nil {
+		return basePoolName
 	}
-	return nil
+	return fmt.Sprintf("%s-numa%d", basePoolName, *numaNode)
 }
@@ -0,0 +1,50 @@
+package driver_test
+
+import (
+	"io/fs"
+	"testing"
+
+	"github.com/jaypipes/ghw"
+	"github.com/jaypipes/ghw/pkg/pci"
+	"github.com/jaypipes/pcidb"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/SchSeba/dra-driver-sriov/pkg/host"
+	mock_host "github.com/SchSeba/dra-driver-sriov/pkg/host/mock"
+)
+
+func TestDriver(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Driver Suite")
+}
+
+// expectDiscovery sets the host expectations discovering a PF with the two VFs 0000:01:00.1 and 0000:01:00.2
+func expectDiscovery(mockHost *mock_host.MockInterface) {
+	mockHost.EXPECT().PCI().Return(&ghw.PCIInfo{
+		Devices: []*pci.Device{{
+			Address: "0000:01:00.0",
+			Vendor:  &pcidb.Vendor{ID: "8086"},
+			Product: &pcidb.Product{ID: "158b"},
+			Class:   &pcidb.Class{ID: "02"},
+		}},
+	}, nil)
+	mockHost.EXPECT().IsSriovVF("0000:01:00.0").Return(false)
+	mockHost.EXPECT().TryGetInterfaceName("0000:01:00.0").Return("eth0")
+	mockHost.EXPECT().GetNicSriovMode("0000:01:00.0").Return("legacy")
+	mockHost.EXPECT().GetSriovNumVFs("0000:01:00.0").Return(2, nil)
+	mockHost.EXPECT().GetSriovTotalVFs("0000:01:00.0").Return(64, nil)
+	mockHost.EXPECT().GetSriovVFTotalMsix("0000:01:00.0").Return(0, fs.ErrNotExist)
+	mockHost.EXPECT().GetPermanentMacAddress("eth0").Return("aa:bb:cc:dd:ee:01", nil)
+	mockHost.EXPECT().IsLinkUp("eth0").Return(true, nil)
+	mockHost.EXPECT().GetBondMaster("eth0").Return("", nil)
+	mockHost.EXPECT().GetNumaNode("0000:01:00.0").Return("0", nil)
+	mockHost.EXPECT().GetParentPciAddress("0000:01:00.0").Return("0000:00:01.0", nil)
+	mockHost.EXPECT().GetPciRootPort("0000:01:00.0").Return("0000:00:01.0", nil)
+	mockHost.EXPECT().GetEswitchMode("0000:01:00.0").Return("switchdev", nil)
+	mockHost.EXPECT().GetDriverByBusAndDevice("0000:01:00.0").Return("ice", nil)
+	mockHost.EXPECT().GetVFList("0000:01:00.0").Return([]host.VFInfo{
+		{PciAddress: "0000:01:00.1", VFID: 0, DeviceID: "154c"},
+		{PciAddress: "0000:01:00.2", VFID: 1, DeviceID: "154c"},
+	}, nil)
+}
@@ -0,0 +1,460 @@
+package driver_test
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/jaypipes/ghw"
+	"github.com/jaypipes/ghw/pkg/pci"
+	"github.com/jaypipes/pcidb"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"go.uber.org/mock/gomock"
+	corev1 "k8s.io/api/core/v1"
+	resourceapi "k8s.io/api/resource/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/rest"
+	k8stesting "k8s.io/client-go/testing"
+	"k8s.io/dynamic-resource-allocation/kubeletplugin"
+	drapbv1 "k8s.io/kubelet/pkg/apis/dra/v1beta1"
+
+	"github.com/SchSeba/dra-driver-sriov/pkg/cdi"
+	"github.com/SchSeba/dra-driver-sriov/pkg/consts"
+	"github.com/SchSeba/dra-driver-sriov/pkg/devicestate"
+	"github.com/SchSeba/dra-driver-sriov/pkg/driver"
+	"github.com/SchSeba/dra-driver-sriov/pkg/host"
+	mock_host "github.com/SchSeba/dra-driver-sriov/pkg/host/mock"
+	"github.com/SchSeba/dra-driver-sriov/pkg/podmanager"
+	draTypes "github.com/SchSeba/dra-driver-sriov/pkg/types"
+)
+
+var _ = Describe("Driver", func() {
+	var (
+		tempDir            string
+		mockCtrl           *gomock.Controller
+		originalHost       host.Interface
+		config             *draTypes.Config
+		deviceStateManager *devicestate.Manager
+	)
+
+	BeforeEach(func() {
+		var err error
+		tempDir, err = os.MkdirTemp("", "driver-test-*")
+		Expect(err).NotTo(HaveOccurred())
+
+		mockCtrl = gomock.NewController(GinkgoT())
+		mockHost := mock_host.NewMockInterface(mockCtrl)
+		originalHost = host.GetHelpers()
+		host.Helpers = mockHost
+		expectDiscovery(mockHost)
+
+		config = &draTypes.Config{
+			Flags: &draTypes.Flags{
+				NodeName:                    "node1",
+				KubeletPluginsDirectoryPath: tempDir,
+				DefaultInterfacePrefix:      "net",
+			},
+		}
+		cdiHandler, err := cdi.NewHandler(tempDir, cdi.DefaultVendor)
+		Expect(err).NotTo(HaveOccurred())
+		deviceStateManager, err = devicestate.NewManager(context.Background(), config, cdiHandler)
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		host.Helpers = originalHost
+		mockCtrl.Finish()
+		os.RemoveAll(tempDir)
+	})
+
+	Context("DriverResources", func() {
+		It("should publish the devices in a pool named after the node", func() {
+			resources := driver.NewTestDriver(config, nil, deviceStateManager, nil).DriverResources()
+
+			Expect(resources.Pools).To(HaveLen(1))
+			Expect(resources.Pools).To(HaveKey("node1"))
+			Expect(resources.Pools["node1"].Slices).To(HaveLen(1))
+			Expect(resources.Pools["node1"].Slices[0].Devices).To(HaveLen(2))
+		})
+
+		It("should publish the devices in the overridden pool", func() {
+			config.Flags.PoolName = "sriov-pool"
+
+			resources := driver.NewTestDriver(config, nil, deviceStateManager, nil).DriverResources()
+
+			Expect(resources.Pools).To(HaveLen(1))
+			Expect(resources.Pools).To(HaveKey("sriov-pool"))
+			Expect(resources.Pools["sriov-pool"].Slices[0].Devices).To(HaveLen(2))
+		})
+
+		It("should publish the shared counters of the VF groups in the slice of their devices", func() {
+			mockHost := mock_host.NewMockInterface(mockCtrl)
+			host.Helpers = mockHost
+			expectDiscovery(mockHost)
+			config.Flags.AdvertiseVFGroups = true
+			cdiHandler, err := cdi.NewHandler(tempDir, cdi.DefaultVendor)
+			Expect(err).NotTo(HaveOccurred())
+			groupDeviceStateManager, err := devicestate.NewManager(context.Background(), config, cdiHandler)
+			Expect(err).NotTo(HaveOccurred())
+
+			resources := driver.NewTestDriver(config, nil, groupDeviceStateManager, nil).DriverResources()
+
+			slice := resources.Pools["node1"].Slices[0]
+			Expect(slice.Devices).To(HaveLen(3))
+			Expect(slice.SharedCounters).To(HaveLen(1))
+			Expect(slice.SharedCounters[0].Name).To(Equal("eth0-vfs"))
+			Expect(slice.SharedCounters[0].Counters["vfs"].Value).To(BeComparableTo(resource.MustParse("2")))
+		})
+
+		It("should not publish the shared counters without VF groups", func() {
+			resources := driver.NewTestDriver(config, nil, deviceStateManager, nil).DriverResources()
+			Expect(resources.Pools["node1"].Slices[0].SharedCounters).To(BeEmpty())
+		})
+
+		It("should not publish the devices of a drained PF", func() {
+			Expect(deviceStateManager.SetDrainedPFs(context.Background(), []string{"eth0"})).To(Succeed())
+
+			resources := driver.NewTestDriver(config, nil, deviceStateManager, nil).DriverResources()
+			Expect(resources.Pools["node1"].Slices[0].Devices).To(BeEmpty())
+			Expect(deviceStateManager.GetAllocatableDevices()).To(HaveLen(2))
+		})
+
+		It("should publish one pool per NUMA node when the pools are split by NUMA node", func() {
+			// rediscover two PFs on NUMA node 0 and NUMA node 1
+			mockHost := mock_host.NewMockInterface(mockCtrl)
+			host.Helpers = mockHost
+			pfs := []struct{ address, name, numaNode, vfAddress string }{
+				{"0000:01:00.0", "eth0", "0", "0000:01:00.2"},
+				{"0000:81:00.0", "eth1", "1", "0000:81:00.2"},
+			}
+			pciDevices := []*pci.Device{}
+			for _, pf := range pfs {
+				pciDevices = append(pciDevices, &pci.Device{
+					Address: pf.address,
+					Vendor:  &pcidb.Vendor{ID: "8086"},
+					Product: &pcidb.Product{ID: "158b"},
+					Class:   &pcidb.Class{ID: "02"},
+				})
+				mockHost.EXPECT().IsSriovVF(pf.address).Return(false)
+				mockHost.EXPECT().TryGetInterfaceName(pf.address).Return(pf.name)
+				mockHost.EXPECT().GetNicSriovMode(pf.address).Return("legacy")
+				mockHost.EXPECT().GetSriovNumVFs(pf.address).Return(1, nil)
+				mockHost.EXPECT().GetSriovTotalVFs(pf.address).Return(64, nil)
+				mockHost.EXPECT().GetSriovVFTotalMsix(pf.address).Return(0, fs.ErrNotExist)
+				mockHost.EXPECT().GetPermanentMacAddress(pf.name).Return("aa:bb:cc:dd:ee:01", nil)
+				mockHost.EXPECT().IsLinkUp(pf.name).Return(true, nil)
+				mockHost.EXPECT().GetBondMaster(pf.name).Return("", nil)
+				mockHost.EXPECT().GetNumaNode(pf.address).Return(pf.numaNode, nil)
+				mockHost.EXPECT().GetParentPciAddress(pf.address).Return("0000:00:01.0", nil)
+				mockHost.EXPECT().GetPciRootPort(pf.address).Return("0000:00:01.0", nil)
+				mockHost.EXPECT().GetEswitchMode(pf.address).Return("switchdev", nil)
+				mockHost.EXPECT().GetDriverByBusAndDevice(pf.address).Return("ice", nil)
+				mockHost.EXPECT().GetVFList(pf.address).Return([]host.VFInfo{
+					{PciAddress: pf.vfAddress, VFID: 0, DeviceID: "154c"},
+				}, nil)
+			}
+			mockHost.EXPECT().PCI().Return(&ghw.PCIInfo{Devices: pciDevices}, nil)
+
+			config.Flags.SplitPoolsByNUMA = true
+			cdiHandler, err := cdi.NewHandler(tempDir, cdi.DefaultVendor)
+			Expect(err).NotTo(HaveOccurred())
+			numaDeviceStateManager, err := devicestate.NewManager(context.Background(), config, cdiHandler)
+			Expect(err).NotTo(HaveOccurred())
+
+			resources := driver.NewTestDriver(config, nil, numaDeviceStateManager, nil).DriverResources()
+
+			poolDevices := func(poolName string) []string {
+				Expect(resources.Pools).To(HaveKey(poolName))
+				names := []string{}
+				for _, device := range resources.Pools[poolName].Slices[0].Devices {
+					names = append(names, device.Name)
+				}
+				return names
+			}
+			Expect(resources.Pools).To(HaveLen(2))
+			Expect(poolDevices("node1-numa0")).To(ConsistOf("0000-01-00-2"))
+			Expect(poolDevices("node1-numa1")).To(ConsistOf("0000-81-00-2"))
+		})
+	})
+
+	Context("seamless upgrade", func() {
+		var (
+			cdiHandler *cdi.Handler
+			claim      *resourceapi.ResourceClaim
+			devices    draTypes.PreparedDevices
+		)
+
+		BeforeEach(func() {
+			config.Flags.SeamlessUpgrade = true
+			config.Flags.PodUID = "new-driver-pod"
+
+			var err error
+			cdiHandler, err = cdi.NewHandler(tempDir, cdi.DefaultVendor)
+			Expect(err).NotTo(HaveOccurred())
+
+			claim = &resourceapi.ResourceClaim{
+				ObjectMeta: metav1.ObjectMeta{Name: "claim", Namespace: "default", UID: "claim-uid"},
+				Status: resourceapi.ResourceClaimStatus{
+					Allocation:  &resourceapi.AllocationResult{},
+					ReservedFor: []resourceapi.ResourceClaimConsumerReference{{Resource: "pods", Name: "pod", UID: "pod-uid"}},
+				},
+			}
+			devices = draTypes.PreparedDevices{{
+				Device: drapbv1.Device{
+					RequestNames: []string{"vf"},
+					PoolName:     "node1",
+					DeviceName:   "0000-01-00-1",
+					CDIDeviceIDs: []string{"k8s.sriovnetwork.openshift.io/vf=claim-uid-0000-01-00-1"},
+				},
+				PciAddress: "0000:01:00.1",
+			}}
+		})
+
+		It("should take over the claims prepared by the old instance without preparing them again", func() {
+			oldPodManager, err := podmanager.NewPodManager(config)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(oldPodManager.Set("pod-uid", claim.UID, devices)).To(Succeed())
+
+			// the new instance reads the checkpoint, no host call is expected to prepare the VF again
+			newPodManager, err := podmanager.NewPodManager(config)
+			Expect(err).NotTo(HaveOccurred())
+			newDriver := driver.NewTestDriver(config, nil, deviceStateManager, newPodManager)
+			newDriver.SetCDI(cdiHandler)
+
+			result, err := newDriver.PrepareResourceClaims(context.Background(), []*resourceapi.ResourceClaim{claim})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result[claim.UID].Err).NotTo(HaveOccurred())
+			Expect(result[claim.UID].Devices).To(ConsistOf(kubeletplugin.Device{
+				Requests:     []string{"vf"},
+				PoolName:     "node1",
+				DeviceName:   "0000-01-00-1",
+				CDIDeviceIDs: []string{"k8s.sriovnetwork.openshift.io/vf=claim-uid-0000-01-00-1"},
+			}))
+		})
+
+		It("should see the claims prepared by the old instance after the new one started", func() {
+			newPodManager, err := podmanager.NewPodManager(config)
+			Expect(err).NotTo(HaveOccurred())
+			newDriver := driver.NewTestDriver(config, nil, deviceStateManager, newPodManager)
+			newDriver.SetCDI(cdiHandler)
+
+			oldPodManager, err := podmanager.NewPodManager(config)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(oldPodManager.Set("pod-uid", claim.UID, devices)).To(Succeed())
+
+			result, err := newDriver.PrepareResourceClaims(context.Background(), []*resourceapi.ResourceClaim{claim})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result[claim.UID].Err).NotTo(HaveOccurred())
+			Expect(result[claim.UID].Devices).To(HaveLen(1))
+		})
+	})
+
+	Context("error aggregation", func() {
+		var (
+			cdiHandler *cdi.Handler
+			podManager *podmanager.PodManager
+			dvr        *driver.Driver
+			canceled   error
+		)
+
+		newClaim := func(name string) *resourceapi.ResourceClaim {
+			return &resourceapi.ResourceClaim{
+				ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default", UID: types.UID(name + "-uid")},
+				Status: resourceapi.ResourceClaimStatus{
+					Allocation:  &resourceapi.AllocationResult{},
+					ReservedFor: []resourceapi.ResourceClaimConsumerReference{{Resource: "pods", Name: "pod", UID: "pod-uid"}},
+				},
+			}
+		}
+
+		BeforeEach(func() {
+			var err error
+			cdiHandler, err = cdi.NewHandler(tempDir, cdi.DefaultVendor)
+			Expect(err).NotTo(HaveOccurred())
+			podManager, err = podmanager.NewPodManager(config)
+			Expect(err).NotTo(HaveOccurred())
+
+			canceled = nil
+			dvr = driver.NewTestDriver(config, nil, deviceStateManager, podManager)
+			dvr.SetCDI(cdiHandler)
+			dvr.SetCancelCtx(func(err error) { canceled = err })
+		})
+
+		It("should store a claim failure in its result and prepare the other claims", func() {
+			prepared := newClaim("prepared")
+			Expect(podManager.Set("pod-uid", prepared.UID, draTypes.PreparedDevices{{
+				Device: drapbv1.Device{
+					RequestNames: []string{"vf"},
+					PoolName:     "node1",
+					DeviceName:   "0000-01-00-1",
+				},
+				PciAddress: "0000:01:00.1",
+			}})).To(Succeed())
+			unallocated := newClaim("unallocated")
+			unallocated.Status.Allocation = nil
+			unreserved := newClaim("unreserved")
+			unreserved.Status.ReservedFor = nil
+
+			result, err := dvr.PrepareResourceClaims(context.Background(), []*resourceapi.ResourceClaim{prepared, unallocated, unreserved})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result).To(HaveLen(3))
+			Expect(result[prepared.UID].Err).NotTo(HaveOccurred())
+			Expect(result[prepared.UID].Devices).To(HaveLen(1))
+			Expect(result[unallocated.UID].Err).To(MatchError(ContainSubstring("claim not yet allocated")))
+			Expect(result[unreserved.UID].Err).To(MatchError(ContainSubstring("no pod info found")))
+			Expect(canceled).NotTo(HaveOccurred())
+		})
+
+		It("should store the failure in the results when no claim could be prepared", func() {
+			unallocated := newClaim("unallocated")
+			unallocated.Status.Allocation = nil
+
+			result, err := dvr.PrepareResourceClaims(context.Background(), []*resourceapi.ResourceClaim{unallocated})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result[unallocated.UID].Err).To(MatchError(ContainSubstring("claim not yet allocated")))
+		})
+
+		It("should return a driver level failure without results", func() {
+			config.Flags.SeamlessUpgrade = true
+			Expect(os.WriteFile(filepath.Join(config.DriverPluginPath(), consts.DriverPluginCheckpointFile), []byte("corrupted"), 0600)).To(Succeed())
+
+			result, err := dvr.PrepareResourceClaims(context.Background(), []*resourceapi.ResourceClaim{newClaim("claim")})
+			Expect(err).To(MatchError(ContainSubstring("error reloading the prepared claims from the checkpoint")))
+			Expect(result).To(BeNil())
+		})
+
+		It("should stop the driver only on a fatal background error", func() {
+			dvr.HandleError(context.Background(), fmt.Errorf("%w: publish failed", kubeletplugin.ErrRecoverable), "recoverable")
+			Expect(canceled).NotTo(HaveOccurred())
+
+			dvr.HandleError(context.Background(), fmt.Errorf("server failed"), "fatal")
+			Expect(canceled).To(MatchError(ContainSubstring("server failed")))
+		})
+	})
+
+	Context("reserved pod", func() {
+		var (
+			podManager *podmanager.PodManager
+			claim      *resourceapi.ResourceClaim
+		)
+
+		BeforeEach(func() {
+			var err error
+			podManager, err = podmanager.NewPodManager(config)
+			Expect(err).NotTo(HaveOccurred())
+
+			claim = &resourceapi.ResourceClaim{
+				ObjectMeta: metav1.ObjectMeta{Name: "claim", Namespace: "default", UID: "claim-uid"},
+				Status: resourceapi.ResourceClaimStatus{
+					Allocation: &resourceapi.AllocationResult{
+						Devices: resourceapi.DeviceAllocationResult{
+							Results: []resourceapi.DeviceRequestAllocationResult{{
+								Request: "vf", Driver: consts.DriverName, Pool: "node1", Device: "0000-01-00-1",
+							}},
+						},
+					},
+					ReservedFor: []resourceapi.ResourceClaimConsumerReference{{Resource: "pods", Name: "pod", UID: "pod-uid"}},
+				},
+			}
+		})
+
+		It("should not prepare the claim of a deleted pod", func() {
+			dvr := driver.NewTestDriver(config, fake.NewSimpleClientset(), deviceStateManager, podManager)
+
+			result, err := dvr.PrepareResourceClaims(context.Background(), []*resourceapi.ResourceClaim{claim})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result[claim.UID].Err).To(MatchError("pod default/pod (pod-uid) the claim default/claim is reserved for no longer exists"))
+			Expect(podManager.GetPodUIDs()).To(BeEmpty())
+		})
+
+		It("should not prepare the claim of a pod recreated with the same name", func() {
+			dvr := driver.NewTestDriver(config, fake.NewSimpleClientset(&corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Name: "pod", Namespace: "default", UID: "new-pod-uid"},
+			}), deviceStateManager, podManager)
+
+			result, err := dvr.PrepareResourceClaims(context.Background(), []*resourceapi.ResourceClaim{claim})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result[claim.UID].Err).To(MatchError(ContainSubstring("no longer exists")))
+			Expect(podManager.GetPodUIDs()).To(BeEmpty())
+		})
+
+		It("should report the prepare failure on the devices status of the claim", func() {
+			clientset := fake.NewSimpleClientset(claim.DeepCopy())
+			dvr := driver.NewTestDriver(config, clientset, deviceStateManager, podManager)
+
+			result, err := dvr.PrepareResourceClaims(context.Background(), []*resourceapi.ResourceClaim{claim})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result[claim.UID].Err).To(HaveOccurred())
+
+			updated, err := clientset.ResourceV1().ResourceClaims("default").Get(context.Background(), "claim", metav1.GetOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(updated.Status.Devices).To(HaveLen(1))
+			Expect(updated.Status.Devices[0].Driver).To(Equal(consts.DriverName))
+			Expect(updated.Status.Devices[0].Pool).To(Equal("node1"))
+			Expect(updated.Status.Devices[0].Device).To(Equal("0000-01-00-1"))
+			condition := meta.FindStatusCondition(updated.Status.Devices[0].Conditions, consts.ConditionTypePrepared)
+			Expect(condition).NotTo(BeNil())
+			Expect(condition.Status).To(Equal(metav1.ConditionFalse))
+			Expect(condition.Reason).To(Equal(consts.ReasonPrepareFailed))
+			Expect(condition.Message).To(Equal("pod default/pod (pod-uid) the claim default/claim is reserved for no longer exists"))
+		})
+
+		// the claim has no config, the prepare goes on with the devices and fails there
+		It("should prepare the claim of an existing pod", func() {
+			dvr := driver.NewTestDriver(config, fake.NewSimpleClientset(&corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Name: "pod", Namespace: "default", UID: "pod-uid"},
+			}), deviceStateManager, podManager)
+
+			result, err := dvr.PrepareResourceClaims(context.Background(), []*resourceapi.ResourceClaim{claim})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result[claim.UID].Err).To(MatchError(ContainSubstring("error preparing devices for claim claim-uid")))
+		})
+
+		It("should prepare the claim when the pod can't be read in time", func() {
+			DeferCleanup(driver.SetReservedPodCheckTimeout(100 * time.Millisecond))
+			// the API server never answers the read of the pod, the request only ends with its context
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if strings.HasSuffix(r.URL.Path, "/pods/pod") {
+					<-r.Context().Done()
+					return
+				}
+				http.NotFound(w, r)
+			}))
+			DeferCleanup(server.Close)
+			clientset, err := kubernetes.NewForConfig(&rest.Config{Host: server.URL})
+			Expect(err).NotTo(HaveOccurred())
+			dvr := driver.NewTestDriver(config, clientset, deviceStateManager, podManager)
+
+			start := time.Now()
+			result, err := dvr.PrepareResourceClaims(context.Background(), []*resourceapi.ResourceClaim{claim})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(time.Since(start)).To(BeNumerically("<", 5*time.Second))
+			Expect(result[claim.UID].Err).To(MatchError(ContainSubstring("error preparing devices for claim claim-uid")))
+		})
+
+		It("should prepare the claim when the pod can't be read", func() {
+			clientset := fake.NewSimpleClientset()
+			clientset.PrependReactor("get", "pods", func(k8stesting.Action) (bool, runtime.Object, error) {
+				return true, nil, fmt.Errorf("connection refused")
+			})
+			dvr := driver.NewTestDriver(config, clientset, deviceStateManager, podManager)
+
+			result, err := dvr.PrepareResourceClaims(context.Background(), []*resourceapi.ResourceClaim{claim})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result[claim.UID].Err).To(MatchError(ContainSubstring("error preparing devices for claim claim-uid")))
+		})
+	})
+})
@@ -0,0 +1,129 @@
+package driver
+
+import (
+	"context"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/wait"
+	coreclientset "k8s.io/client-go/kubernetes"
+	"k8s.io/dynamic-resource-allocation/kubeletplugin"
+	"k8s.io/dynamic-resource-allocation/resourceslice"
+	drapb "k8s.io/kubelet/pkg/apis/dra/v1beta1"
+	registerapi "k8s.io/kubelet/pkg/apis/pluginregistration/v1"
+	"k8s.io/utils/clock"
+
+	"github.com/SchSeba/dra-driver-sriov/pkg/cdi"
+	"github.com/SchSeba/dra-driver-sriov/pkg/devicestate"
+	"github.com/SchSeba/dra-driver-sriov/pkg/podmanager"
+	sriovdratype "github.com/SchSeba/dra-driver-sriov/pkg/types"
+)
+
+// NewTestDriver returns a Driver without the kubelet plugin and the healthcheck service for tests.
+func NewTestDriver(config *sriovdratype.Config, client coreclientset.Interface, deviceStateManager *devicestate.Manager, podManager *podmanager.PodManager) *Driver {
+	return &Driver{
+		client:             client,
+		config:             config,
+		deviceStateManager: deviceStateManager,
+		podManager:         podManager,
+	}
+}
+
+// SetCDI sets the CDI handler writing the pod spec files of the prepared claims.
+func (d *Driver) SetCDI(cdiHandler *cdi.Handler) {
+	d.cdi = cdiHandler
+}
+
+// SetCancelCtx sets the function canceling the main context on a fatal error.
+func (d *Driver) SetCancelCtx(cancelCtx func(error)) {
+	d.cancelCtx = cancelCtx
+}
+
+// WarnIfNoDevices exposes warnIfNoDevices for tests.
+func (d *Driver) WarnIfNoDevices(ctx context.Context) bool {
+	return d.warnIfNoDevices(ctx)
+}
+
+// NewTestHealthcheck returns a Healthcheck calling the given clients instead of the kubelet plugin sockets.
+func NewTestHealthcheck(regClient registerapi.RegistrationClient, draClient drapb.DRAPluginClient) *Healthcheck {
+	return &Healthcheck{
+		regClient: regClient,
+		draClient: draClient,
+	}
+}
+
+// StartHealthcheck exposes startHealthcheck for tests.
+func StartHealthcheck(ctx context.Context, config *sriovdratype.Config) (*Healthcheck, error) {
+	return startHealthcheck(ctx, config)
+}
+
+// Addr returns the address the healthcheck service listens on.
+func (h *Healthcheck) Addr() string {
+	return h.addr.String()
+}
+
+// DriverResources returns the resources published by PublishResources.
+func (d *Driver) DriverResources() resourceslice.DriverResources {
+	return d.driverResources()
+}
+
+// PublishDebouncer exposes the publish debouncer for tests.
+type PublishDebouncer struct {
+	debouncer *publishDebouncer
+}
+
+// NewTestPublishDebouncer returns a publish debouncer running on the given clock.
+func NewTestPublishDebouncer(ctx context.Context, window time.Duration, clock clock.WithDelayedExecution, publish func(context.Context) error) *PublishDebouncer {
+	return &PublishDebouncer{debouncer: newPublishDebouncer(ctx, window, clock, publish)}
+}
+
+// Request requests a publish.
+func (p *PublishDebouncer) Request() {
+	p.debouncer.request()
+}
+
+// Stop cancels the pending publish.
+func (p *PublishDebouncer) Stop() {
+	p.debouncer.stop()
+}
+
+// SetStartKubeletPlugin replaces the kubelet plugin start and returns a function restoring it.
+func SetStartKubeletPlugin(start func(context.Context, kubeletplugin.DRAPlugin, ...kubeletplugin.Option) (*kubeletplugin.Helper, error)) func() {
+	original := startKubeletPlugin
+	startKubeletPlugin = start
+	return func() {
+		startKubeletPlugin = original
+	}
+}
+
+// SetReservedPodCheckTimeout replaces the timeout of the reserved pod check and returns a function restoring it.
+func SetReservedPodCheckTimeout(timeout time.Duration) func() {
+	original := reservedPodCheckTimeout
+	reservedPodCheckTimeout = timeout
+	return func() {
+		reservedPodCheckTimeout = original
+	}
+}
+
+// SetRegistrationRetryBackoff replaces the backoff of the kubelet plugin start attempts and returns a function restoring it.
+func SetRegistrationRetryBackoff(backoff wait.Backoff) func() {
+	original := registrationRetryBackoff
+	registrationRetryBackoff = backoff
+	return func() {
+		registrationRetryBackoff = original
+	}
+}
+
+// StartKubeletPluginWithRetry exposes startKubeletPluginWithRetry.
+func StartKubeletPluginWithRetry(ctx context.Context, plugin kubeletplugin.DRAPlugin, retryTimeout time.Duration) (*kubeletplugin.Helper, error) {
+	return startKubeletPluginWithRetry(ctx, plugin, retryTimeout)
+}
+
+// EnableNodeCondition makes the driver report its health on the node condition with the given clock.
+func (d *Driver) EnableNodeCondition(clk clock.Clock) {
+	d.nodeCondition = newNodeConditionReporter(d.client, d.config.Flags.NodeName, clk)
+}
+
+// RefreshNodeCondition exposes refreshNodeCondition.
+func (d *Driver) RefreshNodeCondition(ctx context.Context) {
+	d.refreshNodeCondition(ctx)
+}
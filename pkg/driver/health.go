@@ -43,6 +43,8 @@ type Healthcheck struct {
 
 	server *grpc.Server
 	wg     sync.WaitGroup
+	// addr is the address the healthcheck service listens on
+	addr net.Addr
 
 	regClient registerapi.RegistrationClient
 	draClient drapb.DRAPluginClient
@@ -56,17 +58,16 @@ func startHealthcheck(ctx context.Context, config *types.Config) (*Healthcheck,
 		return nil, nil
 	}
 
-	addr := net.JoinHostPort("", strconv.Itoa(port))
+	addr := net.JoinHostPort(config.Flags.HealthcheckBindAddress, strconv.Itoa(port))
 	lis, err := net.Listen("tcp", addr)
 	if err != nil {
 		return nil, fmt.Errorf("failed to listen for healthcheck service at %s: %w", addr, err)
 	}
 
+	uidPart := socketUIDSuffix(config.Flags)
 	regSockPath := (&url.URL{
 		Scheme: "unix",
-		// TODO: this needs to adapt when seamless upgrades
-		// are enabled and the filename includes a uid.
-		Path: path.Join(config.Flags.KubeletRegistrarDirectoryPath, consts.DriverName+"-reg.sock"),
+		Path:   path.Join(config.Flags.KubeletRegistrarDirectoryPath, consts.DriverName+uidPart+"-reg.sock"),
 	}).String()
 	log.Info("connecting to registration socket", "path", regSockPath)
 	regConn, err := grpc.NewClient(
@@ -79,7 +80,7 @@ func startHealthcheck(ctx context.Context, config *types.Config) (*Healthcheck,
 
 	draSockPath := (&url.URL{
 		Scheme: "unix",
-		Path:   path.Join(config.DriverPluginPath(), "dra.sock"),
+		Path:   path.Join(config.DriverPluginPath(), "dra"+uidPart+".sock"),
 	}).String()
 	log.Info("connecting to DRA socket", "path", draSockPath)
 	draConn, err := grpc.NewClient(
@@ -93,6 +94,7 @@ func startHealthcheck(ctx context.Context, config *types.Config) (*Healthcheck,
 	server := grpc.NewServer()
 	healthcheck := &Healthcheck{
 		server:    server,
+		addr:      lis.Addr(),
 		regClient: registerapi.NewRegistrationClient(regConn),
 		draClient: drapb.NewDRAPluginClient(draConn),
 	}
@@ -119,10 +121,12 @@ func (h *Healthcheck) Stop(logger klog.Logger) {
 }
 
 // Check implements [grpc_health_v1.HealthServer].
+// The readiness service keeps serving while no device is discovered on the node, so the pods of the nodes
+// without SR-IOV don't stall the rollouts. The empty discovery is reported by a warning and the node condition.
 func (h *Healthcheck) Check(ctx context.Context, req *grpc_health_v1.HealthCheckRequest) (*grpc_health_v1.HealthCheckResponse, error) {
 	log := klog.FromContext(ctx)
 
-	knownServices := map[string]struct{}{"": {}, "liveness": {}}
+	knownServices := map[string]struct{}{"": {}, "liveness": {}, "readiness": {}}
 	if _, known := knownServices[req.GetService()]; !known {
 		return nil, status.Error(codes.NotFound, "unknown service")
 	}
@@ -0,0 +1,200 @@
+package driver_test
+
+import (
+	"context"
+	"net"
+	"os"
+
+	"github.com/jaypipes/ghw"
+	"github.com/jaypipes/ghw/pkg/pci"
+	"github.com/jaypipes/pcidb"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"go.uber.org/mock/gomock"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health/grpc_health_v1"
+	"k8s.io/klog/v2"
+	"k8s.io/klog/v2/ktesting"
+	drapb "k8s.io/kubelet/pkg/apis/dra/v1beta1"
+	registerapi "k8s.io/kubelet/pkg/apis/pluginregistration/v1"
+
+	"github.com/SchSeba/dra-driver-sriov/pkg/cdi"
+	"github.com/SchSeba/dra-driver-sriov/pkg/consts"
+	"github.com/SchSeba/dra-driver-sriov/pkg/devicestate"
+	"github.com/SchSeba/dra-driver-sriov/pkg/driver"
+	"github.com/SchSeba/dra-driver-sriov/pkg/host"
+	mock_host "github.com/SchSeba/dra-driver-sriov/pkg/host/mock"
+	draTypes "github.com/SchSeba/dra-driver-sriov/pkg/types"
+)
+
+// fakeRegistrationClient answers the GetInfo calls of the healthcheck
+type fakeRegistrationClient struct {
+	registerapi.RegistrationClient
+}
+
+func (f *fakeRegistrationClient) GetInfo(context.Context, *registerapi.InfoRequest, ...grpc.CallOption) (*registerapi.PluginInfo, error) {
+	return &registerapi.PluginInfo{}, nil
+}
+
+// fakeDRAPluginClient answers the NodePrepareResources calls of the healthcheck
+type fakeDRAPluginClient struct {
+	drapb.DRAPluginClient
+}
+
+func (f *fakeDRAPluginClient) NodePrepareResources(context.Context, *drapb.NodePrepareResourcesRequest, ...grpc.CallOption) (*drapb.NodePrepareResourcesResponse, error) {
+	return &drapb.NodePrepareResourcesResponse{}, nil
+}
+
+var _ = Describe("Healthcheck", func() {
+	var (
+		tempDir      string
+		mockCtrl     *gomock.Controller
+		mockHost     *mock_host.MockInterface
+		originalHost host.Interface
+		config       *draTypes.Config
+	)
+
+	BeforeEach(func() {
+		var err error
+		tempDir, err = os.MkdirTemp("", "health-test-*")
+		Expect(err).NotTo(HaveOccurred())
+
+		mockCtrl = gomock.NewController(GinkgoT())
+		mockHost = mock_host.NewMockInterface(mockCtrl)
+		originalHost = host.GetHelpers()
+		host.Helpers = mockHost
+
+		config = &draTypes.Config{
+			Flags: &draTypes.Flags{
+				NodeName:                    "node1",
+				KubeletPluginsDirectoryPath: tempDir,
+				DefaultInterfacePrefix:      "net",
+			},
+		}
+	})
+
+	AfterEach(func() {
+		host.Helpers = originalHost
+		mockCtrl.Finish()
+		os.RemoveAll(tempDir)
+	})
+
+	newDeviceStateManager := func() *devicestate.Manager {
+		cdiHandler, err := cdi.NewHandler(tempDir, cdi.DefaultVendor)
+		Expect(err).NotTo(HaveOccurred())
+		deviceStateManager, err := devicestate.NewManager(context.Background(), config, cdiHandler)
+		Expect(err).NotTo(HaveOccurred())
+		return deviceStateManager
+	}
+
+	check := func(service string) grpc_health_v1.HealthCheckResponse_ServingStatus {
+		healthcheck := driver.NewTestHealthcheck(&fakeRegistrationClient{}, &fakeDRAPluginClient{})
+		response, err := healthcheck.Check(context.Background(), &grpc_health_v1.HealthCheckRequest{Service: service})
+		Expect(err).NotTo(HaveOccurred())
+		return response.GetStatus()
+	}
+
+	Context("bind address", func() {
+		It("should listen on the configured bind address", func() {
+			config.Flags.HealthcheckPort = 0
+			config.Flags.HealthcheckBindAddress = "127.0.0.1"
+
+			healthcheck, err := driver.StartHealthcheck(context.Background(), config)
+			Expect(err).NotTo(HaveOccurred())
+			DeferCleanup(healthcheck.Stop, klog.Background())
+
+			host, _, err := net.SplitHostPort(healthcheck.Addr())
+			Expect(err).NotTo(HaveOccurred())
+			Expect(host).To(Equal("127.0.0.1"))
+		})
+
+		It("should listen on all the interfaces without a bind address", func() {
+			config.Flags.HealthcheckPort = 0
+
+			healthcheck, err := driver.StartHealthcheck(context.Background(), config)
+			Expect(err).NotTo(HaveOccurred())
+			DeferCleanup(healthcheck.Stop, klog.Background())
+
+			host, _, err := net.SplitHostPort(healthcheck.Addr())
+			Expect(err).NotTo(HaveOccurred())
+			Expect(net.ParseIP(host).IsUnspecified()).To(BeTrue())
+		})
+	})
+
+	Context("socket paths", func() {
+		startHealthcheckLogs := func() string {
+			logger := ktesting.NewLogger(ktesting.NopTL{}, ktesting.NewConfig(ktesting.BufferLogs(true)))
+			config.Flags.HealthcheckPort = 0
+			config.Flags.HealthcheckBindAddress = "127.0.0.1"
+			config.Flags.KubeletRegistrarDirectoryPath = tempDir
+
+			healthcheck, err := driver.StartHealthcheck(klog.NewContext(context.Background(), logger), config)
+			Expect(err).NotTo(HaveOccurred())
+			DeferCleanup(healthcheck.Stop, klog.Background())
+			return logger.GetSink().(ktesting.Underlier).GetBuffer().String()
+		}
+
+		It("should check the plugin sockets", func() {
+			logs := startHealthcheckLogs()
+			Expect(logs).To(ContainSubstring(`path="unix://` + tempDir + "/" + consts.DriverName + `-reg.sock"`))
+			Expect(logs).To(ContainSubstring(`/dra.sock"`))
+		})
+
+		It("should check the sockets suffixed with the pod UID during a seamless upgrade", func() {
+			config.Flags.SeamlessUpgrade = true
+			config.Flags.PodUID = "driver-pod-uid"
+
+			logs := startHealthcheckLogs()
+			Expect(logs).To(ContainSubstring(`path="unix://` + tempDir + "/" + consts.DriverName + `-driver-pod-uid-reg.sock"`))
+			Expect(logs).To(ContainSubstring(`/dra-driver-pod-uid.sock"`))
+		})
+	})
+
+	Context("without devices discovered", func() {
+		var deviceStateManager *devicestate.Manager
+
+		BeforeEach(func() {
+			// a node with a display controller only
+			mockHost.EXPECT().PCI().Return(&ghw.PCIInfo{Devices: []*pci.Device{{
+				Address: "0000:00:02.0",
+				Class:   &pcidb.Class{ID: "03"},
+			}}}, nil)
+			deviceStateManager = newDeviceStateManager()
+		})
+
+		It("should log a warning", func() {
+			logger := ktesting.NewLogger(ktesting.NopTL{}, ktesting.NewConfig(ktesting.BufferLogs(true)))
+			dvr := driver.NewTestDriver(config, nil, deviceStateManager, nil)
+
+			Expect(dvr.WarnIfNoDevices(klog.NewContext(context.Background(), logger))).To(BeTrue())
+			Expect(logger.GetSink().(ktesting.Underlier).GetBuffer().String()).To(
+				MatchRegexp(`WARNING: no SR-IOV device discovered on the node.*node="node1"`))
+		})
+
+		It("should stay ready and alive", func() {
+			Expect(check("readiness")).To(Equal(grpc_health_v1.HealthCheckResponse_SERVING))
+			Expect(check("liveness")).To(Equal(grpc_health_v1.HealthCheckResponse_SERVING))
+		})
+	})
+
+	Context("with devices discovered", func() {
+		var deviceStateManager *devicestate.Manager
+
+		BeforeEach(func() {
+			expectDiscovery(mockHost)
+			deviceStateManager = newDeviceStateManager()
+		})
+
+		It("should not log a warning", func() {
+			logger := ktesting.NewLogger(ktesting.NopTL{}, ktesting.NewConfig(ktesting.BufferLogs(true)))
+			dvr := driver.NewTestDriver(config, nil, deviceStateManager, nil)
+
+			Expect(dvr.WarnIfNoDevices(klog.NewContext(context.Background(), logger))).To(BeFalse())
+			Expect(logger.GetSink().(ktesting.Underlier).GetBuffer().String()).To(BeEmpty())
+		})
+
+		It("should be ready", func() {
+			Expect(check("readiness")).To(Equal(grpc_health_v1.HealthCheckResponse_SERVING))
+		})
+	})
+})
@@ -0,0 +1,126 @@
+package driver
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	coreclientset "k8s.io/client-go/kubernetes"
+	"k8s.io/klog/v2"
+	"k8s.io/utils/clock"
+
+	"github.com/SchSeba/dra-driver-sriov/pkg/consts"
+	sriovdratype "github.com/SchSeba/dra-driver-sriov/pkg/types"
+)
+
+// nodeConditionInterval is the period the driver health is reported on the node condition
+const nodeConditionInterval = 30 * time.Second
+
+// nodeConditionReporter patches the driver health in a custom condition of the node status,
+// for the cluster tooling watching the node conditions instead of the healthcheck service
+type nodeConditionReporter struct {
+	client   coreclientset.Interface
+	nodeName string
+	clock    clock.Clock
+
+	mu sync.Mutex
+	// reported is the last patched condition, its transition time is kept while the status doesn't change
+	reported *corev1.NodeCondition
+}
+
+func newNodeConditionReporter(client coreclientset.Interface, nodeName string, clock clock.Clock) *nodeConditionReporter {
+	return &nodeConditionReporter{
+		client:   client,
+		nodeName: nodeName,
+		clock:    clock,
+	}
+}
+
+// report patches the driver condition of the node with the given status, the other conditions are left untouched
+func (r *nodeConditionReporter) report(ctx context.Context, status corev1.ConditionStatus, reason, message string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := metav1.NewTime(r.clock.Now())
+	condition := corev1.NodeCondition{
+		Type:               consts.NodeConditionDriverReady,
+		Status:             status,
+		Reason:             reason,
+		Message:            message,
+		LastHeartbeatTime:  now,
+		LastTransitionTime: now,
+	}
+	if r.reported == nil {
+		// keep the transition time of the condition reported by a previous instance
+		r.reported = r.nodeCondition(ctx)
+	}
+	if r.reported != nil && r.reported.Status == status {
+		condition.LastTransitionTime = r.reported.LastTransitionTime
+	}
+
+	// the node conditions are merged by type, the patch only replaces the driver one
+	patch, err := json.Marshal(map[string]any{
+		"status": map[string]any{
+			"conditions": []corev1.NodeCondition{condition},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to marshal the %s node condition: %w", consts.NodeConditionDriverReady, err)
+	}
+	if _, err := r.client.CoreV1().Nodes().PatchStatus(ctx, r.nodeName, patch); err != nil {
+		return fmt.Errorf("failed to patch the %s condition of node %s: %w", consts.NodeConditionDriverReady, r.nodeName, err)
+	}
+	r.reported = &condition
+	return nil
+}
+
+// nodeCondition returns the driver condition currently set on the node, nil when it is not set or the node can't be read
+func (r *nodeConditionReporter) nodeCondition(ctx context.Context) *corev1.NodeCondition {
+	node, err := r.client.CoreV1().Nodes().Get(ctx, r.nodeName, metav1.GetOptions{})
+	if err != nil {
+		klog.FromContext(ctx).V(2).Info("Failed to read the node conditions", "node", r.nodeName, "error", err)
+		return nil
+	}
+	for i := range node.Status.Conditions {
+		if node.Status.Conditions[i].Type == consts.NodeConditionDriverReady {
+			return &node.Status.Conditions[i]
+		}
+	}
+	return nil
+}
+
+// driverHealth returns the status of the driver node condition with its reason and message.
+// The driver is not ready when the last rediscovery failed or no device is discovered on the node.
+func (d *Driver) driverHealth() (corev1.ConditionStatus, string, string) {
+	if err := d.deviceStateManager.DiscoveryError(); err != nil {
+		return corev1.ConditionFalse, consts.ReasonDiscoveryFailed, err.Error()
+	}
+	if len(d.deviceStateManager.GetAllocatableDevices()) == 0 {
+		return corev1.ConditionFalse, consts.ReasonNoDevicesDiscovered, "no SR-IOV device discovered on the node"
+	}
+	return corev1.ConditionTrue, consts.ReasonDriverReady, "the driver is registered and publishes the SR-IOV devices of the node"
+}
+
+// refreshNodeCondition reports the current driver health on the node condition
+func (d *Driver) refreshNodeCondition(ctx context.Context) {
+	status, reason, message := d.driverHealth()
+	if err := d.nodeCondition.report(ctx, status, reason, message); err != nil {
+		klog.FromContext(ctx).Error(err, "Failed to report the driver health on the node condition")
+	}
+}
+
+// ReportStartFailure sets the driver node condition to false when the driver fails to start, so the condition
+// reported by a previous instance doesn't stay true. It does nothing when the node condition is not published.
+func ReportStartFailure(ctx context.Context, config *sriovdratype.Config, reason string, startErr error) {
+	if !config.Flags.PublishNodeCondition {
+		return
+	}
+	reporter := newNodeConditionReporter(config.K8sClient.Interface, config.Flags.NodeName, clock.RealClock{})
+	if err := reporter.report(ctx, corev1.ConditionFalse, reason, startErr.Error()); err != nil {
+		klog.FromContext(ctx).Error(err, "Failed to report the driver start failure on the node condition")
+	}
+}
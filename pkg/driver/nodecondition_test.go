@@ -0,0 +1,178 @@
+package driver_test
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/jaypipes/ghw"
+	"github.com/jaypipes/ghw/pkg/pci"
+	"github.com/jaypipes/pcidb"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"go.uber.org/mock/gomock"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	clocktesting "k8s.io/utils/clock/testing"
+
+	"github.com/SchSeba/dra-driver-sriov/pkg/cdi"
+	"github.com/SchSeba/dra-driver-sriov/pkg/consts"
+	"github.com/SchSeba/dra-driver-sriov/pkg/devicestate"
+	"github.com/SchSeba/dra-driver-sriov/pkg/driver"
+	"github.com/SchSeba/dra-driver-sriov/pkg/flags"
+	"github.com/SchSeba/dra-driver-sriov/pkg/host"
+	mock_host "github.com/SchSeba/dra-driver-sriov/pkg/host/mock"
+	draTypes "github.com/SchSeba/dra-driver-sriov/pkg/types"
+)
+
+var _ = Describe("Node condition", func() {
+	var (
+		ctx          context.Context
+		tempDir      string
+		mockCtrl     *gomock.Controller
+		mockHost     *mock_host.MockInterface
+		originalHost host.Interface
+		config       *draTypes.Config
+		client       *fake.Clientset
+		fakeClock    *clocktesting.FakeClock
+
+		deviceStateManager *devicestate.Manager
+	)
+
+	BeforeEach(func() {
+		var err error
+		ctx = context.Background()
+		tempDir, err = os.MkdirTemp("", "node-condition-test-*")
+		Expect(err).NotTo(HaveOccurred())
+
+		mockCtrl = gomock.NewController(GinkgoT())
+		mockHost = mock_host.NewMockInterface(mockCtrl)
+		originalHost = host.GetHelpers()
+		host.Helpers = mockHost
+
+		client = fake.NewSimpleClientset(&corev1.Node{
+			ObjectMeta: metav1.ObjectMeta{Name: "node1"},
+			Status: corev1.NodeStatus{Conditions: []corev1.NodeCondition{
+				{Type: corev1.NodeReady, Status: corev1.ConditionTrue},
+			}},
+		})
+		fakeClock = clocktesting.NewFakeClock(time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC))
+		config = &draTypes.Config{
+			Flags: &draTypes.Flags{
+				NodeName:                    "node1",
+				KubeletPluginsDirectoryPath: tempDir,
+				DefaultInterfacePrefix:      "net",
+				PublishNodeCondition:        true,
+			},
+			K8sClient: flags.ClientSets{Interface: client},
+		}
+	})
+
+	AfterEach(func() {
+		host.Helpers = originalHost
+		mockCtrl.Finish()
+		os.RemoveAll(tempDir)
+	})
+
+	newDriver := func() *driver.Driver {
+		cdiHandler, err := cdi.NewHandler(tempDir, cdi.DefaultVendor)
+		Expect(err).NotTo(HaveOccurred())
+		deviceStateManager, err = devicestate.NewManager(ctx, config, cdiHandler)
+		Expect(err).NotTo(HaveOccurred())
+		dvr := driver.NewTestDriver(config, client, deviceStateManager, nil)
+		dvr.EnableNodeCondition(fakeClock)
+		return dvr
+	}
+
+	// nodeConditions returns the conditions of the node indexed by type
+	nodeConditions := func() map[corev1.NodeConditionType]corev1.NodeCondition {
+		node, err := client.CoreV1().Nodes().Get(ctx, "node1", metav1.GetOptions{})
+		Expect(err).NotTo(HaveOccurred())
+		conditions := map[corev1.NodeConditionType]corev1.NodeCondition{}
+		for _, condition := range node.Status.Conditions {
+			conditions[condition.Type] = condition
+		}
+		return conditions
+	}
+
+	It("should report the driver ready when devices are discovered", func() {
+		expectDiscovery(mockHost)
+		dvr := newDriver()
+
+		dvr.RefreshNodeCondition(ctx)
+
+		conditions := nodeConditions()
+		Expect(conditions).To(HaveKey(corev1.NodeConditionType(consts.NodeConditionDriverReady)))
+		condition := conditions[consts.NodeConditionDriverReady]
+		Expect(condition.Status).To(Equal(corev1.ConditionTrue))
+		Expect(condition.Reason).To(Equal(consts.ReasonDriverReady))
+		Expect(condition.LastHeartbeatTime.Time).To(BeTemporally("==", fakeClock.Now()))
+		// the other conditions of the node are kept
+		Expect(conditions[corev1.NodeReady].Status).To(Equal(corev1.ConditionTrue))
+	})
+
+	It("should report the driver not ready when no device is discovered", func() {
+		mockHost.EXPECT().PCI().Return(&ghw.PCIInfo{Devices: []*pci.Device{{
+			Address: "0000:00:02.0",
+			Class:   &pcidb.Class{ID: "03"},
+		}}}, nil)
+		dvr := newDriver()
+
+		dvr.RefreshNodeCondition(ctx)
+
+		condition := nodeConditions()[consts.NodeConditionDriverReady]
+		Expect(condition.Status).To(Equal(corev1.ConditionFalse))
+		Expect(condition.Reason).To(Equal(consts.ReasonNoDevicesDiscovered))
+	})
+
+	It("should report the driver not ready when the rediscovery fails and ready again once it succeeds", func() {
+		expectDiscovery(mockHost)
+		dvr := newDriver()
+		dvr.RefreshNodeCondition(ctx)
+		readySince := nodeConditions()[consts.NodeConditionDriverReady].LastTransitionTime
+
+		mockHost.EXPECT().PCI().Return(nil, fmt.Errorf("sysfs not readable")).AnyTimes()
+		mockHost.EXPECT().CheckSysBusPci().Return(nil)
+		Expect(deviceStateManager.Rediscover(ctx)).NotTo(Succeed())
+		fakeClock.Step(time.Minute)
+		dvr.RefreshNodeCondition(ctx)
+
+		condition := nodeConditions()[consts.NodeConditionDriverReady]
+		Expect(condition.Status).To(Equal(corev1.ConditionFalse))
+		Expect(condition.Reason).To(Equal(consts.ReasonDiscoveryFailed))
+		Expect(condition.Message).To(ContainSubstring("sysfs not readable"))
+		Expect(condition.LastTransitionTime.Time).To(BeTemporally(">", readySince.Time))
+	})
+
+	It("should keep the transition time while the status doesn't change", func() {
+		expectDiscovery(mockHost)
+		dvr := newDriver()
+		dvr.RefreshNodeCondition(ctx)
+		readySince := nodeConditions()[consts.NodeConditionDriverReady].LastTransitionTime
+
+		fakeClock.Step(time.Minute)
+		dvr.RefreshNodeCondition(ctx)
+
+		condition := nodeConditions()[consts.NodeConditionDriverReady]
+		Expect(condition.LastTransitionTime.Time).To(BeTemporally("==", readySince.Time))
+		Expect(condition.LastHeartbeatTime.Time).To(BeTemporally("==", fakeClock.Now()))
+	})
+
+	It("should report the start failure", func() {
+		driver.ReportStartFailure(ctx, config, consts.ReasonDiscoveryFailed, fmt.Errorf("error discovering the devices"))
+
+		condition := nodeConditions()[consts.NodeConditionDriverReady]
+		Expect(condition.Status).To(Equal(corev1.ConditionFalse))
+		Expect(condition.Reason).To(Equal(consts.ReasonDiscoveryFailed))
+		Expect(condition.Message).To(Equal("error discovering the devices"))
+	})
+
+	It("should not report the start failure when the node condition is not published", func() {
+		config.Flags.PublishNodeCondition = false
+		driver.ReportStartFailure(ctx, config, consts.ReasonDiscoveryFailed, fmt.Errorf("error discovering the devices"))
+
+		Expect(nodeConditions()).NotTo(HaveKey(corev1.NodeConditionType(consts.NodeConditionDriverReady)))
+	})
+})
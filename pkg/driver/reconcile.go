@@ -0,0 +1,54 @@
+package driver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8stypes "k8s.io/apimachinery/pkg/types"
+	"k8s.io/dynamic-resource-allocation/kubeletplugin"
+	"k8s.io/klog/v2"
+)
+
+// ReconcileOrphanedClaims unprepares the checkpointed claims whose ResourceClaim no longer exists.
+//
+// If the driver stops between Prepare and the pod start the claim can be deleted while it is down,
+// leaving the VF configuration, the CDI spec files and the checkpoint entry behind.
+// A claim recreated with the same name has a different UID and is also considered orphaned.
+func (d *Driver) ReconcileOrphanedClaims(ctx context.Context) error {
+	logger := klog.FromContext(ctx).WithName("ReconcileOrphanedClaims")
+
+	orphanedClaims := map[k8stypes.UID]kubeletplugin.NamespacedObject{}
+	for _, preparedDevicesByClaimID := range d.podManager.Dump() {
+		for claimUID, preparedDevices := range preparedDevicesByClaimID {
+			if len(preparedDevices) == 0 {
+				continue
+			}
+			claim := preparedDevices[0].ClaimNamespacedName
+			if _, found := orphanedClaims[claimUID]; found {
+				continue
+			}
+
+			resourceClaim, err := d.client.ResourceV1().ResourceClaims(claim.Namespace).Get(ctx, claim.Name, metav1.GetOptions{})
+			if err != nil && !apierrors.IsNotFound(err) {
+				return fmt.Errorf("failed to get resource claim %s/%s: %w", claim.Namespace, claim.Name, err)
+			}
+			if err == nil && resourceClaim.UID == claimUID {
+				continue
+			}
+			orphanedClaims[claimUID] = claim
+		}
+	}
+
+	var errs []error
+	for _, claim := range orphanedClaims {
+		logger.Info("Unpreparing orphaned claim", "claim", claim.String(), "uid", claim.UID)
+		if err := d.unprepareResourceClaim(ctx, claim); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
@@ -0,0 +1,149 @@
+package driver_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"go.uber.org/mock/gomock"
+	resourceapi "k8s.io/api/resource/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8stypes "k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/dynamic-resource-allocation/kubeletplugin"
+	drapbv1 "k8s.io/kubelet/pkg/apis/dra/v1beta1"
+
+	configapi "github.com/SchSeba/dra-driver-sriov/pkg/api/virtualfunction/v1alpha1"
+	"github.com/SchSeba/dra-driver-sriov/pkg/cdi"
+	"github.com/SchSeba/dra-driver-sriov/pkg/devicestate"
+	"github.com/SchSeba/dra-driver-sriov/pkg/driver"
+	"github.com/SchSeba/dra-driver-sriov/pkg/host"
+	mock_host "github.com/SchSeba/dra-driver-sriov/pkg/host/mock"
+	"github.com/SchSeba/dra-driver-sriov/pkg/podmanager"
+	draTypes "github.com/SchSeba/dra-driver-sriov/pkg/types"
+)
+
+var _ = Describe("ReconcileOrphanedClaims", func() {
+	var (
+		ctx                context.Context
+		tempDir            string
+		mockCtrl           *gomock.Controller
+		mockHost           *mock_host.MockInterface
+		originalHost       host.Interface
+		config             *draTypes.Config
+		cdiHandler         *cdi.Handler
+		deviceStateManager *devicestate.Manager
+	)
+
+	preparedDevices := func(podUID, claimName string, claimUID k8stypes.UID, deviceName, pciAddress string) draTypes.PreparedDevices {
+		return draTypes.PreparedDevices{
+			{
+				Device: drapbv1.Device{DeviceName: deviceName, PoolName: "node1"},
+				ClaimNamespacedName: kubeletplugin.NamespacedObject{
+					NamespacedName: k8stypes.NamespacedName{Namespace: "default", Name: claimName},
+					UID:            claimUID,
+				},
+				Config:     &configapi.VfConfig{},
+				PciAddress: pciAddress,
+				IfName:     "net1",
+				PodUID:     podUID,
+			},
+		}
+	}
+
+	resourceClaim := func(name string, uid k8stypes.UID) *resourceapi.ResourceClaim {
+		return &resourceapi.ResourceClaim{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: name, UID: uid},
+		}
+	}
+
+	podSpecFiles := func(podUID string) []string {
+		files, err := filepath.Glob(filepath.Join(tempDir, "*"+podUID+"*"))
+		Expect(err).NotTo(HaveOccurred())
+		return files
+	}
+
+	BeforeEach(func() {
+		var err error
+		ctx = context.Background()
+		tempDir, err = os.MkdirTemp("", "driver-test-*")
+		Expect(err).NotTo(HaveOccurred())
+
+		mockCtrl = gomock.NewController(GinkgoT())
+		mockHost = mock_host.NewMockInterface(mockCtrl)
+		originalHost = host.GetHelpers()
+		host.Helpers = mockHost
+
+		expectDiscovery(mockHost)
+
+		config = &draTypes.Config{
+			Flags: &draTypes.Flags{
+				KubeletPluginsDirectoryPath: tempDir,
+				DefaultInterfacePrefix:      "net",
+			},
+		}
+		cdiHandler, err = cdi.NewHandler(tempDir, cdi.DefaultVendor)
+		Expect(err).NotTo(HaveOccurred())
+		deviceStateManager, err = devicestate.NewManager(ctx, config, cdiHandler)
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		host.Helpers = originalHost
+		mockCtrl.Finish()
+		os.RemoveAll(tempDir)
+	})
+
+	It("should unprepare a checkpointed claim whose ResourceClaim was deleted", func() {
+		podManager, err := podmanager.NewPodManager(config)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(podManager.Set("orphan-pod", "orphan-claim-uid",
+			preparedDevices("orphan-pod", "orphan-claim", "orphan-claim-uid", "0000-01-00-1", "0000:01:00.1"))).To(Succeed())
+		Expect(podManager.Set("live-pod", "live-claim-uid",
+			preparedDevices("live-pod", "live-claim", "live-claim-uid", "0000-01-00-2", "0000:01:00.2"))).To(Succeed())
+		Expect(cdiHandler.CreateGlobalPodSpecFile("orphan-pod", []string{"0000:01:00.1"})).To(Succeed())
+		Expect(cdiHandler.CreateGlobalPodSpecFile("live-pod", []string{"0000:01:00.2"})).To(Succeed())
+
+		// simulate a driver restart, the prepared claims are only known from the checkpoint
+		podManager, err = podmanager.NewPodManager(config)
+		Expect(err).NotTo(HaveOccurred())
+
+		clientset := fake.NewSimpleClientset(resourceClaim("live-claim", "live-claim-uid"))
+		mockHost.EXPECT().ResetVF("0000:01:00.1").Return(nil)
+
+		Expect(driver.NewTestDriver(config, clientset, deviceStateManager, podManager).ReconcileOrphanedClaims(ctx)).To(Succeed())
+
+		Expect(podManager.GetPodUIDsByClaimUID("orphan-claim-uid")).To(BeEmpty())
+		Expect(podManager.GetClaimUIDsByPodUID("orphan-pod")).To(BeEmpty())
+		Expect(podSpecFiles("orphan-pod")).To(BeEmpty())
+		Expect(podManager.GetPodUIDsByClaimUID("live-claim-uid")).To(Equal([]k8stypes.UID{"live-pod"}))
+		Expect(podSpecFiles("live-pod")).NotTo(BeEmpty())
+	})
+
+	It("should unprepare a claim recreated with the same name", func() {
+		podManager, err := podmanager.NewPodManager(config)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(podManager.Set("pod", "old-claim-uid",
+			preparedDevices("pod", "claim", "old-claim-uid", "0000-01-00-1", "0000:01:00.1"))).To(Succeed())
+
+		clientset := fake.NewSimpleClientset(resourceClaim("claim", "new-claim-uid"))
+		mockHost.EXPECT().ResetVF("0000:01:00.1").Return(nil)
+
+		Expect(driver.NewTestDriver(config, clientset, deviceStateManager, podManager).ReconcileOrphanedClaims(ctx)).To(Succeed())
+		Expect(podManager.GetPodUIDsByClaimUID("old-claim-uid")).To(BeEmpty())
+	})
+
+	It("should keep all the claims when nothing is orphaned", func() {
+		podManager, err := podmanager.NewPodManager(config)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(podManager.Set("pod", "claim-uid",
+			preparedDevices("pod", "claim", "claim-uid", "0000-01-00-1", "0000:01:00.1"))).To(Succeed())
+
+		clientset := fake.NewSimpleClientset(resourceClaim("claim", "claim-uid"))
+
+		Expect(driver.NewTestDriver(config, clientset, deviceStateManager, podManager).ReconcileOrphanedClaims(ctx)).To(Succeed())
+		Expect(podManager.GetPodUIDsByClaimUID("claim-uid")).To(Equal([]k8stypes.UID{"pod"}))
+	})
+})
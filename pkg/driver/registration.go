@@ -0,0 +1,58 @@
+package driver
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/dynamic-resource-allocation/kubeletplugin"
+	"k8s.io/klog/v2"
+)
+
+// registrationRetryBackoff spaces the kubelet plugin start attempts, the retry timeout bounds them
+var registrationRetryBackoff = wait.Backoff{
+	Duration: 500 * time.Millisecond,
+	Factor:   2.0,
+	Jitter:   0.1,
+	Steps:    math.MaxInt32,
+	Cap:      10 * time.Second,
+}
+
+// startKubeletPlugin starts the kubelet plugin, replaced in tests
+var startKubeletPlugin = kubeletplugin.Start
+
+// startKubeletPluginWithRetry starts the kubelet plugin, retrying the failures until the retry timeout expires.
+// The kubelet plugin registry directory may not be ready yet when the driver starts early on boot, retrying
+// keeps the plugin pod from crash-looping meanwhile. A zero timeout makes a single attempt.
+func startKubeletPluginWithRetry(ctx context.Context, plugin kubeletplugin.DRAPlugin, retryTimeout time.Duration, options ...kubeletplugin.Option) (*kubeletplugin.Helper, error) {
+	if retryTimeout <= 0 {
+		return startKubeletPlugin(ctx, plugin, options...)
+	}
+	logger := klog.FromContext(ctx).WithName("startKubeletPluginWithRetry")
+
+	retryCtx, cancel := context.WithTimeout(ctx, retryTimeout)
+	defer cancel()
+
+	var helper *kubeletplugin.Helper
+	var lastErr error
+	attempts := 0
+	err := wait.ExponentialBackoffWithContext(retryCtx, registrationRetryBackoff, func(context.Context) (bool, error) {
+		attempts++
+		// the plugin lives as long as the driver context, not the retry one
+		helper, lastErr = startKubeletPlugin(ctx, plugin, options...)
+		if lastErr != nil {
+			logger.Info("Failed to start the DRA kubelet plugin, retrying", "attempt", attempts, "error", lastErr.Error())
+			return false, nil
+		}
+		return true, nil
+	})
+	if err != nil {
+		if lastErr == nil {
+			return nil, err
+		}
+		return nil, fmt.Errorf("failed to start the DRA kubelet plugin after %d attempts within %s: %w", attempts, retryTimeout, lastErr)
+	}
+	return helper, nil
+}
@@ -0,0 +1,62 @@
+package driver_test
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/dynamic-resource-allocation/kubeletplugin"
+
+	"github.com/SchSeba/dra-driver-sriov/pkg/driver"
+)
+
+var _ = Describe("Kubelet plugin registration", func() {
+	var (
+		attempts int
+		failures int
+		helper   *kubeletplugin.Helper
+	)
+
+	BeforeEach(func() {
+		attempts = 0
+		failures = 0
+		helper = &kubeletplugin.Helper{}
+		DeferCleanup(driver.SetRegistrationRetryBackoff(wait.Backoff{Duration: time.Millisecond, Factor: 1, Steps: 1000}))
+		DeferCleanup(driver.SetStartKubeletPlugin(func(context.Context, kubeletplugin.DRAPlugin, ...kubeletplugin.Option) (*kubeletplugin.Helper, error) {
+			attempts++
+			if attempts <= failures {
+				return nil, fmt.Errorf("registry directory not ready")
+			}
+			return helper, nil
+		}))
+	})
+
+	It("should retry a transient registration failure until it succeeds", func() {
+		failures = 2
+
+		started, err := driver.StartKubeletPluginWithRetry(context.Background(), nil, time.Minute)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(started).To(BeIdenticalTo(helper))
+		Expect(attempts).To(Equal(3))
+	})
+
+	It("should give up once the retry timeout expired", func() {
+		failures = 1000000
+
+		_, err := driver.StartKubeletPluginWithRetry(context.Background(), nil, 50*time.Millisecond)
+		Expect(err).To(MatchError(ContainSubstring("registry directory not ready")))
+		Expect(err.Error()).To(ContainSubstring("failed to start the DRA kubelet plugin after"))
+		Expect(attempts).To(BeNumerically(">", 1))
+	})
+
+	It("should make a single attempt without a retry timeout", func() {
+		failures = 1
+
+		_, err := driver.StartKubeletPluginWithRetry(context.Background(), nil, 0)
+		Expect(err).To(MatchError(ContainSubstring("registry directory not ready")))
+		Expect(attempts).To(Equal(1))
+	})
+})
@@ -0,0 +1,65 @@
+package driver
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"k8s.io/klog/v2"
+	"k8s.io/utils/clock"
+)
+
+// publishDebouncer coalesces the publish requests received within a window into a single publish,
+// so the change signals firing close together (e.g. a link state change and a resource filter update)
+// don't republish the resource slices several times in quick succession.
+type publishDebouncer struct {
+	// ctx is the driver context used for the deferred publish, the requests may come with a short lived context
+	ctx     context.Context
+	window  time.Duration
+	clock   clock.WithDelayedExecution
+	publish func(context.Context) error
+
+	mu    sync.Mutex
+	timer clock.Timer
+}
+
+func newPublishDebouncer(ctx context.Context, window time.Duration, clock clock.WithDelayedExecution, publish func(context.Context) error) *publishDebouncer {
+	return &publishDebouncer{
+		ctx:     ctx,
+		window:  window,
+		clock:   clock,
+		publish: publish,
+	}
+}
+
+// request schedules a publish at the end of the window, the requests received before it runs are merged into it
+func (p *publishDebouncer) request() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.timer != nil {
+		klog.FromContext(p.ctx).V(3).Info("Publish already pending, coalescing the request")
+		return
+	}
+	p.timer = p.clock.AfterFunc(p.window, p.run)
+}
+
+// run publishes the resources, a request received while publishing schedules a new publish
+func (p *publishDebouncer) run() {
+	p.mu.Lock()
+	p.timer = nil
+	p.mu.Unlock()
+
+	if err := p.publish(p.ctx); err != nil {
+		klog.FromContext(p.ctx).Error(err, "Failed to republish resources")
+	}
+}
+
+// stop cancels the pending publish
+func (p *publishDebouncer) stop() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.timer != nil {
+		p.timer.Stop()
+		p.timer = nil
+	}
+}
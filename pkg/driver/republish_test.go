@@ -0,0 +1,78 @@
+package driver_test
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	clocktesting "k8s.io/utils/clock/testing"
+
+	"github.com/SchSeba/dra-driver-sriov/pkg/driver"
+)
+
+var _ = Describe("PublishDebouncer", func() {
+	const window = 500 * time.Millisecond
+
+	var (
+		fakeClock  *clocktesting.FakeClock
+		published  int
+		publishErr error
+		debouncer  *driver.PublishDebouncer
+	)
+
+	BeforeEach(func() {
+		fakeClock = clocktesting.NewFakeClock(time.Now())
+		published = 0
+		publishErr = nil
+		debouncer = driver.NewTestPublishDebouncer(context.Background(), window, fakeClock, func(context.Context) error {
+			published++
+			return publishErr
+		})
+	})
+
+	It("should publish once for change events fired in quick succession", func() {
+		debouncer.Request()
+		fakeClock.Step(100 * time.Millisecond)
+		debouncer.Request()
+		fakeClock.Step(100 * time.Millisecond)
+		debouncer.Request()
+		Expect(published).To(BeZero())
+
+		fakeClock.Step(window)
+		Expect(published).To(Equal(1))
+		Expect(fakeClock.HasWaiters()).To(BeFalse())
+	})
+
+	It("should publish again for a change after the window", func() {
+		debouncer.Request()
+		fakeClock.Step(window)
+		Expect(published).To(Equal(1))
+
+		debouncer.Request()
+		Expect(published).To(Equal(1))
+		fakeClock.Step(window)
+		Expect(published).To(Equal(2))
+	})
+
+	It("should accept new requests after a failed publish", func() {
+		publishErr = fmt.Errorf("api server unavailable")
+		debouncer.Request()
+		fakeClock.Step(window)
+		Expect(published).To(Equal(1))
+
+		publishErr = nil
+		debouncer.Request()
+		fakeClock.Step(window)
+		Expect(published).To(Equal(2))
+	})
+
+	It("should cancel the pending publish on stop", func() {
+		debouncer.Request()
+		debouncer.Stop()
+
+		fakeClock.Step(window)
+		Expect(published).To(BeZero())
+	})
+})
@@ -0,0 +1,63 @@
+// Package filelock guards the driver plugin data directory so a single driver instance runs on a node.
+package filelock
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+	"time"
+)
+
+// ErrHeld is returned by Acquire when another process holds the lock
+var ErrHeld = errors.New("held by another driver instance")
+
+// Lock is an exclusive flock held on a file
+type Lock struct {
+	file *os.File
+}
+
+// Acquire takes an exclusive lock on the file at path, creating it if needed.
+// It fails right away if another process (or another open of the same file) holds the lock.
+func Acquire(path string) (*Lock, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open lock file %s: %w", path, err)
+	}
+
+	if err := syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		file.Close()
+		if errors.Is(err, syscall.EWOULDBLOCK) {
+			return nil, fmt.Errorf("lock file %s is %w", path, ErrHeld)
+		}
+		return nil, fmt.Errorf("failed to lock file %s: %w", path, err)
+	}
+
+	return &Lock{file: file}, nil
+}
+
+// Wait takes the lock like Acquire, retrying every interval while another process holds it.
+// It returns the context error when the context is done before the lock is released.
+func Wait(ctx context.Context, path string, interval time.Duration) (*Lock, error) {
+	for {
+		lock, err := Acquire(path)
+		if !errors.Is(err, ErrHeld) {
+			return lock, err
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(interval):
+		}
+	}
+}
+
+// Release unlocks and closes the lock file
+func (l *Lock) Release() error {
+	if err := syscall.Flock(int(l.file.Fd()), syscall.LOCK_UN); err != nil {
+		l.file.Close()
+		return fmt.Errorf("failed to unlock file %s: %w", l.file.Name(), err)
+	}
+	return l.file.Close()
+}
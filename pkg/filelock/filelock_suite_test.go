@@ -0,0 +1,13 @@
+package filelock_test
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+func TestFileLock(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "FileLock Suite")
+}
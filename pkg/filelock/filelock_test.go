@@ -0,0 +1,88 @@
+package filelock_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/SchSeba/dra-driver-sriov/pkg/filelock"
+)
+
+var _ = Describe("Lock", func() {
+	var (
+		tempDir  string
+		lockPath string
+	)
+
+	BeforeEach(func() {
+		var err error
+		tempDir, err = os.MkdirTemp("", "filelock-test-*")
+		Expect(err).NotTo(HaveOccurred())
+		lockPath = filepath.Join(tempDir, "driver.lock")
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(tempDir)
+	})
+
+	It("should create the lock file", func() {
+		lock, err := filelock.Acquire(lockPath)
+		Expect(err).NotTo(HaveOccurred())
+		defer lock.Release()
+
+		Expect(lockPath).To(BeARegularFile())
+	})
+
+	It("should fail fast when the lock is already held", func() {
+		lock, err := filelock.Acquire(lockPath)
+		Expect(err).NotTo(HaveOccurred())
+		defer lock.Release()
+
+		start := time.Now()
+		_, err = filelock.Acquire(lockPath)
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).To(ContainSubstring("held by another driver instance"))
+		Expect(time.Since(start)).To(BeNumerically("<", time.Second))
+	})
+
+	It("should allow acquiring the lock again once released", func() {
+		lock, err := filelock.Acquire(lockPath)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(lock.Release()).To(Succeed())
+
+		lock, err = filelock.Acquire(lockPath)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(lock.Release()).To(Succeed())
+	})
+
+	It("should wait for the lock to be released", func() {
+		lock, err := filelock.Acquire(lockPath)
+		Expect(err).NotTo(HaveOccurred())
+		time.AfterFunc(100*time.Millisecond, func() { lock.Release() })
+
+		waited, err := filelock.Wait(context.Background(), lockPath, 10*time.Millisecond)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(waited.Release()).To(Succeed())
+	})
+
+	It("should stop waiting when the context is done", func() {
+		lock, err := filelock.Acquire(lockPath)
+		Expect(err).NotTo(HaveOccurred())
+		defer lock.Release()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		_, err = filelock.Wait(ctx, lockPath, 10*time.Millisecond)
+		Expect(err).To(MatchError(context.DeadlineExceeded))
+	})
+
+	It("should fail when the lock file cannot be created", func() {
+		_, err := filelock.Acquire(filepath.Join(tempDir, "missing", "driver.lock"))
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).To(ContainSubstring("failed to open lock file"))
+	})
+})
@@ -0,0 +1,21 @@
+package host
+
+import (
+	"k8s.io/klog/v2"
+)
+
+// SetSriovnet enables sriovnet and returns a function disabling it.
+func SetSriovnet() func() {
+	EnableSriovnet()
+	return func() {
+		sriovnetEnabled = false
+	}
+}
+
+// SetSysfsTrace enables the sysfs trace with the given logger and returns a function disabling it.
+func SetSysfsTrace(logger klog.Logger) func() {
+	EnableSysfsTrace(logger)
+	return func() {
+		sysfsTraceEnabled = false
+	}
+}
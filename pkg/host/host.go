@@ -4,15 +4,22 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"net"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
 
 	"github.com/jaypipes/ghw"
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netlink/nl"
+	"golang.org/x/sys/unix"
+	"k8s.io/apimachinery/pkg/util/sets"
 	"k8s.io/klog/v2"
+	"k8s.io/utils/cpuset"
 
 	configapi "github.com/SchSeba/dra-driver-sriov/pkg/api/virtualfunction/v1alpha1"
 	"github.com/SchSeba/dra-driver-sriov/pkg/consts"
@@ -22,6 +29,9 @@ var (
 	RootDir = ""
 )
 
+// hostNetworkNamespacePath is the network namespace of the host init process, the driver runs with hostPID
+const hostNetworkNamespacePath = "/proc/1/ns/net"
+
 // Helper functions to build paths respecting RootDir
 
 // buildSysPath constructs a path under /sys with RootDir prefix if set
@@ -65,6 +75,16 @@ type VFInfo struct {
 	DeviceID   string
 }
 
+// VFStats holds the traffic counters of a Virtual Function as reported by its PF
+type VFStats struct {
+	RxPackets uint64
+	TxPackets uint64
+	RxBytes   uint64
+	TxBytes   uint64
+	RxDropped uint64
+	TxDropped uint64
+}
+
 // Interface defines the unified interface for all host system operations.
 // This interface allows for easy mocking in unit tests by implementing mock versions
 // of all the host-related methods.
@@ -74,18 +94,41 @@ type Interface interface {
 	// SR-IOV device utility functions
 	IsSriovVF(pciAddress string) bool
 	IsSriovPF(pciAddress string) bool
+	GetSriovNumVFs(pfPciAddress string) (int, error)
+	GetSriovTotalVFs(pfPciAddress string) (int, error)
+	GetSriovVFTotalMsix(pfPciAddress string) (int, error)
 	GetVFList(pfPciAddress string) ([]VFInfo, error)
+	GetVFIndex(vfPciAddress string) (pfPciAddress string, index int, err error)
+	ResetVF(vfPciAddress string) error
+	VerifyVFReset(vfPciAddress string) error
+	GetVFStats(vfPciAddress string) (*VFStats, error)
+	SetVFMacAddress(vfPciAddress, macAddress string) error
+	SetVFRepresentorMacAddress(vfPciAddress, macAddress string) error
+	SetVFRate(vfPciAddress string, minTxRate, maxTxRate int) error
+	SetVFLinkState(vfPciAddress, linkState string) error
 
 	// PCI device discovery functionality
 	PCI() (*ghw.PCIInfo, error)
+	CheckSysBusPci() error
+	SubscribePCIEvents(ctx context.Context) (<-chan PCIEvent, error)
 
 	// Network interface functions
 	TryGetInterfaceName(pciAddr string) string
 	GetNicSriovMode(pciAddr string) string
+	GetEswitchMode(pciAddr string) (string, error)
+	SetNicSriovMode(pciAddr, mode string) error
+	GetPermanentMacAddress(ifName string) (string, error)
+	IsLinkUp(ifName string) (bool, error)
+	GetDefaultRouteInterfaces() ([]string, error)
+	GetBondMaster(ifName string) (string, error)
+	GetVFRepresentor(pfName string, vfIndex int) (string, error)
 
 	// NUMA and parent device functions
 	GetNumaNode(pciAddress string) (string, error)
+	GetNumaNodeAffinity(pciAddress string) (string, error)
 	GetParentPciAddress(pciAddress string) (string, error)
+	GetPciRootPort(pciAddress string) (string, error)
+	GetNumaNodeCPUs(numaNode string) (cpuset.CPUSet, error)
 
 	// Driver binding operations
 	BindDeviceDriver(pciAddress string, config *configapi.VfConfig) (string, error)
@@ -103,6 +146,10 @@ type Interface interface {
 	// VFIO device functions
 	GetVFIODeviceFile(pciAddress string) (devFileHost, devFileContainer string, err error)
 
+	// Filesystem functions
+	PathExists(path string) bool
+	IsHostNetworkNamespace(netnsPath string) (bool, error)
+
 	// Kernel module management functions
 	IsKernelModuleLoaded(moduleName string) bool
 	LoadKernelModule(moduleName string) error
@@ -112,13 +159,25 @@ type Interface interface {
 
 // Host provides unified host system functionality for SR-IOV, PCI operations, and driver management
 type Host struct {
-	log klog.Logger
+	log     klog.Logger
+	netlink NetlinkLib
+
+	// vfIndexCache maps a PF PCI address to a map of its VF PCI addresses to VF index
+	vfIndexCache   map[string]map[string]int
+	vfIndexCacheMu sync.Mutex
 }
 
 // NewHost creates a new Host instance
 func NewHost() Interface {
+	return NewHostWithNetlink(NewNetlinkLib())
+}
+
+// NewHostWithNetlink creates a new Host instance using the given netlink implementation
+func NewHostWithNetlink(netlinkLib NetlinkLib) Interface {
 	return &Host{
-		log: klog.FromContext(context.Background()).WithName("Host"),
+		log:          klog.FromContext(context.Background()).WithName("Host"),
+		netlink:      netlinkLib,
+		vfIndexCache: map[string]map[string]int{},
 	}
 }
 
@@ -147,7 +206,7 @@ func GetHelpers() Interface {
 func (h *Host) IsSriovVF(pciAddress string) bool {
 	// Check if physfn symlink exists - this indicates it's a VF
 	physfnPath := buildSysBusPciPath(pciAddress, "physfn")
-	if _, err := os.Lstat(physfnPath); err == nil {
+	if _, err := lstat(physfnPath); err == nil {
 		return true
 	}
 	return false
@@ -157,62 +216,383 @@ func (h *Host) IsSriovVF(pciAddress string) bool {
 func (h *Host) IsSriovPF(pciAddress string) bool {
 	// Check if virtfn0 symlink exists - this indicates it's a PF with VFs
 	virtfnPath := buildSysBusPciPath(pciAddress, "virtfn0")
-	if _, err := os.Lstat(virtfnPath); err == nil {
+	if _, err := lstat(virtfnPath); err == nil {
 		return true
 	}
 	return false
 }
 
+// GetSriovNumVFs returns the number of VFs currently enabled on the PF
+func (h *Host) GetSriovNumVFs(pfPciAddress string) (int, error) {
+	return readSysfsInt(buildSysBusPciPath(pfPciAddress, "sriov_numvfs"))
+}
+
+// GetSriovTotalVFs returns the maximum number of VFs supported by the PF
+func (h *Host) GetSriovTotalVFs(pfPciAddress string) (int, error) {
+	return readSysfsInt(buildSysBusPciPath(pfPciAddress, "sriov_totalvfs"))
+}
+
+// GetSriovVFTotalMsix returns the MSI-X vectors the PF can distribute between its VFs.
+// The file is only exposed by the drivers supporting dynamic MSI-X assignment, callers can check
+// for a missing file with errors.Is(err, fs.ErrNotExist).
+func (h *Host) GetSriovVFTotalMsix(pfPciAddress string) (int, error) {
+	return readSysfsInt(buildSysBusPciPath(pfPciAddress, "sriov_vf_total_msix"))
+}
+
+// readSysfsInt reads a sysfs file holding a single integer value
+func readSysfsInt(path string) (int, error) {
+	content, err := readFile(path)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	value, err := strconv.Atoi(strings.TrimSpace(string(content)))
+	if err != nil {
+		return 0, fmt.Errorf("failed to parse %s: %v", path, err)
+	}
+	return value, nil
+}
+
 // GetVFList returns list of VFs for a given PF with their VF IDs and device IDs
 func (h *Host) GetVFList(pfPciAddress string) ([]VFInfo, error) {
-	var vfList []VFInfo
+	vfIndexes, err := h.refreshVFIndexCache(pfPciAddress)
+	if err != nil {
+		return nil, err
+	}
+
+	vfList := make([]VFInfo, 0, len(vfIndexes))
+	for vfAddr, vfID := range vfIndexes {
+		// Read VF device ID from sysfs
+		deviceIDPath := buildSysBusPciPath(vfAddr, "device")
+		deviceIDBytes, err := readFile(deviceIDPath)
+		vfDeviceID := ""
+		if err != nil {
+			klog.Error(err, "Failed to read VF device ID", "vfAddress", vfAddr, "pfAddress", pfPciAddress)
+		} else {
+			vfDeviceID = strings.TrimSpace(string(deviceIDBytes))
+			// Remove 0x prefix if present
+			vfDeviceID = strings.TrimPrefix(vfDeviceID, "0x")
+		}
+
+		vfList = append(vfList, VFInfo{
+			PciAddress: vfAddr,
+			VFID:       vfID,
+			DeviceID:   vfDeviceID,
+		})
+	}
+
+	sort.Slice(vfList, func(i, j int) bool {
+		return vfList[i].VFID < vfList[j].VFID
+	})
+
+	return vfList, nil
+}
+
+// GetVFIndex returns the parent PF PCI address and the index of the VF relative to its PF
+func (h *Host) GetVFIndex(vfPciAddress string) (string, int, error) {
+	physfnPath := buildSysBusPciPath(vfPciAddress, "physfn")
+	target, err := readlink(physfnPath)
+	if err != nil {
+		return "", -1, fmt.Errorf("failed to find parent PF for VF %s: %v", vfPciAddress, err)
+	}
+	pfPciAddress := filepath.Base(target)
+
+	h.vfIndexCacheMu.Lock()
+	vfIndexes, cached := h.vfIndexCache[pfPciAddress]
+	h.vfIndexCacheMu.Unlock()
+
+	if cached {
+		if index, found := vfIndexes[vfPciAddress]; found {
+			return pfPciAddress, index, nil
+		}
+	}
+
+	// the VF is not in the cache, the VFs of the PF may have been recreated so refresh it
+	vfIndexes, err = h.refreshVFIndexCache(pfPciAddress)
+	if err != nil {
+		return "", -1, err
+	}
+	index, found := vfIndexes[vfPciAddress]
+	if !found {
+		return "", -1, fmt.Errorf("VF %s not found under PF %s", vfPciAddress, pfPciAddress)
+	}
+
+	return pfPciAddress, index, nil
+}
 
+// refreshVFIndexCache walks the virtfn* symlinks of a PF and stores the VF address to index map in the cache
+func (h *Host) refreshVFIndexCache(pfPciAddress string) (map[string]int, error) {
 	pfPath := buildSysBusPciPath(pfPciAddress, "")
-	entries, err := os.ReadDir(pfPath)
+	entries, err := readDir(pfPath)
 	if err != nil {
 		return nil, fmt.Errorf("failed to read PF directory: %v", err)
 	}
 
+	vfIndexes := map[string]int{}
 	for _, entry := range entries {
-		if strings.HasPrefix(entry.Name(), "virtfn") {
-			linkPath := filepath.Join(pfPath, entry.Name())
-			target, err := os.Readlink(linkPath)
-			if err != nil {
-				continue
-			}
+		if !strings.HasPrefix(entry.Name(), "virtfn") {
+			continue
+		}
 
-			// Extract VF ID from directory name (virtfn0 -> 0, virtfn1 -> 1, etc.)
-			vfIDStr := strings.TrimPrefix(entry.Name(), "virtfn")
-			vfID, err := strconv.Atoi(vfIDStr)
-			if err != nil {
-				klog.Error(err, "Failed to parse VF ID", "entry", entry.Name(), "pfAddress", pfPciAddress)
-				continue
-			}
+		linkPath := filepath.Join(pfPath, entry.Name())
+		target, err := readlink(linkPath)
+		if err != nil {
+			continue
+		}
 
-			// Extract PCI address from symlink target
-			vfAddr := filepath.Base(target)
-
-			// Read VF device ID from sysfs
-			deviceIDPath := buildSysBusPciPath(vfAddr, "device")
-			deviceIDBytes, err := os.ReadFile(deviceIDPath)
-			vfDeviceID := ""
-			if err != nil {
-				klog.Error(err, "Failed to read VF device ID", "vfAddress", vfAddr, "pfAddress", pfPciAddress)
-			} else {
-				vfDeviceID = strings.TrimSpace(string(deviceIDBytes))
-				// Remove 0x prefix if present
-				vfDeviceID = strings.TrimPrefix(vfDeviceID, "0x")
-			}
+		// Extract VF ID from directory name (virtfn0 -> 0, virtfn1 -> 1, etc.)
+		vfIDStr := strings.TrimPrefix(entry.Name(), "virtfn")
+		vfID, err := strconv.Atoi(vfIDStr)
+		if err != nil {
+			klog.Error(err, "Failed to parse VF ID", "entry", entry.Name(), "pfAddress", pfPciAddress)
+			continue
+		}
+
+		// Extract PCI address from symlink target
+		vfIndexes[filepath.Base(target)] = vfID
+	}
 
-			vfList = append(vfList, VFInfo{
-				PciAddress: vfAddr,
-				VFID:       vfID,
-				DeviceID:   vfDeviceID,
-			})
+	h.vfIndexCacheMu.Lock()
+	h.vfIndexCache[pfPciAddress] = vfIndexes
+	h.vfIndexCacheMu.Unlock()
+
+	return vfIndexes, nil
+}
+
+// ResetVF clears the administrative MAC address, VLAN and TX rate configured on the PF for the given VF
+// and sets its link state back to auto
+func (h *Host) ResetVF(vfPciAddress string) error {
+	pfLink, vfIndex, err := h.getVFParentLink(vfPciAddress)
+	if err != nil {
+		return err
+	}
+	pfName := pfLink.Attrs().Name
+
+	if err := h.netlink.LinkSetVfHardwareAddr(pfLink, vfIndex, make(net.HardwareAddr, 6)); err != nil {
+		return fmt.Errorf("failed to reset MAC address for VF %d on PF %s: %v", vfIndex, pfName, err)
+	}
+	if err := h.netlink.LinkSetVfVlan(pfLink, vfIndex, 0); err != nil {
+		return fmt.Errorf("failed to reset VLAN for VF %d on PF %s: %v", vfIndex, pfName, err)
+	}
+	if err := h.netlink.LinkSetVfRate(pfLink, vfIndex, 0, 0); err != nil {
+		return fmt.Errorf("failed to reset rate for VF %d on PF %s: %v", vfIndex, pfName, err)
+	}
+	// a driver without the VF link state ndo can't have a link state set by the prepare, nothing to reset then
+	if err := h.netlink.LinkSetVfState(pfLink, vfIndex, netlink.VF_LINK_STATE_AUTO); err != nil {
+		if !errors.Is(err, unix.EOPNOTSUPP) {
+			return fmt.Errorf("failed to reset link state for VF %d on PF %s: %v", vfIndex, pfName, err)
 		}
+		h.log.V(2).Info("ResetVF(): the PF driver doesn't support the VF link state, skipping its reset", "vf", vfPciAddress, "pf", pfName, "vfIndex", vfIndex)
 	}
 
-	return vfList, nil
+	h.log.V(2).Info("ResetVF(): reset VF configuration", "vf", vfPciAddress, "pf", pfName, "vfIndex", vfIndex)
+	return nil
+}
+
+// VerifyVFReset reads back the VF configuration from the PF and checks the MAC address, VLAN,
+// TX rate and link state were reset, as some drivers silently ignore the writes
+func (h *Host) VerifyVFReset(vfPciAddress string) error {
+	pfLink, vfIndex, err := h.getVFParentLink(vfPciAddress)
+	if err != nil {
+		return err
+	}
+	pfName := pfLink.Attrs().Name
+
+	vfInfo, err := getVFInfo(pfLink, vfIndex)
+	if err != nil {
+		return err
+	}
+
+	mismatches := []string{}
+	if !isZeroMac(vfInfo.Mac) {
+		mismatches = append(mismatches, fmt.Sprintf("MAC address %s", vfInfo.Mac))
+	}
+	if vfInfo.Vlan != 0 {
+		mismatches = append(mismatches, fmt.Sprintf("VLAN %d", vfInfo.Vlan))
+	}
+	if vfInfo.MinTxRate != 0 || vfInfo.MaxTxRate != 0 {
+		mismatches = append(mismatches, fmt.Sprintf("TX rate %d-%d", vfInfo.MinTxRate, vfInfo.MaxTxRate))
+	}
+	if vfInfo.LinkState != netlink.VF_LINK_STATE_AUTO {
+		mismatches = append(mismatches, fmt.Sprintf("link state %d", vfInfo.LinkState))
+	}
+	if len(mismatches) > 0 {
+		return fmt.Errorf("VF %d on PF %s was not reset: %s", vfIndex, pfName, strings.Join(mismatches, ", "))
+	}
+
+	h.log.V(2).Info("VerifyVFReset(): VF configuration is cleared", "vf", vfPciAddress, "pf", pfName, "vfIndex", vfIndex)
+	return nil
+}
+
+// SetVFMacAddress sets the administrative MAC address of the VF through the PF (legacy SR-IOV VF ndo)
+func (h *Host) SetVFMacAddress(vfPciAddress, macAddress string) error {
+	hwAddr, err := net.ParseMAC(macAddress)
+	if err != nil {
+		return fmt.Errorf("invalid MAC address %s: %v", macAddress, err)
+	}
+
+	pfLink, vfIndex, err := h.getVFParentLink(vfPciAddress)
+	if err != nil {
+		return err
+	}
+
+	if err := h.netlink.LinkSetVfHardwareAddr(pfLink, vfIndex, hwAddr); err != nil {
+		return fmt.Errorf("failed to set MAC address %s for VF %d on PF %s: %v", macAddress, vfIndex, pfLink.Attrs().Name, err)
+	}
+
+	h.log.V(2).Info("SetVFMacAddress(): set VF MAC address", "vf", vfPciAddress, "pf", pfLink.Attrs().Name, "vfIndex", vfIndex, "mac", macAddress)
+	return nil
+}
+
+// SetVFRepresentorMacAddress sets the MAC address of the VF through the hw_addr function of its devlink
+// representor port, used on switchdev PFs instead of the legacy VF ndo
+func (h *Host) SetVFRepresentorMacAddress(vfPciAddress, macAddress string) error {
+	hwAddr, err := net.ParseMAC(macAddress)
+	if err != nil {
+		return fmt.Errorf("invalid MAC address %s: %v", macAddress, err)
+	}
+
+	pfPciAddress, vfIndex, err := h.GetVFIndex(vfPciAddress)
+	if err != nil {
+		return fmt.Errorf("failed to get VF index for %s: %v", vfPciAddress, err)
+	}
+
+	port, err := h.getVFRepresentorPort(pfPciAddress, vfIndex)
+	if err != nil {
+		return err
+	}
+
+	err = h.netlink.DevlinkPortFnSet(port.BusName, port.DeviceName, port.PortIndex, netlink.DevlinkPortFnSetAttrs{
+		FnAttrs:     netlink.DevlinkPortFn{HwAddr: hwAddr},
+		HwAddrValid: true,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to set MAC address %s on representor %s of VF %d: %v", macAddress, port.NetdeviceName, vfIndex, err)
+	}
+
+	h.log.V(2).Info("SetVFRepresentorMacAddress(): set VF MAC address", "vf", vfPciAddress, "representor", port.NetdeviceName, "vfIndex", vfIndex, "mac", macAddress)
+	return nil
+}
+
+// SetVFRate sets the minimum and maximum transmit rates in Mbps of the VF through the PF, 0 means no limit
+func (h *Host) SetVFRate(vfPciAddress string, minTxRate, maxTxRate int) error {
+	pfLink, vfIndex, err := h.getVFParentLink(vfPciAddress)
+	if err != nil {
+		return err
+	}
+
+	if err := h.netlink.LinkSetVfRate(pfLink, vfIndex, minTxRate, maxTxRate); err != nil {
+		return fmt.Errorf("failed to set rate min %d max %d for VF %d on PF %s: %v", minTxRate, maxTxRate, vfIndex, pfLink.Attrs().Name, err)
+	}
+
+	h.log.V(2).Info("SetVFRate(): set VF rate", "vf", vfPciAddress, "pf", pfLink.Attrs().Name, "vfIndex", vfIndex, "minTxRate", minTxRate, "maxTxRate", maxTxRate)
+	return nil
+}
+
+// vfLinkStates maps the VfConfig link states to the netlink ones
+var vfLinkStates = map[string]uint32{
+	configapi.LinkStateAuto:    netlink.VF_LINK_STATE_AUTO,
+	configapi.LinkStateEnable:  netlink.VF_LINK_STATE_ENABLE,
+	configapi.LinkStateDisable: netlink.VF_LINK_STATE_DISABLE,
+}
+
+// SetVFLinkState sets the administrative link state (auto, enable or disable) of the VF through the PF
+func (h *Host) SetVFLinkState(vfPciAddress, linkState string) error {
+	state, ok := vfLinkStates[linkState]
+	if !ok {
+		return fmt.Errorf("invalid link state %q", linkState)
+	}
+
+	pfLink, vfIndex, err := h.getVFParentLink(vfPciAddress)
+	if err != nil {
+		return err
+	}
+
+	if err := h.netlink.LinkSetVfState(pfLink, vfIndex, state); err != nil {
+		return fmt.Errorf("failed to set link state %s for VF %d on PF %s: %v", linkState, vfIndex, pfLink.Attrs().Name, err)
+	}
+
+	h.log.V(2).Info("SetVFLinkState(): set VF link state", "vf", vfPciAddress, "pf", pfLink.Attrs().Name, "vfIndex", vfIndex, "linkState", linkState)
+	return nil
+}
+
+// getVFRepresentorPort returns the devlink port of the PF representing the VF,
+// matched by the pf<N>vf<M> physical port name of the representor netdev
+func (h *Host) getVFRepresentorPort(pfPciAddress string, vfIndex int) (*netlink.DevlinkPort, error) {
+	ports, err := h.netlink.DevLinkGetAllPortList()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list devlink ports: %v", err)
+	}
+
+	for _, port := range ports {
+		if port.BusName != "pci" || port.DeviceName != pfPciAddress || port.PortFlavour != nl.DEVLINK_PORT_FLAVOUR_PCI_VF {
+			continue
+		}
+		content, err := readFile(buildSysPath(filepath.Join("/sys/class/net", port.NetdeviceName, "phys_port_name")))
+		if err != nil {
+			h.log.V(2).Info("getVFRepresentorPort(): failed to read physical port name", "representor", port.NetdeviceName, "error", err.Error())
+			continue
+		}
+		var pfNumber, vfNumber int
+		if _, err := fmt.Sscanf(strings.TrimSpace(string(content)), "pf%dvf%d", &pfNumber, &vfNumber); err != nil {
+			continue
+		}
+		if vfNumber == vfIndex {
+			return port, nil
+		}
+	}
+
+	return nil, fmt.Errorf("no representor port found for VF %d on PF %s", vfIndex, pfPciAddress)
+}
+
+// GetVFStats reads the traffic counters of the VF from its PF, so they are available
+// while the VF netdev is moved to the pod network namespace
+func (h *Host) GetVFStats(vfPciAddress string) (*VFStats, error) {
+	pfLink, vfIndex, err := h.getVFParentLink(vfPciAddress)
+	if err != nil {
+		return nil, err
+	}
+
+	vfInfo, err := getVFInfo(pfLink, vfIndex)
+	if err != nil {
+		return nil, err
+	}
+	return &VFStats{
+		RxPackets: vfInfo.RxPackets,
+		TxPackets: vfInfo.TxPackets,
+		RxBytes:   vfInfo.RxBytes,
+		TxBytes:   vfInfo.TxBytes,
+		RxDropped: vfInfo.RxDropped,
+		TxDropped: vfInfo.TxDropped,
+	}, nil
+}
+
+// getVFInfo returns the VF information reported by the PF link for the VF index
+func getVFInfo(pfLink netlink.Link, vfIndex int) (*netlink.VfInfo, error) {
+	for i := range pfLink.Attrs().Vfs {
+		if pfLink.Attrs().Vfs[i].ID == vfIndex {
+			return &pfLink.Attrs().Vfs[i], nil
+		}
+	}
+	return nil, fmt.Errorf("VF %d is not reported by PF %s", vfIndex, pfLink.Attrs().Name)
+}
+
+// getVFParentLink returns the netlink link of the PF owning the VF and the index of the VF on it
+func (h *Host) getVFParentLink(vfPciAddress string) (netlink.Link, int, error) {
+	pfPciAddress, vfIndex, err := h.GetVFIndex(vfPciAddress)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to get VF index for %s: %v", vfPciAddress, err)
+	}
+
+	pfName := h.TryGetInterfaceName(pfPciAddress)
+	if pfName == "" {
+		return nil, 0, fmt.Errorf("failed to get interface name for PF %s", pfPciAddress)
+	}
+
+	pfLink, err := h.netlink.LinkByName(pfName)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to get link for PF %s: %v", pfName, err)
+	}
+	return pfLink, vfIndex, nil
 }
 
 // PCI Hardware Discovery Functions
@@ -222,14 +602,32 @@ func (h *Host) PCI() (*ghw.PCIInfo, error) {
 	return ghw.PCI()
 }
 
+// CheckSysBusPci returns an error describing why the PCI devices can't be listed from sysfs,
+// it is used to diagnose the PCI discovery failures
+func (h *Host) CheckSysBusPci() error {
+	path := buildSysPath(consts.SysBusPci)
+	entries, err := readDir(path)
+	if err != nil {
+		return fmt.Errorf("%s is not readable: %w", path, err)
+	}
+	if len(entries) == 0 {
+		return fmt.Errorf("%s has no PCI devices", path)
+	}
+	return nil
+}
+
 // TryGetInterfaceName tries to find the network interface name based on PCI address
 func (h *Host) TryGetInterfaceName(pciAddr string) string {
+	if uplink := h.sriovnetUplink(pciAddr); uplink != "" {
+		return uplink
+	}
+
 	netDir := buildSysBusPciPath(pciAddr, "net")
-	if _, err := os.Lstat(netDir); err != nil {
+	if _, err := lstat(netDir); err != nil {
 		return ""
 	}
 
-	fInfos, err := os.ReadDir(netDir)
+	fInfos, err := readDir(netDir)
 	if err != nil {
 		return ""
 	}
@@ -242,18 +640,230 @@ func (h *Host) TryGetInterfaceName(pciAddr string) string {
 	return fInfos[0].Name()
 }
 
-// GetNicSriovMode returns the interface mode (simplified implementation)
-// This is a simplified version that returns "legacy" mode as fallback
-func (h *Host) GetNicSriovMode(_ string) string {
-	// For simplicity, always return legacy mode
-	// A full implementation would use netlink to query the eswitch mode
-	return "legacy"
+// GetNicSriovMode returns the eswitch mode of the PF reported by devlink.
+// It falls back to "legacy" when the PF doesn't support devlink.
+// With sriovnet enabled a PF with an uplink representor is in switchdev mode without asking devlink.
+func (h *Host) GetNicSriovMode(pciAddr string) string {
+	if h.sriovnetUplink(pciAddr) != "" {
+		return consts.EswitchModeSwitchdev
+	}
+	dev, err := h.netlink.DevLinkGetDeviceByName("pci", pciAddr)
+	if err != nil {
+		h.log.V(2).Info("GetNicSriovMode(): failed to get devlink device, assuming legacy mode", "device", pciAddr, "error", err)
+		return consts.EswitchModeLegacy
+	}
+	if dev.Attrs.Eswitch.Mode == "" {
+		return consts.EswitchModeLegacy
+	}
+	return dev.Attrs.Eswitch.Mode
+}
+
+// GetEswitchMode returns the eswitch mode of the PF reported by devlink, empty when the PF has no devlink
+// device or its driver doesn't implement the eswitch. The other devlink errors are returned.
+func (h *Host) GetEswitchMode(pciAddr string) (string, error) {
+	dev, err := h.netlink.DevLinkGetDeviceByName("pci", pciAddr)
+	if errors.Is(err, unix.ENODEV) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to get devlink device for PF %s: %w", pciAddr, err)
+	}
+	return dev.Attrs.Eswitch.Mode, nil
+}
+
+// SetNicSriovMode sets the eswitch mode of the PF through devlink.
+// Changing the mode destroys the VFs of the PF on most drivers.
+func (h *Host) SetNicSriovMode(pciAddr, mode string) error {
+	dev, err := h.netlink.DevLinkGetDeviceByName("pci", pciAddr)
+	if err != nil {
+		return fmt.Errorf("failed to get devlink device for PF %s: %v", pciAddr, err)
+	}
+	if err := h.netlink.DevLinkSetEswitchMode(dev, mode); err != nil {
+		return fmt.Errorf("failed to set eswitch mode %s on PF %s: %v", mode, pciAddr, err)
+	}
+	return nil
+}
+
+// GetPermanentMacAddress returns the permanent MAC address of a network interface.
+// If the permanent address is not reported (or is all-zero) the current MAC address is returned.
+func (h *Host) GetPermanentMacAddress(ifName string) (string, error) {
+	link, err := h.netlink.LinkByName(ifName)
+	if err == nil {
+		if permAddr := link.Attrs().PermHWAddr; !isZeroMac(permAddr) {
+			return permAddr.String(), nil
+		}
+		if hwAddr := link.Attrs().HardwareAddr; !isZeroMac(hwAddr) {
+			return hwAddr.String(), nil
+		}
+	} else {
+		h.log.V(2).Info("GetPermanentMacAddress(): failed to get link, falling back to sysfs", "interface", ifName, "error", err.Error())
+	}
+
+	addressPath := buildSysPath(filepath.Join("/sys/class/net", ifName, "address"))
+	content, err := readFile(addressPath)
+	if err != nil {
+		return "", fmt.Errorf("failed to read MAC address for interface %s: %v", ifName, err)
+	}
+	hwAddr, err := net.ParseMAC(strings.TrimSpace(string(content)))
+	if err != nil {
+		return "", fmt.Errorf("failed to parse MAC address for interface %s: %v", ifName, err)
+	}
+
+	return hwAddr.String(), nil
+}
+
+// IsLinkUp returns true if the operational state of the network interface is up
+func (h *Host) IsLinkUp(ifName string) (bool, error) {
+	link, err := h.netlink.LinkByName(ifName)
+	if err != nil {
+		return false, fmt.Errorf("failed to get link %s: %v", ifName, err)
+	}
+	return link.Attrs().OperState == netlink.OperUp, nil
+}
+
+// GetDefaultRouteInterfaces returns the sorted names of the network interfaces carrying a default route of the
+// main table, with the interfaces underneath them: the parent of a VLAN and the members of a bond or a bridge.
+// The PF of the node uplink is among them even when the route is set on a VLAN or a bond on top of it.
+func (h *Host) GetDefaultRouteInterfaces() ([]string, error) {
+	routes, err := h.netlink.RouteListFiltered(netlink.FAMILY_ALL, &netlink.Route{Table: unix.RT_TABLE_MAIN}, netlink.RT_FILTER_TABLE)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list the routes: %v", err)
+	}
+	links, err := h.netlink.LinkList()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list the links: %v", err)
+	}
+	linksByIndex := map[int]netlink.Link{}
+	for _, link := range links {
+		linksByIndex[link.Attrs().Index] = link
+	}
+
+	// the interfaces to visit, starting from the ones the default routes go through
+	pending := []int{}
+	for _, route := range routes {
+		if route.Dst != nil {
+			if ones, _ := route.Dst.Mask.Size(); ones != 0 {
+				continue
+			}
+		}
+		if route.LinkIndex > 0 {
+			pending = append(pending, route.LinkIndex)
+		}
+		for _, nextHop := range route.MultiPath {
+			pending = append(pending, nextHop.LinkIndex)
+		}
+	}
+
+	names := sets.New[string]()
+	visited := sets.New[int]()
+	for len(pending) > 0 {
+		index := pending[0]
+		pending = pending[1:]
+		link, found := linksByIndex[index]
+		if !found || visited.Has(index) {
+			continue
+		}
+		visited.Insert(index)
+		names.Insert(link.Attrs().Name)
+		if parentIndex := link.Attrs().ParentIndex; parentIndex > 0 {
+			pending = append(pending, parentIndex)
+		}
+		for _, lower := range links {
+			if lower.Attrs().MasterIndex == index {
+				pending = append(pending, lower.Attrs().Index)
+			}
+		}
+	}
+	return sets.List(names), nil
+}
+
+// GetBondMaster returns the name of the bond the network interface is a slave of,
+// or an empty string when the interface has no master or its master is not a bond (e.g. a bridge)
+func (h *Host) GetBondMaster(ifName string) (string, error) {
+	target, err := readlink(buildSysPath(filepath.Join("/sys/class/net", ifName, "master")))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", nil
+		}
+		return "", fmt.Errorf("failed to read the master of interface %s: %v", ifName, err)
+	}
+
+	master := filepath.Base(target)
+	if _, err := stat(buildSysPath(filepath.Join("/sys/class/net", master, "bonding"))); err != nil {
+		if os.IsNotExist(err) {
+			return "", nil
+		}
+		return "", fmt.Errorf("failed to check if master %s of interface %s is a bond: %v", master, ifName, err)
+	}
+	return master, nil
+}
+
+// GetVFRepresentor returns the name of the representor netdev of a VF on a switchdev PF.
+// The representor shares the physical switch ID of the PF and has a pf<N>vf<M> physical port name,
+// the PF number is only checked when the PF port name has the p<N> form.
+func (h *Host) GetVFRepresentor(pfName string, vfIndex int) (string, error) {
+	switchID, err := readNetSysfsAttr(pfName, "phys_switch_id")
+	if err != nil || switchID == "" {
+		return "", fmt.Errorf("failed to get the physical switch ID of PF %s: %v", pfName, err)
+	}
+	pfNumber := -1
+	if portName, err := readNetSysfsAttr(pfName, "phys_port_name"); err == nil {
+		if _, err := fmt.Sscanf(portName, "p%d", &pfNumber); err != nil {
+			pfNumber = -1
+		}
+	}
+
+	netDevices, err := readDir(buildSysPath("/sys/class/net"))
+	if err != nil {
+		return "", fmt.Errorf("failed to list network interfaces: %v", err)
+	}
+	for _, netDevice := range netDevices {
+		if netDevice.Name() == pfName {
+			continue
+		}
+		if id, err := readNetSysfsAttr(netDevice.Name(), "phys_switch_id"); err != nil || id != switchID {
+			continue
+		}
+		portName, err := readNetSysfsAttr(netDevice.Name(), "phys_port_name")
+		if err != nil {
+			continue
+		}
+		var repPfNumber, repVfNumber int
+		if _, err := fmt.Sscanf(portName, "pf%dvf%d", &repPfNumber, &repVfNumber); err != nil {
+			continue
+		}
+		if repVfNumber == vfIndex && (pfNumber < 0 || repPfNumber == pfNumber) {
+			h.log.V(2).Info("GetVFRepresentor(): found VF representor", "pf", pfName, "vfIndex", vfIndex, "representor", netDevice.Name())
+			return netDevice.Name(), nil
+		}
+	}
+
+	return "", fmt.Errorf("no representor found for VF %d on PF %s", vfIndex, pfName)
+}
+
+// readNetSysfsAttr returns the trimmed content of a sysfs attribute of a network interface
+func readNetSysfsAttr(ifName, attr string) (string, error) {
+	content, err := readFile(buildSysPath(filepath.Join("/sys/class/net", ifName, attr)))
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(content)), nil
+}
+
+// isZeroMac returns true if the MAC address is empty or all-zero
+func isZeroMac(mac net.HardwareAddr) bool {
+	for _, b := range mac {
+		if b != 0 {
+			return false
+		}
+	}
+	return true
 }
 
 // GetNumaNode returns the NUMA node for a given PCI device
 func (h *Host) GetNumaNode(pciAddress string) (string, error) {
 	numaNodePath := buildSysBusPciPath(pciAddress, "numa_node")
-	content, err := os.ReadFile(numaNodePath)
+	content, err := readFile(numaNodePath)
 	if err != nil {
 		// If numa_node file doesn't exist, return "0" as default
 		if os.IsNotExist(err) {
@@ -271,6 +881,20 @@ func (h *Host) GetNumaNode(pciAddress string) (string, error) {
 	return numaNode, nil
 }
 
+// GetNumaNodeAffinity returns the NUMA node of a PCI device, unlike GetNumaNode it returns "-1" when the device
+// has no NUMA affinity (numa_node is -1 or missing) instead of defaulting to node 0
+func (h *Host) GetNumaNodeAffinity(pciAddress string) (string, error) {
+	numaNodePath := buildSysBusPciPath(pciAddress, "numa_node")
+	content, err := readFile(numaNodePath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return consts.NoNumaAffinity, nil
+		}
+		return "", fmt.Errorf("failed to read numa_node for %s: %v", pciAddress, err)
+	}
+	return strings.TrimSpace(string(content)), nil
+}
+
 // GetParentPciAddress returns the parent PCI device address
 func (h *Host) GetParentPciAddress(pciAddress string) (string, error) {
 	// Parse the PCI address to get bus information
@@ -288,7 +912,7 @@ func (h *Host) GetParentPciAddress(pciAddress string) (string, error) {
 
 	// First, try to get parent from sysfs
 	parentPath := buildSysBusPciPath(pciAddress, "../")
-	parentDir, err := filepath.EvalSymlinks(parentPath)
+	parentDir, err := evalSymlinks(parentPath)
 	if err == nil {
 		parentAddr := filepath.Base(parentDir)
 		// Validate the parent address format
@@ -304,7 +928,7 @@ func (h *Host) GetParentPciAddress(pciAddress string) (string, error) {
 		// Try to find a bridge on bus 00
 		parentAddr := fmt.Sprintf("%s:00:00.0", domain)
 		parentDevPath := buildSysBusPciPath(parentAddr, "")
-		if _, err := os.Stat(parentDevPath); err == nil {
+		if _, err := stat(parentDevPath); err == nil {
 			return parentAddr, nil
 		}
 	}
@@ -313,6 +937,51 @@ func (h *Host) GetParentPciAddress(pciAddress string) (string, error) {
 	return "", nil
 }
 
+// GetPciRootPort returns the PCIe root port upstream of the PCI device, found by walking its sysfs device path
+// (e.g. /sys/devices/pci0000:00/0000:00:01.0/0000:01:00.0) to the first device below the host bridge.
+// The devices behind the same root port share its bandwidth, whatever the switches in between.
+// A device attached to the host bridge directly has no root port and the host bridge (e.g. pci0000:00) is returned.
+func (h *Host) GetPciRootPort(pciAddress string) (string, error) {
+	devicePath, err := evalSymlinks(buildSysBusPciPath(pciAddress, ""))
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve the sysfs path of PCI device %s: %v", pciAddress, err)
+	}
+
+	components := strings.Split(filepath.ToSlash(devicePath), "/")
+	for i, component := range components {
+		// the host bridges are named pciDDDD:BB after the domain and root bus they expose
+		hostBridge, isHostBridge := strings.CutPrefix(component, "pci")
+		if !isHostBridge || len(strings.Split(hostBridge, ":")) != 2 || i+1 >= len(components) {
+			continue
+		}
+		rootPort := components[i+1]
+		if len(strings.Split(rootPort, ":")) != 3 {
+			return "", fmt.Errorf("unexpected sysfs path %s of PCI device %s", devicePath, pciAddress)
+		}
+		if rootPort == pciAddress {
+			return component, nil
+		}
+		return rootPort, nil
+	}
+	return "", fmt.Errorf("no PCI host bridge in the sysfs path %s of PCI device %s", devicePath, pciAddress)
+}
+
+// GetNumaNodeCPUs returns the set of CPUs that belong to a given NUMA node
+func (h *Host) GetNumaNodeCPUs(numaNode string) (cpuset.CPUSet, error) {
+	cpuListPath := buildSysPath(filepath.Join("/sys/devices/system/node", "node"+numaNode, "cpulist"))
+	content, err := readFile(cpuListPath)
+	if err != nil {
+		return cpuset.New(), fmt.Errorf("failed to read cpulist for NUMA node %s: %v", numaNode, err)
+	}
+
+	cpus, err := cpuset.Parse(strings.TrimSpace(string(content)))
+	if err != nil {
+		return cpuset.New(), fmt.Errorf("failed to parse cpulist for NUMA node %s: %v", numaNode, err)
+	}
+
+	return cpus, nil
+}
+
 // High-level Driver Management Functions
 
 // BindDeviceDriver binds a device to the specified driver based on config.Driver:
@@ -437,7 +1106,7 @@ func (h *Host) UnbindDriverByBusAndDevice(device string) error {
 // GetDriverByBusAndDevice returns driver for device on the bus
 func (h *Host) GetDriverByBusAndDevice(device string) (string, error) {
 	driverLink := buildSysBusPciPath(device, "driver")
-	driverInfo, err := os.Readlink(driverLink)
+	driverInfo, err := readlink(driverLink)
 	if err != nil {
 		if errors.Is(err, os.ErrNotExist) {
 			h.log.V(2).Info("GetDriverByBusAndDevice(): driver path for device not exist", "device", device)
@@ -493,7 +1162,7 @@ func (h *Host) probeDriver(device string) error {
 // if device doesn't support overriding (has no driver_override path), does nothing
 func (h *Host) setDriverOverride(device, override string) error {
 	driverOverridePath := buildSysBusPciPath(device, "driver_override")
-	if _, err := os.Stat(driverOverridePath); err != nil {
+	if _, err := stat(driverOverridePath); err != nil {
 		if os.IsNotExist(err) {
 			h.log.V(2).Info("setDriverOverride(): device doesn't support driver override, skip", "device", device)
 			return nil
@@ -540,7 +1209,7 @@ func (h *Host) GetVFIODeviceFile(pciAddress string) (devFileHost, devFileContain
 
 	// Get iommu group for this device
 	devPath := buildSysBusPciPath(pciAddress, "")
-	_, err = os.Lstat(devPath)
+	_, err = lstat(devPath)
 	if err != nil {
 		h.log.Error(err, "GetVFIODeviceFile(): Could not get directory information for device", "device", pciAddress)
 		err = fmt.Errorf("GetVFIODeviceFile(): Could not get directory information for device: %s, Err: %v", pciAddress, err)
@@ -550,7 +1219,7 @@ func (h *Host) GetVFIODeviceFile(pciAddress string) (devFileHost, devFileContain
 	iommuDir := filepath.Join(devPath, "iommu_group")
 	h.log.V(2).Info("GetVFIODeviceFile(): checking iommu_group", "device", pciAddress, "iommuDir", iommuDir)
 
-	dirInfo, err := os.Lstat(iommuDir)
+	dirInfo, err := lstat(iommuDir)
 	if err != nil {
 		h.log.Error(err, "GetVFIODeviceFile(): unable to find iommu_group", "device", pciAddress)
 		err = fmt.Errorf("GetVFIODeviceFile(): unable to find iommu_group %v", err)
@@ -563,7 +1232,7 @@ func (h *Host) GetVFIODeviceFile(pciAddress string) (devFileHost, devFileContain
 		return devFileHost, devFileContainer, err
 	}
 
-	linkName, err := filepath.EvalSymlinks(iommuDir)
+	linkName, err := evalSymlinks(iommuDir)
 	if err != nil {
 		h.log.Error(err, "GetVFIODeviceFile(): error reading symlink to iommu_group", "device", pciAddress)
 		err = fmt.Errorf("GetVFIODeviceFile(): error reading symlink to iommu_group %v", err)
@@ -578,7 +1247,7 @@ func (h *Host) GetVFIODeviceFile(pciAddress string) (devFileHost, devFileContain
 	namePath := filepath.Join(linkName, "name")
 	// Read the iommu group name
 	// The name file will not exist on baremetal
-	vfioName, errName := os.ReadFile(namePath)
+	vfioName, errName := readFile(namePath)
 	if errName == nil {
 		vName := strings.TrimSpace(string(vfioName))
 		h.log.V(2).Info("GetVFIODeviceFile(): read iommu group name", "device", pciAddress, "vfioName", vName)
@@ -599,12 +1268,36 @@ func (h *Host) GetVFIODeviceFile(pciAddress string) (devFileHost, devFileContain
 	return devFileHost, devFileContainer, err
 }
 
+// Filesystem Functions
+
+// PathExists checks if a path exists on the host
+func (h *Host) PathExists(path string) bool {
+	_, err := stat(buildSysPath(path))
+	return err == nil
+}
+
+// IsHostNetworkNamespace checks if the network namespace path points to the host network namespace,
+// comparing it with the network namespace of the host init process
+func (h *Host) IsHostNetworkNamespace(netnsPath string) (bool, error) {
+	netnsInfo, err := stat(buildSysPath(netnsPath))
+	if err != nil {
+		return false, fmt.Errorf("failed to stat network namespace %s: %w", netnsPath, err)
+	}
+
+	hostNetnsInfo, err := stat(buildSysPath(hostNetworkNamespacePath))
+	if err != nil {
+		return false, fmt.Errorf("failed to stat host network namespace %s: %w", hostNetworkNamespacePath, err)
+	}
+
+	return os.SameFile(netnsInfo, hostNetnsInfo), nil
+}
+
 // Kernel Module Management Functions
 
 // IsKernelModuleLoaded checks if a kernel module is currently loaded
 func (h *Host) IsKernelModuleLoaded(moduleName string) bool {
 	// Read /proc/modules to check if the module is loaded
-	content, err := os.ReadFile(buildProcPath("/proc/modules"))
+	content, err := readFile(buildProcPath("/proc/modules"))
 	if err != nil {
 		h.log.Error(err, "IsKernelModuleLoaded(): failed to read /proc/modules")
 		return false
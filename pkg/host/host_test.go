@@ -1,10 +1,16 @@
 package host_test
 
 import (
+	iofs "io/fs"
+	"net"
 	"os"
+	"path/filepath"
 
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netlink/nl"
+	"golang.org/x/sys/unix"
 
 	configapi "github.com/SchSeba/dra-driver-sriov/pkg/api/virtualfunction/v1alpha1"
 	"github.com/SchSeba/dra-driver-sriov/pkg/host"
@@ -79,6 +85,141 @@ var _ = Describe("Host", func() {
 			})
 		})
 
+		Context("GetSriovNumVFs and GetSriovTotalVFs", func() {
+			It("should read the VF counts from sysfs", func() {
+				fs.Dirs = []string{
+					"sys/bus/pci/devices/0000:01:00.0",
+				}
+				fs.Files = map[string][]byte{
+					"sys/bus/pci/devices/0000:01:00.0/sriov_numvfs":   []byte("4\n"),
+					"sys/bus/pci/devices/0000:01:00.0/sriov_totalvfs": []byte("64\n"),
+				}
+				tearDown = fs.Use()
+
+				numVFs, err := h.GetSriovNumVFs("0000:01:00.0")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(numVFs).To(Equal(4))
+				totalVFs, err := h.GetSriovTotalVFs("0000:01:00.0")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(totalVFs).To(Equal(64))
+			})
+
+			It("should return an error when the device is not SR-IOV capable", func() {
+				fs.Dirs = []string{
+					"sys/bus/pci/devices/0000:01:00.0",
+				}
+				tearDown = fs.Use()
+
+				_, err := h.GetSriovNumVFs("0000:01:00.0")
+				Expect(err).To(HaveOccurred())
+				_, err = h.GetSriovTotalVFs("0000:01:00.0")
+				Expect(err).To(HaveOccurred())
+			})
+		})
+
+		Context("CheckSysBusPci", func() {
+			It("should succeed when PCI devices are listed", func() {
+				fs.Dirs = []string{
+					"sys/bus/pci/devices/0000:01:00.0",
+				}
+				tearDown = fs.Use()
+
+				Expect(h.CheckSysBusPci()).To(Succeed())
+			})
+
+			It("should fail when there are no PCI devices", func() {
+				fs.Dirs = []string{
+					"sys/bus/pci/devices",
+				}
+				tearDown = fs.Use()
+
+				Expect(h.CheckSysBusPci()).To(MatchError(ContainSubstring("has no PCI devices")))
+			})
+
+			It("should fail when the PCI devices directory is missing", func() {
+				fs.Dirs = []string{
+					"sys",
+				}
+				tearDown = fs.Use()
+
+				Expect(h.CheckSysBusPci()).To(MatchError(iofs.ErrNotExist))
+			})
+		})
+
+		Context("GetBondMaster", func() {
+			It("should return the bond shared by two PFs", func() {
+				fs.Dirs = []string{
+					"sys/class/net/eth0",
+					"sys/class/net/eth1",
+					"sys/class/net/bond0/bonding",
+				}
+				fs.Symlinks = map[string]string{
+					"sys/class/net/eth0/master": "../bond0",
+					"sys/class/net/eth1/master": "../bond0",
+				}
+				tearDown = fs.Use()
+
+				bondName, err := h.GetBondMaster("eth0")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(bondName).To(Equal("bond0"))
+				bondName, err = h.GetBondMaster("eth1")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(bondName).To(Equal("bond0"))
+			})
+
+			It("should return an empty name when the interface has no master", func() {
+				fs.Dirs = []string{
+					"sys/class/net/eth0",
+				}
+				tearDown = fs.Use()
+
+				bondName, err := h.GetBondMaster("eth0")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(bondName).To(BeEmpty())
+			})
+
+			It("should return an empty name when the master is not a bond", func() {
+				fs.Dirs = []string{
+					"sys/class/net/eth0",
+					"sys/class/net/br0/bridge",
+				}
+				fs.Symlinks = map[string]string{
+					"sys/class/net/eth0/master": "../br0",
+				}
+				tearDown = fs.Use()
+
+				bondName, err := h.GetBondMaster("eth0")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(bondName).To(BeEmpty())
+			})
+		})
+
+		Context("GetSriovVFTotalMsix", func() {
+			It("should read the MSI-X vectors of the VFs from sysfs", func() {
+				fs.Dirs = []string{
+					"sys/bus/pci/devices/0000:01:00.0",
+				}
+				fs.Files = map[string][]byte{
+					"sys/bus/pci/devices/0000:01:00.0/sriov_vf_total_msix": []byte("1024\n"),
+				}
+				tearDown = fs.Use()
+
+				msix, err := h.GetSriovVFTotalMsix("0000:01:00.0")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(msix).To(Equal(1024))
+			})
+
+			It("should return a not exist error when the PF doesn't expose the file", func() {
+				fs.Dirs = []string{
+					"sys/bus/pci/devices/0000:01:00.0",
+				}
+				tearDown = fs.Use()
+
+				_, err := h.GetSriovVFTotalMsix("0000:01:00.0")
+				Expect(err).To(MatchError(iofs.ErrNotExist))
+			})
+		})
+
 		Context("GetVFList", func() {
 			It("should return list of VFs with their information", func() {
 				fs.Dirs = []string{
@@ -132,6 +273,299 @@ var _ = Describe("Host", func() {
 		})
 	})
 
+	Describe("GetVFIndex", func() {
+		It("should return the PF address and index of a VF", func() {
+			fs.Dirs = []string{
+				"sys/bus/pci/devices/0000:01:00.0",
+				"sys/bus/pci/devices/0000:01:00.1",
+				"sys/bus/pci/devices/0000:01:00.4",
+			}
+			fs.Symlinks = map[string]string{
+				"sys/bus/pci/devices/0000:01:00.0/virtfn0": "../0000:01:00.1",
+				"sys/bus/pci/devices/0000:01:00.0/virtfn3": "../0000:01:00.4",
+				"sys/bus/pci/devices/0000:01:00.4/physfn":  "../0000:01:00.0",
+			}
+			tearDown = fs.Use()
+
+			pfAddress, index, err := h.GetVFIndex("0000:01:00.4")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(pfAddress).To(Equal("0000:01:00.0"))
+			Expect(index).To(Equal(3))
+		})
+
+		It("should refresh the cache when the VF is not known yet", func() {
+			fs.Dirs = []string{
+				"sys/bus/pci/devices/0000:01:00.0",
+				"sys/bus/pci/devices/0000:01:00.1",
+				"sys/bus/pci/devices/0000:01:00.2",
+			}
+			fs.Symlinks = map[string]string{
+				"sys/bus/pci/devices/0000:01:00.0/virtfn0": "../0000:01:00.1",
+				"sys/bus/pci/devices/0000:01:00.1/physfn":  "../0000:01:00.0",
+				"sys/bus/pci/devices/0000:01:00.2/physfn":  "../0000:01:00.0",
+			}
+			tearDown = fs.Use()
+
+			_, index, err := h.GetVFIndex("0000:01:00.1")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(index).To(Equal(0))
+
+			Expect(os.Symlink("../0000:01:00.2", filepath.Join(fs.RootDir, "sys/bus/pci/devices/0000:01:00.0/virtfn1"))).To(Succeed())
+			_, index, err = h.GetVFIndex("0000:01:00.2")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(index).To(Equal(1))
+		})
+
+		It("should return error when the VF has no parent PF", func() {
+			fs.Dirs = []string{
+				"sys/bus/pci/devices/0000:01:00.1",
+			}
+			tearDown = fs.Use()
+
+			_, _, err := h.GetVFIndex("0000:01:00.1")
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("failed to find parent PF"))
+		})
+	})
+
+	Describe("ResetVF", func() {
+		It("should return error when the VF has no parent PF", func() {
+			fs.Dirs = []string{
+				"sys/bus/pci/devices/0000:01:00.1",
+			}
+			tearDown = fs.Use()
+
+			err := h.ResetVF("0000:01:00.1")
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("failed to get VF index for 0000:01:00.1"))
+		})
+
+		It("should return error when the PF has no network interface", func() {
+			fs.Dirs = []string{
+				"sys/bus/pci/devices/0000:01:00.0",
+				"sys/bus/pci/devices/0000:01:00.1",
+			}
+			fs.Symlinks = map[string]string{
+				"sys/bus/pci/devices/0000:01:00.0/virtfn0": "../0000:01:00.1",
+				"sys/bus/pci/devices/0000:01:00.1/physfn":  "../0000:01:00.0",
+			}
+			tearDown = fs.Use()
+
+			err := h.ResetVF("0000:01:00.1")
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("failed to get interface name for PF 0000:01:00.0"))
+		})
+	})
+
+	Describe("VF reset with netlink", func() {
+		var (
+			fakeNetlink *host.FakeNetlink
+			pfLink      *netlink.Device
+		)
+
+		BeforeEach(func() {
+			fs.Dirs = []string{
+				"sys/bus/pci/devices/0000:01:00.0/net/eth0",
+				"sys/bus/pci/devices/0000:01:00.1",
+				"sys/bus/pci/devices/0000:01:00.2",
+			}
+			fs.Symlinks = map[string]string{
+				"sys/bus/pci/devices/0000:01:00.0/virtfn0": "../0000:01:00.1",
+				"sys/bus/pci/devices/0000:01:00.0/virtfn1": "../0000:01:00.2",
+				"sys/bus/pci/devices/0000:01:00.1/physfn":  "../0000:01:00.0",
+				"sys/bus/pci/devices/0000:01:00.2/physfn":  "../0000:01:00.0",
+			}
+			tearDown = fs.Use()
+
+			pfLink = &netlink.Device{LinkAttrs: netlink.LinkAttrs{
+				Name: "eth0",
+				Vfs: []netlink.VfInfo{
+					{ID: 0},
+					{ID: 1, Mac: net.HardwareAddr{0x02, 0, 0, 0, 0, 0x01}, Vlan: 100, MinTxRate: 10, MaxTxRate: 1000, LinkState: netlink.VF_LINK_STATE_DISABLE},
+				},
+			}}
+			fakeNetlink = &host.FakeNetlink{Links: map[string]*netlink.Device{"eth0": pfLink}}
+			h = host.NewHostWithNetlink(fakeNetlink)
+		})
+
+		It("should clear the VF configuration", func() {
+			Expect(h.ResetVF("0000:01:00.2")).To(Succeed())
+
+			vf := pfLink.Vfs[1]
+			Expect(vf.Mac).To(Equal(net.HardwareAddr{0, 0, 0, 0, 0, 0}))
+			Expect(vf.Vlan).To(BeZero())
+			Expect(vf.MinTxRate).To(BeZero())
+			Expect(vf.MaxTxRate).To(BeZero())
+			Expect(vf.LinkState).To(Equal(netlink.VF_LINK_STATE_AUTO))
+			Expect(h.VerifyVFReset("0000:01:00.2")).To(Succeed())
+		})
+
+		It("should reset a VF whose driver doesn't support the link state", func() {
+			fakeNetlink.VfLinkStateErr = unix.EOPNOTSUPP
+
+			Expect(h.ResetVF("0000:01:00.2")).To(Succeed())
+			Expect(pfLink.Vfs[1].Mac).To(Equal(net.HardwareAddr{0, 0, 0, 0, 0, 0}))
+		})
+
+		It("should return error when the link state reset fails", func() {
+			fakeNetlink.VfLinkStateErr = unix.EIO
+
+			err := h.ResetVF("0000:01:00.2")
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("failed to reset link state for VF 1 on PF eth0"))
+		})
+
+		It("should accept a VF without configuration", func() {
+			Expect(h.VerifyVFReset("0000:01:00.1")).To(Succeed())
+		})
+
+		It("should set the VF tx rate through the PF", func() {
+			Expect(h.SetVFRate("0000:01:00.1", 100, 2000)).To(Succeed())
+
+			Expect(pfLink.Vfs[0].MinTxRate).To(Equal(uint32(100)))
+			Expect(pfLink.Vfs[0].MaxTxRate).To(Equal(uint32(2000)))
+		})
+
+		It("should set the VF link state through the PF", func() {
+			Expect(h.SetVFLinkState("0000:01:00.1", "enable")).To(Succeed())
+			Expect(pfLink.Vfs[0].LinkState).To(Equal(netlink.VF_LINK_STATE_ENABLE))
+
+			Expect(h.SetVFLinkState("0000:01:00.1", "disable")).To(Succeed())
+			Expect(pfLink.Vfs[0].LinkState).To(Equal(netlink.VF_LINK_STATE_DISABLE))
+		})
+
+		It("should reject an unknown VF link state", func() {
+			err := h.SetVFLinkState("0000:01:00.1", "up")
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring(`invalid link state "up"`))
+		})
+
+		It("should report the stale configuration when the driver ignores the reset", func() {
+			fakeNetlink.IgnoreVfWrites = true
+
+			Expect(h.ResetVF("0000:01:00.2")).To(Succeed())
+			err := h.VerifyVFReset("0000:01:00.2")
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("VF 1 on PF eth0 was not reset"))
+			Expect(err.Error()).To(ContainSubstring("MAC address 02:00:00:00:00:01"))
+			Expect(err.Error()).To(ContainSubstring("VLAN 100"))
+			Expect(err.Error()).To(ContainSubstring("TX rate 10-1000"))
+			Expect(err.Error()).To(ContainSubstring("link state 2"))
+		})
+
+		It("should return error when the PF does not report the VF", func() {
+			pfLink.Vfs = pfLink.Vfs[:1]
+
+			err := h.VerifyVFReset("0000:01:00.2")
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("VF 1 is not reported by PF eth0"))
+		})
+
+		It("should read the VF stats through the PF", func() {
+			pfLink.Vfs[1].RxPackets = 10
+			pfLink.Vfs[1].TxPackets = 20
+			pfLink.Vfs[1].RxBytes = 1000
+			pfLink.Vfs[1].TxBytes = 2000
+			pfLink.Vfs[1].RxDropped = 1
+			pfLink.Vfs[1].TxDropped = 2
+
+			stats, err := h.GetVFStats("0000:01:00.2")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(*stats).To(Equal(host.VFStats{RxPackets: 10, TxPackets: 20, RxBytes: 1000, TxBytes: 2000, RxDropped: 1, TxDropped: 2}))
+		})
+
+		It("should return error reading the stats of a VF not reported by the PF", func() {
+			pfLink.Vfs = pfLink.Vfs[:1]
+
+			_, err := h.GetVFStats("0000:01:00.2")
+			Expect(err).To(MatchError(ContainSubstring("VF 1 is not reported by PF eth0")))
+		})
+
+		It("should return error when the PF link is missing", func() {
+			delete(fakeNetlink.Links, "eth0")
+
+			err := h.VerifyVFReset("0000:01:00.2")
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("failed to get link for PF eth0"))
+		})
+	})
+
+	Describe("VF MAC address", func() {
+		var (
+			fakeNetlink *host.FakeNetlink
+			pfLink      *netlink.Device
+		)
+
+		BeforeEach(func() {
+			fs.Dirs = []string{
+				"sys/bus/pci/devices/0000:01:00.0/net/eth0",
+				"sys/bus/pci/devices/0000:01:00.1",
+				"sys/bus/pci/devices/0000:01:00.2",
+				"sys/class/net/eth0_0",
+				"sys/class/net/eth0_1",
+			}
+			fs.Files = map[string][]byte{
+				"sys/class/net/eth0_0/phys_port_name": []byte("pf0vf0\n"),
+				"sys/class/net/eth0_1/phys_port_name": []byte("pf0vf1\n"),
+			}
+			fs.Symlinks = map[string]string{
+				"sys/bus/pci/devices/0000:01:00.0/virtfn0": "../0000:01:00.1",
+				"sys/bus/pci/devices/0000:01:00.0/virtfn1": "../0000:01:00.2",
+				"sys/bus/pci/devices/0000:01:00.1/physfn":  "../0000:01:00.0",
+				"sys/bus/pci/devices/0000:01:00.2/physfn":  "../0000:01:00.0",
+			}
+			tearDown = fs.Use()
+
+			pfLink = &netlink.Device{LinkAttrs: netlink.LinkAttrs{
+				Name: "eth0",
+				Vfs:  []netlink.VfInfo{{ID: 0}, {ID: 1}},
+			}}
+			fakeNetlink = &host.FakeNetlink{
+				Links: map[string]*netlink.Device{"eth0": pfLink},
+				DevlinkPorts: []*netlink.DevlinkPort{
+					{BusName: "pci", DeviceName: "0000:01:00.0", PortIndex: 65535, NetdeviceName: "eth0", PortFlavour: nl.DEVLINK_PORT_FLAVOUR_PHYSICAL},
+					{BusName: "pci", DeviceName: "0000:01:00.0", PortIndex: 1, NetdeviceName: "eth0_0", PortFlavour: nl.DEVLINK_PORT_FLAVOUR_PCI_VF},
+					{BusName: "pci", DeviceName: "0000:01:00.0", PortIndex: 2, NetdeviceName: "eth0_1", PortFlavour: nl.DEVLINK_PORT_FLAVOUR_PCI_VF},
+				},
+			}
+			h = host.NewHostWithNetlink(fakeNetlink)
+		})
+
+		It("should set the MAC address through the PF on legacy mode", func() {
+			Expect(h.SetVFMacAddress("0000:01:00.2", "02:00:00:00:00:01")).To(Succeed())
+
+			Expect(pfLink.Vfs[1].Mac.String()).To(Equal("02:00:00:00:00:01"))
+			Expect(fakeNetlink.DevlinkPorts[2].Fn).To(BeNil())
+		})
+
+		It("should set the MAC address on the VF representor port on switchdev mode", func() {
+			Expect(h.SetVFRepresentorMacAddress("0000:01:00.2", "02:00:00:00:00:01")).To(Succeed())
+
+			Expect(fakeNetlink.DevlinkPorts[2].Fn).NotTo(BeNil())
+			Expect(fakeNetlink.DevlinkPorts[2].Fn.HwAddr.String()).To(Equal("02:00:00:00:00:01"))
+			Expect(fakeNetlink.DevlinkPorts[1].Fn).To(BeNil())
+			Expect(pfLink.Vfs[1].Mac).To(BeNil())
+		})
+
+		It("should return error when the VF has no representor port", func() {
+			fakeNetlink.DevlinkPorts = fakeNetlink.DevlinkPorts[:2]
+
+			err := h.SetVFRepresentorMacAddress("0000:01:00.2", "02:00:00:00:00:01")
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("no representor port found for VF 1 on PF 0000:01:00.0"))
+		})
+
+		It("should reject an invalid MAC address", func() {
+			err := h.SetVFMacAddress("0000:01:00.2", "not-a-mac")
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("invalid MAC address not-a-mac"))
+
+			err = h.SetVFRepresentorMacAddress("0000:01:00.2", "not-a-mac")
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("invalid MAC address not-a-mac"))
+		})
+	})
+
 	Describe("Network Interface Functions", func() {
 		Context("TryGetInterfaceName", func() {
 			It("should return interface name when net directory exists", func() {
@@ -167,12 +601,269 @@ var _ = Describe("Host", func() {
 		})
 
 		Context("GetNicSriovMode", func() {
-			It("should return legacy mode", func() {
-				tearDown = fs.Use()
+			It("should return legacy mode when the PF has no devlink device", func() {
+				h = host.NewHostWithNetlink(&host.FakeNetlink{})
 
 				mode := h.GetNicSriovMode("0000:01:00.0")
 				Expect(mode).To(Equal("legacy"))
 			})
+
+			It("should return the eswitch mode reported by devlink", func() {
+				h = host.NewHostWithNetlink(&host.FakeNetlink{DevlinkDevices: []*netlink.DevlinkDevice{
+					{BusName: "pci", DeviceName: "0000:01:00.0", Attrs: netlink.DevlinkDevAttrs{Eswitch: netlink.DevlinkDevEswitchAttr{Mode: "switchdev"}}},
+				}})
+
+				mode := h.GetNicSriovMode("0000:01:00.0")
+				Expect(mode).To(Equal("switchdev"))
+			})
+		})
+
+		Context("sriovnet detection", func() {
+			BeforeEach(func() {
+				h = host.NewHostWithNetlink(&host.FakeNetlink{DevlinkDevices: []*netlink.DevlinkDevice{
+					{BusName: "pci", DeviceName: "0000:02:00.0", Attrs: netlink.DevlinkDevAttrs{Eswitch: netlink.DevlinkDevEswitchAttr{Mode: "legacy"}}},
+				}})
+				// a switchdev PF listing a VF representor before its uplink, a legacy PF and a VF of the legacy PF
+				fs.Dirs = []string{
+					"sys/bus/pci/devices/0000:01:00.0/net/eth0",
+					"sys/bus/pci/devices/0000:01:00.0/net/eth1",
+					"sys/bus/pci/devices/0000:02:00.0/net/eth2",
+					"sys/bus/pci/devices/0000:02:00.1/net/eth3",
+					"sys/class/net/eth0",
+					"sys/class/net/eth1",
+					"sys/class/net/eth2",
+					"sys/class/net/eth3",
+				}
+				fs.Files = map[string][]byte{
+					"sys/class/net/eth0/phys_switch_id": []byte("e41d2d0300fb7a3e\n"),
+					"sys/class/net/eth0/phys_port_name": []byte("pf0vf0\n"),
+					"sys/class/net/eth1/phys_switch_id": []byte("e41d2d0300fb7a3e\n"),
+					"sys/class/net/eth1/phys_port_name": []byte("p0\n"),
+				}
+				fs.Symlinks = map[string]string{
+					"sys/bus/pci/devices/0000:02:00.1/physfn": "../0000:02:00.0",
+				}
+				tearDown = fs.Use()
+			})
+
+			It("should find the same interfaces as the sysfs helpers besides the switchdev uplink", func() {
+				sysfsNames := []string{}
+				for _, pciAddress := range []string{"0000:01:00.0", "0000:02:00.0", "0000:02:00.1"} {
+					sysfsNames = append(sysfsNames, h.TryGetInterfaceName(pciAddress))
+				}
+				Expect(sysfsNames).To(Equal([]string{"eth0", "eth2", "eth3"}))
+
+				DeferCleanup(host.SetSriovnet())
+				sriovnetNames := []string{}
+				for _, pciAddress := range []string{"0000:01:00.0", "0000:02:00.0", "0000:02:00.1"} {
+					sriovnetNames = append(sriovnetNames, h.TryGetInterfaceName(pciAddress))
+				}
+				Expect(sriovnetNames).To(Equal([]string{"eth1", "eth2", "eth3"}))
+			})
+
+			It("should find the same eswitch modes as devlink besides the switchdev PF without devlink", func() {
+				Expect(h.GetNicSriovMode("0000:01:00.0")).To(Equal("legacy"))
+				Expect(h.GetNicSriovMode("0000:02:00.0")).To(Equal("legacy"))
+
+				DeferCleanup(host.SetSriovnet())
+				Expect(h.GetNicSriovMode("0000:01:00.0")).To(Equal("switchdev"))
+				Expect(h.GetNicSriovMode("0000:02:00.0")).To(Equal("legacy"))
+			})
+		})
+
+		Context("GetEswitchMode", func() {
+			It("should return the eswitch mode reported by devlink", func() {
+				h = host.NewHostWithNetlink(&host.FakeNetlink{DevlinkDevices: []*netlink.DevlinkDevice{
+					{BusName: "pci", DeviceName: "0000:01:00.0", Attrs: netlink.DevlinkDevAttrs{Eswitch: netlink.DevlinkDevEswitchAttr{Mode: "legacy"}}},
+				}})
+
+				Expect(h.GetEswitchMode("0000:01:00.0")).To(Equal("legacy"))
+			})
+
+			It("should return an empty mode when the PF has no devlink device", func() {
+				h = host.NewHostWithNetlink(&host.FakeNetlink{})
+
+				Expect(h.GetEswitchMode("0000:01:00.0")).To(BeEmpty())
+			})
+		})
+
+		Context("SetNicSriovMode", func() {
+			It("should set the eswitch mode through devlink", func() {
+				h = host.NewHostWithNetlink(&host.FakeNetlink{DevlinkDevices: []*netlink.DevlinkDevice{
+					{BusName: "pci", DeviceName: "0000:01:00.0", Attrs: netlink.DevlinkDevAttrs{Eswitch: netlink.DevlinkDevEswitchAttr{Mode: "legacy"}}},
+				}})
+
+				Expect(h.SetNicSriovMode("0000:01:00.0", "switchdev")).To(Succeed())
+				Expect(h.GetNicSriovMode("0000:01:00.0")).To(Equal("switchdev"))
+			})
+
+			It("should return an error when the PF has no devlink device", func() {
+				h = host.NewHostWithNetlink(&host.FakeNetlink{})
+
+				err := h.SetNicSriovMode("0000:01:00.0", "switchdev")
+				Expect(err).To(HaveOccurred())
+				Expect(err.Error()).To(ContainSubstring("failed to get devlink device for PF 0000:01:00.0"))
+			})
+		})
+
+		Context("GetPermanentMacAddress", func() {
+			It("should fall back to sysfs when the link is not found", func() {
+				fs.Dirs = []string{
+					"sys/class/net/fakepf0",
+				}
+				fs.Files = map[string][]byte{
+					"sys/class/net/fakepf0/address": []byte("AA:BB:CC:DD:EE:01\n"),
+				}
+				tearDown = fs.Use()
+
+				mac, err := h.GetPermanentMacAddress("fakepf0")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(mac).To(Equal("aa:bb:cc:dd:ee:01"))
+			})
+
+			It("should return error when the MAC address cannot be read", func() {
+				tearDown = fs.Use()
+
+				_, err := h.GetPermanentMacAddress("fakepf0")
+				Expect(err).To(HaveOccurred())
+				Expect(err.Error()).To(ContainSubstring("failed to read MAC address for interface fakepf0"))
+			})
+		})
+
+		Context("IsLinkUp", func() {
+			It("should follow the operational state of the link", func() {
+				pfLink := &netlink.Device{LinkAttrs: netlink.LinkAttrs{Name: "eth0", OperState: netlink.OperUp}}
+				h = host.NewHostWithNetlink(&host.FakeNetlink{Links: map[string]*netlink.Device{"eth0": pfLink}})
+
+				linkUp, err := h.IsLinkUp("eth0")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(linkUp).To(BeTrue())
+
+				pfLink.OperState = netlink.OperDown
+				linkUp, err = h.IsLinkUp("eth0")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(linkUp).To(BeFalse())
+			})
+
+			It("should return an error when the link is not found", func() {
+				h = host.NewHostWithNetlink(&host.FakeNetlink{})
+
+				_, err := h.IsLinkUp("eth0")
+				Expect(err).To(HaveOccurred())
+			})
+		})
+
+		Context("GetDefaultRouteInterfaces", func() {
+			newLink := func(name string, index, masterIndex, parentIndex int) *netlink.Device {
+				return &netlink.Device{LinkAttrs: netlink.LinkAttrs{Name: name, Index: index, MasterIndex: masterIndex, ParentIndex: parentIndex}}
+			}
+
+			It("should return the PF carrying the default route", func() {
+				h = host.NewHostWithNetlink(&host.FakeNetlink{
+					Links: map[string]*netlink.Device{
+						"eth0": newLink("eth0", 2, 0, 0),
+						"eth1": newLink("eth1", 3, 0, 0),
+					},
+					Routes: []netlink.Route{
+						{LinkIndex: 2, Gw: net.ParseIP("192.168.1.1")},
+						{LinkIndex: 3, Dst: &net.IPNet{IP: net.ParseIP("10.0.0.0"), Mask: net.CIDRMask(24, 32)}},
+					},
+				})
+
+				uplinks, err := h.GetDefaultRouteInterfaces()
+				Expect(err).NotTo(HaveOccurred())
+				Expect(uplinks).To(Equal([]string{"eth0"}))
+			})
+
+			It("should return the PFs underneath the VLAN and the bond carrying the default route", func() {
+				h = host.NewHostWithNetlink(&host.FakeNetlink{
+					Links: map[string]*netlink.Device{
+						"eth0":      newLink("eth0", 2, 10, 0),
+						"eth1":      newLink("eth1", 3, 10, 0),
+						"eth2":      newLink("eth2", 4, 0, 0),
+						"eth3":      newLink("eth3", 5, 0, 0),
+						"bond0":     newLink("bond0", 10, 0, 0),
+						"bond0.100": newLink("bond0.100", 11, 0, 10),
+					},
+					Routes: []netlink.Route{
+						{LinkIndex: 11, Gw: net.ParseIP("192.168.1.1")},
+						{LinkIndex: 4, Dst: &net.IPNet{IP: net.ParseIP("10.0.0.0"), Mask: net.CIDRMask(24, 32)}},
+						{LinkIndex: 5, Dst: &net.IPNet{IP: net.IPv6zero, Mask: net.CIDRMask(0, 128)}, Gw: net.ParseIP("fd00::1")},
+					},
+				})
+
+				uplinks, err := h.GetDefaultRouteInterfaces()
+				Expect(err).NotTo(HaveOccurred())
+				Expect(uplinks).To(Equal([]string{"bond0", "bond0.100", "eth0", "eth1", "eth3"}))
+			})
+
+			It("should return no interface without a default route", func() {
+				h = host.NewHostWithNetlink(&host.FakeNetlink{
+					Links: map[string]*netlink.Device{"eth0": newLink("eth0", 2, 0, 0)},
+				})
+
+				uplinks, err := h.GetDefaultRouteInterfaces()
+				Expect(err).NotTo(HaveOccurred())
+				Expect(uplinks).To(BeEmpty())
+			})
+		})
+
+		Context("GetVFRepresentor", func() {
+			BeforeEach(func() {
+				fs.Dirs = []string{
+					"sys/class/net/eth0",
+					"sys/class/net/eth1",
+					"sys/class/net/eth0_0",
+					"sys/class/net/eth0_1",
+					"sys/class/net/eth1_1",
+					"sys/class/net/lo",
+				}
+				fs.Files = map[string][]byte{
+					"sys/class/net/eth0/phys_switch_id":   []byte("aabbcc\n"),
+					"sys/class/net/eth0/phys_port_name":   []byte("p0\n"),
+					"sys/class/net/eth1/phys_switch_id":   []byte("ddeeff\n"),
+					"sys/class/net/eth1/phys_port_name":   []byte("p1\n"),
+					"sys/class/net/eth0_0/phys_switch_id": []byte("aabbcc\n"),
+					"sys/class/net/eth0_0/phys_port_name": []byte("pf0vf0\n"),
+					"sys/class/net/eth0_1/phys_switch_id": []byte("aabbcc\n"),
+					"sys/class/net/eth0_1/phys_port_name": []byte("pf0vf1\n"),
+					"sys/class/net/eth1_1/phys_switch_id": []byte("ddeeff\n"),
+					"sys/class/net/eth1_1/phys_port_name": []byte("pf1vf1\n"),
+				}
+				tearDown = fs.Use()
+			})
+
+			It("should match the VF representor by switch ID and port name", func() {
+				representor, err := h.GetVFRepresentor("eth0", 1)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(representor).To(Equal("eth0_1"))
+
+				representor, err = h.GetVFRepresentor("eth1", 1)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(representor).To(Equal("eth1_1"))
+			})
+
+			It("should not match the representor of another PF number on the same switch", func() {
+				fs.Files["sys/class/net/eth0_1/phys_port_name"] = []byte("pf1vf1\n")
+				tearDown()
+				tearDown = fs.Use()
+
+				_, err := h.GetVFRepresentor("eth0", 1)
+				Expect(err).To(HaveOccurred())
+				Expect(err.Error()).To(ContainSubstring("no representor found for VF 1 on PF eth0"))
+			})
+
+			It("should return an error when the VF has no representor", func() {
+				_, err := h.GetVFRepresentor("eth1", 0)
+				Expect(err).To(HaveOccurred())
+				Expect(err.Error()).To(ContainSubstring("no representor found for VF 0 on PF eth1"))
+			})
+
+			It("should return an error when the PF has no switch ID", func() {
+				_, err := h.GetVFRepresentor("lo", 0)
+				Expect(err).To(HaveOccurred())
+				Expect(err.Error()).To(ContainSubstring("failed to get the physical switch ID of PF lo"))
+			})
 		})
 	})
 
@@ -215,6 +906,68 @@ var _ = Describe("Host", func() {
 			})
 		})
 
+		Context("GetNumaNodeAffinity", func() {
+			It("should return NUMA node from file", func() {
+				fs.Dirs = []string{
+					"sys/bus/pci/devices/0000:01:00.0",
+				}
+				fs.Files = map[string][]byte{
+					"sys/bus/pci/devices/0000:01:00.0/numa_node": []byte("1\n"),
+				}
+				tearDown = fs.Use()
+
+				numaNode, err := h.GetNumaNodeAffinity("0000:01:00.0")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(numaNode).To(Equal("1"))
+			})
+
+			It("should return '-1' when NUMA node file contains -1", func() {
+				fs.Dirs = []string{
+					"sys/bus/pci/devices/0000:01:00.0",
+				}
+				fs.Files = map[string][]byte{
+					"sys/bus/pci/devices/0000:01:00.0/numa_node": []byte("-1"),
+				}
+				tearDown = fs.Use()
+
+				numaNode, err := h.GetNumaNodeAffinity("0000:01:00.0")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(numaNode).To(Equal("-1"))
+			})
+
+			It("should return '-1' when NUMA node file does not exist", func() {
+				tearDown = fs.Use()
+
+				numaNode, err := h.GetNumaNodeAffinity("0000:01:00.0")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(numaNode).To(Equal("-1"))
+			})
+		})
+
+		Context("GetNumaNodeCPUs", func() {
+			It("should return the CPUs of the NUMA node", func() {
+				fs.Dirs = []string{
+					"sys/devices/system/node/node1",
+				}
+				fs.Files = map[string][]byte{
+					"sys/devices/system/node/node1/cpulist": []byte("8-11,24\n"),
+				}
+				tearDown = fs.Use()
+
+				cpus, err := h.GetNumaNodeCPUs("1")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(cpus.List()).To(Equal([]int{8, 9, 10, 11, 24}))
+			})
+
+			It("should return error when the NUMA node does not exist", func() {
+				tearDown = fs.Use()
+
+				_, err := h.GetNumaNodeCPUs("3")
+				Expect(err).To(HaveOccurred())
+				Expect(err.Error()).To(ContainSubstring("failed to read cpulist for NUMA node 3"))
+			})
+		})
+
 		Context("GetParentPciAddress", func() {
 			It("should return parent address from symlink", func() {
 				fs.Dirs = []string{
@@ -246,6 +999,44 @@ var _ = Describe("Host", func() {
 				Expect(err.Error()).To(ContainSubstring("invalid PCI address format"))
 			})
 		})
+
+		Context("GetPciRootPort", func() {
+			BeforeEach(func() {
+				fs.Dirs = []string{
+					"sys/bus/pci/devices",
+					"sys/devices/pci0000:00/0000:00:01.0/0000:01:00.0/0000:02:01.0/0000:03:00.0",
+					"sys/devices/pci0000:00/0000:00:01.0/0000:01:00.0/0000:02:01.0/0000:03:00.2",
+					"sys/devices/pci0000:00/0000:00:1f.6",
+				}
+				fs.Symlinks = map[string]string{
+					"sys/bus/pci/devices/0000:03:00.0": "../../../devices/pci0000:00/0000:00:01.0/0000:01:00.0/0000:02:01.0/0000:03:00.0",
+					"sys/bus/pci/devices/0000:03:00.2": "../../../devices/pci0000:00/0000:00:01.0/0000:01:00.0/0000:02:01.0/0000:03:00.2",
+					"sys/bus/pci/devices/0000:00:1f.6": "../../../devices/pci0000:00/0000:00:1f.6",
+				}
+				tearDown = fs.Use()
+			})
+
+			It("should return the root port above the switches", func() {
+				rootPort, err := h.GetPciRootPort("0000:03:00.0")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(rootPort).To(Equal("0000:00:01.0"))
+
+				rootPort, err = h.GetPciRootPort("0000:03:00.2")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(rootPort).To(Equal("0000:00:01.0"))
+			})
+
+			It("should return the host bridge for a device attached to it directly", func() {
+				rootPort, err := h.GetPciRootPort("0000:00:1f.6")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(rootPort).To(Equal("pci0000:00"))
+			})
+
+			It("should return an error when the device does not exist", func() {
+				_, err := h.GetPciRootPort("0000:04:00.0")
+				Expect(err).To(MatchError(ContainSubstring("failed to resolve the sysfs path of PCI device 0000:04:00.0")))
+			})
+		})
 	})
 
 	Describe("Driver Management Functions", func() {
@@ -450,6 +1241,66 @@ vhost_net 32768 1 tun, Live 0xffffffffa0456000`),
 		})
 	})
 
+	Describe("Filesystem Functions", func() {
+		Context("PathExists", func() {
+			It("should return true for an existing path", func() {
+				fs.Dirs = []string{
+					"dev/hugepages",
+				}
+				tearDown = fs.Use()
+
+				Expect(h.PathExists("/dev/hugepages")).To(BeTrue())
+			})
+
+			It("should return false for a missing path", func() {
+				tearDown = fs.Use()
+
+				Expect(h.PathExists("/dev/hugepages")).To(BeFalse())
+			})
+		})
+
+		Context("IsHostNetworkNamespace", func() {
+			BeforeEach(func() {
+				fs.Dirs = []string{
+					"proc/1/ns",
+					"proc/1234/ns",
+					"proc/5678/ns",
+				}
+				fs.Files = map[string][]byte{
+					"proc/1/ns/net":    []byte(""),
+					"proc/5678/ns/net": []byte(""),
+				}
+				fs.Symlinks = map[string]string{
+					"proc/1234/ns/net": "../../1/ns/net",
+				}
+			})
+
+			It("should return true for the host network namespace", func() {
+				tearDown = fs.Use()
+
+				isHost, err := h.IsHostNetworkNamespace("/proc/1234/ns/net")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(isHost).To(BeTrue())
+			})
+
+			It("should return false for a pod network namespace", func() {
+				tearDown = fs.Use()
+
+				isHost, err := h.IsHostNetworkNamespace("/proc/5678/ns/net")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(isHost).To(BeFalse())
+			})
+
+			It("should return an error for a missing network namespace", func() {
+				tearDown = fs.Use()
+
+				_, err := h.IsHostNetworkNamespace("/var/run/netns/missing")
+				Expect(err).To(HaveOccurred())
+				Expect(err.Error()).To(ContainSubstring("failed to stat network namespace /var/run/netns/missing"))
+			})
+		})
+	})
+
 	Describe("Edge Cases and Error Handling", func() {
 		Context("File System Operations", func() {
 			It("should handle non-existent directories gracefully", func() {
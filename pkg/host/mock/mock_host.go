@@ -10,12 +10,14 @@
 package mock_host
 
 import (
+	context "context"
 	reflect "reflect"
 
 	v1alpha1 "github.com/SchSeba/dra-driver-sriov/pkg/api/virtualfunction/v1alpha1"
 	host "github.com/SchSeba/dra-driver-sriov/pkg/host"
 	ghw "github.com/jaypipes/ghw"
 	gomock "go.uber.org/mock/gomock"
+	cpuset "k8s.io/utils/cpuset"
 )
 
 // MockInterface is a mock of Interface interface.
@@ -85,6 +87,20 @@ func (mr *MockInterfaceMockRecorder) BindDriverByBusAndDevice(device, driver any
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "BindDriverByBusAndDevice", reflect.TypeOf((*MockInterface)(nil).BindDriverByBusAndDevice), device, driver)
 }
 
+// CheckSysBusPci mocks base method.
+func (m *MockInterface) CheckSysBusPci() error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CheckSysBusPci")
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// CheckSysBusPci indicates an expected call of CheckSysBusPci.
+func (mr *MockInterfaceMockRecorder) CheckSysBusPci() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CheckSysBusPci", reflect.TypeOf((*MockInterface)(nil).CheckSysBusPci))
+}
+
 // EnsureDpdkModuleLoaded mocks base method.
 func (m *MockInterface) EnsureDpdkModuleLoaded(driver string) error {
 	m.ctrl.T.Helper()
@@ -113,6 +129,36 @@ func (mr *MockInterfaceMockRecorder) EnsureVhostModulesLoaded() *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "EnsureVhostModulesLoaded", reflect.TypeOf((*MockInterface)(nil).EnsureVhostModulesLoaded))
 }
 
+// GetBondMaster mocks base method.
+func (m *MockInterface) GetBondMaster(ifName string) (string, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetBondMaster", ifName)
+	ret0, _ := ret[0].(string)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetBondMaster indicates an expected call of GetBondMaster.
+func (mr *MockInterfaceMockRecorder) GetBondMaster(ifName any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetBondMaster", reflect.TypeOf((*MockInterface)(nil).GetBondMaster), ifName)
+}
+
+// GetDefaultRouteInterfaces mocks base method.
+func (m *MockInterface) GetDefaultRouteInterfaces() ([]string, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetDefaultRouteInterfaces")
+	ret0, _ := ret[0].([]string)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetDefaultRouteInterfaces indicates an expected call of GetDefaultRouteInterfaces.
+func (mr *MockInterfaceMockRecorder) GetDefaultRouteInterfaces() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetDefaultRouteInterfaces", reflect.TypeOf((*MockInterface)(nil).GetDefaultRouteInterfaces))
+}
+
 // GetDriverByBusAndDevice mocks base method.
 func (m *MockInterface) GetDriverByBusAndDevice(device string) (string, error) {
 	m.ctrl.T.Helper()
@@ -128,6 +174,21 @@ func (mr *MockInterfaceMockRecorder) GetDriverByBusAndDevice(device any) *gomock
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetDriverByBusAndDevice", reflect.TypeOf((*MockInterface)(nil).GetDriverByBusAndDevice), device)
 }
 
+// GetEswitchMode mocks base method.
+func (m *MockInterface) GetEswitchMode(pciAddr string) (string, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetEswitchMode", pciAddr)
+	ret0, _ := ret[0].(string)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetEswitchMode indicates an expected call of GetEswitchMode.
+func (mr *MockInterfaceMockRecorder) GetEswitchMode(pciAddr any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetEswitchMode", reflect.TypeOf((*MockInterface)(nil).GetEswitchMode), pciAddr)
+}
+
 // GetNicSriovMode mocks base method.
 func (m *MockInterface) GetNicSriovMode(pciAddr string) string {
 	m.ctrl.T.Helper()
@@ -157,6 +218,36 @@ func (mr *MockInterfaceMockRecorder) GetNumaNode(pciAddress any) *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetNumaNode", reflect.TypeOf((*MockInterface)(nil).GetNumaNode), pciAddress)
 }
 
+// GetNumaNodeAffinity mocks base method.
+func (m *MockInterface) GetNumaNodeAffinity(pciAddress string) (string, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetNumaNodeAffinity", pciAddress)
+	ret0, _ := ret[0].(string)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetNumaNodeAffinity indicates an expected call of GetNumaNodeAffinity.
+func (mr *MockInterfaceMockRecorder) GetNumaNodeAffinity(pciAddress any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetNumaNodeAffinity", reflect.TypeOf((*MockInterface)(nil).GetNumaNodeAffinity), pciAddress)
+}
+
+// GetNumaNodeCPUs mocks base method.
+func (m *MockInterface) GetNumaNodeCPUs(numaNode string) (cpuset.CPUSet, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetNumaNodeCPUs", numaNode)
+	ret0, _ := ret[0].(cpuset.CPUSet)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetNumaNodeCPUs indicates an expected call of GetNumaNodeCPUs.
+func (mr *MockInterfaceMockRecorder) GetNumaNodeCPUs(numaNode any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetNumaNodeCPUs", reflect.TypeOf((*MockInterface)(nil).GetNumaNodeCPUs), numaNode)
+}
+
 // GetParentPciAddress mocks base method.
 func (m *MockInterface) GetParentPciAddress(pciAddress string) (string, error) {
 	m.ctrl.T.Helper()
@@ -172,6 +263,81 @@ func (mr *MockInterfaceMockRecorder) GetParentPciAddress(pciAddress any) *gomock
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetParentPciAddress", reflect.TypeOf((*MockInterface)(nil).GetParentPciAddress), pciAddress)
 }
 
+// GetPciRootPort mocks base method.
+func (m *MockInterface) GetPciRootPort(pciAddress string) (string, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetPciRootPort", pciAddress)
+	ret0, _ := ret[0].(string)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetPciRootPort indicates an expected call of GetPciRootPort.
+func (mr *MockInterfaceMockRecorder) GetPciRootPort(pciAddress any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetPciRootPort", reflect.TypeOf((*MockInterface)(nil).GetPciRootPort), pciAddress)
+}
+
+// GetPermanentMacAddress mocks base method.
+func (m *MockInterface) GetPermanentMacAddress(ifName string) (string, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetPermanentMacAddress", ifName)
+	ret0, _ := ret[0].(string)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetPermanentMacAddress indicates an expected call of GetPermanentMacAddress.
+func (mr *MockInterfaceMockRecorder) GetPermanentMacAddress(ifName any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetPermanentMacAddress", reflect.TypeOf((*MockInterface)(nil).GetPermanentMacAddress), ifName)
+}
+
+// GetSriovNumVFs mocks base method.
+func (m *MockInterface) GetSriovNumVFs(pfPciAddress string) (int, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetSriovNumVFs", pfPciAddress)
+	ret0, _ := ret[0].(int)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetSriovNumVFs indicates an expected call of GetSriovNumVFs.
+func (mr *MockInterfaceMockRecorder) GetSriovNumVFs(pfPciAddress any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetSriovNumVFs", reflect.TypeOf((*MockInterface)(nil).GetSriovNumVFs), pfPciAddress)
+}
+
+// GetSriovTotalVFs mocks base method.
+func (m *MockInterface) GetSriovTotalVFs(pfPciAddress string) (int, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetSriovTotalVFs", pfPciAddress)
+	ret0, _ := ret[0].(int)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetSriovTotalVFs indicates an expected call of GetSriovTotalVFs.
+func (mr *MockInterfaceMockRecorder) GetSriovTotalVFs(pfPciAddress any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetSriovTotalVFs", reflect.TypeOf((*MockInterface)(nil).GetSriovTotalVFs), pfPciAddress)
+}
+
+// GetSriovVFTotalMsix mocks base method.
+func (m *MockInterface) GetSriovVFTotalMsix(pfPciAddress string) (int, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetSriovVFTotalMsix", pfPciAddress)
+	ret0, _ := ret[0].(int)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetSriovVFTotalMsix indicates an expected call of GetSriovVFTotalMsix.
+func (mr *MockInterfaceMockRecorder) GetSriovVFTotalMsix(pfPciAddress any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetSriovVFTotalMsix", reflect.TypeOf((*MockInterface)(nil).GetSriovVFTotalMsix), pfPciAddress)
+}
+
 // GetVFIODeviceFile mocks base method.
 func (m *MockInterface) GetVFIODeviceFile(pciAddress string) (string, string, error) {
 	m.ctrl.T.Helper()
@@ -188,6 +354,22 @@ func (mr *MockInterfaceMockRecorder) GetVFIODeviceFile(pciAddress any) *gomock.C
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetVFIODeviceFile", reflect.TypeOf((*MockInterface)(nil).GetVFIODeviceFile), pciAddress)
 }
 
+// GetVFIndex mocks base method.
+func (m *MockInterface) GetVFIndex(vfPciAddress string) (string, int, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetVFIndex", vfPciAddress)
+	ret0, _ := ret[0].(string)
+	ret1, _ := ret[1].(int)
+	ret2, _ := ret[2].(error)
+	return ret0, ret1, ret2
+}
+
+// GetVFIndex indicates an expected call of GetVFIndex.
+func (mr *MockInterfaceMockRecorder) GetVFIndex(vfPciAddress any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetVFIndex", reflect.TypeOf((*MockInterface)(nil).GetVFIndex), vfPciAddress)
+}
+
 // GetVFList mocks base method.
 func (m *MockInterface) GetVFList(pfPciAddress string) ([]host.VFInfo, error) {
 	m.ctrl.T.Helper()
@@ -203,6 +385,36 @@ func (mr *MockInterfaceMockRecorder) GetVFList(pfPciAddress any) *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetVFList", reflect.TypeOf((*MockInterface)(nil).GetVFList), pfPciAddress)
 }
 
+// GetVFRepresentor mocks base method.
+func (m *MockInterface) GetVFRepresentor(pfName string, vfIndex int) (string, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetVFRepresentor", pfName, vfIndex)
+	ret0, _ := ret[0].(string)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetVFRepresentor indicates an expected call of GetVFRepresentor.
+func (mr *MockInterfaceMockRecorder) GetVFRepresentor(pfName, vfIndex any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetVFRepresentor", reflect.TypeOf((*MockInterface)(nil).GetVFRepresentor), pfName, vfIndex)
+}
+
+// GetVFStats mocks base method.
+func (m *MockInterface) GetVFStats(vfPciAddress string) (*host.VFStats, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetVFStats", vfPciAddress)
+	ret0, _ := ret[0].(*host.VFStats)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetVFStats indicates an expected call of GetVFStats.
+func (mr *MockInterfaceMockRecorder) GetVFStats(vfPciAddress any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetVFStats", reflect.TypeOf((*MockInterface)(nil).GetVFStats), vfPciAddress)
+}
+
 // IsDpdkDriver mocks base method.
 func (m *MockInterface) IsDpdkDriver(driver string) bool {
 	m.ctrl.T.Helper()
@@ -217,6 +429,21 @@ func (mr *MockInterfaceMockRecorder) IsDpdkDriver(driver any) *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "IsDpdkDriver", reflect.TypeOf((*MockInterface)(nil).IsDpdkDriver), driver)
 }
 
+// IsHostNetworkNamespace mocks base method.
+func (m *MockInterface) IsHostNetworkNamespace(netnsPath string) (bool, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "IsHostNetworkNamespace", netnsPath)
+	ret0, _ := ret[0].(bool)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// IsHostNetworkNamespace indicates an expected call of IsHostNetworkNamespace.
+func (mr *MockInterfaceMockRecorder) IsHostNetworkNamespace(netnsPath any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "IsHostNetworkNamespace", reflect.TypeOf((*MockInterface)(nil).IsHostNetworkNamespace), netnsPath)
+}
+
 // IsKernelModuleLoaded mocks base method.
 func (m *MockInterface) IsKernelModuleLoaded(moduleName string) bool {
 	m.ctrl.T.Helper()
@@ -231,6 +458,21 @@ func (mr *MockInterfaceMockRecorder) IsKernelModuleLoaded(moduleName any) *gomoc
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "IsKernelModuleLoaded", reflect.TypeOf((*MockInterface)(nil).IsKernelModuleLoaded), moduleName)
 }
 
+// IsLinkUp mocks base method.
+func (m *MockInterface) IsLinkUp(ifName string) (bool, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "IsLinkUp", ifName)
+	ret0, _ := ret[0].(bool)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// IsLinkUp indicates an expected call of IsLinkUp.
+func (mr *MockInterfaceMockRecorder) IsLinkUp(ifName any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "IsLinkUp", reflect.TypeOf((*MockInterface)(nil).IsLinkUp), ifName)
+}
+
 // IsSriovPF mocks base method.
 func (m *MockInterface) IsSriovPF(pciAddress string) bool {
 	m.ctrl.T.Helper()
@@ -288,6 +530,34 @@ func (mr *MockInterfaceMockRecorder) PCI() *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "PCI", reflect.TypeOf((*MockInterface)(nil).PCI))
 }
 
+// PathExists mocks base method.
+func (m *MockInterface) PathExists(path string) bool {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "PathExists", path)
+	ret0, _ := ret[0].(bool)
+	return ret0
+}
+
+// PathExists indicates an expected call of PathExists.
+func (mr *MockInterfaceMockRecorder) PathExists(path any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "PathExists", reflect.TypeOf((*MockInterface)(nil).PathExists), path)
+}
+
+// ResetVF mocks base method.
+func (m *MockInterface) ResetVF(vfPciAddress string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ResetVF", vfPciAddress)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// ResetVF indicates an expected call of ResetVF.
+func (mr *MockInterfaceMockRecorder) ResetVF(vfPciAddress any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ResetVF", reflect.TypeOf((*MockInterface)(nil).ResetVF), vfPciAddress)
+}
+
 // RestoreDeviceDriver mocks base method.
 func (m *MockInterface) RestoreDeviceDriver(pciAddress, originalDriver string) error {
 	m.ctrl.T.Helper()
@@ -302,6 +572,91 @@ func (mr *MockInterfaceMockRecorder) RestoreDeviceDriver(pciAddress, originalDri
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RestoreDeviceDriver", reflect.TypeOf((*MockInterface)(nil).RestoreDeviceDriver), pciAddress, originalDriver)
 }
 
+// SetNicSriovMode mocks base method.
+func (m *MockInterface) SetNicSriovMode(pciAddr, mode string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SetNicSriovMode", pciAddr, mode)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SetNicSriovMode indicates an expected call of SetNicSriovMode.
+func (mr *MockInterfaceMockRecorder) SetNicSriovMode(pciAddr, mode any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetNicSriovMode", reflect.TypeOf((*MockInterface)(nil).SetNicSriovMode), pciAddr, mode)
+}
+
+// SetVFLinkState mocks base method.
+func (m *MockInterface) SetVFLinkState(vfPciAddress, linkState string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SetVFLinkState", vfPciAddress, linkState)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SetVFLinkState indicates an expected call of SetVFLinkState.
+func (mr *MockInterfaceMockRecorder) SetVFLinkState(vfPciAddress, linkState any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetVFLinkState", reflect.TypeOf((*MockInterface)(nil).SetVFLinkState), vfPciAddress, linkState)
+}
+
+// SetVFMacAddress mocks base method.
+func (m *MockInterface) SetVFMacAddress(vfPciAddress, macAddress string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SetVFMacAddress", vfPciAddress, macAddress)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SetVFMacAddress indicates an expected call of SetVFMacAddress.
+func (mr *MockInterfaceMockRecorder) SetVFMacAddress(vfPciAddress, macAddress any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetVFMacAddress", reflect.TypeOf((*MockInterface)(nil).SetVFMacAddress), vfPciAddress, macAddress)
+}
+
+// SetVFRate mocks base method.
+func (m *MockInterface) SetVFRate(vfPciAddress string, minTxRate, maxTxRate int) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SetVFRate", vfPciAddress, minTxRate, maxTxRate)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SetVFRate indicates an expected call of SetVFRate.
+func (mr *MockInterfaceMockRecorder) SetVFRate(vfPciAddress, minTxRate, maxTxRate any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetVFRate", reflect.TypeOf((*MockInterface)(nil).SetVFRate), vfPciAddress, minTxRate, maxTxRate)
+}
+
+// SetVFRepresentorMacAddress mocks base method.
+func (m *MockInterface) SetVFRepresentorMacAddress(vfPciAddress, macAddress string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SetVFRepresentorMacAddress", vfPciAddress, macAddress)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SetVFRepresentorMacAddress indicates an expected call of SetVFRepresentorMacAddress.
+func (mr *MockInterfaceMockRecorder) SetVFRepresentorMacAddress(vfPciAddress, macAddress any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetVFRepresentorMacAddress", reflect.TypeOf((*MockInterface)(nil).SetVFRepresentorMacAddress), vfPciAddress, macAddress)
+}
+
+// SubscribePCIEvents mocks base method.
+func (m *MockInterface) SubscribePCIEvents(ctx context.Context) (<-chan host.PCIEvent, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SubscribePCIEvents", ctx)
+	ret0, _ := ret[0].(<-chan host.PCIEvent)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// SubscribePCIEvents indicates an expected call of SubscribePCIEvents.
+func (mr *MockInterfaceMockRecorder) SubscribePCIEvents(ctx any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SubscribePCIEvents", reflect.TypeOf((*MockInterface)(nil).SubscribePCIEvents), ctx)
+}
+
 // TryGetInterfaceName mocks base method.
 func (m *MockInterface) TryGetInterfaceName(pciAddr string) string {
 	m.ctrl.T.Helper()
@@ -329,3 +684,17 @@ func (mr *MockInterfaceMockRecorder) UnbindDriverByBusAndDevice(device any) *gom
 	mr.mock.ctrl.T.Helper()
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UnbindDriverByBusAndDevice", reflect.TypeOf((*MockInterface)(nil).UnbindDriverByBusAndDevice), device)
 }
+
+// VerifyVFReset mocks base method.
+func (m *MockInterface) VerifyVFReset(vfPciAddress string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "VerifyVFReset", vfPciAddress)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// VerifyVFReset indicates an expected call of VerifyVFReset.
+func (mr *MockInterfaceMockRecorder) VerifyVFReset(vfPciAddress any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "VerifyVFReset", reflect.TypeOf((*MockInterface)(nil).VerifyVFReset), vfPciAddress)
+}
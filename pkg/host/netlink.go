@@ -0,0 +1,74 @@
+package host
+
+import (
+	"net"
+
+	"github.com/vishvananda/netlink"
+)
+
+// NetlinkLib wraps the netlink calls used by the host helpers so they can be faked in tests
+type NetlinkLib interface {
+	LinkByName(name string) (netlink.Link, error)
+	LinkList() ([]netlink.Link, error)
+	RouteListFiltered(family int, filter *netlink.Route, filterMask uint64) ([]netlink.Route, error)
+	LinkSetVfHardwareAddr(link netlink.Link, vf int, hwaddr net.HardwareAddr) error
+	LinkSetVfVlan(link netlink.Link, vf, vlan int) error
+	LinkSetVfRate(link netlink.Link, vf, minRate, maxRate int) error
+	LinkSetVfState(link netlink.Link, vf int, state uint32) error
+	DevLinkGetAllPortList() ([]*netlink.DevlinkPort, error)
+	DevlinkPortFnSet(bus, device string, portIndex uint32, fnAttrs netlink.DevlinkPortFnSetAttrs) error
+	DevLinkGetDeviceByName(bus, device string) (*netlink.DevlinkDevice, error)
+	DevLinkSetEswitchMode(dev *netlink.DevlinkDevice, newMode string) error
+}
+
+// netlinkLib implements NetlinkLib using the vishvananda/netlink library
+type netlinkLib struct{}
+
+// NewNetlinkLib returns the NetlinkLib talking to the kernel
+func NewNetlinkLib() NetlinkLib {
+	return &netlinkLib{}
+}
+
+func (n *netlinkLib) LinkByName(name string) (netlink.Link, error) {
+	return netlink.LinkByName(name)
+}
+
+func (n *netlinkLib) LinkList() ([]netlink.Link, error) {
+	return netlink.LinkList()
+}
+
+func (n *netlinkLib) RouteListFiltered(family int, filter *netlink.Route, filterMask uint64) ([]netlink.Route, error) {
+	return netlink.RouteListFiltered(family, filter, filterMask)
+}
+
+func (n *netlinkLib) LinkSetVfHardwareAddr(link netlink.Link, vf int, hwaddr net.HardwareAddr) error {
+	return netlink.LinkSetVfHardwareAddr(link, vf, hwaddr)
+}
+
+func (n *netlinkLib) LinkSetVfVlan(link netlink.Link, vf, vlan int) error {
+	return netlink.LinkSetVfVlan(link, vf, vlan)
+}
+
+func (n *netlinkLib) LinkSetVfRate(link netlink.Link, vf, minRate, maxRate int) error {
+	return netlink.LinkSetVfRate(link, vf, minRate, maxRate)
+}
+
+func (n *netlinkLib) LinkSetVfState(link netlink.Link, vf int, state uint32) error {
+	return netlink.LinkSetVfState(link, vf, state)
+}
+
+func (n *netlinkLib) DevLinkGetAllPortList() ([]*netlink.DevlinkPort, error) {
+	return netlink.DevLinkGetAllPortList()
+}
+
+func (n *netlinkLib) DevlinkPortFnSet(bus, device string, portIndex uint32, fnAttrs netlink.DevlinkPortFnSetAttrs) error {
+	return netlink.DevlinkPortFnSet(bus, device, portIndex, fnAttrs)
+}
+
+func (n *netlinkLib) DevLinkGetDeviceByName(bus, device string) (*netlink.DevlinkDevice, error) {
+	return netlink.DevLinkGetDeviceByName(bus, device)
+}
+
+func (n *netlinkLib) DevLinkSetEswitchMode(dev *netlink.DevlinkDevice, newMode string) error {
+	return netlink.DevLinkSetEswitchMode(dev, newMode)
+}
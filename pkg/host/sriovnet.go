@@ -0,0 +1,67 @@
+package host
+
+import (
+	"os"
+
+	"github.com/k8snetworkplumbingwg/sriovnet"
+	utilfs "github.com/k8snetworkplumbingwg/sriovnet/pkg/utils/filesystem"
+)
+
+// sriovnetEnabled makes TryGetInterfaceName and GetNicSriovMode ask sriovnet first, it is set on startup
+// before the helpers are used. The sysfs and devlink implementations stay the fallback.
+var sriovnetEnabled bool
+
+// EnableSriovnet makes TryGetInterfaceName and GetNicSriovMode use the uplink representor found by sriovnet,
+// which picks the right netdev of a switchdev PF among its representors. The reads of sriovnet honor RootDir
+// and the sysfs trace.
+func EnableSriovnet() {
+	utilfs.Fs = sysfsFs{}
+	sriovnetEnabled = true
+}
+
+// sriovnetUplink returns the uplink representor netdev of a switchdev PF, empty when sriovnet is disabled,
+// the device is a VF or the PF has no uplink representor
+func (h *Host) sriovnetUplink(pciAddr string) string {
+	if !sriovnetEnabled || h.IsSriovVF(pciAddr) {
+		return ""
+	}
+	uplink, err := sriovnet.GetUplinkRepresentor(pciAddr)
+	if err != nil {
+		h.log.V(2).Info("sriovnet found no uplink representor, using the sysfs and devlink helpers", "device", pciAddr, "error", err)
+		return ""
+	}
+	return uplink
+}
+
+// sysfsFs is the filesystem of sriovnet, it reads under RootDir through the traced helpers
+type sysfsFs struct {
+	utilfs.DefaultFs
+}
+
+func (sysfsFs) Stat(name string) (os.FileInfo, error) {
+	return stat(buildSysPath(name))
+}
+
+func (sysfsFs) Readlink(name string) (string, error) {
+	return readlink(buildSysPath(name))
+}
+
+func (sysfsFs) ReadFile(name string) ([]byte, error) {
+	return readFile(buildSysPath(name))
+}
+
+func (sysfsFs) ReadDir(name string) ([]os.FileInfo, error) {
+	entries, err := readDir(buildSysPath(name))
+	if err != nil {
+		return nil, err
+	}
+	infos := make([]os.FileInfo, 0, len(entries))
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil {
+			return nil, err
+		}
+		infos = append(infos, info)
+	}
+	return infos, nil
+}
@@ -0,0 +1,74 @@
+package host
+
+import (
+	"os"
+	"path/filepath"
+
+	"k8s.io/klog/v2"
+)
+
+// sysfsTraceEnabled makes the helpers log every sysfs and procfs path they read with the raw content,
+// it is set on startup before the helpers are used. A disabled trace costs the reads a single boolean check.
+var (
+	sysfsTraceEnabled bool
+	sysfsTraceLogger  klog.Logger
+)
+
+// EnableSysfsTrace logs every sysfs and procfs path read by the helpers with its raw content at V(5),
+// to diagnose the discovery on unusual hardware. The reads done by ghw for PCI() are not traced.
+func EnableSysfsTrace(logger klog.Logger) {
+	sysfsTraceLogger = logger.WithName("SysfsTrace")
+	sysfsTraceEnabled = true
+}
+
+func readFile(path string) ([]byte, error) {
+	content, err := os.ReadFile(path)
+	if sysfsTraceEnabled {
+		sysfsTraceLogger.V(5).Info("Read file", "path", path, "content", string(content), "error", err)
+	}
+	return content, err
+}
+
+func readlink(path string) (string, error) {
+	target, err := os.Readlink(path)
+	if sysfsTraceEnabled {
+		sysfsTraceLogger.V(5).Info("Read link", "path", path, "target", target, "error", err)
+	}
+	return target, err
+}
+
+func evalSymlinks(path string) (string, error) {
+	target, err := filepath.EvalSymlinks(path)
+	if sysfsTraceEnabled {
+		sysfsTraceLogger.V(5).Info("Resolved symlinks", "path", path, "target", target, "error", err)
+	}
+	return target, err
+}
+
+func readDir(path string) ([]os.DirEntry, error) {
+	entries, err := os.ReadDir(path)
+	if sysfsTraceEnabled {
+		names := make([]string, 0, len(entries))
+		for _, entry := range entries {
+			names = append(names, entry.Name())
+		}
+		sysfsTraceLogger.V(5).Info("Read directory", "path", path, "entries", names, "error", err)
+	}
+	return entries, err
+}
+
+func stat(path string) (os.FileInfo, error) {
+	info, err := os.Stat(path)
+	if sysfsTraceEnabled {
+		sysfsTraceLogger.V(5).Info("Stat", "path", path, "exists", err == nil, "error", err)
+	}
+	return info, err
+}
+
+func lstat(path string) (os.FileInfo, error) {
+	info, err := os.Lstat(path)
+	if sysfsTraceEnabled {
+		sysfsTraceLogger.V(5).Info("Lstat", "path", path, "exists", err == nil, "error", err)
+	}
+	return info, err
+}
@@ -0,0 +1,75 @@
+package host_test
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"k8s.io/klog/v2/ktesting"
+
+	"github.com/SchSeba/dra-driver-sriov/pkg/host"
+)
+
+var _ = Describe("Sysfs trace", func() {
+	var (
+		h  host.Interface
+		fs *host.FakeFilesystem
+	)
+
+	BeforeEach(func() {
+		h = host.NewHost()
+		fs = &host.FakeFilesystem{
+			Dirs: []string{
+				"sys/bus/pci/devices/0000:01:00.0",
+				"sys/bus/pci/devices/0000:01:00.1",
+			},
+			Files: map[string][]byte{
+				"sys/bus/pci/devices/0000:01:00.0/sriov_numvfs": []byte("1\n"),
+				"sys/bus/pci/devices/0000:01:00.1/device":       []byte("0x1016"),
+			},
+			Symlinks: map[string]string{
+				"sys/bus/pci/devices/0000:01:00.0/virtfn0": "../0000:01:00.1",
+				"sys/bus/pci/devices/0000:01:00.1/physfn":  "../0000:01:00.0",
+			},
+		}
+		DeferCleanup(fs.Use())
+	})
+
+	// discover reads the PF and VF information like the device discovery
+	discover := func() {
+		Expect(h.IsSriovVF("0000:01:00.0")).To(BeFalse())
+		Expect(h.GetSriovNumVFs("0000:01:00.0")).To(Equal(1))
+		_, err := h.GetVFList("0000:01:00.0")
+		Expect(err).NotTo(HaveOccurred())
+	}
+
+	It("should log the sysfs paths read during the discovery with their content", func() {
+		logger := ktesting.NewLogger(ktesting.NopTL{}, ktesting.NewConfig(ktesting.BufferLogs(true), ktesting.Verbosity(5)))
+		DeferCleanup(host.SetSysfsTrace(logger))
+
+		discover()
+
+		logs := logger.GetSink().(ktesting.Underlier).GetBuffer().String()
+		Expect(logs).To(ContainSubstring(`Lstat path="` + fs.RootDir + `/sys/bus/pci/devices/0000:01:00.0/physfn" exists=false`))
+		Expect(logs).To(MatchRegexp(`Read file path=".*/sys/bus/pci/devices/0000:01:00.0/sriov_numvfs" content=<\s+1\s`))
+		Expect(logs).To(MatchRegexp(`Read directory path=".*/sys/bus/pci/devices/0000:01:00.0" entries=\["sriov_numvfs","virtfn0"\]`))
+		Expect(logs).To(MatchRegexp(`Read link path=".*/sys/bus/pci/devices/0000:01:00.0/virtfn0" target="../0000:01:00.1"`))
+		Expect(logs).To(MatchRegexp(`Read file path=".*/sys/bus/pci/devices/0000:01:00.1/device" content="0x1016"`))
+	})
+
+	It("should not log the sysfs reads below V(5)", func() {
+		logger := ktesting.NewLogger(ktesting.NopTL{}, ktesting.NewConfig(ktesting.BufferLogs(true), ktesting.Verbosity(4)))
+		DeferCleanup(host.SetSysfsTrace(logger))
+
+		discover()
+
+		Expect(logger.GetSink().(ktesting.Underlier).GetBuffer().String()).To(BeEmpty())
+	})
+
+	It("should not log the sysfs reads when disabled", func() {
+		logger := ktesting.NewLogger(ktesting.NopTL{}, ktesting.NewConfig(ktesting.BufferLogs(true), ktesting.Verbosity(5)))
+		host.SetSysfsTrace(logger)()
+
+		discover()
+
+		Expect(logger.GetSink().(ktesting.Underlier).GetBuffer().String()).To(BeEmpty())
+	})
+})
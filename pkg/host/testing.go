@@ -2,8 +2,14 @@ package host
 
 import (
 	"fmt"
+	"maps"
+	"net"
 	"os"
 	"path"
+	"slices"
+
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
 )
 
 // FakeFilesystem allows to setup isolated fake files structure used for the tests.
@@ -65,3 +71,118 @@ func (fs *FakeFilesystem) Use() func() {
 		}
 	}
 }
+
+// FakeNetlink is a NetlinkLib keeping the links in memory, used for the tests.
+type FakeNetlink struct {
+	Links        map[string]*netlink.Device
+	DevlinkPorts []*netlink.DevlinkPort
+	// DevlinkDevices are the devlink devices of the PFs, holding their eswitch mode
+	DevlinkDevices []*netlink.DevlinkDevice
+	// IgnoreVfWrites makes the VF configuration calls succeed without changing the links,
+	// like a driver silently ignoring them
+	IgnoreVfWrites bool
+	// VfLinkStateErr is returned by LinkSetVfState, like a driver without the VF link state ndo
+	VfLinkStateErr error
+	// Routes is the route table, the routes are returned unfiltered
+	Routes []netlink.Route
+}
+
+func (f *FakeNetlink) LinkByName(name string) (netlink.Link, error) {
+	link, ok := f.Links[name]
+	if !ok {
+		return nil, fmt.Errorf("link %s not found", name)
+	}
+	return link, nil
+}
+
+// LinkList returns the links sorted by name
+func (f *FakeNetlink) LinkList() ([]netlink.Link, error) {
+	links := []netlink.Link{}
+	for _, name := range slices.Sorted(maps.Keys(f.Links)) {
+		links = append(links, f.Links[name])
+	}
+	return links, nil
+}
+
+func (f *FakeNetlink) RouteListFiltered(_ int, _ *netlink.Route, _ uint64) ([]netlink.Route, error) {
+	return f.Routes, nil
+}
+
+func (f *FakeNetlink) LinkSetVfHardwareAddr(link netlink.Link, vf int, hwaddr net.HardwareAddr) error {
+	return f.setVf(link, vf, func(vfInfo *netlink.VfInfo) {
+		vfInfo.Mac = hwaddr
+	})
+}
+
+func (f *FakeNetlink) LinkSetVfVlan(link netlink.Link, vf, vlan int) error {
+	return f.setVf(link, vf, func(vfInfo *netlink.VfInfo) {
+		vfInfo.Vlan = vlan
+	})
+}
+
+func (f *FakeNetlink) LinkSetVfRate(link netlink.Link, vf, minRate, maxRate int) error {
+	return f.setVf(link, vf, func(vfInfo *netlink.VfInfo) {
+		vfInfo.MinTxRate = uint32(minRate)
+		vfInfo.MaxTxRate = uint32(maxRate)
+	})
+}
+
+func (f *FakeNetlink) LinkSetVfState(link netlink.Link, vf int, state uint32) error {
+	if f.VfLinkStateErr != nil {
+		return f.VfLinkStateErr
+	}
+	return f.setVf(link, vf, func(vfInfo *netlink.VfInfo) {
+		vfInfo.LinkState = state
+	})
+}
+
+func (f *FakeNetlink) setVf(link netlink.Link, vf int, set func(vfInfo *netlink.VfInfo)) error {
+	vfs := link.Attrs().Vfs
+	for i := range vfs {
+		if vfs[i].ID != vf {
+			continue
+		}
+		if !f.IgnoreVfWrites {
+			set(&vfs[i])
+		}
+		return nil
+	}
+	return fmt.Errorf("VF %d not found on link %s", vf, link.Attrs().Name)
+}
+
+func (f *FakeNetlink) DevLinkGetAllPortList() ([]*netlink.DevlinkPort, error) {
+	return f.DevlinkPorts, nil
+}
+
+func (f *FakeNetlink) DevlinkPortFnSet(bus, device string, portIndex uint32, fnAttrs netlink.DevlinkPortFnSetAttrs) error {
+	for _, port := range f.DevlinkPorts {
+		if port.BusName != bus || port.DeviceName != device || port.PortIndex != portIndex {
+			continue
+		}
+		if port.Fn == nil {
+			port.Fn = &netlink.DevlinkPortFn{}
+		}
+		if fnAttrs.HwAddrValid {
+			port.Fn.HwAddr = fnAttrs.FnAttrs.HwAddr
+		}
+		if fnAttrs.StateValid {
+			port.Fn.State = fnAttrs.FnAttrs.State
+		}
+		return nil
+	}
+	return fmt.Errorf("devlink port %s/%s/%d not found", bus, device, portIndex)
+}
+
+func (f *FakeNetlink) DevLinkGetDeviceByName(bus, device string) (*netlink.DevlinkDevice, error) {
+	for _, dev := range f.DevlinkDevices {
+		if dev.BusName == bus && dev.DeviceName == device {
+			return dev, nil
+		}
+	}
+	return nil, fmt.Errorf("devlink device %s/%s not found: %w", bus, device, unix.ENODEV)
+}
+
+func (f *FakeNetlink) DevLinkSetEswitchMode(dev *netlink.DevlinkDevice, newMode string) error {
+	dev.Attrs.Eswitch.Mode = newMode
+	return nil
+}
@@ -0,0 +1,104 @@
+package host
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"golang.org/x/sys/unix"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// PCIEventAdd is the action of a PCI device added to the host
+	PCIEventAdd = "add"
+	// PCIEventRemove is the action of a PCI device removed from the host
+	PCIEventRemove = "remove"
+
+	// ueventBufferSize bounds the size of a single kernel uevent message
+	ueventBufferSize = 16 * 1024
+	// ueventReadTimeoutSec is the interval at which the uevent reader checks its context
+	ueventReadTimeoutSec = 1
+	// pciClassNetwork is the PCI base class of the network controllers
+	pciClassNetwork = 0x02
+)
+
+// PCIEvent is a network PCI device added to or removed from the host
+type PCIEvent struct {
+	Action     string
+	PciAddress string
+}
+
+// SubscribePCIEvents listens to the kernel uevents and returns the add and remove events of the network PCI devices,
+// e.g. a NIC hot-plugged or a PF enabled. The channel is closed when the context is done or the socket fails.
+func (h *Host) SubscribePCIEvents(ctx context.Context) (<-chan PCIEvent, error) {
+	fd, err := unix.Socket(unix.AF_NETLINK, unix.SOCK_RAW|unix.SOCK_CLOEXEC, unix.NETLINK_KOBJECT_UEVENT)
+	if err != nil {
+		return nil, fmt.Errorf("error opening the uevent socket: %w", err)
+	}
+	// group 1 receives the kernel events, group 2 the events re-broadcasted by udev
+	if err := unix.Bind(fd, &unix.SockaddrNetlink{Family: unix.AF_NETLINK, Groups: 1}); err != nil {
+		unix.Close(fd)
+		return nil, fmt.Errorf("error binding the uevent socket: %w", err)
+	}
+	// the reads time out so the reader notices the context is done
+	if err := unix.SetsockoptTimeval(fd, unix.SOL_SOCKET, unix.SO_RCVTIMEO, &unix.Timeval{Sec: ueventReadTimeoutSec}); err != nil {
+		unix.Close(fd)
+		return nil, fmt.Errorf("error setting the uevent socket read timeout: %w", err)
+	}
+
+	events := make(chan PCIEvent)
+	go func() {
+		defer close(events)
+		defer unix.Close(fd)
+		logger := klog.FromContext(ctx).WithName("SubscribePCIEvents")
+
+		buf := make([]byte, ueventBufferSize)
+		for ctx.Err() == nil {
+			n, _, err := unix.Recvfrom(fd, buf, 0)
+			if err != nil {
+				if errors.Is(err, unix.EAGAIN) || errors.Is(err, unix.EINTR) {
+					continue
+				}
+				logger.Error(err, "Failed to read the uevent socket")
+				return
+			}
+			event, ok := ParsePCIUevent(buf[:n])
+			if !ok {
+				continue
+			}
+			select {
+			case events <- event:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return events, nil
+}
+
+// ParsePCIUevent returns the event of a kernel uevent message adding or removing a network PCI device.
+// The message is the action@devpath header followed by NUL separated KEY=value fields,
+// false is returned for the other messages.
+func ParsePCIUevent(msg []byte) (PCIEvent, bool) {
+	fields := map[string]string{}
+	for _, field := range bytes.Split(msg, []byte{0}) {
+		key, value, found := bytes.Cut(field, []byte("="))
+		if found {
+			fields[string(key)] = string(value)
+		}
+	}
+	if fields["SUBSYSTEM"] != "pci" || fields["PCI_SLOT_NAME"] == "" {
+		return PCIEvent{}, false
+	}
+	if fields["ACTION"] != PCIEventAdd && fields["ACTION"] != PCIEventRemove {
+		return PCIEvent{}, false
+	}
+	class, err := strconv.ParseUint(fields["PCI_CLASS"], 16, 32)
+	if err != nil || class>>16 != pciClassNetwork {
+		return PCIEvent{}, false
+	}
+	return PCIEvent{Action: fields["ACTION"], PciAddress: fields["PCI_SLOT_NAME"]}, true
+}
@@ -0,0 +1,48 @@
+package host_test
+
+import (
+	"strings"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/SchSeba/dra-driver-sriov/pkg/host"
+)
+
+// uevent builds a kernel uevent message from its header and fields
+func uevent(header string, fields ...string) []byte {
+	return []byte(strings.Join(append([]string{header}, fields...), "\x00") + "\x00")
+}
+
+var _ = Describe("ParsePCIUevent", func() {
+	It("should return the added network PCI device", func() {
+		event, ok := host.ParsePCIUevent(uevent("add@/devices/pci0000:00/0000:00:01.0/0000:01:00.0",
+			"ACTION=add", "DEVPATH=/devices/pci0000:00/0000:00:01.0/0000:01:00.0", "SUBSYSTEM=pci",
+			"PCI_CLASS=20000", "PCI_SLOT_NAME=0000:01:00.0", "SEQNUM=4242"))
+		Expect(ok).To(BeTrue())
+		Expect(event).To(Equal(host.PCIEvent{Action: host.PCIEventAdd, PciAddress: "0000:01:00.0"}))
+	})
+
+	It("should return the removed network PCI device", func() {
+		event, ok := host.ParsePCIUevent(uevent("remove@/devices/pci0000:00/0000:00:01.0/0000:01:00.0",
+			"ACTION=remove", "SUBSYSTEM=pci", "PCI_CLASS=20000", "PCI_SLOT_NAME=0000:01:00.0"))
+		Expect(ok).To(BeTrue())
+		Expect(event.Action).To(Equal(host.PCIEventRemove))
+	})
+
+	It("should ignore the devices that are not network controllers", func() {
+		_, ok := host.ParsePCIUevent(uevent("add@/devices/pci0000:00/0000:00:17.0",
+			"ACTION=add", "SUBSYSTEM=pci", "PCI_CLASS=10601", "PCI_SLOT_NAME=0000:00:17.0"))
+		Expect(ok).To(BeFalse())
+	})
+
+	It("should ignore the other subsystems and actions", func() {
+		_, ok := host.ParsePCIUevent(uevent("add@/devices/virtual/net/eth0",
+			"ACTION=add", "SUBSYSTEM=net", "INTERFACE=eth0"))
+		Expect(ok).To(BeFalse())
+
+		_, ok = host.ParsePCIUevent(uevent("bind@/devices/pci0000:00/0000:00:01.0/0000:01:00.0",
+			"ACTION=bind", "SUBSYSTEM=pci", "PCI_CLASS=20000", "PCI_SLOT_NAME=0000:01:00.0", "DRIVER=ice"))
+		Expect(ok).To(BeFalse())
+	})
+})
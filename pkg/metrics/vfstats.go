@@ -0,0 +1,140 @@
+// Package metrics exposes the traffic statistics of the prepared VFs as Prometheus gauges.
+package metrics
+
+import (
+	"context"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/klog/v2"
+
+	"github.com/SchSeba/dra-driver-sriov/pkg/consts"
+	"github.com/SchSeba/dra-driver-sriov/pkg/devicestate"
+	"github.com/SchSeba/dra-driver-sriov/pkg/host"
+	"github.com/SchSeba/dra-driver-sriov/pkg/podmanager"
+)
+
+const (
+	namespace = "sriov_dra"
+	subsystem = "vf"
+
+	labelDevice = "device"
+	labelPF     = "pf"
+)
+
+// vfLabels identifies the series of a VF
+type vfLabels struct {
+	device string
+	pf     string
+}
+
+// VFStatsCollector periodically reads the traffic counters of the prepared VFs
+// and sets them on gauges labeled by device name and PF
+type VFStatsCollector struct {
+	deviceStateManager *devicestate.Manager
+	podManager         *podmanager.PodManager
+
+	rxPackets *prometheus.GaugeVec
+	txPackets *prometheus.GaugeVec
+	rxBytes   *prometheus.GaugeVec
+	txBytes   *prometheus.GaugeVec
+	rxDropped *prometheus.GaugeVec
+	txDropped *prometheus.GaugeVec
+
+	// collected are the VFs having series, the ones no longer prepared are deleted on the next refresh
+	collected sets.Set[vfLabels]
+}
+
+// NewVFStatsCollector creates the VF statistics gauges and registers them
+func NewVFStatsCollector(registerer prometheus.Registerer, deviceStateManager *devicestate.Manager, podManager *podmanager.PodManager) (*VFStatsCollector, error) {
+	newGauge := func(name, help string) *prometheus.GaugeVec {
+		return prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      name,
+			Help:      help,
+		}, []string{labelDevice, labelPF})
+	}
+	c := &VFStatsCollector{
+		deviceStateManager: deviceStateManager,
+		podManager:         podManager,
+		rxPackets:          newGauge("rx_packets", "Packets received by the prepared VF."),
+		txPackets:          newGauge("tx_packets", "Packets transmitted by the prepared VF."),
+		rxBytes:            newGauge("rx_bytes", "Bytes received by the prepared VF."),
+		txBytes:            newGauge("tx_bytes", "Bytes transmitted by the prepared VF."),
+		rxDropped:          newGauge("rx_dropped", "Received packets dropped by the prepared VF."),
+		txDropped:          newGauge("tx_dropped", "Transmitted packets dropped by the prepared VF."),
+		collected:          sets.New[vfLabels](),
+	}
+
+	for _, gauge := range c.gauges() {
+		if err := registerer.Register(gauge); err != nil {
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// Run refreshes the VF statistics every interval until the context is done
+func (c *VFStatsCollector) Run(ctx context.Context, interval time.Duration) {
+	wait.UntilWithContext(ctx, c.Refresh, interval)
+}
+
+// Refresh reads the statistics of the currently prepared VFs and deletes the series of the VFs unprepared since the last refresh.
+// A VF whose statistics can't be read keeps no series until the next refresh.
+func (c *VFStatsCollector) Refresh(ctx context.Context) {
+	logger := klog.FromContext(ctx).WithName("VFStatsCollector")
+
+	prepared := map[string]vfLabels{}
+	for _, preparedDevicesByClaimID := range c.podManager.Dump() {
+		for _, preparedDevices := range preparedDevicesByClaimID {
+			for _, preparedDevice := range preparedDevices {
+				prepared[preparedDevice.PciAddress] = vfLabels{
+					device: preparedDevice.Device.DeviceName,
+					pf:     c.pfName(preparedDevice.Device.DeviceName),
+				}
+			}
+		}
+	}
+
+	collected := sets.New[vfLabels]()
+	for pciAddress, labels := range prepared {
+		stats, err := host.GetHelpers().GetVFStats(pciAddress)
+		if err != nil {
+			logger.V(2).Info("Failed to read the VF statistics", "device", labels.device, "pciAddress", pciAddress, "error", err)
+			continue
+		}
+		c.rxPackets.WithLabelValues(labels.device, labels.pf).Set(float64(stats.RxPackets))
+		c.txPackets.WithLabelValues(labels.device, labels.pf).Set(float64(stats.TxPackets))
+		c.rxBytes.WithLabelValues(labels.device, labels.pf).Set(float64(stats.RxBytes))
+		c.txBytes.WithLabelValues(labels.device, labels.pf).Set(float64(stats.TxBytes))
+		c.rxDropped.WithLabelValues(labels.device, labels.pf).Set(float64(stats.RxDropped))
+		c.txDropped.WithLabelValues(labels.device, labels.pf).Set(float64(stats.TxDropped))
+		collected.Insert(labels)
+	}
+
+	for labels := range c.collected.Difference(collected) {
+		for _, gauge := range c.gauges() {
+			gauge.DeleteLabelValues(labels.device, labels.pf)
+		}
+	}
+	c.collected = collected
+}
+
+// pfName returns the name of the PF of the published device, empty when it is unknown
+func (c *VFStatsCollector) pfName(deviceName string) string {
+	device, found := c.deviceStateManager.GetAllocatedDeviceByDeviceName(deviceName)
+	if !found {
+		return ""
+	}
+	if pfName := device.Attributes[consts.AttributePFName].StringValue; pfName != nil {
+		return *pfName
+	}
+	return ""
+}
+
+func (c *VFStatsCollector) gauges() []*prometheus.GaugeVec {
+	return []*prometheus.GaugeVec{c.rxPackets, c.txPackets, c.rxBytes, c.txBytes, c.rxDropped, c.txDropped}
+}
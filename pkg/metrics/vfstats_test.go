@@ -0,0 +1,154 @@
+package metrics_test
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+
+	"github.com/jaypipes/ghw"
+	"github.com/jaypipes/ghw/pkg/pci"
+	"github.com/jaypipes/pcidb"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/mock/gomock"
+	drapbv1 "k8s.io/kubelet/pkg/apis/dra/v1beta1"
+
+	"github.com/SchSeba/dra-driver-sriov/pkg/cdi"
+	"github.com/SchSeba/dra-driver-sriov/pkg/devicestate"
+	"github.com/SchSeba/dra-driver-sriov/pkg/host"
+	mock_host "github.com/SchSeba/dra-driver-sriov/pkg/host/mock"
+	"github.com/SchSeba/dra-driver-sriov/pkg/metrics"
+	"github.com/SchSeba/dra-driver-sriov/pkg/podmanager"
+	draTypes "github.com/SchSeba/dra-driver-sriov/pkg/types"
+)
+
+var _ = Describe("VFStatsCollector", func() {
+	var (
+		tempDir      string
+		mockCtrl     *gomock.Controller
+		mockHost     *mock_host.MockInterface
+		originalHost host.Interface
+		registry     *prometheus.Registry
+		podManager   *podmanager.PodManager
+		collector    *metrics.VFStatsCollector
+	)
+
+	BeforeEach(func() {
+		var err error
+		tempDir, err = os.MkdirTemp("", "metrics-test-*")
+		Expect(err).NotTo(HaveOccurred())
+
+		mockCtrl = gomock.NewController(GinkgoT())
+		mockHost = mock_host.NewMockInterface(mockCtrl)
+		originalHost = host.GetHelpers()
+		host.Helpers = mockHost
+
+		mockHost.EXPECT().PCI().Return(&ghw.PCIInfo{
+			Devices: []*pci.Device{{
+				Address: "0000:01:00.0",
+				Vendor:  &pcidb.Vendor{ID: "8086"},
+				Product: &pcidb.Product{ID: "158b"},
+				Class:   &pcidb.Class{ID: "02"},
+			}},
+		}, nil)
+		mockHost.EXPECT().IsSriovVF("0000:01:00.0").Return(false)
+		mockHost.EXPECT().TryGetInterfaceName("0000:01:00.0").Return("eth0")
+		mockHost.EXPECT().GetNicSriovMode("0000:01:00.0").Return("legacy")
+		mockHost.EXPECT().GetSriovNumVFs("0000:01:00.0").Return(2, nil)
+		mockHost.EXPECT().GetSriovTotalVFs("0000:01:00.0").Return(64, nil)
+		mockHost.EXPECT().GetSriovVFTotalMsix("0000:01:00.0").Return(0, fs.ErrNotExist)
+		mockHost.EXPECT().GetPermanentMacAddress("eth0").Return("aa:bb:cc:dd:ee:01", nil)
+		mockHost.EXPECT().IsLinkUp("eth0").Return(true, nil)
+		mockHost.EXPECT().GetBondMaster("eth0").Return("", nil)
+		mockHost.EXPECT().GetNumaNode("0000:01:00.0").Return("0", nil)
+		mockHost.EXPECT().GetParentPciAddress("0000:01:00.0").Return("0000:00:01.0", nil)
+		mockHost.EXPECT().GetPciRootPort("0000:01:00.0").Return("0000:00:01.0", nil)
+		mockHost.EXPECT().GetEswitchMode("0000:01:00.0").Return("switchdev", nil)
+		mockHost.EXPECT().GetDriverByBusAndDevice("0000:01:00.0").Return("ice", nil)
+		mockHost.EXPECT().GetVFList("0000:01:00.0").Return([]host.VFInfo{
+			{PciAddress: "0000:01:00.1", VFID: 0, DeviceID: "154c"},
+			{PciAddress: "0000:01:00.2", VFID: 1, DeviceID: "154c"},
+		}, nil)
+
+		config := &draTypes.Config{
+			Flags: &draTypes.Flags{
+				KubeletPluginsDirectoryPath: tempDir,
+				DefaultInterfacePrefix:      "net",
+			},
+		}
+		cdiHandler, err := cdi.NewHandler(tempDir, cdi.DefaultVendor)
+		Expect(err).NotTo(HaveOccurred())
+		deviceStateManager, err := devicestate.NewManager(context.Background(), config, cdiHandler)
+		Expect(err).NotTo(HaveOccurred())
+		podManager, err = podmanager.NewPodManager(config)
+		Expect(err).NotTo(HaveOccurred())
+
+		registry = prometheus.NewRegistry()
+		collector, err = metrics.NewVFStatsCollector(registry, deviceStateManager, podManager)
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(podManager.Set("pod-uid", "claim-uid", draTypes.PreparedDevices{
+			{Device: drapbv1.Device{DeviceName: "0000-01-00-1"}, PciAddress: "0000:01:00.1"},
+		})).To(Succeed())
+	})
+
+	AfterEach(func() {
+		host.Helpers = originalHost
+		mockCtrl.Finish()
+		os.RemoveAll(tempDir)
+	})
+
+	// gaugeValues returns the values of the gauge indexed by the device and PF labels
+	gaugeValues := func(name string) map[string]float64 {
+		families, err := registry.Gather()
+		Expect(err).NotTo(HaveOccurred())
+		values := map[string]float64{}
+		for _, family := range families {
+			if family.GetName() != name {
+				continue
+			}
+			for _, metric := range family.GetMetric() {
+				labels := map[string]string{}
+				for _, label := range metric.GetLabel() {
+					labels[label.GetName()] = label.GetValue()
+				}
+				values[labels["device"]+"/"+labels["pf"]] = metric.GetGauge().GetValue()
+			}
+		}
+		return values
+	}
+
+	It("should set the gauges of the prepared VFs only", func() {
+		mockHost.EXPECT().GetVFStats("0000:01:00.1").Return(&host.VFStats{
+			RxPackets: 10, TxPackets: 20, RxBytes: 1000, TxBytes: 2000, RxDropped: 1, TxDropped: 2,
+		}, nil)
+
+		collector.Refresh(context.Background())
+
+		Expect(gaugeValues("sriov_dra_vf_rx_packets")).To(Equal(map[string]float64{"0000-01-00-1/eth0": 10}))
+		Expect(gaugeValues("sriov_dra_vf_tx_packets")).To(Equal(map[string]float64{"0000-01-00-1/eth0": 20}))
+		Expect(gaugeValues("sriov_dra_vf_rx_bytes")).To(Equal(map[string]float64{"0000-01-00-1/eth0": 1000}))
+		Expect(gaugeValues("sriov_dra_vf_tx_bytes")).To(Equal(map[string]float64{"0000-01-00-1/eth0": 2000}))
+		Expect(gaugeValues("sriov_dra_vf_rx_dropped")).To(Equal(map[string]float64{"0000-01-00-1/eth0": 1}))
+		Expect(gaugeValues("sriov_dra_vf_tx_dropped")).To(Equal(map[string]float64{"0000-01-00-1/eth0": 2}))
+	})
+
+	It("should delete the gauges of the VFs unprepared since the last refresh", func() {
+		mockHost.EXPECT().GetVFStats("0000:01:00.1").Return(&host.VFStats{RxPackets: 10}, nil)
+		collector.Refresh(context.Background())
+		Expect(gaugeValues("sriov_dra_vf_rx_packets")).To(HaveLen(1))
+
+		Expect(podManager.DeletePod("pod-uid")).To(Succeed())
+		collector.Refresh(context.Background())
+		Expect(gaugeValues("sriov_dra_vf_rx_packets")).To(BeEmpty())
+	})
+
+	It("should skip the VFs whose statistics can't be read", func() {
+		mockHost.EXPECT().GetVFStats("0000:01:00.1").Return(nil, fmt.Errorf("link eth0 not found"))
+
+		collector.Refresh(context.Background())
+		Expect(gaugeValues("sriov_dra_vf_rx_packets")).To(BeEmpty())
+	})
+})
@@ -0,0 +1,57 @@
+package nri
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/containerd/nri/pkg/api"
+	k8stypes "k8s.io/apimachinery/pkg/types"
+	"k8s.io/klog/v2"
+
+	"github.com/SchSeba/dra-driver-sriov/pkg/host"
+)
+
+// Drain detaches the networks of every device tracked by the pod manager and resets the VFs.
+// The prepared state and the CDI spec files are left untouched so kubelet can still unprepare the claims.
+// Errors are collected so a single failing device doesn't prevent the rest from being drained.
+func (p *Plugin) Drain(ctx context.Context) error {
+	logger := klog.FromContext(ctx).WithName("NRI Drain")
+
+	var errs []error
+	for _, podUID := range p.podManager.GetPodUIDs() {
+		devices, found := p.podManager.GetDevicesByPodUID(podUID)
+		if !found {
+			continue
+		}
+
+		pod := p.sandboxForPod(podUID)
+		networkNamespace := getNetworkNamespace(pod, p.netnsResolution)
+
+		for _, device := range devices {
+			logger.Info("Draining device", "deviceName", device.Device.DeviceName, "pciAddress", device.PciAddress, "pod.UID", podUID)
+			if err := p.cniRuntime.DetachNetwork(ctx, pod, networkNamespace, device); err != nil {
+				logger.Error(err, "Failed to detach network", "deviceName", device.Device.DeviceName, "pod.UID", podUID)
+				errs = append(errs, fmt.Errorf("failed to detach network for device %s: %w", device.Device.DeviceName, err))
+			}
+			if err := host.GetHelpers().ResetVF(device.PciAddress); err != nil {
+				logger.Error(err, "Failed to reset VF", "pciAddress", device.PciAddress, "pod.UID", podUID)
+				errs = append(errs, fmt.Errorf("failed to reset VF %s: %w", device.PciAddress, err))
+			}
+		}
+		p.podManager.ClearAttached(podUID)
+	}
+
+	return errors.Join(errs...)
+}
+
+// sandboxForPod returns the sandbox seen by RunPodSandbox for the pod. When the sandbox was created before the driver
+// started, a sandbox without network namespace is returned and CNI DEL is best effort.
+func (p *Plugin) sandboxForPod(podUID k8stypes.UID) *api.PodSandbox {
+	p.sandboxesMu.Lock()
+	defer p.sandboxesMu.Unlock()
+	if pod, found := p.sandboxes[string(podUID)]; found {
+		return pod
+	}
+	return &api.PodSandbox{Uid: string(podUID)}
+}
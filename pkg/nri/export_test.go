@@ -0,0 +1,35 @@
+package nri
+
+import (
+	"context"
+	stdnet "net"
+
+	"github.com/containerd/nri/pkg/stub"
+)
+
+// ProcessPendingNetworkDeviceData runs the queued network device data updates synchronously,
+// replacing the updateNetworkDeviceDataRunner goroutine in tests.
+func (p *Plugin) ProcessPendingNetworkDeviceData(ctx context.Context) {
+	for {
+		select {
+		case networkDeviceDataList := <-p.networkDeviceDataUpdateChan:
+			p.updateNetworkDeviceData(ctx, networkDeviceDataList)
+		default:
+			return
+		}
+	}
+}
+
+// SetDialNRI replaces the NRI socket dialer of the pre-flight check and returns a function restoring it.
+func SetDialNRI(dial func(string) (stdnet.Conn, error)) func() {
+	original := dialNRI
+	dialNRI = dial
+	return func() { dialNRI = original }
+}
+
+// SetNewStub replaces the NRI plugin stub constructor and returns a function restoring it.
+func SetNewStub(newStubFunc func(plugin interface{}, opts ...stub.Option) (stub.Stub, error)) func() {
+	original := newStub
+	newStub = newStubFunc
+	return func() { newStub = original }
+}
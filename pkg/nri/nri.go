@@ -2,24 +2,28 @@ package nri
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"sync"
 
+	"github.com/SchSeba/dra-driver-sriov/pkg/claimstatus"
 	"github.com/SchSeba/dra-driver-sriov/pkg/cni"
 	"github.com/SchSeba/dra-driver-sriov/pkg/consts"
 	"github.com/SchSeba/dra-driver-sriov/pkg/flags"
+	"github.com/SchSeba/dra-driver-sriov/pkg/host"
 	"github.com/SchSeba/dra-driver-sriov/pkg/podmanager"
 	"github.com/SchSeba/dra-driver-sriov/pkg/types"
 	"github.com/containerd/nri/pkg/api"
 	"github.com/containerd/nri/pkg/stub"
-	resourceapi "k8s.io/api/resource/v1"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	k8stypes "k8s.io/apimachinery/pkg/types"
-	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/apimachinery/pkg/util/sets"
 	"k8s.io/klog/v2"
-	"sigs.k8s.io/controller-runtime/pkg/client"
 )
 
+// maxParallelDetaches bounds the CNI DEL operations run at once on StopPodSandbox
+const maxParallelDetaches = 4
+
 // Plugin represents a NRI plugin catching RunPodSandbox and StopPodSandbox events to
 // call CNI ADD/DEL based on ResourceClaim attached to pods.
 type Plugin struct {
@@ -30,18 +34,43 @@ type Plugin struct {
 	k8sClient                   flags.ClientSets
 	networkDeviceDataUpdateChan chan types.NetworkDataChanStructList
 	interfacePrefix             string
+	netnsResolution             string
+	nodeName                    string
+
+	// sandboxes keeps the pod sandboxes seen by RunPodSandbox indexed by pod UID,
+	// so the networks can be detached on drain
+	sandboxes   map[string]*api.PodSandbox
+	sandboxesMu sync.Mutex
+	// detachedOrphans keeps the pods whose networks were detached by ReconcileOrphanedPods,
+	// so they are detached once while kubelet has not unprepared them
+	detachedOrphans sets.Set[k8stypes.UID]
 	// PodResourceStore PodResourceStore
 	// UpdateStatusFunc UpdateStatus
 }
 
+// newStub creates the NRI plugin stub, replaced in tests
+var newStub = stub.New
+
 // NewNRIPlugin creates a new NRI plugin.
 func NewNRIPlugin(config *types.Config, podManager *podmanager.PodManager, cniRuntime *cni.Runtime) (*Plugin, error) {
+	netnsResolution := config.Flags.NetnsResolution
+	if netnsResolution == "" {
+		netnsResolution = consts.NetnsResolutionAuto
+	}
+	if err := validateNetnsResolution(netnsResolution); err != nil {
+		return nil, err
+	}
+
 	p := &Plugin{
 		podManager:                  podManager,
 		cniRuntime:                  cniRuntime,
 		k8sClient:                   config.K8sClient,
 		interfacePrefix:             config.Flags.DefaultInterfacePrefix,
+		netnsResolution:             netnsResolution,
+		nodeName:                    config.Flags.NodeName,
 		networkDeviceDataUpdateChan: make(chan types.NetworkDataChanStructList, 100),
+		sandboxes:                   map[string]*api.PodSandbox{},
+		detachedOrphans:             sets.New[k8stypes.UID](),
 	}
 	var err error
 	// register the NRI plugin
@@ -54,7 +83,16 @@ func NewNRIPlugin(config *types.Config, podManager *podmanager.PodManager, cniRu
 		}),
 	}
 
-	p.stub, err = stub.New(p, nriOpts...)
+	// the stub defaults the name and index to the environment set by a runtime launching the plugin,
+	// the flags are read from other variables and setting both fails the stub creation
+	if name := config.Flags.NRIPluginName; name != "" {
+		nriOpts = append(nriOpts, stub.WithPluginName(name))
+	}
+	if idx := config.Flags.NRIPluginIndex; idx != "" {
+		nriOpts = append(nriOpts, stub.WithPluginIdx(idx))
+	}
+
+	p.stub, err = newStub(p, nriOpts...)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create plugin stub: %w", err)
 	}
@@ -93,25 +131,57 @@ func (p *Plugin) RunPodSandbox(ctx context.Context, pod *api.PodSandbox) error {
 		return nil
 	}
 
-	// if we don't have a network namespace, we can't attach networks
-	// so we skip the network attachment
-	networkNamespace := getNetworkNamespace(pod)
-	if networkNamespace == "" {
-		logger.Info("No network namespace found for pod skipping network attachment", "pod.UID", pod.Uid, "pod.Name", pod.Name, "pod.Namespace", pod.Namespace)
+	// attaching a VF into the host network namespace can break the node connectivity,
+	// so pods without a network namespace of their own (hostNetwork) are refused
+	networkNamespace := getNetworkNamespace(pod, p.netnsResolution)
+	hostNetwork, err := isHostNetwork(networkNamespace)
+	if err != nil {
+		logger.Error(err, "Failed to check the pod network namespace", "pod.UID", pod.Uid, "networkNamespace", networkNamespace)
+		return fmt.Errorf("failed to check the pod network namespace: %w", err)
+	}
+	if hostNetwork {
+		logger.Info("Pod uses the host network namespace, refusing to attach the networks", "pod.UID", pod.Uid, "pod.Name", pod.Name, "pod.Namespace", pod.Namespace, "networkNamespace", networkNamespace)
+		p.networkDeviceDataUpdateChan <- hostNetworkConditions(devices)
 		return nil
 	}
 
+	p.sandboxesMu.Lock()
+	p.sandboxes[pod.Uid] = pod
+	p.sandboxesMu.Unlock()
+
+	podCPUs, err := getPodCPUs(pod)
+	if err != nil {
+		logger.Error(err, "Failed to parse pod cpuset", "pod.UID", pod.Uid)
+		return fmt.Errorf("failed to parse pod cpuset: %w", err)
+	}
+	if podCPUs.IsEmpty() {
+		logger.V(2).Info("Pod has no pinned CPUs, skipping NUMA alignment validation", "pod.UID", pod.Uid)
+	} else if err := validateNumaAlignment(logger, podCPUs, devices); err != nil {
+		logger.Error(err, "NUMA alignment validation failed", "pod.UID", pod.Uid, "pod.Name", pod.Name, "pod.Namespace", pod.Namespace)
+		return fmt.Errorf("NUMA alignment validation failed: %w", err)
+	}
+
 	networkDevicesData := types.NetworkDataChanStructList{}
 	for _, device := range devices {
-		networkDeviceData, err := p.cniRuntime.AttachNetwork(ctx, pod, networkNamespace, device)
+		// NRI can deliver RunPodSandbox more than once, the devices already attached are only reconciled in the claim status
+		if networkData, attached := p.podManager.GetAttached(k8stypes.UID(pod.Uid), device); attached {
+			logger.Info("Network already attached, skipping", "deviceName", device.Device.DeviceName, "pod.UID", pod.Uid, "pod.Name", pod.Name, "pod.Namespace", pod.Namespace)
+			networkDevicesData = append(networkDevicesData, networkData)
+			continue
+		}
+
+		networkDeviceData, networkStatus, err := p.cniRuntime.AttachNetwork(ctx, pod, networkNamespace, device)
 		if err != nil {
 			logger.Error(err, "Failed to attach network", "deviceName", device.Device.DeviceName, "pod.UID", pod.Uid, "pod.Name", pod.Name, "pod.Namespace", pod.Namespace)
 			return fmt.Errorf("failed to attach network: %w", err)
 		}
-		networkDevicesData = append(networkDevicesData, &types.NetworkDataChanStruct{
+		networkData := &types.NetworkDataChanStruct{
 			PreparedDevice:    device,
 			NetworkDeviceData: networkDeviceData,
-		})
+			NetworkStatus:     networkStatus,
+		}
+		p.podManager.SetAttached(k8stypes.UID(pod.Uid), networkData)
+		networkDevicesData = append(networkDevicesData, networkData)
 		logger.Info("Attached network", "deviceName", device.Device.DeviceName, "pod.UID", pod.Uid, "pod.Name", pod.Name, "pod.Namespace", pod.Namespace, "networkDeviceData", networkDeviceData)
 	}
 
@@ -124,26 +194,90 @@ func (p *Plugin) StopPodSandbox(ctx context.Context, pod *api.PodSandbox) error
 	logger := klog.FromContext(ctx).WithName("NRI StopPodSandbox")
 	logger.Info("StopPodSandbox", "pod.UID", pod.Uid, "pod.Name", pod.Name, "pod.Namespace", pod.Namespace)
 
+	p.sandboxesMu.Lock()
+	_, attached := p.sandboxes[pod.Uid]
+	delete(p.sandboxes, pod.Uid)
+	p.sandboxesMu.Unlock()
+
 	devices, found := p.podManager.GetDevicesByPodUID(k8stypes.UID(pod.Uid))
 	if !found {
 		logger.Info("No prepared devices found for pod", "pod.UID", pod.Uid)
 		return nil
 	}
+	for _, device := range devices {
+		if _, found := p.podManager.GetAttached(k8stypes.UID(pod.Uid), device); found {
+			attached = true
+		}
+	}
 
-	networkNamespace := getNetworkNamespace(pod)
-	if networkNamespace == "" {
-		return fmt.Errorf("error getting network namespace for pod '%s' in namespace '%s'", pod.Name, pod.Namespace)
+	networkNamespace := getNetworkNamespace(pod, p.netnsResolution)
+	namespaceGone := networkNamespace != "" && !host.GetHelpers().PathExists(networkNamespace)
+	if namespaceGone {
+		// the sandbox network namespace can be removed before StopPodSandbox, the CNI DEL
+		// still releases the IPAM allocation and restores the VF without it
+		logger.Info("Pod network namespace is gone, detaching the networks without it", "pod.UID", pod.Uid, "networkNamespace", networkNamespace)
+		networkNamespace = ""
 	}
 
-	for _, device := range devices {
-		logger.Info("Detaching network", "device", device)
-		err := p.cniRuntime.DetachNetwork(ctx, pod, networkNamespace, device)
+	// RunPodSandbox records the pods it attached, the network namespace is only checked for the
+	// pods attached before a driver restart as RunPodSandbox refuses the host network ones
+	if !attached && !namespaceGone {
+		hostNetwork, err := isHostNetwork(networkNamespace)
 		if err != nil {
-			logger.Error(err, "Failed to detach network", "deviceName", device.Device.DeviceName, "pod.UID", pod.Uid, "pod.Name", pod.Name, "pod.Namespace", pod.Namespace)
-			return fmt.Errorf("error CNI.DetachNetwork for pod '%s' (uid: %s) in namespace '%s': %v", pod.Name, pod.Uid, pod.Namespace, err)
+			logger.Error(err, "Failed to check the pod network namespace", "pod.UID", pod.Uid, "networkNamespace", networkNamespace)
+			return fmt.Errorf("failed to check the pod network namespace: %w", err)
+		}
+		if hostNetwork {
+			// the networks were never attached to a host network pod
+			logger.Info("Pod uses the host network namespace, skipping network detachment", "pod.UID", pod.Uid, "pod.Name", pod.Name, "pod.Namespace", pod.Namespace)
+			return nil
 		}
 	}
-	return nil
+
+	detachErrs := p.detachNetworks(ctx, pod, networkNamespace, devices)
+	networkDevicesData := types.NetworkDataChanStructList{}
+	var errs []error
+	for i, device := range devices {
+		if detachErrs[i] != nil {
+			errs = append(errs, fmt.Errorf("error CNI.DetachNetwork of device %s for pod '%s' (uid: %s) in namespace '%s': %v",
+				device.Device.DeviceName, pod.Name, pod.Uid, pod.Namespace, detachErrs[i]))
+			continue
+		}
+		// clear the network data of the device
+		networkDevicesData = append(networkDevicesData, &types.NetworkDataChanStruct{
+			PreparedDevice: device,
+		})
+	}
+	p.podManager.ClearAttached(k8stypes.UID(pod.Uid))
+
+	p.networkDeviceDataUpdateChan <- networkDevicesData
+	return errors.Join(errs...)
+}
+
+// detachNetworks runs the CNI DEL of the devices in parallel, at most maxParallelDetaches at a time, so the pods
+// with many VFs are torn down quickly. Every detach is attempted, the error of each device is returned at its index.
+func (p *Plugin) detachNetworks(ctx context.Context, pod *api.PodSandbox, networkNamespace string, devices types.PreparedDevices) []error {
+	logger := klog.FromContext(ctx).WithName("detachNetworks")
+
+	errs := make([]error, len(devices))
+	workers := make(chan struct{}, maxParallelDetaches)
+	var wg sync.WaitGroup
+	for i, device := range devices {
+		wg.Add(1)
+		workers <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-workers }()
+
+			logger.Info("Detaching network", "device", device)
+			if err := p.cniRuntime.DetachNetwork(ctx, pod, networkNamespace, device); err != nil {
+				logger.Error(err, "Failed to detach network", "deviceName", device.Device.DeviceName, "pod.UID", pod.Uid, "pod.Name", pod.Name, "pod.Namespace", pod.Namespace)
+				errs[i] = err
+			}
+		}()
+	}
+	wg.Wait()
+	return errs
 }
 
 // updateNetworkDeviceDataRunner is a goroutine that updates the network device data
@@ -161,71 +295,39 @@ func (p *Plugin) updateNetworkDeviceDataRunner(ctx context.Context) {
 }
 
 // updateNetworkDeviceData updates the network device data for each pod in the networkDataChanStructList.
+// A nil NetworkDeviceData clears the network data and the network status of the device (detach),
+// a Condition is set instead of the network data.
 // we use it so we don't block the CNI ADD/DEL operations as we are limited by the NRI plugin timeout
 func (p *Plugin) updateNetworkDeviceData(ctx context.Context, networkDataChanStructList types.NetworkDataChanStructList) {
 	logger := klog.FromContext(ctx).WithName("updateNetworkDeviceData")
 	logger.Info("Updating network device data", "networkDataChanStructList", networkDataChanStructList)
 
 	for _, networkDataChanStruct := range networkDataChanStructList {
-		// get the claim object
-		claim := &resourceapi.ResourceClaim{}
-		err := p.k8sClient.Client.Get(ctx, client.ObjectKey{
-			Name:      networkDataChanStruct.PreparedDevice.ClaimNamespacedName.Name,
-			Namespace: networkDataChanStruct.PreparedDevice.ClaimNamespacedName.Namespace,
-		}, claim)
-		if err != nil {
-			logger.Error(err, "Failed to get claim object", "claimName", networkDataChanStruct.PreparedDevice.ClaimNamespacedName.Name, "claimNamespace", networkDataChanStruct.PreparedDevice.ClaimNamespacedName.Namespace)
+		preparedDevice := networkDataChanStruct.PreparedDevice
+		// the status of a VF group device can't describe the interfaces of all its VFs
+		if preparedDevice.GroupDeviceName != "" {
+			logger.V(2).Info("Skipping network data update of a VF prepared for a VF group", "device", preparedDevice.Device.DeviceName, "group", preparedDevice.GroupDeviceName)
 			continue
 		}
-
-		for idx, device := range claim.Status.Devices {
-			if device.Device != networkDataChanStruct.PreparedDevice.Device.DeviceName || device.Pool != networkDataChanStruct.PreparedDevice.Device.PoolName || device.Driver != consts.DriverName {
-				continue
-			}
-			claim.Status.Devices[idx].NetworkData = networkDataChanStruct.NetworkDeviceData
+		mutate := claimstatus.All(
+			claimstatus.SetNetworkData(preparedDevice.Device.PoolName, preparedDevice.Device.DeviceName, networkDataChanStruct.NetworkDeviceData),
+			claimstatus.SetNetworkStatus(preparedDevice.Device.PoolName, preparedDevice.Device.DeviceName, networkDataChanStruct.NetworkStatus),
+		)
+		if networkDataChanStruct.Condition != nil {
+			mutate = claimstatus.SetCondition(preparedDevice.Device.PoolName, preparedDevice.Device.DeviceName, *networkDataChanStruct.Condition)
 		}
-
-		err = p.updateClaimNetworkDataWithRetry(ctx, claim)
-		if err != nil {
-			logger.Error(err, "Failed to update claim network data", "claim", claim.UID)
+		err := claimstatus.UpdateDevices(ctx, p.k8sClient.Interface,
+			preparedDevice.ClaimNamespacedName.Namespace,
+			preparedDevice.ClaimNamespacedName.Name,
+			mutate)
+		if apierrors.IsNotFound(err) {
+			// the claim was already deleted, there is no status left to update
+			logger.V(2).Info("Claim not found, skipping network data update", "claimName", preparedDevice.ClaimNamespacedName.Name, "claimNamespace", preparedDevice.ClaimNamespacedName.Namespace)
 			continue
 		}
-	}
-}
-
-// updateClaimNetworkDataWithRetry updates the network device data for a claim with retries.
-func (p *Plugin) updateClaimNetworkDataWithRetry(ctx context.Context, claim *resourceapi.ResourceClaim) error {
-	logger := klog.FromContext(ctx).WithName("updateClaimNetworkDataWithRetry")
-	originalDevices := claim.Status.Devices
-	err := wait.ExponentialBackoffWithContext(ctx, consts.Backoff, func(ctx context.Context) (bool, error) {
-		_, updateErr := p.k8sClient.ResourceV1().ResourceClaims(claim.Namespace).UpdateStatus(ctx, claim, metav1.UpdateOptions{})
-		if updateErr != nil {
-			// If this is a conflict error, fetch fresh claim and copy over devices list
-			if apierrors.IsConflict(updateErr) {
-				logger.V(2).Info("Conflict detected, refreshing claim", "claim", claim.UID)
-
-				freshClaim, fetchErr := p.k8sClient.ResourceV1().ResourceClaims(claim.Namespace).Get(ctx, claim.Name, metav1.GetOptions{})
-				if fetchErr != nil {
-					logger.V(2).Info("Failed to fetch fresh claim", "claim", claim.UID, "error", fetchErr.Error())
-					return false, nil // Continue retrying
-				}
-
-				// Copy original devices list to fresh claim
-				freshClaim.Status.Devices = originalDevices
-				claim = freshClaim // Use fresh claim for next retry
-
-				logger.V(2).Info("Refreshed claim, retrying status update", "claim", claim.UID)
-			} else {
-				logger.V(2).Info("Retrying claim status update", "claim", claim.UID, "error", updateErr.Error())
-			}
-			return false, nil // Return false to continue retrying, nil to not fail immediately
+		if err != nil {
+			logger.Error(err, "Failed to update claim network data", "claimName", preparedDevice.ClaimNamespacedName.Name, "claimNamespace", preparedDevice.ClaimNamespacedName.Namespace)
+			continue
 		}
-		return true, nil // Success
-	})
-
-	if err != nil {
-		logger.Error(err, "Failed to update claim status after retries", "claim", claim.UID)
-		return err
 	}
-	return nil
 }
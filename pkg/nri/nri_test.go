@@ -0,0 +1,644 @@
+package nri_test
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/containerd/nri/pkg/api"
+	"github.com/containerd/nri/pkg/stub"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"go.uber.org/mock/gomock"
+	corev1 "k8s.io/api/core/v1"
+	resourceapi "k8s.io/api/resource/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8sruntime "k8s.io/apimachinery/pkg/runtime"
+	k8stypes "k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+	"k8s.io/dynamic-resource-allocation/kubeletplugin"
+	drapbv1 "k8s.io/kubelet/pkg/apis/dra/v1beta1"
+	"k8s.io/utils/cpuset"
+
+	configapi "github.com/SchSeba/dra-driver-sriov/pkg/api/virtualfunction/v1alpha1"
+	"github.com/SchSeba/dra-driver-sriov/pkg/cni"
+	"github.com/SchSeba/dra-driver-sriov/pkg/consts"
+	"github.com/SchSeba/dra-driver-sriov/pkg/flags"
+	"github.com/SchSeba/dra-driver-sriov/pkg/host"
+	mock_host "github.com/SchSeba/dra-driver-sriov/pkg/host/mock"
+	"github.com/SchSeba/dra-driver-sriov/pkg/nri"
+	"github.com/SchSeba/dra-driver-sriov/pkg/podmanager"
+	draTypes "github.com/SchSeba/dra-driver-sriov/pkg/types"
+)
+
+const testNetConf = `{"cniVersion":"1.0.0","name":"test-net","type":"sriov"}`
+
+var _ = Describe("NRI Plugin", func() {
+	var (
+		ctx           context.Context
+		tempDir       string
+		config        *draTypes.Config
+		podManager    *podmanager.PodManager
+		fakeCNI       *cni.FakeCNI
+		clientset     *fake.Clientset
+		plugin        *nri.Plugin
+		mockCtrl      *gomock.Controller
+		mockHost      *mock_host.MockInterface
+		originalHost  host.Interface
+		pod           *api.PodSandbox
+		podUID        k8stypes.UID
+		claimUID      k8stypes.UID
+		vfPciAddress  string
+		preparedClaim draTypes.PreparedDevices
+	)
+
+	BeforeEach(func() {
+		var err error
+		ctx = context.Background()
+		os.Setenv("NRI_PLUGIN_IDX", "42")
+
+		tempDir, err = os.MkdirTemp("", "nri-test-*")
+		Expect(err).NotTo(HaveOccurred())
+
+		clientset = fake.NewSimpleClientset()
+		config = &draTypes.Config{
+			K8sClient: flags.ClientSets{Interface: clientset},
+			Flags: &draTypes.Flags{
+				KubeletPluginsDirectoryPath: tempDir,
+				DefaultInterfacePrefix:      "net",
+			},
+			CancelMainCtx: func(error) {},
+		}
+
+		podManager, err = podmanager.NewPodManager(config)
+		Expect(err).NotTo(HaveOccurred())
+
+		fakeCNI = &cni.FakeCNI{}
+		cniRuntime := cni.New("test-driver", []string{}, "")
+		cniRuntime.CNIConfig = fakeCNI
+
+		plugin, err = nri.NewNRIPlugin(config, podManager, cniRuntime)
+		Expect(err).NotTo(HaveOccurred())
+
+		mockCtrl = gomock.NewController(GinkgoT())
+		mockHost = mock_host.NewMockInterface(mockCtrl)
+		originalHost = host.GetHelpers()
+		host.Helpers = mockHost
+		mockHost.EXPECT().IsHostNetworkNamespace("/var/run/netns/test").Return(false, nil).AnyTimes()
+		mockHost.EXPECT().PathExists("/var/run/netns/test").Return(true).AnyTimes()
+
+		podUID = "test-pod-uid"
+		claimUID = "test-claim-uid"
+		vfPciAddress = "0000:01:00.1"
+		pod = &api.PodSandbox{
+			Id:        "test-sandbox-id",
+			Uid:       string(podUID),
+			Name:      "test-pod",
+			Namespace: "default",
+			Linux: &api.LinuxPodSandbox{
+				Namespaces: []*api.LinuxNamespace{
+					{Type: "network", Path: "/var/run/netns/test"},
+				},
+			},
+		}
+		preparedClaim = draTypes.PreparedDevices{
+			{
+				Device: drapbv1.Device{
+					DeviceName: "0000-01-00-1",
+					PoolName:   "test-node",
+				},
+				ClaimNamespacedName: kubeletplugin.NamespacedObject{
+					NamespacedName: k8stypes.NamespacedName{Namespace: "default", Name: "test-claim"},
+					UID:            claimUID,
+				},
+				Config:             &configapi.VfConfig{RequireNumaAlignment: true},
+				PciAddress:         vfPciAddress,
+				IfName:             "net1",
+				PodUID:             string(podUID),
+				NetAttachDefConfig: testNetConf,
+			},
+		}
+	})
+
+	AfterEach(func() {
+		host.Helpers = originalHost
+		mockCtrl.Finish()
+		os.Unsetenv("NRI_PLUGIN_IDX")
+		os.RemoveAll(tempDir)
+	})
+
+	Context("RunPodSandbox NUMA alignment", func() {
+		BeforeEach(func() {
+			Expect(podManager.Set(podUID, claimUID, preparedClaim)).To(Succeed())
+			mockHost.EXPECT().GetNumaNodeAffinity(vfPciAddress).Return("0", nil).AnyTimes()
+			mockHost.EXPECT().GetNumaNodeCPUs("0").Return(cpuset.New(0, 1, 2, 3), nil).AnyTimes()
+		})
+
+		It("should attach the network when the VF is on the pod's NUMA node", func() {
+			pod.Linux.PodResources = &api.LinuxResources{Cpu: &api.LinuxCPU{Cpus: "2-3"}}
+
+			Expect(plugin.RunPodSandbox(ctx, pod)).To(Succeed())
+			Expect(fakeCNI.AddCalls).To(HaveLen(1))
+		})
+
+		It("should reject the pod when the VF is on a different NUMA node", func() {
+			pod.Linux.PodResources = &api.LinuxResources{Cpu: &api.LinuxCPU{Cpus: "4-5"}}
+
+			err := plugin.RunPodSandbox(ctx, pod)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring(fmt.Sprintf("device %s on NUMA node 0 is not aligned", vfPciAddress)))
+			Expect(fakeCNI.AddCalls).To(BeEmpty())
+		})
+
+		It("should attach the network when the VF has no NUMA affinity", func() {
+			pod.Linux.PodResources = &api.LinuxResources{Cpu: &api.LinuxCPU{Cpus: "4-5"}}
+			noAffinityHost := mock_host.NewMockInterface(mockCtrl)
+			noAffinityHost.EXPECT().IsHostNetworkNamespace("/var/run/netns/test").Return(false, nil).AnyTimes()
+			noAffinityHost.EXPECT().PathExists("/var/run/netns/test").Return(true).AnyTimes()
+			noAffinityHost.EXPECT().GetNumaNodeAffinity(vfPciAddress).Return(consts.NoNumaAffinity, nil)
+			host.Helpers = noAffinityHost
+
+			Expect(plugin.RunPodSandbox(ctx, pod)).To(Succeed())
+			Expect(fakeCNI.AddCalls).To(HaveLen(1))
+		})
+
+		It("should skip validation when the pod has no pinned CPUs", func() {
+			Expect(plugin.RunPodSandbox(ctx, pod)).To(Succeed())
+			Expect(fakeCNI.AddCalls).To(HaveLen(1))
+		})
+	})
+
+	Context("RunPodSandbox delivered more than once", func() {
+		BeforeEach(func() {
+			Expect(podManager.Set(podUID, claimUID, preparedClaim)).To(Succeed())
+			mockHost.EXPECT().GetNumaNodeAffinity(vfPciAddress).Return("0", nil).AnyTimes()
+			mockHost.EXPECT().GetNumaNodeCPUs("0").Return(cpuset.New(0, 1, 2, 3), nil).AnyTimes()
+
+			claim := &resourceapi.ResourceClaim{
+				ObjectMeta: metav1.ObjectMeta{Name: "test-claim", Namespace: "default", UID: claimUID},
+				Status: resourceapi.ResourceClaimStatus{
+					Devices: []resourceapi.AllocatedDeviceStatus{
+						{Driver: consts.DriverName, Pool: "test-node", Device: "0000-01-00-1"},
+					},
+				},
+			}
+			_, err := clientset.ResourceV1().ResourceClaims("default").Create(ctx, claim, metav1.CreateOptions{})
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("should attach the network once and reconcile the claim status", func() {
+			Expect(plugin.RunPodSandbox(ctx, pod)).To(Succeed())
+			plugin.ProcessPendingNetworkDeviceData(ctx)
+
+			// the status is lost in between, the second event restores it without attaching again
+			claim, err := clientset.ResourceV1().ResourceClaims("default").Get(ctx, "test-claim", metav1.GetOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(claim.Status.Devices[0].NetworkData).NotTo(BeNil())
+			claim.Status.Devices[0].NetworkData = nil
+			_, err = clientset.ResourceV1().ResourceClaims("default").UpdateStatus(ctx, claim, metav1.UpdateOptions{})
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(plugin.RunPodSandbox(ctx, pod)).To(Succeed())
+			plugin.ProcessPendingNetworkDeviceData(ctx)
+			Expect(fakeCNI.AddCalls).To(HaveLen(1))
+
+			claim, err = clientset.ResourceV1().ResourceClaims("default").Get(ctx, "test-claim", metav1.GetOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(claim.Status.Devices[0].NetworkData).NotTo(BeNil())
+		})
+
+		It("should attach the network again after the sandbox is stopped", func() {
+			Expect(plugin.RunPodSandbox(ctx, pod)).To(Succeed())
+			Expect(plugin.StopPodSandbox(ctx, pod)).To(Succeed())
+			Expect(plugin.RunPodSandbox(ctx, pod)).To(Succeed())
+			Expect(fakeCNI.AddCalls).To(HaveLen(2))
+		})
+	})
+
+	Context("Drain", func() {
+		var (
+			pod2UID       k8stypes.UID
+			vf2PciAddress string
+		)
+
+		BeforeEach(func() {
+			pod2UID = "test-pod-uid-2"
+			vf2PciAddress = "0000:01:00.2"
+			Expect(podManager.Set(podUID, claimUID, preparedClaim)).To(Succeed())
+			Expect(podManager.Set(pod2UID, "test-claim-uid-2", draTypes.PreparedDevices{
+				{
+					Device:             drapbv1.Device{DeviceName: "0000-01-00-2"},
+					PciAddress:         vf2PciAddress,
+					IfName:             "net1",
+					PodUID:             string(pod2UID),
+					NetAttachDefConfig: testNetConf,
+				},
+			})).To(Succeed())
+		})
+
+		It("should detach and reset every tracked device", func() {
+			Expect(plugin.RunPodSandbox(ctx, pod)).To(Succeed())
+
+			mockHost.EXPECT().ResetVF(vfPciAddress).Return(nil)
+			mockHost.EXPECT().ResetVF(vf2PciAddress).Return(nil)
+
+			Expect(plugin.Drain(ctx)).To(Succeed())
+			Expect(fakeCNI.DelCalls).To(HaveLen(2))
+
+			netNSByContainerID := map[string]string{}
+			for _, rt := range fakeCNI.DelCalls {
+				netNSByContainerID[rt.ContainerID] = rt.NetNS
+			}
+			Expect(netNSByContainerID).To(HaveKeyWithValue("test-sandbox-id", "/var/run/netns/test"))
+		})
+
+		It("should keep draining when a device fails", func() {
+			fakeCNI.DelErr = fmt.Errorf("del failed")
+			mockHost.EXPECT().ResetVF(vfPciAddress).Return(fmt.Errorf("reset failed"))
+			mockHost.EXPECT().ResetVF(vf2PciAddress).Return(nil)
+
+			err := plugin.Drain(ctx)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("del failed"))
+			Expect(err.Error()).To(ContainSubstring("failed to reset VF " + vfPciAddress))
+			Expect(fakeCNI.DelCalls).To(HaveLen(2))
+		})
+
+		It("should not remove the prepared devices", func() {
+			mockHost.EXPECT().ResetVF(gomock.Any()).Return(nil).Times(2)
+
+			Expect(plugin.Drain(ctx)).To(Succeed())
+			_, found := podManager.GetDevicesByPodUID(podUID)
+			Expect(found).To(BeTrue())
+		})
+	})
+
+	Context("Orphaned pods", func() {
+		var orphanPod *api.PodSandbox
+
+		BeforeEach(func() {
+			orphanPod = &api.PodSandbox{
+				Id:        "orphan-sandbox-id",
+				Uid:       "orphan-pod-uid",
+				Name:      "orphan-pod",
+				Namespace: "default",
+				Linux:     pod.Linux,
+			}
+			Expect(podManager.Set(podUID, claimUID, preparedClaim)).To(Succeed())
+			Expect(podManager.Set(k8stypes.UID(orphanPod.Uid), "orphan-claim-uid", draTypes.PreparedDevices{
+				{
+					Device:             drapbv1.Device{DeviceName: "0000-01-00-2", PoolName: "test-node"},
+					PciAddress:         "0000:01:00.2",
+					IfName:             "net1",
+					PodUID:             orphanPod.Uid,
+					NetAttachDefConfig: testNetConf,
+				},
+			})).To(Succeed())
+			Expect(plugin.RunPodSandbox(ctx, pod)).To(Succeed())
+			Expect(plugin.RunPodSandbox(ctx, orphanPod)).To(Succeed())
+
+			// only the live pod is still known to the API server
+			_, err := clientset.CoreV1().Pods("default").Create(ctx, &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Name: pod.Name, Namespace: pod.Namespace, UID: podUID},
+			}, metav1.CreateOptions{})
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("should detach only the networks of the orphaned pod, once", func() {
+			Expect(plugin.ReconcileOrphanedPods(ctx)).To(Succeed())
+			Expect(fakeCNI.DelCalls).To(HaveLen(1))
+			Expect(fakeCNI.DelCalls[0].ContainerID).To(Equal("orphan-sandbox-id"))
+			Expect(fakeCNI.DelCalls[0].NetNS).To(Equal("/var/run/netns/test"))
+
+			Expect(plugin.ReconcileOrphanedPods(ctx)).To(Succeed())
+			Expect(fakeCNI.DelCalls).To(HaveLen(1))
+
+			// the prepared devices are left to kubelet
+			_, found := podManager.GetDevicesByPodUID(k8stypes.UID(orphanPod.Uid))
+			Expect(found).To(BeTrue())
+		})
+
+		It("should report the failed detaches", func() {
+			fakeCNI.DelErr = fmt.Errorf("del failed")
+
+			err := plugin.ReconcileOrphanedPods(ctx)
+			Expect(err).To(MatchError(ContainSubstring("del failed")))
+			Expect(fakeCNI.DelCalls).To(HaveLen(1))
+		})
+
+		It("should not detach anything when the pods can't be listed", func() {
+			clientset.PrependReactor("list", "pods", func(k8stesting.Action) (bool, k8sruntime.Object, error) {
+				return true, nil, fmt.Errorf("api server unavailable")
+			})
+
+			Expect(plugin.ReconcileOrphanedPods(ctx)).To(MatchError(ContainSubstring("api server unavailable")))
+			Expect(fakeCNI.DelCalls).To(BeEmpty())
+		})
+	})
+
+	Context("StopPodSandbox network data", func() {
+		BeforeEach(func() {
+			Expect(podManager.Set(podUID, claimUID, preparedClaim)).To(Succeed())
+		})
+
+		It("should clear the device network data from the claim status after detach", func() {
+			claim := &resourceapi.ResourceClaim{
+				ObjectMeta: metav1.ObjectMeta{Name: "test-claim", Namespace: "default", UID: claimUID},
+				Status: resourceapi.ResourceClaimStatus{
+					Devices: []resourceapi.AllocatedDeviceStatus{
+						{
+							Driver:      consts.DriverName,
+							Pool:        "test-node",
+							Device:      "0000-01-00-1",
+							NetworkData: &resourceapi.NetworkDeviceData{InterfaceName: "net1", IPs: []string{"10.0.0.2/24"}},
+						},
+					},
+				},
+			}
+			_, err := clientset.ResourceV1().ResourceClaims("default").Create(ctx, claim, metav1.CreateOptions{})
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(plugin.StopPodSandbox(ctx, pod)).To(Succeed())
+			Expect(fakeCNI.DelCalls).To(HaveLen(1))
+			plugin.ProcessPendingNetworkDeviceData(ctx)
+
+			updated, err := clientset.ResourceV1().ResourceClaims("default").Get(ctx, "test-claim", metav1.GetOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(updated.Status.Devices).To(HaveLen(1))
+			Expect(updated.Status.Devices[0].Device).To(Equal("0000-01-00-1"))
+			Expect(updated.Status.Devices[0].NetworkData).To(BeNil())
+		})
+
+		It("should not fail when the claim was already deleted", func() {
+			Expect(plugin.StopPodSandbox(ctx, pod)).To(Succeed())
+			Expect(fakeCNI.DelCalls).To(HaveLen(1))
+			plugin.ProcessPendingNetworkDeviceData(ctx)
+
+			_, err := clientset.ResourceV1().ResourceClaims("default").Get(ctx, "test-claim", metav1.GetOptions{})
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Context("StopPodSandbox with several devices", func() {
+		BeforeEach(func() {
+			devices := draTypes.PreparedDevices{}
+			for i := 1; i <= 6; i++ {
+				device := *preparedClaim[0]
+				device.Device.DeviceName = fmt.Sprintf("0000-01-00-%d", i)
+				device.PciAddress = fmt.Sprintf("0000:01:00.%d", i)
+				device.IfName = fmt.Sprintf("net%d", i)
+				devices = append(devices, &device)
+			}
+			Expect(podManager.Set(podUID, claimUID, devices)).To(Succeed())
+		})
+
+		It("should detach all the devices", func() {
+			Expect(plugin.StopPodSandbox(ctx, pod)).To(Succeed())
+
+			ifNames := []string{}
+			for _, rt := range fakeCNI.DelCalls {
+				ifNames = append(ifNames, rt.IfName)
+			}
+			Expect(ifNames).To(ConsistOf("net1", "net2", "net3", "net4", "net5", "net6"))
+		})
+
+		It("should attempt every detach and aggregate the errors", func() {
+			fakeCNI.DelErrByIfName = map[string]error{
+				"net2": fmt.Errorf("sriov DEL failed"),
+				"net5": fmt.Errorf("ipam DEL failed"),
+			}
+
+			err := plugin.StopPodSandbox(ctx, pod)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("device 0000-01-00-2"))
+			Expect(err.Error()).To(ContainSubstring("sriov DEL failed"))
+			Expect(err.Error()).To(ContainSubstring("device 0000-01-00-5"))
+			Expect(err.Error()).To(ContainSubstring("ipam DEL failed"))
+			Expect(err.Error()).NotTo(ContainSubstring("device 0000-01-00-1"))
+			Expect(fakeCNI.DelCalls).To(HaveLen(6))
+		})
+	})
+
+	Context("Network namespace resolution", func() {
+		const procfsNetNS = "/proc/1234/ns/net"
+
+		newPlugin := func(strategy string) *nri.Plugin {
+			config.Flags.NetnsResolution = strategy
+			cniRuntime := cni.New("test-driver", []string{}, "")
+			cniRuntime.CNIConfig = fakeCNI
+			p, err := nri.NewNRIPlugin(config, podManager, cniRuntime)
+			Expect(err).NotTo(HaveOccurred())
+			return p
+		}
+
+		BeforeEach(func() {
+			Expect(podManager.Set(podUID, claimUID, preparedClaim)).To(Succeed())
+			pod.Pid = 1234
+		})
+
+		It("should reject an unknown strategy", func() {
+			config.Flags.NetnsResolution = "bogus"
+			_, err := nri.NewNRIPlugin(config, podManager, cni.New("test-driver", []string{}, ""))
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring(`unknown network namespace resolution "bogus"`))
+		})
+
+		It("should use the sandbox namespaces with the nri strategy", func() {
+			Expect(newPlugin(consts.NetnsResolutionNRI).RunPodSandbox(ctx, pod)).To(Succeed())
+			Expect(fakeCNI.AddCalls).To(HaveLen(1))
+			Expect(fakeCNI.AddCalls[0].NetNS).To(Equal("/var/run/netns/test"))
+		})
+
+		It("should skip the attachment with the nri strategy when the namespaces are not populated", func() {
+			pod.Linux.Namespaces = nil
+
+			Expect(newPlugin(consts.NetnsResolutionNRI).RunPodSandbox(ctx, pod)).To(Succeed())
+			Expect(fakeCNI.AddCalls).To(BeEmpty())
+		})
+
+		It("should use the sandbox pid with the procfs strategy", func() {
+			mockHost.EXPECT().PathExists(procfsNetNS).Return(true)
+			mockHost.EXPECT().IsHostNetworkNamespace(procfsNetNS).Return(false, nil)
+
+			Expect(newPlugin(consts.NetnsResolutionProcfs).RunPodSandbox(ctx, pod)).To(Succeed())
+			Expect(fakeCNI.AddCalls).To(HaveLen(1))
+			Expect(fakeCNI.AddCalls[0].NetNS).To(Equal(procfsNetNS))
+		})
+
+		It("should skip the attachment with the procfs strategy when the sandbox pid is unknown", func() {
+			pod.Pid = 0
+
+			Expect(newPlugin(consts.NetnsResolutionProcfs).RunPodSandbox(ctx, pod)).To(Succeed())
+			Expect(fakeCNI.AddCalls).To(BeEmpty())
+		})
+
+		It("should prefer the sandbox namespaces with the auto strategy", func() {
+			Expect(newPlugin(consts.NetnsResolutionAuto).RunPodSandbox(ctx, pod)).To(Succeed())
+			Expect(fakeCNI.AddCalls).To(HaveLen(1))
+			Expect(fakeCNI.AddCalls[0].NetNS).To(Equal("/var/run/netns/test"))
+		})
+
+		It("should fall back to procfs with the auto strategy when the namespaces are not populated", func() {
+			pod.Linux.Namespaces = nil
+			mockHost.EXPECT().PathExists(procfsNetNS).Return(true)
+			mockHost.EXPECT().IsHostNetworkNamespace(procfsNetNS).Return(false, nil)
+
+			Expect(newPlugin(consts.NetnsResolutionAuto).RunPodSandbox(ctx, pod)).To(Succeed())
+			Expect(fakeCNI.AddCalls).To(HaveLen(1))
+			Expect(fakeCNI.AddCalls[0].NetNS).To(Equal(procfsNetNS))
+		})
+
+		It("should fall back to procfs with the auto strategy when the reported path doesn't exist", func() {
+			pod.Linux.Namespaces = []*api.LinuxNamespace{{Type: "network", Path: "/run/containerd/netns/bind-mount"}}
+			mockHost.EXPECT().PathExists("/run/containerd/netns/bind-mount").Return(false)
+			mockHost.EXPECT().PathExists(procfsNetNS).Return(true)
+			mockHost.EXPECT().IsHostNetworkNamespace(procfsNetNS).Return(false, nil)
+
+			Expect(newPlugin(consts.NetnsResolutionAuto).RunPodSandbox(ctx, pod)).To(Succeed())
+			Expect(fakeCNI.AddCalls).To(HaveLen(1))
+			Expect(fakeCNI.AddCalls[0].NetNS).To(Equal(procfsNetNS))
+		})
+
+		It("should detach without a network namespace with the auto strategy when the sandbox is gone", func() {
+			Expect(podManager.Set(podUID, claimUID, preparedClaim)).To(Succeed())
+			pod.Linux.Namespaces = []*api.LinuxNamespace{{Type: "network", Path: "/run/containerd/netns/gone"}}
+			mockHost.EXPECT().PathExists("/run/containerd/netns/gone").Return(false).Times(2)
+			mockHost.EXPECT().PathExists(procfsNetNS).Return(false)
+
+			Expect(newPlugin(consts.NetnsResolutionAuto).StopPodSandbox(ctx, pod)).To(Succeed())
+			Expect(fakeCNI.DelCalls).To(HaveLen(1))
+			Expect(fakeCNI.DelCalls[0].NetNS).To(BeEmpty())
+		})
+	})
+
+	Context("Plugin registration", func() {
+		var createdStub stub.Stub
+
+		BeforeEach(func() {
+			createdStub = nil
+			DeferCleanup(nri.SetNewStub(func(p interface{}, opts ...stub.Option) (stub.Stub, error) {
+				var err error
+				createdStub, err = stub.New(p, opts...)
+				return createdStub, err
+			}))
+		})
+
+		stubName := func() string {
+			named, ok := createdStub.(interface{ Name() string })
+			Expect(ok).To(BeTrue())
+			return named.Name()
+		}
+
+		It("should pass the plugin name and index to the stub", func() {
+			GinkgoT().Setenv("NRI_PLUGIN_IDX", "")
+			config.Flags.NRIPluginName = "sriov"
+			config.Flags.NRIPluginIndex = "10"
+
+			_, err := nri.NewNRIPlugin(config, podManager, cni.New("test-driver", []string{}, ""))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(stubName()).To(Equal("10-sriov"))
+		})
+
+		It("should keep the index from the environment when the flag is not set", func() {
+			config.Flags.NRIPluginName = "sriov"
+
+			_, err := nri.NewNRIPlugin(config, podManager, cni.New("test-driver", []string{}, ""))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(stubName()).To(Equal("42-sriov"))
+		})
+
+		It("should fail when the runtime sets the index the flag sets too", func() {
+			config.Flags.NRIPluginIndex = "10"
+
+			_, err := nri.NewNRIPlugin(config, podManager, cni.New("test-driver", []string{}, ""))
+			Expect(err).To(MatchError(ContainSubstring(`plugin ID already set ("42")`)))
+			Expect(os.Getenv("NRI_PLUGIN_IDX")).To(Equal("42"))
+		})
+
+		It("should reject an index that is not two digits", func() {
+			Expect(nri.ValidatePluginIndex("")).To(Succeed())
+			Expect(nri.ValidatePluginIndex("10")).To(Succeed())
+			for _, idx := range []string{"1", "100", "a1"} {
+				Expect(nri.ValidatePluginIndex(idx)).To(MatchError(ContainSubstring("invalid NRI plugin index")), idx)
+			}
+		})
+	})
+
+	Context("Host network pods", func() {
+		var claim *resourceapi.ResourceClaim
+
+		BeforeEach(func() {
+			Expect(podManager.Set(podUID, claimUID, preparedClaim)).To(Succeed())
+			claim = &resourceapi.ResourceClaim{
+				ObjectMeta: metav1.ObjectMeta{Name: "test-claim", Namespace: "default", UID: claimUID},
+				Status: resourceapi.ResourceClaimStatus{
+					Devices: []resourceapi.AllocatedDeviceStatus{
+						{Driver: consts.DriverName, Pool: "test-node", Device: "0000-01-00-1"},
+					},
+				},
+			}
+			_, err := clientset.ResourceV1().ResourceClaims("default").Create(ctx, claim, metav1.CreateOptions{})
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		expectHostNetworkCondition := func() {
+			plugin.ProcessPendingNetworkDeviceData(ctx)
+
+			updated, err := clientset.ResourceV1().ResourceClaims("default").Get(ctx, "test-claim", metav1.GetOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(updated.Status.Devices).To(HaveLen(1))
+			Expect(updated.Status.Devices[0].NetworkData).To(BeNil())
+			Expect(updated.Status.Devices[0].Conditions).To(HaveLen(1))
+			Expect(updated.Status.Devices[0].Conditions[0].Type).To(Equal(consts.ConditionTypeNetworkAttached))
+			Expect(updated.Status.Devices[0].Conditions[0].Status).To(Equal(metav1.ConditionFalse))
+			Expect(updated.Status.Devices[0].Conditions[0].Reason).To(Equal(consts.ReasonHostNetwork))
+		}
+
+		It("should not attach a pod sandbox without a network namespace", func() {
+			pod.Linux.Namespaces = nil
+
+			Expect(plugin.RunPodSandbox(ctx, pod)).To(Succeed())
+			Expect(fakeCNI.AddCalls).To(BeEmpty())
+			expectHostNetworkCondition()
+		})
+
+		It("should not attach a pod sandbox in the host network namespace", func() {
+			pod.Linux.Namespaces = []*api.LinuxNamespace{{Type: "network", Path: "/proc/1234/ns/net"}}
+			mockHost.EXPECT().PathExists("/proc/1234/ns/net").Return(true)
+			mockHost.EXPECT().IsHostNetworkNamespace("/proc/1234/ns/net").Return(true, nil)
+
+			Expect(plugin.RunPodSandbox(ctx, pod)).To(Succeed())
+			Expect(fakeCNI.AddCalls).To(BeEmpty())
+			expectHostNetworkCondition()
+		})
+
+		It("should fail when the network namespace can't be checked", func() {
+			pod.Linux.Namespaces = []*api.LinuxNamespace{{Type: "network", Path: "/proc/1234/ns/net"}}
+			mockHost.EXPECT().PathExists("/proc/1234/ns/net").Return(true)
+			mockHost.EXPECT().IsHostNetworkNamespace("/proc/1234/ns/net").Return(false, fmt.Errorf("stat failed"))
+
+			err := plugin.RunPodSandbox(ctx, pod)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("stat failed"))
+			Expect(fakeCNI.AddCalls).To(BeEmpty())
+		})
+
+		It("should skip the detachment on stop", func() {
+			pod.Linux.Namespaces = nil
+
+			Expect(plugin.StopPodSandbox(ctx, pod)).To(Succeed())
+			Expect(fakeCNI.DelCalls).To(BeEmpty())
+		})
+
+		It("should detach the attached pods when the network namespace is no longer reported on stop", func() {
+			Expect(plugin.RunPodSandbox(ctx, pod)).To(Succeed())
+			Expect(fakeCNI.AddCalls).To(HaveLen(1))
+
+			pod.Linux.Namespaces = nil
+			Expect(plugin.StopPodSandbox(ctx, pod)).To(Succeed())
+			Expect(fakeCNI.DelCalls).To(HaveLen(1))
+			Expect(fakeCNI.DelCalls[0].NetNS).To(BeEmpty())
+		})
+	})
+})
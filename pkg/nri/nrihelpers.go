@@ -1,10 +1,42 @@
 package nri
 
 import (
+	"fmt"
+
 	"github.com/containerd/nri/pkg/api"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+	"k8s.io/utils/cpuset"
+
+	"github.com/SchSeba/dra-driver-sriov/pkg/consts"
+	"github.com/SchSeba/dra-driver-sriov/pkg/host"
+	"github.com/SchSeba/dra-driver-sriov/pkg/types"
 )
 
-func getNetworkNamespace(pod *api.PodSandbox) string {
+// getNetworkNamespace returns the network namespace path of the pod sandbox using the given resolution strategy.
+// The auto strategy uses the path reported by the runtime when the driver can see it, the runtimes populating it
+// with a bind-mount outside of the driver mounts are resolved through the /proc/<pid>/ns/net of the sandbox instead.
+// The reported path is kept when neither exists, e.g. for the CNI DEL of a sandbox already gone.
+func getNetworkNamespace(pod *api.PodSandbox, strategy string) string {
+	switch strategy {
+	case consts.NetnsResolutionNRI:
+		return getNRINetworkNamespace(pod)
+	case consts.NetnsResolutionProcfs:
+		return getProcfsNetworkNamespace(pod)
+	default:
+		networkNamespace := getNRINetworkNamespace(pod)
+		if networkNamespace != "" && host.GetHelpers().PathExists(networkNamespace) {
+			return networkNamespace
+		}
+		if procfsNetworkNamespace := getProcfsNetworkNamespace(pod); procfsNetworkNamespace != "" {
+			return procfsNetworkNamespace
+		}
+		return networkNamespace
+	}
+}
+
+// getNRINetworkNamespace returns the network namespace path reported in the pod sandbox linux namespaces
+func getNRINetworkNamespace(pod *api.PodSandbox) string {
 	for _, namespace := range pod.Linux.GetNamespaces() {
 		if namespace.Type == "network" {
 			return namespace.Path
@@ -13,3 +45,108 @@ func getNetworkNamespace(pod *api.PodSandbox) string {
 
 	return ""
 }
+
+// getProcfsNetworkNamespace returns the /proc/<pid>/ns/net path of the pod sandbox process,
+// or an empty string if the runtime didn't report the sandbox pid or the path doesn't exist
+func getProcfsNetworkNamespace(pod *api.PodSandbox) string {
+	if pod.GetPid() == 0 {
+		return ""
+	}
+
+	networkNamespace := fmt.Sprintf("/proc/%d/ns/net", pod.GetPid())
+	if !host.GetHelpers().PathExists(networkNamespace) {
+		return ""
+	}
+	return networkNamespace
+}
+
+// isHostNetwork checks if the pod network namespace is the host one, a pod without a
+// network namespace of its own is considered as using the host network
+func isHostNetwork(networkNamespace string) (bool, error) {
+	if networkNamespace == "" {
+		return true, nil
+	}
+	return host.GetHelpers().IsHostNetworkNamespace(networkNamespace)
+}
+
+// hostNetworkConditions returns the status updates reporting the devices were not attached
+// because the pod uses the host network namespace
+func hostNetworkConditions(devices types.PreparedDevices) types.NetworkDataChanStructList {
+	networkDevicesData := types.NetworkDataChanStructList{}
+	for _, device := range devices {
+		networkDevicesData = append(networkDevicesData, &types.NetworkDataChanStruct{
+			PreparedDevice: device,
+			Condition: &metav1.Condition{
+				Type:    consts.ConditionTypeNetworkAttached,
+				Status:  metav1.ConditionFalse,
+				Reason:  consts.ReasonHostNetwork,
+				Message: "pod uses the host network namespace, the virtual function was not attached",
+			},
+		})
+	}
+	return networkDevicesData
+}
+
+// validateNetnsResolution checks the network namespace resolution strategy is a known one
+func validateNetnsResolution(strategy string) error {
+	switch strategy {
+	case consts.NetnsResolutionAuto, consts.NetnsResolutionNRI, consts.NetnsResolutionProcfs:
+		return nil
+	default:
+		return fmt.Errorf("unknown network namespace resolution %q, must be one of %s, %s or %s",
+			strategy, consts.NetnsResolutionAuto, consts.NetnsResolutionNRI, consts.NetnsResolutionProcfs)
+	}
+}
+
+// ValidatePluginIndex checks the NRI plugin index is the two digit string NRI orders the plugins by,
+// empty keeps the index from the environment or the binary name
+func ValidatePluginIndex(idx string) error {
+	if idx == "" {
+		return nil
+	}
+	if err := api.CheckPluginIndex(idx); err != nil {
+		return fmt.Errorf("invalid NRI plugin index: %w", err)
+	}
+	return nil
+}
+
+// getPodCPUs returns the cpuset the pod sandbox is pinned to, or an empty set if the pod is not pinned
+func getPodCPUs(pod *api.PodSandbox) (cpuset.CPUSet, error) {
+	cpus := pod.GetLinux().GetPodResources().GetCpu().GetCpus()
+	if cpus == "" {
+		return cpuset.New(), nil
+	}
+	return cpuset.Parse(cpus)
+}
+
+// validateNumaAlignment checks that every device requesting NUMA alignment is located
+// on the NUMA node that holds all the CPUs the pod is pinned to. The devices without NUMA affinity are
+// equally close to every CPU and always aligned.
+func validateNumaAlignment(logger klog.Logger, podCPUs cpuset.CPUSet, devices types.PreparedDevices) error {
+	for _, device := range devices {
+		if device.Config == nil || !device.Config.RequireNumaAlignment {
+			continue
+		}
+
+		numaNode, err := host.GetHelpers().GetNumaNodeAffinity(device.PciAddress)
+		if err != nil {
+			return fmt.Errorf("failed to get NUMA node for device %s: %w", device.PciAddress, err)
+		}
+		if numaNode == consts.NoNumaAffinity {
+			logger.V(2).Info("Device has no NUMA affinity, skipping NUMA alignment validation", "device", device.PciAddress)
+			continue
+		}
+
+		numaCPUs, err := host.GetHelpers().GetNumaNodeCPUs(numaNode)
+		if err != nil {
+			return fmt.Errorf("failed to get CPUs for NUMA node %s: %w", numaNode, err)
+		}
+
+		if !podCPUs.IsSubsetOf(numaCPUs) {
+			return fmt.Errorf("device %s on NUMA node %s is not aligned with pod CPUs %s",
+				device.PciAddress, numaNode, podCPUs.String())
+		}
+	}
+
+	return nil
+}
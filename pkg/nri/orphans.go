@@ -0,0 +1,83 @@
+package nri
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	k8stypes "k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/klog/v2"
+
+	"github.com/SchSeba/dra-driver-sriov/pkg/types"
+)
+
+// RunOrphanedPodsReconciler detaches the networks of the orphaned pods every interval until the context is done.
+func (p *Plugin) RunOrphanedPodsReconciler(ctx context.Context, interval time.Duration) {
+	logger := klog.FromContext(ctx).WithName("RunOrphanedPodsReconciler")
+	wait.UntilWithContext(ctx, func(ctx context.Context) {
+		if err := p.ReconcileOrphanedPods(ctx); err != nil {
+			logger.Error(err, "Failed to reconcile the orphaned pods")
+		}
+	}, interval)
+}
+
+// ReconcileOrphanedPods detaches the networks of the pods tracked by the pod manager that no longer exist on the node.
+//
+// A node reboot or a StopPodSandbox event dropped by the runtime leaves the VF attachments and their IPAM leases behind.
+// The detach is best effort and done once per pod, the prepared devices are left to kubelet which unprepares the
+// claims of the deleted pods.
+func (p *Plugin) ReconcileOrphanedPods(ctx context.Context) error {
+	logger := klog.FromContext(ctx).WithName("ReconcileOrphanedPods")
+
+	pods, err := p.k8sClient.CoreV1().Pods("").List(ctx, metav1.ListOptions{
+		FieldSelector: fields.OneTermEqualSelector("spec.nodeName", p.nodeName).String(),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to list the pods of node %s: %w", p.nodeName, err)
+	}
+	livePodUIDs := sets.New[k8stypes.UID]()
+	for _, pod := range pods.Items {
+		livePodUIDs.Insert(pod.UID)
+	}
+
+	trackedPodUIDs := sets.New(p.podManager.GetPodUIDs()...)
+	// forget the orphans kubelet unprepared in the meantime
+	p.detachedOrphans = p.detachedOrphans.Intersection(trackedPodUIDs)
+
+	var errs []error
+	for _, podUID := range sets.List(trackedPodUIDs.Difference(livePodUIDs).Difference(p.detachedOrphans)) {
+		devices, found := p.podManager.GetDevicesByPodUID(podUID)
+		if !found {
+			continue
+		}
+		pod := p.sandboxForPod(podUID)
+		networkNamespace := getNetworkNamespace(pod, p.netnsResolution)
+
+		networkDevicesData := types.NetworkDataChanStructList{}
+		for _, device := range devices {
+			logger.Info("Detaching network of orphaned pod", "deviceName", device.Device.DeviceName, "pod.UID", podUID)
+			if err := p.cniRuntime.DetachNetwork(ctx, pod, networkNamespace, device); err != nil {
+				logger.Error(err, "Failed to detach network of orphaned pod", "deviceName", device.Device.DeviceName, "pod.UID", podUID)
+				errs = append(errs, fmt.Errorf("failed to detach network for device %s of orphaned pod %s: %w", device.Device.DeviceName, podUID, err))
+			}
+			// clear the network data of the device
+			networkDevicesData = append(networkDevicesData, &types.NetworkDataChanStruct{
+				PreparedDevice: device,
+			})
+		}
+		p.networkDeviceDataUpdateChan <- networkDevicesData
+
+		p.podManager.ClearAttached(podUID)
+		p.sandboxesMu.Lock()
+		delete(p.sandboxes, string(podUID))
+		p.sandboxesMu.Unlock()
+		p.detachedOrphans.Insert(podUID)
+	}
+
+	return errors.Join(errs...)
+}
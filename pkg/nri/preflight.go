@@ -0,0 +1,43 @@
+package nri
+
+import (
+	"context"
+	"fmt"
+	stdnet "net"
+	"os"
+	"time"
+
+	"github.com/containerd/nri/pkg/api"
+	"k8s.io/klog/v2"
+)
+
+// preflightDialTimeout bounds the NRI socket dial of the pre-flight check
+const preflightDialTimeout = 5 * time.Second
+
+// dialNRI connects to the NRI socket, replaced in tests
+var dialNRI = func(socketPath string) (stdnet.Conn, error) {
+	return stdnet.DialTimeout("unix", socketPath, preflightDialTimeout)
+}
+
+// CheckAvailable verifies the container runtime accepts NRI plugin connections on its socket.
+// It runs before the devices are published, so a runtime without NRI fails the startup
+// instead of getting pods scheduled on devices that can't be attached.
+func CheckAvailable(ctx context.Context) error {
+	logger := klog.FromContext(ctx).WithName("NRI preflight")
+
+	// a plugin launched by the runtime gets a pre-connected socket instead of dialing it
+	if env := os.Getenv(api.PluginSocketEnvVar); env != "" {
+		logger.V(2).Info("Using the pre-connected NRI socket from the environment", "env", api.PluginSocketEnvVar)
+		return nil
+	}
+
+	conn, err := dialNRI(api.DefaultSocketPath)
+	if err != nil {
+		return fmt.Errorf("NRI is not available on socket %s, make sure NRI is enabled in the container runtime: %w", api.DefaultSocketPath, err)
+	}
+	if err := conn.Close(); err != nil {
+		logger.V(2).Info("Failed to close the NRI pre-flight connection", "error", err)
+	}
+	logger.Info("NRI is available", "socket", api.DefaultSocketPath)
+	return nil
+}
@@ -0,0 +1,58 @@
+package nri_test
+
+import (
+	"context"
+	"fmt"
+	stdnet "net"
+
+	"github.com/containerd/nri/pkg/api"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/SchSeba/dra-driver-sriov/pkg/nri"
+)
+
+var _ = Describe("NRI preflight", func() {
+	var dialedPath string
+
+	BeforeEach(func() {
+		dialedPath = ""
+		GinkgoT().Setenv(api.PluginSocketEnvVar, "")
+	})
+
+	It("should fail when the NRI socket can't be connected", func() {
+		DeferCleanup(nri.SetDialNRI(func(socketPath string) (stdnet.Conn, error) {
+			dialedPath = socketPath
+			return nil, fmt.Errorf("connection refused")
+		}))
+
+		err := nri.CheckAvailable(context.Background())
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).To(ContainSubstring("make sure NRI is enabled in the container runtime"))
+		Expect(err.Error()).To(ContainSubstring("connection refused"))
+		Expect(dialedPath).To(Equal(api.DefaultSocketPath))
+	})
+
+	It("should succeed when the NRI socket accepts the connection", func() {
+		DeferCleanup(nri.SetDialNRI(func(socketPath string) (stdnet.Conn, error) {
+			dialedPath = socketPath
+			client, server := stdnet.Pipe()
+			DeferCleanup(server.Close)
+			return client, nil
+		}))
+
+		Expect(nri.CheckAvailable(context.Background())).To(Succeed())
+		Expect(dialedPath).To(Equal(api.DefaultSocketPath))
+	})
+
+	It("should not dial when the runtime passed a pre-connected socket", func() {
+		GinkgoT().Setenv(api.PluginSocketEnvVar, "3")
+		DeferCleanup(nri.SetDialNRI(func(socketPath string) (stdnet.Conn, error) {
+			dialedPath = socketPath
+			return nil, fmt.Errorf("connection refused")
+		}))
+
+		Expect(nri.CheckAvailable(context.Background())).To(Succeed())
+		Expect(dialedPath).To(BeEmpty())
+	})
+})
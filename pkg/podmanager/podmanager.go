@@ -1,13 +1,21 @@
 package podmanager
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
 	"sync"
+	"time"
 
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/sets"
 	"k8s.io/dynamic-resource-allocation/kubeletplugin"
 	"k8s.io/klog/v2"
 	"k8s.io/kubernetes/pkg/kubelet/checkpointmanager"
+	cmerrors "k8s.io/kubernetes/pkg/kubelet/checkpointmanager/errors"
 
 	"github.com/SchSeba/dra-driver-sriov/pkg/consts"
 	drasriovtypes "github.com/SchSeba/dra-driver-sriov/pkg/types"
@@ -16,13 +24,26 @@ import (
 // PodManager provides a thread-safe, centralized store for all prepared network devices
 // across multiple Pods. It is indexed by the Pod's UID, and for each Pod, it maps
 // claim IDs to their specific PreparedDevices.
+// A reverse index from claim UID to pod UIDs is kept in sync with it.
 type PodManager struct {
 	mu                     sync.RWMutex
 	preparedClaimsByPodUID drasriovtypes.PreparedClaimsByPodUID
-	checkpointManager      checkpointmanager.CheckpointManager
+	podUIDsByClaimUID      map[types.UID]sets.Set[types.UID]
+	// attachedByPodUID keeps the network data of the devices attached to the pods indexed by pod UID and device,
+	// so a RunPodSandbox event delivered again (e.g. after a runtime restart) doesn't attach them twice
+	attachedByPodUID  map[types.UID]map[string]*drasriovtypes.NetworkDataChanStruct
+	checkpointManager checkpointmanager.CheckpointManager
 }
 
 func NewPodManager(config *drasriovtypes.Config) (*PodManager, error) {
+	corruptionPolicy := config.Flags.CheckpointCorruptionPolicy
+	if corruptionPolicy == "" {
+		corruptionPolicy = consts.CheckpointCorruptionPolicyFail
+	}
+	if err := validateCheckpointCorruptionPolicy(corruptionPolicy); err != nil {
+		return nil, err
+	}
+
 	checkpointManager, err := checkpointmanager.NewCheckpointManager(config.DriverPluginPath())
 	if err != nil {
 		return nil, fmt.Errorf("unable to create checkpoint manager: %v", err)
@@ -37,16 +58,33 @@ func NewPodManager(config *drasriovtypes.Config) (*PodManager, error) {
 		mu:                     sync.RWMutex{},
 		checkpointManager:      checkpointManager,
 		preparedClaimsByPodUID: make(drasriovtypes.PreparedClaimsByPodUID),
+		podUIDsByClaimUID:      map[types.UID]sets.Set[types.UID]{},
+		attachedByPodUID:       map[types.UID]map[string]*drasriovtypes.NetworkDataChanStruct{},
 	}
 
 	for _, c := range checkpoints {
 		if c == consts.DriverPluginCheckpointFile {
 			klog.Infof("Found checkpoint: %s", c)
 			checkpoint := drasriovtypes.NewCheckpoint()
-			if err := checkpointManager.GetCheckpoint(consts.DriverPluginCheckpointFile, checkpoint); err != nil {
-				return nil, fmt.Errorf("unable to load checkpoint: %v", err)
+			// GetCheckpoint verifies the checkpoint checksum after unmarshalling it
+			err := checkpointManager.GetCheckpoint(consts.DriverPluginCheckpointFile, checkpoint)
+			if err != nil && isCorruptCheckpoint(err) && corruptionPolicy == consts.CheckpointCorruptionPolicyQuarantine {
+				quarantinePath, err := quarantineCheckpoint(config.DriverPluginPath())
+				if err != nil {
+					return nil, err
+				}
+				klog.Warningf("Checkpoint is corrupted, moved it to %s and starting with an empty checkpoint: the devices prepared before the restart are no longer tracked and must be cleaned up manually", quarantinePath)
+				break
+			}
+			if err != nil {
+				return nil, fmt.Errorf("unable to load checkpoint: %w", err)
 			}
 			podmManager.preparedClaimsByPodUID = checkpoint.V1.PreparedClaimsByPodUID
+			for podUID, preparedDevicesByClaimID := range podmManager.preparedClaimsByPodUID {
+				for claimUID := range preparedDevicesByClaimID {
+					podmManager.indexClaim(claimUID, podUID)
+				}
+			}
 			klog.Infof("Loaded checkpoint with %d pods", len(podmManager.preparedClaimsByPodUID))
 			return podmManager, nil
 		}
@@ -61,6 +99,58 @@ func NewPodManager(config *drasriovtypes.Config) (*PodManager, error) {
 	return podmManager, nil
 }
 
+// Reload replaces the prepared claims with the ones of the checkpoint, so the claims prepared or
+// unprepared by another driver instance sharing the checkpoint are seen. The attached networks are kept.
+func (s *PodManager) Reload() error {
+	checkpoint := drasriovtypes.NewCheckpoint()
+	if err := s.checkpointManager.GetCheckpoint(consts.DriverPluginCheckpointFile, checkpoint); err != nil {
+		return fmt.Errorf("unable to load checkpoint: %w", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.preparedClaimsByPodUID = checkpoint.V1.PreparedClaimsByPodUID
+	if s.preparedClaimsByPodUID == nil {
+		s.preparedClaimsByPodUID = make(drasriovtypes.PreparedClaimsByPodUID)
+	}
+	s.podUIDsByClaimUID = map[types.UID]sets.Set[types.UID]{}
+	for podUID, preparedDevicesByClaimID := range s.preparedClaimsByPodUID {
+		for claimUID := range preparedDevicesByClaimID {
+			s.indexClaim(claimUID, podUID)
+		}
+	}
+	return nil
+}
+
+// validateCheckpointCorruptionPolicy checks the checkpoint corruption policy is a known one
+func validateCheckpointCorruptionPolicy(policy string) error {
+	switch policy {
+	case consts.CheckpointCorruptionPolicyFail, consts.CheckpointCorruptionPolicyQuarantine:
+		return nil
+	default:
+		return fmt.Errorf("unknown checkpoint corruption policy %q, must be %s or %s",
+			policy, consts.CheckpointCorruptionPolicyFail, consts.CheckpointCorruptionPolicyQuarantine)
+	}
+}
+
+// isCorruptCheckpoint returns true when the checkpoint content can't be trusted,
+// either its checksum doesn't match or it isn't valid JSON
+func isCorruptCheckpoint(err error) bool {
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	return errors.Is(err, cmerrors.CorruptCheckpointError{}) || errors.As(err, &syntaxErr) || errors.As(err, &typeErr)
+}
+
+// quarantineCheckpoint moves the checkpoint file aside so it can be inspected, and returns its new path
+func quarantineCheckpoint(checkpointDir string) (string, error) {
+	checkpointPath := filepath.Join(checkpointDir, consts.DriverPluginCheckpointFile)
+	quarantinePath := fmt.Sprintf("%s.corrupt-%s", checkpointPath, time.Now().UTC().Format("20060102T150405Z"))
+	if err := os.Rename(checkpointPath, quarantinePath); err != nil {
+		return "", fmt.Errorf("unable to quarantine the corrupted checkpoint: %w", err)
+	}
+	return quarantinePath, nil
+}
+
 // Set stores the configuration for all prepared devices under a given Pod UID.
 // If a configuration for the Pod UID or claim ID already exists, it will be overwritten.
 func (s *PodManager) Set(podUID types.UID, claimID types.UID, preparedDevices drasriovtypes.PreparedDevices) error {
@@ -70,6 +160,27 @@ func (s *PodManager) Set(podUID types.UID, claimID types.UID, preparedDevices dr
 		s.preparedClaimsByPodUID[podUID] = make(drasriovtypes.PreparedDevicesByClaimID)
 	}
 	s.preparedClaimsByPodUID[podUID][claimID] = preparedDevices
+	s.indexClaim(claimID, podUID)
+
+	return s.syncToCheckpoint()
+}
+
+// Unset removes the prepared devices of a single claim of a Pod UID, the other claims of the pod are kept.
+func (s *PodManager) Unset(podUID types.UID, claimID types.UID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if podConfigs, ok := s.preparedClaimsByPodUID[podUID]; ok {
+		delete(podConfigs, claimID)
+		if len(podConfigs) == 0 {
+			delete(s.preparedClaimsByPodUID, podUID)
+		}
+	}
+	if podUIDs, ok := s.podUIDsByClaimUID[claimID]; ok {
+		podUIDs.Delete(podUID)
+		if podUIDs.Len() == 0 {
+			delete(s.podUIDsByClaimUID, claimID)
+		}
+	}
 
 	return s.syncToCheckpoint()
 }
@@ -102,22 +213,109 @@ func (s *PodManager) GetDevicesByPodUID(podUID types.UID) (drasriovtypes.Prepare
 	return preparedDevices, true
 }
 
+// GetPodUIDs returns the UIDs of all the pods with prepared devices.
+func (s *PodManager) GetPodUIDs() []types.UID {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	podUIDs := make([]types.UID, 0, len(s.preparedClaimsByPodUID))
+	for podUID := range s.preparedClaimsByPodUID {
+		podUIDs = append(podUIDs, podUID)
+	}
+	return podUIDs
+}
+
+// GetClaimUIDsByPodUID returns the sorted UIDs of all the claims prepared for a given Pod UID.
+func (s *PodManager) GetClaimUIDsByPodUID(podUID types.UID) []types.UID {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	claimUIDs := make([]types.UID, 0, len(s.preparedClaimsByPodUID[podUID]))
+	for claimUID := range s.preparedClaimsByPodUID[podUID] {
+		claimUIDs = append(claimUIDs, claimUID)
+	}
+	slices.Sort(claimUIDs)
+	return claimUIDs
+}
+
+// GetPodUIDsByClaimUID returns the sorted UIDs of all the pods a given claim is prepared for.
+func (s *PodManager) GetPodUIDsByClaimUID(claimUID types.UID) []types.UID {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return sets.List(s.podUIDsByClaimUID[claimUID])
+}
+
+// GetPreparedDevices returns the prepared devices of every claim, the claims shared by several pods are returned once
+func (s *PodManager) GetPreparedDevices() drasriovtypes.PreparedDevices {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	seen := sets.New[types.UID]()
+	var preparedDevices drasriovtypes.PreparedDevices
+	for _, preparedDevicesByClaimID := range s.preparedClaimsByPodUID {
+		for claimUID, claimDevices := range preparedDevicesByClaimID {
+			if seen.Has(claimUID) {
+				continue
+			}
+			seen.Insert(claimUID)
+			preparedDevices = append(preparedDevices, claimDevices...)
+		}
+	}
+	return preparedDevices
+}
+
+// Dump returns a consistent snapshot of all the prepared devices indexed by Pod UID and claim UID.
+// The maps and slices are copies, the prepared devices are shared and must not be modified.
+func (s *PodManager) Dump() drasriovtypes.PreparedClaimsByPodUID {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	dump := make(drasriovtypes.PreparedClaimsByPodUID, len(s.preparedClaimsByPodUID))
+	for podUID, preparedDevicesByClaimID := range s.preparedClaimsByPodUID {
+		dump[podUID] = make(drasriovtypes.PreparedDevicesByClaimID, len(preparedDevicesByClaimID))
+		for claimUID, preparedDevices := range preparedDevicesByClaimID {
+			dump[podUID][claimUID] = slices.Clone(preparedDevices)
+		}
+	}
+	return dump
+}
+
 // DeletePod removes all configurations associated with a given Pod UID.
 func (s *PodManager) DeletePod(podUID types.UID) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	delete(s.preparedClaimsByPodUID, podUID)
+	s.deletePod(podUID)
 	return s.syncToCheckpoint()
 }
 
+// SetAttached records the network data of a device attached to the pod.
+func (s *PodManager) SetAttached(podUID types.UID, networkData *drasriovtypes.NetworkDataChanStruct) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.attachedByPodUID[podUID]; !ok {
+		s.attachedByPodUID[podUID] = map[string]*drasriovtypes.NetworkDataChanStruct{}
+	}
+	s.attachedByPodUID[podUID][attachmentKey(networkData.PreparedDevice)] = networkData
+}
+
+// GetAttached returns the network data of the device and true if it is already attached to the pod.
+func (s *PodManager) GetAttached(podUID types.UID, device *drasriovtypes.PreparedDevice) (*drasriovtypes.NetworkDataChanStruct, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	networkData, found := s.attachedByPodUID[podUID][attachmentKey(device)]
+	return networkData, found
+}
+
+// ClearAttached forgets the devices attached to the pod, once their networks are detached.
+func (s *PodManager) ClearAttached(podUID types.UID) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.attachedByPodUID, podUID)
+}
+
 // GetByClaim retrieves the configuration for a specific claim.
 func (s *PodManager) GetByClaim(claim kubeletplugin.NamespacedObject) (drasriovtypes.PreparedDevices, bool) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 	preparedDevices := drasriovtypes.PreparedDevices{}
-	for _, preparedDevicesByClaimID := range s.preparedClaimsByPodUID {
-		devices, found := preparedDevicesByClaimID[claim.UID]
-		if found {
+	for podUID := range s.podUIDsByClaimUID[claim.UID] {
+		if devices, found := s.preparedClaimsByPodUID[podUID][claim.UID]; found {
 			preparedDevices = append(preparedDevices, devices...)
 			return preparedDevices, true
 		}
@@ -130,22 +328,40 @@ func (s *PodManager) GetByClaim(claim kubeletplugin.NamespacedObject) (drasriovt
 func (s *PodManager) DeleteClaim(claim kubeletplugin.NamespacedObject) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	podsToDelete := []types.UID{}
-	for uid, preparedDevicesByClaimID := range s.preparedClaimsByPodUID {
-		_, found := preparedDevicesByClaimID[claim.UID]
-		if found {
-			podsToDelete = append(podsToDelete, uid)
-			break
-		}
+	podUIDs, found := s.podUIDsByClaimUID[claim.UID]
+	if !found {
+		return nil
+	}
+	for _, podUID := range podUIDs.UnsortedList() {
+		s.deletePod(podUID)
 	}
+	return s.syncToCheckpoint()
+}
 
-	if len(podsToDelete) > 0 {
-		for _, uid := range podsToDelete {
-			delete(s.preparedClaimsByPodUID, uid)
+// deletePod removes the pod and its claims from the reverse index, the caller must hold the lock
+func (s *PodManager) deletePod(podUID types.UID) {
+	for claimUID := range s.preparedClaimsByPodUID[podUID] {
+		podUIDs := s.podUIDsByClaimUID[claimUID]
+		podUIDs.Delete(podUID)
+		if podUIDs.Len() == 0 {
+			delete(s.podUIDsByClaimUID, claimUID)
 		}
-		return s.syncToCheckpoint()
 	}
-	return nil
+	delete(s.preparedClaimsByPodUID, podUID)
+	delete(s.attachedByPodUID, podUID)
+}
+
+// attachmentKey identifies a device of a claim among the devices attached to a pod
+func attachmentKey(device *drasriovtypes.PreparedDevice) string {
+	return string(device.ClaimNamespacedName.UID) + "/" + device.Device.PoolName + "/" + device.Device.DeviceName
+}
+
+// indexClaim adds the pod to the reverse index of the claim, the caller must hold the lock
+func (s *PodManager) indexClaim(claimUID, podUID types.UID) {
+	if _, ok := s.podUIDsByClaimUID[claimUID]; !ok {
+		s.podUIDsByClaimUID[claimUID] = sets.New[types.UID]()
+	}
+	s.podUIDsByClaimUID[claimUID].Insert(podUID)
 }
 
 func (s *PodManager) syncToCheckpoint() error {
@@ -1,15 +1,20 @@
 package podmanager_test
 
 import (
+	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
+	"sync"
 
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
+	resourcev1 "k8s.io/api/resource/v1"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/dynamic-resource-allocation/kubeletplugin"
 	drapbv1 "k8s.io/kubelet/pkg/apis/dra/v1beta1"
 
+	"github.com/SchSeba/dra-driver-sriov/pkg/consts"
 	"github.com/SchSeba/dra-driver-sriov/pkg/flags"
 	"github.com/SchSeba/dra-driver-sriov/pkg/podmanager"
 	draTypes "github.com/SchSeba/dra-driver-sriov/pkg/types"
@@ -119,6 +124,63 @@ var _ = Describe("PodManager", func() {
 			Expect(err).To(HaveOccurred())
 			Expect(err.Error()).To(ContainSubstring("unable to create checkpoint manager"))
 		})
+
+		It("should reject an unknown checkpoint corruption policy", func() {
+			config.Flags.CheckpointCorruptionPolicy = "ignore"
+			_, err := podmanager.NewPodManager(config)
+			Expect(err).To(MatchError(ContainSubstring("unknown checkpoint corruption policy")))
+		})
+
+		Context("with a tampered checkpoint", func() {
+			checkpointPath := func() string {
+				return filepath.Join(config.DriverPluginPath(), consts.DriverPluginCheckpointFile)
+			}
+
+			BeforeEach(func() {
+				var err error
+				pm, err = podmanager.NewPodManager(config)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(pm.Set(podUID, claimUID, devices)).To(Succeed())
+
+				// change a prepared device without updating the checksum
+				content, err := os.ReadFile(checkpointPath())
+				Expect(err).NotTo(HaveOccurred())
+				tampered := strings.Replace(string(content), "0000:01:00.0", "0000:01:00.7", 1)
+				Expect(tampered).NotTo(Equal(string(content)))
+				Expect(os.WriteFile(checkpointPath(), []byte(tampered), 0600)).To(Succeed())
+			})
+
+			It("should refuse to start with the fail policy", func() {
+				config.Flags.CheckpointCorruptionPolicy = consts.CheckpointCorruptionPolicyFail
+				_, err := podmanager.NewPodManager(config)
+				Expect(err).To(MatchError(ContainSubstring("checkpoint is corrupted")))
+			})
+
+			It("should refuse to start when no policy is set", func() {
+				_, err := podmanager.NewPodManager(config)
+				Expect(err).To(MatchError(ContainSubstring("unable to load checkpoint")))
+			})
+
+			It("should quarantine the checkpoint and start fresh with the quarantine policy", func() {
+				config.Flags.CheckpointCorruptionPolicy = consts.CheckpointCorruptionPolicyQuarantine
+				pm2, err := podmanager.NewPodManager(config)
+				Expect(err).NotTo(HaveOccurred())
+
+				_, found := pm2.Get(podUID, claimUID)
+				Expect(found).To(BeFalse())
+				Expect(pm2.GetPodUIDs()).To(BeEmpty())
+
+				quarantined, err := filepath.Glob(checkpointPath() + ".corrupt-*")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(quarantined).To(HaveLen(1))
+				Expect(checkpointPath()).To(BeAnExistingFile())
+
+				// the fresh checkpoint is trusted on the next start
+				config.Flags.CheckpointCorruptionPolicy = consts.CheckpointCorruptionPolicyFail
+				_, err = podmanager.NewPodManager(config)
+				Expect(err).NotTo(HaveOccurred())
+			})
+		})
 	})
 
 	Context("Set and Get operations", func() {
@@ -206,6 +268,22 @@ var _ = Describe("PodManager", func() {
 			Expect(len(devices2Retrieved)).To(Equal(1))
 			Expect(devices2Retrieved[0].PciAddress).To(Equal("0000:02:00.0"))
 		})
+
+		It("should unset a single claim and keep the other claims of the pod", func() {
+			claim2UID := types.UID("test-claim-uid-99999")
+			Expect(pm.Set(podUID, claimUID, devices)).To(Succeed())
+			Expect(pm.Set(podUID, claim2UID, devices)).To(Succeed())
+
+			Expect(pm.Unset(podUID, claimUID)).To(Succeed())
+			_, found := pm.Get(podUID, claimUID)
+			Expect(found).To(BeFalse())
+			_, found = pm.Get(podUID, claim2UID)
+			Expect(found).To(BeTrue())
+
+			Expect(pm.Unset(podUID, claim2UID)).To(Succeed())
+			_, found = pm.GetDevicesByPodUID(podUID)
+			Expect(found).To(BeFalse())
+		})
 	})
 
 	Context("GetDevicesByPodUID", func() {
@@ -260,6 +338,26 @@ var _ = Describe("PodManager", func() {
 		})
 	})
 
+	Context("GetPodUIDs", func() {
+		BeforeEach(func() {
+			var err error
+			pm, err = podmanager.NewPodManager(config)
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("should return the UIDs of all pods with prepared devices", func() {
+			pod2UID := types.UID("test-pod-uid-2")
+			Expect(pm.Set(podUID, claimUID, devices)).To(Succeed())
+			Expect(pm.Set(pod2UID, types.UID("test-claim-uid-2"), devices)).To(Succeed())
+
+			Expect(pm.GetPodUIDs()).To(ConsistOf(podUID, pod2UID))
+		})
+
+		It("should return an empty list when no pods are tracked", func() {
+			Expect(pm.GetPodUIDs()).To(BeEmpty())
+		})
+	})
+
 	Context("GetByClaim", func() {
 		BeforeEach(func() {
 			var err error
@@ -311,6 +409,92 @@ var _ = Describe("PodManager", func() {
 		})
 	})
 
+	Context("Claim and pod indexes", func() {
+		var (
+			otherPodUID   types.UID
+			otherClaimUID types.UID
+		)
+
+		BeforeEach(func() {
+			var err error
+			pm, err = podmanager.NewPodManager(config)
+			Expect(err).NotTo(HaveOccurred())
+
+			otherPodUID = types.UID("other-pod-uid")
+			otherClaimUID = types.UID("other-claim-uid")
+			Expect(pm.Set(podUID, claimUID, devices[:1])).To(Succeed())
+			Expect(pm.Set(podUID, otherClaimUID, devices[1:])).To(Succeed())
+			Expect(pm.Set(otherPodUID, claimUID, devices[:1])).To(Succeed())
+		})
+
+		It("should list the claims of a pod and the pods of a claim", func() {
+			Expect(pm.GetClaimUIDsByPodUID(podUID)).To(Equal([]types.UID{otherClaimUID, claimUID}))
+			Expect(pm.GetClaimUIDsByPodUID(otherPodUID)).To(Equal([]types.UID{claimUID}))
+			Expect(pm.GetPodUIDsByClaimUID(claimUID)).To(Equal([]types.UID{otherPodUID, podUID}))
+			Expect(pm.GetPodUIDsByClaimUID(otherClaimUID)).To(Equal([]types.UID{podUID}))
+		})
+
+		It("should return empty lists for unknown UIDs", func() {
+			Expect(pm.GetClaimUIDsByPodUID("non-existent-pod")).To(BeEmpty())
+			Expect(pm.GetPodUIDsByClaimUID("non-existent-claim")).To(BeEmpty())
+		})
+
+		It("should update the claim index when a pod is deleted", func() {
+			Expect(pm.DeletePod(podUID)).To(Succeed())
+
+			Expect(pm.GetClaimUIDsByPodUID(podUID)).To(BeEmpty())
+			Expect(pm.GetPodUIDsByClaimUID(claimUID)).To(Equal([]types.UID{otherPodUID}))
+			Expect(pm.GetPodUIDsByClaimUID(otherClaimUID)).To(BeEmpty())
+		})
+
+		It("should update both indexes when a claim is deleted", func() {
+			Expect(pm.DeleteClaim(kubeletplugin.NamespacedObject{UID: otherClaimUID})).To(Succeed())
+
+			Expect(pm.GetClaimUIDsByPodUID(podUID)).To(BeEmpty())
+			Expect(pm.GetPodUIDsByClaimUID(otherClaimUID)).To(BeEmpty())
+			Expect(pm.GetPodUIDsByClaimUID(claimUID)).To(Equal([]types.UID{otherPodUID}))
+		})
+
+		It("should rebuild the claim index from the checkpoint", func() {
+			pm2, err := podmanager.NewPodManager(config)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(pm2.GetPodUIDsByClaimUID(claimUID)).To(Equal([]types.UID{otherPodUID, podUID}))
+			Expect(pm2.GetPodUIDsByClaimUID(otherClaimUID)).To(Equal([]types.UID{podUID}))
+		})
+
+		It("should reload the claims changed by another instance sharing the checkpoint", func() {
+			pm2, err := podmanager.NewPodManager(config)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(pm2.DeletePod(otherPodUID)).To(Succeed())
+			Expect(pm.GetPodUIDsByClaimUID(claimUID)).To(Equal([]types.UID{otherPodUID, podUID}))
+
+			Expect(pm.Reload()).To(Succeed())
+			Expect(pm.GetPodUIDsByClaimUID(claimUID)).To(Equal([]types.UID{podUID}))
+			_, found := pm.GetDevicesByPodUID(otherPodUID)
+			Expect(found).To(BeFalse())
+		})
+
+		It("should return the devices of the claims shared by several pods once", func() {
+			Expect(pm.GetPreparedDevices()).To(ConsistOf(devices[0], devices[1]))
+		})
+
+		It("should dump a snapshot that is not affected by later changes", func() {
+			dump := pm.Dump()
+			Expect(dump).To(HaveLen(2))
+			Expect(dump[podUID]).To(HaveKeyWithValue(claimUID, devices[:1]))
+			Expect(dump[podUID]).To(HaveKeyWithValue(otherClaimUID, devices[1:]))
+			Expect(dump[otherPodUID]).To(HaveKeyWithValue(claimUID, devices[:1]))
+
+			Expect(pm.DeletePod(podUID)).To(Succeed())
+			Expect(pm.Set(otherPodUID, claimUID, devices)).To(Succeed())
+
+			Expect(dump).To(HaveKey(podUID))
+			Expect(dump[otherPodUID][claimUID]).To(HaveLen(1))
+		})
+	})
+
 	Context("Delete operations", func() {
 		BeforeEach(func() {
 			var err error
@@ -385,6 +569,47 @@ var _ = Describe("PodManager", func() {
 		})
 	})
 
+	Context("Attached devices", func() {
+		BeforeEach(func() {
+			var err error
+			pm, err = podmanager.NewPodManager(config)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(pm.Set(podUID, claimUID, devices)).To(Succeed())
+		})
+
+		It("should track the network data of the attached devices per pod", func() {
+			networkData := &draTypes.NetworkDataChanStruct{
+				PreparedDevice:    devices[0],
+				NetworkDeviceData: &resourcev1.NetworkDeviceData{InterfaceName: "net1", IPs: []string{"10.0.0.2/24"}},
+			}
+			pm.SetAttached(podUID, networkData)
+
+			attached, found := pm.GetAttached(podUID, devices[0])
+			Expect(found).To(BeTrue())
+			Expect(attached).To(Equal(networkData))
+			_, found = pm.GetAttached(podUID, devices[1])
+			Expect(found).To(BeFalse())
+			_, found = pm.GetAttached(types.UID("other-pod"), devices[0])
+			Expect(found).To(BeFalse())
+		})
+
+		It("should forget the attached devices once cleared", func() {
+			pm.SetAttached(podUID, &draTypes.NetworkDataChanStruct{PreparedDevice: devices[0]})
+			pm.ClearAttached(podUID)
+
+			_, found := pm.GetAttached(podUID, devices[0])
+			Expect(found).To(BeFalse())
+		})
+
+		It("should forget the attached devices of a deleted pod", func() {
+			pm.SetAttached(podUID, &draTypes.NetworkDataChanStruct{PreparedDevice: devices[0]})
+			Expect(pm.DeleteClaim(kubeletplugin.NamespacedObject{UID: claimUID})).To(Succeed())
+
+			_, found := pm.GetAttached(podUID, devices[0])
+			Expect(found).To(BeFalse())
+		})
+	})
+
 	Context("Checkpoint synchronization", func() {
 		BeforeEach(func() {
 			var err error
@@ -465,6 +690,44 @@ var _ = Describe("PodManager", func() {
 			<-done
 			<-done
 		})
+
+		It("should keep both indexes consistent under concurrent adds and deletes", func() {
+			var wg sync.WaitGroup
+			for i := 0; i < 10; i++ {
+				wg.Add(1)
+				go func(i int) {
+					defer GinkgoRecover()
+					defer wg.Done()
+					testPodUID := types.UID(fmt.Sprintf("test-pod-%d", i))
+					for j := 0; j < 5; j++ {
+						testClaimUID := types.UID(fmt.Sprintf("test-claim-%d", j))
+						Expect(pm.Set(testPodUID, testClaimUID, devices[:1])).To(Succeed())
+						_ = pm.GetPodUIDsByClaimUID(testClaimUID)
+						_ = pm.Dump()
+					}
+					if i%2 == 0 {
+						Expect(pm.DeletePod(testPodUID)).To(Succeed())
+					}
+				}(i)
+			}
+			wg.Wait()
+
+			dump := pm.Dump()
+			Expect(dump).To(HaveLen(5))
+			for podUID, preparedDevicesByClaimID := range dump {
+				Expect(pm.GetClaimUIDsByPodUID(podUID)).To(HaveLen(len(preparedDevicesByClaimID)))
+				for claimUID := range preparedDevicesByClaimID {
+					Expect(pm.GetPodUIDsByClaimUID(claimUID)).To(ContainElement(podUID))
+				}
+			}
+			for j := 0; j < 5; j++ {
+				podUIDs := pm.GetPodUIDsByClaimUID(types.UID(fmt.Sprintf("test-claim-%d", j)))
+				Expect(podUIDs).To(HaveLen(5))
+				for _, podUID := range podUIDs {
+					Expect(dump).To(HaveKey(podUID))
+				}
+			}
+		})
 	})
 
 	Context("Edge cases", func() {
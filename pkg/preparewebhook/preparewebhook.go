@@ -0,0 +1,124 @@
+// Package preparewebhook calls a user provided webhook deciding if a claim can be prepared,
+// so cluster policies (e.g. which namespaces may request trust mode) are enforced on the node.
+package preparewebhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+
+	resourceapi "k8s.io/api/resource/v1"
+	"k8s.io/klog/v2"
+
+	configapi "github.com/SchSeba/dra-driver-sriov/pkg/api/virtualfunction/v1alpha1"
+)
+
+const (
+	// timeout bounds a webhook call, a webhook not answering in time denies the prepare
+	timeout = 10 * time.Second
+	// maxResponseSize bounds the webhook response read
+	maxResponseSize = 64 * 1024
+)
+
+// Review is the body POSTed to the webhook
+type Review struct {
+	Claim   *resourceapi.ResourceClaim `json:"claim"`
+	Devices []ReviewDevice             `json:"devices"`
+}
+
+// ReviewDevice is a device of the claim with the VfConfig resolved for it
+type ReviewDevice struct {
+	Request string              `json:"request"`
+	Pool    string              `json:"pool"`
+	Device  string              `json:"device"`
+	Config  *configapi.VfConfig `json:"config"`
+}
+
+// Response is the body returned by the webhook
+type Response struct {
+	Allowed bool   `json:"allowed"`
+	Reason  string `json:"reason,omitempty"`
+}
+
+// Client calls the prepare webhook
+type Client struct {
+	url        string
+	httpClient *http.Client
+}
+
+// New returns a client for the webhook at the given https URL. The webhook certificate is verified with
+// the CA certificates of the PEM caFile, or with the system ones when caFile is empty.
+func New(webhookURL, caFile string) (*Client, error) {
+	parsed, err := url.Parse(webhookURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid prepare webhook URL %q: %w", webhookURL, err)
+	}
+	if parsed.Scheme != "https" || parsed.Host == "" {
+		return nil, fmt.Errorf("invalid prepare webhook URL %q, must be an absolute https URL", webhookURL)
+	}
+
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	if caFile != "" {
+		caCerts, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("error reading the prepare webhook CA file: %w", err)
+		}
+		rootCAs := x509.NewCertPool()
+		if !rootCAs.AppendCertsFromPEM(caCerts) {
+			return nil, fmt.Errorf("prepare webhook CA file %s holds no PEM certificate", caFile)
+		}
+		tlsConfig.RootCAs = rootCAs
+	}
+	return &Client{
+		url: webhookURL,
+		httpClient: &http.Client{
+			Timeout:   timeout,
+			Transport: &http.Transport{TLSClientConfig: tlsConfig},
+		},
+	}, nil
+}
+
+// Review asks the webhook if the claim can be prepared with the resolved configs.
+// It fails closed: an error is returned when the webhook denies the claim and when it can't be reached
+// or returns an unexpected response.
+func (c *Client) Review(ctx context.Context, review *Review) error {
+	logger := klog.FromContext(ctx).WithName("preparewebhook")
+
+	body, err := json.Marshal(review)
+	if err != nil {
+		return fmt.Errorf("error marshalling the prepare webhook review: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("error creating the prepare webhook request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("prepare webhook call failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("prepare webhook returned status %d", resp.StatusCode)
+	}
+	response := &Response{}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxResponseSize)).Decode(response); err != nil {
+		return fmt.Errorf("error decoding the prepare webhook response: %w", err)
+	}
+	if !response.Allowed {
+		return fmt.Errorf("prepare denied by webhook: %s", response.Reason)
+	}
+
+	logger.V(3).Info("Prepare allowed by webhook", "claim", review.Claim.Namespace+"/"+review.Claim.Name)
+	return nil
+}
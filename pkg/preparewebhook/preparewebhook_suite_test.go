@@ -0,0 +1,13 @@
+package preparewebhook_test
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+func TestPrepareWebhook(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "PrepareWebhook Suite")
+}
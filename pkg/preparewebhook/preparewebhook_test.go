@@ -0,0 +1,143 @@
+package preparewebhook_test
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	resourceapi "k8s.io/api/resource/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	configapi "github.com/SchSeba/dra-driver-sriov/pkg/api/virtualfunction/v1alpha1"
+	"github.com/SchSeba/dra-driver-sriov/pkg/preparewebhook"
+)
+
+var _ = Describe("PrepareWebhook", func() {
+	var (
+		review   *preparewebhook.Review
+		received *preparewebhook.Review
+	)
+
+	// writeCAFile writes the certificate of the TLS server in a PEM CA file
+	writeCAFile := func(server *httptest.Server) string {
+		caFile := filepath.Join(GinkgoT().TempDir(), "ca.pem")
+		Expect(os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}), 0600)).To(Succeed())
+		return caFile
+	}
+
+	// newWebhook serves the webhook with the handler and records the received review
+	newWebhook := func(handler func(w http.ResponseWriter)) *preparewebhook.Client {
+		server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			defer GinkgoRecover()
+			Expect(r.Method).To(Equal(http.MethodPost))
+			Expect(r.Header.Get("Content-Type")).To(Equal("application/json"))
+			received = &preparewebhook.Review{}
+			Expect(json.NewDecoder(r.Body).Decode(received)).To(Succeed())
+			handler(w)
+		}))
+		DeferCleanup(server.Close)
+
+		client, err := preparewebhook.New(server.URL, writeCAFile(server))
+		Expect(err).NotTo(HaveOccurred())
+		return client
+	}
+
+	respond := func(response preparewebhook.Response) func(w http.ResponseWriter) {
+		return func(w http.ResponseWriter) {
+			Expect(json.NewEncoder(w).Encode(response)).To(Succeed())
+		}
+	}
+
+	BeforeEach(func() {
+		received = nil
+		review = &preparewebhook.Review{
+			Claim: &resourceapi.ResourceClaim{
+				ObjectMeta: metav1.ObjectMeta{Name: "claim-1", Namespace: "tenant-a"},
+			},
+			Devices: []preparewebhook.ReviewDevice{
+				{
+					Request: "vf",
+					Pool:    "node1",
+					Device:  "0000-01-00-2",
+					Config:  &configapi.VfConfig{IfName: "net1", Vlan: 100},
+				},
+			},
+		}
+	})
+
+	It("should allow the prepare when the webhook allows it", func() {
+		client := newWebhook(respond(preparewebhook.Response{Allowed: true}))
+
+		Expect(client.Review(context.Background(), review)).To(Succeed())
+		Expect(received.Claim.Namespace).To(Equal("tenant-a"))
+		Expect(received.Devices).To(HaveLen(1))
+		Expect(received.Devices[0].Device).To(Equal("0000-01-00-2"))
+		Expect(received.Devices[0].Config.Vlan).To(Equal(100))
+	})
+
+	It("should deny the prepare with the webhook reason", func() {
+		client := newWebhook(respond(preparewebhook.Response{Allowed: false, Reason: "VLAN 100 is not allowed in namespace tenant-a"}))
+
+		err := client.Review(context.Background(), review)
+		Expect(err).To(MatchError("prepare denied by webhook: VLAN 100 is not allowed in namespace tenant-a"))
+	})
+
+	It("should fail closed when the webhook returns an error status", func() {
+		client := newWebhook(func(w http.ResponseWriter) {
+			w.WriteHeader(http.StatusInternalServerError)
+		})
+
+		Expect(client.Review(context.Background(), review)).To(MatchError("prepare webhook returned status 500"))
+	})
+
+	It("should fail closed when the webhook response is not valid", func() {
+		client := newWebhook(func(w http.ResponseWriter) {
+			_, _ = w.Write([]byte("allowed"))
+		})
+
+		Expect(client.Review(context.Background(), review)).To(MatchError(ContainSubstring("error decoding the prepare webhook response")))
+	})
+
+	It("should fail closed when the webhook can't be reached", func() {
+		server := httptest.NewTLSServer(http.NotFoundHandler())
+		client, err := preparewebhook.New(server.URL, writeCAFile(server))
+		Expect(err).NotTo(HaveOccurred())
+		server.Close()
+
+		Expect(client.Review(context.Background(), review)).To(MatchError(ContainSubstring("prepare webhook call failed")))
+	})
+
+	It("should fail closed when the webhook certificate is not signed by a trusted CA", func() {
+		server := httptest.NewTLSServer(http.NotFoundHandler())
+		DeferCleanup(server.Close)
+		client, err := preparewebhook.New(server.URL, "")
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(client.Review(context.Background(), review)).To(MatchError(ContainSubstring("certificate signed by unknown authority")))
+	})
+
+	It("should reject a URL that is not an absolute https URL", func() {
+		_, err := preparewebhook.New("http://webhook.example.com/allow", "")
+		Expect(err).To(MatchError(ContainSubstring("must be an absolute https URL")))
+		_, err = preparewebhook.New("unix:///run/webhook.sock", "")
+		Expect(err).To(HaveOccurred())
+		_, err = preparewebhook.New("/allow", "")
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("should reject a CA file without a PEM certificate", func() {
+		caFile := filepath.Join(GinkgoT().TempDir(), "ca.pem")
+		Expect(os.WriteFile(caFile, []byte("not a certificate"), 0600)).To(Succeed())
+
+		_, err := preparewebhook.New("https://webhook.example.com/allow", caFile)
+		Expect(err).To(MatchError(ContainSubstring("holds no PEM certificate")))
+		_, err = preparewebhook.New("https://webhook.example.com/allow", filepath.Join(GinkgoT().TempDir(), "missing.pem"))
+		Expect(err).To(MatchError(ContainSubstring("error reading the prepare webhook CA file")))
+	})
+})
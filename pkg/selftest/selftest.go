@@ -0,0 +1,100 @@
+// Package selftest checks that the node is ready to run the driver without starting the plugin.
+package selftest
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/SchSeba/dra-driver-sriov/pkg/devicestate"
+	"github.com/SchSeba/dra-driver-sriov/pkg/types"
+)
+
+// Result is the outcome of a single check
+type Result struct {
+	Name string
+	// Message describes what the check found when it passed
+	Message string
+	Err     error
+}
+
+// Run runs all the checks against the node
+func Run(ctx context.Context, config *types.Config, cniBinDir string) []Result {
+	return []Result{
+		check("SR-IOV discovery", func() (string, error) {
+			return CheckDiscovery(ctx, config.Flags.DeviceNaming, config.Flags.MaxVFsPerNode, config.Flags.DiscoveryConcurrency)
+		}),
+		check("CNI bin directory", func() (string, error) {
+			return CheckCNIBinDir(cniBinDir)
+		}),
+		check("kubelet plugins directory", func() (string, error) {
+			return CheckDirWritable(config.Flags.KubeletPluginsDirectoryPath)
+		}),
+	}
+}
+
+// PrintReport writes the check results and returns true when all the checks passed
+func PrintReport(w io.Writer, results []Result) bool {
+	ready := true
+	for _, result := range results {
+		if result.Err != nil {
+			ready = false
+			fmt.Fprintf(w, "[FAIL] %s: %v\n", result.Name, result.Err)
+			continue
+		}
+		fmt.Fprintf(w, "[ OK ] %s: %s\n", result.Name, result.Message)
+	}
+	if ready {
+		fmt.Fprintln(w, "Node is ready for SR-IOV DRA")
+	} else {
+		fmt.Fprintln(w, "Node is not ready for SR-IOV DRA")
+	}
+	return ready
+}
+
+// CheckDiscovery runs the device discovery and fails when no VF is found
+func CheckDiscovery(ctx context.Context, deviceNaming string, maxVFs int, concurrency int) (string, error) {
+	devices, err := devicestate.DiscoverSriovDevices(ctx, deviceNaming, maxVFs, nil, concurrency)
+	if err != nil {
+		return "", err
+	}
+	if len(devices) == 0 {
+		return "", fmt.Errorf("no SR-IOV virtual function found, make sure VFs are enabled on the physical functions")
+	}
+	return fmt.Sprintf("%d virtual functions found", len(devices)), nil
+}
+
+// CheckCNIBinDir fails when the CNI bin directory is missing
+func CheckCNIBinDir(path string) (string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", fmt.Errorf("CNI bin directory %s is not available: %w", path, err)
+	}
+	if !info.IsDir() {
+		return "", fmt.Errorf("CNI bin directory %s is not a directory", path)
+	}
+	return fmt.Sprintf("%s is present", path), nil
+}
+
+// CheckDirWritable fails when a file can't be created in the directory
+func CheckDirWritable(path string) (string, error) {
+	file, err := os.CreateTemp(path, ".selftest-")
+	if err != nil {
+		return "", fmt.Errorf("directory %s is not writable: %w", path, err)
+	}
+	name := file.Name()
+	if err := file.Close(); err != nil {
+		return "", fmt.Errorf("error closing %s: %w", name, err)
+	}
+	if err := os.Remove(name); err != nil {
+		return "", fmt.Errorf("error removing %s: %w", name, err)
+	}
+	return fmt.Sprintf("%s is writable", path), nil
+}
+
+// check runs a check and wraps its outcome in a Result
+func check(name string, run func() (string, error)) Result {
+	message, err := run()
+	return Result{Name: name, Message: message, Err: err}
+}
@@ -0,0 +1,130 @@
+package selftest_test
+
+import (
+	"context"
+
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/jaypipes/ghw"
+	"github.com/jaypipes/ghw/pkg/pci"
+	"github.com/jaypipes/pcidb"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"go.uber.org/mock/gomock"
+
+	"github.com/SchSeba/dra-driver-sriov/pkg/consts"
+	"github.com/SchSeba/dra-driver-sriov/pkg/host"
+	mock_host "github.com/SchSeba/dra-driver-sriov/pkg/host/mock"
+	"github.com/SchSeba/dra-driver-sriov/pkg/selftest"
+)
+
+var _ = Describe("Selftest", func() {
+	var tempDir string
+
+	BeforeEach(func() {
+		tempDir = GinkgoT().TempDir()
+	})
+
+	Context("CheckDiscovery", func() {
+		var (
+			mockCtrl     *gomock.Controller
+			mockHost     *mock_host.MockInterface
+			originalHost host.Interface
+		)
+
+		BeforeEach(func() {
+			mockCtrl = gomock.NewController(GinkgoT())
+			mockHost = mock_host.NewMockInterface(mockCtrl)
+			originalHost = host.GetHelpers()
+			host.Helpers = mockHost
+		})
+
+		AfterEach(func() {
+			host.Helpers = originalHost
+			mockCtrl.Finish()
+		})
+
+		It("should fail when no VF is found", func() {
+			mockHost.EXPECT().PCI().Return(&ghw.PCIInfo{
+				Devices: []*pci.Device{{
+					Address: "0000:00:1f.0",
+					Vendor:  &pcidb.Vendor{ID: "8086"},
+					Product: &pcidb.Product{ID: "a30d"},
+					Class:   &pcidb.Class{ID: "06"},
+				}},
+			}, nil)
+
+			_, err := selftest.CheckDiscovery(context.Background(), consts.DeviceNamingPCI, 0, 0)
+			Expect(err).To(MatchError(ContainSubstring("no SR-IOV virtual function found")))
+		})
+
+		It("should fail when the discovery fails", func() {
+			_, err := selftest.CheckDiscovery(context.Background(), "unknown", 0, 0)
+			Expect(err).To(MatchError(ContainSubstring("unknown device naming scheme")))
+		})
+	})
+
+	Context("CheckCNIBinDir", func() {
+		It("should pass when the directory exists", func() {
+			message, err := selftest.CheckCNIBinDir(tempDir)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(message).To(ContainSubstring(tempDir))
+		})
+
+		It("should fail when the directory is missing", func() {
+			_, err := selftest.CheckCNIBinDir(filepath.Join(tempDir, "missing"))
+			Expect(err).To(MatchError(os.ErrNotExist))
+		})
+
+		It("should fail when the path is a file", func() {
+			path := filepath.Join(tempDir, "file")
+			Expect(os.WriteFile(path, nil, 0600)).To(Succeed())
+
+			_, err := selftest.CheckCNIBinDir(path)
+			Expect(err).To(MatchError(ContainSubstring("is not a directory")))
+		})
+	})
+
+	Context("CheckDirWritable", func() {
+		It("should pass and leave no file behind when the directory is writable", func() {
+			_, err := selftest.CheckDirWritable(tempDir)
+			Expect(err).NotTo(HaveOccurred())
+
+			entries, err := os.ReadDir(tempDir)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(entries).To(BeEmpty())
+		})
+
+		It("should fail when the directory is missing", func() {
+			_, err := selftest.CheckDirWritable(filepath.Join(tempDir, "missing"))
+			Expect(err).To(MatchError(ContainSubstring("is not writable")))
+		})
+	})
+
+	Context("PrintReport", func() {
+		It("should report a ready node when all the checks passed", func() {
+			var out bytes.Buffer
+			ready := selftest.PrintReport(&out, []selftest.Result{
+				{Name: "first", Message: "fine"},
+				{Name: "second", Message: "fine too"},
+			})
+			Expect(ready).To(BeTrue())
+			Expect(out.String()).To(ContainSubstring("[ OK ] first: fine"))
+			Expect(out.String()).To(ContainSubstring("Node is ready"))
+		})
+
+		It("should report a node not ready when a check failed", func() {
+			var out bytes.Buffer
+			ready := selftest.PrintReport(&out, []selftest.Result{
+				{Name: "first", Message: "fine"},
+				{Name: "second", Err: fmt.Errorf("broken")},
+			})
+			Expect(ready).To(BeFalse())
+			Expect(out.String()).To(ContainSubstring("[FAIL] second: broken"))
+			Expect(out.String()).To(ContainSubstring("Node is not ready"))
+		})
+	})
+})
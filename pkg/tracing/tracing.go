@@ -0,0 +1,68 @@
+// Package tracing sets up the OpenTelemetry tracing of the prepare and CNI operations.
+// The tracing is opt-in, without an endpoint the spans are not recorded.
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/SchSeba/dra-driver-sriov/pkg/consts"
+)
+
+const (
+	// AttributeClaimUID is the span attribute holding the UID of the claim being processed
+	AttributeClaimUID = attribute.Key("claim.uid")
+	// AttributeDevice is the span attribute holding the name of the device being processed
+	AttributeDevice = attribute.Key("device.name")
+)
+
+// Setup exports the spans to the OTLP HTTP collector at the endpoint URL (e.g. http://collector:4318)
+// and returns the function flushing the pending spans on shutdown.
+// When the endpoint is empty the tracing stays disabled and the returned function does nothing.
+func Setup(ctx context.Context, endpoint string) (func(context.Context) error, error) {
+	if endpoint == "" {
+		return func(context.Context) error { return nil }, nil
+	}
+	parsed, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("invalid OpenTelemetry endpoint %q: %w", endpoint, err)
+	}
+	if (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return nil, fmt.Errorf("invalid OpenTelemetry endpoint %q, must be an absolute http or https URL", endpoint)
+	}
+
+	exporter, err := otlptracehttp.New(ctx, otlptracehttp.WithEndpointURL(endpoint))
+	if err != nil {
+		return nil, fmt.Errorf("error creating the OpenTelemetry exporter: %w", err)
+	}
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewSchemaless(semconv.ServiceName(consts.DriverName))),
+	)
+	otel.SetTracerProvider(provider)
+	return provider.Shutdown, nil
+}
+
+// Start starts a span of the driver tracer, the span is a child of the span of the context if any
+func Start(ctx context.Context, name string, attributes ...attribute.KeyValue) (context.Context, trace.Span) {
+	return otel.Tracer(consts.DriverName).Start(ctx, name, trace.WithAttributes(attributes...))
+}
+
+// End records the error of the operation on the span, if any, and ends it
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
@@ -0,0 +1,69 @@
+package tracing_test
+
+import (
+	"context"
+	"fmt"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+
+	"github.com/SchSeba/dra-driver-sriov/pkg/tracing"
+)
+
+var _ = Describe("Tracing", func() {
+	Context("Setup", func() {
+		It("should leave the tracing disabled without an endpoint", func() {
+			originalProvider := otel.GetTracerProvider()
+			shutdown, err := tracing.Setup(context.Background(), "")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(otel.GetTracerProvider()).To(BeIdenticalTo(originalProvider))
+			Expect(shutdown(context.Background())).To(Succeed())
+		})
+
+		DescribeTable("should reject an invalid endpoint",
+			func(endpoint string) {
+				_, err := tracing.Setup(context.Background(), endpoint)
+				Expect(err).To(MatchError(ContainSubstring("invalid OpenTelemetry endpoint")))
+			},
+			Entry("without a scheme", "collector:4318"),
+			Entry("with an unsupported scheme", "grpc://collector:4317"),
+			Entry("without a host", "http://"),
+		)
+	})
+
+	Context("Start and End", func() {
+		var exporter *tracetest.InMemoryExporter
+
+		BeforeEach(func() {
+			exporter = tracetest.NewInMemoryExporter()
+			provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+			originalProvider := otel.GetTracerProvider()
+			otel.SetTracerProvider(provider)
+			DeferCleanup(func() {
+				otel.SetTracerProvider(originalProvider)
+				Expect(provider.Shutdown(context.Background())).To(Succeed())
+			})
+		})
+
+		It("should nest the spans and record the errors", func() {
+			ctx, parent := tracing.Start(context.Background(), "parent", tracing.AttributeClaimUID.String("claim-1"))
+			_, child := tracing.Start(ctx, "child", tracing.AttributeDevice.String("0000-01-00-1"))
+			tracing.End(child, fmt.Errorf("failed"))
+			tracing.End(parent, nil)
+
+			spans := exporter.GetSpans()
+			Expect(spans).To(HaveLen(2))
+			Expect(spans[0].Name).To(Equal("child"))
+			Expect(spans[0].Parent.SpanID()).To(Equal(spans[1].SpanContext.SpanID()))
+			Expect(spans[0].Attributes).To(ContainElement(tracing.AttributeDevice.String("0000-01-00-1")))
+			Expect(spans[0].Status.Code).To(Equal(codes.Error))
+			Expect(spans[0].Status.Description).To(Equal("failed"))
+			Expect(spans[1].Attributes).To(ContainElement(tracing.AttributeClaimUID.String("claim-1")))
+			Expect(spans[1].Status.Code).To(Equal(codes.Unset))
+		})
+	})
+})
@@ -1,7 +1,14 @@
 package types
 
 import (
+	"fmt"
+	"net"
 	"path/filepath"
+	"strings"
+	"time"
+
+	resourceapi "k8s.io/api/resource/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
 
 	"github.com/SchSeba/dra-driver-sriov/pkg/consts"
 	"github.com/SchSeba/dra-driver-sriov/pkg/flags"
@@ -12,12 +19,61 @@ type Flags struct {
 	LoggingConfig    *flags.LoggingConfig
 
 	NodeName                      string
+	PodUID                        string
 	Namespace                     string
 	CdiRoot                       string
+	CdiVendor                     string
+	CdiSpecMode                   string
+	CdiSpecOwner                  string
+	CdiSpecVersion                string
+	SkipCDICommonSpec             bool
+	GCStaleCDI                    bool
+	IsolateCNICache               bool
+	EnvPrefix                     string
 	KubeletRegistrarDirectoryPath string
 	KubeletPluginsDirectoryPath   string
 	HealthcheckPort               int
+	HealthcheckBindAddress        string
 	DefaultInterfacePrefix        string
+	IfNameTemplate                string
+	DrainOnShutdown               bool
+	SeamlessUpgrade               bool
+	MaxAllocationsPerPF           int
+	NetnsResolution               string
+	NRIPluginName                 string
+	NRIPluginIndex                string
+	VerifyVFReset                 bool
+	VFResetGracePeriod            time.Duration
+	VFNetdevWaitTimeout           time.Duration
+	DeviceNaming                  string
+	MaxVFsPerNode                 int
+	DiscoveryConcurrency          int
+	DefaultVfConfigFile           string
+	ManageEswitchMode             string
+	LinkStateRefreshInterval      time.Duration
+	VFStatsInterval               time.Duration
+	OrphanedPodsReconcileInterval time.Duration
+	PCIHotplugDebounceWindow      time.Duration
+	DebugHTTPPort                 int
+	PoolName                      string
+	DrainedPFsFile                string
+	RepublishDebounceWindow       time.Duration
+	RegistrationRetryTimeout      time.Duration
+	CheckpointCorruptionPolicy    string
+	SplitPoolsByNUMA              bool
+	ReservedVFs                   string
+	AllowedHostPaths              string
+	ExcludeHostUsedVFs            bool
+	ExcludeDefaultRoutePF         bool
+	DefaultRoutePFAllowlist       string
+	ExtraDeviceAttributes         string
+	AdvertiseVFGroups             bool
+	PublishNodeCondition          bool
+	LogSysfsPaths                 bool
+	UseSriovnet                   bool
+	PrepareWebhookURL             string
+	PrepareWebhookCAFile          string
+	OtelEndpoint                  string
 }
 
 type Config struct {
@@ -29,3 +85,52 @@ type Config struct {
 func (c Config) DriverPluginPath() string {
 	return filepath.Join(c.Flags.KubeletPluginsDirectoryPath, consts.DriverName)
 }
+
+// PoolName returns the name of the resourceslice pool, the node name unless it is overridden
+func (c Config) PoolName() string {
+	if c.Flags.PoolName != "" {
+		return c.Flags.PoolName
+	}
+	return c.Flags.NodeName
+}
+
+// ValidatePoolName checks the pool name against the resourceslice naming constraints,
+// one or more DNS subdomains separated by slashes
+func ValidatePoolName(name string) error {
+	if name == "" {
+		return fmt.Errorf("pool name must not be empty")
+	}
+	if len(name) > resourceapi.PoolNameMaxLength {
+		return fmt.Errorf("pool name %q must be no more than %d characters", name, resourceapi.PoolNameMaxLength)
+	}
+	for _, segment := range strings.Split(name, "/") {
+		if errs := validation.IsDNS1123Subdomain(segment); len(errs) > 0 {
+			return fmt.Errorf("invalid pool name %q: %s", name, strings.Join(errs, ", "))
+		}
+	}
+	return nil
+}
+
+// ValidateEnvPrefix checks the prefix of the injected environment variables makes valid variable names,
+// an empty prefix keeps the names unchanged
+func ValidateEnvPrefix(prefix string) error {
+	if prefix == "" {
+		return nil
+	}
+	if errs := validation.IsEnvVarName(prefix); len(errs) > 0 {
+		return fmt.Errorf("invalid environment variable prefix %q: %s", prefix, strings.Join(errs, ", "))
+	}
+	return nil
+}
+
+// ValidateBindAddress checks the address a listener binds to is an IP address or a host name,
+// an empty address binds to all the interfaces
+func ValidateBindAddress(address string) error {
+	if address == "" || net.ParseIP(address) != nil {
+		return nil
+	}
+	if errs := validation.IsDNS1123Subdomain(address); len(errs) > 0 {
+		return fmt.Errorf("invalid bind address %q, must be an IP address or a host name: %s", address, strings.Join(errs, ", "))
+	}
+	return nil
+}
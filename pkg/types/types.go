@@ -6,6 +6,7 @@ import (
 
 	configapi "github.com/SchSeba/dra-driver-sriov/pkg/api/virtualfunction/v1alpha1"
 	resourceapi "k8s.io/api/resource/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	k8stypes "k8s.io/apimachinery/pkg/types"
 	"k8s.io/dynamic-resource-allocation/kubeletplugin"
@@ -26,13 +27,40 @@ type PreparedDevicesByClaimID map[k8stypes.UID]PreparedDevices
 // PreparedClaimsByPodUID is a map of pod uid to map of claim ID to prepared devices
 type PreparedClaimsByPodUID map[k8stypes.UID]PreparedDevicesByClaimID
 
+// NetworkRoute is a route of the CNI result
+type NetworkRoute struct {
+	Dst string `json:"dst"`
+	GW  string `json:"gw,omitempty"`
+}
+
+// NetworkDNS is the DNS configuration of the CNI result
+type NetworkDNS struct {
+	Nameservers []string `json:"nameservers,omitempty"`
+	Domain      string   `json:"domain,omitempty"`
+	Search      []string `json:"search,omitempty"`
+	Options     []string `json:"options,omitempty"`
+}
+
+// NetworkStatus holds the parts of the CNI result NetworkDeviceData can't carry,
+// it is published under the network key of the device status data
+type NetworkStatus struct {
+	Routes []NetworkRoute `json:"routes,omitempty"`
+	DNS    *NetworkDNS    `json:"dns,omitempty"`
+}
+
 type NetworkDataChanStruct struct {
 	PreparedDevice    *PreparedDevice
 	NetworkDeviceData *resourceapi.NetworkDeviceData
+	// NetworkStatus holds the routes and DNS of the attached device, nil when the CNI result has none
+	NetworkStatus *NetworkStatus
+	// Condition is set on the device status instead of the network data when not nil
+	Condition *metav1.Condition
 }
 type NetworkDataChanStructList []*NetworkDataChanStruct
 
-// AddDeviceIDToNetConf adds the deviceID (PCI address) to the netconf
+// AddDeviceIDToNetConf adds the deviceID (PCI address) to the netconf.
+// If the netconf already has a different deviceID an error is returned instead of overwriting it,
+// as this is a misconfiguration of the NetworkAttachmentDefinition.
 func AddDeviceIDToNetConf(originalConfig, deviceID string) (string, error) {
 	// Unmarshal the existing configuration into a raw map
 	var rawConfig map[string]interface{}
@@ -40,9 +68,23 @@ func AddDeviceIDToNetConf(originalConfig, deviceID string) (string, error) {
 		return "", fmt.Errorf("failed to unmarshal existing config: %w", err)
 	}
 
+	if err := checkExistingDeviceID(rawConfig, deviceID); err != nil {
+		return "", err
+	}
 	// Set the deviceID (PCI address)
 	rawConfig["deviceID"] = deviceID
 
+	// For a conflist the deviceID must be set on the first plugin (the sriov one),
+	// the top level fields are not passed to the chained plugins
+	if plugins, ok := rawConfig["plugins"].([]interface{}); ok && len(plugins) > 0 {
+		if firstPlugin, ok := plugins[0].(map[string]interface{}); ok {
+			if err := checkExistingDeviceID(firstPlugin, deviceID); err != nil {
+				return "", err
+			}
+			firstPlugin["deviceID"] = deviceID
+		}
+	}
+
 	// Marshal the modified configuration back to a JSON string
 	modifiedConfig, err := json.Marshal(rawConfig)
 	if err != nil {
@@ -52,6 +94,39 @@ func AddDeviceIDToNetConf(originalConfig, deviceID string) (string, error) {
 	return string(modifiedConfig), nil
 }
 
+// AddVlanToNetConf sets the vlan of the netconf, sriov-cni reads it from the netconf and not from the CNI args.
+// The vlan requested by the claim overrides the one of the NetworkAttachmentDefinition.
+func AddVlanToNetConf(originalConfig string, vlan int) (string, error) {
+	var rawConfig map[string]interface{}
+	if err := json.Unmarshal([]byte(originalConfig), &rawConfig); err != nil {
+		return "", fmt.Errorf("failed to unmarshal existing config: %w", err)
+	}
+
+	rawConfig["vlan"] = vlan
+	// like the deviceID, the vlan of a conflist is set on its first plugin
+	if plugins, ok := rawConfig["plugins"].([]interface{}); ok && len(plugins) > 0 {
+		if firstPlugin, ok := plugins[0].(map[string]interface{}); ok {
+			firstPlugin["vlan"] = vlan
+		}
+	}
+
+	modifiedConfig, err := json.Marshal(rawConfig)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal modified config: %w", err)
+	}
+
+	return string(modifiedConfig), nil
+}
+
+// checkExistingDeviceID returns an error if the config already has a deviceID different from the given one
+func checkExistingDeviceID(rawConfig map[string]interface{}, deviceID string) error {
+	existing, ok := rawConfig["deviceID"]
+	if !ok || existing == "" || existing == deviceID {
+		return nil
+	}
+	return fmt.Errorf("net attach def config already has deviceID %v which conflicts with allocated device %s", existing, deviceID)
+}
+
 type OpaqueDeviceConfig struct {
 	Requests []string
 	Config   runtime.Object
@@ -67,6 +142,9 @@ type PreparedDevice struct {
 	PodUID              string
 	NetAttachDefConfig  string
 	OriginalDriver      string // Store original driver for restoration during unprepare
+	// GroupDeviceName is the allocated VF group device the VF was prepared for, empty when the VF was allocated by itself.
+	// It is omitted when empty so the checksum of the checkpoints written by the previous versions still matches.
+	GroupDeviceName string `json:",omitempty"`
 }
 
 type Checkpoint struct {
@@ -2,6 +2,7 @@ package types_test
 
 import (
 	"encoding/json"
+	"strings"
 
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
@@ -28,14 +29,22 @@ var _ = Describe("Types", func() {
 			Expect(config["name"]).To(Equal("mynet"))
 		})
 
-		It("should replace existing deviceID in config", func() {
+		It("should return error when the config has a conflicting deviceID", func() {
 			originalConfig := `{"type": "sriov", "deviceID": "old-device", "name": "mynet"}`
 			deviceID := "0000:01:00.0"
 
+			_, err := draTypes.AddDeviceIDToNetConf(originalConfig, deviceID)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("already has deviceID old-device"))
+		})
+
+		It("should keep a matching deviceID in config", func() {
+			originalConfig := `{"type": "sriov", "deviceID": "0000:01:00.0", "name": "mynet"}`
+			deviceID := "0000:01:00.0"
+
 			result, err := draTypes.AddDeviceIDToNetConf(originalConfig, deviceID)
 			Expect(err).NotTo(HaveOccurred())
 
-			// Parse result to verify deviceID was replaced
 			var config map[string]interface{}
 			err = json.Unmarshal([]byte(result), &config)
 			Expect(err).NotTo(HaveOccurred())
@@ -44,6 +53,27 @@ var _ = Describe("Types", func() {
 			Expect(config["name"]).To(Equal("mynet"))
 		})
 
+		It("should set the deviceID when the existing one is empty", func() {
+			originalConfig := `{"type": "sriov", "deviceID": "", "name": "mynet"}`
+			deviceID := "0000:01:00.0"
+
+			result, err := draTypes.AddDeviceIDToNetConf(originalConfig, deviceID)
+			Expect(err).NotTo(HaveOccurred())
+
+			var config map[string]interface{}
+			err = json.Unmarshal([]byte(result), &config)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(config["deviceID"]).To(Equal(deviceID))
+		})
+
+		It("should return error when the first plugin of a conflist has a conflicting deviceID", func() {
+			originalConfig := `{"name": "mynet", "plugins": [{"type": "sriov", "deviceID": "0000:02:00.0"}]}`
+
+			_, err := draTypes.AddDeviceIDToNetConf(originalConfig, "0000:01:00.0")
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("already has deviceID 0000:02:00.0"))
+		})
+
 		It("should handle empty JSON object", func() {
 			originalConfig := `{}`
 			deviceID := "0000:01:00.0"
@@ -93,6 +123,31 @@ var _ = Describe("Types", func() {
 			Expect(capabilities["ips"]).To(BeTrue())
 		})
 
+		It("should add deviceID to the first plugin of a conflist", func() {
+			originalConfig := `{
+				"cniVersion": "1.0.0",
+				"name": "mynet",
+				"plugins": [
+					{"type": "sriov"},
+					{"type": "tuning"}
+				]
+			}`
+			deviceID := "0000:01:00.0"
+
+			result, err := draTypes.AddDeviceIDToNetConf(originalConfig, deviceID)
+			Expect(err).NotTo(HaveOccurred())
+
+			var config map[string]interface{}
+			err = json.Unmarshal([]byte(result), &config)
+			Expect(err).NotTo(HaveOccurred())
+
+			plugins, exists := config["plugins"].([]interface{})
+			Expect(exists).To(BeTrue())
+			Expect(plugins).To(HaveLen(2))
+			Expect(plugins[0].(map[string]interface{})["deviceID"]).To(Equal(deviceID))
+			Expect(plugins[1].(map[string]interface{})).NotTo(HaveKey("deviceID"))
+		})
+
 		It("should return error for invalid JSON", func() {
 			originalConfig := `invalid json`
 			deviceID := "0000:01:00.0"
@@ -117,6 +172,34 @@ var _ = Describe("Types", func() {
 		})
 	})
 
+	Context("AddVlanToNetConf", func() {
+		It("should set the vlan of the netconf", func() {
+			result, err := draTypes.AddVlanToNetConf(`{"type": "sriov", "name": "mynet", "vlan": 10}`, 100)
+			Expect(err).NotTo(HaveOccurred())
+
+			var config map[string]interface{}
+			Expect(json.Unmarshal([]byte(result), &config)).To(Succeed())
+			Expect(config["vlan"]).To(BeNumerically("==", 100))
+			Expect(config["name"]).To(Equal("mynet"))
+		})
+
+		It("should set the vlan of the first plugin of a conflist", func() {
+			result, err := draTypes.AddVlanToNetConf(`{"name": "mynet", "plugins": [{"type": "sriov"}, {"type": "tuning"}]}`, 100)
+			Expect(err).NotTo(HaveOccurred())
+
+			var config map[string]interface{}
+			Expect(json.Unmarshal([]byte(result), &config)).To(Succeed())
+			plugins := config["plugins"].([]interface{})
+			Expect(plugins[0].(map[string]interface{})["vlan"]).To(BeNumerically("==", 100))
+			Expect(plugins[1].(map[string]interface{})).NotTo(HaveKey("vlan"))
+		})
+
+		It("should return error for invalid JSON", func() {
+			_, err := draTypes.AddVlanToNetConf(`{"type": "sriov"`, 100)
+			Expect(err).To(MatchError(ContainSubstring("failed to unmarshal existing config")))
+		})
+	})
+
 	Context("Checkpoint operations", func() {
 		var checkpoint *draTypes.Checkpoint
 
@@ -252,4 +335,65 @@ var _ = Describe("Types", func() {
 			Expect(len(networkDataList)).To(Equal(1))
 		})
 	})
+
+	Context("PoolName", func() {
+		It("should default to the node name", func() {
+			config := draTypes.Config{Flags: &draTypes.Flags{NodeName: "node1"}}
+			Expect(config.PoolName()).To(Equal("node1"))
+		})
+
+		It("should return the overridden pool name", func() {
+			config := draTypes.Config{Flags: &draTypes.Flags{NodeName: "node1", PoolName: "sriov-pool"}}
+			Expect(config.PoolName()).To(Equal("sriov-pool"))
+		})
+	})
+
+	Context("ValidatePoolName", func() {
+		It("should accept DNS subdomains separated by slashes", func() {
+			Expect(draTypes.ValidatePoolName("node1")).To(Succeed())
+			Expect(draTypes.ValidatePoolName("worker-0.example.com")).To(Succeed())
+			Expect(draTypes.ValidatePoolName("rack1/node1")).To(Succeed())
+		})
+
+		It("should reject an empty pool name", func() {
+			Expect(draTypes.ValidatePoolName("")).To(MatchError(ContainSubstring("must not be empty")))
+		})
+
+		It("should reject invalid segments", func() {
+			Expect(draTypes.ValidatePoolName("Node_1")).To(MatchError(ContainSubstring(`invalid pool name "Node_1"`)))
+			Expect(draTypes.ValidatePoolName("rack1//node1")).To(HaveOccurred())
+			Expect(draTypes.ValidatePoolName("/node1")).To(HaveOccurred())
+		})
+
+		It("should reject a too long pool name", func() {
+			Expect(draTypes.ValidatePoolName(strings.Repeat("a", 254))).To(MatchError(ContainSubstring("must be no more than 253 characters")))
+		})
+	})
+
+	Context("ValidateEnvPrefix", func() {
+		It("should accept an empty prefix and valid variable names", func() {
+			Expect(draTypes.ValidateEnvPrefix("")).To(Succeed())
+			Expect(draTypes.ValidateEnvPrefix("MYAPP_")).To(Succeed())
+		})
+
+		It("should reject a prefix making invalid variable names", func() {
+			Expect(draTypes.ValidateEnvPrefix("MY APP")).To(MatchError(ContainSubstring(`invalid environment variable prefix "MY APP"`)))
+			Expect(draTypes.ValidateEnvPrefix("MY=APP")).To(HaveOccurred())
+		})
+	})
+
+	Context("ValidateBindAddress", func() {
+		It("should accept an empty address, IP addresses and host names", func() {
+			Expect(draTypes.ValidateBindAddress("")).To(Succeed())
+			Expect(draTypes.ValidateBindAddress("127.0.0.1")).To(Succeed())
+			Expect(draTypes.ValidateBindAddress("::1")).To(Succeed())
+			Expect(draTypes.ValidateBindAddress("localhost")).To(Succeed())
+		})
+
+		It("should reject an address that is not a host", func() {
+			Expect(draTypes.ValidateBindAddress("127.0.0.1:8080")).To(MatchError(ContainSubstring(`invalid bind address "127.0.0.1:8080"`)))
+			Expect(draTypes.ValidateBindAddress("not a host")).To(HaveOccurred())
+		})
+	})
+
 })